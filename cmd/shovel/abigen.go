@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// An entry in an ABI JSON file. Only the fields
+// needed to build an integration are decoded.
+type abiEntry struct {
+	Type      string     `json:"type"`
+	Name      string     `json:"name"`
+	Anonymous bool       `json:"anonymous"`
+	Inputs    []abiInput `json:"inputs"`
+}
+
+type abiInput struct {
+	Indexed    bool       `json:"indexed,omitempty"`
+	Name       string     `json:"name"`
+	Type       string     `json:"type"`
+	Column     string     `json:"column,omitempty"`
+	Components []abiInput `json:"components,omitempty"`
+}
+
+type abiEvent struct {
+	Name      string     `json:"name"`
+	Type      string     `json:"type"`
+	Anonymous bool       `json:"anonymous,omitempty"`
+	Inputs    []abiInput `json:"inputs"`
+}
+
+type abigenColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type abigenSource struct {
+	Name string `json:"name"`
+}
+
+type abigenBlock struct {
+	Name      string   `json:"name"`
+	Column    string   `json:"column"`
+	FilterOp  string   `json:"filter_op,omitempty"`
+	FilterArg []string `json:"filter_arg,omitempty"`
+}
+
+type abigenIntegration struct {
+	Name    string         `json:"name"`
+	Enabled bool           `json:"enabled"`
+	Sources []abigenSource `json:"sources"`
+	Table   struct {
+		Name    string         `json:"name"`
+		Columns []abigenColumn `json:"columns"`
+	} `json:"table"`
+	Block    []abigenBlock `json:"block"`
+	Event    *abiEvent     `json:"event,omitempty"`
+	Events   []abiEvent    `json:"events,omitempty"`
+	Function *abiEvent     `json:"function,omitempty"`
+}
+
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, strings.Split(v, ",")...)
+	return nil
+}
+
+// Implements: shovel abigen -abi erc20.json -event Transfer
+//
+// Prints an integration that indexes every input of the named
+// events (or function) so that it can be pasted into a config.
+func abigen(args []string, w io.Writer) error {
+	var (
+		fs       = flag.NewFlagSet("abigen", flag.ContinueOnError)
+		abiPath  string
+		events   stringsFlag
+		addrs    stringsFlag
+		function string
+		name     string
+		table    string
+		src      string
+	)
+	fs.StringVar(&abiPath, "abi", "", "path to ABI JSON file")
+	fs.Var(&events, "event", "event name. may be repeated or comma separated")
+	fs.StringVar(&function, "function", "", "function name. decodes transaction input")
+	fs.StringVar(&name, "name", "", "integration name (default: table name)")
+	fs.StringVar(&table, "table", "", "table name (default: snake case of first event)")
+	fs.StringVar(&src, "src", "mainnet", "source name")
+	fs.Var(&addrs, "addr", "contract address to filter by (log_addr or tx_to). may be repeated or comma separated")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch {
+	case len(abiPath) == 0:
+		return fmt.Errorf("missing -abi")
+	case len(events) == 0 && len(function) == 0:
+		return fmt.Errorf("missing -event or -function")
+	case len(events) > 0 && len(function) > 0:
+		return fmt.Errorf("-event and -function are mutually exclusive")
+	}
+	data, err := os.ReadFile(abiPath)
+	if err != nil {
+		return fmt.Errorf("reading abi: %w", err)
+	}
+	entries, err := parseABI(data)
+	if err != nil {
+		return fmt.Errorf("parsing abi: %w", err)
+	}
+	find := func(typ, name string) (abiEntry, error) {
+		for _, e := range entries {
+			if e.Type == typ && e.Name == name {
+				return e, nil
+			}
+		}
+		return abiEntry{}, fmt.Errorf("%s %q not found in %s", typ, name, abiPath)
+	}
+
+	var (
+		ig   abigenIntegration
+		cols = map[string]string{}
+		add  = func(name, typ, abiType string) error {
+			// ValidateFix requires events that share a
+			// column to have the same abi type. Block
+			// data is compared by its pg type.
+			if len(abiType) == 0 {
+				abiType = typ
+			}
+			if t, ok := cols[name]; ok {
+				if t != abiType {
+					return fmt.Errorf("column %q has types %s and %s", name, t, abiType)
+				}
+				return nil
+			}
+			cols[name] = abiType
+			ig.Table.Columns = append(ig.Table.Columns, abigenColumn{name, typ})
+			return nil
+		}
+		bd = func(names ...string) error {
+			for _, name := range names {
+				b := abigenBlock{Name: name, Column: name}
+				if (name == "log_addr" || name == "tx_to") && len(addrs) > 0 {
+					b.FilterOp, b.FilterArg = "contains", addrs
+				}
+				ig.Block = append(ig.Block, b)
+				if err := add(name, "bytea", ""); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	)
+	switch {
+	case len(function) > 0:
+		e, err := find("function", function)
+		if err != nil {
+			return err
+		}
+		inputs, err := columns(add, abiInput{}, e.Inputs)
+		if err != nil {
+			return err
+		}
+		ig.Function = &abiEvent{Name: e.Name, Type: e.Type, Inputs: inputs}
+		if len(table) == 0 {
+			table = snake(e.Name)
+		}
+		if err := bd("tx_hash", "tx_signer", "tx_to"); err != nil {
+			return err
+		}
+	default:
+		for _, name := range events {
+			e, err := find("event", name)
+			if err != nil {
+				return err
+			}
+			// without a signature in topic0 the logs can
+			// only be matched by their address
+			if e.Anonymous && len(addrs) == 0 {
+				return fmt.Errorf("anonymous event %s requires -addr", e.Name)
+			}
+			inputs, err := columns(add, abiInput{}, e.Inputs)
+			if err != nil {
+				return err
+			}
+			ig.Events = append(ig.Events, abiEvent{
+				Name:      e.Name,
+				Type:      e.Type,
+				Anonymous: e.Anonymous,
+				Inputs:    inputs,
+			})
+			if len(table) == 0 {
+				table = snake(e.Name)
+			}
+		}
+		if err := bd("log_addr", "tx_hash"); err != nil {
+			return err
+		}
+		if len(ig.Events) == 1 {
+			ig.Event, ig.Events = &ig.Events[0], nil
+		} else {
+			ig.Block = append(ig.Block, abigenBlock{Name: "event_name", Column: "event_name"})
+			if err := add("event_name", "text", ""); err != nil {
+				return err
+			}
+		}
+	}
+	if len(name) == 0 {
+		name = table
+	}
+	ig.Name = name
+	ig.Enabled = true
+	ig.Table.Name = table
+	ig.Sources = append(ig.Sources, abigenSource{src})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ig)
+}
+
+// Accepts a JSON array of ABI entries or an object
+// with an abi field (eg a forge or hardhat artifact).
+func parseABI(data []byte) ([]abiEntry, error) {
+	var entries []abiEntry
+	if err := json.Unmarshal(data, &entries); err == nil {
+		return entries, nil
+	}
+	var artifact struct {
+		ABI []abiEntry `json:"abi"`
+	}
+	if err := json.Unmarshal(data, &artifact); err != nil {
+		return nil, err
+	}
+	return artifact.ABI, nil
+}
+
+// Sets a column on each input. Tuples are flattened so that
+// each component is stored in its own column.
+func columns(add func(string, string, string) error, parent abiInput, inputs []abiInput) ([]abiInput, error) {
+	var res []abiInput
+	for i, inp := range inputs {
+		if len(inp.Name) == 0 {
+			inp.Name = fmt.Sprintf("arg%d", i)
+		}
+		// the log only has the value's hash so
+		// the input is matched but not selected
+		if inp.Indexed && hashed(inp.Type) {
+			res = append(res, inp)
+			continue
+		}
+		if len(inp.Components) > 0 {
+			var err error
+			inp.Components, err = columns(add, inp, inp.Components)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, inp)
+			continue
+		}
+		inp.Column = dbName(parent, inp)
+		if err := add(inp.Column, dbType(inp.Type), inp.Type); err != nil {
+			return nil, err
+		}
+		res = append(res, inp)
+	}
+	return res, nil
+}
+
+// Indexed inputs of these types are
+// logged as the keccak hash of their value
+func hashed(abiType string) bool {
+	return abiType == "string" ||
+		abiType == "bytes" ||
+		strings.HasPrefix(abiType, "tuple") ||
+		strings.HasSuffix(abiType, "]")
+}
+
+// Matches the dashboard's naming so that generated
+// configs look like those made with the UI.
+func dbName(parent, inp abiInput) string {
+	switch {
+	case inp.Name == "from":
+		return "f"
+	case inp.Name == "to":
+		return "t"
+	case len(parent.Name) > 0:
+		return parent.Name[:1] + "_" + snake(inp.Name)
+	default:
+		return snake(inp.Name)
+	}
+}
+
+// Arrays are unnested into rows so the
+// element type is used for the column.
+func dbType(abiType string) string {
+	t, _, _ := strings.Cut(abiType, "[")
+	switch {
+	case strings.HasPrefix(t, "uint"), strings.HasPrefix(t, "int"):
+		return "numeric"
+	case t == "bool":
+		return "bool"
+	case t == "string":
+		return "text"
+	default:
+		return "bytea"
+	}
+}
+
+// tokenId -> token_id, ERC20Transfer -> erc20_transfer
+func snake(s string) string {
+	var (
+		b  strings.Builder
+		rs = []rune(s)
+	)
+	for i, r := range rs {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := rs[i-1]
+			next := i+1 < len(rs) && unicode.IsLower(rs[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && next) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
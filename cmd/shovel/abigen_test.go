@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/indexsupply/shovel/shovel/config"
+	"kr.dev/diff"
+)
+
+func TestAbigen(t *testing.T) {
+	const abi = `[
+		{"type": "event", "name": "Transfer", "inputs": [
+			{"indexed": true, "name": "from", "type": "address"},
+			{"indexed": true, "name": "to", "type": "address"},
+			{"indexed": false, "name": "value", "type": "uint256"}
+		]},
+		{"type": "event", "name": "Approval", "inputs": [
+			{"indexed": true, "name": "owner", "type": "address"},
+			{"indexed": true, "name": "spender", "type": "address"},
+			{"indexed": false, "name": "value", "type": "uint256"}
+		]},
+		{"type": "event", "name": "TransferBatch", "inputs": [
+			{"indexed": true, "name": "operator", "type": "address"},
+			{"indexed": false, "name": "ids", "type": "uint256[]"},
+			{"indexed": false, "name": "values", "type": "uint256[]"}
+		]},
+		{"type": "event", "name": "OrderFilled", "inputs": [
+			{"indexed": true, "name": "maker", "type": "address"},
+			{"indexed": false, "name": "order", "type": "tuple", "components": [
+				{"name": "token", "type": "address"},
+				{"name": "amount", "type": "uint256"}
+			]}
+		]},
+		{"type": "event", "name": "Note", "anonymous": true, "inputs": [
+			{"indexed": true, "name": "sig", "type": "bytes4"},
+			{"indexed": false, "name": "", "type": "bytes"}
+		]},
+		{"type": "event", "name": "Registered", "inputs": [
+			{"indexed": true, "name": "label", "type": "string"},
+			{"indexed": true, "name": "ids", "type": "uint256[]"},
+			{"indexed": false, "name": "owner", "type": "address"}
+		]},
+		{"type": "event", "name": "Minted", "inputs": [
+			{"indexed": false, "name": "value", "type": "uint128"}
+		]},
+		{"type": "event", "name": "Sent", "inputs": [
+			{"indexed": false, "name": "value", "type": "bytes32"}
+		]},
+		{"type": "function", "name": "transfer", "inputs": [
+			{"name": "to", "type": "address"},
+			{"name": "amount", "type": "uint256"}
+		]}
+	]`
+	abiPath := filepath.Join(t.TempDir(), "abi.json")
+	diff.Test(t, t.Fatalf, os.WriteFile(abiPath, []byte(abi), 0644), nil)
+
+	cases := []struct {
+		args []string
+		cols []string
+		err  string
+	}{
+		{
+			args: []string{"-event", "Transfer"},
+			cols: []string{"f", "t", "value", "log_addr", "tx_hash"},
+		},
+		{
+			args: []string{"-event", "Transfer,Approval"},
+			cols: []string{"f", "t", "value", "owner", "spender", "log_addr", "tx_hash", "event_name"},
+		},
+		{
+			args: []string{"-event", "TransferBatch"},
+			cols: []string{"operator", "ids", "values", "log_addr", "tx_hash"},
+		},
+		{
+			args: []string{"-event", "OrderFilled"},
+			cols: []string{"maker", "o_token", "o_amount", "log_addr", "tx_hash"},
+		},
+		{
+			args: []string{"-event", "Registered"},
+			cols: []string{"owner", "log_addr", "tx_hash"},
+		},
+		{
+			args: []string{"-event", "Note"},
+			err:  "anonymous event Note requires -addr",
+		},
+		{
+			args: []string{"-event", "Note", "-addr", "0x9c8ff314c9bc7f6e59a9d9225fb22946427edc03"},
+			cols: []string{"sig", "arg1", "log_addr", "tx_hash"},
+		},
+		{
+			args: []string{"-event", "Transfer,Sent"},
+			err:  `column "value" has types uint256 and bytes32`,
+		},
+		{
+			args: []string{"-event", "Transfer,Minted"},
+			err:  `column "value" has types uint256 and uint128`,
+		},
+		{
+			args: []string{"-function", "transfer"},
+			cols: []string{"t", "amount", "tx_hash", "tx_signer", "tx_to"},
+		},
+	}
+	for _, tc := range cases {
+		var buf bytes.Buffer
+		err := abigen(append([]string{"-abi", abiPath}, tc.args...), &buf)
+		if len(tc.err) > 0 {
+			if err == nil {
+				t.Errorf("%v: expected error %q", tc.args, tc.err)
+				continue
+			}
+			diff.Test(t, t.Errorf, err.Error(), tc.err)
+			continue
+		}
+		diff.Test(t, t.Fatalf, err, nil)
+
+		var ig config.Integration
+		diff.Test(t, t.Fatalf, json.Unmarshal(buf.Bytes(), &ig), nil)
+		var cols []string
+		for _, c := range ig.Table.Columns {
+			cols = append(cols, c.Name)
+		}
+		diff.Test(t, t.Errorf, cols, tc.cols)
+
+		conf := &config.Root{Integrations: []config.Integration{ig}}
+		if err := config.ValidateFix(conf); err != nil {
+			t.Errorf("%v: validating: %s", tc.args, err)
+		}
+	}
+}
+
+func TestSnake(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"tokenId", "token_id"},
+		{"ERC20Transfer", "erc20_transfer"},
+		{"TransferBatch", "transfer_batch"},
+		{"value", "value"},
+	}
+	for _, tc := range cases {
+		diff.Test(t, t.Errorf, snake(tc.in), tc.want)
+	}
+}
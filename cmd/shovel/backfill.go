@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/indexsupply/shovel/shovel"
+	"github.com/indexsupply/shovel/shovel/config"
+	"github.com/indexsupply/shovel/wos"
+	"github.com/indexsupply/shovel/wpg"
+)
+
+// Indexes a block range for a single integration and exits.
+// Progress is recorded separately from the integration's
+// main task so that it may run alongside a main instance.
+func backfill(ctx context.Context, args []string) error {
+	var (
+		fs          = flag.NewFlagSet("backfill", flag.ContinueOnError)
+		cfile       string
+		src         string
+		ig          string
+		start       uint64
+		stop        uint64
+		shards      int
+		concurrency int
+		batchSize   int
+		freezerDir  string
+		skipMigrate bool
+	)
+	fs.StringVar(&cfile, "config", "", "task config file (json, yaml, or toml)")
+	fs.StringVar(&src, "src", "", "source name")
+	fs.StringVar(&ig, "ig", "", "integration name")
+	fs.Uint64Var(&start, "start", 0, "first block of the range")
+	fs.Uint64Var(&stop, "stop", 0, "last block of the range (inclusive)")
+	fs.IntVar(&shards, "shards", 1, "split the range into this many shards indexed concurrently (at most -concurrency)")
+	fs.IntVar(&concurrency, "concurrency", 0, "concurrent requests shared by the shards (default: source concurrency)")
+	fs.IntVar(&batchSize, "batch-size", 0, "blocks per batch (default: source batch_size)")
+	fs.StringVar(&freezerDir, "freezer", "", "read blocks from a geth freezer (eg <datadir>/geth/chaindata/ancient/chain) instead of the source's urls")
+	fs.BoolVar(&skipMigrate, "skip-migrate", false, "do not run db migrations on startup")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch {
+	case len(src) == 0:
+		return fmt.Errorf("missing -src")
+	case len(ig) == 0:
+		return fmt.Errorf("missing -ig")
+	case stop == 0:
+		return fmt.Errorf("missing -stop")
+	}
+
+	var (
+		conf  config.Root
+		pgurl = os.Getenv("DATABASE_URL")
+	)
+	if cfile != "" {
+		var err error
+		conf, err = loadConfig(cfile)
+		if err != nil {
+			return err
+		}
+		pgurl = wos.Getenv(conf.PGURL)
+	}
+	pg, err := wpg.NewPool(ctx, pgurl)
+	if err != nil {
+		return fmt.Errorf("connecting to pg: %w", err)
+	}
+	defer pg.Close()
+	pools, err := shovel.Pools{"": pg}.Open(ctx, conf)
+	if err != nil {
+		return err
+	}
+	if !skipMigrate {
+		if err := migrate(ctx, pools, conf); err != nil {
+			return fmt.Errorf("migrating: %w", err)
+		}
+	}
+	return shovel.Backfill(ctx, pools, conf, src, ig, start, stop, shards, concurrency, batchSize, freezerDir)
+}
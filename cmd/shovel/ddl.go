@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/indexsupply/shovel/shovel"
+	"github.com/indexsupply/shovel/shovel/config"
+	"github.com/indexsupply/shovel/wos"
+	"github.com/indexsupply/shovel/wpg"
+)
+
+// Prints the statements that are run on startup: shovel's
+// own schema followed by the config's integration tables.
+// Integrations saved in the database are not included.
+//
+// With -plan, only the integration table statements that
+// would change the database are printed. Nothing is applied.
+func ddl(ctx context.Context, args []string, w io.Writer) error {
+	var (
+		fs           = flag.NewFlagSet("ddl", flag.ContinueOnError)
+		cfile        string
+		skipInternal bool
+		plan         bool
+	)
+	fs.StringVar(&cfile, "config", "", "task config file (json, yaml, or toml)")
+	fs.BoolVar(&skipInternal, "skip-internal", false, "only print integration tables")
+	fs.BoolVar(&plan, "plan", false, "connect to pg and print pending changes")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var (
+		conf  config.Root
+		pgurl = os.Getenv("DATABASE_URL")
+	)
+	if len(cfile) > 0 {
+		var err error
+		conf, err = loadConfig(cfile)
+		if err != nil {
+			return err
+		}
+		pgurl = wos.Getenv(conf.PGURL)
+	}
+	if plan {
+		pg, err := wpg.NewPool(ctx, pgurl)
+		if err != nil {
+			return fmt.Errorf("connecting to pg: %w", err)
+		}
+		defer pg.Close()
+		pools, err := shovel.Pools{"": pg}.Open(ctx, conf)
+		if err != nil {
+			return err
+		}
+		for _, db := range append([]config.Database{{}}, conf.Databases...) {
+			stmts, err := config.Plan(ctx, pools[db.Name], conf.ForDatabase(db.Name))
+			if err != nil {
+				return err
+			}
+			if len(db.Name) > 0 && len(stmts) > 0 {
+				fmt.Fprintf(w, "-- database: %s\n", db.Name)
+			}
+			for _, stmt := range stmts {
+				fmt.Fprintf(w, "%s\n", sqlfmt(stmt))
+			}
+		}
+		return nil
+	}
+	if !skipInternal {
+		fmt.Fprintf(w, "%s\n\n", strings.TrimSpace(shovel.Schema))
+	}
+	for _, stmt := range config.DDL(conf) {
+		fmt.Fprintf(w, "%s\n", sqlfmt(stmt))
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/indexsupply/shovel/shovel"
+	"github.com/indexsupply/shovel/shovel/config"
+	"github.com/indexsupply/shovel/shovel/sink"
+	"github.com/indexsupply/shovel/wos"
+	"github.com/indexsupply/shovel/wpg"
+)
+
+// Writes an integration's rows for a block range to w as
+// csv (with a header) or ndjson. Rows are read in batches
+// using a server-side cursor so that large ranges aren't
+// held in memory.
+func export(ctx context.Context, args []string, w io.Writer) error {
+	var (
+		fs     = flag.NewFlagSet("export", flag.ContinueOnError)
+		cfile  string
+		src    string
+		igName string
+		start  uint64
+		stop   uint64
+		format string
+		fetch  int
+	)
+	fs.StringVar(&cfile, "config", "", "task config file (json, yaml, or toml)")
+	fs.StringVar(&src, "src", "", "source name (default: every source)")
+	fs.StringVar(&igName, "ig", "", "integration name")
+	fs.Uint64Var(&start, "start", 0, "first block of the range")
+	fs.Uint64Var(&stop, "stop", 0, "last block of the range (inclusive, 0 for no limit)")
+	fs.StringVar(&format, "format", "csv", "csv or ndjson")
+	fs.IntVar(&fetch, "fetch", 10000, "rows per cursor fetch")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch {
+	case len(igName) == 0:
+		return fmt.Errorf("missing -ig")
+	case format != "csv" && format != "ndjson":
+		return fmt.Errorf("-format must be csv or ndjson")
+	case fetch <= 0:
+		return fmt.Errorf("-fetch must be positive")
+	}
+
+	var (
+		conf  config.Root
+		pgurl = os.Getenv("DATABASE_URL")
+	)
+	if len(cfile) > 0 {
+		var err error
+		conf, err = loadConfig(cfile)
+		if err != nil {
+			return err
+		}
+		pgurl = wos.Getenv(conf.PGURL)
+	}
+	pg, err := wpg.NewPool(ctx, pgurl)
+	if err != nil {
+		return fmt.Errorf("connecting to pg: %w", err)
+	}
+	defer pg.Close()
+	pools, err := shovel.Pools{"": pg}.Open(ctx, conf)
+	if err != nil {
+		return err
+	}
+	igs, err := conf.AllIntegrations(ctx, pools.Main())
+	if err != nil {
+		return fmt.Errorf("loading integrations: %w", err)
+	}
+	var (
+		ig    config.Integration
+		found bool
+	)
+	for i := range igs {
+		if igs[i].Name == igName {
+			ig, found = igs[i], true
+		}
+	}
+	if !found {
+		return fmt.Errorf("unknown integration: %s", igName)
+	}
+
+	var (
+		bw   = bufio.NewWriter(w)
+		cw   = csv.NewWriter(bw)
+		cols = ig.Table.Columns
+		rec  = make([]string, len(cols))
+	)
+	if format == "csv" {
+		for i := range cols {
+			rec[i] = cols[i].Name
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	err = shovel.ReadRows(ctx, pools.For(ig), ig, src, start, stop, fetch, func(cols []wpg.Column, rows [][]any) error {
+		for _, row := range rows {
+			var err error
+			switch format {
+			case "csv":
+				for i := range row {
+					rec[i] = sink.Text(row[i])
+				}
+				err = cw.Write(rec)
+			case "ndjson":
+				var b []byte
+				b, err = sink.JSON(cols, row)
+				if err == nil {
+					b = append(b, '\n')
+					_, err = bw.Write(b)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("writing row: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
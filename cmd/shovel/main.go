@@ -4,25 +4,35 @@ import (
 	"bytes"
 	"context"
 	_ "embed"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	npprof "net/http/pprof"
 	"os"
+	"os/signal"
 	"runtime/debug"
 	"runtime/pprof"
+	"slices"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/indexsupply/shovel/shovel"
+	"github.com/indexsupply/shovel/shovel/alert"
 	"github.com/indexsupply/shovel/shovel/config"
+	"github.com/indexsupply/shovel/shovel/stream"
 	"github.com/indexsupply/shovel/shovel/web"
 	"github.com/indexsupply/shovel/wctx"
 	"github.com/indexsupply/shovel/wos"
+	"github.com/indexsupply/shovel/wotel"
 	"github.com/indexsupply/shovel/wpg"
 	"github.com/indexsupply/shovel/wslog"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"google.golang.org/grpc"
 )
 
 func check(err error) {
@@ -40,6 +50,27 @@ func sqlfmt(s string) string {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "abigen" {
+		check(abigen(os.Args[2:], os.Stdout))
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ddl" {
+		check(ddl(context.Background(), os.Args[2:], os.Stdout))
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		check(validate(context.Background(), os.Args[2:], os.Stdout))
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		check(export(context.Background(), os.Args[2:], os.Stdout))
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		ctx := wctx.WithVersion(context.Background(), Commit)
+		check(backfill(ctx, os.Args[2:]))
+		return
+	}
 	var (
 		ctx   = context.Background()
 		cfile string
@@ -51,8 +82,14 @@ func main() {
 		profile     string
 		version     bool
 		verbose     bool
+		watch       time.Duration
+		refresh     time.Duration
+		spawnPG     bool
+		spawnPGDir  string
+		grpcListen  string
+		shutdown    time.Duration
 	)
-	flag.StringVar(&cfile, "config", "", "task config file")
+	flag.StringVar(&cfile, "config", "", "task config file (json, yaml, or toml)")
 	flag.BoolVar(&printSchema, "print-schema", false, "print schema and exit")
 	flag.BoolVar(&skipMigrate, "skip-migrate", false, "do not run db migrations on startup")
 	flag.StringVar(&listen, "l", "localhost:8546", "dashboard server listen address")
@@ -60,6 +97,12 @@ func main() {
 	flag.StringVar(&profile, "profile", "", "run profile after indexing")
 	flag.BoolVar(&version, "version", false, "version")
 	flag.BoolVar(&verbose, "v", false, "verbose logging")
+	flag.DurationVar(&watch, "watch", 0, "check config file for changes at this interval and reload (0 disables)")
+	flag.DurationVar(&refresh, "refresh-secrets", 0, "re-read vault: and aws-sm: references at this interval and reload (0 disables)")
+	flag.BoolVar(&spawnPG, "spawn-pg", false, "spawn a postgres server from the initdb and postgres binaries on PATH instead of using pg_url (not an embedded database)")
+	flag.StringVar(&spawnPGDir, "spawn-pg-dir", "", "keep -spawn-pg data in this directory (default: temporary)")
+	flag.StringVar(&grpcListen, "grpc", "", "serve the row streaming grpc api at this address (disabled when empty)")
+	flag.DurationVar(&shutdown, "shutdown-timeout", 30*time.Second, "on SIGTERM wait this long for in-flight batches to commit before rolling them back")
 
 	flag.Parse()
 
@@ -91,6 +134,13 @@ func main() {
 		}
 		return "src", srcName
 	})
+	lh.RegisterContext(func(ctx context.Context) (string, any) {
+		id := wctx.ReqID(ctx)
+		if id == "" {
+			return "", nil
+		}
+		return "rid", id
+	})
 	lh.RegisterContext(func(ctx context.Context) (string, any) {
 		num, limit := wctx.NumLimit(ctx)
 		if num == 0 || limit == 0 {
@@ -98,10 +148,6 @@ func main() {
 		}
 		return "req", fmt.Sprintf("%d/%d", num, limit)
 	})
-	slog.SetDefault(slog.New(lh.WithAttrs([]slog.Attr{
-		slog.String("v", Commit),
-	})))
-
 	ctx = wctx.WithVersion(ctx, Commit)
 
 	if version {
@@ -117,13 +163,20 @@ func main() {
 	case cfile == "":
 		pgurl = os.Getenv("DATABASE_URL")
 	case cfile != "":
-		f, err := os.Open(cfile)
+		var err error
+		conf, err = loadConfig(cfile)
 		check(err)
-		check(json.NewDecoder(f).Decode(&conf))
-		check(config.ValidateFix(&conf))
-		pgurl = wos.Getenv(conf.PGURL)
+		pgurl = conf.PGURL
+		if !wos.IsSecret(pgurl) {
+			pgurl = wos.Getenv(pgurl)
+		}
 	}
 
+	check(setLog(lh, logLevel, conf.Log, verbose))
+	slog.SetDefault(slog.New(lh.WithAttrs([]slog.Attr{
+		slog.String("v", Commit),
+	})))
+
 	if printSchema {
 		for _, stmt := range config.DDL(conf) {
 			fmt.Printf("%s\n", sqlfmt(stmt))
@@ -131,48 +184,101 @@ func main() {
 		os.Exit(0)
 	}
 
-	pg, err := wpg.NewPool(ctx, pgurl)
-	check(err)
+	// Registered before anything is started so that a
+	// signal always results in a graceful shutdown
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 
-	if !skipMigrate {
-		dbtx, err := pg.Begin(ctx)
-		check(err)
-		_, err = dbtx.Exec(
-			ctx,
-			"select pg_advisory_xact_lock($1)",
-			wpg.LockHash("main.migrate"),
+	var local *wpg.Local
+	if spawnPG || len(spawnPGDir) > 0 {
+		var (
+			url string
+			err error
 		)
+		local, url, err = wpg.StartLocal(ctx, spawnPGDir)
 		check(err)
-		_, err = dbtx.Exec(ctx, shovel.Schema)
+		slog.InfoContext(ctx, "spawn-pg", "url", url)
+		pgurl = url
+	}
+
+	if ep := string(conf.Telemetry.Endpoint); ep != "" {
+		headers := map[string]string{}
+		for k, v := range conf.Telemetry.Headers {
+			headers[k] = string(v)
+		}
+		shutdownOtel, err := wotel.Setup(ctx, wotel.Config{
+			Endpoint:    ep,
+			Headers:     headers,
+			Insecure:    conf.Telemetry.Insecure,
+			SampleRatio: conf.Telemetry.SampleRatio,
+			Version:     Commit,
+		})
 		check(err)
-		check(config.Migrate(ctx, dbtx, conf))
-		check(dbtx.Commit(ctx))
+		// flushes buffered spans, including the shutdown's
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+			defer cancel()
+			if err := shutdownOtel(ctx); err != nil {
+				slog.ErrorContext(ctx, "otel-shutdown", "error", err)
+			}
+		}()
+	}
+
+	pg, err := wpg.NewPool(ctx, pgurl)
+	check(err)
+	pools, err := shovel.Pools{"": pg}.Open(ctx, conf)
+	check(err)
+
+	if !skipMigrate {
+		check(migrate(ctx, pools, conf))
 	}
 
 	var (
 		pbuf bytes.Buffer
-		mgr  = shovel.NewManager(ctx, pg, conf)
-		wh   = web.New(mgr, &conf, pg)
+		mgr  = shovel.NewManager(ctx, pg, conf).WithPools(pools)
+		wh   = web.New(mgr, pg)
 	)
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", wh.Index)
 	mux.HandleFunc("/diag", wh.Diag)
 	mux.HandleFunc("/metrics", wh.Prom)
+	mux.HandleFunc("/healthz", wh.Healthz)
+	mux.HandleFunc("/readyz", wh.Readyz)
 	mux.HandleFunc("/login", wh.Login)
+	mux.HandleFunc("/oidc/login", wh.OIDCLogin)
+	mux.HandleFunc("/oidc/callback", wh.OIDCCallback)
 	mux.Handle("/task-updates", wh.Authn(wh.Updates))
 	mux.Handle("/add-source", wh.Authn(wh.AddSource))
 	mux.Handle("/save-source", wh.Authn(wh.SaveSource))
 	mux.Handle("/add-integration", wh.Authn(wh.AddIntegration))
 	mux.Handle("/save-integration", wh.Authn(wh.SaveIntegration))
-	mux.HandleFunc("/debug/pprof/", npprof.Index)
-	mux.HandleFunc("/debug/pprof/cmdline", npprof.Cmdline)
-	mux.HandleFunc("/debug/pprof/profile", npprof.Profile)
-	mux.HandleFunc("/debug/pprof/symbol", npprof.Symbol)
-	mux.HandleFunc("/debug/pprof/trace", npprof.Trace)
-	mux.HandleFunc("/debug/pprof/capture", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/validate-integration", wh.Authn(wh.ValidateIntegration))
+	mux.Handle("/api/", wh.Authn(wh.API))
+	mux.Handle("/query", wh.Authn(wh.Query))
+	mux.HandleFunc("/graphql", wh.GraphQL)
+	mux.Handle("/migrate-plan", wh.Authn(wh.MigratePlan))
+	mux.Handle("/pause", wh.Authn(wh.Pause))
+	mux.Handle("/resume", wh.Authn(wh.Resume))
+	mux.Handle("/debug/pprof/", wh.Debug(npprof.Index))
+	mux.Handle("/debug/pprof/cmdline", wh.Debug(npprof.Cmdline))
+	mux.Handle("/debug/pprof/profile", wh.Debug(npprof.Profile))
+	mux.Handle("/debug/pprof/symbol", wh.Debug(npprof.Symbol))
+	mux.Handle("/debug/pprof/trace", wh.Debug(npprof.Trace))
+	mux.Handle("/debug/vars", wh.Debug(wh.Vars))
+	mux.Handle("/debug/pprof/capture", wh.Authn(func(w http.ResponseWriter, r *http.Request) {
 		w.Write(pbuf.Bytes())
-	})
-	go http.ListenAndServe(listen, log(true, mux))
+	}))
+	hs := &http.Server{Addr: listen, Handler: log(true, mux)}
+	go hs.ListenAndServe()
+
+	var gs *grpc.Server
+	if len(grpcListen) > 0 {
+		ln, err := net.Listen("tcp", grpcListen)
+		check(err)
+		gs = grpc.NewServer()
+		stream.Register(gs, stream.NewServer(shovel.Streams, mgr))
+		go gs.Serve(ln)
+	}
 
 	if profile == "cpu" {
 		check(pprof.StartCPUProfile(&pbuf))
@@ -184,11 +290,45 @@ func main() {
 
 	go func() {
 		for {
-			check(shovel.PruneTask(ctx, pg, 200))
+			check(mgr.PruneTasks())
+			if err := mgr.Prune(); err != nil {
+				slog.ErrorContext(ctx, "prune", "error", err)
+			}
+			if err := mgr.Heal(); err != nil {
+				slog.ErrorContext(ctx, "heal", "error", err)
+			}
+			if err := mgr.Export(); err != nil {
+				slog.ErrorContext(ctx, "export", "error", err)
+			}
 			time.Sleep(time.Minute * 10)
 		}
 	}()
 
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := shovel.SnapshotProgress(ctx, pg); err != nil {
+				slog.ErrorContext(ctx, "snapshot-progress", "error", err)
+			}
+		}
+	}()
+
+	alerts := alert.New(conf.Alerts).WithConfig(func() config.Alerts {
+		return mgr.Config().Alerts
+	})
+	go alerts.Run(ctx, 30*time.Second, func() []alert.Sample {
+		var res []alert.Sample
+		for _, id := range mgr.Running() {
+			src, ig, _ := strings.Cut(id, "/")
+			res = append(res, alert.Sample{
+				Src:    src,
+				IG:     ig,
+				Lag:    shovel.Throughput(src, ig).Lag,
+				Errors: shovel.Errors(src, ig),
+			})
+		}
+		return res
+	})
+
 	ec := make(chan error)
 	go mgr.Run(ec)
 	if err := <-ec; err != nil {
@@ -196,13 +336,232 @@ func main() {
 		os.Exit(1)
 	}
 
+	// -watch and -refresh-secrets reload from their own
+	// routines. Migrating and reloading one config at a
+	// time keeps an older config from replacing a newer one.
+	var applyMut sync.Mutex
+	apply := func(nc config.Root) error {
+		applyMut.Lock()
+		defer applyMut.Unlock()
+		if !skipMigrate {
+			pools, err := mgr.OpenPools(nc)
+			if err != nil {
+				return fmt.Errorf("opening databases: %w", err)
+			}
+			if err := migrate(ctx, pools, nc); err != nil {
+				return fmt.Errorf("migrating: %w", err)
+			}
+		}
+		if _, err := mgr.Reload(nc); err != nil {
+			return fmt.Errorf("reloading tasks: %w", err)
+		}
+		return nil
+	}
+	// stopped at shutdown so that a reload doesn't
+	// start tasks while they're being shut down
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	defer stopWatch()
+	if watch > 0 && cfile != "" {
+		go watchConfig(watchCtx, cfile, watch, apply)
+	}
+	// Secrets are read when the config is decoded so rotated
+	// values are picked up by reloading. Only tasks whose
+	// source or integration changed are restarted.
+	if refresh > 0 && cfile != "" {
+		go func() {
+			t := time.NewTicker(refresh)
+			defer t.Stop()
+			for {
+				select {
+				case <-watchCtx.Done():
+					return
+				case <-t.C:
+				}
+				nc, err := loadConfig(cfile)
+				if err == nil {
+					err = apply(nc)
+				}
+				if err != nil {
+					slog.ErrorContext(ctx, "refresh-secrets", "error", err)
+				}
+			}
+		}()
+	}
+
 	switch profile {
 	case "cpu":
 		pprof.StopCPUProfile()
 	case "heap":
 		check(pprof.Lookup("heap").WriteTo(&pbuf, 0))
 	}
-	select {}
+	<-sig
+	stopWatch()
+	slog.InfoContext(ctx, "shutdown", "timeout", shutdown)
+	sctx, cancel := context.WithTimeout(ctx, shutdown)
+	defer cancel()
+	if err := mgr.Shutdown(sctx); err != nil {
+		slog.ErrorContext(ctx, "shutdown-tasks", "error", err)
+	}
+	stop(sctx, hs, gs)
+	for _, pg := range mgr.Pools() {
+		pg.Close()
+	}
+	if local != nil {
+		if err := local.Stop(); err != nil {
+			slog.ErrorContext(ctx, "spawn-pg", "error", err)
+		}
+	}
+	slog.InfoContext(ctx, "shutdown-complete")
+}
+
+// Waits for in-flight requests and streams until ctx is done
+func stop(ctx context.Context, hs *http.Server, gs *grpc.Server) {
+	if gs != nil {
+		done := make(chan struct{})
+		go func() {
+			gs.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			gs.Stop()
+		}
+	}
+	if err := hs.Shutdown(ctx); err != nil {
+		hs.Close()
+	}
+}
+
+// The -v flag takes precedence over log.level
+func setLog(lh *wslog.Handler, level *slog.LevelVar, lc config.Log, verbose bool) error {
+	if len(lc.Level) > 0 && !verbose {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(lc.Level)); err != nil {
+			return fmt.Errorf("log level: %w", err)
+		}
+		level.Set(l)
+	}
+	for name, s := range lc.Levels {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(s)); err != nil {
+			return fmt.Errorf("log level for %s: %w", name, err)
+		}
+		lh.SetLevel(name, l)
+	}
+	if lc.Format == "json" {
+		lh.UseJSON()
+	}
+	return nil
+}
+
+func loadConfig(path string) (config.Root, error) {
+	conf, err := config.Load(path)
+	if err != nil {
+		return conf, err
+	}
+	if err := config.ValidateFix(&conf); err != nil {
+		return conf, fmt.Errorf("validating config: %w", err)
+	}
+	return conf, nil
+}
+
+// Migrates each database using the integrations
+// that are written to it.
+func migrate(ctx context.Context, pools shovel.Pools, conf config.Root) error {
+	for name, pg := range pools {
+		if err := migrateDB(ctx, pg, conf.ForDatabase(name)); err != nil {
+			if len(name) > 0 {
+				return fmt.Errorf("database %s: %w", name, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateDB(ctx context.Context, pg *pgxpool.Pool, conf config.Root) error {
+	dbtx, err := pg.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbtx.Rollback(ctx)
+	_, err = dbtx.Exec(
+		ctx,
+		"select pg_advisory_xact_lock($1)",
+		wpg.LockHash("main.migrate"),
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := dbtx.Exec(ctx, shovel.Schema); err != nil {
+		return err
+	}
+	if err := config.Migrate(ctx, dbtx, conf); err != nil {
+		return err
+	}
+	return dbtx.Commit(ctx)
+}
+
+// Polls the modification time of the config file (and any
+// files it includes) and calls apply with the re-read config
+// when one of them changes. Includes are expanded on every
+// poll so that files added to or removed from an included
+// directory or glob are picked up. Invalid configs are logged
+// and ignored so that a typo doesn't stop a running process.
+// Returns when ctx is done.
+func watchConfig(ctx context.Context, path string, d time.Duration, apply func(config.Root) error) {
+	var (
+		files []string
+		last  time.Time
+	)
+	changed := func() (bool, error) {
+		fs, err := config.Files(path)
+		if err != nil {
+			return false, err
+		}
+		var mt time.Time
+		for _, f := range fs {
+			fi, err := os.Stat(f)
+			if err != nil {
+				return false, err
+			}
+			if fi.ModTime().After(mt) {
+				mt = fi.ModTime()
+			}
+		}
+		res := !slices.Equal(fs, files) || mt.After(last)
+		files, last = fs, mt
+		return res, nil
+	}
+	changed()
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		ok, err := changed()
+		if err != nil {
+			slog.ErrorContext(ctx, "watch-config", "error", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		nc, err := loadConfig(path)
+		if err != nil {
+			slog.ErrorContext(ctx, "watch-config", "error", err)
+			continue
+		}
+		if err := apply(nc); err != nil {
+			slog.ErrorContext(ctx, "watch-config", "error", err)
+			continue
+		}
+		slog.InfoContext(ctx, "watch-config", "reloaded", path)
+	}
 }
 
 func log(v bool, h http.Handler) http.Handler {
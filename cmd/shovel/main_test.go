@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/indexsupply/shovel/shovel/config"
+	"kr.dev/diff"
+)
+
+func TestWatchConfig(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		path        = filepath.Join(t.TempDir(), "config.json")
+		applied     = make(chan config.Root, 1)
+		done        = make(chan struct{})
+	)
+	defer cancel()
+	diff.Test(t, t.Fatalf, os.WriteFile(path, []byte(`{}`), 0644), nil)
+	go func() {
+		watchConfig(ctx, path, 10*time.Millisecond, func(nc config.Root) error {
+			applied <- nc
+			return nil
+		})
+		close(done)
+	}()
+
+	// the file is changed after the watcher has read
+	// its first modification time
+	time.Sleep(50 * time.Millisecond)
+	future := time.Now().Add(time.Minute)
+	diff.Test(t, t.Fatalf, os.WriteFile(path, []byte(`{"pg_url": "postgres:///foo"}`), 0644), nil)
+	diff.Test(t, t.Fatalf, os.Chtimes(path, future, future), nil)
+	select {
+	case nc := <-applied:
+		diff.Test(t, t.Errorf, nc.PGURL, "postgres:///foo")
+	case <-time.After(5 * time.Second):
+		t.Fatal("config wasn't applied")
+	}
+
+	// a file added to an included directory is picked up
+	// even though it's older than the last reload
+	diff.Test(t, t.Fatalf, os.Mkdir(filepath.Join(filepath.Dir(path), "igs"), 0755), nil)
+	diff.Test(t, t.Fatalf, os.WriteFile(path, []byte(`{"include": ["igs/*.json"]}`), 0644), nil)
+	diff.Test(t, t.Fatalf, os.Chtimes(path, future, future), nil)
+	inc := filepath.Join(filepath.Dir(path), "igs", "a.json")
+	diff.Test(t, t.Fatalf, os.WriteFile(inc, []byte(`{}`), 0644), nil)
+	select {
+	case <-applied:
+	case <-time.After(5 * time.Second):
+		t.Fatal("config wasn't applied")
+	}
+	inc = filepath.Join(filepath.Dir(path), "igs", "b.json")
+	diff.Test(t, t.Fatalf, os.WriteFile(inc, []byte(`{"eth_sources": [{"name": "b", "chain_id": 1}]}`), 0644), nil)
+	// a.json may have been applied separately
+	for timeout := time.After(5 * time.Second); ; {
+		var nc config.Root
+		select {
+		case nc = <-applied:
+		case <-timeout:
+			t.Fatal("included file wasn't applied")
+		}
+		if len(nc.Sources) == 1 {
+			break
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher didn't return")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/indexsupply/shovel/shovel"
+	"github.com/indexsupply/shovel/shovel/config"
+)
+
+// Checks a config file without connecting to Postgres.
+// Every problem is reported (rather than only the first)
+// and a non-nil error is returned when there are any.
+func validate(ctx context.Context, args []string, w io.Writer) error {
+	var (
+		fs      = flag.NewFlagSet("validate", flag.ContinueOnError)
+		cfile   string
+		skipRPC bool
+	)
+	fs.StringVar(&cfile, "config", "", "task config file (json, yaml, or toml)")
+	fs.BoolVar(&skipRPC, "skip-rpc", false, "do not check each source url's chain id")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(cfile) == 0 {
+		return fmt.Errorf("missing -config")
+	}
+	conf, err := loadConfig(cfile)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, ig := range conf.Integrations {
+		if err := config.ValidateTypes(ig); err != nil {
+			errs = append(errs, fmt.Errorf("integration %s: %w", ig.Name, err))
+			continue
+		}
+		if _, err := shovel.NewDestination(ig); err != nil {
+			errs = append(errs, fmt.Errorf("integration %s: %w", ig.Name, err))
+		}
+	}
+	if !skipRPC {
+		for _, sc := range conf.Sources {
+			for _, u := range sc.URLs {
+				err := checkChainID(ctx, sc, u)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("source %s: %w", sc.Name, err))
+				}
+			}
+		}
+	}
+	for _, err := range errs {
+		fmt.Fprintf(w, "%s\n", err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d problems found", len(errs))
+	}
+	fmt.Fprintf(w, "ok: %d sources, %d integrations\n",
+		len(conf.Sources),
+		len(conf.Integrations),
+	)
+	return nil
+}
+
+func checkChainID(ctx context.Context, sc config.Source, url string) error {
+	sc.URLs = []string{url}
+	var (
+		want     = sc.ChainID
+		c        = shovel.NewClient(sc)
+		host     = c.NextURL().Hostname()
+		got, err = c.ChainID(ctx, c.NextURL().String())
+	)
+	switch {
+	case err != nil:
+		return fmt.Errorf("%s: %w", host, err)
+	case got != want:
+		const tag = "%s: chain_id is %d but config has %d"
+		return fmt.Errorf(tag, host, got, want)
+	}
+	return nil
+}
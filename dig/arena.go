@@ -0,0 +1,57 @@
+package dig
+
+import "github.com/holiman/uint256"
+
+// Values for the rows of an insert are handed out from
+// slabs so that decoding a batch of blocks makes a few
+// large allocations instead of one (or more) per field.
+//
+// Slabs aren't reused. A value stays valid for as long as
+// it's referenced (eg by a sink that holds rows until the
+// transaction commits) and the slab is collected with the
+// last of its values.
+//
+// A nil arena allocates each value.
+type arena struct {
+	anys []any
+	ints []uint256.Int
+	negs []negInt
+}
+
+const slabSize = 512
+
+func (a *arena) row(n int) []any {
+	if a == nil {
+		return make([]any, n)
+	}
+	if len(a.anys)+n > cap(a.anys) {
+		a.anys = make([]any, 0, max(slabSize, n))
+	}
+	i := len(a.anys)
+	a.anys = a.anys[:i+n]
+	return a.anys[i : i+n : i+n]
+}
+
+func (a *arena) uint(d []byte) *uint256.Int {
+	if a == nil {
+		return new(uint256.Int).SetBytes(d)
+	}
+	if len(a.ints) == cap(a.ints) {
+		a.ints = make([]uint256.Int, 0, slabSize)
+	}
+	a.ints = a.ints[:len(a.ints)+1]
+	return a.ints[len(a.ints)-1].SetBytes(d)
+}
+
+func (a *arena) int(d []byte) *negInt {
+	if a == nil {
+		return &negInt{new(uint256.Int).SetBytes(d)}
+	}
+	if len(a.negs) == cap(a.negs) {
+		a.negs = make([]negInt, 0, slabSize)
+	}
+	a.negs = a.negs[:len(a.negs)+1]
+	x := &a.negs[len(a.negs)-1]
+	x.i = a.uint(d)
+	return x
+}
@@ -0,0 +1,210 @@
+package dig
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/indexsupply/shovel/eth"
+	"github.com/indexsupply/shovel/wctx"
+	"github.com/indexsupply/shovel/wpg"
+	"github.com/indexsupply/shovel/wprom"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Determines what happens to logs (or calls) that can't be
+// decoded and rows that can't be inserted.
+//
+//	OnErrorFail        the batch fails and is retried (default)
+//	OnErrorSkip        the log or row is logged and skipped
+//	OnErrorDeadLetter  the log or row is written to
+//	                   shovel.dead_letters in the same
+//	                   transaction as the batch's rows
+//
+// Calldata that can't be decoded is skipped (and counted in
+// shovel_calldata_errors_total) unless OnErrorFail is set
+// explicitly since any account can send a tx whose input
+// begins with the function's selector.
+type OnError string
+
+const (
+	OnErrorFail       OnError = "fail"
+	OnErrorSkip       OnError = "skip"
+	OnErrorDeadLetter OnError = "dead_letter"
+)
+
+var mCalldataErrors = wprom.NewCounter(
+	"shovel_calldata_errors_total",
+	"txs whose input has the function's selector but can't be decoded",
+	"src", "ig",
+)
+
+func (oe OnError) lenient() bool {
+	return oe == OnErrorSkip || oe == OnErrorDeadLetter
+}
+
+type deadLetter struct {
+	blockNum uint64
+	txHash   []byte
+	logIdx   any
+	addr     []byte
+	topics   [][]byte
+	data     []byte
+	row      any
+	err      string
+}
+
+func (lwc *logWithCtx) deadLetter(err error) {
+	dl := deadLetter{
+		blockNum: lwc.b.Num(),
+		txHash:   lwc.t.Hash(),
+		err:      err.Error(),
+	}
+	switch {
+	case lwc.l != nil:
+		dl.logIdx = uint64(lwc.l.Idx)
+		dl.addr = lwc.l.Address.Bytes()
+		dl.topics = make([][]byte, len(lwc.l.Topics))
+		for i := range lwc.l.Topics {
+			dl.topics[i] = lwc.l.Topics[i]
+		}
+		dl.data = lwc.l.Data.Bytes()
+	default:
+		dl.addr = lwc.t.To.Bytes()
+		dl.data = lwc.t.Data.Bytes()
+	}
+	lwc.dls = append(lwc.dls, dl)
+}
+
+// Inserts rows in a savepoint. When the batch fails each
+// row is inserted in its own savepoint and the rows that
+// fail become dead letters. Returns the inserted rows.
+func (ig Integration) insertLenient(ctx context.Context, pg wpg.Conn, lwc *logWithCtx, rows [][]any) (int64, [][]any, error) {
+	if len(rows) == 0 {
+		return 0, rows, nil
+	}
+	pg, err := wpg.Direct(ctx, pg)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := pg.Exec(ctx, "savepoint shovel_batch"); err != nil {
+		return 0, nil, fmt.Errorf("creating savepoint: %w", err)
+	}
+	nr, inserted, err := ig.insert(ctx, pg, rows)
+	if err == nil {
+		_, err = pg.Exec(ctx, "release savepoint shovel_batch")
+		return nr, inserted, err
+	}
+	if _, err := pg.Exec(ctx, "rollback to savepoint shovel_batch"); err != nil {
+		return 0, nil, fmt.Errorf("rolling back batch: %w", err)
+	}
+	nr, inserted = 0, nil
+	for _, row := range rows {
+		if _, err := pg.Exec(ctx, "savepoint shovel_row"); err != nil {
+			return 0, nil, fmt.Errorf("creating savepoint: %w", err)
+		}
+		n, ins, err := ig.insertRows(ctx, pg, [][]any{row})
+		if err != nil {
+			if _, err := pg.Exec(ctx, "rollback to savepoint shovel_row"); err != nil {
+				return 0, nil, fmt.Errorf("rolling back row: %w", err)
+			}
+			lwc.dls = append(lwc.dls, ig.rowDeadLetter(row, err))
+			continue
+		}
+		if _, err := pg.Exec(ctx, "release savepoint shovel_row"); err != nil {
+			return 0, nil, fmt.Errorf("releasing savepoint: %w", err)
+		}
+		nr += n
+		inserted = append(inserted, ins...)
+	}
+	return nr, inserted, nil
+}
+
+func (ig Integration) rowDeadLetter(row []any, err error) deadLetter {
+	var (
+		dl  = deadLetter{err: err.Error()}
+		obj = map[string]any{}
+	)
+	for j, def := range ig.coldefs {
+		switch def.BlockData.Name {
+		case "block_num":
+			dl.blockNum, _ = row[j].(uint64)
+		case "tx_hash":
+			dl.txHash, _ = row[j].([]byte)
+		case "log_idx":
+			dl.logIdx = row[j]
+		}
+		switch v := row[j].(type) {
+		case []byte:
+			obj[def.Column.Name] = eth.EncodeHex(v)
+		case driver.Valuer:
+			obj[def.Column.Name], _ = v.Value()
+		default:
+			obj[def.Column.Name] = v
+		}
+	}
+	if b, err := json.Marshal(obj); err == nil {
+		dl.row = string(b)
+	}
+	return dl
+}
+
+func (ig Integration) handleDeadLetters(ctx context.Context, pg wpg.Conn, dls []deadLetter) error {
+	if ig.OnError == OnErrorDeadLetter {
+		return ig.writeDeadLetters(ctx, pg, dls)
+	}
+	for _, dl := range dls {
+		slog.WarnContext(ctx, "skipping",
+			"ig", ig.name,
+			"block", dl.blockNum,
+			"tx", eth.EncodeHex(dl.txHash),
+			"error", dl.err,
+		)
+	}
+	return nil
+}
+
+func (ig Integration) writeDeadLetters(ctx context.Context, pg wpg.Conn, dls []deadLetter) error {
+	if len(dls) == 0 {
+		return nil
+	}
+	rows := make([][]any, len(dls))
+	for i, dl := range dls {
+		rows[i] = []any{
+			wctx.SrcName(ctx),
+			ig.name,
+			dl.blockNum,
+			dl.txHash,
+			dl.logIdx,
+			dl.addr,
+			dl.topics,
+			dl.data,
+			dl.row,
+			dl.err,
+		}
+	}
+	_, err := pg.CopyFrom(
+		ctx,
+		pgx.Identifier{"shovel", "dead_letters"},
+		[]string{
+			"src_name",
+			"ig_name",
+			"block_num",
+			"tx_hash",
+			"log_idx",
+			"log_addr",
+			"topics",
+			"data",
+			"row",
+			"error",
+		},
+		pgx.CopyFromRows(rows),
+	)
+	if err != nil {
+		return fmt.Errorf("writing dead letters: %w", err)
+	}
+	return nil
+}
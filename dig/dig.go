@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"context"
 	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -15,6 +16,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/indexsupply/shovel/bint"
 	"github.com/indexsupply/shovel/eth"
@@ -41,6 +44,7 @@ type atype struct {
 	// array
 	length int
 	elem   *atype
+	unnest bool
 }
 
 func (t atype) String() string {
@@ -214,6 +218,15 @@ func (r *Result) GetRow() row {
 	return r.collection[r.n-1]
 }
 
+// Returns the i'th row, adding rows as needed, so that
+// elements at the same index of unnested arrays share a row.
+func (r *Result) rowAt(i int) row {
+	for r.n <= i {
+		r.GetRow()
+	}
+	return r.collection[i]
+}
+
 func (r *Result) Bytes() [][][]byte {
 	var res = make([][][]byte, r.Len())
 	for i := 0; i < r.Len(); i++ {
@@ -297,7 +310,11 @@ func scan(r row, res *Result, input []byte, t atype) error {
 			length, start, pos = int(bint.Decode(input[:32])), 32, 32
 		}
 		for i := 0; i < length; i++ {
-			if !t.hasKind('a') {
+			switch {
+			case t.hasKind('a'):
+			case t.unnest:
+				r = res.rowAt(i)
+			default:
 				r = res.GetRow()
 			}
 			switch {
@@ -372,12 +389,27 @@ type Input struct {
 
 	Column string `json:"column"`
 	Filter
+
+	// Array elements are written to the same rows as the
+	// elements of other unnested arrays with the same index
+	// (eg ids and values of ERC-1155's TransferBatch).
+	// The element's index is available as array_idx.
+	Unnest bool `json:"unnest,omitempty"`
+
+	// Applied in order to the decoded value before it's
+	// written. See [parseTransforms].
+	Transform []string `json:"transform,omitempty"`
 }
 
 type Ref struct {
 	Integration string `json:"integration"`
 	Table       string `json:"table"`
 	Column      string `json:"column"`
+
+	// How often a log_addr filter re-reads the addresses
+	// of a table that isn't managed by shovel (eg "1m").
+	// Empty re-reads the table before every batch.
+	Refresh string `json:"refresh,omitempty"`
 }
 
 type Filter struct {
@@ -387,7 +419,7 @@ type Filter struct {
 }
 
 func (f Filter) Accept(ctx context.Context, pgmut *sync.Mutex, pg wpg.Conn, d any, frs *filterResults) error {
-	if len(f.Arg) == 0 && len(f.Ref.Integration) == 0 {
+	if len(f.Arg) == 0 && len(f.Ref.Table) == 0 {
 		return nil
 	}
 
@@ -457,39 +489,99 @@ func (f Filter) Accept(ctx context.Context, pgmut *sync.Mutex, pg wpg.Conn, d an
 			frs.add(v != f.Arg[0])
 		}
 	case uint64:
-		i, err := strconv.ParseUint(f.Arg[0], 10, 64)
-		if err != nil {
-			return fmt.Errorf("unable to convert filter arg to int: %q", f.Arg[0])
-		}
-		switch f.Op {
-		case "eq":
-			frs.add(v == i)
-		case "ne":
-			frs.add(v != i)
-		case "gt":
-			frs.add(v > i)
-		case "lt":
-			frs.add(v < i)
+		args := make([]uint64, len(f.Arg))
+		for j := range f.Arg {
+			i, err := strconv.ParseUint(f.Arg[j], 10, 64)
+			if err != nil {
+				return fmt.Errorf("unable to convert filter arg to int: %q", f.Arg[j])
+			}
+			args[j] = i
 		}
+		return f.compare(frs, func(j int) int {
+			switch {
+			case v < args[j]:
+				return -1
+			case v > args[j]:
+				return 1
+			default:
+				return 0
+			}
+		})
 	case *uint256.Int:
-		i := &uint256.Int{}
-		if err := i.SetFromDecimal(f.Arg[0]); err != nil {
-			return fmt.Errorf("unable to convert filter arg dec to uint256: %q", f.Arg[0])
+		args := make([]*uint256.Int, len(f.Arg))
+		for j := range f.Arg {
+			i := &uint256.Int{}
+			if err := i.Scan(f.Arg[j]); err != nil {
+				return fmt.Errorf("unable to convert filter arg dec to uint256: %q", f.Arg[j])
+			}
+			args[j] = i
 		}
-		switch f.Op {
-		case "eq":
-			frs.add(v.Cmp(i) == 0)
-		case "ne":
-			frs.add(v.Cmp(i) != 0)
-		case "gt":
-			frs.add(v.Cmp(i) == 1)
-		case "lt":
-			frs.add(v.Cmp(i) == -1)
+		return f.compare(frs, func(j int) int { return v.Cmp(args[j]) })
+	case *negInt:
+		args := make([]*uint256.Int, len(f.Arg))
+		for j := range f.Arg {
+			i, err := decimalInt256(f.Arg[j])
+			if err != nil {
+				return fmt.Errorf("unable to convert filter arg dec to int256: %q", f.Arg[j])
+			}
+			args[j] = i
+		}
+		return f.compare(frs, func(j int) int {
+			switch {
+			case v.i.Slt(args[j]):
+				return -1
+			case v.i.Sgt(args[j]):
+				return 1
+			default:
+				return 0
+			}
+		})
+	}
+	return nil
+}
+
+// Adds the result of a numeric comparison to frs. cmp(j)
+// compares the decoded value to the j'th filter arg
+// and returns -1, 0, or 1. The range op is inclusive
+// on both ends: filter_arg: [min, max].
+func (f Filter) compare(frs *filterResults, cmp func(int) int) error {
+	switch f.Op {
+	case "eq":
+		frs.add(cmp(0) == 0)
+	case "ne":
+		frs.add(cmp(0) != 0)
+	case "gt":
+		frs.add(cmp(0) > 0)
+	case "gte":
+		frs.add(cmp(0) >= 0)
+	case "lt":
+		frs.add(cmp(0) < 0)
+	case "lte":
+		frs.add(cmp(0) <= 0)
+	case "range":
+		if len(f.Arg) != 2 {
+			return fmt.Errorf("range filter requires 2 args. got: %d", len(f.Arg))
 		}
+		frs.add(cmp(0) >= 0 && cmp(1) <= 0)
 	}
 	return nil
 }
 
+// Parses a (possibly negative) decimal string into
+// its two's complement int256 representation.
+// Scientific notation (eg 1e18) is allowed.
+func decimalInt256(s string) (*uint256.Int, error) {
+	neg := strings.HasPrefix(s, "-")
+	i := &uint256.Int{}
+	if err := i.Scan(strings.TrimPrefix(s, "-")); err != nil {
+		return nil, err
+	}
+	if neg {
+		i.Neg(i)
+	}
+	return i, nil
+}
+
 func parseArray(elm atype, s string) atype {
 	if !strings.Contains(s, "]") {
 		return elm
@@ -539,7 +631,11 @@ func (inp Input) ABIType(pos int) (int, atype) {
 		base.pos = pos
 		pos++
 	}
-	return pos, parseArray(base, inp.Type)
+	t := parseArray(base, inp.Type)
+	if inp.Unnest && t.kind == 'a' {
+		t.unnest = true
+	}
+	return pos, t
 }
 
 func (inp Input) Signature() string {
@@ -590,6 +686,15 @@ type Event struct {
 	Name   string  `json:"name"`
 	Type   string  `json:"type"`
 	Inputs []Input `json:"inputs"`
+
+	// Dotted paths to tuple members. See [Event.Expand].
+	Select []string `json:"select,omitempty"`
+
+	// Anonymous events don't log their signature hash so
+	// every topic is an indexed input. When set, logs are
+	// matched on the first topic. Otherwise logs are matched
+	// on address and layout (number of topics and data size).
+	Topic0 string `json:"topic0,omitempty"`
 }
 
 func (e Event) ABIType() atype {
@@ -610,6 +715,15 @@ func (e Event) SignatureHash() []byte {
 	return eth.Keccak([]byte(e.Signature()))
 }
 
+// Value of the first topic used to match logs.
+// Empty for anonymous events without a topic0.
+func (e Event) topic0() []byte {
+	if e.Anon {
+		return eth.DecodeHex(e.Topic0)
+	}
+	return e.SignatureHash()
+}
+
 func (e Event) Signature() string {
 	var s strings.Builder
 	s.WriteString(e.Name)
@@ -642,8 +756,131 @@ func (e Event) numIndexed() int {
 	return res
 }
 
+// Function is an ABI function definition. It is used
+// to decode a transaction's input (calldata) in the same
+// way that [Event] is used to decode a log.
+type Function struct {
+	Name   string  `json:"name"`
+	Type   string  `json:"type"`
+	Inputs []Input `json:"inputs"`
+
+	// Dotted paths to tuple members. See [Event.Expand].
+	Select []string `json:"select,omitempty"`
+}
+
+func (f Function) Empty() bool {
+	return len(f.Name) == 0
+}
+
+func (f Function) ABIType() atype {
+	var fields []atype
+	var pos = 0
+	for i := range f.Inputs {
+		var t atype
+		pos, t = f.Inputs[i].ABIType(pos)
+		fields = append(fields, t)
+	}
+	return tuple(fields...)
+}
+
+func (f Function) Signature() string {
+	var s strings.Builder
+	s.WriteString(f.Name)
+	s.WriteString("(")
+	for i := range f.Inputs {
+		s.WriteString(f.Inputs[i].Signature())
+		if i+1 < len(f.Inputs) {
+			s.WriteString(",")
+		}
+	}
+	s.WriteString(")")
+	return s.String()
+}
+
+// The first 4 bytes of the signature's hash.
+// Calldata for f is prefixed with the selector.
+func (f Function) Selector() []byte {
+	return eth.Keccak([]byte(f.Signature()))[:4]
+}
+
+func (f Function) Selected() []Input {
+	var res []Input
+	for i := range f.Inputs {
+		res = append(res, f.Inputs[i].Selected()...)
+	}
+	return res
+}
+
 type Notification struct {
 	Columns []string `json:"columns"`
+
+	// Sends a JSON object with each row's unique columns
+	// and block_num on the shovel_<table> channel. Like
+	// Columns, notifications are delivered on commit.
+	Publish bool `json:"publish"`
+}
+
+// Receives the rows copied by [Integration.Insert] before
+// the pg transaction commits. Sinks should hold the rows
+// until they're flushed (see [Flusher]). An error aborts the
+// insert so that the rows are retried with the rest of the
+// batch.
+type Sink interface {
+	Send(ctx context.Context, cols []wpg.Column, rows [][]any) error
+}
+
+// Implemented by sinks that deliver after the pg transaction
+// commits. Rows received by Send are held until Flush is called
+// after the commit, or dropped by Reset before the next insert.
+// pg is the pool that the transaction was started from.
+type Flusher interface {
+	Flush(ctx context.Context, pg wpg.Conn) error
+	Reset()
+}
+
+// Implemented by Flushers that write what they hold to pg in
+// the transaction (eg an outbox that Flush publishes from) so
+// that it isn't lost when delivery fails after the commit.
+// Called before the transaction commits.
+type Stager interface {
+	Stage(ctx context.Context, pg wpg.Conn) error
+}
+
+// Implemented by sinks that remove the rows of blocks
+// >= n when they are deleted during a reorg. Called
+// before the pg transaction commits so, like rows, the
+// delete should be held until the next Flush.
+type Deleter interface {
+	Delete(ctx context.Context, n uint64) error
+}
+
+func (ig Integration) Flush(ctx context.Context, pg wpg.Conn) error {
+	var errs []error
+	for _, s := range ig.Sinks {
+		if f, ok := s.(Flusher); ok {
+			errs = append(errs, f.Flush(ctx, pg))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (ig Integration) Stage(ctx context.Context, pg wpg.Conn) error {
+	for _, s := range ig.Sinks {
+		if st, ok := s.(Stager); ok {
+			if err := st.Stage(ctx, pg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ig Integration) Reset() {
+	for _, s := range ig.Sinks {
+		if f, ok := s.(Flusher); ok {
+			f.Reset()
+		}
+	}
 }
 
 type coldef struct {
@@ -651,29 +888,51 @@ type coldef struct {
 	BlockData BlockData
 	Column    wpg.Column
 	Notify    bool
+
+	kind       dbkind
+	transforms []transform
+}
+
+func (def coldef) transform(d any) any {
+	for _, t := range def.transforms {
+		d = t(d)
+	}
+	return d
 }
 
 // Implements the [shovel.Integration] interface
 type Integration struct {
 	name         string
 	Event        Event
+	Function     Function
 	Block        []BlockData
 	Table        wpg.Table
 	Notification Notification
+	Sinks        []Sink
 	filterAGG    string
 
+	// See [OnError]
+	OnError OnError
+
 	Columns []string
 	coldefs []coldef
 
 	indexing         indexingOP
 	numIndexed       int
 	numSelected      int
+	numFnSelected    int
 	numBDSelected    int
 	numTraceSelected int
+	numWDSelected    int
+	numLogSelected   int
 	numNotify        int
 
 	resultCache *Result
 	sighash     []byte
+	anon        bool
+	fnResult    *Result
+	selector    []byte
+	decoders    []*decoder
 }
 
 type indexingOP byte
@@ -682,12 +941,27 @@ const (
 	indexTx indexingOP = iota
 	indexTrace
 	indexLog
+	indexCall
+	indexWithdrawal
+	indexAllLogs
 )
 
-func New(name string, ev Event, bd []BlockData, table wpg.Table, notif Notification, filterAGG string) (Integration, error) {
+func New(name string, ev Event, fn Function, bd []BlockData, table wpg.Table, notif Notification, filterAGG string) (Integration, error) {
+	if !fn.Empty() && len(ev.Name) > 0 {
+		return Integration{}, fmt.Errorf("event and function are mutually exclusive")
+	}
+	ev, err := ev.Expand()
+	if err != nil {
+		return Integration{}, err
+	}
+	fn, err = fn.Expand()
+	if err != nil {
+		return Integration{}, err
+	}
 	ig := Integration{
 		name:         name,
 		Event:        ev,
+		Function:     fn,
 		Block:        bd,
 		Table:        table,
 		Notification: notif,
@@ -696,9 +970,16 @@ func New(name string, ev Event, bd []BlockData, table wpg.Table, notif Notificat
 		numNotify:   len(notif.Columns),
 		numIndexed:  ev.numIndexed(),
 		resultCache: NewResult(ev.ABIType()),
-		sighash:     ev.SignatureHash(),
+		sighash:     ev.topic0(),
+		anon:        ev.Anon,
+	}
+	if !fn.Empty() {
+		ig.fnResult = NewResult(fn.ABIType())
+		ig.selector = fn.Selector()
+	}
+	if err := ig.setCols(); err != nil {
+		return Integration{}, err
 	}
-	ig.setCols()
 	ig.setIndexing()
 	return ig, nil
 }
@@ -710,12 +991,21 @@ func (ig *Integration) setIndexing() {
 	if ig.numSelected > 0 {
 		ig.indexing = indexLog
 	}
+	if ig.numSelected == 0 && ig.numLogSelected > 0 {
+		ig.indexing = indexAllLogs
+	}
 	if ig.numTraceSelected > 0 {
 		ig.indexing = indexTrace
 	}
+	if ig.numWDSelected > 0 {
+		ig.indexing = indexWithdrawal
+	}
+	if ig.numFnSelected > 0 {
+		ig.indexing = indexCall
+	}
 }
 
-func (ig *Integration) setCols() {
+func (ig *Integration) setCols() error {
 	getCol := func(name string) wpg.Column {
 		for _, c := range ig.Table.Columns {
 			if c.Name == name {
@@ -726,14 +1016,36 @@ func (ig *Integration) setCols() {
 	}
 	for _, input := range ig.Event.Selected() {
 		c := getCol(input.Column)
+		ts, err := parseTransforms(input.Transform)
+		if err != nil {
+			return fmt.Errorf("input %s: %w", input.Name, err)
+		}
 		ig.Columns = append(ig.Columns, c.Name)
 		ig.coldefs = append(ig.coldefs, coldef{
-			Input:  input,
-			Column: c,
-			Notify: slices.Contains(ig.Notification.Columns, c.Name),
+			Input:      input,
+			Column:     c,
+			Notify:     slices.Contains(ig.Notification.Columns, c.Name),
+			kind:       dbkindOf(input.Type),
+			transforms: ts,
 		})
 		ig.numSelected++
 	}
+	for _, input := range ig.Function.Selected() {
+		c := getCol(input.Column)
+		ts, err := parseTransforms(input.Transform)
+		if err != nil {
+			return fmt.Errorf("input %s: %w", input.Name, err)
+		}
+		ig.Columns = append(ig.Columns, c.Name)
+		ig.coldefs = append(ig.coldefs, coldef{
+			Input:      input,
+			Column:     c,
+			Notify:     slices.Contains(ig.Notification.Columns, c.Name),
+			kind:       dbkindOf(input.Type),
+			transforms: ts,
+		})
+		ig.numFnSelected++
+	}
 	for _, bd := range ig.Block {
 		c := getCol(bd.Column)
 		ig.Columns = append(ig.Columns, c.Name)
@@ -746,16 +1058,73 @@ func (ig *Integration) setCols() {
 		if strings.HasPrefix(c.Name, "trace_") {
 			ig.numTraceSelected++
 		}
+		if strings.HasPrefix(bd.Name, "withdrawal_") {
+			ig.numWDSelected++
+		}
+		if strings.HasPrefix(bd.Name, "log_") {
+			ig.numLogSelected++
+		}
 	}
+	return nil
 }
 
 func (ig Integration) Name() string { return ig.name }
 
+// Multi combines integrations that share a name and table
+// but decode different events. Rows for each event are
+// written using the event's columns. Block data, including
+// filters, is shared.
+type Multi []Integration
+
+func (m Multi) Name() string { return m[0].name }
+
+func (m Multi) Filter() glf.Filter {
+	var topics []string
+	for i := range m {
+		if len(m[i].sighash) == 0 {
+			topics = nil
+			break
+		}
+		topics = append(topics, eth.EncodeHex(m[i].sighash))
+	}
+	f := m[0].Filter()
+	if len(topics) == 0 {
+		f.SetTopics(nil)
+		return f
+	}
+	f.SetTopics([][]string{topics})
+	return f
+}
+
+func (m Multi) Delete(ctx context.Context, pg wpg.Conn, n uint64) error {
+	return m[0].Delete(ctx, pg, n)
+}
+
+// Each integration shares the same sinks
+func (m Multi) Flush(ctx context.Context, pg wpg.Conn) error { return m[0].Flush(ctx, pg) }
+func (m Multi) Stage(ctx context.Context, pg wpg.Conn) error { return m[0].Stage(ctx, pg) }
+func (m Multi) Reset()                                       { m[0].Reset() }
+
+func (m Multi) Insert(ctx context.Context, pgmut *sync.Mutex, pg wpg.Conn, blocks []eth.Block) (int64, error) {
+	var res int64
+	for i := range m {
+		n, err := m[i].Insert(ctx, pgmut, pg, blocks)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", m[i].Event.Name, err)
+		}
+		res += n
+	}
+	return res, nil
+}
+
 func (ig Integration) Filter() glf.Filter {
 	var (
 		fields []string
 		addrs  []string
 	)
+	if ig.numFnSelected > 0 {
+		fields = append(fields, "tx_input")
+	}
 	for i := range ig.Block {
 		fields = append(fields, ig.Block[i].Name)
 
@@ -765,7 +1134,36 @@ func (ig Integration) Filter() glf.Filter {
 			}
 		}
 	}
-	return *glf.New(fields, addrs, [][]string{{eth.EncodeHex(ig.sighash)}})
+	var topics [][]string
+	if len(ig.sighash) > 0 && ig.indexing != indexAllLogs {
+		topics = [][]string{{eth.EncodeHex(ig.sighash)}}
+	}
+	return *glf.New(fields, addrs, topics)
+}
+
+// Topics of anonymous events start with the first
+// indexed input rather than the signature hash.
+func (ig Integration) topicOffset() int {
+	if ig.anon {
+		return 0
+	}
+	return 1
+}
+
+// Anonymous events without a topic0 are matched
+// on layout so logs whose data can't hold the event's
+// un-indexed inputs are skipped rather than failing
+// the batch.
+func (ig Integration) fits(data []byte) bool {
+	t := ig.resultCache.t
+	switch {
+	case len(t.fields) == 0:
+		return len(data) == 0
+	case t.static:
+		return len(data) == t.size
+	default:
+		return len(data) > 0
+	}
 }
 
 func (ig Integration) Delete(ctx context.Context, pg wpg.Conn, n uint64) error {
@@ -776,7 +1174,26 @@ func (ig Integration) Delete(ctx context.Context, pg wpg.Conn, n uint64) error {
 		and block_num >= $3
 	`
 	_, err := pg.Exec(ctx,
-		fmt.Sprintf(q, ig.Table.Name),
+		fmt.Sprintf(q, ig.Table.QName()),
+		wctx.SrcName(ctx),
+		ig.name,
+		n,
+	)
+	if err != nil {
+		return err
+	}
+	for _, s := range ig.Sinks {
+		if d, ok := s.(Deleter); ok {
+			if err := d.Delete(ctx, n); err != nil {
+				return fmt.Errorf("deleting from sink: %w", err)
+			}
+		}
+	}
+	if ig.OnError != OnErrorDeadLetter {
+		return nil
+	}
+	_, err = pg.Exec(ctx,
+		fmt.Sprintf(q, "shovel.dead_letters"),
 		wctx.SrcName(ctx),
 		ig.name,
 		n,
@@ -784,15 +1201,55 @@ func (ig Integration) Delete(ctx context.Context, pg wpg.Conn, n uint64) error {
 	return err
 }
 
+// Time spent by Insert decoding blocks into rows and
+// writing the rows. Set using [WithTiming].
+type Timing struct {
+	decode, write atomic.Int64
+}
+
+func (t *Timing) Decode() time.Duration {
+	return time.Duration(t.decode.Load())
+}
+
+func (t *Timing) Write() time.Duration {
+	return time.Duration(t.write.Load())
+}
+
+type timingKey struct{}
+
+func WithTiming(ctx context.Context, t *Timing) context.Context {
+	return context.WithValue(ctx, timingKey{}, t)
+}
+
+func timing(ctx context.Context) *Timing {
+	t, _ := ctx.Value(timingKey{}).(*Timing)
+	if t == nil {
+		return &Timing{}
+	}
+	return t
+}
+
 func (ig Integration) Insert(ctx context.Context, pgmut *sync.Mutex, pg wpg.Conn, blocks []eth.Block) (int64, error) {
 	var (
 		err  error
 		skip bool
 		rows [][]any
-		lwc  = &logWithCtx{ctx: wctx.WithIGName(ctx, ig.Name())}
+		lwc  = &logWithCtx{ctx: wctx.WithIGName(ctx, ig.Name()), event: ig.Event.Name, arena: &arena{}}
+		tm   = timing(ctx)
+		t0   = time.Now()
 	)
 	for bidx := range blocks {
 		lwc.b = &blocks[bidx]
+		if ig.indexing == indexWithdrawal {
+			for widx := range blocks[bidx].Withdrawals {
+				lwc.w = &lwc.b.Withdrawals[widx]
+				rows, _, err = ig.processTx(rows, lwc, pgmut, pg)
+				if err != nil {
+					return 0, fmt.Errorf("processing withdrawal: %w", err)
+				}
+			}
+			continue
+		}
 		for tidx := range blocks[bidx].Txs {
 			lwc.t = &lwc.b.Txs[tidx]
 			switch ig.indexing {
@@ -812,6 +1269,11 @@ func (ig Integration) Insert(ctx context.Context, pgmut *sync.Mutex, pg wpg.Conn
 						return 0, fmt.Errorf("processing log: %w", err)
 					}
 				}
+			case indexCall:
+				rows, err = ig.processCall(rows, lwc, pgmut, pg)
+				if err != nil {
+					return 0, fmt.Errorf("processing call: %w", err)
+				}
 			case indexLog:
 				for lidx := range blocks[bidx].Txs[tidx].Logs {
 					lwc.l = &lwc.t.Logs[lidx]
@@ -820,28 +1282,238 @@ func (ig Integration) Insert(ctx context.Context, pgmut *sync.Mutex, pg wpg.Conn
 						return 0, fmt.Errorf("processing log: %w", err)
 					}
 				}
+			case indexAllLogs:
+				for lidx := range blocks[bidx].Txs[tidx].Logs {
+					lwc.l = &lwc.t.Logs[lidx]
+					lwc.dl = ig.decode(lwc.l)
+					rows, _, err = ig.processTx(rows, lwc, pgmut, pg)
+					if err != nil {
+						return 0, fmt.Errorf("processing log: %w", err)
+					}
+				}
 			}
 		}
 	}
-	pgmut.Lock()
-	defer pgmut.Unlock()
+	tm.decode.Add(int64(time.Since(t0)))
 
-	nr, err := pg.CopyFrom(
-		ctx,
-		pgx.Identifier{ig.Table.Name},
-		ig.Columns,
-		pgx.CopyFromRows(rows),
-	)
+	tw := time.Now()
+	pgmut.Lock()
+	nr, rows, err := ig.write(ctx, pg, lwc, blocks, rows)
+	pgmut.Unlock()
+	tm.write.Add(int64(time.Since(tw)))
 	if err != nil {
 		return 0, err
 	}
+	if len(rows) > 0 && len(ig.Sinks) > 0 {
+		cols := make([]wpg.Column, len(ig.coldefs))
+		for i := range ig.coldefs {
+			cols[i] = ig.coldefs[i].Column
+		}
+		for _, s := range ig.Sinks {
+			if err := s.Send(lwc.ctx, cols, rows); err != nil {
+				return 0, fmt.Errorf("sending to sink: %w", err)
+			}
+		}
+	}
+	return nr, nil
+}
+
+// Called with pgmut held. Returns the rows that were
+// inserted.
+func (ig Integration) write(ctx context.Context, pg wpg.Conn, lwc *logWithCtx, blocks []eth.Block, rows [][]any) (int64, [][]any, error) {
+	if ig.Table.PartitionSize > 0 && len(blocks) > 0 {
+		first, last := blocks[0].Num(), blocks[len(blocks)-1].Num()
+		if err := ig.Table.Partition(ctx, pg, first, last); err != nil {
+			return 0, nil, fmt.Errorf("creating partitions: %w", err)
+		}
+	}
+
+	var (
+		nr  int64
+		err error
+	)
+	switch {
+	case ig.OnError.lenient():
+		nr, rows, err = ig.insertLenient(ctx, pg, lwc, rows)
+		if err != nil {
+			return 0, nil, err
+		}
+		if err := ig.handleDeadLetters(lwc.ctx, pg, lwc.dls); err != nil {
+			return 0, nil, err
+		}
+	default:
+		nr, rows, err = ig.insert(ctx, pg, rows)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	if ig.Notification.Publish && len(rows) > 0 {
+		if err := ig.publish(lwc.ctx, pg, rows); err != nil {
+			slog.ErrorContext(lwc.ctx, "publishing rows", "error", err)
+		}
+	}
 	if ig.numNotify == 0 {
-		return nr, nil
+		return nr, rows, nil
 	}
 	if err := ig.notify(lwc, pg, rows); err != nil {
 		slog.ErrorContext(lwc.ctx, "sending notifications", "error", err)
 	}
-	return nr, nil
+	return nr, rows, nil
+}
+
+func (ig Integration) insert(ctx context.Context, pg wpg.Conn, rows [][]any) (int64, [][]any, error) {
+	if wctx.Backfill(ctx) && !wctx.Overlap(ctx) {
+		nr, err := pg.CopyFrom(
+			ctx,
+			ig.Table.Identifier(),
+			ig.Columns,
+			pgx.CopyFromRows(rows),
+		)
+		return nr, rows, err
+	}
+	return ig.insertRows(ctx, pg, rows)
+}
+
+// Near the head, blocks may be re-processed (eg after a
+// restart that raced a commit) so rows that already exist
+// are skipped rather than failing the unique index. COPY
+// is faster but has no equivalent of on conflict.
+//
+// Returns the rows that were inserted so that sinks and
+// notifications don't see the skipped rows twice.
+func (ig Integration) insertRows(ctx context.Context, pg wpg.Conn, rows [][]any) (int64, [][]any, error) {
+	if len(rows) == 0 || len(ig.Columns) == 0 {
+		return 0, nil, nil
+	}
+	const maxParams = 65535
+	var (
+		nr       int64
+		inserted [][]any
+		key      = ig.uniqueKey()
+		cols     = make([]string, len(ig.Columns))
+		perStmt  = maxParams / len(ig.Columns)
+	)
+	for i := range ig.Columns {
+		cols[i] = pgx.Identifier{ig.Columns[i]}.Sanitize()
+	}
+	for len(rows) > 0 {
+		var (
+			n      = min(len(rows), perStmt)
+			values = make([]string, n)
+			args   = make([]any, 0, n*len(cols))
+		)
+		for i := 0; i < n; i++ {
+			ph := make([]string, len(cols))
+			for j := range cols {
+				args = append(args, rows[i][j])
+				ph[j] = fmt.Sprintf("$%d", len(args))
+				if len(key) > 0 {
+					ph[j] += "::" + ig.coldefs[j].Column.Type
+				}
+			}
+			if len(key) > 0 {
+				ph = append(ph, strconv.Itoa(i))
+			}
+			values[i] = "(" + strings.Join(ph, ",") + ")"
+		}
+		if len(key) == 0 {
+			q := fmt.Sprintf(
+				"insert into %s (%s) values %s on conflict do nothing",
+				ig.Table.Identifier().Sanitize(),
+				strings.Join(cols, ","),
+				strings.Join(values, ","),
+			)
+			cmd, err := pg.Exec(ctx, q, args...)
+			if err != nil {
+				return 0, nil, fmt.Errorf("inserting rows: %w", err)
+			}
+			nr += cmd.RowsAffected()
+			inserted = append(inserted, rows[:n]...)
+			rows = rows[n:]
+			continue
+		}
+		ords, err := ig.insertReturning(ctx, pg, cols, key, values, args)
+		if err != nil {
+			return 0, nil, fmt.Errorf("inserting rows: %w", err)
+		}
+		for _, i := range ords {
+			inserted = append(inserted, rows[i])
+		}
+		nr += int64(len(ords))
+		rows = rows[n:]
+	}
+	return nr, inserted, nil
+}
+
+// Inserts the values and returns the (sorted) ordinals of
+// the rows that didn't conflict. The ordinal is the last
+// column of each value. Rows are matched on the unique key
+// since insert ... returning can't reference the source
+// rows. When the values repeat a key the first one is
+// inserted. A key with a null never conflicts.
+func (ig Integration) insertReturning(ctx context.Context, pg wpg.Conn, cols []string, key []int, values []string, args []any) ([]int, error) {
+	var (
+		rk = make([]string, len(key))
+		vk = make([]string, len(key))
+		ik = make([]string, len(key))
+		nk = make([]string, len(key))
+	)
+	for i, j := range key {
+		rk[i] = cols[j]
+		vk[i] = "v." + cols[j]
+		ik[i] = "ins." + cols[j]
+		nk[i] = vk[i] + " is null"
+	}
+	q := fmt.Sprintf(`
+		with v (%s, shovel_ord) as (values %s),
+		ins as (
+			insert into %s (%s)
+			select %s from v order by shovel_ord
+			on conflict do nothing
+			returning %s
+		)
+		select min(v.shovel_ord)
+		from v join ins on (%s) = (%s)
+		group by %s
+		union all
+		select v.shovel_ord from v where %s
+		order by 1
+	`,
+		strings.Join(cols, ","),
+		strings.Join(values, ","),
+		ig.Table.Identifier().Sanitize(),
+		strings.Join(cols, ","),
+		strings.Join(cols, ","),
+		strings.Join(rk, ","),
+		strings.Join(vk, ","),
+		strings.Join(ik, ","),
+		strings.Join(ik, ","),
+		strings.Join(nk, " or "),
+	)
+	rows, err := pg.Query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int])
+}
+
+// Indexes into ig.Columns of the table's unique key. Nil
+// when the table has no unique key or the integration
+// doesn't write every column of it, in which case every
+// row is assumed to be inserted.
+func (ig Integration) uniqueKey() []int {
+	if len(ig.Table.Unique) == 0 || len(ig.coldefs) != len(ig.Columns) {
+		return nil
+	}
+	var key []int
+	for _, name := range ig.Table.Unique[0] {
+		j := slices.Index(ig.Columns, name)
+		if j < 0 {
+			return nil
+		}
+		key = append(key, j)
+	}
+	return key
 }
 
 func (ig *Integration) notify(lwc *logWithCtx, pg wpg.Conn, rows [][]any) error {
@@ -860,22 +1532,11 @@ func (ig *Integration) notify(lwc *logWithCtx, pg wpg.Conn, rows [][]any) error
 				if ig.coldefs[k].Column.Name != ig.Notification.Columns[j] {
 					continue
 				}
-				switch v := rows[i][k].(type) {
-				case int:
-					payload = append(payload, strconv.Itoa(v))
-				case uint64:
-					payload = append(payload, strconv.FormatUint(v, 10))
-				case eth.Uint64:
-					payload = append(payload, strconv.FormatUint(uint64(v), 10))
-				case string:
-					payload = append(payload, v)
-				case []byte:
-					payload = append(payload, eth.EncodeHex(v))
-				case *uint256.Int:
-					payload = append(payload, v.Dec())
-				default:
-					return fmt.Errorf("unknown type for notification: %T", rows[i][k])
+				s, err := notifyText(rows[i][k])
+				if err != nil {
+					return err
 				}
+				payload = append(payload, s)
 			}
 		}
 		if _, err := pg.Exec(lwc.ctx, q, strings.Join(payload, ",")); err != nil {
@@ -885,12 +1546,116 @@ func (ig *Integration) notify(lwc *logWithCtx, pg wpg.Conn, rows [][]any) error
 	return nil
 }
 
+func notifyText(d any) (string, error) {
+	switch v := d.(type) {
+	case int:
+		return strconv.Itoa(v), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case eth.Uint64:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []byte:
+		return eth.EncodeHex(v), nil
+	case *uint256.Int:
+		return v.Dec(), nil
+	case decimal:
+		return string(v), nil
+	case time.Time:
+		return v.UTC().Format(time.RFC3339), nil
+	default:
+		return "", fmt.Errorf("unknown type for notification: %T", d)
+	}
+}
+
+// Channel used by [Notification.Publish]
+func (ig Integration) PublishChannel() string {
+	return "shovel_" + strings.ReplaceAll(ig.Table.QName(), ".", "_")
+}
+
+// The table's unique columns, or block_num when the
+// table has no unique index, followed by block_num.
+func (ig Integration) publishColumns() []int {
+	names := []string{"block_num"}
+	if len(ig.Table.Unique) > 0 {
+		names = append(slices.Clone(ig.Table.Unique[0]), names...)
+	}
+	var res []int
+	for _, name := range names {
+		for k := range ig.coldefs {
+			if ig.coldefs[k].Column.Name == name && !slices.Contains(res, k) {
+				res = append(res, k)
+			}
+		}
+	}
+	return res
+}
+
+func (ig Integration) publish(ctx context.Context, pg wpg.Conn, rows [][]any) error {
+	payloads, err := ig.publishPayloads(rows)
+	if err != nil {
+		return err
+	}
+	const q = `select pg_notify($1, p) from unnest($2::text[]) p`
+	if _, err := pg.Exec(ctx, q, ig.PublishChannel(), payloads); err != nil {
+		return fmt.Errorf("publishing rows: %w", err)
+	}
+	return nil
+}
+
+// A compact JSON object per row. Integers are
+// numbers and every other value is a string.
+func (ig Integration) publishPayloads(rows [][]any) ([]string, error) {
+	var (
+		cols     = ig.publishColumns()
+		payloads = make([]string, len(rows))
+		b        []byte
+	)
+	for i := range rows {
+		b = append(b[:0], '{')
+		for j, k := range cols {
+			if j > 0 {
+				b = append(b, ',')
+			}
+			b = strconv.AppendQuote(b, ig.coldefs[k].Column.Name)
+			b = append(b, ':')
+			switch v := rows[i][k].(type) {
+			case nil:
+				b = append(b, "null"...)
+			case int, uint64, eth.Uint64:
+				s, _ := notifyText(v)
+				b = append(b, s...)
+			default:
+				s, err := notifyText(v)
+				if err != nil {
+					return nil, err
+				}
+				jb, err := json.Marshal(s)
+				if err != nil {
+					return nil, err
+				}
+				b = append(b, jb...)
+			}
+		}
+		payloads[i] = string(append(b, '}'))
+	}
+	return payloads, nil
+}
+
 type logWithCtx struct {
-	ctx context.Context
-	b   *eth.Block
-	t   *eth.Tx
-	l   *eth.Log
-	ta  *eth.TraceAction
+	ctx   context.Context
+	event string
+	b     *eth.Block
+	t     *eth.Tx
+	l     *eth.Log
+	ta    *eth.TraceAction
+	w     *eth.Withdrawal
+	dl    *decodedLog
+	dls   []deadLetter
+	arena *arena
 }
 
 func (lwc *logWithCtx) get(name string) any {
@@ -901,12 +1666,28 @@ func (lwc *logWithCtx) get(name string) any {
 		return wctx.IGName(lwc.ctx)
 	case "chain_id":
 		return wctx.ChainID(lwc.ctx)
+	case "event_name":
+		return lwc.event
 	case "block_hash":
 		return lwc.b.Hash()
 	case "block_num":
 		return lwc.b.Num()
 	case "block_time":
 		return lwc.b.Time
+	case "block_blob_gas_used":
+		return lwc.b.BlobGasUsed
+	case "block_excess_blob_gas":
+		return lwc.b.ExcessBlobGas
+	case "block_l1_num":
+		return lwc.b.L1Num
+	case "block_send_root":
+		return lwc.b.SendRoot.Bytes()
+	case "block_send_count":
+		return lwc.b.SendCount
+	case "block_l1_batch_num":
+		return lwc.b.L1BatchNum
+	case "block_l1_batch_time":
+		return lwc.b.L1BatchTime
 	case "tx_hash":
 		return lwc.t.Hash()
 	case "tx_idx":
@@ -932,6 +1713,10 @@ func (lwc *logWithCtx) get(name string) any {
 		return lwc.l.Idx
 	case "tx_gas_used":
 		return lwc.t.GasUsed
+	case "tx_cumulative_gas_used":
+		return lwc.t.CumulativeGasUsed
+	case "tx_contract_address":
+		return lwc.t.ContractAddress.Bytes()
 	case "tx_gas_price":
 		return &lwc.t.GasPrice
 	case "tx_effective_gas_price":
@@ -940,10 +1725,54 @@ func (lwc *logWithCtx) get(name string) any {
 		return &lwc.t.MaxPriorityFeePerGas
 	case "tx_max_fee_per_gas":
 		return &lwc.t.MaxFeePerGas
+	case "tx_max_fee_per_blob_gas":
+		return &lwc.t.MaxFeePerBlobGas
+	case "tx_source_hash":
+		return lwc.t.SourceHash.Bytes()
+	case "tx_mint":
+		return &lwc.t.Mint
+	case "tx_is_system_tx":
+		return lwc.t.IsSystemTx
+	case "tx_request_id":
+		return lwc.t.RequestID.Bytes()
+	case "tx_ticket_id":
+		return lwc.t.TicketID.Bytes()
+	case "tx_refund_to":
+		return lwc.t.RefundTo.Bytes()
+	case "tx_l1_base_fee":
+		return &lwc.t.L1BaseFee
+	case "tx_deposit_value":
+		return &lwc.t.DepositValue
+	case "tx_l1_batch_tx_idx":
+		return lwc.t.L1BatchTxIdx
+	case "tx_blob_versioned_hashes":
+		res := make([][]byte, len(lwc.t.BlobVersionedHashes))
+		for i := range lwc.t.BlobVersionedHashes {
+			res[i] = lwc.t.BlobVersionedHashes[i]
+		}
+		return res
 	case "tx_nonce":
 		return lwc.t.Nonce
 	case "log_addr":
 		return lwc.l.Address.Bytes()
+	case "log_topics":
+		res := make([][]byte, len(lwc.l.Topics))
+		for i := range lwc.l.Topics {
+			res[i] = lwc.l.Topics[i]
+		}
+		return res
+	case "log_data":
+		return lwc.l.Data.Bytes()
+	case "log_event":
+		if lwc.dl == nil {
+			return nil
+		}
+		return lwc.dl.event
+	case "log_decoded":
+		if lwc.dl == nil {
+			return nil
+		}
+		return lwc.dl.json
 	case "trace_action_call_type":
 		return lwc.ta.CallType
 	case "trace_action_idx":
@@ -954,6 +1783,25 @@ func (lwc *logWithCtx) get(name string) any {
 		return lwc.ta.To.Bytes()
 	case "trace_action_value":
 		return &lwc.ta.Value
+	case "trace_action_input":
+		return lwc.ta.Input.Bytes()
+	case "trace_action_selector":
+		if len(lwc.ta.Input) < 4 {
+			return []byte{}
+		}
+		return []byte(lwc.ta.Input[:4])
+	case "trace_action_depth":
+		return lwc.ta.Depth
+	case "trace_action_error":
+		return lwc.ta.Error
+	case "withdrawal_index":
+		return lwc.w.Index
+	case "withdrawal_validator_index":
+		return lwc.w.ValidatorIndex
+	case "withdrawal_address":
+		return lwc.w.Address.Bytes()
+	case "withdrawal_amount":
+		return lwc.w.Amount
 	default:
 		return nil
 	}
@@ -992,7 +1840,7 @@ func (ig Integration) processTx(rows [][]any, lwc *logWithCtx, pgmut *sync.Mutex
 		return rows, false, nil
 	case ig.numBDSelected > 0:
 		frs := filterResults{kind: ig.filterAGG}
-		row := make([]any, len(ig.coldefs))
+		row := lwc.arena.row(len(ig.coldefs))
 		for i, def := range ig.coldefs {
 			switch {
 			case !def.BlockData.Empty():
@@ -1006,31 +1854,97 @@ func (ig Integration) processTx(rows [][]any, lwc *logWithCtx, pgmut *sync.Mutex
 			}
 		}
 		if frs.accept() {
-			rows = append(rows, row)
+			rows = append(rows, ig.encode(row))
 		}
 	}
 	return rows, true, nil
 }
 
+// Decodes the tx's input using ig.Function when the input
+// begins with the function's selector. A row is added for
+// each decoded result (more than 1 when an array is selected).
+func (ig Integration) processCall(rows [][]any, lwc *logWithCtx, pgmut *sync.Mutex, pg wpg.Conn) ([][]any, error) {
+	input := lwc.t.Data.Bytes()
+	if len(input) < 4 || !bytes.Equal(ig.selector, input[:4]) {
+		return rows, nil
+	}
+	if err := ig.fnResult.Scan(input[4:]); err != nil {
+		// Anyone can send a tx with the selector and any input
+		// so the tx is skipped unless on_error is set to fail.
+		err = fmt.Errorf("scanning calldata: %w", err)
+		mCalldataErrors.Inc(wctx.SrcName(lwc.ctx), ig.name)
+		switch ig.OnError {
+		case OnErrorFail:
+			return nil, err
+		case OnErrorSkip, OnErrorDeadLetter:
+			lwc.deadLetter(err)
+		default:
+			slog.WarnContext(lwc.ctx, "skipping",
+				"ig", ig.name,
+				"block", lwc.b.Num(),
+				"tx", eth.EncodeHex(lwc.t.Hash()),
+				"error", err,
+			)
+		}
+		return rows, nil
+	}
+	for i := 0; i < ig.fnResult.Len(); i++ {
+		actr := 0
+		frs := filterResults{kind: ig.filterAGG}
+		row := lwc.arena.row(len(ig.coldefs))
+		for j, def := range ig.coldefs {
+			switch {
+			case def.BlockData.Name == "abi_idx", def.BlockData.Name == "array_idx":
+				row[j] = i
+			case !def.BlockData.Empty():
+				d := lwc.get(def.BlockData.Name)
+				if err := def.BlockData.Accept(lwc.ctx, pgmut, pg, d, &frs); err != nil {
+					return nil, fmt.Errorf("checking filter: %w", err)
+				}
+				row[j] = d
+			default:
+				d := def.kind.decode(lwc.arena, ig.fnResult.At(i)[actr])
+				if err := def.Input.Accept(lwc.ctx, pgmut, pg, d, &frs); err != nil {
+					return nil, fmt.Errorf("checking filter: %w", err)
+				}
+				row[j] = d
+				actr++
+			}
+		}
+		if frs.accept() {
+			rows = append(rows, ig.encode(row))
+		}
+	}
+	return rows, nil
+}
+
 func (ig Integration) processLog(rows [][]any, lwc *logWithCtx, pgmut *sync.Mutex, pg wpg.Conn) ([][]any, error) {
 	switch {
-	case len(lwc.l.Topics)-1 != ig.numIndexed:
+	case len(lwc.l.Topics)-ig.topicOffset() != ig.numIndexed:
 		return rows, nil
-	case !bytes.Equal(ig.sighash, lwc.l.Topics[0]):
+	case len(ig.sighash) > 0 && !bytes.Equal(ig.sighash, lwc.l.Topics[0]):
+		return rows, nil
+	case ig.anon && len(ig.sighash) == 0 && !ig.fits(lwc.l.Data):
 		return rows, nil
 	case len(lwc.l.Data) > 0:
 		err := ig.resultCache.Scan(lwc.l.Data)
-		if err != nil {
+		switch {
+		case err != nil && ig.anon && len(ig.sighash) == 0:
+			return rows, nil
+		case err != nil && ig.OnError.lenient():
+			lwc.deadLetter(fmt.Errorf("scanning abi data: %w", err))
+			return rows, nil
+		case err != nil:
 			return nil, fmt.Errorf("scanning abi data: %w", err)
 		}
 		for i := 0; i < ig.resultCache.Len(); i++ {
-			ictr, actr := 1, 0
+			ictr, actr := ig.topicOffset(), 0
 			frs := filterResults{kind: ig.filterAGG}
-			row := make([]any, len(ig.coldefs))
+			row := lwc.arena.row(len(ig.coldefs))
 			for j, def := range ig.coldefs {
 				switch {
 				case def.Input.Indexed:
-					d := dbtype(def.Input.Type, lwc.l.Topics[ictr])
+					d := def.kind.decode(lwc.arena, lwc.l.Topics[ictr])
 					if err := def.Input.Accept(lwc.ctx, pgmut, pg, d, &frs); err != nil {
 						return nil, fmt.Errorf("checking filter: %w", err)
 					}
@@ -1039,7 +1953,7 @@ func (ig Integration) processLog(rows [][]any, lwc *logWithCtx, pgmut *sync.Mute
 				case !def.BlockData.Empty():
 					var d any
 					switch {
-					case def.BlockData.Name == "abi_idx":
+					case def.BlockData.Name == "abi_idx", def.BlockData.Name == "array_idx":
 						d = i
 					default:
 						d = lwc.get(def.BlockData.Name)
@@ -1049,7 +1963,7 @@ func (ig Integration) processLog(rows [][]any, lwc *logWithCtx, pgmut *sync.Mute
 					}
 					row[j] = d
 				default:
-					d := dbtype(def.Input.Type, ig.resultCache.At(i)[actr])
+					d := def.kind.decode(lwc.arena, ig.resultCache.At(i)[actr])
 					if err := def.Input.Accept(lwc.ctx, pgmut, pg, d, &frs); err != nil {
 						return nil, fmt.Errorf("checking filter: %w", err)
 					}
@@ -1058,16 +1972,16 @@ func (ig Integration) processLog(rows [][]any, lwc *logWithCtx, pgmut *sync.Mute
 				}
 			}
 			if frs.accept() {
-				rows = append(rows, row)
+				rows = append(rows, ig.encode(row))
 			}
 		}
 	default:
 		frs := filterResults{kind: ig.filterAGG}
-		row := make([]any, len(ig.coldefs))
+		row := lwc.arena.row(len(ig.coldefs))
 		for i, def := range ig.coldefs {
 			switch {
 			case def.Input.Indexed:
-				d := dbtype(def.Input.Type, lwc.l.Topics[1+i])
+				d := def.kind.decode(lwc.arena, lwc.l.Topics[ig.topicOffset()+i])
 				if err := def.Input.Accept(lwc.ctx, pgmut, pg, d, &frs); err != nil {
 					return nil, fmt.Errorf("checking filter: %w", err)
 				}
@@ -1083,7 +1997,7 @@ func (ig Integration) processLog(rows [][]any, lwc *logWithCtx, pgmut *sync.Mute
 			}
 		}
 		if frs.accept() {
-			rows = append(rows, row)
+			rows = append(rows, ig.encode(row))
 		}
 	}
 	return rows, nil
@@ -1094,38 +2008,71 @@ type negInt struct {
 }
 
 func (ni *negInt) Value() (driver.Value, error) {
-	v, err := ni.i.Value()
-	if ni.i.Sign() < 0 {
-		x := uint256.NewInt(0).Neg(ni.i)
-		v, err = x.Value()
-		return "-" + v.(string), err
+	if ni.i.Sign() >= 0 {
+		return ni.i.Dec(), nil
 	}
-	return v, err
+	var x uint256.Int
+	x.Neg(ni.i)
+	return "-" + x.Dec(), nil
 }
 
-func dbtype(abitype string, d []byte) any {
+// The database type of an abi type. Computed once per
+// column so that decoding doesn't compare type names.
+type dbkind byte
+
+const (
+	dbBytes dbkind = iota
+	dbInt
+	dbUint
+	dbAddress
+	dbBool
+	dbString
+	dbDynBytes
+)
+
+func dbkindOf(abitype string) dbkind {
 	switch {
 	case strings.HasPrefix(abitype, "int"):
-		x := &uint256.Int{}
-		x.SetBytes(d)
-		return &negInt{x}
+		return dbInt
 	case strings.HasPrefix(abitype, "uint"):
-		var x uint256.Int
-		x.SetBytes(d)
-		return &x
+		return dbUint
 	case strings.HasPrefix(abitype, "address"):
+		return dbAddress
+	case abitype == "bool":
+		return dbBool
+	case abitype == "string":
+		return dbString
+	case abitype == "bytes":
+		return dbDynBytes
+	default:
+		return dbBytes
+	}
+}
+
+func dbtype(abitype string, d []byte) any {
+	return dbkindOf(abitype).decode(nil, d)
+}
+
+// Integers are allocated from a (which may be nil)
+func (k dbkind) decode(a *arena, d []byte) any {
+	switch k {
+	case dbInt:
+		return a.int(d)
+	case dbUint:
+		return a.uint(d)
+	case dbAddress:
 		if len(d) == 32 {
 			return d[12:]
 		}
 		return d
-	case abitype == "bool":
+	case dbBool:
 		if len(d) == 32 {
 			return d[31] == 0x01
 		}
 		return false
-	case abitype == "string":
+	case dbString:
 		return string(d)
-	case abitype == "bytes":
+	case dbDynBytes:
 		if len(d) == 0 {
 			return []byte{}
 		}
@@ -8,12 +8,14 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"blake.io/pqx/pqxtest"
 	"github.com/holiman/uint256"
 	"github.com/indexsupply/shovel/bint"
 	"github.com/indexsupply/shovel/eth"
 	"github.com/indexsupply/shovel/tc"
+	"github.com/indexsupply/shovel/wpg"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
 
@@ -196,6 +198,11 @@ func TestScan_Reset(t *testing.T) {
 	})
 }
 
+func unnest(t atype) atype {
+	t.unnest = true
+	return t
+}
+
 func TestScan(t *testing.T) {
 	cases := []struct {
 		desc  string
@@ -274,6 +281,27 @@ func TestScan(t *testing.T) {
 				[][]byte{[]byte("bye")},
 			},
 		},
+		{
+			desc: "unnested arrays",
+			input: hb(`
+				0000000000000000000000000000000000000000000000000000000000000040
+				00000000000000000000000000000000000000000000000000000000000000a0
+				0000000000000000000000000000000000000000000000000000000000000002
+				0000000000000000000000000000000000000000000000000000000000000001
+				0000000000000000000000000000000000000000000000000000000000000002
+				0000000000000000000000000000000000000000000000000000000000000002
+				000000000000000000000000000000000000000000000000000000000000000a
+				0000000000000000000000000000000000000000000000000000000000000014
+			`),
+			at: tuple(
+				unnest(array(sel(0, static()))),
+				unnest(array(sel(1, static()))),
+			),
+			want: [][][]byte{
+				[][]byte{n2b(1), n2b(10)},
+				[][]byte{n2b(2), n2b(20)},
+			},
+		},
 	}
 	for _, tc := range cases {
 		res := NewResult(tc.at)
@@ -318,6 +346,57 @@ func TestABIType(t *testing.T) {
 	}
 }
 
+func TestEncode(t *testing.T) {
+	cases := []struct {
+		enc  string
+		d    any
+		want any
+	}{
+		{"numeric", uint256.NewInt(42), decimal("42")},
+		{"decimal", uint256.NewInt(42), "42"},
+		{"hex", uint256.NewInt(42), "0x2a"},
+		{"bytea", uint256.NewInt(42), n2b(42)},
+		{"decimal", []byte{0x01, 0x00}, "256"},
+		{"hex", []byte{0x01, 0x00}, "0x0100"},
+		{"decimal", dbtype("int256", hb("ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffd6")), "-42"},
+		{"hex", eth.Uint64(255), "0xff"},
+		{"checksum", hb("5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"), "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"},
+		{"hex", true, true},
+		{"hex", nil, nil},
+	}
+	for _, tc := range cases {
+		diff.Test(t, t.Errorf, encode(tc.enc, tc.d), tc.want)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	cases := []struct {
+		specs []string
+		d     any
+		want  any
+	}{
+		{[]string{"hex"}, []byte{0xAB}, "0xab"},
+		{[]string{"hex"}, uint256.NewInt(255), "0xff"},
+		{[]string{"utf8"}, append([]byte("USDC"), make([]byte, 28)...), "USDC"},
+		{[]string{"lpad(4)"}, []byte{0x01}, []byte{0, 0, 0, 0x01}},
+		{[]string{"slice(1,3)"}, []byte{1, 2, 3, 4}, []byte{2, 3}},
+		{[]string{"slice(2)", "hex"}, []byte{1, 2, 3, 4}, "0x0304"},
+		{[]string{"slice(9)"}, []byte{1, 2}, []byte{}},
+		{[]string{"timestamp"}, uint256.NewInt(1700000000), time.Unix(1700000000, 0).UTC()},
+	}
+	for _, tc := range cases {
+		ts, err := parseTransforms(tc.specs)
+		diff.Test(t, t.Fatalf, err, nil)
+		def := coldef{transforms: ts}
+		diff.Test(t, t.Errorf, def.transform(tc.d), tc.want)
+	}
+
+	_, err := parseTransforms([]string{"lpad"})
+	diff.Test(t, t.Errorf, err.Error(), `transform "lpad": wrong number of arguments`)
+	_, err = parseTransforms([]string{"upper"})
+	diff.Test(t, t.Errorf, err.Error(), `transform "upper": unknown transform. must be one of: hex, utf8, lpad, slice, timestamp`)
+}
+
 func TestDBType(t *testing.T) {
 	cases := []struct {
 		abitype  string
@@ -396,6 +475,358 @@ func TestSelected(t *testing.T) {
 	diff.Test(t, t.Errorf, want, event.Selected())
 }
 
+func TestExpand(t *testing.T) {
+	event := Event{
+		Name: "OrderFulfilled",
+		Inputs: []Input{
+			Input{Name: "hash", Type: "bytes32", Column: "hash"},
+			Input{
+				Name: "order",
+				Type: "tuple",
+				Components: []Input{
+					Input{Name: "maker", Type: "address"},
+					Input{Name: "amount", Type: "uint256", Column: "amt"},
+					Input{
+						Name: "offer",
+						Type: "tuple",
+						Components: []Input{
+							Input{Name: "token", Type: "address"},
+						},
+					},
+				},
+			},
+		},
+		Select: []string{"order.maker", "order.offer", "order.amount"},
+	}
+	got, err := event.Expand()
+	diff.Test(t, t.Fatalf, nil, err)
+	var cols []string
+	for _, inp := range got.Selected() {
+		cols = append(cols, inp.Column)
+	}
+	diff.Test(t, t.Errorf, cols, []string{"hash", "order_maker", "amt", "order_offer_token"})
+	diff.Test(t, t.Errorf, event.Inputs[1].Components[0].Column, "")
+
+	for _, tc := range []struct {
+		path string
+		want string
+	}{
+		{"order.taker", "event OrderFulfilled: select order.taker: no input named order.taker"},
+		{"hash.x", "event OrderFulfilled: select hash.x: hash is not a tuple"},
+		{"foo", "event OrderFulfilled: select foo: no input named foo"},
+	} {
+		event.Select = []string{tc.path}
+		_, err := event.Expand()
+		diff.Test(t, t.Errorf, err.Error(), tc.want)
+	}
+}
+
+func TestFunction(t *testing.T) {
+	fn := Function{
+		Name: "transfer",
+		Type: "function",
+		Inputs: []Input{
+			{Name: "to", Type: "address", Column: "to"},
+			{Name: "amount", Type: "uint256", Column: "amount"},
+		},
+	}
+	diff.Test(t, t.Errorf, fn.Signature(), "transfer(address,uint256)")
+	diff.Test(t, t.Errorf, hex.EncodeToString(fn.Selector()), "a9059cbb")
+
+	ig, err := New("foo", Event{}, fn, []BlockData{{Name: "block_num", Column: "block_num"}}, wpg.Table{
+		Name: "foo",
+		Columns: []wpg.Column{
+			{Name: "to", Type: "bytea"},
+			{Name: "amount", Type: "numeric"},
+			{Name: "block_num", Type: "numeric"},
+		},
+	}, Notification{}, "")
+	tc.NoErr(t, err)
+	diff.Test(t, t.Errorf, ig.Filter().UseBlocks, true)
+
+	var (
+		to     = strings.Repeat("ab", 20)
+		amount = "00000000000000000000000000000000000000000000000000000000000000ff"
+		block  = eth.Block{Header: eth.Header{Number: 42}}
+		lwc    = &logWithCtx{ctx: context.Background(), b: &block}
+	)
+	for _, c := range []struct {
+		input string
+		want  int
+	}{
+		{"a9059cbb" + strings.Repeat("00", 12) + to + amount, 1},
+		{"095ea7b3" + strings.Repeat("00", 12) + to + amount, 0},
+		{"", 0},
+	} {
+		lwc.t = &eth.Tx{Data: eth.Bytes(eth.DecodeHex(c.input))}
+		rows, err := ig.processCall(nil, lwc, new(sync.Mutex), nil)
+		tc.NoErr(t, err)
+		diff.Test(t, t.Fatalf, len(rows), c.want)
+		if c.want == 0 {
+			continue
+		}
+		diff.Test(t, t.Errorf, rows[0][0], eth.DecodeHex(to))
+		diff.Test(t, t.Errorf, rows[0][1].(*uint256.Int).Uint64(), uint64(255))
+		diff.Test(t, t.Errorf, rows[0][2], uint64(42))
+	}
+
+	for _, input := range []string{
+		"a9059cbb" + strings.Repeat("00", 12) + to, // truncated
+		"a9059cbbdeadbeef",
+	} {
+		lwc.t = &eth.Tx{Data: eth.Bytes(eth.DecodeHex(input))}
+		for oe, ndl := range map[OnError]int{"": 0, OnErrorSkip: 1, OnErrorDeadLetter: 1} {
+			ig.OnError = oe
+			lwc.dls = nil
+			rows, err := ig.processCall(nil, lwc, new(sync.Mutex), nil)
+			diff.Test(t, t.Errorf, err, nil)
+			diff.Test(t, t.Errorf, len(rows), 0)
+			diff.Test(t, t.Errorf, len(lwc.dls), ndl)
+		}
+		ig.OnError = OnErrorFail
+		_, err := ig.processCall(nil, lwc, new(sync.Mutex), nil)
+		diff.Test(t, t.Errorf, err != nil, true)
+	}
+}
+
+func TestMulti(t *testing.T) {
+	var m Multi
+	for _, name := range []string{"Deposit", "Withdraw"} {
+		ev := Event{
+			Name: name,
+			Inputs: []Input{
+				{Name: "amount", Type: "uint256", Column: "amount"},
+			},
+		}
+		bd := []BlockData{{Name: "event_name", Column: "event_name"}}
+		ig, err := New("foo", ev, Function{}, bd, wpg.Table{Name: "foo"}, Notification{}, "")
+		tc.NoErr(t, err)
+		m = append(m, ig)
+	}
+	f := m.Filter()
+	diff.Test(t, t.Errorf, f.Topics(), [][]string{{
+		eth.EncodeHex(eth.Keccak([]byte("Deposit(uint256)"))),
+		eth.EncodeHex(eth.Keccak([]byte("Withdraw(uint256)"))),
+	}})
+
+	var (
+		block = eth.Block{Header: eth.Header{Number: 1}}
+		lwc   = &logWithCtx{ctx: context.Background(), event: "Withdraw", b: &block}
+	)
+	lwc.t = &eth.Tx{}
+	lwc.l = &eth.Log{
+		Topics: []eth.Bytes{eth.Keccak([]byte("Withdraw(uint256)"))},
+		Data:   n2b(42),
+	}
+	rows, err := m[0].processLog(nil, lwc, new(sync.Mutex), nil)
+	tc.NoErr(t, err)
+	diff.Test(t, t.Errorf, len(rows), 0)
+	rows, err = m[1].processLog(nil, lwc, new(sync.Mutex), nil)
+	tc.NoErr(t, err)
+	diff.Test(t, t.Fatalf, len(rows), 1)
+	diff.Test(t, t.Errorf, rows[0][1], "Withdraw")
+}
+
+func TestAnonymous(t *testing.T) {
+	ev := Event{
+		Anon: true,
+		Name: "Note",
+		Inputs: []Input{
+			{Indexed: true, Name: "sig", Type: "bytes4", Column: "sig"},
+			{Name: "amount", Type: "uint256", Column: "amount"},
+		},
+	}
+	ig, err := New("foo", ev, Function{}, nil, wpg.Table{Name: "foo"}, Notification{}, "")
+	tc.NoErr(t, err)
+	f := ig.Filter()
+	diff.Test(t, t.Errorf, len(f.Topics()), 0)
+
+	var (
+		sig   = hb("a9059cbb00000000000000000000000000000000000000000000000000000000")
+		block = eth.Block{Header: eth.Header{Number: 1}}
+		lwc   = &logWithCtx{ctx: context.Background(), b: &block, t: &eth.Tx{}}
+	)
+	cases := []struct {
+		desc   string
+		topics []eth.Bytes
+		data   []byte
+		want   int
+	}{
+		{"match", []eth.Bytes{sig}, n2b(42), 1},
+		{"extra topic", []eth.Bytes{sig, sig}, n2b(42), 0},
+		{"extra data", []eth.Bytes{sig}, append(n2b(42), n2b(42)...), 0},
+	}
+	for _, c := range cases {
+		lwc.l = &eth.Log{Topics: c.topics, Data: c.data}
+		rows, err := ig.processLog(nil, lwc, new(sync.Mutex), nil)
+		tc.NoErr(t, err)
+		if len(rows) != c.want {
+			t.Errorf("%s: got %d rows want %d", c.desc, len(rows), c.want)
+		}
+	}
+
+	ev.Topic0 = eth.EncodeHex(sig)
+	ig, err = New("foo", ev, Function{}, nil, wpg.Table{Name: "foo"}, Notification{}, "")
+	tc.NoErr(t, err)
+	f = ig.Filter()
+	diff.Test(t, t.Errorf, f.Topics(), [][]string{{eth.EncodeHex(sig)}})
+	lwc.l = &eth.Log{Topics: []eth.Bytes{n2b(1)}, Data: n2b(42)}
+	rows, err := ig.processLog(nil, lwc, new(sync.Mutex), nil)
+	tc.NoErr(t, err)
+	diff.Test(t, t.Errorf, len(rows), 0)
+}
+
+func TestDeadLetter(t *testing.T) {
+	ev := Event{
+		Name: "Memo",
+		Inputs: []Input{
+			{Name: "memo", Type: "string", Column: "memo"},
+		},
+	}
+	ig, err := New("foo", ev, Function{}, nil, wpg.Table{Name: "foo"}, Notification{}, "")
+	tc.NoErr(t, err)
+
+	var (
+		block = eth.Block{Header: eth.Header{Number: 1}}
+		lwc   = &logWithCtx{ctx: context.Background(), b: &block, t: &eth.Tx{}}
+	)
+	lwc.l = &eth.Log{
+		Idx:    7,
+		Topics: []eth.Bytes{ev.SignatureHash()},
+		Data:   n2b(1 << 40),
+	}
+	_, err = ig.processLog(nil, lwc, new(sync.Mutex), nil)
+	if err == nil {
+		t.Fatal("expected scan error")
+	}
+
+	ig.OnError = OnErrorDeadLetter
+	rows, err := ig.processLog(nil, lwc, new(sync.Mutex), nil)
+	tc.NoErr(t, err)
+	diff.Test(t, t.Errorf, len(rows), 0)
+	diff.Test(t, t.Fatalf, len(lwc.dls), 1)
+	diff.Test(t, t.Errorf, lwc.dls[0].blockNum, uint64(1))
+	diff.Test(t, t.Errorf, lwc.dls[0].logIdx, any(uint64(7)))
+	diff.Test(t, t.Errorf, lwc.dls[0].data, n2b(1<<40))
+
+	ig.OnError = OnErrorSkip
+	_, err = ig.processLog(nil, lwc, new(sync.Mutex), nil)
+	tc.NoErr(t, err)
+	diff.Test(t, t.Errorf, len(lwc.dls), 2)
+}
+
+func TestWildcard(t *testing.T) {
+	bd := []BlockData{
+		{Name: "log_addr", Column: "log_addr", Filter: Filter{Op: "contains", Arg: []string{"0x01"}}},
+		{Name: "log_event", Column: "log_event"},
+		{Name: "log_decoded", Column: "log_decoded"},
+	}
+	ig, err := New("foo", Event{}, Function{}, bd, wpg.Table{Name: "foo"}, Notification{}, "")
+	tc.NoErr(t, err)
+	ig.SetABI([]Event{
+		{
+			Name: "Transfer",
+			Inputs: []Input{
+				{Indexed: true, Name: "from", Type: "address"},
+				{Name: "ids", Type: "uint256[]"},
+				{Name: "memo", Type: "string"},
+			},
+		},
+	})
+	diff.Test(t, t.Errorf, ig.indexing, indexAllLogs)
+	f := ig.Filter()
+	diff.Test(t, t.Errorf, len(f.Topics()), 0)
+	diff.Test(t, t.Errorf, f.Addresses(), []string{"0x01"})
+
+	l := &eth.Log{
+		Topics: []eth.Bytes{
+			eth.Keccak([]byte("Transfer(address,uint256[],string)")),
+			hb("000000000000000000000000000000000000000000000000000000000000000a"),
+		},
+		Data: hb(`
+			0000000000000000000000000000000000000000000000000000000000000040
+			00000000000000000000000000000000000000000000000000000000000000a0
+			0000000000000000000000000000000000000000000000000000000000000002
+			0000000000000000000000000000000000000000000000000000000000000001
+			0000000000000000000000000000000000000000000000000000000000000002
+			0000000000000000000000000000000000000000000000000000000000000003
+			666f6f0000000000000000000000000000000000000000000000000000000000
+		`),
+	}
+	dl := ig.decode(l)
+	diff.Test(t, t.Fatalf, dl != nil, true)
+	diff.Test(t, t.Errorf, dl.event, "Transfer")
+	diff.Test(t, t.Errorf, dl.json, `{"from":"0x000000000000000000000000000000000000000a","ids":["1","2"],"memo":"foo"}`)
+
+	l.Data = l.Data[:32]
+	diff.Test(t, t.Errorf, ig.decode(l) == nil, true)
+}
+
+func TestInsertRows(t *testing.T) {
+	var (
+		ctx = context.Background()
+		pg  = testpg(t)
+	)
+	_, err := pg.Exec(ctx, `create table x (a numeric, b bytea, unique (a))`)
+	tc.NoErr(t, err)
+	ig := Integration{
+		Table:   wpg.Table{Name: "x"},
+		Columns: []string{"a", "b"},
+	}
+	rows := [][]any{
+		{uint256.NewInt(1), []byte{0x01}},
+		{uint256.NewInt(2), []byte{0x02}},
+	}
+	nr, ins, err := ig.insertRows(ctx, pg, rows)
+	tc.NoErr(t, err)
+	diff.Test(t, t.Errorf, nr, int64(2))
+	diff.Test(t, t.Errorf, len(ins), 2)
+
+	nr, _, err = ig.insertRows(ctx, pg, append(rows, []any{uint256.NewInt(3), nil}))
+	tc.NoErr(t, err)
+	diff.Test(t, t.Errorf, nr, int64(1))
+}
+
+func TestInsertRows_Returning(t *testing.T) {
+	var (
+		ctx = context.Background()
+		pg  = testpg(t)
+	)
+	_, err := pg.Exec(ctx, `create table x (a numeric, b bytea, c text, unique (a, c))`)
+	tc.NoErr(t, err)
+	ig := Integration{
+		Table: wpg.Table{
+			Name:   "x",
+			Unique: [][]string{{"a", "c"}},
+		},
+		Columns: []string{"a", "b", "c"},
+		coldefs: []coldef{
+			{Column: wpg.Column{Name: "a", Type: "numeric"}},
+			{Column: wpg.Column{Name: "b", Type: "bytea"}},
+			{Column: wpg.Column{Name: "c", Type: "text"}},
+		},
+	}
+	rows := [][]any{
+		{uint256.NewInt(1), []byte{0x01}, "foo"},
+		{uint256.NewInt(2), []byte{0x02}, nil},
+	}
+	nr, ins, err := ig.insertRows(ctx, pg, rows)
+	tc.NoErr(t, err)
+	diff.Test(t, t.Errorf, nr, int64(2))
+	diff.Test(t, t.Errorf, ins, rows)
+
+	// a null in the key never conflicts so the second row
+	// is inserted again
+	rows = append(rows,
+		[]any{uint256.NewInt(3), []byte{0x03}, "bar"},
+		[]any{uint256.NewInt(3), []byte{0x04}, "bar"},
+	)
+	nr, ins, err = ig.insertRows(ctx, pg, rows)
+	tc.NoErr(t, err)
+	diff.Test(t, t.Errorf, nr, int64(2))
+	diff.Test(t, t.Errorf, ins, [][]any{rows[1], rows[2]})
+}
+
 func TestNumIndexed(t *testing.T) {
 	event := Event{
 		Name: "",
@@ -435,6 +866,56 @@ func TestFilter(t *testing.T) {
 			dec2uint256("340282366920938463463374607431768211456"),
 			true,
 		},
+		{
+			Filter{Op: "gte", Arg: []string{"2"}},
+			eth.Uint64(2),
+			true,
+		},
+		{
+			Filter{Op: "lte", Arg: []string{"1"}},
+			eth.Uint64(2),
+			false,
+		},
+		{
+			Filter{Op: "range", Arg: []string{"1", "3"}},
+			eth.Uint64(3),
+			true,
+		},
+		{
+			Filter{Op: "range", Arg: []string{"1", "3"}},
+			eth.Uint64(4),
+			false,
+		},
+		{
+			Filter{Op: "gt", Arg: []string{"1e18"}},
+			dec2uint256("1000000000000000001"),
+			true,
+		},
+		{
+			Filter{Op: "lt", Arg: []string{"1e18"}},
+			dec2uint256("1000000000000000001"),
+			false,
+		},
+		{
+			Filter{Op: "range", Arg: []string{"1e18", "2e18"}},
+			dec2uint256("1500000000000000000"),
+			true,
+		},
+		{
+			Filter{Op: "lt", Arg: []string{"0"}},
+			dbtype("int256", eth.DecodeHex("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")),
+			true,
+		},
+		{
+			Filter{Op: "range", Arg: []string{"-10", "-2"}},
+			dbtype("int256", eth.DecodeHex("0xfffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffd")),
+			true,
+		},
+		{
+			Filter{Op: "gte", Arg: []string{"-2"}},
+			dbtype("int256", eth.DecodeHex("0xfffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffd")),
+			false,
+		},
 		{
 			Filter{Op: "eq", Arg: []string{"foo"}},
 			"foo",
@@ -490,3 +971,121 @@ func TestFilterResults(t *testing.T) {
 		tc.WantGot(t, c.want, frs.accept())
 	}
 }
+
+func TestPublishPayloads(t *testing.T) {
+	ig := Integration{
+		Table: wpg.Table{
+			Schema: "erc20",
+			Name:   "transfers",
+			Unique: [][]string{{"ig_name", "tx_hash", "log_idx"}},
+		},
+		coldefs: []coldef{
+			{Column: wpg.Column{Name: "ig_name"}},
+			{Column: wpg.Column{Name: "block_num"}},
+			{Column: wpg.Column{Name: "tx_hash"}},
+			{Column: wpg.Column{Name: "log_idx"}},
+			{Column: wpg.Column{Name: "value"}},
+		},
+	}
+	got, err := ig.publishPayloads([][]any{
+		{"foo", uint64(42), []byte{0xab}, uint64(1), uint256.NewInt(5)},
+	})
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, got, []string{`{"ig_name":"foo","tx_hash":"0xab","log_idx":1,"block_num":42}`})
+	diff.Test(t, t.Errorf, ig.PublishChannel(), "shovel_erc20_transfers")
+}
+
+func TestArena(t *testing.T) {
+	a := &arena{}
+	r1 := a.row(2)
+	r2 := a.row(2)
+	r1 = append(r1, "x")
+	r2[0] = "y"
+	diff.Test(t, t.Errorf, r1, []any{nil, nil, "x"})
+	diff.Test(t, t.Errorf, r2, []any{"y", nil})
+
+	x := a.uint(n2b(1))
+	y := a.uint(n2b(2))
+	diff.Test(t, t.Errorf, x.Uint64(), uint64(1))
+	diff.Test(t, t.Errorf, y.Uint64(), uint64(2))
+
+	// larger than a slab
+	diff.Test(t, t.Errorf, len(a.row(slabSize+1)), slabSize+1)
+	for i := 0; i < slabSize+1; i++ {
+		a.int(n2b(uint64(i)))
+	}
+	diff.Test(t, t.Errorf, x.Uint64(), uint64(1))
+}
+
+func transferIG(tb testing.TB) Integration {
+	ev := Event{
+		Name: "Transfer",
+		Inputs: []Input{
+			{Indexed: true, Name: "from", Type: "address", Column: "f"},
+			{Indexed: true, Name: "to", Type: "address", Column: "t"},
+			{Name: "value", Type: "uint256", Column: "v"},
+		},
+	}
+	bd := []BlockData{
+		{Name: "block_num", Column: "block_num"},
+		{Name: "tx_hash", Column: "tx_hash"},
+	}
+	table := wpg.Table{
+		Name: "transfers",
+		Columns: []wpg.Column{
+			{Name: "f", Type: "bytea"},
+			{Name: "t", Type: "bytea"},
+			{Name: "v", Type: "numeric", Encoding: "numeric"},
+			{Name: "block_num", Type: "numeric"},
+			{Name: "tx_hash", Type: "bytea"},
+		},
+	}
+	ig, err := New("transfers", ev, Function{}, bd, table, Notification{}, "")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return ig
+}
+
+func BenchmarkProcessLog(b *testing.B) {
+	var (
+		ig    = transferIG(b)
+		block = eth.Block{Header: eth.Header{Number: 1}}
+		lwc   = &logWithCtx{ctx: context.Background(), b: &block, t: &eth.Tx{}}
+		pgmut = new(sync.Mutex)
+		rows  = make([][]any, 0, 1000)
+		err   error
+	)
+	lwc.l = &eth.Log{
+		Topics: []eth.Bytes{
+			eth.Keccak([]byte("Transfer(address,address,uint256)")),
+			hb("000000000000000000000000a0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"),
+			hb("000000000000000000000000dac17f958d2ee523a2206206994597c13d831ec7"),
+		},
+		Data: n2b(1e18),
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if i%1000 == 0 {
+			rows = rows[:0]
+			lwc.arena = &arena{}
+		}
+		rows, err = ig.processLog(rows, lwc, pgmut, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	var (
+		x    = uint256.NewInt(1e18)
+		addr = hb("a0b86991c6218b36c1d19d4a2e9eb0ce3606eb48")
+	)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encode("numeric", x)
+		encode("hex", addr)
+		encode("checksum", addr)
+	}
+}
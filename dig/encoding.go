@@ -0,0 +1,125 @@
+package dig
+
+import (
+	"database/sql/driver"
+	"math/big"
+
+	"github.com/indexsupply/shovel/eth"
+
+	"github.com/holiman/uint256"
+)
+
+// Applies each input's transforms and then converts
+// integers and bytes according to their column's encoding
+// (see [wpg.Column]). Other values are unchanged.
+func (ig Integration) encode(row []any) []any {
+	for j := range ig.coldefs {
+		row[j] = ig.coldefs[j].transform(row[j])
+		if enc := ig.coldefs[j].Column.Encoding; len(enc) > 0 {
+			row[j] = encode(enc, row[j])
+		}
+	}
+	return row
+}
+
+// Decimal string written to a numeric column
+type decimal string
+
+func (d decimal) Value() (driver.Value, error) { return string(d), nil }
+
+var two256 = new(big.Int).Lsh(big.NewInt(1), 256)
+
+func encode(enc string, d any) any {
+	// Common cases that don't need a big.Int
+	switch v := d.(type) {
+	case *uint256.Int:
+		switch enc {
+		case "numeric":
+			return decimal(v.Dec())
+		case "decimal":
+			return v.Dec()
+		case "hex":
+			return v.Hex()
+		case "bytea":
+			b := v.Bytes32()
+			return b[:]
+		}
+	case []byte:
+		switch {
+		case enc == "hex", enc == "checksum" && len(v) != 20:
+			return eth.EncodeHex(v)
+		case enc == "bytea":
+			return v
+		}
+	}
+	n, b, ok := number(d)
+	if !ok {
+		return d
+	}
+	switch enc {
+	case "numeric":
+		return decimal(n.String())
+	case "decimal":
+		return n.String()
+	case "hex":
+		switch {
+		case b != nil:
+			return eth.EncodeHex(b)
+		case n.Sign() < 0:
+			return "-0x" + new(big.Int).Neg(n).Text(16)
+		default:
+			return "0x" + n.Text(16)
+		}
+	case "checksum":
+		switch {
+		case b == nil:
+			return d
+		case len(b) == 20:
+			return eth.EncodeAddress(b)
+		default:
+			return eth.EncodeHex(b)
+		}
+	case "bytea":
+		if b != nil {
+			return b
+		}
+		// 32 byte word, two's complement when negative
+		if n.Sign() < 0 {
+			n = new(big.Int).Add(n, two256)
+		}
+		return n.FillBytes(make([]byte, 32))
+	default:
+		return d
+	}
+}
+
+// Returns d as an integer and, when d is a byte
+// string, its bytes.
+func number(d any) (*big.Int, []byte, bool) {
+	switch v := d.(type) {
+	case []byte:
+		return new(big.Int).SetBytes(v), v, true
+	case *uint256.Int:
+		return v.ToBig(), nil, true
+	case *negInt:
+		n := v.i.ToBig()
+		if v.i.Sign() < 0 {
+			n.Sub(n, two256)
+		}
+		return n, nil, true
+	case eth.Uint64:
+		return new(big.Int).SetUint64(uint64(v)), nil, true
+	case eth.Byte:
+		return big.NewInt(int64(v)), nil, true
+	case uint64:
+		return new(big.Int).SetUint64(v), nil, true
+	case uint8:
+		return big.NewInt(int64(v)), nil, true
+	case int:
+		return big.NewInt(int64(v)), nil, true
+	case int64:
+		return big.NewInt(v), nil, true
+	default:
+		return nil, nil, false
+	}
+}
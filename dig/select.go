@@ -0,0 +1,92 @@
+package dig
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Sets the column of each input named by a dotted path in
+// e.Select. The column is the path with dots replaced by
+// underscores (eg order.maker is stored in order_maker).
+// When a path names a tuple every member is selected.
+// Inputs that already have a column are left as is.
+//
+// The returned event's inputs don't share memory with e.
+func (e Event) Expand() (Event, error) {
+	inputs, err := selectPaths(e.Inputs, e.Select)
+	if err != nil {
+		return e, fmt.Errorf("event %s: %w", e.Name, err)
+	}
+	e.Inputs = inputs
+	return e, nil
+}
+
+// See [Event.Expand]
+func (f Function) Expand() (Function, error) {
+	inputs, err := selectPaths(f.Inputs, f.Select)
+	if err != nil {
+		return f, fmt.Errorf("function %s: %w", f.Name, err)
+	}
+	f.Inputs = inputs
+	return f, nil
+}
+
+func selectPaths(inputs []Input, paths []string) ([]Input, error) {
+	if len(paths) == 0 {
+		return inputs, nil
+	}
+	res := copyInputs(inputs)
+	for _, p := range paths {
+		if err := selectPath(res, strings.Split(p, "."), nil); err != nil {
+			return nil, fmt.Errorf("select %s: %w", p, err)
+		}
+	}
+	return res, nil
+}
+
+func copyInputs(inputs []Input) []Input {
+	if inputs == nil {
+		return nil
+	}
+	res := make([]Input, len(inputs))
+	for i := range inputs {
+		res[i] = inputs[i]
+		res[i].Components = copyInputs(inputs[i].Components)
+	}
+	return res
+}
+
+func selectPath(inputs []Input, path, prefix []string) error {
+	name := append(slices.Clone(prefix), path[0])
+	for i := range inputs {
+		if inputs[i].Name != path[0] {
+			continue
+		}
+		switch {
+		case len(path) == 1:
+			selectAll(&inputs[i], name)
+			return nil
+		case len(inputs[i].Components) == 0:
+			return fmt.Errorf("%s is not a tuple", strings.Join(name, "."))
+		case inputs[i].Indexed:
+			// indexed tuples are stored as a hash in the topics
+			return fmt.Errorf("%s is an indexed tuple", strings.Join(name, "."))
+		default:
+			return selectPath(inputs[i].Components, path[1:], name)
+		}
+	}
+	return fmt.Errorf("no input named %s", strings.Join(name, "."))
+}
+
+func selectAll(inp *Input, name []string) {
+	if len(inp.Components) == 0 || inp.Indexed {
+		if len(inp.Column) == 0 {
+			inp.Column = strings.Join(name, "_")
+		}
+		return
+	}
+	for i := range inp.Components {
+		selectAll(&inp.Components[i], append(slices.Clone(name), inp.Components[i].Name))
+	}
+}
@@ -0,0 +1,166 @@
+package dig
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/indexsupply/shovel/eth"
+
+	"github.com/holiman/uint256"
+)
+
+// Converts a decoded input value before it's written.
+// Transforms run after filters so filters always
+// see the decoded value.
+type transform func(any) any
+
+// Checks that the input's transforms are valid.
+// See [parseTransforms].
+func (inp Input) ValidateTransform() error {
+	_, err := parseTransforms(inp.Transform)
+	return err
+}
+
+// Parses an input's transforms. Each is a name optionally
+// followed by integer arguments in parentheses:
+//
+//	hex         bytes or integer as 0x prefixed lowercase text
+//	utf8        bytes as text with trailing zero bytes removed
+//	lpad(n)     bytes left padded with zeros to n bytes
+//	slice(i,j)  bytes[i:j] (j may be omitted)
+//	timestamp   integer (unix seconds) as a timestamp
+func parseTransforms(specs []string) ([]transform, error) {
+	var res []transform
+	for _, spec := range specs {
+		name, args, err := parseSpec(spec)
+		if err != nil {
+			return nil, fmt.Errorf("transform %q: %w", spec, err)
+		}
+		nargs := map[string][2]int{
+			"hex":       {0, 0},
+			"utf8":      {0, 0},
+			"lpad":      {1, 1},
+			"slice":     {1, 2},
+			"timestamp": {0, 0},
+		}
+		n, ok := nargs[name]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("transform %q: unknown transform. must be one of: hex, utf8, lpad, slice, timestamp", spec)
+		case len(args) < n[0] || len(args) > n[1]:
+			return nil, fmt.Errorf("transform %q: wrong number of arguments", spec)
+		}
+		switch name {
+		case "hex":
+			res = append(res, hexT)
+		case "utf8":
+			res = append(res, utf8T)
+		case "lpad":
+			res = append(res, lpadT(args[0]))
+		case "slice":
+			j := -1
+			if len(args) == 2 {
+				j = args[1]
+			}
+			res = append(res, sliceT(args[0], j))
+		case "timestamp":
+			res = append(res, timestampT)
+		}
+	}
+	return res, nil
+}
+
+func parseSpec(s string) (string, []int, error) {
+	name, rest, ok := strings.Cut(strings.TrimSpace(s), "(")
+	if !ok {
+		return name, nil, nil
+	}
+	rest, ok = strings.CutSuffix(rest, ")")
+	if !ok {
+		return "", nil, fmt.Errorf("missing )")
+	}
+	var args []int
+	for _, a := range strings.Split(rest, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(a))
+		if err != nil || n < 0 {
+			return "", nil, fmt.Errorf("invalid argument %q", a)
+		}
+		args = append(args, n)
+	}
+	return name, args, nil
+}
+
+func hexT(d any) any {
+	switch v := d.(type) {
+	case []byte:
+		return eth.EncodeHex(v)
+	case *uint256.Int:
+		return v.Hex()
+	default:
+		return d
+	}
+}
+
+// Postgres text can't contain NUL bytes
+func utf8T(d any) any {
+	b, ok := d.([]byte)
+	if !ok {
+		return d
+	}
+	b = bytes.TrimRight(b, "\x00")
+	if !utf8.Valid(b) || bytes.IndexByte(b, 0) >= 0 {
+		return strings.ToValidUTF8(strings.ReplaceAll(string(b), "\x00", ""), "")
+	}
+	return string(b)
+}
+
+func lpadT(n int) transform {
+	return func(d any) any {
+		b, ok := d.([]byte)
+		if !ok || len(b) >= n {
+			return d
+		}
+		return append(make([]byte, n-len(b)), b...)
+	}
+}
+
+// j < 0 slices to the end. Indexes are clamped
+// to the length of the bytes.
+func sliceT(i, j int) transform {
+	return func(d any) any {
+		b, ok := d.([]byte)
+		if !ok {
+			return d
+		}
+		end := j
+		if end < 0 || end > len(b) {
+			end = len(b)
+		}
+		return b[min(i, end):end]
+	}
+}
+
+func timestampT(d any) any {
+	var secs uint64
+	switch v := d.(type) {
+	case *uint256.Int:
+		if !v.IsUint64() {
+			return nil
+		}
+		secs = v.Uint64()
+	case eth.Uint64:
+		secs = uint64(v)
+	case uint64:
+		secs = v
+	default:
+		return d
+	}
+	if secs > 1<<62 {
+		return nil
+	}
+	return time.Unix(int64(secs), 0).UTC()
+}
@@ -0,0 +1,164 @@
+package dig
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+
+	"github.com/indexsupply/shovel/eth"
+)
+
+// An integration without an event or function that selects
+// log data (eg log_addr, log_topics, log_data) captures
+// every log from its log_addr filter's addresses.
+//
+// Logs matching one of the events set by [Integration.SetABI]
+// are decoded into log_event and log_decoded. Decoding is
+// best-effort: logs that don't match, or that fail to
+// decode, have null log_event and log_decoded values.
+func (ig *Integration) SetABI(events []Event) {
+	ig.decoders = nil
+	for _, ev := range events {
+		if ev.Anon {
+			continue
+		}
+		ig.decoders = append(ig.decoders, newDecoder(ev))
+	}
+}
+
+type decoder struct {
+	ev      Event
+	sighash []byte
+	nidx    int
+	leaves  []leaf
+	res     *Result
+}
+
+// A non-indexed input without components. Leaves are
+// ordered like the columns of the decoder's Result.
+type leaf struct {
+	path  string
+	typ   string // element type for arrays
+	multi bool   // the input is, or is within, an array
+}
+
+func newDecoder(ev Event) *decoder {
+	ev.Inputs = copyInputs(ev.Inputs)
+	d := &decoder{
+		ev:      ev,
+		sighash: ev.SignatureHash(),
+		nidx:    ev.numIndexed(),
+	}
+	var walk func(inputs []Input, prefix string, multi bool)
+	walk = func(inputs []Input, prefix string, multi bool) {
+		for i := range inputs {
+			var (
+				path  = prefix + inputs[i].Name
+				typ   = inputs[i].Type
+				multi = multi || strings.HasSuffix(typ, "]")
+			)
+			if k := strings.IndexByte(typ, '['); k >= 0 {
+				typ = typ[:k]
+			}
+			inputs[i].Column = ""
+			if len(inputs[i].Components) > 0 {
+				walk(inputs[i].Components, path+".", multi)
+				continue
+			}
+			inputs[i].Column = path
+			d.leaves = append(d.leaves, leaf{path, typ, multi})
+		}
+	}
+	for i := range ev.Inputs {
+		if ev.Inputs[i].Indexed {
+			continue
+		}
+		walk(ev.Inputs[i:i+1], "", false)
+	}
+	d.res = NewResult(ev.ABIType())
+	return d
+}
+
+type decodedLog struct {
+	event string
+	json  string
+}
+
+func (ig Integration) decode(l *eth.Log) *decodedLog {
+	if len(l.Topics) == 0 {
+		return nil
+	}
+	for _, d := range ig.decoders {
+		if len(l.Topics)-1 != d.nidx || !bytes.Equal(d.sighash, l.Topics[0]) {
+			continue
+		}
+		res, ok := d.decode(l)
+		if !ok {
+			return nil
+		}
+		return res
+	}
+	return nil
+}
+
+func (d *decoder) decode(l *eth.Log) (*decodedLog, bool) {
+	var (
+		vals = map[string]any{}
+		tidx = 1
+	)
+	for _, inp := range d.ev.Inputs {
+		if !inp.Indexed {
+			continue
+		}
+		switch {
+		case len(inp.Components) > 0, strings.HasSuffix(inp.Type, "]"),
+			inp.Type == "string", inp.Type == "bytes":
+			// topic is a hash of the value
+			vals[inp.Name] = eth.EncodeHex(l.Topics[tidx])
+		default:
+			vals[inp.Name] = jsonValue(inp.Type, l.Topics[tidx])
+		}
+		tidx++
+	}
+	if len(d.leaves) > 0 {
+		if err := d.res.Scan(l.Data); err != nil {
+			return nil, false
+		}
+	}
+	for j, lf := range d.leaves {
+		if !lf.multi {
+			vals[lf.path] = jsonValue(lf.typ, d.res.At(0)[j])
+			continue
+		}
+		list := []any{}
+		for i := 0; i < d.res.Len(); i++ {
+			if b := d.res.At(i)[j]; b != nil {
+				list = append(list, jsonValue(lf.typ, b))
+			}
+		}
+		vals[lf.path] = list
+	}
+	b, err := json.Marshal(vals)
+	if err != nil {
+		return nil, false
+	}
+	return &decodedLog{event: d.ev.Name, json: string(b)}, true
+}
+
+// Numbers are decimal strings since they may
+// exceed the precision of JSON numbers.
+func jsonValue(abitype string, b []byte) any {
+	switch v := dbtype(abitype, b).(type) {
+	case []byte:
+		return eth.EncodeHex(v)
+	case driver.Valuer:
+		x, err := v.Value()
+		if err != nil {
+			return nil
+		}
+		return x
+	default:
+		return v
+	}
+}
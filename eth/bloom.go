@@ -0,0 +1,28 @@
+package eth
+
+// Reports whether a block's 2048 bit logs bloom may contain
+// v (an address or a topic). There are false positives but
+// no false negatives. An empty bloom is treated as unknown
+// since some chains don't set it.
+func BloomContains(bloom, v []byte) bool {
+	if len(bloom) != 256 || allZero(bloom) {
+		return true
+	}
+	h := Keccak(v)
+	for i := 0; i < 6; i += 2 {
+		bit := (uint(h[i])<<8 | uint(h[i+1])) & 2047
+		if bloom[255-bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func allZero(b []byte) bool {
+	for i := range b {
+		if b[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -3,6 +3,7 @@ package eth
 import (
 	"encoding/hex"
 	"strconv"
+	"strings"
 )
 
 // deals with eth's 0x prefix and possible odd length
@@ -17,9 +18,18 @@ func DecodeHex(s string) []byte {
 	return h
 }
 
+const hextable = "0123456789abcdef"
+
 // 0x prefixed hex encoded string
 func EncodeHex(b []byte) string {
-	return "0x" + hex.EncodeToString(b)
+	var s strings.Builder
+	s.Grow(2 + 2*len(b))
+	s.WriteString("0x")
+	for _, c := range b {
+		s.WriteByte(hextable[c>>4])
+		s.WriteByte(hextable[c&0x0f])
+	}
+	return s.String()
 }
 
 // deals with eth's 0x prefix and possible odd length
@@ -41,3 +51,25 @@ func DecodeUint64(s string) uint64 {
 func EncodeUint64(n uint64) string {
 	return "0x" + strconv.FormatUint(n, 16)
 }
+
+// EIP-55 mixed case hex encoding of a 20 byte address
+func EncodeAddress(b []byte) string {
+	res := make([]byte, 2+hex.EncodedLen(len(b)))
+	copy(res, "0x")
+	lower := res[2:]
+	hex.Encode(lower, b)
+	hash := Keccak32(lower)
+	for i, c := range lower {
+		if c < 'a' {
+			continue
+		}
+		nibble := hash[i/2] >> 4
+		if i%2 == 1 {
+			nibble = hash[i/2] & 0x0f
+		}
+		if nibble >= 8 {
+			lower[i] = c - 32
+		}
+	}
+	return string(res)
+}
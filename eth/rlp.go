@@ -0,0 +1,239 @@
+package eth
+
+import (
+	"fmt"
+
+	"github.com/indexsupply/shovel/rlp"
+
+	"github.com/holiman/uint256"
+)
+
+// Decodes a tx from its canonical encoding: an RLP list for
+// legacy txs and type || RLP payload for typed txs (as in a
+// block body). The signer is recovered on the first call to
+// [Tx.Signer] except for deposits which include it.
+func (tx *Tx) UnmarshalRLP(b []byte) error {
+	if len(b) == 0 {
+		return rlp.ErrShort
+	}
+	tx.rbuf = b
+	tx.PrecompHash = nil
+	if b[0] >= 0xc0 {
+		tx.Type = 0
+		return tx.decodeFields(b, legacyFields)
+	}
+	tx.Type = Byte(b[0])
+	switch b[0] {
+	case 0x01:
+		return tx.decodeFields(b[1:], accessListFields)
+	case 0x02:
+		return tx.decodeFields(b[1:], dynamicFeeFields)
+	case 0x03:
+		return tx.decodeFields(b[1:], blobFields)
+	case 0x04:
+		return tx.decodeFields(b[1:], setCodeFields)
+	case 0x7e:
+		return tx.decodeFields(b[1:], depositFields)
+	default:
+		return fmt.Errorf("unsupported tx type: %x", b[0])
+	}
+}
+
+type txField func(*Tx, []byte) error
+
+func (tx *Tx) decodeFields(b []byte, fields []txField) error {
+	items, err := rlp.List(b)
+	if err != nil {
+		return fmt.Errorf("decoding tx type %x: %w", byte(tx.Type), err)
+	}
+	if len(items) != len(fields) {
+		const tag = "decoding tx type %x: expected %d fields got %d"
+		return fmt.Errorf(tag, byte(tx.Type), len(fields), len(items))
+	}
+	for i := range fields {
+		if fields[i] == nil {
+			continue
+		}
+		if err := fields[i](tx, items[i]); err != nil {
+			return fmt.Errorf("decoding tx type %x field %d: %w", byte(tx.Type), i, err)
+		}
+	}
+	if tx.Type == 0 && tx.eip155() {
+		tx.ChainID.SetUint64(tx.chainid())
+	}
+	return nil
+}
+
+func rlpBytes(f func(*Tx) *Bytes) txField {
+	return func(tx *Tx, item []byte) error {
+		d, err := rlp.Bytes(item)
+		if err != nil {
+			return err
+		}
+		f(tx).Write(d)
+		return nil
+	}
+}
+
+func rlpUint64(f func(*Tx) *Uint64) txField {
+	return func(tx *Tx, item []byte) error {
+		n, err := rlp.Uint64(item)
+		*f(tx) = Uint64(n)
+		return err
+	}
+}
+
+func rlpUint256(f func(*Tx) *uint256.Int) txField {
+	return func(tx *Tx, item []byte) error {
+		d, err := rlp.Bytes(item)
+		if err != nil {
+			return err
+		}
+		if len(d) > 32 {
+			return fmt.Errorf("uint256 overflow")
+		}
+		f(tx).SetBytes(d)
+		return nil
+	}
+}
+
+func rlpAccessList(tx *Tx, item []byte) error {
+	tuples, err := rlp.List(item)
+	if err != nil {
+		return err
+	}
+	tx.AccessList = make(AccessTuples, len(tuples))
+	for i := range tuples {
+		fields, err := rlp.List(tuples[i])
+		if err != nil || len(fields) != 2 {
+			return fmt.Errorf("access list tuple %d", i)
+		}
+		addr, err := rlp.Bytes(fields[0])
+		if err != nil || len(addr) != 20 {
+			return fmt.Errorf("access list address %d", i)
+		}
+		copy(tx.AccessList[i].Address[:], addr)
+		keys, err := rlp.List(fields[1])
+		if err != nil {
+			return err
+		}
+		tx.AccessList[i].StorageKeys = make([][32]byte, len(keys))
+		for j := range keys {
+			k, err := rlp.Bytes(keys[j])
+			if err != nil || len(k) != 32 {
+				return fmt.Errorf("access list key %d/%d", i, j)
+			}
+			copy(tx.AccessList[i].StorageKeys[j][:], k)
+		}
+	}
+	return nil
+}
+
+func rlpBlobHashes(tx *Tx, item []byte) error {
+	hashes, err := rlp.List(item)
+	if err != nil {
+		return err
+	}
+	tx.BlobVersionedHashes = make([]Bytes, len(hashes))
+	for i := range hashes {
+		h, err := rlp.Bytes(hashes[i])
+		if err != nil {
+			return err
+		}
+		tx.BlobVersionedHashes[i].Write(h)
+	}
+	return nil
+}
+
+func rlpBool(f func(*Tx) *bool) txField {
+	return func(tx *Tx, item []byte) error {
+		n, err := rlp.Uint64(item)
+		*f(tx) = n == 1
+		return err
+	}
+}
+
+var (
+	fChainID  = rlpUint256(func(tx *Tx) *uint256.Int { return &tx.ChainID })
+	fNonce    = rlpUint64(func(tx *Tx) *Uint64 { return &tx.Nonce })
+	fGasPrice = rlpUint256(func(tx *Tx) *uint256.Int { return &tx.GasPrice })
+	fGasLimit = rlpUint64(func(tx *Tx) *Uint64 { return &tx.GasLimit })
+	fTo       = rlpBytes(func(tx *Tx) *Bytes { return &tx.To })
+	fValue    = rlpUint256(func(tx *Tx) *uint256.Int { return &tx.Value })
+	fData     = rlpBytes(func(tx *Tx) *Bytes { return &tx.Data })
+	fV        = rlpUint256(func(tx *Tx) *uint256.Int { return &tx.V })
+	fR        = rlpUint256(func(tx *Tx) *uint256.Int { return &tx.R })
+	fS        = rlpUint256(func(tx *Tx) *uint256.Int { return &tx.S })
+	fTip      = rlpUint256(func(tx *Tx) *uint256.Int { return &tx.MaxPriorityFeePerGas })
+	fFeeCap   = rlpUint256(func(tx *Tx) *uint256.Int { return &tx.MaxFeePerGas })
+	fBlobFee  = rlpUint256(func(tx *Tx) *uint256.Int { return &tx.MaxFeePerBlobGas })
+
+	legacyFields     = []txField{fNonce, fGasPrice, fGasLimit, fTo, fValue, fData, fV, fR, fS}
+	accessListFields = []txField{fChainID, fNonce, fGasPrice, fGasLimit, fTo, fValue, fData, rlpAccessList, fV, fR, fS}
+	dynamicFeeFields = []txField{fChainID, fNonce, fTip, fFeeCap, fGasLimit, fTo, fValue, fData, rlpAccessList, fV, fR, fS}
+	blobFields       = []txField{fChainID, fNonce, fTip, fFeeCap, fGasLimit, fTo, fValue, fData, rlpAccessList, fBlobFee, rlpBlobHashes, fV, fR, fS}
+	// the authorization list isn't kept
+	setCodeFields = []txField{fChainID, fNonce, fTip, fFeeCap, fGasLimit, fTo, fValue, fData, rlpAccessList, nil, fV, fR, fS}
+	depositFields = []txField{
+		rlpBytes(func(tx *Tx) *Bytes { return &tx.SourceHash }),
+		rlpBytes(func(tx *Tx) *Bytes { return &tx.From }),
+		fTo,
+		rlpUint256(func(tx *Tx) *uint256.Int { return &tx.Mint }),
+		fValue,
+		fGasLimit,
+		rlpBool(func(tx *Tx) *bool { return &tx.IsSystemTx }),
+		fData,
+	}
+)
+
+// Hash of the unsigned tx. Must hold cacheMut.
+func (tx *Tx) sighash() ([]byte, error) {
+	if tx.Type == 0 {
+		items, err := rlp.List(tx.rbuf)
+		if err != nil {
+			return nil, err
+		}
+		unsigned := items[:6]
+		if tx.eip155() {
+			unsigned = append(unsigned[:6:6],
+				rlp.EncodeUint64(tx.chainid()),
+				rlp.EncodeUint64(0),
+				rlp.EncodeUint64(0),
+			)
+		}
+		return Keccak(rlp.EncodeList(unsigned...)), nil
+	}
+	items, err := rlp.List(tx.rbuf[1:])
+	if err != nil {
+		return nil, err
+	}
+	b := append([]byte{tx.rbuf[0]}, rlp.EncodeList(items[:len(items)-3]...)...)
+	return Keccak(b), nil
+}
+
+// Must hold cacheMut. Typed and EIP-155 txs came after
+// Homestead so their signatures must have a low s. Other
+// legacy txs may be from Frontier, which allowed any s.
+func (tx *Tx) recover() ([]byte, error) {
+	var (
+		recid byte
+		lowS  = true
+	)
+	switch v := tx.V.Uint64(); {
+	case !tx.V.IsUint64(), tx.Type != 0 && v > 1:
+		return nil, errSig
+	case tx.Type != 0:
+		recid = byte(v)
+	case v == 27 || v == 28:
+		recid, lowS = byte(v-27), false
+	case v >= 35:
+		recid = byte((v - 35) % 2)
+	default:
+		return nil, errSig
+	}
+	h, err := tx.sighash()
+	if err != nil {
+		return nil, err
+	}
+	return ecrecover(h, &tx.R, &tx.S, recid, lowS)
+}
@@ -0,0 +1,211 @@
+package eth
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/holiman/uint256"
+)
+
+// Public key recovery for secp256k1 signatures. Used to find
+// a tx's signer when it isn't provided by the source (eg txs
+// decoded from RLP). Points are kept in Jacobian coordinates
+// so that a recovery only needs a few field inversions.
+var (
+	secpP  = uint256.MustFromHex("0xfffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f")
+	secpN  = uint256.MustFromHex("0xfffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141")
+	secpGx = uint256.MustFromHex("0x79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")
+	secpGy = uint256.MustFromHex("0x483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8")
+	secpMu = uint256.Reciprocal(secpP)
+
+	// Homestead (EIP-2) made signatures with s > n/2
+	// invalid since (r, n-s) is also a valid signature.
+	secpHalfN = new(uint256.Int).Rsh(secpN, 1)
+
+	errSig = errors.New("invalid signature")
+)
+
+func fmul(z, x, y *uint256.Int) *uint256.Int { return z.MulModWithReciprocal(x, y, secpP, &secpMu) }
+func fadd(z, x, y *uint256.Int) *uint256.Int { return z.AddMod(x, y, secpP) }
+
+func fsub(z, x, y *uint256.Int) *uint256.Int {
+	borrow := x.Lt(y)
+	z.Sub(x, y)
+	if borrow {
+		z.Add(z, secpP)
+	}
+	return z
+}
+
+func fexp(z, x *uint256.Int, e *big.Int) *uint256.Int {
+	var r big.Int
+	r.Exp(x.ToBig(), e, secpP.ToBig())
+	z.SetFromBig(&r)
+	return z
+}
+
+// Infinity when z is zero
+type jpoint struct{ x, y, z uint256.Int }
+
+func (p *jpoint) inf() bool { return p.z.IsZero() }
+
+func (p *jpoint) double() {
+	if p.inf() || p.y.IsZero() {
+		p.z.Clear()
+		return
+	}
+	var a, b, c, d, e, f, t uint256.Int
+	fmul(&a, &p.x, &p.x)
+	fmul(&b, &p.y, &p.y)
+	fmul(&c, &b, &b)
+	fadd(&t, &p.x, &b)
+	fmul(&d, &t, &t)
+	fsub(&d, &d, &a)
+	fsub(&d, &d, &c)
+	fadd(&d, &d, &d)
+	fadd(&e, &a, &a)
+	fadd(&e, &e, &a)
+	fmul(&f, &e, &e)
+
+	fmul(&p.z, &p.y, &p.z)
+	fadd(&p.z, &p.z, &p.z)
+	fsub(&p.x, &f, &d)
+	fsub(&p.x, &p.x, &d)
+	fsub(&t, &d, &p.x)
+	fmul(&p.y, &e, &t)
+	fadd(&c, &c, &c)
+	fadd(&c, &c, &c)
+	fadd(&c, &c, &c)
+	fsub(&p.y, &p.y, &c)
+}
+
+// Adds the affine point (qx, qy)
+func (p *jpoint) addAffine(qx, qy *uint256.Int) {
+	if p.inf() {
+		p.x.Set(qx)
+		p.y.Set(qy)
+		p.z.SetOne()
+		return
+	}
+	var z1z1, u2, s2, h, r, hh, i, j, v, t uint256.Int
+	fmul(&z1z1, &p.z, &p.z)
+	fmul(&u2, qx, &z1z1)
+	fmul(&s2, qy, &p.z)
+	fmul(&s2, &s2, &z1z1)
+	fsub(&h, &u2, &p.x)
+	fsub(&r, &s2, &p.y)
+	if h.IsZero() {
+		if r.IsZero() {
+			p.double()
+			return
+		}
+		p.z.Clear()
+		return
+	}
+	fmul(&hh, &h, &h)
+	fadd(&i, &hh, &hh)
+	fadd(&i, &i, &i)
+	fmul(&j, &h, &i)
+	fadd(&r, &r, &r)
+	fmul(&v, &p.x, &i)
+
+	fadd(&t, &p.z, &h)
+	fmul(&p.z, &t, &t)
+	fsub(&p.z, &p.z, &z1z1)
+	fsub(&p.z, &p.z, &hh)
+	fmul(&p.x, &r, &r)
+	fsub(&p.x, &p.x, &j)
+	fsub(&p.x, &p.x, &v)
+	fsub(&p.x, &p.x, &v)
+	fsub(&t, &v, &p.x)
+	fmul(&t, &r, &t)
+	fmul(&j, &p.y, &j)
+	fadd(&j, &j, &j)
+	fsub(&p.y, &t, &j)
+}
+
+func (p *jpoint) affine() (x, y uint256.Int) {
+	var zinv, zinv2 uint256.Int
+	zi := new(big.Int).ModInverse(p.z.ToBig(), secpP.ToBig())
+	zinv.SetFromBig(zi)
+	fmul(&zinv2, &zinv, &zinv)
+	fmul(&x, &p.x, &zinv2)
+	fmul(&zinv2, &zinv2, &zinv)
+	fmul(&y, &p.y, &zinv2)
+	return x, y
+}
+
+// Returns the address of the key that signed hash.
+// recid is the parity of the y coordinate of r's point.
+// r and s must be in [1, n-1] and when lowS is set s must
+// also be at most n/2.
+func ecrecover(hash []byte, r, s *uint256.Int, recid byte, lowS bool) ([]byte, error) {
+	switch {
+	case len(hash) != 32, recid > 1:
+		return nil, errSig
+	case r.IsZero() || !r.Lt(secpN):
+		return nil, errSig
+	case s.IsZero() || !s.Lt(secpN):
+		return nil, errSig
+	case lowS && s.Gt(secpHalfN):
+		return nil, errSig
+	}
+	// r's point. y² = x³ + 7
+	var rx, ry, y2, t uint256.Int
+	rx.Set(r)
+	fmul(&y2, &rx, &rx)
+	fmul(&y2, &y2, &rx)
+	fadd(&y2, &y2, t.SetUint64(7))
+	exp := new(big.Int).Add(secpP.ToBig(), big.NewInt(1))
+	fexp(&ry, &y2, exp.Rsh(exp, 2))
+	if !fmul(&t, &ry, &ry).Eq(&y2) {
+		return nil, errSig
+	}
+	if byte(ry.Uint64()&1) != recid {
+		fsub(&ry, secpP, &ry)
+	}
+
+	// Q = r⁻¹(sR - eG) = u1G + u2R
+	var (
+		n    = secpN.ToBig()
+		rinv = new(big.Int).ModInverse(r.ToBig(), n)
+		e    = new(big.Int).SetBytes(hash)
+		u1   = new(big.Int).Mul(e, rinv)
+		u2   = new(big.Int).Mul(s.ToBig(), rinv)
+	)
+	u1.Neg(u1).Mod(u1, n)
+	u2.Mod(u2, n)
+
+	// G+R for adding both points in one step
+	var sum jpoint
+	sum.addAffine(secpGx, secpGy)
+	sum.addAffine(&rx, &ry)
+	var sx, sy uint256.Int
+	if !sum.inf() {
+		sx, sy = sum.affine()
+	}
+
+	var q jpoint
+	for i := max(u1.BitLen(), u2.BitLen()) - 1; i >= 0; i-- {
+		q.double()
+		switch b1, b2 := u1.Bit(i), u2.Bit(i); {
+		case b1 == 1 && b2 == 1:
+			if !sum.inf() {
+				q.addAffine(&sx, &sy)
+			}
+		case b1 == 1:
+			q.addAffine(secpGx, secpGy)
+		case b2 == 1:
+			q.addAffine(&rx, &ry)
+		}
+	}
+	// sR = eG. Not a public key.
+	if q.inf() {
+		return nil, errSig
+	}
+	qx, qy := q.affine()
+	var pub [64]byte
+	qx.WriteToArray32((*[32]byte)(pub[:32]))
+	qy.WriteToArray32((*[32]byte)(pub[32:]))
+	return Keccak(pub[:])[12:], nil
+}
@@ -115,7 +115,16 @@ type Block struct {
 	sync.Mutex
 
 	Header
-	Txs Txs `json:"transactions"`
+	Txs         Txs          `json:"transactions"`
+	Withdrawals []Withdrawal `json:"withdrawals"`
+}
+
+// EIP-4895 beacon chain withdrawal. Amount is in gwei.
+type Withdrawal struct {
+	Index          Uint64 `json:"index"`
+	ValidatorIndex Uint64 `json:"validatorIndex"`
+	Address        Bytes  `json:"address"`
+	Amount         Uint64 `json:"amount"`
 }
 
 func (b *Block) SetNum(n uint64) { b.Header.Number = Uint64(n) }
@@ -170,7 +179,9 @@ func (ls *Logs) Add(other *Log) {
 type Receipt struct {
 	Status            Byte
 	GasUsed           Uint64
+	CumulativeGasUsed Uint64
 	EffectiveGasPrice uint256.Int
+	ContractAddress   Bytes
 	Logs              Logs
 }
 
@@ -180,6 +191,19 @@ type Header struct {
 	Parent    Bytes  `json:"parentHash"`
 	LogsBloom Bytes  `json:"logsBloom"`
 	Time      Uint64 `json:"timestamp"`
+
+	// EIP-4844
+	BlobGasUsed   Uint64 `json:"blobGasUsed"`
+	ExcessBlobGas Uint64 `json:"excessBlobGas"`
+
+	// Arbitrum
+	L1Num     Uint64 `json:"l1BlockNumber"`
+	SendRoot  Bytes  `json:"sendRoot"`
+	SendCount Uint64 `json:"sendCount"`
+
+	// zkSync Era. Zero until the block's batch is sealed.
+	L1BatchNum  Uint64 `json:"l1BatchNumber"`
+	L1BatchTime Uint64 `json:"l1BatchTimestamp"`
 }
 
 type AccessTuple struct {
@@ -191,12 +215,21 @@ type AccessTuples []AccessTuple
 
 type Txs []Tx
 
+// Idx, Depth, and Error are set by the client rather
+// than decoded from the action
 type TraceAction struct {
-	Idx      uint64
+	Idx      uint64      `json:"-"`
 	From     Bytes       `json:"from"`
 	CallType string      `json:"callType"`
 	To       Bytes       `json:"to"`
 	Value    uint256.Int `json:"value"`
+	Input    Bytes       `json:"input"`
+
+	// Number of calls between this call and the tx's
+	// top-level call. The top-level call has depth 0.
+	Depth uint64 `json:"-"`
+	// Set when the call reverted
+	Error string `json:"-"`
 }
 
 type Tx struct {
@@ -224,6 +257,28 @@ type Tx struct {
 	MaxPriorityFeePerGas uint256.Int `json:"maxPriorityFeePerGas"`
 	MaxFeePerGas         uint256.Int `json:"maxFeePerGas"`
 
+	// EIP-4844
+	MaxFeePerBlobGas    uint256.Int `json:"maxFeePerBlobGas"`
+	BlobVersionedHashes []Bytes     `json:"blobVersionedHashes"`
+
+	// OP Stack deposit (type 0x7e). Deposits have no
+	// signature or gas price fields so those are zero.
+	SourceHash Bytes       `json:"sourceHash"`
+	Mint       uint256.Int `json:"mint"`
+	IsSystemTx bool        `json:"isSystemTx"`
+
+	// Arbitrum retryable (types 0x68 and 0x69). Internal
+	// txs (0x6a) are unsigned and have no extra fields.
+	RequestID    Bytes       `json:"requestId"`
+	TicketID     Bytes       `json:"ticketId"`
+	RefundTo     Bytes       `json:"refundTo"`
+	L1BaseFee    uint256.Int `json:"l1BaseFee"`
+	DepositValue uint256.Int `json:"depositValue"`
+
+	// zkSync Era. EIP-712 (0x71) and priority (0xff) txs
+	// have the common fields.
+	L1BatchTxIdx Uint64 `json:"l1BatchTxIndex"`
+
 	PrecompHash  Bytes `json:"hash"`
 	cacheMut     sync.Mutex
 	rbuf, signer []byte
@@ -238,8 +293,22 @@ func (tx *Tx) Hash() []byte {
 	return tx.PrecompHash
 }
 
+// Returns From or, for txs decoded from RLP without it,
+// the address recovered from the signature
 func (tx *Tx) Signer() ([]byte, error) {
-	return tx.From, nil
+	if len(tx.From) > 0 || len(tx.rbuf) == 0 {
+		return tx.From, nil
+	}
+	tx.cacheMut.Lock()
+	defer tx.cacheMut.Unlock()
+	if len(tx.signer) == 0 {
+		s, err := tx.recover()
+		if err != nil {
+			return nil, fmt.Errorf("recovering signer: %w", err)
+		}
+		tx.signer = s
+	}
+	return tx.signer, nil
 }
 
 func (tx *Tx) v() byte {
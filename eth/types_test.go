@@ -1,10 +1,13 @@
 package eth
 
 import (
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"math/big"
 	"testing"
 
+	"github.com/holiman/uint256"
 	"kr.dev/diff"
 )
 
@@ -123,3 +126,304 @@ func TestBytes_Write(t *testing.T) {
 	diff.Test(t, t.Errorf, 16, len(x))
 	diff.Test(t, t.Errorf, 32, cap(x))
 }
+
+func TestBlock_Blobs(t *testing.T) {
+	const input = `{
+		"number": "0x1",
+		"blobGasUsed": "0x40000",
+		"excessBlobGas": "0x0",
+		"transactions": [{
+			"type": "0x3",
+			"maxFeePerBlobGas": "0x3b9aca00",
+			"blobVersionedHashes": [
+				"0x01aa000000000000000000000000000000000000000000000000000000000000",
+				"0x01bb000000000000000000000000000000000000000000000000000000000000"
+			]
+		}]
+	}`
+	var b Block
+	diff.Test(t, t.Fatalf, nil, json.Unmarshal([]byte(input), &b))
+	diff.Test(t, t.Errorf, b.BlobGasUsed, Uint64(262144))
+	diff.Test(t, t.Errorf, b.ExcessBlobGas, Uint64(0))
+	diff.Test(t, t.Fatalf, len(b.Txs), 1)
+	diff.Test(t, t.Errorf, b.Txs[0].MaxFeePerBlobGas.Dec(), "1000000000")
+	diff.Test(t, t.Fatalf, len(b.Txs[0].BlobVersionedHashes), 2)
+	diff.Test(t, t.Errorf, []byte(b.Txs[0].BlobVersionedHashes[1][:2]), h2b("01bb"))
+}
+
+func TestBlock_Deposit(t *testing.T) {
+	const input = `{
+		"number": "0x1",
+		"transactions": [{
+			"type": "0x7e",
+			"hash": "0xaa00000000000000000000000000000000000000000000000000000000000000",
+			"from": "0xdeaddeaddeaddeaddeaddeaddeaddeaddead0001",
+			"to": "0x4200000000000000000000000000000000000015",
+			"sourceHash": "0xbb00000000000000000000000000000000000000000000000000000000000000",
+			"mint": "0xde0b6b3a7640000",
+			"isSystemTx": true,
+			"gas": "0xf4240",
+			"gasPrice": "0x0",
+			"value": "0x0",
+			"input": "0x",
+			"nonce": "0x1",
+			"v": "0x0",
+			"r": "0x0",
+			"s": "0x0",
+			"transactionIndex": "0x0"
+		}]
+	}`
+	var b Block
+	diff.Test(t, t.Fatalf, nil, json.Unmarshal([]byte(input), &b))
+	diff.Test(t, t.Fatalf, len(b.Txs), 1)
+	tx := &b.Txs[0]
+	diff.Test(t, t.Errorf, tx.Type, Byte(0x7e))
+	diff.Test(t, t.Errorf, []byte(tx.SourceHash[:1]), h2b("bb"))
+	diff.Test(t, t.Errorf, tx.Mint.Dec(), "1000000000000000000")
+	diff.Test(t, t.Errorf, tx.IsSystemTx, true)
+	diff.Test(t, t.Errorf, tx.GasPrice.Dec(), "0")
+	signer, err := tx.Signer()
+	diff.Test(t, t.Errorf, nil, err)
+	diff.Test(t, t.Errorf, []byte(signer[:2]), h2b("dead"))
+	diff.Test(t, t.Errorf, tx.Hash()[:1], h2b("aa"))
+}
+
+func TestBlock_Arbitrum(t *testing.T) {
+	const input = `{
+		"number": "0xa",
+		"l1BlockNumber": "0x12a05f2",
+		"sendRoot": "0xcc00000000000000000000000000000000000000000000000000000000000000",
+		"sendCount": "0x2f",
+		"transactions": [{
+			"type": "0x6a",
+			"from": "0x00000000000000000000000000000000000a4b05",
+			"to": "0x00000000000000000000000000000000000a4b05",
+			"gas": "0x0",
+			"gasPrice": "0x0",
+			"value": "0x0",
+			"input": "0x6bf6a42d",
+			"nonce": "0x0",
+			"chainId": "0xa4b1",
+			"v": "0x0",
+			"r": "0x0",
+			"s": "0x0",
+			"transactionIndex": "0x0"
+		}, {
+			"type": "0x69",
+			"from": "0x1100000000000000000000000000000000000000",
+			"to": "0x000000000000000000000000000000000000006e",
+			"requestId": "0xdd00000000000000000000000000000000000000000000000000000000000000",
+			"l1BaseFee": "0x3b9aca00",
+			"depositValue": "0xde0b6b3a7640000",
+			"retryTo": null,
+			"gas": "0x0",
+			"gasPrice": "0x0",
+			"value": "0x0",
+			"input": "0x",
+			"nonce": "0x0",
+			"transactionIndex": "0x1"
+		}]
+	}`
+	var b Block
+	diff.Test(t, t.Fatalf, nil, json.Unmarshal([]byte(input), &b))
+	diff.Test(t, t.Errorf, b.L1Num, Uint64(19531250))
+	diff.Test(t, t.Errorf, []byte(b.SendRoot[:1]), h2b("cc"))
+	diff.Test(t, t.Errorf, b.SendCount, Uint64(47))
+	diff.Test(t, t.Fatalf, len(b.Txs), 2)
+	diff.Test(t, t.Errorf, b.Txs[0].Type, Byte(0x6a))
+	diff.Test(t, t.Errorf, []byte(b.Txs[1].RequestID[:1]), h2b("dd"))
+	diff.Test(t, t.Errorf, b.Txs[1].L1BaseFee.Dec(), "1000000000")
+	diff.Test(t, t.Errorf, b.Txs[1].DepositValue.Dec(), "1000000000000000000")
+}
+
+func scalarBase(k *uint256.Int) (x, y uint256.Int) {
+	var p jpoint
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		p.double()
+		if k.ToBig().Bit(i) == 1 {
+			p.addAffine(secpGx, secpGy)
+		}
+	}
+	return p.affine()
+}
+
+func TestEcrecover(t *testing.T) {
+	cases := []struct {
+		key  uint64
+		want string
+	}{
+		{1, "7e5f4552091a69125d5dfcb7b8c2659029395bdf"},
+		{2, "2b5ad5c4795c026514f8317c7a215e218dccd6cf"},
+		{0xdeadbeef, ""},
+	}
+	for _, tc := range cases {
+		var (
+			d    = uint256.NewInt(tc.key)
+			hash = Keccak([]byte("shovel"))
+			k    = new(uint256.Int).SetBytes(Keccak(append(hash, d.Bytes()...)))
+		)
+		k.Mod(k, secpN)
+		// r = (kG).x, s = k⁻¹(e + rd)
+		rx, ry := scalarBase(k)
+		var (
+			n    = secpN.ToBig()
+			r    = new(big.Int).Mod(rx.ToBig(), n)
+			s    = new(big.Int).Mul(r, d.ToBig())
+			kinv = new(big.Int).ModInverse(k.ToBig(), n)
+		)
+		s.Add(s, new(big.Int).SetBytes(hash))
+		s.Mul(s, kinv).Mod(s, n)
+		var ur, us uint256.Int
+		ur.SetFromBig(r)
+		us.SetFromBig(s)
+
+		px, py := scalarBase(d)
+		var pub [64]byte
+		px.WriteToArray32((*[32]byte)(pub[:32]))
+		py.WriteToArray32((*[32]byte)(pub[32:]))
+		want := Keccak(pub[:])[12:]
+		if len(tc.want) > 0 {
+			diff.Test(t, t.Errorf, hex.EncodeToString(want), tc.want)
+		}
+
+		got, err := ecrecover(hash, &ur, &us, byte(ry.Uint64()&1), false)
+		diff.Test(t, t.Fatalf, err, nil)
+		diff.Test(t, t.Errorf, got, want)
+
+		got, err = ecrecover(hash, &ur, &us, byte(ry.Uint64()&1)^1, false)
+		diff.Test(t, t.Fatalf, err, nil)
+		diff.Test(t, t.Errorf, bytes.Equal(got, want), false)
+	}
+}
+
+func TestEcrecover_Vectors(t *testing.T) {
+	const (
+		// go-ethereum's crypto tests
+		gethHash = "ce0677bb30baa8cf067c88db9811f4333d131bf8bcf12fe7065d211dce971008"
+		gethR    = "0x90f27b8b488db00b00606796d2987f6a5f59ae62ea05effe84fef5b8b0e54998"
+		gethS    = "0x4a691139ad57a3f0b906637673aa2f63d1f55cb1a69199d4009eea23ceaddc93"
+		gethPub  = "e32df42865e97135acfb65f3bae71bdc86f4d49150ad6a440b6f15878109880a0a2b2667f7e725ceea70c673093bf67663e0312623c8e091b13cf2c0f11ef652"
+
+		// EIP-155 example tx
+		exHash  = "daf5a779ae972f972197303d7b574746c7ef83eadac0f2791ad23db92e4c8e53"
+		exR     = "0x28ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276"
+		exS     = "0x67cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"
+		exHighS = "0x98341627668089e51348fccfb4c7ff31c55912f2d2e47ef09652acf665fad3be"
+		exAddr  = "9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f"
+
+		one = "0000000000000000000000000000000000000000000000000000000000000001"
+		n   = "0xfffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141"
+	)
+	gethAddr := hex.EncodeToString(Keccak(h2b(gethPub))[12:])
+	cases := []struct {
+		desc  string
+		hash  string
+		r, s  string
+		recid byte
+		lowS  bool
+		want  string // empty when invalid
+	}{
+		{"geth", gethHash, gethR, gethS, 1, true, gethAddr},
+		{"eip-155", exHash, exR, exS, 0, true, exAddr},
+		{"high s frontier", exHash, exR, exHighS, 1, false, exAddr},
+		{"high s homestead", exHash, exR, exHighS, 1, true, ""},
+		{"recid 2", exHash, exR, exS, 2, true, ""},
+		{"short hash", exHash[2:], exR, exS, 0, true, ""},
+		{"zero r", exHash, "0x0", exS, 0, true, ""},
+		{"zero s", exHash, exR, "0x0", 0, true, ""},
+		{"r = n", exHash, n, exS, 0, true, ""},
+		{"s = n", exHash, exR, n, 0, false, ""},
+		{"r not on curve", exHash, "0x5", exS, 0, true, ""},
+		// R = G and s = e so sR - eG is the point at infinity
+		{"infinity", one, secpGx.Hex(), "0x1", 0, true, ""},
+	}
+	for _, tc := range cases {
+		got, err := ecrecover(
+			h2b(tc.hash),
+			uint256.MustFromHex(tc.r),
+			uint256.MustFromHex(tc.s),
+			tc.recid,
+			tc.lowS,
+		)
+		if tc.want == "" {
+			diff.Test(t, t.Errorf, err, errSig)
+			continue
+		}
+		diff.Test(t, t.Fatalf, err, nil)
+		diff.Test(t, t.Errorf, hex.EncodeToString(got), tc.want)
+	}
+}
+
+func TestUnmarshalRLP(t *testing.T) {
+	// signed tx from the EIP-155 example
+	const raw = "f86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"
+	var tx Tx
+	diff.Test(t, t.Fatalf, tx.UnmarshalRLP(h2b(raw)), nil)
+	diff.Test(t, t.Errorf, tx.Nonce, Uint64(9))
+	diff.Test(t, t.Errorf, tx.GasPrice.Uint64(), uint64(20e9))
+	diff.Test(t, t.Errorf, tx.GasLimit, Uint64(21000))
+	diff.Test(t, t.Errorf, tx.To, Bytes(h2b("3535353535353535353535353535353535353535")))
+	diff.Test(t, t.Errorf, tx.Value.Uint64(), uint64(1e18))
+	diff.Test(t, t.Errorf, tx.ChainID.Uint64(), uint64(1))
+	diff.Test(t, t.Errorf, tx.Hash(), Keccak(h2b(raw)))
+
+	h, err := tx.sighash()
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, hex.EncodeToString(h), "daf5a779ae972f972197303d7b574746c7ef83eadac0f2791ad23db92e4c8e53")
+
+	signer, err := tx.Signer()
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, hex.EncodeToString(signer), "9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f")
+
+	err = tx.UnmarshalRLP(append([]byte{0x05}, h2b(raw)...))
+	diff.Test(t, t.Errorf, err.Error(), "unsupported tx type: 5")
+}
+
+func TestBlock_ZKSync(t *testing.T) {
+	const input = `{
+		"number": "0x2faf080",
+		"l1BatchNumber": "0x7a120",
+		"l1BatchTimestamp": null,
+		"sealFields": [],
+		"transactions": [{
+			"type": "0xff",
+			"from": "0x2200000000000000000000000000000000000000",
+			"to": "0x0000000000000000000000000000000000008006",
+			"gas": "0x44aa200",
+			"gasPrice": "0x0",
+			"value": "0x0",
+			"input": "0x",
+			"nonce": "0x1",
+			"l1BatchNumber": "0x7a120",
+			"l1BatchTxIndex": "0x0",
+			"transactionIndex": "0x0"
+		}, {
+			"type": "0x71",
+			"from": "0x3300000000000000000000000000000000000000",
+			"to": "0x000000000000000000000000000000000000800a",
+			"gas": "0x1e8480",
+			"gasPrice": "0x2b275d0",
+			"maxFeePerGas": "0x2b275d0",
+			"maxPriorityFeePerGas": "0x0",
+			"value": "0x0",
+			"input": "0x",
+			"nonce": "0x5",
+			"chainId": "0x144",
+			"v": "0x0",
+			"r": "0x1",
+			"s": "0x2",
+			"l1BatchNumber": "0x7a120",
+			"l1BatchTxIndex": "0x3",
+			"transactionIndex": "0x1"
+		}]
+	}`
+	var b Block
+	diff.Test(t, t.Fatalf, nil, json.Unmarshal([]byte(input), &b))
+	diff.Test(t, t.Errorf, b.L1BatchNum, Uint64(500000))
+	diff.Test(t, t.Errorf, b.L1BatchTime, Uint64(0))
+	diff.Test(t, t.Fatalf, len(b.Txs), 2)
+	diff.Test(t, t.Errorf, b.Txs[0].Type, Byte(0xff))
+	diff.Test(t, t.Errorf, b.Txs[1].Type, Byte(0x71))
+	diff.Test(t, t.Errorf, b.Txs[1].L1BatchTxIdx, Uint64(3))
+	diff.Test(t, t.Errorf, b.Txs[1].MaxFeePerGas.Uint64(), uint64(45250000))
+}
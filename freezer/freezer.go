@@ -0,0 +1,412 @@
+// Reads historical blocks from a geth node's freezer
+//
+// geth moves blocks older than ~90k blocks from its key-value
+// store into flat files (the freezer or ancient store). Reading
+// them directly is much faster (and cheaper) than requesting
+// them from a provider, so a [Freezer] can be used as a task's
+// source to backfill the frozen range.
+//
+// Receipts are stored without derived fields. Tx hashes,
+// signers, gas used, effective gas prices, contract addresses,
+// and log indexes are computed from the block. Traces aren't
+// available.
+//
+// There is no equivalent for Erigon or reth. Their datadirs
+// keep recent blocks in MDBX, which needs cgo bindings to
+// libmdbx, and reth stores rows in its own compact encoding
+// that changes between releases. Use their JSON-RPC (or an
+// ipc:// url) as the source instead.
+package freezer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/indexsupply/shovel/eth"
+	"github.com/indexsupply/shovel/jrpc2"
+	"github.com/indexsupply/shovel/rlp"
+	"github.com/indexsupply/shovel/shovel/glf"
+
+	"github.com/holiman/uint256"
+)
+
+type Freezer struct {
+	url      *jrpc2.URL
+	headers  *table
+	bodies   *table
+	receipts *table
+}
+
+// dir is the freezer's directory (eg <datadir>/geth/chaindata/ancient/chain)
+// or a directory that contains it (eg the node's datadir)
+func Open(dir string) (*Freezer, error) {
+	for _, sub := range []string{
+		"",
+		"chain",
+		filepath.Join("ancient", "chain"),
+		filepath.Join("chaindata", "ancient", "chain"),
+		filepath.Join("geth", "chaindata", "ancient", "chain"),
+	} {
+		d := filepath.Join(dir, sub)
+		if !exists(filepath.Join(d, "headers.cidx")) && !exists(filepath.Join(d, "headers.ridx")) {
+			continue
+		}
+		f := &Freezer{url: jrpc2.MustURL("file://" + d)}
+		var err error
+		if f.headers, err = openTable(d, "headers"); err != nil {
+			return nil, err
+		}
+		if f.bodies, err = openTable(d, "bodies"); err != nil {
+			f.headers.close()
+			return nil, err
+		}
+		if f.receipts, err = openTable(d, "receipts"); err != nil {
+			f.headers.close()
+			f.bodies.close()
+			return nil, err
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("no freezer found in %s", dir)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+func (f *Freezer) Close() {
+	f.headers.close()
+	f.bodies.close()
+	f.receipts.close()
+}
+
+func (f *Freezer) NextURL() *jrpc2.URL { return f.url }
+
+// The last block in each of the tables
+func (f *Freezer) Latest(_ context.Context, _ string, _ uint64) (uint64, []byte, error) {
+	var last uint64 = math.MaxUint64
+	for _, t := range []*table{f.headers, f.bodies, f.receipts} {
+		n, err := t.items()
+		if err != nil {
+			return 0, nil, fmt.Errorf("reading %s index: %w", t.name, err)
+		}
+		if n == 0 {
+			return 0, nil, fmt.Errorf("freezer is empty")
+		}
+		last = min(last, n-1)
+	}
+	h, err := f.hash(last)
+	return last, h, err
+}
+
+func (f *Freezer) Hash(_ context.Context, _ string, n uint64) ([]byte, error) {
+	return f.hash(n)
+}
+
+func (f *Freezer) hash(n uint64) ([]byte, error) {
+	h, err := f.headers.item(n)
+	if err != nil {
+		return nil, err
+	}
+	return eth.Keccak(h), nil
+}
+
+func (f *Freezer) Get(
+	ctx context.Context,
+	_ string,
+	filter *glf.Filter,
+	start, limit uint64,
+) ([]eth.Block, error) {
+	if filter.UseTraces {
+		return nil, fmt.Errorf("freezer doesn't have traces")
+	}
+	blocks := make([]eth.Block, limit)
+	for i := range blocks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := f.block(&blocks[i], filter, start+uint64(i)); err != nil {
+			return nil, fmt.Errorf("block %d: %w", start+uint64(i), err)
+		}
+	}
+	return blocks, nil
+}
+
+func (f *Freezer) block(b *eth.Block, filter *glf.Filter, n uint64) error {
+	h, err := f.headers.item(n)
+	if err != nil {
+		return err
+	}
+	baseFee, err := decodeHeader(&b.Header, h)
+	if err != nil {
+		return fmt.Errorf("decoding header: %w", err)
+	}
+	if uint64(b.Header.Number) != n {
+		return fmt.Errorf("freezer has block %d at %d", b.Header.Number, n)
+	}
+	var (
+		useReceipts = filter.UseReceipts || filter.UseLogs
+		screened    = !filter.UseBlocks && filter.Screens() && !filter.MayMatch(b.Header.LogsBloom)
+	)
+	if !filter.UseBlocks && (!useReceipts || screened) {
+		return nil
+	}
+	body, err := f.bodies.item(n)
+	if err != nil {
+		return err
+	}
+	if err := decodeBody(b, body); err != nil {
+		return fmt.Errorf("decoding body: %w", err)
+	}
+	if !useReceipts || screened {
+		return nil
+	}
+	receipts, err := f.receipts.item(n)
+	if err != nil {
+		return err
+	}
+	if err := decodeReceipts(b, baseFee, receipts); err != nil {
+		return fmt.Errorf("decoding receipts: %w", err)
+	}
+	return nil
+}
+
+// Returns the base fee which isn't kept in [eth.Header]
+func decodeHeader(h *eth.Header, b []byte) (*uint256.Int, error) {
+	items, err := rlp.List(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) < 15 {
+		return nil, fmt.Errorf("expected at least 15 header fields got %d", len(items))
+	}
+	h.Hash.Write(eth.Keccak(b))
+	for _, f := range []struct {
+		i int
+		d *eth.Bytes
+	}{
+		{0, &h.Parent},
+		{6, &h.LogsBloom},
+	} {
+		d, err := rlp.Bytes(items[f.i])
+		if err != nil {
+			return nil, err
+		}
+		f.d.Write(d)
+	}
+	for _, f := range []struct {
+		i int
+		n *eth.Uint64
+	}{
+		{8, &h.Number},
+		{11, &h.Time},
+		{17, &h.BlobGasUsed},
+		{18, &h.ExcessBlobGas},
+	} {
+		if f.i >= len(items) {
+			continue
+		}
+		n, err := rlp.Uint64(items[f.i])
+		if err != nil {
+			return nil, err
+		}
+		*f.n = eth.Uint64(n)
+	}
+	var baseFee uint256.Int
+	if len(items) > 15 {
+		d, err := rlp.Bytes(items[15])
+		if err != nil {
+			return nil, err
+		}
+		baseFee.SetBytes(d)
+	}
+	return &baseFee, nil
+}
+
+// [txs, uncles, withdrawals, ...]
+func decodeBody(b *eth.Block, d []byte) error {
+	items, err := rlp.List(d)
+	if err != nil {
+		return err
+	}
+	if len(items) < 2 {
+		return fmt.Errorf("expected at least 2 body fields got %d", len(items))
+	}
+	txs, err := rlp.List(items[0])
+	if err != nil {
+		return err
+	}
+	b.Txs = make(eth.Txs, len(txs))
+	for i := range txs {
+		// typed txs are wrapped in a string
+		_, payload, _, list, err := rlp.Split(txs[i])
+		if err != nil {
+			return err
+		}
+		enc := txs[i]
+		if !list {
+			enc = payload
+		}
+		b.Txs[i].Idx = eth.Uint64(i)
+		if err := b.Txs[i].UnmarshalRLP(enc); err != nil {
+			return fmt.Errorf("tx %d: %w", i, err)
+		}
+	}
+	if len(items) < 3 {
+		return nil
+	}
+	ws, err := rlp.List(items[2])
+	if err != nil {
+		return err
+	}
+	b.Withdrawals = make([]eth.Withdrawal, len(ws))
+	for i := range ws {
+		fields, err := rlp.List(ws[i])
+		if err != nil || len(fields) != 4 {
+			return fmt.Errorf("withdrawal %d", i)
+		}
+		w := &b.Withdrawals[i]
+		for j, n := range []*eth.Uint64{&w.Index, &w.ValidatorIndex, nil, &w.Amount} {
+			if n == nil {
+				continue
+			}
+			v, err := rlp.Uint64(fields[j])
+			if err != nil {
+				return fmt.Errorf("withdrawal %d: %w", i, err)
+			}
+			*n = eth.Uint64(v)
+		}
+		addr, err := rlp.Bytes(fields[2])
+		if err != nil {
+			return fmt.Errorf("withdrawal %d: %w", i, err)
+		}
+		w.Address.Write(addr)
+	}
+	return nil
+}
+
+// Receipts are stored as [status, cumulative gas used, logs]
+// and logs as [address, topics, data]
+func decodeReceipts(b *eth.Block, baseFee *uint256.Int, d []byte) error {
+	receipts, err := rlp.List(d)
+	if err != nil {
+		return err
+	}
+	if len(receipts) != len(b.Txs) {
+		return fmt.Errorf("%d receipts for %d txs", len(receipts), len(b.Txs))
+	}
+	var (
+		logIdx  uint64
+		prevGas uint64
+	)
+	for i := range receipts {
+		fields, err := rlp.List(receipts[i])
+		if err != nil {
+			return err
+		}
+		if len(fields) != 3 {
+			const tag = "receipt %d has %d fields. legacy receipts aren't supported"
+			return fmt.Errorf(tag, i, len(fields))
+		}
+		tx := &b.Txs[i]
+		status, err := rlp.Bytes(fields[0])
+		if err != nil {
+			return err
+		}
+		if len(status) == 1 && status[0] == 1 {
+			tx.Status = 1
+		}
+		gas, err := rlp.Uint64(fields[1])
+		if err != nil {
+			return err
+		}
+		tx.CumulativeGasUsed = eth.Uint64(gas)
+		tx.GasUsed = eth.Uint64(gas - prevGas)
+		prevGas = gas
+		effectiveGasPrice(tx, baseFee)
+		if len(tx.To) == 0 {
+			signer, err := tx.Signer()
+			if err != nil {
+				return fmt.Errorf("tx %d: %w", i, err)
+			}
+			tx.ContractAddress.Write(contractAddress(signer, uint64(tx.Nonce)))
+		}
+		logs, err := rlp.List(fields[2])
+		if err != nil {
+			return err
+		}
+		tx.Logs = make(eth.Logs, len(logs))
+		for j := range logs {
+			if err := decodeLog(&tx.Logs[j], logs[j]); err != nil {
+				return fmt.Errorf("tx %d log %d: %w", i, j, err)
+			}
+			tx.Logs[j].Idx = eth.Uint64(logIdx)
+			logIdx++
+		}
+	}
+	return nil
+}
+
+func decodeLog(l *eth.Log, d []byte) error {
+	fields, err := rlp.List(d)
+	if err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("expected 3 fields got %d", len(fields))
+	}
+	addr, err := rlp.Bytes(fields[0])
+	if err != nil {
+		return err
+	}
+	l.Address.Write(addr)
+	topics, err := rlp.List(fields[1])
+	if err != nil {
+		return err
+	}
+	l.Topics = make([]eth.Bytes, len(topics))
+	for i := range topics {
+		t, err := rlp.Bytes(topics[i])
+		if err != nil {
+			return err
+		}
+		l.Topics[i].Write(t)
+	}
+	data, err := rlp.Bytes(fields[2])
+	if err != nil {
+		return err
+	}
+	l.Data.Write(data)
+	return nil
+}
+
+// As reported by eth_getTransactionReceipt. Dynamic fee txs
+// pay the base fee plus the tip, up to the max fee. Their
+// GasPrice is also set to the effective price (as in
+// eth_getBlockByNumber).
+func effectiveGasPrice(tx *eth.Tx, baseFee *uint256.Int) {
+	switch tx.Type {
+	case 0x00, 0x01:
+		tx.EffectiveGasPrice.Set(&tx.GasPrice)
+	case 0x02, 0x03, 0x04:
+		p := new(uint256.Int).Add(baseFee, &tx.MaxPriorityFeePerGas)
+		if p.Gt(&tx.MaxFeePerGas) {
+			p.Set(&tx.MaxFeePerGas)
+		}
+		tx.EffectiveGasPrice.Set(p)
+		tx.GasPrice.Set(p)
+	}
+}
+
+func contractAddress(sender []byte, nonce uint64) []byte {
+	return eth.Keccak(rlp.EncodeList(
+		rlp.EncodeBytes(sender),
+		rlp.EncodeUint64(nonce),
+	))[12:]
+}
@@ -0,0 +1,243 @@
+package freezer
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/indexsupply/shovel/eth"
+	"github.com/indexsupply/shovel/rlp"
+	"github.com/indexsupply/shovel/shovel/glf"
+
+	"github.com/klauspost/compress/s2"
+	"kr.dev/diff"
+)
+
+func h2b(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+// Writes items to a table whose first item is tail.
+// Data files are split at maxFile bytes.
+func writeTable(t *testing.T, dir, name string, compressed bool, tail uint64, items [][]byte, maxFile int) {
+	var (
+		idx  = make([]byte, 6)
+		ext  = "rdat"
+		data = map[uint16][]byte{}
+		file uint16
+	)
+	if compressed {
+		ext = "cdat"
+	}
+	binary.BigEndian.PutUint32(idx[2:], uint32(tail))
+	for _, item := range items {
+		if compressed {
+			item = s2.EncodeSnappy(nil, item)
+		}
+		if len(data[file]) > 0 && len(data[file])+len(item) > maxFile {
+			file++
+		}
+		data[file] = append(data[file], item...)
+		e := make([]byte, 6)
+		binary.BigEndian.PutUint16(e, file)
+		binary.BigEndian.PutUint32(e[2:], uint32(len(data[file])))
+		idx = append(idx, e...)
+	}
+	iext := "ridx"
+	if compressed {
+		iext = "cidx"
+	}
+	diff.Test(t, t.Fatalf, os.WriteFile(filepath.Join(dir, name+"."+iext), idx, 0644), nil)
+	for num, d := range data {
+		path := filepath.Join(dir, fmt.Sprintf("%s.%04d.%s", name, num, ext))
+		diff.Test(t, t.Fatalf, os.WriteFile(path, d, 0644), nil)
+	}
+}
+
+func bytesN(n int, b byte) []byte {
+	d := make([]byte, n)
+	for i := range d {
+		d[i] = b
+	}
+	return d
+}
+
+func header(num uint64, parent []byte) []byte {
+	return rlp.EncodeList(
+		rlp.EncodeBytes(parent),
+		rlp.EncodeBytes(bytesN(32, 1)), // uncles
+		rlp.EncodeBytes(bytesN(20, 2)), // coinbase
+		rlp.EncodeBytes(bytesN(32, 3)), // state root
+		rlp.EncodeBytes(bytesN(32, 4)), // tx root
+		rlp.EncodeBytes(bytesN(32, 5)), // receipt root
+		rlp.EncodeBytes(make([]byte, 256)),
+		rlp.EncodeUint64(0), // difficulty
+		rlp.EncodeUint64(num),
+		rlp.EncodeUint64(30_000_000),
+		rlp.EncodeUint64(71000),
+		rlp.EncodeUint64(1700000000+12*num),
+		rlp.EncodeBytes(nil),           // extra
+		rlp.EncodeBytes(bytesN(32, 6)), // mix
+		rlp.EncodeBytes(make([]byte, 8)),
+		rlp.EncodeUint64(10e9), // base fee
+	)
+}
+
+// signed tx from the EIP-155 example
+const legacyTx = "f86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"
+
+func dynamicFeeTx() []byte {
+	return append([]byte{0x02}, rlp.EncodeList(
+		rlp.EncodeUint64(1),
+		rlp.EncodeUint64(7),
+		rlp.EncodeUint64(2e9),  // tip
+		rlp.EncodeUint64(20e9), // fee cap
+		rlp.EncodeUint64(50000),
+		rlp.EncodeBytes(bytesN(20, 0xaa)),
+		rlp.EncodeUint64(0),
+		rlp.EncodeBytes([]byte{0xde, 0xad}),
+		rlp.EncodeList(),
+		rlp.EncodeUint64(1),
+		rlp.EncodeBytes(bytesN(32, 0x11)),
+		rlp.EncodeBytes(bytesN(32, 0x22)),
+	)...)
+}
+
+func body() []byte {
+	return rlp.EncodeList(
+		rlp.EncodeList(
+			h2b(legacyTx),
+			rlp.EncodeBytes(dynamicFeeTx()),
+		),
+		rlp.EncodeList(),
+		rlp.EncodeList(rlp.EncodeList(
+			rlp.EncodeUint64(3),
+			rlp.EncodeUint64(4),
+			rlp.EncodeBytes(bytesN(20, 0xbb)),
+			rlp.EncodeUint64(32e9),
+		)),
+	)
+}
+
+func receipts() []byte {
+	return rlp.EncodeList(
+		rlp.EncodeList(
+			rlp.EncodeUint64(1),
+			rlp.EncodeUint64(21000),
+			rlp.EncodeList(rlp.EncodeList(
+				rlp.EncodeBytes(bytesN(20, 0xcc)),
+				rlp.EncodeList(rlp.EncodeBytes(bytesN(32, 0xdd))),
+				rlp.EncodeBytes([]byte("data")),
+			)),
+		),
+		rlp.EncodeList(
+			rlp.EncodeUint64(0),
+			rlp.EncodeUint64(71000),
+			rlp.EncodeList(rlp.EncodeList(
+				rlp.EncodeBytes(bytesN(20, 0xee)),
+				rlp.EncodeList(),
+				rlp.EncodeBytes(nil),
+			)),
+		),
+	)
+}
+
+// blocks 1 through 3. block 0 has been removed.
+func testFreezer(t *testing.T) string {
+	var (
+		dir     = filepath.Join(t.TempDir(), "geth", "chaindata", "ancient", "chain")
+		headers [][]byte
+		bodies  [][]byte
+		rcpts   [][]byte
+		parent  = make([]byte, 32)
+	)
+	diff.Test(t, t.Fatalf, os.MkdirAll(dir, 0755), nil)
+	for n := uint64(1); n <= 3; n++ {
+		h := header(n, parent)
+		parent = eth.Keccak(h)
+		headers = append(headers, h)
+		bodies = append(bodies, body())
+		rcpts = append(rcpts, receipts())
+	}
+	writeTable(t, dir, "headers", true, 1, headers, 700)
+	writeTable(t, dir, "bodies", true, 1, bodies, 700)
+	writeTable(t, dir, "receipts", true, 1, rcpts, 100)
+	return dir
+}
+
+func TestFreezer(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		dir     = testFreezer(t)
+		datadir = filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(dir))))
+	)
+	f, err := Open(datadir)
+	diff.Test(t, t.Fatalf, err, nil)
+	defer f.Close()
+
+	last, hash, err := f.Latest(ctx, "", 0)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, last, uint64(3))
+	h3, err := f.Hash(ctx, "", 3)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, hash, h3)
+	_, err = f.Hash(ctx, "", 0)
+	diff.Test(t, t.Errorf, err.Error(), "headers 0 was removed from the freezer")
+	_, err = f.Hash(ctx, "", 4)
+	diff.Test(t, t.Errorf, err != nil, true)
+
+	filter := glf.New([]string{"block_num", "tx_hash", "tx_gas_used", "log_addr", "log_idx"}, nil, nil)
+	blocks, err := f.Get(ctx, "", filter, 2, 2)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Fatalf, len(blocks), 2)
+	diff.Test(t, t.Errorf, blocks[0].Num(), uint64(2))
+	diff.Test(t, t.Errorf, blocks[1].Num(), uint64(3))
+	diff.Test(t, t.Errorf, []byte(blocks[1].Header.Parent), []byte(blocks[0].Hash()))
+	diff.Test(t, t.Errorf, blocks[1].Header.Time, eth.Uint64(1700000036))
+
+	txs := blocks[0].Txs
+	diff.Test(t, t.Fatalf, len(txs), 2)
+	diff.Test(t, t.Errorf, txs[0].Hash(), eth.Keccak(h2b(legacyTx)))
+	diff.Test(t, t.Errorf, txs[1].Hash(), eth.Keccak(dynamicFeeTx()))
+	diff.Test(t, t.Errorf, txs[1].Idx, eth.Uint64(1))
+	diff.Test(t, t.Errorf, txs[0].Status, eth.Byte(1))
+	diff.Test(t, t.Errorf, txs[1].Status, eth.Byte(0))
+	diff.Test(t, t.Errorf, txs[0].GasUsed, eth.Uint64(21000))
+	diff.Test(t, t.Errorf, txs[1].GasUsed, eth.Uint64(50000))
+	diff.Test(t, t.Errorf, txs[0].EffectiveGasPrice.Uint64(), uint64(20e9))
+	diff.Test(t, t.Errorf, txs[1].EffectiveGasPrice.Uint64(), uint64(12e9))
+	diff.Test(t, t.Errorf, txs[0].Logs[0].Idx, eth.Uint64(0))
+	diff.Test(t, t.Errorf, txs[1].Logs[0].Idx, eth.Uint64(1))
+	diff.Test(t, t.Errorf, []byte(txs[0].Logs[0].Address), bytesN(20, 0xcc))
+	diff.Test(t, t.Errorf, []byte(txs[0].Logs[0].Topics[0]), bytesN(32, 0xdd))
+	diff.Test(t, t.Errorf, string(txs[0].Logs[0].Data), "data")
+
+	signer, err := txs[0].Signer()
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, hex.EncodeToString(signer), "9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f")
+
+	filter = glf.New([]string{"block_num", "withdrawal_amount"}, nil, nil)
+	blocks, err = f.Get(ctx, "", filter, 1, 1)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, blocks[0].Withdrawals[0].Amount, eth.Uint64(32e9))
+	diff.Test(t, t.Errorf, blocks[0].Txs[0].Logs, eth.Logs(nil))
+
+	filter = glf.New([]string{"block_num", "block_time"}, nil, nil)
+	blocks, err = f.Get(ctx, "", filter, 1, 3)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, len(blocks[2].Txs), 0)
+
+	_, err = f.Get(ctx, "", filter, 3, 2)
+	diff.Test(t, t.Errorf, err != nil, true)
+}
+
+func TestContractAddress(t *testing.T) {
+	sender := h2b("6ac7ea33f8831ea9dcc53393aaa88b25a785dbf0")
+	diff.Test(t, t.Errorf, hex.EncodeToString(contractAddress(sender, 0)), "cd234a471b72ba2f1ccf0a70fcaba648a5eecd8d")
+	diff.Test(t, t.Errorf, hex.EncodeToString(contractAddress(sender, 1)), "343c43a37d37dff08ae8c4a11544c718abb4fcf8")
+}
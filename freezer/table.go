@@ -0,0 +1,152 @@
+package freezer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// A freezer table is an index file of 6 byte entries (a 2 byte
+// file number and a 4 byte offset, big-endian) and data files
+// of concatenated items. Entry i+1 is the end of item i. When
+// it's in a different file than entry i the item is at the
+// start of that file. The first entry holds the number of
+// items removed from the tail in its offset.
+//
+// Tables with a .cidx index have snappy compressed items.
+type table struct {
+	dir        string
+	name       string
+	compressed bool
+	index      *os.File
+	tail       uint64 // items removed from the tail
+	tailFile   uint32
+
+	mut   sync.Mutex
+	files map[uint32]*os.File
+}
+
+const entrySize = 6
+
+type entry struct {
+	file   uint32
+	offset uint32
+}
+
+func (e *entry) unmarshal(b []byte) {
+	e.file = uint32(binary.BigEndian.Uint16(b[:2]))
+	e.offset = binary.BigEndian.Uint32(b[2:6])
+}
+
+func openTable(dir, name string) (*table, error) {
+	t := &table{dir: dir, name: name, files: map[uint32]*os.File{}}
+	idx, err := os.Open(filepath.Join(dir, name+".ridx"))
+	if errors.Is(err, fs.ErrNotExist) {
+		t.compressed = true
+		idx, err = os.Open(filepath.Join(dir, name+".cidx"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening %s index: %w", name, err)
+	}
+	t.index = idx
+	var (
+		b     [entrySize]byte
+		first entry
+	)
+	if _, err := idx.ReadAt(b[:], 0); err != nil {
+		idx.Close()
+		return nil, fmt.Errorf("reading %s index: %w", name, err)
+	}
+	first.unmarshal(b[:])
+	t.tail, t.tailFile = uint64(first.offset), first.file
+	return t, nil
+}
+
+// Number of items including those removed from the tail.
+// The index is read each time since the node may be
+// appending to it.
+func (t *table) items() (uint64, error) {
+	fi, err := t.index.Stat()
+	if err != nil {
+		return 0, err
+	}
+	n := uint64(fi.Size() / entrySize)
+	if n == 0 {
+		return 0, nil
+	}
+	return t.tail + n - 1, nil
+}
+
+func (t *table) data(num uint32) (*os.File, error) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if f, ok := t.files[num]; ok {
+		return f, nil
+	}
+	ext := "rdat"
+	if t.compressed {
+		ext = "cdat"
+	}
+	f, err := os.Open(filepath.Join(t.dir, fmt.Sprintf("%s.%04d.%s", t.name, num, ext)))
+	if err != nil {
+		return nil, err
+	}
+	t.files[num] = f
+	return f, nil
+}
+
+func (t *table) item(n uint64) ([]byte, error) {
+	if n < t.tail {
+		return nil, fmt.Errorf("%s %d was removed from the freezer", t.name, n)
+	}
+	var (
+		i          = n - t.tail
+		b          [2 * entrySize]byte
+		start, end entry
+	)
+	if _, err := t.index.ReadAt(b[:], int64(i*entrySize)); err != nil {
+		return nil, fmt.Errorf("%s %d isn't in the freezer: %w", t.name, n, err)
+	}
+	start.unmarshal(b[:entrySize])
+	end.unmarshal(b[entrySize:])
+	if i == 0 {
+		start = entry{file: t.tailFile}
+	}
+	if start.file != end.file {
+		start = entry{file: end.file}
+	}
+	if end.offset < start.offset {
+		return nil, fmt.Errorf("%s %d: corrupt index", t.name, n)
+	}
+	f, err := t.data(end.file)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s data: %w", t.name, err)
+	}
+	d := make([]byte, end.offset-start.offset)
+	if _, err := f.ReadAt(d, int64(start.offset)); err != nil {
+		return nil, fmt.Errorf("reading %s %d: %w", t.name, n, err)
+	}
+	if !t.compressed {
+		return d, nil
+	}
+	d, err = s2.Decode(nil, d)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %s %d: %w", t.name, n, err)
+	}
+	return d, nil
+}
+
+func (t *table) close() {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	for _, f := range t.files {
+		f.Close()
+	}
+	t.index.Close()
+}
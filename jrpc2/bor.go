@@ -0,0 +1,39 @@
+package jrpc2
+
+import (
+	"encoding/binary"
+
+	"github.com/indexsupply/shovel/eth"
+)
+
+// Chain ids of Bor (Polygon PoS) networks: mainnet,
+// Mumbai, and Amoy
+func isBor(chainID uint64) bool {
+	switch chainID {
+	case 137, 80001, 80002:
+		return true
+	default:
+		return false
+	}
+}
+
+// Bor appends a pseudo-tx to blocks with state sync events
+// (eg bridged deposits). Its index is the number of txs in
+// the block and its logs and receipt are returned by
+// eth_getLogs and eth_getBlockReceipts but some providers
+// omit its hash (or return zeros). Returns h or, when it's
+// missing from the state-sync tx of a Bor chain, the hash
+// that Bor derives for the pseudo-tx:
+// keccak("matic-bor-receipt-" || uint64(num) || blockHash)
+func (c *Client) txHash(h, blockHash []byte, num uint64, stateSync bool) []byte {
+	if !stateSync || !isBor(c.wantChainID) {
+		return h
+	}
+	for _, b := range h {
+		if b != 0 {
+			return h
+		}
+	}
+	key := binary.BigEndian.AppendUint64([]byte("matic-bor-receipt-"), num)
+	return eth.Keccak(append(key, blockHash...))
+}
@@ -0,0 +1,94 @@
+package jrpc2
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/indexsupply/shovel/eth"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Returned when a url reports a different chain id than
+// the client's. See [Client.WithChainID].
+type ChainIDError struct {
+	Host      string
+	Got, Want uint64
+}
+
+func (e *ChainIDError) Error() string {
+	return fmt.Sprintf("%s is on chain %d, not %d", e.Host, e.Got, e.Want)
+}
+
+// Requests aren't sent to a url until its eth_chainId is id.
+// Each url is checked before its first request and again
+// after its circuit closes, so a misconfigured url (or a
+// provider that changed while it was down) fails like any
+// other error and its requests go to the source's other urls.
+// Zero disables the check.
+func (c *Client) WithChainID(id uint64) *Client {
+	c.wantChainID = id
+	return c
+}
+
+func (c *Client) unverified(u *URL, req any) bool {
+	if c.wantChainID == 0 || u.chainID.Load() == c.wantChainID {
+		return false
+	}
+	r, ok := req.(request)
+	return !ok || r.Method != "eth_chainId"
+}
+
+func (c *Client) verifyChainID(ctx context.Context, u *URL) error {
+	resp := struct {
+		Error  `json:"error"`
+		Result eth.Uint64 `json:"result"`
+	}{}
+	err := c.send(ctx, u.String(), &resp, request{
+		ID:      fmt.Sprintf("chainid-%x", randbytes()),
+		Version: "2.0",
+		Method:  "eth_chainId",
+		Params:  []any{},
+	})
+	switch {
+	case err != nil:
+		return fmt.Errorf("checking chain id: %w", err)
+	case resp.Error.Exists():
+		return fmt.Errorf("rpc=eth_chainId %w", resp.Error)
+	case uint64(resp.Result) != c.wantChainID:
+		return &ChainIDError{Host: u.Hostname(), Got: uint64(resp.Result), Want: c.wantChainID}
+	}
+	u.chainID.Store(c.wantChainID)
+	return nil
+}
+
+// Checks the chain id of each url that hasn't been verified.
+// Returns a [ChainIDError] when any url is on another chain.
+// Urls that can't be reached are logged and checked again
+// before their first request.
+func (c *Client) VerifyChainID(ctx context.Context) error {
+	if c.wantChainID == 0 {
+		return nil
+	}
+	var eg errgroup.Group
+	for _, u := range c.urls {
+		if u.chainID.Load() == c.wantChainID {
+			continue
+		}
+		u := u
+		eg.Go(func() error {
+			got, err := c.ChainID(ctx, u.String())
+			switch {
+			case err != nil:
+				slog.WarnContext(ctx, "chain-id-unverified", "host", u.Hostname(), "error", err)
+				return nil
+			case got != c.wantChainID:
+				return &ChainIDError{Host: u.Hostname(), Got: got, Want: c.wantChainID}
+			}
+			u.chainID.Store(got)
+			return nil
+		})
+	}
+	return eg.Wait()
+}
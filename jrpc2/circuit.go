@@ -0,0 +1,131 @@
+package jrpc2
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indexsupply/shovel/wprom"
+)
+
+var (
+	urlsMut sync.Mutex
+	urls    = map[string]*URL{}
+
+	mCircuit = wprom.NewGauge(
+		"shovel_rpc_circuit",
+		"circuit breaker state for an rpc host. 0=closed 1=half-open 2=open",
+		"src", "host",
+	)
+)
+
+type CircuitState int
+
+const (
+	Closed CircuitState = iota
+	HalfOpen
+	Open
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+func (s CircuitState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Returned without sending a request when
+// the url's circuit is open.
+type CircuitOpenError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for %s until %s", e.Host, e.Until.Format(time.TimeOnly))
+}
+
+func (u *URL) openError() error {
+	u.mut.Lock()
+	defer u.mut.Unlock()
+	return &CircuitOpenError{Host: u.Hostname(), Until: u.downUntil}
+}
+
+func (u *URL) State(now time.Time) CircuitState {
+	u.mut.Lock()
+	defer u.mut.Unlock()
+	switch {
+	case u.nfail == 0:
+		return Closed
+	case now.Before(u.downUntil):
+		return Open
+	default:
+		return HalfOpen
+	}
+}
+
+// Since requests aren't sent while the circuit is open,
+// failures are logged at most once per open period.
+func (u *URL) log(ctx context.Context, recovered bool, err error) {
+	switch {
+	case err != nil:
+		u.mut.Lock()
+		nfail, until := u.nfail, u.downUntil
+		u.mut.Unlock()
+		slog.ErrorContext(ctx, "rpc-circuit-open",
+			"host", u.Hostname(),
+			"nfail", nfail,
+			"until", until.Format(time.TimeOnly),
+			"error", err,
+		)
+	case recovered:
+		slog.InfoContext(ctx, "rpc-circuit-closed", "host", u.Hostname())
+	}
+}
+
+type Circuit struct {
+	Host  string       `json:"host"`
+	State CircuitState `json:"state"`
+	NFail int          `json:"nfail"`
+	Until time.Time    `json:"until"`
+}
+
+// Circuits that are open or half-open
+func Circuits() []Circuit {
+	urlsMut.Lock()
+	defer urlsMut.Unlock()
+	var (
+		now = time.Now()
+		res []Circuit
+	)
+	for _, u := range urls {
+		s := u.State(now)
+		if s == Closed {
+			continue
+		}
+		u.mut.Lock()
+		res = append(res, Circuit{
+			Host:  u.Hostname(),
+			State: s,
+			NFail: u.nfail,
+			Until: u.downUntil,
+		})
+		u.mut.Unlock()
+	}
+	slices.SortFunc(res, func(a, b Circuit) int {
+		return strings.Compare(a.Host, b.Host)
+	})
+	return res
+}
@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -25,9 +26,12 @@ import (
 	"github.com/indexsupply/shovel/eth"
 	"github.com/indexsupply/shovel/shovel/glf"
 	"github.com/indexsupply/shovel/wctx"
+	"github.com/indexsupply/shovel/wotel"
+	"github.com/indexsupply/shovel/wprom"
 
 	"github.com/goccy/go-json"
-	"github.com/klauspost/compress/gzhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 	"nhooyr.io/websocket"
 	"nhooyr.io/websocket/wsjson"
@@ -36,18 +40,101 @@ import (
 type URL struct {
 	parsed   *url.URL
 	provided string
+
+	// Circuit breaker. A failed request opens the circuit
+	// until downUntil, during which requests to the url fail
+	// fast and NextURL skips it so that a failing provider
+	// doesn't halt indexing. Each consecutive failure doubles
+	// the open period. After that a single request is allowed
+	// (half-open); on success the circuit is closed and nfail
+	// is reset.
+	mut       sync.Mutex
+	nfail     int
+	downUntil time.Time
+	probing   bool
+
+	// Set when the provider doesn't support
+	// eth_getBlockReceipts. See [Client.receipts].
+	noBlockReceipts atomic.Bool
+
+	// Set when the provider doesn't support
+	// trace_block. See [Client.WithTraceMethod].
+	noTraceBlock atomic.Bool
+
+	// The chain id the url reported. Zero until it's
+	// verified. See [Client.WithChainID].
+	chainID atomic.Uint64
+}
+
+const (
+	minDown = 5 * time.Second
+	maxDown = 5 * time.Minute
+)
+
+func (u *URL) down(now time.Time) bool {
+	u.mut.Lock()
+	defer u.mut.Unlock()
+	return now.Before(u.downUntil) || u.probing
+}
+
+// Reports whether a request may be sent. When the open
+// period has elapsed only the first caller is allowed.
+func (u *URL) allow(now time.Time) bool {
+	u.mut.Lock()
+	defer u.mut.Unlock()
+	switch {
+	case u.nfail == 0:
+		return true
+	case now.Before(u.downUntil), u.probing:
+		return false
+	default:
+		u.probing = true
+		return true
+	}
 }
 
+// Reports whether a successful request closed the circuit
+func (u *URL) report(now time.Time, err error) bool {
+	u.mut.Lock()
+	defer u.mut.Unlock()
+	u.probing = false
+	if err == nil {
+		recovered := u.nfail > 0
+		u.nfail = 0
+		u.downUntil = time.Time{}
+		return recovered
+	}
+	u.nfail++
+	d := maxDown
+	if u.nfail < 16 {
+		d = min(maxDown, minDown<<(u.nfail-1))
+	}
+	u.downUntil = now.Add(d)
+	return false
+}
+
+// URLs are shared by every client in the process so that
+// a circuit opened by one task applies to all of them.
 func MustURL(provided string) *URL {
+	urlsMut.Lock()
+	defer urlsMut.Unlock()
+	if u, ok := urls[provided]; ok {
+		return u
+	}
 	parsed, err := url.Parse(provided)
 	if err != nil {
 		fmt.Printf("unable to parse url: %s\n", provided)
 		os.Exit(1)
 	}
-	return &URL{parsed: parsed, provided: provided}
+	u := &URL{parsed: parsed, provided: provided}
+	urls[provided] = u
+	return u
 }
 
 func (u *URL) Hostname() string {
+	if u.parsed.Scheme == "ipc" {
+		return "ipc"
+	}
 	return u.parsed.Hostname()
 }
 
@@ -71,19 +158,23 @@ func New(providedURLs ...string) *Client {
 		nocache = strings.Contains(provided, "nocache")
 		urls = append(urls, MustURL(provided))
 	}
-	return &Client{
+	c := &Client{
 		d:       debug,
 		nocache: nocache,
 		hc: &http.Client{
 			Timeout:   10 * time.Second,
-			Transport: gzhttp.Transport(http.DefaultTransport),
+			Transport: Transport{}.roundTripper(),
 		},
 		urls:         urls,
 		pollDuration: time.Second,
+		follow:       "latest",
 		lcache:       NumHash{maxreads: 20},
 		bcache:       cache{maxreads: 20},
 		hcache:       cache{maxreads: 20},
+		shared:       shared{maxreads: 20},
+		bloom:        true,
 	}
+	return c.fixturesFromEnv()
 }
 
 type Client struct {
@@ -93,24 +184,135 @@ type Client struct {
 	urls    []*URL
 	wsurl   string
 
+	traceMethod string
+	limiter     *Limiter
+	follow      string
+
+	store    Store
+	chainID  uint64
+	finality uint64
+
 	reqCounter   uint64
 	pollDuration time.Duration
 
 	lcache NumHash
 	bcache cache
 	hcache cache
+	shared shared
+
+	// see [Client.WithLogFilter]
+	logFilter atomic.Pointer[glf.Filter]
+
+	// see [Client.WithBloom]
+	bloom bool
+
+	// sent with each http request and websocket dial
+	header http.Header
+
+	// dials the websocket. see [Client.WithTransport]
+	wshc *http.Client
+
+	// connections for ipc:// urls
+	ipc ipcPool
+
+	// see [Client.WithChainID]
+	wantChainID uint64
+
+	// see [Client.WithLenient]
+	lenient bool
 }
 
+// Round-robins through the urls skipping those that
+// have recently failed. If every url has failed
+// then the next url is returned regardless.
 func (c *Client) NextURL() *URL {
-	atomic.AddUint64(&c.reqCounter, 1)
-	next := c.reqCounter % uint64(len(c.urls))
-	return c.urls[next]
+	var (
+		now = time.Now()
+		n   = atomic.AddUint64(&c.reqCounter, 1)
+	)
+	for i := uint64(0); i < uint64(len(c.urls)); i++ {
+		u := c.urls[(n+i)%uint64(len(c.urls))]
+		if !u.down(now) {
+			return u
+		}
+	}
+	return c.urls[n%uint64(len(c.urls))]
 }
 
+func (c *Client) find(url string) *URL {
+	for _, u := range c.urls {
+		if u.String() == url {
+			return u
+		}
+	}
+	return nil
+}
+
+// Safe to call while the client is in use so that a
+// reloaded config can change the number of integrations
+// reading from the client.
 func (c *Client) WithMaxReads(n int) *Client {
+	c.lcache.Lock()
 	c.lcache.maxreads = n
-	c.bcache.maxreads = n
-	c.hcache.maxreads = n
+	c.lcache.Unlock()
+	for _, bc := range []*cache{&c.bcache, &c.hcache} {
+		bc.Lock()
+		bc.maxreads = n
+		bc.Unlock()
+	}
+	c.shared.mut.Lock()
+	c.shared.maxreads = n
+	c.shared.mut.Unlock()
+	return c
+}
+
+// Logs requests whose filter is covered by f (see
+// [glf.Filter.Covers]) use f instead so that integrations
+// reading the same block range share one eth_getLogs
+// request. The extra logs are ignored by the integrations
+// that don't need them.
+//
+// An f without addresses or topics removes the filter
+// since it would request every log. Safe to call while
+// the client is in use.
+func (c *Client) WithLogFilter(f glf.Filter) *Client {
+	if len(f.Addresses()) == 0 && len(f.Topics()) == 0 {
+		c.logFilter.Store(nil)
+		return c
+	}
+	c.logFilter.Store(&f)
+	return c
+}
+
+// When enabled (the default) receipts are only requested
+// for blocks whose logs bloom may match the filter's
+// addresses and topics. See [glf.Filter.MayMatch].
+func (c *Client) WithBloom(enabled bool) *Client {
+	c.bloom = enabled
+	return c
+}
+
+// Sets headers (eg an api key) on requests to the
+// client's urls, including the websocket url
+func (c *Client) WithHeaders(h map[string]string) *Client {
+	for k, v := range h {
+		if c.header == nil {
+			c.header = http.Header{}
+		}
+		c.header.Set(k, v)
+	}
+	return c
+}
+
+func (c *Client) WithBasicAuth(username, password string) *Client {
+	if len(username) == 0 {
+		return c
+	}
+	if c.header == nil {
+		c.header = http.Header{}
+	}
+	r := http.Request{Header: c.header}
+	r.SetBasicAuth(username, password)
 	return c
 }
 
@@ -124,6 +326,38 @@ func (c *Client) WithWSURL(url string) *Client {
 	return c
 }
 
+// Requests wait for l before they are sent. The same
+// Limiter may be used by many clients.
+func (c *Client) WithLimiter(l *Limiter) *Client {
+	c.limiter = l
+	return c
+}
+
+// Sets the block tag used by Latest: latest (the default),
+// safe, or finalized. Following safe or finalized blocks
+// avoids reorgs at the cost of latency. Since newHeads only
+// reports the latest block, the websocket url isn't used
+// unless following latest.
+func (c *Client) WithFollow(tag string) *Client {
+	if len(tag) > 0 {
+		c.follow = tag
+	}
+	return c
+}
+
+// Sets the rpc method used to load traces: trace_block,
+// trace_filter, or debug_traceBlockByNumber (which uses geth's
+// callTracer). trace_filter requests a range of blocks in a
+// single call. When unset, trace_block is used unless the
+// provider doesn't support it, in which case
+// debug_traceBlockByNumber is used.
+func (c *Client) WithTraceMethod(method string) *Client {
+	if len(method) > 0 {
+		c.traceMethod = method
+	}
+	return c
+}
+
 func (c *Client) debug(r io.Reader) io.Reader {
 	if !c.d {
 		return r
@@ -138,7 +372,95 @@ type request struct {
 	Params  []any  `json:"params"`
 }
 
+var mErrors = wprom.NewCounter("shovel_rpc_errors_total", "number of failed rpc requests", "src", "host")
+
 func (c *Client) do(ctx context.Context, url string, dest, req any) error {
+	ctx, span := wotel.Tracer.Start(ctx, "rpc",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("host", hostname(url)),
+			attribute.StringSlice("methods", methods(req)),
+		),
+	)
+	u := c.find(url)
+	if u != nil && !u.allow(time.Now()) {
+		err := u.openError()
+		wotel.End(span, err)
+		return err
+	}
+	if c.limiter != nil {
+		if err := c.limiter.Wait(ctx, ncalls(req)); err != nil {
+			wotel.End(span, err)
+			return err
+		}
+	}
+	var err error
+	if u != nil && c.unverified(u, req) {
+		err = c.verifyChainID(ctx, u)
+	}
+	if err == nil {
+		err = c.send(ctx, url, dest, req)
+	}
+	if err != nil {
+		mErrors.Inc(wctx.SrcName(ctx), hostname(url))
+	}
+	if u != nil {
+		recovered := u.report(time.Now(), err)
+		if recovered {
+			// the provider may have changed while it was down
+			u.chainID.Store(0)
+		}
+		u.log(ctx, recovered, err)
+		mCircuit.Set(uint64(u.State(time.Now())), wctx.SrcName(ctx), u.Hostname())
+	}
+	wotel.End(span, err)
+	return err
+}
+
+func (c *Client) send(ctx context.Context, url string, dest, req any) error {
+	if isIPC(url) {
+		return c.doIPC(ctx, url, dest, req)
+	}
+	return c.doHTTP(ctx, url, dest, req)
+}
+
+// Distinct method names in a request or batch
+func methods(req any) []string {
+	switch r := req.(type) {
+	case request:
+		return []string{r.Method}
+	case []request:
+		var res []string
+		for i := range r {
+			if !slices.Contains(res, r[i].Method) {
+				res = append(res, r[i].Method)
+			}
+		}
+		return res
+	default:
+		return nil
+	}
+}
+
+func ncalls(req any) int {
+	if r, ok := req.([]request); ok {
+		return len(r)
+	}
+	return 1
+}
+
+func hostname(s string) string {
+	if isIPC(s) {
+		return "ipc"
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+func (c *Client) doHTTP(ctx context.Context, url string, dest, req any) error {
 	var (
 		eg   errgroup.Group
 		r, w = io.Pipe()
@@ -153,6 +475,9 @@ func (c *Client) do(ctx context.Context, url string, dest, req any) error {
 		if err != nil {
 			return fmt.Errorf("unable to new request: %w", err)
 		}
+		for k, v := range c.header {
+			req.Header[k] = v
+		}
 		req.Header.Add("content-type", "application/json")
 		resp, err = c.hc.Do(req)
 		if err != nil {
@@ -163,6 +488,11 @@ func (c *Client) do(ctx context.Context, url string, dest, req any) error {
 	if err := eg.Wait(); err != nil {
 		return err
 	}
+	defer func() {
+		// the connection is only reused once the body is read
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
 	if resp.StatusCode/100 != 2 {
 		b, _ := io.ReadAll(resp.Body)
 		text := strings.Map(func(r rune) rune {
@@ -174,8 +504,7 @@ func (c *Client) do(ctx context.Context, url string, dest, req any) error {
 		const msg = "rpc http error: %d %.100s"
 		return fmt.Errorf(msg, resp.StatusCode, text)
 	}
-	defer resp.Body.Close()
-	if err := json.NewDecoder(c.debug(resp.Body)).Decode(dest); err != nil {
+	if err := c.decode(json.NewDecoder(c.debug(resp.Body)), dest); err != nil {
 		return fmt.Errorf("unable to json decode: %w", err)
 	}
 	wctx.CounterAdd(ctx, 1)
@@ -201,10 +530,22 @@ type NumHash struct {
 	once     sync.Once
 	maxreads int
 	nreads   int
+	changed  chan struct{}
 	Num      eth.Uint64 `json:"number"`
 	Hash     eth.Bytes  `json:"hash"`
 }
 
+// Returns a channel that is closed the next
+// time a greater block number is cached.
+func (nh *NumHash) wait() <-chan struct{} {
+	nh.Lock()
+	defer nh.Unlock()
+	if nh.changed == nil {
+		nh.changed = make(chan struct{})
+	}
+	return nh.changed
+}
+
 func (nh *NumHash) error(err error) {
 	nh.Lock()
 	nh.nreads = 0
@@ -221,6 +562,10 @@ func (nh *NumHash) update(n eth.Uint64, h []byte) {
 	nh.nreads = 0
 	nh.Num = n
 	nh.Hash.Write(h)
+	if nh.changed != nil {
+		close(nh.changed)
+		nh.changed = nil
+	}
 }
 
 func (nh *NumHash) get(ctx context.Context, n uint64) (uint64, []byte, bool) {
@@ -272,7 +617,10 @@ func (c *Client) wsListen(ctx context.Context) {
 	ctx, cancel := context.WithTimeout(ctx, time.Minute)
 	defer cancel()
 
-	wsc, _, err := websocket.Dial(ctx, c.wsurl, nil)
+	wsc, _, err := websocket.Dial(ctx, c.wsurl, &websocket.DialOptions{
+		HTTPClient: c.wshc,
+		HTTPHeader: c.header,
+	})
 	if err != nil {
 		c.lcache.error(fmt.Errorf("ws dial %q: %w", c.wsurl, err))
 		return
@@ -317,17 +665,21 @@ func (c *Client) httpPoll(ctx context.Context, url string) {
 			ID:      "1",
 			Version: "2.0",
 			Method:  "eth_getBlockByNumber",
-			Params:  []any{"latest", false},
+			Params:  []any{c.follow, false},
 		})
 		if err != nil {
 			c.lcache.error(err)
 			return
 		}
 		if hresp.Error.Exists() {
-			const tag = "eth_getBlockByNumber/latest"
+			tag := "eth_getBlockByNumber/" + c.follow
 			c.lcache.error(fmt.Errorf("rpc=%s %w", tag, hresp.Error))
 			return
 		}
+		if hresp.Header == nil {
+			c.lcache.error(fmt.Errorf("no %s block", c.follow))
+			return
+		}
 		slog.DebugContext(ctx, "http poll",
 			"n", hresp.Number,
 			"h", fmt.Sprintf("%.4x", hresp.Hash),
@@ -336,6 +688,13 @@ func (c *Client) httpPoll(ctx context.Context, url string) {
 	}
 }
 
+// Returns a channel that is closed when a new head is
+// received from the websocket subscription (or http poll).
+// Allows callers to wait for new blocks instead of sleeping.
+func (c *Client) NewHeads() <-chan struct{} {
+	return c.lcache.wait()
+}
+
 // Returns the latest block number/hash greater than n.
 // If n is lower than the cached block number,
 // returns the cached value; otherwise, fetches the
@@ -348,7 +707,7 @@ func (c *Client) httpPoll(ctx context.Context, url string) {
 func (c *Client) Latest(ctx context.Context, url string, n uint64) (uint64, []byte, error) {
 	c.lcache.once.Do(func() {
 		switch {
-		case len(c.wsurl) > 0:
+		case len(c.wsurl) > 0 && c.follow == "latest":
 			slog.DebugContext(ctx, "jrpc2 ws listening")
 			go c.wsListen(context.Background())
 		default:
@@ -365,15 +724,18 @@ func (c *Client) Latest(ctx context.Context, url string, n uint64) (uint64, []by
 		ID:      fmt.Sprintf("latest-%d-%x", n, randbytes()),
 		Version: "2.0",
 		Method:  "eth_getBlockByNumber",
-		Params:  []any{"latest", false},
+		Params:  []any{c.follow, false},
 	})
 	if err != nil {
 		return 0, nil, fmt.Errorf("unable request latest: %w", err)
 	}
 	if hresp.Error.Exists() {
-		const tag = "eth_getBlockByNumber/latest"
+		tag := "eth_getBlockByNumber/" + c.follow
 		return 0, nil, fmt.Errorf("rpc=%s %w", tag, hresp.Error)
 	}
+	if hresp.Header == nil {
+		return 0, nil, fmt.Errorf("no %s block", c.follow)
+	}
 	slog.DebugContext(ctx, "http-get-latest",
 		"n", hresp.Number,
 		"h", fmt.Sprintf("%.4x", hresp.Hash),
@@ -400,6 +762,26 @@ func (c *Client) Hash(ctx context.Context, url string, n uint64) ([]byte, error)
 	return hresp.Hash, nil
 }
 
+func (c *Client) ChainID(ctx context.Context, url string) (uint64, error) {
+	resp := struct {
+		Error  `json:"error"`
+		Result eth.Uint64 `json:"result"`
+	}{}
+	err := c.do(ctx, url, &resp, request{
+		ID:      fmt.Sprintf("chainid-%x", randbytes()),
+		Version: "2.0",
+		Method:  "eth_chainId",
+		Params:  []any{},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable request chain id: %w", err)
+	}
+	if resp.Error.Exists() {
+		return 0, fmt.Errorf("rpc=eth_chainId %w", resp.Error)
+	}
+	return uint64(resp.Result), nil
+}
+
 type key struct {
 	a, b uint64
 }
@@ -435,6 +817,13 @@ func (c *Client) Get(
 		if err != nil {
 			return nil, fmt.Errorf("getting headers: %w", err)
 		}
+	case filter.UseReceipts && c.bloom && filter.Screens():
+		// headers aren't cached since receipts are
+		// added to them. see [Client.bloomReceipts]
+		blocks, err = c.headers(ctx, url, start, limit)
+		if err != nil {
+			return nil, fmt.Errorf("getting headers: %w", err)
+		}
 	default:
 		for i := uint64(0); i < limit; i++ {
 			blocks = append(blocks, eth.Block{
@@ -451,6 +840,10 @@ func (c *Client) Get(
 	}
 
 	switch {
+	case filter.UseReceipts && c.bloom && filter.Screens():
+		if err := c.bloomReceipts(ctx, url, filter, bm, blocks); err != nil {
+			return nil, fmt.Errorf("getting receipts: %w", err)
+		}
 	case filter.UseReceipts:
 		if err := c.receipts(ctx, url, bm, start, limit); err != nil {
 			return nil, fmt.Errorf("getting receipts: %w", err)
@@ -460,7 +853,7 @@ func (c *Client) Get(
 			return nil, fmt.Errorf("getting logs: %w", err)
 		}
 	case filter.UseTraces:
-		if err := c.traces(ctx, url, bm, start, limit); err != nil {
+		if err := c.allTraces(ctx, url, bm, start, limit); err != nil {
 			return nil, fmt.Errorf("getting traces: %w", err)
 		}
 	}
@@ -553,6 +946,7 @@ func (c *Client) blocks(ctx context.Context, url string, start, limit uint64) ([
 		t0     = time.Now()
 		reqs   = make([]request, limit)
 		resps  = make([]blockResp, limit)
+		dest   = make([]any, limit)
 		blocks = make([]eth.Block, limit)
 	)
 	for i := uint64(0); i < limit; i++ {
@@ -563,8 +957,9 @@ func (c *Client) blocks(ctx context.Context, url string, start, limit uint64) ([
 			Params:  []any{eth.EncodeUint64(start + i), true},
 		}
 		resps[i].Block = &blocks[i]
+		dest[i] = &resps[i]
 	}
-	err := c.do(ctx, url, &resps, reqs)
+	err := c.doBlocks(ctx, url, "blocks", start, reqs, dest)
 	if err != nil {
 		return nil, fmt.Errorf("requesting blocks: %w", err)
 	}
@@ -617,6 +1012,7 @@ func (c *Client) headers(ctx context.Context, url string, start, limit uint64) (
 		t0     = time.Now()
 		reqs   = make([]request, limit)
 		resps  = make([]headerResp, limit)
+		dest   = make([]any, limit)
 		blocks = make([]eth.Block, limit)
 	)
 	for i := uint64(0); i < limit; i++ {
@@ -627,8 +1023,9 @@ func (c *Client) headers(ctx context.Context, url string, start, limit uint64) (
 			Params:  []any{eth.EncodeUint64(start + i), false},
 		}
 		resps[i].Header = &blocks[i].Header
+		dest[i] = &resps[i]
 	}
-	err := c.do(ctx, url, &resps, reqs)
+	err := c.doBlocks(ctx, url, "headers", start, reqs, dest)
 	if err != nil {
 		return nil, fmt.Errorf("requesting headers: %w", err)
 	}
@@ -652,8 +1049,11 @@ type receiptResult struct {
 	TxTo              eth.Bytes   `json:"to"`
 	Status            eth.Byte    `json:"status"`
 	GasUsed           eth.Uint64  `json:"gasUsed"`
+	CumulativeGasUsed eth.Uint64  `json:"cumulativeGasUsed"`
 	EffectiveGasPrice uint256.Int `json:"effectiveGasPrice"`
+	ContractAddress   eth.Bytes   `json:"contractAddress"`
 	Logs              eth.Logs    `json:"logs"`
+	L1BatchTxIdx      eth.Uint64  `json:"l1BatchTxIndex"`
 }
 
 type receiptResp struct {
@@ -661,10 +1061,19 @@ type receiptResp struct {
 	Result []receiptResult `json:"result"`
 }
 
+// Uses eth_getBlockReceipts unless the url's provider doesn't
+// support it, in which case receipts are requested per-tx using
+// eth_getTransactionReceipt. Support is learned from the first
+// request and remembered for the life of the process.
 func (c *Client) receipts(ctx context.Context, url string, bm blockmap, start, limit uint64) error {
+	u := c.find(url)
+	if u != nil && u.noBlockReceipts.Load() {
+		return c.txReceipts(ctx, url, bm, start, limit)
+	}
 	var (
 		reqs  = make([]request, limit)
 		resps = make([]receiptResp, limit)
+		dest  = make([]any, limit)
 	)
 	for i := uint64(0); i < limit; i++ {
 		reqs[i] = request{
@@ -673,12 +1082,21 @@ func (c *Client) receipts(ctx context.Context, url string, bm blockmap, start, l
 			Method:  "eth_getBlockReceipts",
 			Params:  []any{eth.EncodeUint64(start + i)},
 		}
+		dest[i] = &resps[i]
 	}
-	err := c.do(ctx, url, &resps, reqs)
+	err := c.doBlocks(ctx, url, "receipts", start, reqs, dest)
 	if err != nil {
 		return fmt.Errorf("requesting receipts: %w", err)
 	}
 	for i := range resps {
+		if u != nil && unsupported(resps[i].Error) {
+			slog.InfoContext(ctx, "rpc-block-receipts-unsupported",
+				"host", u.Hostname(),
+				"error", resps[i].Error,
+			)
+			u.noBlockReceipts.Store(true)
+			return c.txReceipts(ctx, url, bm, start, limit)
+		}
 		if resps[i].Error.Exists() {
 			const tag = "eth_getBlockReceipts"
 			return fmt.Errorf("rpc=%s %w", tag, resps[i].Error)
@@ -689,29 +1107,158 @@ func (c *Client) receipts(ctx context.Context, url string, bm blockmap, start, l
 			slog.ErrorContext(ctx, "no rpc error but empty result")
 			continue
 		}
-		blockNum := uint64(resps[i].Result[0].BlockNum)
-		if blockNum < start || blockNum > start+limit {
-			const tag = "eth_getBlockReceipts out of range block. num=%d start=%d lim=%d"
-			return fmt.Errorf(tag, blockNum, start, limit)
+		if err := c.setReceipts(bm, start, limit, resps[i].Result); err != nil {
+			return err
 		}
-		b, ok := bm[blockNum]
-		if !ok {
-			return fmt.Errorf("block not found")
+	}
+	return nil
+}
+
+var mBloomSkips = wprom.NewCounter("shovel_rpc_bloom_skips_total", "blocks whose receipts weren't requested because of their logs bloom", "src")
+
+// Requests receipts for each run of consecutive blocks
+// whose logs bloom may match the filter. Blocks that can't
+// contain any of the filter's logs are left without txs.
+func (c *Client) bloomReceipts(ctx context.Context, url string, filter *glf.Filter, bm blockmap, blocks []eth.Block) error {
+	var nskip uint64
+	for i := 0; i < len(blocks); {
+		if !filter.MayMatch(blocks[i].LogsBloom) {
+			nskip++
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(blocks) && filter.MayMatch(blocks[j].LogsBloom) {
+			j++
 		}
-		b.Header.Hash.Write(resps[i].Result[0].BlockHash)
-		for j := range resps[i].Result {
-			tx := b.Tx(uint64(resps[i].Result[j].TxIdx))
-			tx.PrecompHash.Write(resps[i].Result[j].TxHash)
-			tx.Type.Write(byte(resps[i].Result[j].TxType))
-			tx.From.Write(resps[i].Result[j].TxFrom)
-			tx.To.Write(resps[i].Result[j].TxTo)
-			tx.Status.Write(byte(resps[i].Result[j].Status))
-			tx.GasUsed = resps[i].Result[j].GasUsed
-			tx.EffectiveGasPrice = resps[i].Result[j].EffectiveGasPrice
-			tx.Logs = make([]eth.Log, len(resps[i].Result[j].Logs))
-			copy(tx.Logs, resps[i].Result[j].Logs)
+		if err := c.receipts(ctx, url, bm, blocks[i].Num(), uint64(j-i)); err != nil {
+			return err
 		}
+		i = j
 	}
+	mBloomSkips.Add(nskip, wctx.SrcName(ctx))
+	return nil
+}
+
+// Reports whether e is a provider's response
+// to a method that it doesn't implement.
+func unsupported(e Error) bool {
+	if e.Code == -32601 {
+		return true
+	}
+	msg := strings.ToLower(e.Message)
+	return strings.Contains(msg, "method not found") ||
+		strings.Contains(msg, "does not exist") ||
+		strings.Contains(msg, "not supported")
+}
+
+// res contains the receipts for a single block
+func (c *Client) setReceipts(bm blockmap, start, limit uint64, res []receiptResult) error {
+	blockNum := uint64(res[0].BlockNum)
+	if blockNum < start || blockNum > start+limit {
+		const tag = "receipts out of range block. num=%d start=%d lim=%d"
+		return fmt.Errorf(tag, blockNum, start, limit)
+	}
+	b, ok := bm[blockNum]
+	if !ok {
+		return fmt.Errorf("block not found")
+	}
+	b.Header.Hash.Write(res[0].BlockHash)
+	for j := range res {
+		var (
+			idx       = uint64(res[j].TxIdx)
+			tx        = b.Tx(idx)
+			stateSync = j == len(res)-1 && idx == uint64(len(res)-1)
+		)
+		tx.PrecompHash.Write(c.txHash(res[j].TxHash, res[0].BlockHash, blockNum, stateSync))
+		tx.Type.Write(byte(res[j].TxType))
+		tx.From.Write(res[j].TxFrom)
+		tx.To.Write(res[j].TxTo)
+		tx.Status.Write(byte(res[j].Status))
+		tx.GasUsed = res[j].GasUsed
+		tx.CumulativeGasUsed = res[j].CumulativeGasUsed
+		tx.EffectiveGasPrice = res[j].EffectiveGasPrice
+		tx.ContractAddress.Write(res[j].ContractAddress)
+		tx.L1BatchTxIdx = res[j].L1BatchTxIdx
+		tx.Logs = make([]eth.Log, len(res[j].Logs))
+		copy(tx.Logs, res[j].Logs)
+	}
+	return nil
+}
+
+// Max eth_getTransactionReceipt calls in a single batch.
+// Providers commonly reject larger batches.
+const txReceiptsBatch = 500
+
+type txReceiptResp struct {
+	Error  `json:"error"`
+	Result *receiptResult `json:"result"`
+}
+
+// Requests the block's tx hashes and then each tx's receipt
+func (c *Client) txReceipts(ctx context.Context, url string, bm blockmap, start, limit uint64) error {
+	var (
+		t0     = time.Now()
+		reqs   = make([]request, limit)
+		bresps = make([]blockTxsResp, limit)
+	)
+	for i := uint64(0); i < limit; i++ {
+		reqs[i] = request{
+			ID:      fmt.Sprintf("txs-%d-%d-%x", start, limit, randbytes()),
+			Version: "2.0",
+			Method:  "eth_getBlockByNumber",
+			Params:  []any{eth.EncodeUint64(start + i), false},
+		}
+	}
+	if err := c.do(ctx, url, &bresps, reqs); err != nil {
+		return fmt.Errorf("requesting tx hashes: %w", err)
+	}
+	var hashes []eth.Bytes
+	for i := range bresps {
+		switch {
+		case bresps[i].Error.Exists():
+			return fmt.Errorf("rpc=eth_getBlockByNumber %w", bresps[i].Error)
+		case bresps[i].Result == nil:
+			return fmt.Errorf("eth backend missing block: %d", start+uint64(i))
+		}
+		hashes = append(hashes, bresps[i].Result.Txs...)
+	}
+	byBlock := map[uint64][]receiptResult{}
+	for len(hashes) > 0 {
+		n := min(len(hashes), txReceiptsBatch)
+		var (
+			reqs  = make([]request, n)
+			resps = make([]txReceiptResp, n)
+		)
+		for i := range reqs {
+			reqs[i] = request{
+				ID:      fmt.Sprintf("tx-receipt-%d-%x", start, randbytes()),
+				Version: "2.0",
+				Method:  "eth_getTransactionReceipt",
+				Params:  []any{hashes[i]},
+			}
+		}
+		if err := c.do(ctx, url, &resps, reqs); err != nil {
+			return fmt.Errorf("requesting tx receipts: %w", err)
+		}
+		for i := range resps {
+			switch {
+			case resps[i].Error.Exists():
+				return fmt.Errorf("rpc=eth_getTransactionReceipt %w", resps[i].Error)
+			case resps[i].Result == nil:
+				return fmt.Errorf("eth backend missing receipt: %x", hashes[i])
+			}
+			num := uint64(resps[i].Result.BlockNum)
+			byBlock[num] = append(byBlock[num], *resps[i].Result)
+		}
+		hashes = hashes[n:]
+	}
+	for _, res := range byBlock {
+		if err := c.setReceipts(bm, start, limit, res); err != nil {
+			return err
+		}
+	}
+	slog.DebugContext(ctx, "http-get-tx-receipts", "elapsed", time.Since(t0))
 	return nil
 }
 
@@ -749,8 +1296,13 @@ func (c *Client) logs(ctx context.Context, url string, filter *glf.Filter, bm bl
 			&headerResp{},
 			&logResp{},
 		}
+		union = c.logFilter.Load()
+		share = union != nil && !c.nocache && union.Covers(*filter)
 	)
-	err := c.do(ctx, url, &resp, []request{
+	if share {
+		lf.Address, lf.Topics = union.Addresses(), union.Topics()
+	}
+	reqs := []request{
 		request{
 			ID:      fmt.Sprintf("blocks-%d-%d-%x", start, limit, randbytes()),
 			Version: "2.0",
@@ -763,7 +1315,18 @@ func (c *Client) logs(ctx context.Context, url string, filter *glf.Filter, bm bl
 			Method:  "eth_getLogs",
 			Params:  []any{lf},
 		},
-	})
+	}
+	var err error
+	switch {
+	case share:
+		var raws []json.RawMessage
+		raws, err = c.doShared(ctx, url, "logs", fmt.Sprintf("logs-%d-%d", start, limit), reqs)
+		for i := 0; err == nil && i < len(resp); i++ {
+			err = json.Unmarshal(raws[i], resp[i])
+		}
+	default:
+		err = c.do(ctx, url, &resp, reqs)
+	}
 	if err != nil {
 		return fmt.Errorf("making logs request: %w", err)
 	}
@@ -779,7 +1342,12 @@ func (c *Client) logs(ctx context.Context, url string, filter *glf.Filter, bm bl
 	case hresp.Header == nil:
 		return fmt.Errorf("eth backend missing logs for block: %d", toBlock)
 	}
-	var logsByTx = map[key][]logResult{}
+	var (
+		logsByTx = map[key][]logResult{}
+		// The block's txs aren't loaded so the tx with the
+		// highest index stands in for the block's last tx
+		lastTx = map[uint64]uint64{}
+	)
 	for i := range lresp.Result {
 		var (
 			blockNum = uint64(lresp.Result[i].BlockNum)
@@ -790,6 +1358,7 @@ func (c *Client) logs(ctx context.Context, url string, filter *glf.Filter, bm bl
 			const tag = "eth_getLogs out of range block. num=%d start=%d lim=%d"
 			return fmt.Errorf(tag, blockNum, start, limit)
 		}
+		lastTx[blockNum] = max(lastTx[blockNum], txIdx)
 		if logs, ok := logsByTx[k]; ok {
 			logsByTx[k] = append(logs, lresp.Result[i])
 			continue
@@ -805,7 +1374,7 @@ func (c *Client) logs(ctx context.Context, url string, filter *glf.Filter, bm bl
 		b.Lock()
 		b.Header.Hash.Write(logs[0].BlockHash)
 		tx := b.Tx(k.b)
-		tx.PrecompHash.Write(logs[0].TxHash)
+		tx.PrecompHash.Write(c.txHash(logs[0].TxHash, logs[0].BlockHash, k.a, k.b == lastTx[k.a]))
 		for i := range logs {
 			tx.Logs.Add(logs[i].Log)
 		}
@@ -819,11 +1388,13 @@ func (c *Client) logs(ctx context.Context, url string, filter *glf.Filter, bm bl
 }
 
 type traceBlockResult struct {
-	BlockHash eth.Bytes       `json:"blockHash"`
-	BlockNum  uint64          `json:"blockNumber"`
-	TxHash    eth.Bytes       `json:"transactionHash"`
-	TxIdx     uint64          `json:"transactionPosition"`
-	Action    eth.TraceAction `json:"action"`
+	BlockHash    eth.Bytes       `json:"blockHash"`
+	BlockNum     uint64          `json:"blockNumber"`
+	TxHash       eth.Bytes       `json:"transactionHash"`
+	TxIdx        uint64          `json:"transactionPosition"`
+	TraceAddress []uint64        `json:"traceAddress"`
+	Err          string          `json:"error"`
+	Action       eth.TraceAction `json:"action"`
 }
 
 type traceBlockResp struct {
@@ -831,53 +1402,274 @@ type traceBlockResp struct {
 	Result []traceBlockResult `json:"result"`
 }
 
+// Like receipts, the range is requested in one batch
 func (c *Client) traces(ctx context.Context, url string, bm blockmap, start, limit uint64) error {
-	t0 := time.Now()
+	var (
+		t0    = time.Now()
+		reqs  = make([]request, limit)
+		resps = make([]traceBlockResp, limit)
+	)
 	for i := uint64(0); i < limit; i++ {
-		res := traceBlockResp{}
-		req := request{
+		reqs[i] = request{
 			ID:      fmt.Sprintf("traces-%d-%d-%x", start, limit, randbytes()),
 			Version: "2.0",
 			Method:  "trace_block",
 			Params:  []any{eth.EncodeUint64(start + i)},
 		}
-		err := c.do(ctx, url, &res, req)
-		if err != nil {
-			return fmt.Errorf("requesting traces: %w", err)
-		}
-		if res.Error.Exists() {
+	}
+	err := c.do(ctx, url, &resps, reqs)
+	if err != nil {
+		return fmt.Errorf("requesting traces: %w", err)
+	}
+	if len(resps) != len(reqs) {
+		return fmt.Errorf("trace_block: expected %d responses got %d", len(reqs), len(resps))
+	}
+	for i := range resps {
+		if resps[i].Error.Exists() {
 			const tag = "trace_block"
-			return fmt.Errorf("rpc=%s %w", tag, res.Error)
+			return fmt.Errorf("rpc=%s %w", tag, resps[i].Error)
 		}
-		if len(res.Result) == 0 {
+		if len(resps[i].Result) == 0 {
 			return fmt.Errorf("no rpc error but empty result")
 		}
-		block, ok := bm[res.Result[0].BlockNum]
-		if !ok {
-			return fmt.Errorf("missing block in block map")
+		if err := setTraces(bm, resps[i].Result); err != nil {
+			return err
 		}
-		block.Header.Hash.Write(res.Result[0].BlockHash)
+	}
+	slog.DebugContext(ctx, "http-get-traces", "elapsed", time.Since(t0))
+	return nil
+}
 
-		var tracesByTx = map[key][]traceBlockResult{}
-		for i := range res.Result {
-			k := key{block.Num(), uint64(res.Result[i].TxIdx)}
-			if traces, ok := tracesByTx[k]; ok {
-				tracesByTx[k] = append(traces, res.Result[i])
-				continue
-			}
-			tracesByTx[k] = []traceBlockResult{res.Result[i]}
-		}
-		for k, traces := range tracesByTx {
-			tx := block.Tx(k.b)
-			tx.PrecompHash.Write(traces[0].TxHash)
-			tx.TraceActions = make([]eth.TraceAction, len(traces))
-			for i := range traces {
-				ta := traces[i].Action
-				ta.Idx = uint64(i)
-				tx.TraceActions[i] = ta
+// res contains the traces for a single block
+func setTraces(bm blockmap, res []traceBlockResult) error {
+	block, ok := bm[res[0].BlockNum]
+	if !ok {
+		return fmt.Errorf("missing block in block map")
+	}
+	block.Header.Hash.Write(res[0].BlockHash)
+
+	var tracesByTx = map[key][]traceBlockResult{}
+	for i := range res {
+		k := key{block.Num(), uint64(res[i].TxIdx)}
+		if traces, ok := tracesByTx[k]; ok {
+			tracesByTx[k] = append(traces, res[i])
+			continue
+		}
+		tracesByTx[k] = []traceBlockResult{res[i]}
+	}
+	for k, traces := range tracesByTx {
+		tx := block.Tx(k.b)
+		tx.PrecompHash.Write(traces[0].TxHash)
+		tx.TraceActions = make([]eth.TraceAction, len(traces))
+		for i := range traces {
+			ta := traces[i].Action
+			ta.Idx = uint64(i)
+			ta.Depth = uint64(len(traces[i].TraceAddress))
+			ta.Error = traces[i].Err
+			tx.TraceActions[i] = ta
+		}
+	}
+	return nil
+}
+
+func (c *Client) allTraces(ctx context.Context, url string, bm blockmap, start, limit uint64) error {
+	switch c.traceMethod {
+	case "trace_block":
+		return c.traces(ctx, url, bm, start, limit)
+	case "trace_filter":
+		return c.filterTraces(ctx, url, bm, start, limit)
+	case "debug_traceBlockByNumber":
+		return c.callTraces(ctx, url, bm, start, limit)
+	}
+	u := c.find(url)
+	if u != nil && u.noTraceBlock.Load() {
+		return c.callTraces(ctx, url, bm, start, limit)
+	}
+	err := c.traces(ctx, url, bm, start, limit)
+	var rpcErr Error
+	if u != nil && errors.As(err, &rpcErr) && unsupported(rpcErr) {
+		slog.InfoContext(ctx, "rpc-trace-block-unsupported",
+			"host", u.Hostname(),
+			"error", rpcErr,
+		)
+		u.noTraceBlock.Store(true)
+		return c.callTraces(ctx, url, bm, start, limit)
+	}
+	return err
+}
+
+// Uses a single trace_filter call for the range. Like logs,
+// the last block's header is requested in the same batch to
+// ensure that the provider has the entire range.
+func (c *Client) filterTraces(ctx context.Context, url string, bm blockmap, start, limit uint64) error {
+	var (
+		t0 = time.Now()
+		tf = struct {
+			From string `json:"fromBlock"`
+			To   string `json:"toBlock"`
+		}{
+			From: eth.EncodeUint64(start),
+			To:   eth.EncodeUint64(start + limit - 1),
+		}
+		resp = []any{
+			&headerResp{},
+			&traceBlockResp{},
+		}
+	)
+	err := c.do(ctx, url, &resp, []request{
+		request{
+			ID:      fmt.Sprintf("blocks-%d-%d-%x", start, limit, randbytes()),
+			Version: "2.0",
+			Method:  "eth_getBlockByNumber",
+			Params:  []any{tf.To, false},
+		},
+		request{
+			ID:      fmt.Sprintf("trace-filter-%d-%d-%x", start, limit, randbytes()),
+			Version: "2.0",
+			Method:  "trace_filter",
+			Params:  []any{tf},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("requesting trace_filter: %w", err)
+	}
+	var (
+		hresp = resp[0].(*headerResp)
+		tresp = resp[1].(*traceBlockResp)
+	)
+	switch {
+	case hresp.Error.Exists():
+		return fmt.Errorf("rpc=trace_filter/eth_getBlockByNumber %w", hresp.Error)
+	case tresp.Error.Exists():
+		return fmt.Errorf("rpc=trace_filter %w", tresp.Error)
+	case hresp.Header == nil:
+		return fmt.Errorf("eth backend missing traces for block: %d", start+limit-1)
+	}
+	var byBlock = map[uint64][]traceBlockResult{}
+	for i := range tresp.Result {
+		n := tresp.Result[i].BlockNum
+		if n < start || n >= start+limit {
+			const tag = "trace_filter out of range block. num=%d start=%d lim=%d"
+			return fmt.Errorf(tag, n, start, limit)
+		}
+		byBlock[n] = append(byBlock[n], tresp.Result[i])
+	}
+	for _, res := range byBlock {
+		if err := setTraces(bm, res); err != nil {
+			return err
+		}
+	}
+	slog.DebugContext(ctx, "http-get-trace-filter",
+		"ntraces", len(tresp.Result),
+		"elapsed", time.Since(t0),
+	)
+	return nil
+}
+
+// geth's callTracer output
+type callFrame struct {
+	Type  string      `json:"type"`
+	From  eth.Bytes   `json:"from"`
+	To    eth.Bytes   `json:"to"`
+	Value uint256.Int `json:"value"`
+	Input eth.Bytes   `json:"input"`
+	Error string      `json:"error"`
+	Calls []callFrame `json:"calls"`
+}
+
+type callTraceResp struct {
+	Error  `json:"error"`
+	Result []struct {
+		TxHash eth.Bytes `json:"txHash"`
+		Result callFrame `json:"result"`
+	} `json:"result"`
+}
+
+type blockTxsResp struct {
+	Error  `json:"error"`
+	Result *struct {
+		eth.Header
+		Txs []eth.Bytes `json:"transactions"`
+	} `json:"result"`
+}
+
+// Flattens the call tree in depth-first order so that
+// the order matches trace_block's.
+func flatten(res []eth.TraceAction, f callFrame, depth uint64) []eth.TraceAction {
+	res = append(res, eth.TraceAction{
+		Idx:      uint64(len(res)),
+		From:     f.From,
+		CallType: strings.ToLower(f.Type),
+		To:       f.To,
+		Value:    f.Value,
+		Input:    f.Input,
+		Depth:    depth,
+		Error:    f.Error,
+	})
+	for i := range f.Calls {
+		res = flatten(res, f.Calls[i], depth+1)
+	}
+	return res
+}
+
+// Uses debug_traceBlockByNumber with the callTracer. Unlike
+// trace_block, the result doesn't include block or tx hashes
+// so the block (with tx hashes) is requested in the same batch.
+func (c *Client) callTraces(ctx context.Context, url string, bm blockmap, start, limit uint64) error {
+	t0 := time.Now()
+	for i := uint64(0); i < limit; i++ {
+		var (
+			n    = eth.EncodeUint64(start + i)
+			resp = []any{
+				&blockTxsResp{},
+				&callTraceResp{},
 			}
+		)
+		err := c.do(ctx, url, &resp, []request{
+			request{
+				ID:      fmt.Sprintf("block-%d-%x", start+i, randbytes()),
+				Version: "2.0",
+				Method:  "eth_getBlockByNumber",
+				Params:  []any{n, false},
+			},
+			request{
+				ID:      fmt.Sprintf("call-traces-%d-%x", start+i, randbytes()),
+				Version: "2.0",
+				Method:  "debug_traceBlockByNumber",
+				Params:  []any{n, map[string]any{"tracer": "callTracer"}},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("requesting call traces: %w", err)
+		}
+		var (
+			bresp = resp[0].(*blockTxsResp)
+			tresp = resp[1].(*callTraceResp)
+		)
+		switch {
+		case bresp.Error.Exists():
+			return fmt.Errorf("rpc=eth_getBlockByNumber %w", bresp.Error)
+		case tresp.Error.Exists():
+			return fmt.Errorf("rpc=debug_traceBlockByNumber %w", tresp.Error)
+		case bresp.Result == nil:
+			return fmt.Errorf("eth backend missing block: %d", start+i)
+		case len(bresp.Result.Txs) != len(tresp.Result):
+			const tag = "debug_traceBlockByNumber %d traces for %d txs"
+			return fmt.Errorf(tag, len(tresp.Result), len(bresp.Result.Txs))
+		}
+		block, ok := bm[start+i]
+		if !ok {
+			return fmt.Errorf("missing block in block map")
+		}
+		block.Header.Hash.Write(bresp.Result.Hash)
+		block.Header.Parent.Write(bresp.Result.Parent)
+		block.Header.Time = bresp.Result.Time
+		for j := range tresp.Result {
+			tx := block.Tx(uint64(j))
+			tx.PrecompHash.Write(bresp.Result.Txs[j])
+			tx.TraceActions = flatten(nil, tresp.Result[j].Result, 0)
 		}
 	}
-	slog.DebugContext(ctx, "http-get-traces", "elapsed", time.Since(t0))
+	slog.DebugContext(ctx, "http-get-call-traces", "elapsed", time.Since(t0))
 	return nil
 }
@@ -1,18 +1,31 @@
 package jrpc2
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	_ "embed"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"slices"
 	"sort"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -20,6 +33,9 @@ import (
 	"github.com/indexsupply/shovel/eth"
 	"github.com/indexsupply/shovel/shovel/glf"
 	"github.com/indexsupply/shovel/tc"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
 	"golang.org/x/sync/errgroup"
 	"kr.dev/diff"
 )
@@ -331,6 +347,283 @@ func TestValidate_Logs_NoBlocks(t *testing.T) {
 	tc.WantGot(t, want, err.Error())
 }
 
+func TestChainID(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		diff.Test(t, t.Fatalf, nil, err)
+		diff.Test(t, t.Fatalf, true, methodsMatch(t, body, "eth_chainId"))
+		_, err = w.Write([]byte(`{"jsonrpc": "2.0", "id": "1", "result": "0x2105"}`))
+		diff.Test(t, t.Fatalf, nil, err)
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL)
+	id, err := c.ChainID(context.Background(), c.NextURL().String())
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, uint64(8453), id)
+}
+
+func TestTransport_Reuse(t *testing.T) {
+	var nconns int64
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(`{"jsonrpc": "2.0", "id": "1", "result": "0x2105"}`))
+		diff.Test(t, t.Fatalf, nil, err)
+	}))
+	ts.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			atomic.AddInt64(&nconns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	var (
+		eg errgroup.Group
+		c  = New(ts.URL).WithTransport(Transport{MaxIdleConns: 8})
+	)
+	for i := 0; i < 8; i++ {
+		eg.Go(func() error {
+			for j := 0; j < 20; j++ {
+				if _, err := c.ChainID(context.Background(), ts.URL); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	diff.Test(t, t.Fatalf, nil, eg.Wait())
+	if n := atomic.LoadInt64(&nconns); n > 8 {
+		t.Errorf("expected at most 8 connections got %d", n)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	const body = `{"jsonrpc": "2.0", "id": "1", "result": "0x2105"}`
+	var (
+		enc    string
+		accept string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept = r.Header.Get("Accept-Encoding")
+		var zw io.WriteCloser
+		switch enc {
+		case "gzip":
+			zw = gzip.NewWriter(w)
+		case "deflate":
+			zw = zlib.NewWriter(w)
+		case "raw-deflate":
+			zw, _ = flate.NewWriter(w, flate.DefaultCompression)
+		default:
+			w.Write([]byte(body))
+			return
+		}
+		w.Header().Set("Content-Encoding", strings.TrimPrefix(enc, "raw-"))
+		zw.Write([]byte(body))
+		zw.Close()
+	}))
+	defer ts.Close()
+
+	for _, enc = range []string{"", "gzip", "deflate", "raw-deflate"} {
+		c := New(ts.URL)
+		id, err := c.ChainID(context.Background(), ts.URL)
+		diff.Test(t, t.Fatalf, nil, err)
+		diff.Test(t, t.Errorf, uint64(8453), id)
+		diff.Test(t, t.Errorf, accept, "zstd, gzip, deflate")
+	}
+
+	enc = ""
+	c := New(ts.URL).WithTransport(Transport{DisableCompression: true})
+	_, err := c.ChainID(context.Background(), ts.URL)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, accept, "")
+}
+
+func TestHeaders(t *testing.T) {
+	var (
+		key        string
+		user, pass string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get("x-api-key")
+		user, pass, _ = r.BasicAuth()
+		_, err := w.Write([]byte(`{"jsonrpc": "2.0", "id": "1", "result": "0x2105"}`))
+		diff.Test(t, t.Fatalf, nil, err)
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL).
+		WithHeaders(map[string]string{"X-Api-Key": "foo"}).
+		WithBasicAuth("bar", "baz")
+	_, err := c.ChainID(context.Background(), ts.URL)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, key, "foo")
+	diff.Test(t, t.Errorf, user, "bar")
+	diff.Test(t, t.Errorf, pass, "baz")
+}
+
+func TestTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	diff.Test(t, t.Fatalf, nil, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "shovel"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	diff.Test(t, t.Fatalf, nil, err)
+	cert, err := x509.ParseCertificate(der)
+	diff.Test(t, t.Fatalf, nil, err)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(`{"jsonrpc": "2.0", "id": "1", "result": "0x2105"}`))
+		diff.Test(t, t.Fatalf, nil, err)
+	}))
+	ts.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  x509.NewCertPool(),
+	}
+	ts.TLS.ClientCAs.AddCert(cert)
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	c := New(ts.URL).WithTransport(Transport{TLS: &tls.Config{
+		RootCAs: roots,
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der},
+			PrivateKey:  key,
+		}},
+	}})
+	id, err := c.ChainID(context.Background(), ts.URL)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, id, uint64(8453))
+
+	c = New(ts.URL).WithTransport(Transport{TLS: &tls.Config{RootCAs: roots}})
+	_, err = c.ChainID(context.Background(), ts.URL)
+	diff.Test(t, t.Errorf, err != nil, true)
+}
+
+func TestIPC(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geth.ipc")
+	l, err := net.Listen("unix", path)
+	diff.Test(t, t.Fatalf, err, nil)
+	defer l.Close()
+
+	var nconns, nreqs atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			nconns.Add(1)
+			go func() {
+				defer conn.Close()
+				dec := json.NewDecoder(conn)
+				for {
+					var req map[string]any
+					if err := dec.Decode(&req); err != nil {
+						return
+					}
+					nreqs.Add(1)
+					// not newline delimited
+					conn.Write([]byte(`{"jsonrpc": "2.0", "id": "1", "result": "0x2105"}`))
+				}
+			}()
+		}
+	}()
+
+	u := "ipc://" + path
+	c := New(u)
+	for i := 0; i < 3; i++ {
+		id, err := c.ChainID(context.Background(), u)
+		diff.Test(t, t.Fatalf, err, nil)
+		diff.Test(t, t.Errorf, id, uint64(8453))
+	}
+	diff.Test(t, t.Errorf, nreqs.Load(), int32(3))
+	diff.Test(t, t.Errorf, nconns.Load(), int32(1))
+	diff.Test(t, t.Errorf, MustURL(u).Hostname(), "ipc")
+}
+
+func TestChainIDVerification(t *testing.T) {
+	node := func(chainID string, nreqs map[string]int) *httptest.Server {
+		var mut sync.Mutex
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req request
+			diff.Test(t, t.Fatalf, json.NewDecoder(r.Body).Decode(&req), nil)
+			mut.Lock()
+			nreqs[req.Method]++
+			mut.Unlock()
+			switch req.Method {
+			case "eth_chainId":
+				fmt.Fprintf(w, `{"jsonrpc": "2.0", "id": "1", "result": "%s"}`, chainID)
+			default:
+				fmt.Fprintf(w, `{"jsonrpc": "2.0", "id": "1", "result": {"number": "0x1", "hash": "0xaa"}}`)
+			}
+		}))
+	}
+	var (
+		ctx       = context.Background()
+		mainReqs  = map[string]int{}
+		baseReqs  = map[string]int{}
+		mainnet   = node("0x1", mainReqs)
+		base      = node("0x2105", baseReqs)
+		c         = New(mainnet.URL, base.URL).WithChainID(8453)
+		chainErr  *ChainIDError
+		mainnetID = MustURL(mainnet.URL)
+	)
+	defer mainnet.Close()
+	defer base.Close()
+
+	err := c.VerifyChainID(ctx)
+	if !errors.As(err, &chainErr) {
+		t.Fatalf("expected ChainIDError. got: %v", err)
+	}
+	diff.Test(t, t.Errorf, chainErr.Got, uint64(1))
+	diff.Test(t, t.Errorf, chainErr.Want, uint64(8453))
+
+	_, err = c.Hash(ctx, mainnet.URL, 1)
+	if !errors.As(err, &chainErr) {
+		t.Fatalf("expected ChainIDError. got: %v", err)
+	}
+	diff.Test(t, t.Errorf, mainReqs["eth_getBlockByNumber"], 0)
+	diff.Test(t, t.Errorf, mainnetID.State(time.Now()), Open)
+
+	for i := 0; i < 3; i++ {
+		u := c.NextURL()
+		diff.Test(t, t.Errorf, u.String(), base.URL)
+		h, err := c.Hash(ctx, u.String(), 1)
+		diff.Test(t, t.Fatalf, err, nil)
+		diff.Test(t, t.Errorf, h, []byte{0xaa})
+	}
+	diff.Test(t, t.Errorf, baseReqs["eth_chainId"], 1)
+	diff.Test(t, t.Errorf, baseReqs["eth_getBlockByNumber"], 3)
+}
+
+func TestProxy(t *testing.T) {
+	var host string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.URL.Host
+		_, err := w.Write([]byte(`{"jsonrpc": "2.0", "id": "1", "result": "0x2105"}`))
+		diff.Test(t, t.Fatalf, nil, err)
+	}))
+	defer proxy.Close()
+	pu, err := url.Parse(proxy.URL)
+	diff.Test(t, t.Fatalf, err, nil)
+
+	const rpc = "http://rpc.example.com"
+	c := New(rpc).WithTransport(Transport{Proxy: pu})
+	id, err := c.ChainID(context.Background(), rpc)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, id, uint64(8453))
+	diff.Test(t, t.Errorf, host, "rpc.example.com")
+}
+
 func TestError(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		body, err := io.ReadAll(r.Body)
@@ -358,6 +651,66 @@ func TestError(t *testing.T) {
 	diff.Test(t, t.Errorf, want, got.Error())
 }
 
+func TestLenient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		diff.Test(t, t.Fatalf, nil, err)
+		switch {
+		case methodsMatch(t, body, "eth_getBlockByNumber"):
+			_, err := w.Write([]byte(`
+				[{
+					"jsonrpc": "2.0",
+					"id": "1",
+					"result": {
+						"number": "0xf4241",
+						"hash": "0x01",
+						"parentHash": "0x0",
+						"timestamp": 1700000000,
+						"baseFeePerGas": null,
+						"transactions": [{
+							"hash": "0x02",
+							"type": "0x7b",
+							"nonce": 5,
+							"gasPrice": null,
+							"value": "56",
+							"gas": "0x",
+							"input": "",
+							"to": null,
+							"v": "0x00",
+							"r": "0x001",
+							"s": "0x1"
+						}]
+					}
+				}]
+			`))
+			diff.Test(t, t.Fatalf, nil, err)
+		}
+	}))
+	defer ts.Close()
+
+	var (
+		ctx    = context.Background()
+		c      = New(ts.URL).WithLenient(true)
+		filter = &glf.Filter{UseBlocks: true}
+	)
+	blocks, err := c.Get(ctx, ts.URL, filter, 1000001, 1)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, blocks[0].Num(), uint64(1000001))
+	diff.Test(t, t.Errorf, blocks[0].Time, eth.Uint64(1700000000))
+	diff.Test(t, t.Errorf, []byte(blocks[0].Header.Parent), []byte{0})
+
+	tx := blocks[0].Txs[0]
+	diff.Test(t, t.Errorf, tx.Type, eth.Byte(0x7b))
+	diff.Test(t, t.Errorf, tx.Value.Uint64(), uint64(56))
+	diff.Test(t, t.Errorf, tx.Nonce, eth.Uint64(5))
+	diff.Test(t, t.Errorf, tx.GasPrice.IsZero(), true)
+	diff.Test(t, t.Errorf, tx.R.Uint64(), uint64(1))
+	diff.Test(t, t.Errorf, len(tx.Data), 0)
+
+	_, err = New(ts.URL).Get(ctx, ts.URL, filter, 1000001, 1)
+	diff.Test(t, t.Errorf, err != nil, true)
+}
+
 func TestGet(t *testing.T) {
 	ctx := context.Background()
 	const start, limit = 10, 5
@@ -463,6 +816,119 @@ func TestGet_Cached_Pruned(t *testing.T) {
 	diff.Test(t, t.Errorf, n, int32(2))
 }
 
+// Integrations whose filters are covered by the client's
+// log filter share a single eth_getLogs request
+func TestGet_SharedLogs(t *testing.T) {
+	var nlogs int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		diff.Test(t, t.Fatalf, nil, err)
+		switch {
+		case methodsMatch(t, body, "eth_getBlockByNumber", "eth_getLogs"):
+			atomic.AddInt32(&nlogs, 1)
+			_, err := w.Write([]byte(logs18000000JSON))
+			diff.Test(t, t.Fatalf, nil, err)
+		}
+	}))
+	defer ts.Close()
+	var (
+		ctx = context.Background()
+		a   = glf.New([]string{"log_addr"}, []string{"0xa"}, [][]string{{"0x1"}})
+		b   = glf.New([]string{"log_addr"}, []string{"0xb"}, [][]string{{"0x2"}})
+		d   = glf.New([]string{"log_addr"}, []string{"0xd"}, nil)
+		c   = New(ts.URL).WithLogFilter(glf.Union(*a, *b))
+	)
+	for _, f := range []*glf.Filter{a, b} {
+		blocks, err := c.Get(ctx, c.NextURL().String(), f, 18000000, 1)
+		diff.Test(t, t.Fatalf, nil, err)
+		diff.Test(t, t.Errorf, blocks[0].Num(), uint64(18000000))
+	}
+	diff.Test(t, t.Errorf, nlogs, int32(1))
+
+	_, err := c.Get(ctx, c.NextURL().String(), d, 18000000, 1)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, nlogs, int32(2))
+}
+
+func TestShared(t *testing.T) {
+	var (
+		ctx = context.Background()
+		s   = shared{maxreads: 2}
+	)
+	e, owner := s.claim("receipts-1")
+	diff.Test(t, t.Errorf, owner, true)
+	w, owner := s.claim("receipts-1")
+	diff.Test(t, t.Errorf, owner, false)
+	s.fill("receipts-1", e, []json.RawMessage{[]byte(`{"result": null}`)}, nil)
+	raws, err := w.wait(ctx, "receipts")
+	diff.Test(t, t.Errorf, err, nil)
+	diff.Test(t, t.Errorf, string(raws[0]), `{"result": null}`)
+
+	// empty results aren't kept
+	e, owner = s.claim("receipts-1")
+	diff.Test(t, t.Errorf, owner, true)
+	s.fill("receipts-1", e, []json.RawMessage{[]byte(`{"result": []}`)}, nil)
+	_, owner = s.claim("receipts-1")
+	diff.Test(t, t.Errorf, owner, false)
+
+	// read by maxreads callers
+	_, owner = s.claim("receipts-1")
+	diff.Test(t, t.Errorf, owner, true)
+}
+
+func TestBloom(t *testing.T) {
+	var nreceipts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		diff.Test(t, t.Fatalf, nil, err)
+		switch {
+		case methodsMatch(t, body, "eth_getBlockReceipts"):
+			atomic.AddInt32(&nreceipts, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		case methodsMatch(t, body, "eth_getBlockByNumber"):
+			_, err := w.Write([]byte(block18000000JSON))
+			diff.Test(t, t.Fatalf, nil, err)
+		case methodsMatch(t, body, "eth_getBlockByNumber", "eth_getLogs"):
+			_, err := w.Write([]byte(logs18000000JSON))
+			diff.Test(t, t.Fatalf, nil, err)
+		}
+	}))
+	defer ts.Close()
+	var (
+		ctx = context.Background()
+		c   = New(ts.URL)
+	)
+	blocks, err := c.Get(ctx, c.NextURL().String(), &glf.Filter{UseBlocks: true, UseLogs: true}, 18000000, 1)
+	diff.Test(t, t.Fatalf, nil, err)
+	var (
+		bloom = blocks[0].LogsBloom
+		l     = blocks[0].Txs[0].Logs[0]
+	)
+	for _, topic := range l.Topics {
+		diff.Test(t, t.Errorf, eth.BloomContains(bloom, topic), true)
+	}
+	diff.Test(t, t.Errorf, eth.BloomContains(bloom, l.Address), true)
+	diff.Test(t, t.Errorf, eth.BloomContains(bloom, []byte("not an address")), false)
+	diff.Test(t, t.Errorf, eth.BloomContains(nil, []byte("not an address")), true)
+
+	var (
+		addr = eth.EncodeHex(l.Address)
+		sig  = eth.EncodeHex(l.Topics[0])
+	)
+	f := glf.New([]string{"log_addr"}, []string{addr}, [][]string{{sig}})
+	diff.Test(t, t.Errorf, f.MayMatch(bloom), true)
+	f = glf.New([]string{"log_addr"}, []string{addr}, [][]string{{eth.EncodeHex([]byte("not a topic"))}})
+	diff.Test(t, t.Errorf, f.MayMatch(bloom), false)
+
+	// receipts aren't requested for blocks that can't match
+	f = glf.New([]string{"tx_status", "log_addr"}, []string{addr}, [][]string{{eth.EncodeHex([]byte("not a topic"))}})
+	diff.Test(t, t.Fatalf, f.UseReceipts, true)
+	blocks, err = c.Get(ctx, c.NextURL().String(), f, 18000000, 1)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, blocks[0].Num(), uint64(18000000))
+	diff.Test(t, t.Errorf, nreceipts, int32(0))
+}
+
 func TestNoLogs(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		body, err := io.ReadAll(r.Body)
@@ -490,6 +956,86 @@ func TestNoLogs(t *testing.T) {
 	diff.Test(t, t.Errorf, len(tx.Logs), 0)
 }
 
+func TestStateSyncLogs(t *testing.T) {
+	const (
+		blockHash = "0xcb5cab7266694daa0d28cbf40496c08dd30bf732c41e0455e7ad389c10d79f4f"
+		txHash    = "0xefb6c796269c0d1f15fdedb5496fa196eb7fb55b601c0fa527609405519fd581"
+	)
+	var chainID atomic.Uint64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		diff.Test(t, t.Fatalf, nil, err)
+		switch {
+		case methodsMatch(t, body, "eth_chainId"):
+			_, err = fmt.Fprintf(w, `{"jsonrpc": "2.0", "id": "1", "result": "0x%x"}`, chainID.Load())
+			diff.Test(t, t.Fatalf, nil, err)
+		case methodsMatch(t, body, "eth_getBlockByNumber", "eth_getLogs"):
+			_, err := w.Write([]byte(`[
+				{"jsonrpc":"2.0","id":"1","result":{"number":"0xf4241","hash":"` + blockHash + `"}},
+				{"jsonrpc":"2.0","id":"1","result":[{
+					"address":"0x819f4b08e6d3baa33ba63f660baed65d2a6eb64c",
+					"topics":[],
+					"data":"0x",
+					"blockNumber":"0xf4241",
+					"blockHash":"` + blockHash + `",
+					"transactionHash":"` + txHash + `",
+					"transactionIndex":"0x0",
+					"logIndex":"0x0"
+				}, {
+					"address":"0x0000000000000000000000000000000000001001",
+					"topics":[],
+					"data":"0x",
+					"blockNumber":"0xf4241",
+					"blockHash":"` + blockHash + `",
+					"transactionHash":null,
+					"transactionIndex":"0x1",
+					"logIndex":"0x1"
+				}]}
+			]`))
+			diff.Test(t, t.Fatalf, nil, err)
+		}
+	}))
+	defer ts.Close()
+
+	var (
+		ctx     = context.Background()
+		key     = append([]byte("matic-bor-receipt-"), 0, 0, 0, 0, 0, 0x0f, 0x42, 0x41)
+		derived = eth.Keccak(append(key, eth.DecodeHex(blockHash)...))
+	)
+	for _, tc := range []struct {
+		chainID uint64
+		want    []byte
+	}{
+		{137, derived},
+		{80002, derived},
+		{1, nil},
+	} {
+		chainID.Store(tc.chainID)
+		c := New(ts.URL).WithChainID(tc.chainID)
+		blocks, err := c.Get(ctx, ts.URL, &glf.Filter{UseLogs: true}, 1000001, 1)
+		diff.Test(t, t.Fatalf, nil, err)
+		diff.Test(t, t.Fatalf, len(blocks[0].Txs), 2)
+		var (
+			tx0 = blocks[0].Tx(0)
+			tx1 = blocks[0].Tx(1)
+		)
+		diff.Test(t, t.Errorf, eth.EncodeHex(tx0.Hash()), txHash)
+		diff.Test(t, t.Errorf, []byte(tx1.PrecompHash), tc.want)
+		diff.Test(t, t.Errorf, len(tx1.Logs), 1)
+	}
+}
+
+func TestTxHash(t *testing.T) {
+	var (
+		zero      = make([]byte, 32)
+		blockHash = eth.DecodeHex("0xcb5cab7266694daa0d28cbf40496c08dd30bf732c41e0455e7ad389c10d79f4f")
+		bor       = New("").WithChainID(137)
+	)
+	diff.Test(t, t.Errorf, bor.txHash(zero, blockHash, 1, false), zero)
+	diff.Test(t, t.Errorf, bytes.Equal(bor.txHash(zero, blockHash, 1, true), zero), false)
+	diff.Test(t, t.Errorf, New("").WithChainID(1).txHash(zero, blockHash, 1, true), zero)
+}
+
 func TestLatest(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		body, err := io.ReadAll(r.Body)
@@ -536,3 +1082,631 @@ func TestLatest(t *testing.T) {
 	tx3 := blocks[0].Txs[3]
 	diff.Test(t, t.Errorf, fmt.Sprintf("%s", tx3.Value.Dec()), "69970000000000014")
 }
+
+func TestCallTraces(t *testing.T) {
+	const (
+		blockJSON = `{
+			"jsonrpc": "2.0",
+			"id": "1",
+			"result": {
+				"number": "0x2a",
+				"hash": "0xaa00000000000000000000000000000000000000000000000000000000000000",
+				"parentHash": "0xbb00000000000000000000000000000000000000000000000000000000000000",
+				"timestamp": "0x1",
+				"transactions": ["0xcc00000000000000000000000000000000000000000000000000000000000000"]
+			}
+		}`
+		tracesJSON = `{
+			"jsonrpc": "2.0",
+			"id": "2",
+			"result": [{
+				"result": {
+					"type": "CALL",
+					"from": "0x1100000000000000000000000000000000000000",
+					"to": "0x2200000000000000000000000000000000000000",
+					"value": "0x0",
+					"input": "0xa9059cbb",
+					"calls": [{
+						"type": "CALL",
+						"from": "0x2200000000000000000000000000000000000000",
+						"to": "0x3300000000000000000000000000000000000000",
+						"value": "0xff",
+						"input": "0x",
+						"error": "execution reverted"
+					}]
+				}
+			}]
+		}`
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		diff.Test(t, t.Fatalf, nil, err)
+		switch {
+		case methodsMatch(t, body, "eth_getBlockByNumber", "debug_traceBlockByNumber"):
+			_, err := w.Write([]byte("[" + blockJSON + "," + tracesJSON + "]"))
+			diff.Test(t, t.Fatalf, nil, err)
+		default:
+			t.Fatalf("unexpected request: %s", body)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := New(ts.URL).WithTraceMethod("debug_traceBlockByNumber")
+	blocks, err := c.Get(ctx, c.NextURL().String(), &glf.Filter{UseTraces: true}, 42, 1)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Fatalf, len(blocks), 1)
+
+	b := blocks[0]
+	diff.Test(t, t.Errorf, fmt.Sprintf("%.2x", b.Hash()), "aa00")
+	diff.Test(t, t.Errorf, fmt.Sprintf("%.2x", b.Parent), "bb00")
+	diff.Test(t, t.Fatalf, len(b.Txs), 1)
+	diff.Test(t, t.Errorf, fmt.Sprintf("%.2x", b.Txs[0].Hash()), "cc00")
+
+	tas := b.Txs[0].TraceActions
+	diff.Test(t, t.Fatalf, len(tas), 2)
+	diff.Test(t, t.Errorf, tas[0].CallType, "call")
+	diff.Test(t, t.Errorf, tas[0].Depth, uint64(0))
+	diff.Test(t, t.Errorf, fmt.Sprintf("%x", tas[0].Input), "a9059cbb")
+	diff.Test(t, t.Errorf, tas[1].Idx, uint64(1))
+	diff.Test(t, t.Errorf, tas[1].Depth, uint64(1))
+	diff.Test(t, t.Errorf, tas[1].Value.Dec(), "255")
+	diff.Test(t, t.Errorf, tas[1].Error, "execution reverted")
+}
+
+func TestNewHeads(t *testing.T) {
+	c := New("")
+	ch := c.NewHeads()
+	c.lcache.update(eth.Uint64(1), []byte{0x01})
+	select {
+	case <-ch:
+	default:
+		t.Fatal("expected channel to be closed after update")
+	}
+
+	ch = c.NewHeads()
+	c.lcache.update(eth.Uint64(1), []byte{0x01})
+	select {
+	case <-ch:
+		t.Fatal("expected channel to be open after stale update")
+	default:
+	}
+}
+
+func TestFailover(t *testing.T) {
+	var nbad int32
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&nbad, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(`{"jsonrpc": "2.0", "id": "1", "result": {"hash": "0xaa"}}`))
+		diff.Test(t, t.Fatalf, nil, err)
+	}))
+	defer good.Close()
+
+	var (
+		ctx = context.Background()
+		c   = New(bad.URL, good.URL)
+	)
+	for i := 0; i < 10; i++ {
+		c.Hash(ctx, c.NextURL().String(), 18000000)
+	}
+	diff.Test(t, t.Errorf, nbad, int32(1))
+
+	// fail back once the down period has elapsed
+	c.urls[0].mut.Lock()
+	c.urls[0].downUntil = time.Now().Add(-time.Second)
+	c.urls[0].mut.Unlock()
+	for i := 0; i < 10; i++ {
+		c.Hash(ctx, c.NextURL().String(), 18000000)
+	}
+	diff.Test(t, t.Errorf, nbad, int32(2))
+}
+
+func TestLimiter(t *testing.T) {
+	var (
+		l   = NewLimiter(10)
+		now = time.Now()
+	)
+	for i := 0; i < 10; i++ {
+		diff.Test(t, t.Errorf, l.reserve(now, 1), time.Duration(0))
+	}
+	diff.Test(t, t.Errorf, l.reserve(now, 1), 100*time.Millisecond)
+	// a batch larger than the bucket is allowed but
+	// subsequent requests wait for the deficit
+	now = now.Add(time.Second)
+	diff.Test(t, t.Errorf, l.reserve(now, 20), time.Second+100*time.Millisecond)
+	now = now.Add(5 * time.Second)
+	diff.Test(t, t.Errorf, l.reserve(now, 1), time.Duration(0))
+}
+
+func TestLimiter_Priority(t *testing.T) {
+	var (
+		l     = NewLimiter(1)
+		first = &waiter{priority: -1, ready: make(chan struct{})}
+		ws    = []*waiter{
+			{priority: -1, ready: make(chan struct{})},
+			{priority: 0, ready: make(chan struct{})},
+			{priority: 1, ready: make(chan struct{})},
+			{priority: 0, ready: make(chan struct{})},
+		}
+		order []int
+	)
+	l.push(first)
+	for _, w := range ws {
+		l.push(w)
+	}
+	l.done(first)
+	for len(order) < len(ws) {
+		for i, w := range ws {
+			select {
+			case <-w.ready:
+				if !slices.Contains(order, i) {
+					order = append(order, i)
+					l.done(w)
+				}
+			default:
+			}
+		}
+	}
+	// higher priority first and then by arrival
+	diff.Test(t, t.Errorf, order, []int{2, 1, 3, 0})
+}
+
+func TestCircuit(t *testing.T) {
+	var nreq int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&nreq, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, err := w.Write([]byte(`{"jsonrpc": "2.0", "id": "1", "result": {"hash": "0xaa"}}`))
+		diff.Test(t, t.Fatalf, nil, err)
+	}))
+	defer ts.Close()
+
+	var (
+		ctx = context.Background()
+		c   = New(ts.URL)
+		u   = c.NextURL()
+	)
+	for i := 0; i < 10; i++ {
+		c.Hash(ctx, u.String(), 18000000)
+	}
+	diff.Test(t, t.Errorf, nreq, int32(1))
+	diff.Test(t, t.Errorf, u.State(time.Now()), Open)
+
+	_, err := c.Hash(ctx, u.String(), 18000000)
+	var coe *CircuitOpenError
+	if !errors.As(err, &coe) {
+		t.Fatalf("expected CircuitOpenError. got: %v", err)
+	}
+
+	// half-open allows a single request which closes the circuit
+	u.mut.Lock()
+	u.downUntil = time.Now().Add(-time.Second)
+	u.mut.Unlock()
+	diff.Test(t, t.Errorf, u.State(time.Now()), HalfOpen)
+	diff.Test(t, t.Errorf, u.allow(time.Now()), true)
+	diff.Test(t, t.Errorf, u.allow(time.Now()), false)
+	u.report(time.Now(), nil)
+	_, err = c.Hash(ctx, u.String(), 18000000)
+	diff.Test(t, t.Errorf, err, nil)
+	diff.Test(t, t.Errorf, u.State(time.Now()), Closed)
+}
+
+type memStore map[string][]byte
+
+func (ms memStore) Get(_ context.Context, chainID uint64, kind string, start, limit uint64) (map[uint64][]byte, error) {
+	res := map[uint64][]byte{}
+	for i := start; i < start+limit; i++ {
+		if b, ok := ms[fmt.Sprintf("%d-%s-%d", chainID, kind, i)]; ok {
+			res[i] = b
+		}
+	}
+	return res, nil
+}
+
+func (ms memStore) Put(_ context.Context, chainID uint64, kind string, data map[uint64][]byte) error {
+	for n, b := range data {
+		ms[fmt.Sprintf("%d-%s-%d", chainID, kind, n)] = b
+	}
+	return nil
+}
+
+func TestStore(t *testing.T) {
+	var n int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		diff.Test(t, t.Fatalf, nil, err)
+		switch {
+		case methodsMatch(t, body, "eth_getBlockByNumber"):
+			atomic.AddInt32(&n, 1)
+			_, err := w.Write([]byte(block18000000JSON))
+			diff.Test(t, t.Fatalf, nil, err)
+		}
+	}))
+	defer ts.Close()
+	var (
+		ctx   = context.Background()
+		store = memStore{}
+		get   = func(latest uint64) {
+			c := New(ts.URL).WithStore(store, 1, 10)
+			c.lcache.update(eth.Uint64(latest), nil)
+			blocks, err := c.Get(ctx, c.NextURL().String(), &glf.Filter{UseHeaders: true}, 18000000, 1)
+			diff.Test(t, t.Fatalf, nil, err)
+			diff.Test(t, t.Errorf, blocks[0].Num(), uint64(18000000))
+		}
+	)
+	get(18000005) // not final
+	diff.Test(t, t.Errorf, n, int32(1))
+	diff.Test(t, t.Errorf, len(store), 0)
+
+	get(18000010)
+	diff.Test(t, t.Errorf, n, int32(2))
+	diff.Test(t, t.Errorf, len(store), 1)
+
+	get(18000010)
+	diff.Test(t, t.Errorf, n, int32(2))
+}
+
+func TestReceipts_Fallback(t *testing.T) {
+	const (
+		unsupportedJSON = `[{
+			"jsonrpc": "2.0",
+			"id": "1",
+			"error": {"code": -32601, "message": "the method eth_getBlockReceipts does not exist/is not available"}
+		}]`
+		blockJSON = `[{
+			"jsonrpc": "2.0",
+			"id": "1",
+			"result": {
+				"number": "0x2a",
+				"hash": "0xaa00000000000000000000000000000000000000000000000000000000000000",
+				"transactions": [
+					"0xcc00000000000000000000000000000000000000000000000000000000000000",
+					"0xdd00000000000000000000000000000000000000000000000000000000000000"
+				]
+			}
+		}]`
+		receiptsJSON = `[{
+			"jsonrpc": "2.0",
+			"id": "1",
+			"result": {
+				"blockHash": "0xaa00000000000000000000000000000000000000000000000000000000000000",
+				"blockNumber": "0x2a",
+				"transactionHash": "0xcc00000000000000000000000000000000000000000000000000000000000000",
+				"transactionIndex": "0x0",
+				"status": "0x1",
+				"gasUsed": "0x5208",
+				"cumulativeGasUsed": "0x5208",
+				"effectiveGasPrice": "0x3b9aca00",
+				"contractAddress": "0xee00000000000000000000000000000000000000",
+				"logs": []
+			}
+		}, {
+			"jsonrpc": "2.0",
+			"id": "2",
+			"result": {
+				"blockHash": "0xaa00000000000000000000000000000000000000000000000000000000000000",
+				"blockNumber": "0x2a",
+				"transactionHash": "0xdd00000000000000000000000000000000000000000000000000000000000000",
+				"transactionIndex": "0x1",
+				"status": "0x0",
+				"gasUsed": "0x1",
+				"cumulativeGasUsed": "0x5209",
+				"contractAddress": null,
+				"logs": []
+			}
+		}]`
+	)
+	var nblockReceipts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		diff.Test(t, t.Fatalf, nil, err)
+		switch {
+		case methodsMatch(t, body, "eth_getBlockReceipts"):
+			atomic.AddInt32(&nblockReceipts, 1)
+			_, err = w.Write([]byte(unsupportedJSON))
+		case methodsMatch(t, body, "eth_getBlockByNumber"):
+			_, err = w.Write([]byte(blockJSON))
+		case methodsMatch(t, body, "eth_getTransactionReceipt", "eth_getTransactionReceipt"):
+			_, err = w.Write([]byte(receiptsJSON))
+		default:
+			t.Fatalf("unexpected request: %s", body)
+		}
+		diff.Test(t, t.Fatalf, nil, err)
+	}))
+	defer ts.Close()
+
+	var (
+		ctx = context.Background()
+		c   = New(ts.URL)
+	)
+	for i := 0; i < 2; i++ {
+		blocks, err := c.Get(ctx, c.NextURL().String(), &glf.Filter{UseReceipts: true}, 42, 1)
+		diff.Test(t, t.Fatalf, nil, err)
+		diff.Test(t, t.Fatalf, len(blocks), 1)
+		b := &blocks[0]
+		diff.Test(t, t.Errorf, fmt.Sprintf("%.2x", b.Hash()), "aa00")
+		diff.Test(t, t.Fatalf, len(b.Txs), 2)
+		diff.Test(t, t.Errorf, fmt.Sprintf("%.2x", b.Txs[1].Hash()), "dd00")
+		diff.Test(t, t.Errorf, b.Txs[0].GasUsed, eth.Uint64(21000))
+		diff.Test(t, t.Errorf, b.Txs[0].EffectiveGasPrice.Dec(), "1000000000")
+		diff.Test(t, t.Errorf, fmt.Sprintf("%.2x", b.Txs[0].ContractAddress), "ee00")
+		diff.Test(t, t.Errorf, b.Txs[1].Status, eth.Byte(0))
+		diff.Test(t, t.Errorf, b.Txs[1].CumulativeGasUsed, eth.Uint64(21001))
+		diff.Test(t, t.Errorf, len(b.Txs[1].ContractAddress), 0)
+	}
+	diff.Test(t, t.Errorf, nblockReceipts, int32(1))
+}
+
+func TestTraceFilter(t *testing.T) {
+	const (
+		headerJSON = `{
+			"jsonrpc": "2.0",
+			"id": "1",
+			"result": {"number": "0x2b", "hash": "0xbb00000000000000000000000000000000000000000000000000000000000000"}
+		}`
+		tracesJSON = `{
+			"jsonrpc": "2.0",
+			"id": "2",
+			"result": [{
+				"blockHash": "0xaa00000000000000000000000000000000000000000000000000000000000000",
+				"blockNumber": 42,
+				"transactionHash": "0xcc00000000000000000000000000000000000000000000000000000000000000",
+				"transactionPosition": 0,
+				"traceAddress": [],
+				"action": {"callType": "call", "from": "0x1100000000000000000000000000000000000000", "to": "0x2200000000000000000000000000000000000000", "value": "0x0", "input": "0x"}
+			}, {
+				"blockHash": "0xaa00000000000000000000000000000000000000000000000000000000000000",
+				"blockNumber": 42,
+				"transactionHash": "0xcc00000000000000000000000000000000000000000000000000000000000000",
+				"transactionPosition": 0,
+				"traceAddress": [0],
+				"action": {"callType": "call", "from": "0x2200000000000000000000000000000000000000", "to": "0x3300000000000000000000000000000000000000", "value": "0xff", "input": "0x"}
+			}, {
+				"blockHash": "0xbb00000000000000000000000000000000000000000000000000000000000000",
+				"blockNumber": 43,
+				"transactionHash": "0xdd00000000000000000000000000000000000000000000000000000000000000",
+				"transactionPosition": 1,
+				"traceAddress": [],
+				"action": {"callType": "delegatecall", "from": "0x1100000000000000000000000000000000000000", "to": "0x2200000000000000000000000000000000000000", "value": "0x0", "input": "0x"}
+			}]
+		}`
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		diff.Test(t, t.Fatalf, nil, err)
+		switch {
+		case methodsMatch(t, body, "eth_getBlockByNumber", "trace_filter"):
+			_, err := w.Write([]byte("[" + headerJSON + "," + tracesJSON + "]"))
+			diff.Test(t, t.Fatalf, nil, err)
+		default:
+			t.Fatalf("unexpected request: %s", body)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := New(ts.URL).WithTraceMethod("trace_filter")
+	blocks, err := c.Get(ctx, c.NextURL().String(), &glf.Filter{UseTraces: true}, 42, 2)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Fatalf, len(blocks), 2)
+	diff.Test(t, t.Errorf, fmt.Sprintf("%.2x", blocks[0].Hash()), "aa00")
+	diff.Test(t, t.Fatalf, len(blocks[0].Txs), 1)
+	diff.Test(t, t.Fatalf, len(blocks[0].Txs[0].TraceActions), 2)
+	diff.Test(t, t.Errorf, blocks[0].Txs[0].TraceActions[1].Depth, uint64(1))
+	diff.Test(t, t.Errorf, blocks[0].Txs[0].TraceActions[1].Value.Dec(), "255")
+	diff.Test(t, t.Errorf, fmt.Sprintf("%.2x", blocks[1].Hash()), "bb00")
+	diff.Test(t, t.Fatalf, len(blocks[1].Txs), 1)
+	diff.Test(t, t.Errorf, blocks[1].Txs[0].Idx, eth.Uint64(1))
+	diff.Test(t, t.Errorf, blocks[1].Txs[0].TraceActions[0].CallType, "delegatecall")
+}
+
+func TestTraceBlock(t *testing.T) {
+	const (
+		block42 = `{
+			"jsonrpc": "2.0",
+			"id": "1",
+			"result": [{
+				"blockHash": "0xaa00000000000000000000000000000000000000000000000000000000000000",
+				"blockNumber": 42,
+				"transactionHash": "0xcc00000000000000000000000000000000000000000000000000000000000000",
+				"transactionPosition": 0,
+				"traceAddress": [],
+				"action": {"callType": "call", "from": "0x1100000000000000000000000000000000000000", "to": "0x2200000000000000000000000000000000000000", "value": "0x0", "input": "0x"}
+			}, {
+				"blockHash": "0xaa00000000000000000000000000000000000000000000000000000000000000",
+				"blockNumber": 42,
+				"transactionHash": "0xcc00000000000000000000000000000000000000000000000000000000000000",
+				"transactionPosition": 0,
+				"traceAddress": [0],
+				"error": "Reverted",
+				"action": {"callType": "call", "from": "0x2200000000000000000000000000000000000000", "to": "0x3300000000000000000000000000000000000000", "value": "0xff", "input": "0x"}
+			}]
+		}`
+		block43 = `{
+			"jsonrpc": "2.0",
+			"id": "2",
+			"result": [{
+				"blockHash": "0xbb00000000000000000000000000000000000000000000000000000000000000",
+				"blockNumber": 43,
+				"transactionHash": "0xdd00000000000000000000000000000000000000000000000000000000000000",
+				"transactionPosition": 0,
+				"traceAddress": [],
+				"action": {"callType": "delegatecall", "from": "0x1100000000000000000000000000000000000000", "to": "0x2200000000000000000000000000000000000000", "value": "0x0", "input": "0x"}
+			}]
+		}`
+	)
+	var nreq int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		diff.Test(t, t.Fatalf, nil, err)
+		switch {
+		case methodsMatch(t, body, "trace_block", "trace_block"):
+			atomic.AddInt32(&nreq, 1)
+			_, err := w.Write([]byte("[" + block42 + "," + block43 + "]"))
+			diff.Test(t, t.Fatalf, nil, err)
+		default:
+			t.Fatalf("unexpected request: %s", body)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := New(ts.URL).WithTraceMethod("trace_block")
+	blocks, err := c.Get(ctx, c.NextURL().String(), &glf.Filter{UseTraces: true}, 42, 2)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, nreq, int32(1))
+	diff.Test(t, t.Fatalf, len(blocks), 2)
+	diff.Test(t, t.Errorf, fmt.Sprintf("%.2x", blocks[0].Hash()), "aa00")
+	diff.Test(t, t.Fatalf, len(blocks[0].Txs[0].TraceActions), 2)
+	diff.Test(t, t.Errorf, blocks[0].Txs[0].TraceActions[1].Idx, uint64(1))
+	diff.Test(t, t.Errorf, blocks[0].Txs[0].TraceActions[1].Depth, uint64(1))
+	diff.Test(t, t.Errorf, blocks[0].Txs[0].TraceActions[1].Error, "Reverted")
+	diff.Test(t, t.Errorf, fmt.Sprintf("%.2x", blocks[1].Hash()), "bb00")
+	diff.Test(t, t.Errorf, blocks[1].Txs[0].TraceActions[0].CallType, "delegatecall")
+}
+
+func TestTraces_Fallback(t *testing.T) {
+	const (
+		unsupportedJSON = `{
+			"jsonrpc": "2.0",
+			"id": "1",
+			"error": {"code": -32601, "message": "the method trace_block does not exist/is not available"}
+		}`
+		blockJSON = `{
+			"jsonrpc": "2.0",
+			"id": "1",
+			"result": {
+				"number": "0x2a",
+				"hash": "0xaa00000000000000000000000000000000000000000000000000000000000000",
+				"transactions": ["0xcc00000000000000000000000000000000000000000000000000000000000000"]
+			}
+		}`
+		tracesJSON = `{
+			"jsonrpc": "2.0",
+			"id": "2",
+			"result": [{"result": {"type": "CALL", "from": "0x1100000000000000000000000000000000000000", "value": "0x0", "input": "0x"}}]
+		}`
+	)
+	var ntraceBlock int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		diff.Test(t, t.Fatalf, nil, err)
+		switch {
+		case methodsMatch(t, body, "trace_block"):
+			atomic.AddInt32(&ntraceBlock, 1)
+			_, err = w.Write([]byte("[" + unsupportedJSON + "]"))
+		case methodsMatch(t, body, "eth_getBlockByNumber", "debug_traceBlockByNumber"):
+			_, err = w.Write([]byte("[" + blockJSON + "," + tracesJSON + "]"))
+		default:
+			t.Fatalf("unexpected request: %s", body)
+		}
+		diff.Test(t, t.Fatalf, nil, err)
+	}))
+	defer ts.Close()
+
+	var (
+		ctx = context.Background()
+		c   = New(ts.URL)
+	)
+	for i := 0; i < 2; i++ {
+		blocks, err := c.Get(ctx, c.NextURL().String(), &glf.Filter{UseTraces: true}, 42, 1)
+		diff.Test(t, t.Fatalf, nil, err)
+		diff.Test(t, t.Fatalf, len(blocks[0].Txs), 1)
+		diff.Test(t, t.Errorf, len(blocks[0].Txs[0].TraceActions), 1)
+	}
+	diff.Test(t, t.Errorf, ntraceBlock, int32(1))
+}
+
+func TestLatest_Follow(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		diff.Test(t, t.Fatalf, nil, json.NewDecoder(r.Body).Decode(&req))
+		diff.Test(t, t.Fatalf, req.Method, "eth_getBlockByNumber")
+		var res string
+		switch req.Params[0] {
+		case "finalized":
+			res = `{"number": "0x10", "hash": "0xaa00000000000000000000000000000000000000000000000000000000000000"}`
+		case "safe":
+			res = "null"
+		default:
+			t.Fatalf("unexpected tag: %v", req.Params[0])
+		}
+		_, err := fmt.Fprintf(w, `{"jsonrpc": "2.0", "id": "1", "result": %s}`, res)
+		diff.Test(t, t.Fatalf, nil, err)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := New(ts.URL).WithFollow("finalized")
+	n, h, err := c.Latest(ctx, c.NextURL().String(), 0)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, n, uint64(16))
+	diff.Test(t, t.Errorf, fmt.Sprintf("%.2x", h), "aa00")
+
+	c = New(ts.URL).WithFollow("safe")
+	_, _, err = c.Latest(ctx, c.NextURL().String(), 0)
+	diff.Test(t, t.Errorf, err.Error(), "no safe block")
+}
+
+func TestFixtures(t *testing.T) {
+	var nreqs atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nreqs.Add(1)
+		body, err := io.ReadAll(r.Body)
+		diff.Test(t, t.Fatalf, nil, err)
+		switch {
+		case methodsMatch(t, body, "eth_chainId"):
+			_, err = w.Write([]byte(`{"jsonrpc": "2.0", "id": "1", "result": "0x2105"}`))
+		case methodsMatch(t, body, "eth_getBlockByNumber"):
+			_, err = w.Write([]byte(block18000000JSON))
+		case methodsMatch(t, body, "eth_getBlockByNumber", "eth_getLogs"):
+			_, err = w.Write([]byte(logs18000000JSON))
+		}
+		diff.Test(t, t.Fatalf, nil, err)
+	}))
+	var (
+		ctx    = context.Background()
+		dir    = t.TempDir()
+		filter = &glf.Filter{UseBlocks: true, UseLogs: true}
+	)
+	c := New(ts.URL).WithFixtures(Record, dir)
+	id, err := c.ChainID(ctx, ts.URL)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, uint64(8453), id)
+	want, err := c.Get(ctx, ts.URL, filter, 18000000, 1)
+	diff.Test(t, t.Fatalf, nil, err)
+	ts.Close()
+
+	files, err := os.ReadDir(dir)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, int(nreqs.Load()), len(files))
+
+	c = New(ts.URL).WithFixtures(Replay, dir)
+	id, err = c.ChainID(ctx, ts.URL)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, uint64(8453), id)
+	got, err := c.Get(ctx, ts.URL, filter, 18000000, 1)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, got, want)
+
+	_, err = c.Get(ctx, ts.URL, filter, 18000001, 1)
+	if err == nil || !strings.Contains(err.Error(), "no fixture") {
+		t.Errorf("expected missing fixture error got: %v", err)
+	}
+}
+
+func TestReplaceIDs(t *testing.T) {
+	got, err := replaceIDs(
+		[]byte(`[{"id":"a","method":"x"},{"id":"b","method":"y"}]`),
+		[]byte(`[{"id":"c","method":"x"},{"id":"d","method":"y"}]`),
+		[]byte(`[{"id":"b","result":"0x2"},{"id":"a","result":"0x1"}]`),
+	)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, string(got), `[{"id":"d","result":"0x2"},{"id":"c","result":"0x1"}]`)
+}
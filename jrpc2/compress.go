@@ -0,0 +1,100 @@
+package jrpc2
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+const acceptEncoding = "zstd, gzip, deflate"
+
+// Requests compressed responses and decompresses them.
+// Logs and receipts are mostly hex so they compress well
+// and large responses over slow links are several times
+// faster compressed.
+type compressor struct {
+	next http.RoundTripper
+}
+
+func (c compressor) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(req.Header.Get("Accept-Encoding")) > 0 {
+		return c.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	var newReader func(io.Reader) (io.ReadCloser, error)
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip":
+		newReader = func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		}
+	case "deflate":
+		newReader = newDeflateReader
+	case "zstd":
+		newReader = func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		}
+	default:
+		return resp, nil
+	}
+	resp.Body = &decoder{body: resp.Body, newReader: newReader}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// deflate is supposed to be zlib wrapped
+// but some servers send raw deflate
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	h, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// Creates the reader on the first Read so that
+// an empty body isn't an error until it's read
+type decoder struct {
+	body      io.ReadCloser
+	newReader func(io.Reader) (io.ReadCloser, error)
+	r         io.ReadCloser
+	err       error
+}
+
+func (d *decoder) Read(p []byte) (int, error) {
+	if d.r == nil && d.err == nil {
+		d.r, d.err = d.newReader(d.body)
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.r.Read(p)
+}
+
+func (d *decoder) Close() error {
+	if d.r != nil {
+		d.r.Close()
+	}
+	return d.body.Close()
+}
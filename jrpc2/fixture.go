@@ -0,0 +1,236 @@
+package jrpc2
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/goccy/go-json"
+)
+
+type FixtureMode int
+
+const (
+	Record FixtureMode = iota + 1
+	Replay
+)
+
+// Uses fixtures in dir for http requests. In Record mode each
+// request and its response are written to dir. In Replay mode
+// responses are read from dir and no requests are made; a
+// request without a fixture fails.
+//
+// Fixtures are named by method and a hash of the request with
+// its ids removed (ids are random) followed by the number of
+// times the same request was made. This way a request whose
+// response changes (eg latest block) is replayed in the order
+// it was recorded. Once the recorded responses are used up the
+// last one is repeated.
+//
+// Websocket subscriptions aren't recorded.
+func (c *Client) WithFixtures(mode FixtureMode, dir string) *Client {
+	c.hc.Transport = &fixtures{
+		mode: mode,
+		dir:  dir,
+		next: c.hc.Transport,
+		seen: map[string]int{},
+	}
+	return c
+}
+
+// Sets fixtures using SHOVEL_RPC_RECORD or SHOVEL_RPC_REPLAY
+// so that a bug report can include the responses of the
+// provider that caused it.
+func (c *Client) fixturesFromEnv() *Client {
+	if dir := os.Getenv("SHOVEL_RPC_RECORD"); len(dir) > 0 {
+		return c.WithFixtures(Record, dir)
+	}
+	if dir := os.Getenv("SHOVEL_RPC_REPLAY"); len(dir) > 0 {
+		return c.WithFixtures(Replay, dir)
+	}
+	return c
+}
+
+type fixture struct {
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response"`
+}
+
+type fixtures struct {
+	mode FixtureMode
+	dir  string
+	next http.RoundTripper
+
+	mut  sync.Mutex
+	seen map[string]int
+}
+
+func (f *fixtures) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading request: %w", err)
+	}
+	name, err := fixtureName(body)
+	if err != nil {
+		return nil, err
+	}
+	f.mut.Lock()
+	n := f.seen[name]
+	f.seen[name]++
+	f.mut.Unlock()
+
+	switch f.mode {
+	case Record:
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		resp, err := f.next.RoundTrip(req)
+		if err != nil || resp.StatusCode/100 != 2 {
+			return resp, err
+		}
+		rb, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading response: %w", err)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(rb))
+		return resp, f.write(name, n, fixture{body, rb})
+	case Replay:
+		fx, err := f.read(name, n)
+		if err != nil {
+			return nil, err
+		}
+		rb, err := replaceIDs(fx.Request, body, fx.Response)
+		if err != nil {
+			return nil, fmt.Errorf("fixture %s: %w", name, err)
+		}
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"application/json"}},
+			Body:          io.NopCloser(bytes.NewReader(rb)),
+			ContentLength: int64(len(rb)),
+			Request:       req,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown fixture mode: %d", f.mode)
+	}
+}
+
+func (f *fixtures) path(name string, n int) string {
+	return filepath.Join(f.dir, fmt.Sprintf("%s-%d.json", name, n))
+}
+
+func (f *fixtures) write(name string, n int, fx fixture) error {
+	b, err := json.MarshalIndent(fx, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encoding fixture: %w", err)
+	}
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return fmt.Errorf("creating fixture dir: %w", err)
+	}
+	if err := os.WriteFile(f.path(name, n), b, 0644); err != nil {
+		return fmt.Errorf("writing fixture: %w", err)
+	}
+	return nil
+}
+
+func (f *fixtures) read(name string, n int) (fixture, error) {
+	var fx fixture
+	for ; n >= 0; n-- {
+		b, err := os.ReadFile(f.path(name, n))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fx, fmt.Errorf("reading fixture: %w", err)
+		}
+		if err := json.Unmarshal(b, &fx); err != nil {
+			return fx, fmt.Errorf("decoding fixture %s: %w", name, err)
+		}
+		return fx, nil
+	}
+	return fx, fmt.Errorf("no fixture for %s in %s", name, f.dir)
+}
+
+// Decodes a request or a batch into a slice of objects
+func objects(b []byte) ([]map[string]json.RawMessage, bool, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '[' {
+		var res []map[string]json.RawMessage
+		err := json.Unmarshal(b, &res)
+		return res, true, err
+	}
+	var res map[string]json.RawMessage
+	err := json.Unmarshal(b, &res)
+	return []map[string]json.RawMessage{res}, false, err
+}
+
+func fixtureName(body []byte) (string, error) {
+	reqs, batch, err := objects(body)
+	if err != nil {
+		return "", fmt.Errorf("decoding request: %w", err)
+	}
+	if len(reqs) == 0 {
+		return "", fmt.Errorf("empty batch")
+	}
+	for i := range reqs {
+		delete(reqs[i], "id")
+	}
+	// maps are encoded with sorted keys
+	b, err := json.Marshal(reqs)
+	if err != nil {
+		return "", fmt.Errorf("encoding request: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	var method string
+	json.Unmarshal(reqs[0]["method"], &method)
+	if batch {
+		method += "-batch"
+	}
+	return fmt.Sprintf("%s-%s", method, hex.EncodeToString(sum[:8])), nil
+}
+
+// Rewrites the ids in the recorded response to match the
+// ids of the current request. Requests are matched by their
+// position since the hash of both requests is the same.
+func replaceIDs(recorded, current, response []byte) ([]byte, error) {
+	oldReqs, _, err := objects(recorded)
+	if err != nil {
+		return nil, fmt.Errorf("decoding recorded request: %w", err)
+	}
+	newReqs, _, err := objects(current)
+	if err != nil {
+		return nil, fmt.Errorf("decoding request: %w", err)
+	}
+	if len(oldReqs) != len(newReqs) {
+		return nil, fmt.Errorf("batch size %d != %d", len(oldReqs), len(newReqs))
+	}
+	ids := map[string]json.RawMessage{}
+	for i := range oldReqs {
+		ids[string(oldReqs[i]["id"])] = newReqs[i]["id"]
+	}
+	resps, batch, err := objects(response)
+	if err != nil {
+		return nil, fmt.Errorf("decoding recorded response: %w", err)
+	}
+	for i := range resps {
+		if id, ok := ids[string(resps[i]["id"])]; ok {
+			resps[i]["id"] = id
+		}
+	}
+	if batch {
+		return json.Marshal(resps)
+	}
+	return json.Marshal(resps[0])
+}
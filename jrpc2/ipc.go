@@ -0,0 +1,94 @@
+package jrpc2
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indexsupply/shovel/wctx"
+
+	"github.com/goccy/go-json"
+)
+
+// Urls of the form ipc:///path/to/geth.ipc are requested over
+// the node's unix socket. Requests and responses are JSON
+// values written back to back on the connection (geth and
+// reth don't delimit them) so a connection carries one request
+// at a time and concurrent requests use separate connections.
+// Idle connections are kept for reuse.
+type ipcPool struct {
+	mut  sync.Mutex
+	idle map[string][]*ipcConn
+}
+
+const ipcScheme = "ipc://"
+
+func isIPC(url string) bool {
+	return strings.HasPrefix(url, ipcScheme)
+}
+
+type ipcConn struct {
+	net.Conn
+	dec *json.Decoder // buffers reads so it stays with the conn
+}
+
+func (p *ipcPool) get(ctx context.Context, path string) (*ipcConn, error) {
+	p.mut.Lock()
+	if n := len(p.idle[path]); n > 0 {
+		c := p.idle[path][n-1]
+		p.idle[path] = p.idle[path][:n-1]
+		p.mut.Unlock()
+		return c, nil
+	}
+	p.mut.Unlock()
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &ipcConn{Conn: nc, dec: json.NewDecoder(nc)}, nil
+}
+
+func (p *ipcPool) put(path string, c *ipcConn) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.idle == nil {
+		p.idle = map[string][]*ipcConn{}
+	}
+	if len(p.idle[path]) >= 16 {
+		c.Close()
+		return
+	}
+	p.idle[path] = append(p.idle[path], c)
+}
+
+func (c *Client) doIPC(ctx context.Context, url string, dest, req any) error {
+	path := strings.TrimPrefix(url, ipcScheme)
+	conn, err := c.ipc.get(ctx, path)
+	if err != nil {
+		return fmt.Errorf("unable to dial ipc: %w", err)
+	}
+	deadline := time.Now().Add(c.hc.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return fmt.Errorf("setting ipc deadline: %w", err)
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		conn.Close()
+		return fmt.Errorf("unable to write ipc request: %w", err)
+	}
+	if err := c.decode(conn.dec, dest); err != nil {
+		conn.Close()
+		return fmt.Errorf("unable to json decode: %w", err)
+	}
+	c.ipc.put(path, conn)
+	wctx.CounterAdd(ctx, 1)
+	return nil
+}
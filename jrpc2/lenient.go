@@ -0,0 +1,194 @@
+package jrpc2
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// Nodes for some chains (eg BSC, Polygon, Celo, and some
+// L2s) return values that the eth types reject: null
+// quantities, "0x", quantities with leading zeros or in
+// decimal, and empty or odd length hex data. When enabled,
+// responses are rewritten into canonical form before
+// they're decoded. Decoding is slower so it's only meant
+// for sources that need it.
+func (c *Client) WithLenient(enabled bool) *Client {
+	c.lenient = enabled
+	return c
+}
+
+func (c *Client) decode(dec *json.Decoder, dest any) error {
+	if !c.lenient {
+		return dec.Decode(dest)
+	}
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	b, err := canonical(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dest)
+}
+
+// Fields decoded into [eth.Uint64], [eth.Byte], or
+// [uint256.Int]
+var quantityFields = map[string]bool{
+	"amount":               true,
+	"blobGasUsed":          true,
+	"chainId":              true,
+	"cumulativeGasUsed":    true,
+	"depositValue":         true,
+	"effectiveGasPrice":    true,
+	"excessBlobGas":        true,
+	"gas":                  true,
+	"gasPrice":             true,
+	"gasUsed":              true,
+	"index":                true,
+	"l1BaseFee":            true,
+	"l1BatchNumber":        true,
+	"l1BatchTimestamp":     true,
+	"l1BatchTxIndex":       true,
+	"l1BlockNumber":        true,
+	"logIndex":             true,
+	"maxFeePerBlobGas":     true,
+	"maxFeePerGas":         true,
+	"maxPriorityFeePerGas": true,
+	"mint":                 true,
+	"nonce":                true,
+	"number":               true,
+	"r":                    true,
+	"s":                    true,
+	"sendCount":            true,
+	"status":               true,
+	"timestamp":            true,
+	"transactionIndex":     true,
+	"type":                 true,
+	"v":                    true,
+	"validatorIndex":       true,
+	"value":                true,
+}
+
+// Fields decoded into [eth.Bytes]
+var hexFields = map[string]bool{
+	"address":             true,
+	"blobVersionedHashes": true,
+	"blockHash":           true,
+	"contractAddress":     true,
+	"data":                true,
+	"from":                true,
+	"hash":                true,
+	"input":               true,
+	"logsBloom":           true,
+	"parentHash":          true,
+	"refundTo":            true,
+	"requestId":           true,
+	"sendRoot":            true,
+	"sourceHash":          true,
+	"ticketId":            true,
+	"to":                  true,
+	"topics":              true,
+	"transactionHash":     true,
+	"txHash":              true,
+}
+
+func canonical(raw []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	rewrite(v)
+	return json.Marshal(v)
+}
+
+func rewrite(v any) {
+	switch v := v.(type) {
+	case []any:
+		for i := range v {
+			rewrite(v[i])
+		}
+	case map[string]any:
+		for k, x := range v {
+			switch {
+			case x == nil && (quantityFields[k] || hexFields[k]):
+				delete(v, k)
+			case quantityFields[k]:
+				var s string
+				switch x := x.(type) {
+				case string:
+					s = x
+				case json.Number:
+					s = x.String()
+				default:
+					continue
+				}
+				q, ok := quantity(s)
+				switch {
+				case !ok:
+				case len(q) > 66:
+					delete(v, k)
+				default:
+					v[k] = q
+				}
+			case hexFields[k]:
+				switch x := x.(type) {
+				case string:
+					if len(x) == 0 {
+						delete(v, k)
+						continue
+					}
+					v[k] = hexData(x)
+				case []any:
+					for i := range x {
+						if s, ok := x[i].(string); ok {
+							x[i] = hexData(s)
+						}
+					}
+				}
+			default:
+				rewrite(x)
+			}
+		}
+	}
+}
+
+// Returns s as 0x prefixed hex without leading zeros.
+// Decimal numbers are converted. Returns false when s
+// is neither.
+func quantity(s string) (string, bool) {
+	if h, ok := strings.CutPrefix(s, "0x"); ok {
+		h = strings.TrimLeft(h, "0")
+		for i := range h {
+			if !isHex(h[i]) {
+				return "", false
+			}
+		}
+		if len(h) == 0 {
+			h = "0"
+		}
+		return "0x" + h, true
+	}
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 {
+		return "", false
+	}
+	return "0x" + n.Text(16), true
+}
+
+func hexData(s string) string {
+	h, ok := strings.CutPrefix(s, "0x")
+	if !ok || len(h)%2 == 0 {
+		return s
+	}
+	return "0x0" + h
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,126 @@
+package jrpc2
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/indexsupply/shovel/wctx"
+)
+
+// Token bucket shared by every client of a source.
+// The bucket holds up to one second of requests.
+//
+// Batches take one token per call and may take more
+// tokens than the bucket holds, in which case later
+// requests wait for the deficit to be repaid.
+//
+// Callers take turns reserving tokens. Waiting callers
+// are ordered by [wctx.Priority] and then by arrival so
+// that when the source is saturated higher priority
+// integrations are served first.
+type Limiter struct {
+	mut    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	busy  bool
+	queue []*waiter
+}
+
+type waiter struct {
+	priority int
+	ready    chan struct{}
+}
+
+// rps is the number of calls per second
+func NewLimiter(rps float64) *Limiter {
+	burst := max(1, rps)
+	return &Limiter{rate: rps, burst: burst, tokens: burst}
+}
+
+func (l *Limiter) Rate() float64 {
+	return l.rate
+}
+
+// Returns how long to wait before sending n calls
+func (l *Limiter) reserve(now time.Time, n int) time.Duration {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if !l.last.IsZero() {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Queues w behind waiters of the same or higher priority
+// and gives the turn to the head of the queue when no one
+// has it. Must hold mut.
+func (l *Limiter) push(w *waiter) {
+	i := 0
+	for i < len(l.queue) && l.queue[i].priority >= w.priority {
+		i++
+	}
+	l.queue = append(l.queue, nil)
+	copy(l.queue[i+1:], l.queue[i:])
+	l.queue[i] = w
+	l.next()
+}
+
+// Must hold mut
+func (l *Limiter) next() {
+	if l.busy || len(l.queue) == 0 {
+		return
+	}
+	w := l.queue[0]
+	l.queue = l.queue[1:]
+	l.busy = true
+	close(w.ready)
+}
+
+// Removes w from the queue or, if w has the turn,
+// passes the turn to the next waiter
+func (l *Limiter) done(w *waiter) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	for i := range l.queue {
+		if l.queue[i] == w {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			return
+		}
+	}
+	l.busy = false
+	l.next()
+}
+
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	w := &waiter{priority: wctx.Priority(ctx), ready: make(chan struct{})}
+	l.mut.Lock()
+	l.push(w)
+	l.mut.Unlock()
+	defer l.done(w)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.ready:
+	}
+	d := l.reserve(time.Now(), n)
+	if d == 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
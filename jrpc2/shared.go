@@ -0,0 +1,146 @@
+package jrpc2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/indexsupply/shovel/wctx"
+	"github.com/indexsupply/shovel/wprom"
+
+	"github.com/goccy/go-json"
+)
+
+// Responses shared by the tasks that use a client. Integrations
+// reading the same source tend to request the same blocks at
+// about the same time (eg when following the head) so the first
+// request for a key is sent and the others wait for its response
+// instead of making their own.
+//
+// Entries are removed once they have been read maxreads times
+// (ie by every integration), after sharedTTL, or when the
+// responses held exceed sharedBytes.
+type shared struct {
+	mut      sync.Mutex
+	maxreads int
+	nbytes   int
+	entries  map[string]*entry
+}
+
+const (
+	sharedTTL   = 30 * time.Second
+	sharedBytes = 64 << 20
+)
+
+var mShared = wprom.NewCounter("shovel_rpc_shared_total", "responses read from another task's request", "src", "kind")
+
+type entry struct {
+	t      time.Time
+	done   chan struct{}
+	nreads int
+	size   int
+	raws   []json.RawMessage
+	err    error
+}
+
+// Returns the entry for k. When owner is true the caller must
+// request the data and call fill. Otherwise the caller waits
+// for the owner's response.
+func (s *shared) claim(k string) (e *entry, owner bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	now := time.Now()
+	if s.entries == nil {
+		s.entries = map[string]*entry{}
+	}
+	s.prune(now)
+	if e, ok := s.entries[k]; ok {
+		e.nreads++
+		return e, false
+	}
+	e = &entry{t: now, done: make(chan struct{}), nreads: 1}
+	s.entries[k] = e
+	return e, true
+}
+
+// Must hold mut
+func (s *shared) prune(now time.Time) {
+	var oldest string
+	for k, e := range s.entries {
+		select {
+		case <-e.done:
+		default:
+			continue // in flight
+		}
+		if e.nreads >= s.maxreads || now.Sub(e.t) > sharedTTL {
+			s.remove(k)
+			continue
+		}
+		if len(oldest) == 0 || e.t.Before(s.entries[oldest].t) {
+			oldest = k
+		}
+	}
+	if s.nbytes > sharedBytes && len(oldest) > 0 {
+		s.remove(oldest)
+	}
+}
+
+// Must hold mut
+func (s *shared) remove(k string) {
+	s.nbytes -= s.entries[k].size
+	delete(s.entries, k)
+}
+
+// Failed requests and responses that must be requested again
+// (see [storable]) are given to the current waiters but aren't
+// kept so that the next caller makes its own request.
+func (s *shared) fill(k string, e *entry, raws []json.RawMessage, err error) {
+	keep := err == nil
+	for i := range raws {
+		keep = keep && storable(raws[i])
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	e.raws, e.err = raws, err
+	if s.entries[k] == e {
+		switch {
+		case !keep:
+			delete(s.entries, k)
+		default:
+			for i := range raws {
+				e.size += len(raws[i])
+			}
+			s.nbytes += e.size
+		}
+	}
+	close(e.done)
+}
+
+func (e *entry) wait(ctx context.Context, kind string) ([]json.RawMessage, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-e.done:
+	}
+	if e.err == nil {
+		mShared.Add(uint64(len(e.raws)), wctx.SrcName(ctx), kind)
+	}
+	return e.raws, e.err
+}
+
+// Sends reqs unless another task is sending the request
+// identified by k in which case its responses are returned.
+func (c *Client) doShared(ctx context.Context, url, kind, k string, reqs []request) ([]json.RawMessage, error) {
+	e, owner := c.shared.claim(k)
+	if !owner {
+		return e.wait(ctx, kind)
+	}
+	raws := make([]json.RawMessage, len(reqs))
+	err := c.do(ctx, url, &raws, reqs)
+	if err == nil && len(raws) < len(reqs) {
+		err = fmt.Errorf("%s: expected %d responses got %d", kind, len(reqs), len(raws))
+	}
+	c.shared.fill(k, e, raws, err)
+	return raws, err
+}
@@ -0,0 +1,196 @@
+package jrpc2
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/indexsupply/shovel/wctx"
+	"github.com/indexsupply/shovel/wprom"
+
+	"github.com/goccy/go-json"
+)
+
+// Persistent storage for rpc responses of finalized blocks.
+// Responses are stored as raw JSON and keyed by chain, kind
+// (eg blocks, headers, receipts), and block number.
+//
+// Only blocks that are at least finality blocks behind the
+// latest block are stored. Since these blocks are immutable
+// the stored responses are reused when a block range is
+// indexed again (eg by a new integration or a backfill)
+// instead of being requested from the rpc provider.
+//
+// Logs and traces are not stored. eth_getLogs responses
+// depend on an integration's filter and so are not reusable.
+type Store interface {
+	// Returns responses for blocks in [start, start+limit).
+	// Missing blocks are omitted from the result.
+	Get(ctx context.Context, chainID uint64, kind string, start, limit uint64) (map[uint64][]byte, error)
+	Put(ctx context.Context, chainID uint64, kind string, data map[uint64][]byte) error
+}
+
+var (
+	mStoreHits   = wprom.NewCounter("shovel_rpc_store_hits_total", "block responses read from the store", "src", "kind")
+	mStoreMisses = wprom.NewCounter("shovel_rpc_store_misses_total", "block responses requested from the rpc provider", "src", "kind")
+)
+
+func (c *Client) WithStore(s Store, chainID, finality uint64) *Client {
+	c.store = s
+	c.chainID = chainID
+	c.finality = finality
+	return c
+}
+
+// Greatest block number that is considered final.
+// Returns false when the latest block isn't yet known.
+func (c *Client) final() (uint64, bool) {
+	c.lcache.Lock()
+	n := uint64(c.lcache.Num)
+	c.lcache.Unlock()
+	if n <= c.finality {
+		return 0, false
+	}
+	return n - c.finality, true
+}
+
+// Like do but reqs[i] is a request for block start+i and its
+// response is decoded into dest[i]. When the client has a
+// store, responses for finalized blocks are read from the
+// store and only the missing blocks are requested. Requests
+// for receipts that another task is requesting wait for its
+// response (see [shared]).
+func (c *Client) doBlocks(ctx context.Context, url, kind string, start uint64, reqs []request, dest []any) error {
+	var (
+		final, ok = c.final()
+		useStore  = c.store != nil && ok && final >= start
+		stored    map[uint64][]byte
+		missing   []int
+	)
+	if useStore {
+		var err error
+		limit := min(uint64(len(reqs)), final-start+1)
+		stored, err = c.store.Get(ctx, c.chainID, kind, start, limit)
+		if err != nil {
+			slog.WarnContext(ctx, "rpc-store-get", "kind", kind, "error", err)
+		}
+	}
+	for i := range reqs {
+		if raw, ok := stored[start+uint64(i)]; ok {
+			if err := json.Unmarshal(raw, dest[i]); err == nil {
+				continue
+			}
+		}
+		missing = append(missing, i)
+	}
+	if useStore {
+		mStoreHits.Add(uint64(len(reqs)-len(missing)), wctx.SrcName(ctx), kind)
+		mStoreMisses.Add(uint64(len(missing)), wctx.SrcName(ctx), kind)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	raws, err := c.fetchBlocks(ctx, url, kind, start, missing, reqs)
+	if err != nil {
+		return err
+	}
+	put := map[uint64][]byte{}
+	for j, i := range missing {
+		if err := json.Unmarshal(raws[j], dest[i]); err != nil {
+			return fmt.Errorf("decoding %s response: %w", kind, err)
+		}
+		if num := start + uint64(i); useStore && num <= final && storable(raws[j]) {
+			put[num] = raws[j]
+		}
+	}
+	if len(put) == 0 {
+		return nil
+	}
+	if err := c.store.Put(ctx, c.chainID, kind, put); err != nil {
+		slog.WarnContext(ctx, "rpc-store-put", "kind", kind, "error", err)
+	}
+	return nil
+}
+
+// Returns the responses to reqs[i] for each i in idx.
+// Receipts that another task is requesting aren't requested.
+// Blocks and headers are shared by [cache] instead.
+func (c *Client) fetchBlocks(ctx context.Context, url, kind string, start uint64, idx []int, reqs []request) ([]json.RawMessage, error) {
+	var (
+		raws    = make([]json.RawMessage, len(idx))
+		owned   []int
+		batch   []request
+		keys    = make([]string, len(idx))
+		entries = make([]*entry, len(idx))
+	)
+	for j, i := range idx {
+		if c.nocache || kind != "receipts" {
+			owned = append(owned, j)
+			batch = append(batch, reqs[i])
+			continue
+		}
+		keys[j] = fmt.Sprintf("%s-%d", kind, start+uint64(i))
+		e, owner := c.shared.claim(keys[j])
+		entries[j] = e
+		if owner {
+			owned = append(owned, j)
+			batch = append(batch, reqs[i])
+		}
+	}
+	if len(batch) > 0 {
+		resps := make([]json.RawMessage, len(batch))
+		err := c.do(ctx, url, &resps, batch)
+		if err == nil && len(resps) < len(batch) {
+			err = fmt.Errorf("%s: expected %d responses got %d", kind, len(batch), len(resps))
+		}
+		for n, j := range owned {
+			if entries[j] == nil {
+				continue
+			}
+			var raw []json.RawMessage
+			if err == nil {
+				raw = resps[n : n+1]
+			}
+			c.shared.fill(keys[j], entries[j], raw, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for n, j := range owned {
+			raws[j] = resps[n]
+		}
+	}
+	for j := range idx {
+		if raws[j] != nil {
+			continue
+		}
+		res, err := entries[j].wait(ctx, kind)
+		if err != nil {
+			return nil, err
+		}
+		raws[j] = res[0]
+	}
+	return raws, nil
+}
+
+// Responses with an error or without a result
+// (eg the provider hasn't yet seen the block)
+// must be requested again.
+func storable(raw []byte) bool {
+	var resp struct {
+		Error  `json:"error"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return false
+	}
+	switch {
+	case resp.Error.Exists():
+		return false
+	case len(resp.Result) == 0, bytes.Equal(resp.Result, []byte("null")):
+		return false
+	default:
+		return true
+	}
+}
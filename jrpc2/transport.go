@@ -0,0 +1,97 @@
+package jrpc2
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTP settings for a client's urls. Zero values use the
+// defaults. Unlike [http.DefaultTransport], which keeps 2
+// idle connections per host, connections are kept for
+// reuse so that concurrent requests don't open (and leave
+// in TIME_WAIT) a connection each.
+type Transport struct {
+	MaxIdleConns          int           // per host. defaults to 100
+	IdleConnTimeout       time.Duration // defaults to 90s
+	KeepAlive             time.Duration // tcp keep-alive period. defaults to 30s
+	ResponseHeaderTimeout time.Duration // defaults to none
+	DisableHTTP2          bool
+
+	// Responses are requested uncompressed. See [compressor].
+	DisableCompression bool
+
+	// Client certificates and root CAs, eg for nodes
+	// behind mutual TLS. Also used by the websocket.
+	TLS *tls.Config
+
+	// http, https, or socks5 proxy for requests and the
+	// websocket. When nil the HTTP_PROXY, HTTPS_PROXY, and
+	// NO_PROXY env vars are used.
+	Proxy *url.URL
+}
+
+func (t Transport) proxy() func(*http.Request) (*url.URL, error) {
+	if t.Proxy == nil {
+		return http.ProxyFromEnvironment
+	}
+	return http.ProxyURL(t.Proxy)
+}
+
+func (t Transport) roundTripper() http.RoundTripper {
+	var (
+		maxIdle     = 100
+		idleTimeout = 90 * time.Second
+		keepAlive   = 30 * time.Second
+	)
+	if t.MaxIdleConns > 0 {
+		maxIdle = t.MaxIdleConns
+	}
+	if t.IdleConnTimeout > 0 {
+		idleTimeout = t.IdleConnTimeout
+	}
+	if t.KeepAlive > 0 {
+		keepAlive = t.KeepAlive
+	}
+	ht := &http.Transport{
+		Proxy: t.proxy(),
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: keepAlive,
+		}).DialContext,
+		ForceAttemptHTTP2:     !t.DisableHTTP2,
+		MaxIdleConns:          maxIdle,
+		MaxIdleConnsPerHost:   maxIdle,
+		IdleConnTimeout:       idleTimeout,
+		ResponseHeaderTimeout: t.ResponseHeaderTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig:       t.TLS,
+		DisableCompression:    true, // see compressor
+	}
+	if t.DisableHTTP2 {
+		// a non-nil empty map disables http2
+		ht.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	if t.DisableCompression {
+		return ht
+	}
+	return compressor{ht}
+}
+
+func (c *Client) WithTransport(t Transport) *Client {
+	// the websocket's handshake isn't compressed
+	// and its connection isn't reused
+	c.wshc = &http.Client{Transport: &http.Transport{
+		Proxy:           t.proxy(),
+		TLSClientConfig: t.TLS,
+	}}
+	if f, ok := c.hc.Transport.(*fixtures); ok {
+		f.next = t.roundTripper()
+		return c
+	}
+	c.hc.Transport = t.roundTripper()
+	return c
+}
@@ -0,0 +1,134 @@
+// Recursive Length Prefix encoding/decoding
+//
+// Decoding doesn't copy. Items and payloads are sub-slices
+// of the input and are only valid as long as the input is.
+package rlp
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/indexsupply/shovel/bint"
+)
+
+var ErrShort = errors.New("rlp: input too short")
+
+// Splits the first item from b. The payload is the item's
+// content: a string's bytes or a list's encoded items.
+// item is the encoding of the first item including its prefix.
+func Split(b []byte) (item, payload, rest []byte, list bool, err error) {
+	if len(b) == 0 {
+		return nil, nil, nil, false, ErrShort
+	}
+	var (
+		p      = b[0]
+		hlen   int
+		plen   int
+		islist = p >= 0xc0
+	)
+	switch {
+	case p < 0x80:
+		return b[:1], b[:1], b[1:], false, nil
+	case p <= 0xb7:
+		hlen, plen = 1, int(p-0x80)
+	case p < 0xc0:
+		n := int(p - 0xb7)
+		if len(b) < 1+n {
+			return nil, nil, nil, false, ErrShort
+		}
+		hlen, plen = 1+n, int(bint.Decode(b[1:1+n]))
+	case p <= 0xf7:
+		hlen, plen = 1, int(p-0xc0)
+	default:
+		n := int(p - 0xf7)
+		if len(b) < 1+n {
+			return nil, nil, nil, false, ErrShort
+		}
+		hlen, plen = 1+n, int(bint.Decode(b[1:1+n]))
+	}
+	if plen < 0 || len(b)-hlen < plen {
+		return nil, nil, nil, false, ErrShort
+	}
+	end := hlen + plen
+	return b[:end], b[hlen:end], b[end:], islist, nil
+}
+
+// Returns the encoded items of the list encoded in b
+func List(b []byte) ([][]byte, error) {
+	_, payload, _, list, err := Split(b)
+	if err != nil {
+		return nil, err
+	}
+	if !list {
+		return nil, fmt.Errorf("rlp: expected list")
+	}
+	var items [][]byte
+	for len(payload) > 0 {
+		item, _, rest, _, err := Split(payload)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		payload = rest
+	}
+	return items, nil
+}
+
+// Returns the bytes of the string encoded in b
+func Bytes(b []byte) ([]byte, error) {
+	_, payload, _, list, err := Split(b)
+	if err != nil {
+		return nil, err
+	}
+	if list {
+		return nil, fmt.Errorf("rlp: expected string")
+	}
+	return payload, nil
+}
+
+func Uint64(b []byte) (uint64, error) {
+	d, err := Bytes(b)
+	if err != nil {
+		return 0, err
+	}
+	if len(d) > 8 {
+		return 0, fmt.Errorf("rlp: uint64 overflow")
+	}
+	return bint.Decode(d), nil
+}
+
+func header(b []byte, offset byte, n int) []byte {
+	if n <= 55 {
+		return append(b, offset+byte(n))
+	}
+	l := bint.Encode(nil, uint64(n))
+	b = append(b, offset+55+byte(len(l)))
+	return append(b, l...)
+}
+
+func EncodeBytes(d []byte) []byte {
+	if len(d) == 1 && d[0] < 0x80 {
+		return []byte{d[0]}
+	}
+	return append(header(make([]byte, 0, len(d)+9), 0x80, len(d)), d...)
+}
+
+func EncodeUint64(n uint64) []byte {
+	if n == 0 {
+		return []byte{0x80}
+	}
+	return EncodeBytes(bint.Encode(nil, n))
+}
+
+// Encodes a list of items that are already encoded
+func EncodeList(items ...[]byte) []byte {
+	var n int
+	for i := range items {
+		n += len(items[i])
+	}
+	b := header(make([]byte, 0, n+9), 0xc0, n)
+	for i := range items {
+		b = append(b, items[i]...)
+	}
+	return b
+}
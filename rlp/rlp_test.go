@@ -0,0 +1,61 @@
+package rlp
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"kr.dev/diff"
+)
+
+func TestEncode(t *testing.T) {
+	lorem := "Lorem ipsum dolor sit amet, consectetur adipisicing elit"
+	cases := []struct {
+		got  []byte
+		want string
+	}{
+		{EncodeBytes(nil), "80"},
+		{EncodeBytes([]byte{0x0f}), "0f"},
+		{EncodeBytes([]byte("dog")), "83646f67"},
+		{EncodeBytes([]byte(lorem)), "b838" + hex.EncodeToString([]byte(lorem))},
+		{EncodeUint64(0), "80"},
+		{EncodeUint64(1024), "820400"},
+		{EncodeList(), "c0"},
+		{EncodeList(EncodeBytes([]byte("cat")), EncodeBytes([]byte("dog"))), "c88363617483646f67"},
+		{EncodeList(EncodeList(), EncodeList(EncodeList())), "c3c0c1c0"},
+	}
+	for _, tc := range cases {
+		diff.Test(t, t.Errorf, hex.EncodeToString(tc.got), tc.want)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	lorem := strings.Repeat("a", 1024)
+	b := EncodeList(
+		EncodeBytes([]byte("cat")),
+		EncodeUint64(1024),
+		EncodeList(EncodeBytes([]byte(lorem))),
+	)
+	items, err := List(b)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Fatalf, len(items), 3)
+
+	s, err := Bytes(items[0])
+	diff.Test(t, t.Errorf, err, nil)
+	diff.Test(t, t.Errorf, string(s), "cat")
+
+	n, err := Uint64(items[1])
+	diff.Test(t, t.Errorf, err, nil)
+	diff.Test(t, t.Errorf, n, uint64(1024))
+
+	inner, err := List(items[2])
+	diff.Test(t, t.Fatalf, err, nil)
+	s, err = Bytes(inner[0])
+	diff.Test(t, t.Errorf, err, nil)
+	diff.Test(t, t.Errorf, string(s), lorem)
+
+	_, err = Bytes(items[2])
+	diff.Test(t, t.Errorf, err != nil, true)
+	_, err = List(b[:len(b)-1])
+	diff.Test(t, t.Errorf, err, ErrShort)
+}
@@ -0,0 +1,301 @@
+// Checks alert rules against the running tasks
+//
+// A [Checker] is given a [Sample] of each task's lag and error
+// count every interval. When a rule's condition starts or stops
+// holding for a task an [Alert] is sent to every hook. Alerts
+// are only sent on changes so a stuck task results in a single
+// firing alert followed by a resolved alert once it recovers.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/indexsupply/shovel/shovel/config"
+)
+
+type Sample struct {
+	Src    string
+	IG     string
+	Lag    uint64
+	Errors uint64 // total since the process started
+}
+
+type State string
+
+const (
+	Firing   State = "firing"
+	Resolved State = "resolved"
+)
+
+type Alert struct {
+	Rule    string    `json:"rule"`
+	Src     string    `json:"src"`
+	IG      string    `json:"ig"`
+	State   State     `json:"state"`
+	Value   uint64    `json:"value"`
+	Since   time.Time `json:"since"`
+	Message string    `json:"message"`
+}
+
+func (a Alert) key() string {
+	return a.Rule + "/" + a.Src + "/" + a.IG
+}
+
+type point struct {
+	t      time.Time
+	errors uint64
+}
+
+type status struct {
+	rule, src, ig string
+
+	// when the condition started holding. zero when it doesn't
+	since  time.Time
+	firing bool
+}
+
+type Checker struct {
+	conf config.Alerts
+	hc   *http.Client
+
+	// see [Checker.WithConfig]
+	reload func() config.Alerts
+
+	// for PagerDuty's Events API
+	pdURL string
+
+	status  map[string]*status
+	history map[string][]point
+}
+
+func New(conf config.Alerts) *Checker {
+	return &Checker{
+		conf:    conf,
+		hc:      &http.Client{Timeout: 10 * time.Second},
+		pdURL:   "https://events.pagerduty.com/v2/enqueue",
+		status:  map[string]*status{},
+		history: map[string][]point{},
+	}
+}
+
+// Replaces the checker's config with the result of f at
+// the start of each interval in [Checker.Run] so that
+// rules and hooks follow config reloads.
+func (c *Checker) WithConfig(f func() config.Alerts) *Checker {
+	c.reload = f
+	return c
+}
+
+// Number of errors within d of now
+func (c *Checker) errors(s Sample, now time.Time, d time.Duration) uint64 {
+	for _, p := range c.history[s.Src+"/"+s.IG] {
+		if now.Sub(p.t) <= d {
+			return s.Errors - min(p.errors, s.Errors)
+		}
+	}
+	return 0
+}
+
+func (c *Checker) record(samples []Sample, now time.Time) {
+	var keep time.Duration
+	for _, r := range c.conf.Rules {
+		keep = max(keep, r.Duration())
+	}
+	seen := map[string]bool{}
+	for _, s := range samples {
+		k := s.Src + "/" + s.IG
+		seen[k] = true
+		h := append(c.history[k], point{now, s.Errors})
+		for len(h) > 1 && now.Sub(h[1].t) >= keep {
+			h = h[1:]
+		}
+		c.history[k] = h
+	}
+	for k := range c.history {
+		if !seen[k] {
+			delete(c.history, k)
+		}
+	}
+}
+
+// Evaluates every rule against the samples and returns the
+// alerts whose state changed. Tasks that are no longer
+// sampled (eg removed integrations) are resolved.
+func (c *Checker) Check(samples []Sample, now time.Time) []Alert {
+	c.record(samples, now)
+	var (
+		res  []Alert
+		seen = map[string]bool{}
+	)
+	for _, r := range c.conf.Rules {
+		for _, s := range samples {
+			a := Alert{Rule: r.Name, Src: s.Src, IG: s.IG}
+			k := a.key()
+			seen[k] = true
+			st, ok := c.status[k]
+			if !ok {
+				st = &status{rule: r.Name, src: s.Src, ig: s.IG}
+				c.status[k] = st
+			}
+			var holds, ready bool
+			switch {
+			case r.MaxLag > 0:
+				a.Value = s.Lag
+				a.Message = fmt.Sprintf("%s/%s is %d blocks behind (max %d for %s)",
+					s.Src, s.IG, s.Lag, r.MaxLag, r.Duration())
+				holds = s.Lag > r.MaxLag
+				if holds && st.since.IsZero() {
+					st.since = now
+				}
+				ready = holds && now.Sub(st.since) >= r.Duration()
+			case r.MaxErrors > 0:
+				a.Value = c.errors(s, now, r.Duration())
+				a.Message = fmt.Sprintf("%s/%s had %d errors in %s (max %d)",
+					s.Src, s.IG, a.Value, r.Duration(), r.MaxErrors)
+				holds = a.Value > r.MaxErrors
+				if holds && st.since.IsZero() {
+					st.since = now
+				}
+				ready = holds
+			}
+			if !holds {
+				st.since = time.Time{}
+			}
+			switch {
+			case ready && !st.firing:
+				st.firing = true
+				a.State, a.Since = Firing, st.since
+				res = append(res, a)
+			case !holds && st.firing:
+				st.firing = false
+				a.State, a.Since = Resolved, now
+				res = append(res, a)
+			}
+		}
+	}
+	for k, st := range c.status {
+		if seen[k] {
+			continue
+		}
+		if st.firing {
+			res = append(res, Alert{
+				Rule:    st.rule,
+				Src:     st.src,
+				IG:      st.ig,
+				State:   Resolved,
+				Since:   now,
+				Message: fmt.Sprintf("%s/%s is no longer running", st.src, st.ig),
+			})
+		}
+		delete(c.status, k)
+	}
+	return res
+}
+
+// Calls samples every interval until ctx is done and
+// sends the alerts returned by [Checker.Check].
+func (c *Checker) Run(ctx context.Context, interval time.Duration, samples func() []Sample) {
+	if len(c.conf.Rules) == 0 && c.reload == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if c.reload != nil {
+				c.conf = c.reload()
+			}
+			for _, a := range c.Check(samples(), time.Now()) {
+				slog.WarnContext(ctx, "alert",
+					"rule", a.Rule,
+					"src", a.Src,
+					"ig", a.IG,
+					"state", a.State,
+					"value", a.Value,
+				)
+				c.Send(ctx, a)
+			}
+		}
+	}
+}
+
+// Sends the alert to every hook. Failures are logged
+// since there's nowhere else to report them.
+func (c *Checker) Send(ctx context.Context, a Alert) {
+	for _, h := range c.conf.Hooks {
+		if err := c.send(ctx, h, a); err != nil {
+			slog.ErrorContext(ctx, "alert-hook", "kind", h.Kind, "error", err)
+		}
+	}
+}
+
+func (c *Checker) send(ctx context.Context, h config.AlertHook, a Alert) error {
+	var (
+		url  = string(h.URL)
+		body any
+	)
+	switch h.Kind {
+	case "slack":
+		icon := ":red_circle:"
+		if a.State == Resolved {
+			icon = ":large_green_circle:"
+		}
+		body = map[string]string{
+			"text": fmt.Sprintf("%s shovel %s %s: %s", icon, a.Rule, a.State, a.Message),
+		}
+	case "pagerduty":
+		url = c.pdURL
+		action := "trigger"
+		if a.State == Resolved {
+			action = "resolve"
+		}
+		body = map[string]any{
+			"routing_key":  string(h.RoutingKey),
+			"event_action": action,
+			"dedup_key":    "shovel/" + a.key(),
+			"payload": map[string]any{
+				"summary":   a.Message,
+				"source":    "shovel",
+				"severity":  "error",
+				"timestamp": a.Since.Format(time.RFC3339),
+				"custom_details": map[string]any{
+					"rule":  a.Rule,
+					"src":   a.Src,
+					"ig":    a.IG,
+					"value": a.Value,
+				},
+			},
+		}
+	default:
+		body = a
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("encoding alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("content-type", "application/json")
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("post: %w", err)
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/indexsupply/shovel/shovel/config"
+	"github.com/indexsupply/shovel/wos"
+
+	"kr.dev/diff"
+)
+
+func states(alerts []Alert) []string {
+	var res []string
+	for _, a := range alerts {
+		res = append(res, a.key()+" "+string(a.State))
+	}
+	return res
+}
+
+func TestCheck_Lag(t *testing.T) {
+	c := New(config.Alerts{Rules: []config.AlertRule{{
+		Name:   "stall",
+		MaxLag: 10,
+		For:    "2m",
+	}}})
+	var (
+		now   = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		check = func(lag uint64, d time.Duration) []string {
+			now = now.Add(d)
+			return states(c.Check([]Sample{{Src: "a", IG: "b", Lag: lag}}, now))
+		}
+	)
+	diff.Test(t, t.Errorf, check(11, 0), []string(nil))
+	diff.Test(t, t.Errorf, check(11, time.Minute), []string(nil))
+	diff.Test(t, t.Errorf, check(11, time.Minute), []string{"stall/a/b firing"})
+	diff.Test(t, t.Errorf, check(11, time.Minute), []string(nil))
+	diff.Test(t, t.Errorf, check(10, time.Minute), []string{"stall/a/b resolved"})
+	diff.Test(t, t.Errorf, check(11, time.Minute), []string(nil))
+
+	// removed tasks are resolved
+	now = now.Add(2 * time.Minute)
+	diff.Test(t, t.Errorf, states(c.Check([]Sample{{Src: "a", IG: "b", Lag: 11}}, now)), []string{"stall/a/b firing"})
+	diff.Test(t, t.Errorf, states(c.Check(nil, now)), []string{"stall/a/b resolved"})
+}
+
+func TestCheck_Errors(t *testing.T) {
+	c := New(config.Alerts{Rules: []config.AlertRule{{
+		Name:      "errors",
+		MaxErrors: 2,
+		For:       "2m",
+	}}})
+	var (
+		now   = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		check = func(errors uint64) []string {
+			now = now.Add(time.Minute)
+			return states(c.Check([]Sample{{Src: "a", IG: "b", Errors: errors}}, now))
+		}
+	)
+	diff.Test(t, t.Errorf, check(5), []string(nil))
+	diff.Test(t, t.Errorf, check(7), []string(nil))
+	diff.Test(t, t.Errorf, check(8), []string{"errors/a/b firing"})
+	diff.Test(t, t.Errorf, check(10), []string(nil))
+	diff.Test(t, t.Errorf, check(10), []string{"errors/a/b resolved"})
+}
+
+func TestSend(t *testing.T) {
+	var got []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, body)
+	}))
+	defer srv.Close()
+
+	c := New(config.Alerts{Hooks: []config.AlertHook{
+		{Kind: "slack", URL: wos.EnvString(srv.URL)},
+		{Kind: "pagerduty", RoutingKey: "key"},
+		{Kind: "webhook", URL: wos.EnvString(srv.URL)},
+	}})
+	c.pdURL = srv.URL
+	c.Send(context.Background(), Alert{
+		Rule:    "stall",
+		Src:     "a",
+		IG:      "b",
+		State:   Resolved,
+		Message: "msg",
+	})
+	if len(got) != 3 {
+		t.Fatalf("expected 3 requests. got: %d", len(got))
+	}
+	diff.Test(t, t.Errorf, got[0]["text"], ":large_green_circle: shovel stall resolved: msg")
+	diff.Test(t, t.Errorf, got[1]["event_action"], "resolve")
+	diff.Test(t, t.Errorf, got[1]["dedup_key"], "shovel/stall/a/b")
+	diff.Test(t, t.Errorf, got[2]["state"], "resolved")
+}
+
+func TestRun_WithConfig(t *testing.T) {
+	got := make(chan map[string]any, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		select {
+		case got <- body:
+		default:
+		}
+	}))
+	defer srv.Close()
+
+	// starts without rules and picks them up from the reloaded config
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := New(config.Alerts{}).WithConfig(func() config.Alerts {
+		return config.Alerts{
+			Rules: []config.AlertRule{{Name: "errors", MaxErrors: 1, For: "1h"}},
+			Hooks: []config.AlertHook{{Kind: "webhook", URL: wos.EnvString(srv.URL)}},
+		}
+	})
+	var errors uint64
+	go c.Run(ctx, time.Millisecond, func() []Sample {
+		errors += 10
+		return []Sample{{Src: "a", IG: "b", Errors: errors}}
+	})
+	select {
+	case body := <-got:
+		diff.Test(t, t.Errorf, body["state"], "firing")
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an alert")
+	}
+}
@@ -0,0 +1,252 @@
+package shovel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/indexsupply/shovel/eth"
+	"github.com/indexsupply/shovel/shovel/config"
+	"github.com/indexsupply/shovel/shovel/glf"
+	"github.com/indexsupply/shovel/wctx"
+	"github.com/indexsupply/shovel/wpg"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// The integration name used by an atomic source's task for
+// logs, metrics, and [Manager.Pause]. Progress is recorded
+// in task_updates using each integration's name.
+const AtomicName = "_atomic"
+
+type member struct {
+	ig          config.Integration
+	dest        Destination
+	start, stop uint64
+}
+
+// Inserts every member's rows using the caller's pg tx.
+// Members may be at different heights (eg an integration was
+// added to the source) so each member skips the blocks it
+// has already indexed or that are outside of its range.
+type group struct {
+	src     string
+	members []member
+}
+
+func newGroup(src string, members []member) (*group, error) {
+	g := &group{src: src}
+	for _, m := range members {
+		dest, err := NewDestination(m.ig)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m.ig.Name, err)
+		}
+		m.dest = dest
+		g.members = append(g.members, m)
+	}
+	return g, nil
+}
+
+func memberLatest(ctx context.Context, pg wpg.Conn, src, ig string) (uint64, error) {
+	const q = `
+		select num
+		from shovel.task_updates
+		where src_name = $1
+		and ig_name = $2
+		order by num desc
+		limit 1
+	`
+	var n uint64
+	err := pg.QueryRow(ctx, q, src, ig).Scan(&n)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (g *group) Insert(ctx context.Context, pgmut *sync.Mutex, pg wpg.Conn, blocks []eth.Block) (int64, error) {
+	var res int64
+	for _, m := range g.members {
+		pgmut.Lock()
+		latest, err := memberLatest(ctx, pg, g.src, m.ig.Name)
+		pgmut.Unlock()
+		if err != nil {
+			return 0, fmt.Errorf("%s: querying latest: %w", m.ig.Name, err)
+		}
+		// blocks are sorted so the member's blocks are contiguous
+		lo, hi := 0, len(blocks)
+		for lo < hi && (blocks[lo].Num() <= latest || blocks[lo].Num() < m.start) {
+			lo++
+		}
+		for hi > lo && m.stop > 0 && blocks[hi-1].Num() > m.stop {
+			hi--
+		}
+		if lo == hi {
+			continue
+		}
+		n, err := m.dest.Insert(wctx.WithIGName(ctx, m.ig.Name), pgmut, pg, blocks[lo:hi])
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", m.ig.Name, err)
+		}
+		res += n
+	}
+	return res, nil
+}
+
+func (g *group) Delete(ctx context.Context, pg wpg.Conn, n uint64) error {
+	for _, m := range g.members {
+		if err := m.dest.Delete(ctx, pg, n); err != nil {
+			return fmt.Errorf("%s: %w", m.ig.Name, err)
+		}
+	}
+	return nil
+}
+
+func (g *group) Filter() glf.Filter {
+	var filters []glf.Filter
+	for _, m := range g.members {
+		filters = append(filters, m.dest.Filter())
+	}
+	return glf.Union(filters...)
+}
+
+func (g *group) Reset() {
+	for _, m := range g.members {
+		if f, ok := m.dest.(flusher); ok {
+			f.Reset()
+		}
+	}
+}
+
+func (g *group) Flush(ctx context.Context, pg wpg.Conn) error {
+	var errs []error
+	for _, m := range g.members {
+		if f, ok := m.dest.(flusher); ok {
+			errs = append(errs, f.Flush(ctx, pg))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (g *group) Stage(ctx context.Context, pg wpg.Conn) error {
+	for _, m := range g.members {
+		if s, ok := m.dest.(stager); ok {
+			if err := s.Stage(ctx, pg); err != nil {
+				return fmt.Errorf("%s: %w", m.ig.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Orders integrations so that each one's dependencies
+// are inserted before it in the same pg tx
+func sortDependencies(igs []config.Integration) []config.Integration {
+	var (
+		res    []config.Integration
+		added  = map[string]bool{}
+		byName = map[string]config.Integration{}
+		add    func(ig config.Integration)
+	)
+	for _, ig := range igs {
+		byName[ig.Name] = ig
+	}
+	add = func(ig config.Integration) {
+		if added[ig.Name] {
+			return
+		}
+		added[ig.Name] = true
+		for _, dep := range ig.Dependencies {
+			if d, ok := byName[dep]; ok {
+				add(d)
+			}
+		}
+		res = append(res, ig)
+	}
+	for _, ig := range igs {
+		add(ig)
+	}
+	return res
+}
+
+// Builds the single task for an atomic source. Every
+// integration must write to the same database since
+// a pg tx can't span databases.
+func atomicTask(ctx context.Context, pools Pools, c config.Root, sc config.Source, src Source, igs []config.Integration) (*Task, error) {
+	igs = sortDependencies(igs)
+	var (
+		members     []member
+		names       []string
+		start, stop uint64
+		pg          = pools.For(igs[0])
+		// the task runs at its highest member's priority
+		top = igs[0]
+	)
+	for i, ig := range igs {
+		if pools.For(ig) != pg {
+			const tag = "atomic source %s: %s and %s use different databases"
+			return nil, fmt.Errorf(tag, sc.Name, igs[0].Name, ig.Name)
+		}
+		var ref config.Source
+		for _, r := range ig.Sources {
+			if r.Name == sc.Name {
+				ref = r
+			}
+		}
+		m := member{ig: ig}
+		m.start, m.stop = sc.Range(ref)
+		if m.stop > 0 && m.start > m.stop {
+			const tag = "%s start (%d) exceeds %s stop (%d)"
+			return nil, fmt.Errorf(tag, ig.Name, m.start, sc.Name, m.stop)
+		}
+		switch {
+		case i == 0:
+			start, stop = m.start, m.stop
+		default:
+			start = min(start, m.start)
+			if stop > 0 && m.stop > 0 {
+				stop = max(stop, m.stop)
+			} else {
+				stop = 0
+			}
+		}
+		members = append(members, m)
+		names = append(names, ig.Name)
+		if ig.PriorityLevel() > top.PriorityLevel() {
+			top = ig
+		}
+	}
+	ctx = wctx.WithChainID(ctx, sc.ChainID)
+	ctx = wctx.WithSrcName(ctx, sc.Name)
+	ctx = wctx.WithIGName(ctx, AtomicName)
+	task, err := NewTask(
+		WithContext(ctx),
+		WithPG(pg),
+		WithRange(start, stop),
+		WithPollDuration(sc.PollDuration),
+		WithConcurrency(sc.Concurrency, sc.BatchSize),
+		WithDecodeWorkers(sc.DecodeWorkers),
+		WithReorgDepth(sc.ReorgDepth),
+		WithAdaptive(sc.Adaptive),
+		WithMaxInsertLatency(c.Backpressure.Latency()),
+		WithSkip(sc.Skip),
+		WithVerifyChain(sc.VerifyChain),
+		WithSrcName(sc.Name),
+		WithChainID(sc.ChainID),
+		WithSource(src),
+		WithIntegration(config.Integration{
+			Name:     AtomicName,
+			Enabled:  true,
+			Priority: top.Priority,
+		}),
+		WithIntegrationFactory(func(config.Integration) (Destination, error) {
+			return newGroup(sc.Name, members)
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("setting up atomic task for %s: %w", sc.Name, err)
+	}
+	task.members = names
+	return task, nil
+}
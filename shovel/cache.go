@@ -0,0 +1,63 @@
+package shovel
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/indexsupply/shovel/wpg"
+)
+
+// Stores rpc responses for finalized blocks in
+// shovel.block_cache. See [jrpc2.Store].
+type BlockCache struct {
+	pg wpg.Conn
+}
+
+func (bc BlockCache) Get(ctx context.Context, chainID uint64, kind string, start, limit uint64) (map[uint64][]byte, error) {
+	const q = `
+		select num, data
+		from shovel.block_cache
+		where chain_id = $1
+		and kind = $2
+		and num >= $3
+		and num < $4
+	`
+	rows, err := bc.pg.Query(ctx, q, chainID, kind, start, start+limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying block cache: %w", err)
+	}
+	defer rows.Close()
+	res := map[uint64][]byte{}
+	for rows.Next() {
+		var (
+			num  uint64
+			data []byte
+		)
+		if err := rows.Scan(&num, &data); err != nil {
+			return nil, fmt.Errorf("scanning block cache: %w", err)
+		}
+		res[num] = data
+	}
+	return res, rows.Err()
+}
+
+func (bc BlockCache) Put(ctx context.Context, chainID uint64, kind string, data map[uint64][]byte) error {
+	var (
+		nums  = make([]uint64, 0, len(data))
+		datas = make([][]byte, 0, len(data))
+	)
+	for n, d := range data {
+		nums = append(nums, n)
+		datas = append(datas, d)
+	}
+	const q = `
+		insert into shovel.block_cache(chain_id, kind, num, data)
+		select $1, $2, unnest($3::numeric[]), unnest($4::bytea[])
+		on conflict do nothing
+	`
+	_, err := bc.pg.Exec(ctx, q, chainID, kind, nums, datas)
+	if err != nil {
+		return fmt.Errorf("inserting block cache: %w", err)
+	}
+	return nil
+}
@@ -1,20 +1,35 @@
 package config
 
 import (
+	"bytes"
 	"cmp"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/indexsupply/shovel/dig"
+	"github.com/indexsupply/shovel/eth"
+	"github.com/indexsupply/shovel/shovel/sink"
 	"github.com/indexsupply/shovel/wos"
 	"github.com/indexsupply/shovel/wpg"
 	"github.com/indexsupply/shovel/wstrings"
 
+	"github.com/BurntSushi/toml"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"gopkg.in/yaml.v3"
 )
 
 type Root struct {
@@ -22,25 +37,399 @@ type Root struct {
 	PGURL        string        `json:"pg_url"`
 	Sources      []Source      `json:"eth_sources"`
 	Integrations []Integration `json:"integrations"`
+	Telemetry    Telemetry     `json:"telemetry"`
+	Health       Health        `json:"health"`
+	Encoding     Encoding      `json:"encoding"`
+	Log          Log           `json:"log"`
+	Alerts       Alerts        `json:"alerts"`
+	Backpressure Backpressure  `json:"backpressure"`
+
+	// Databases other than pg_url. An integration naming
+	// one of these in its database field is written to it.
+	Databases []Database `json:"databases"`
+
+	// Files, directories, or globs whose sources and integrations
+	// are merged into this config. Relative paths are resolved
+	// against the directory of the file that includes them.
+	Include []string `json:"include"`
+
+	// Every file read by [Load], including the root file.
+	Files []string `json:"-"`
 }
 
-func union(a, b wpg.Table) wpg.Table {
-	for i := range b.Columns {
-		var found bool
-		for j := range a.Columns {
-			if b.Columns[i].Name == a.Columns[j].Name {
-				found = true
+// Reads the config file at path. The file's extension selects
+// the format: .yaml/.yml and .toml are supported in addition to JSON.
+//
+// YAML and TOML files are converted to JSON before decoding so that
+// every format shares the same schema and $ENV resolution. Unquoted
+// hex in YAML (eg an address) is kept as a string. TOML reads it as
+// an integer so it must be quoted.
+//
+// Env vars are interpolated into the fields listed in
+// [interpolated] (eg table and integration names) before
+// decoding. See [wos.Interpolate]. Other fields are kept as
+// written so that SQL expressions and the like aren't
+// mangled.
+//
+// Sources and integrations from files listed in the include
+// field are appended to the returned config.
+func Load(path string) (Root, error) {
+	return load(path, map[string]bool{})
+}
+
+func load(path string, seen map[string]bool) (Root, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return Root{}, fmt.Errorf("resolving %s: %w", path, err)
+	}
+	if seen[abs] {
+		return Root{}, fmt.Errorf("include cycle: %s", path)
+	}
+	seen[abs] = true
+	defer delete(seen, abs)
+
+	conf, err := decode(path)
+	if err != nil {
+		return conf, err
+	}
+	conf.Files = append(conf.Files, path)
+	for _, inc := range conf.Include {
+		paths, err := includePaths(filepath.Dir(path), inc)
+		if err != nil {
+			return conf, fmt.Errorf("include %q: %w", inc, err)
+		}
+		for _, p := range paths {
+			frag, err := load(p, seen)
+			if err != nil {
+				return conf, fmt.Errorf("include %q: %w", inc, err)
+			}
+			conf.Sources = append(conf.Sources, frag.Sources...)
+			conf.Integrations = append(conf.Integrations, frag.Integrations...)
+			conf.Files = append(conf.Files, frag.Files...)
+		}
+	}
+	if err := checkUniqueNames(conf); err != nil {
+		return conf, fmt.Errorf("%s: %w", path, err)
+	}
+	return conf, nil
+}
+
+// Expands an include entry into a sorted list of files.
+// Directories expand to the config files they contain.
+func includePaths(dir, inc string) ([]string, error) {
+	if !filepath.IsAbs(inc) {
+		inc = filepath.Join(dir, inc)
+	}
+	matches, err := filepath.Glob(inc)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no files found")
+	}
+	var res []string
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			res = append(res, m)
+			continue
+		}
+		entries, err := os.ReadDir(m)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			switch strings.ToLower(filepath.Ext(e.Name())) {
+			case ".json", ".yaml", ".yml", ".toml":
+				if !e.IsDir() {
+					res = append(res, filepath.Join(m, e.Name()))
+				}
+			}
+		}
+	}
+	slices.Sort(res)
+	return res, nil
+}
+
+func checkUniqueNames(conf Root) error {
+	var (
+		srcs = map[string]bool{}
+		igs  = map[string]bool{}
+	)
+	for _, sc := range conf.Sources {
+		if srcs[sc.Name] {
+			return fmt.Errorf("duplicate source: %s", sc.Name)
+		}
+		srcs[sc.Name] = true
+	}
+	for _, ig := range conf.Integrations {
+		if igs[ig.Name] {
+			return fmt.Errorf("duplicate integration: %s", ig.Name)
+		}
+		igs[ig.Name] = true
+	}
+	return nil
+}
+
+// Returns the config file at path and the files that it
+// includes, expanding globs and directories as [Load] does.
+// Files aren't decoded into a [Root] so that secrets
+// aren't read.
+func Files(path string) ([]string, error) {
+	return files(path, map[string]bool{})
+}
+
+func files(path string, seen map[string]bool) ([]string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", path, err)
+	}
+	if seen[abs] {
+		return nil, fmt.Errorf("include cycle: %s", path)
+	}
+	seen[abs] = true
+	defer delete(seen, abs)
+
+	m, err := parse(path)
+	if err != nil {
+		return nil, err
+	}
+	res := []string{path}
+	incs, _ := m["include"].([]any)
+	for _, x := range incs {
+		inc, ok := x.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: include must be a list of strings", path)
+		}
+		paths, err := includePaths(filepath.Dir(path), inc)
+		if err != nil {
+			return nil, fmt.Errorf("include %q: %w", inc, err)
+		}
+		for _, p := range paths {
+			fs, err := files(p, seen)
+			if err != nil {
+				return nil, fmt.Errorf("include %q: %w", inc, err)
+			}
+			res = append(res, fs...)
+		}
+	}
+	return res, nil
+}
+
+// Reads the file at path with env vars interpolated
+func parse(path string) (map[string]any, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	var m map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		var n yaml.Node
+		if err := yaml.Unmarshal(b, &n); err != nil {
+			return nil, fmt.Errorf("decoding yaml: %w", err)
+		}
+		hexStrings(&n)
+		if err := n.Decode(&m); err != nil {
+			return nil, fmt.Errorf("decoding yaml: %w", err)
+		}
+	case ".toml":
+		if err := toml.Unmarshal(b, &m); err != nil {
+			return nil, fmt.Errorf("decoding toml: %w", err)
+		}
+	default:
+		// numbers are kept as written (eg large chain ids)
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&m); err != nil {
+			return nil, fmt.Errorf("decoding json: %w", err)
+		}
+	}
+	if err := interpolate(m, nil); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+func decode(path string) (Root, error) {
+	var conf Root
+	m, err := parse(path)
+	if err != nil {
+		return conf, err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return conf, fmt.Errorf("converting %s to json: %w", path, err)
+	}
+	if err := json.Unmarshal(b, &conf); err != nil {
+		// eg an unquoted hex value in a toml file
+		var te *json.UnmarshalTypeError
+		if errors.As(err, &te) && te.Value == "number" && te.Type.Kind() == reflect.String {
+			return conf, fmt.Errorf("%s: %s must be a string. quote hex values", path, te.Field)
+		}
+		return conf, fmt.Errorf("decoding json: %w", err)
+	}
+	return conf, nil
+}
+
+// YAML resolves unquoted hex (eg 0x10 or an address) to a
+// number. Hex values in a config are addresses, topics, and
+// other bytes so they're kept as written.
+func hexStrings(n *yaml.Node) {
+	if n.Kind == yaml.ScalarNode && (n.Tag == "!!int" || n.Tag == "!!float") {
+		if strings.HasPrefix(n.Value, "0x") || strings.HasPrefix(n.Value, "0X") {
+			n.Tag = "!!str"
+		}
+	}
+	for _, c := range n.Content {
+		hexStrings(c)
+	}
+}
+
+// Fields that env vars are interpolated into. A * matches
+// any key or index and a field's path also matches the
+// strings below it (eg every field of a source).
+var interpolated = [][]string{
+	{"pg_url"},
+	{"eth_sources"},
+	{"databases", "*", "url"},
+	{"databases", "*", "query_url"},
+	{"dashboard", "root_password"},
+	{"dashboard", "query_pg_url"},
+	{"dashboard", "oidc", "issuer"},
+	{"dashboard", "oidc", "client_id"},
+	{"dashboard", "oidc", "client_secret"},
+	{"dashboard", "oidc", "redirect_url"},
+	{"telemetry", "endpoint"},
+	{"telemetry", "headers"},
+	{"alerts", "hooks", "*", "url"},
+	{"alerts", "hooks", "*", "routing_key"},
+	{"integrations", "*", "name"},
+	{"integrations", "*", "table", "name"},
+	{"integrations", "*", "sources"},
+	{"integrations", "*", "block", "*", "filter_arg"},
+	{"integrations", "*", "event", "inputs", "*", "filter_arg"},
+	{"integrations", "*", "events", "*", "inputs", "*", "filter_arg"},
+	{"integrations", "*", "sinks"},
+	{"integrations", "*", "export"},
+}
+
+func interpolates(path []string) bool {
+	for _, p := range interpolated {
+		if len(p) > len(path) {
+			continue
+		}
+		match := true
+		for i := range p {
+			if p[i] != "*" && p[i] != path[i] {
+				match = false
 				break
 			}
 		}
-		if !found {
-			a.Columns = append(a.Columns, wpg.Column{
-				Name: b.Columns[i].Name,
-				Type: b.Columns[i].Type,
-			})
+		if match {
+			return true
 		}
 	}
-	return a
+	return false
+}
+
+// Replaces each string in v whose path is in [interpolated]
+// with [wos.Interpolate]
+func interpolate(v any, path []string) error {
+	replace := func(k string, x any) (any, error) {
+		p := append(path[:len(path):len(path)], k)
+		s, ok := x.(string)
+		if !ok {
+			return x, interpolate(x, p)
+		}
+		if !interpolates(p) {
+			return s, nil
+		}
+		s, err := wos.Interpolate(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", strings.Join(p, "."), err)
+		}
+		return s, nil
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for k, x := range v {
+			x, err := replace(k, x)
+			if err != nil {
+				return err
+			}
+			v[k] = x
+		}
+	case []any:
+		for i, x := range v {
+			x, err := replace(strconv.Itoa(i), x)
+			if err != nil {
+				return err
+			}
+			v[i] = x
+		}
+	}
+	return nil
+}
+
+// Combines the definitions of a table that is shared by
+// integrations. Columns with the same name must have the
+// same type, encoding, and expression. Indexes are combined.
+// Since a table has one unique index, a's is used when set.
+func union(a, b wpg.Table) (wpg.Table, error) {
+	a.Columns = slices.Clone(a.Columns)
+	for _, bc := range b.Columns {
+		i := slices.IndexFunc(a.Columns, func(c wpg.Column) bool {
+			return c.Name == bc.Name
+		})
+		if i < 0 {
+			a.Columns = append(a.Columns, bc)
+			continue
+		}
+		ac := a.Columns[i]
+		switch {
+		case !strings.EqualFold(ac.Type, bc.Type):
+			return a, fmt.Errorf("column %s: type %s conflicts with %s", bc.Name, ac.Type, bc.Type)
+		case ac.Encoding != bc.Encoding:
+			return a, fmt.Errorf("column %s: encoding %q conflicts with %q", bc.Name, ac.Encoding, bc.Encoding)
+		case ac.Expr != bc.Expr:
+			return a, fmt.Errorf("column %s: expr %q conflicts with %q", bc.Name, ac.Expr, bc.Expr)
+		}
+	}
+	if len(a.Unique) == 0 {
+		a.Unique = b.Unique
+	}
+	a.DisableUnique = a.DisableUnique && b.DisableUnique
+	a.Index = slices.Clone(a.Index)
+	for _, cols := range b.Index {
+		if !slices.ContainsFunc(a.Index, func(x []string) bool { return slices.Equal(x, cols) }) {
+			a.Index = append(a.Index, cols)
+		}
+	}
+	a.Indexes = slices.Clone(a.Indexes)
+	for _, bi := range b.Indexes {
+		i := slices.IndexFunc(a.Indexes, func(x wpg.Index) bool {
+			return x.Ident(a.Name) == bi.Ident(b.Name)
+		})
+		if i < 0 {
+			a.Indexes = append(a.Indexes, bi)
+			continue
+		}
+		ai := a.Indexes[i]
+		if !slices.Equal(ai.Columns, bi.Columns) || ai.Method != bi.Method || ai.Where != bi.Where {
+			return a, fmt.Errorf("index %s has conflicting definitions", bi.Ident(b.Name))
+		}
+	}
+	switch {
+	case a.PartitionSize == 0:
+		a.PartitionSize = b.PartitionSize
+	case b.PartitionSize != 0 && a.PartitionSize != b.PartitionSize:
+		return a, fmt.Errorf("partition_size %d conflicts with %d", a.PartitionSize, b.PartitionSize)
+	}
+	return a, nil
 }
 
 func Migrate(ctx context.Context, pg wpg.Conn, conf Root) error {
@@ -52,32 +441,96 @@ func Migrate(ctx context.Context, pg wpg.Conn, conf Root) error {
 	return nil
 }
 
+// Statements that [Migrate] would run that change the database.
+// Used to review a migration before it is applied.
+func Plan(ctx context.Context, pg wpg.Conn, conf Root) ([]string, error) {
+	var res []string
+	for _, t := range Tables(conf) {
+		stmts, err := t.Plan(ctx, pg)
+		if err != nil {
+			return nil, fmt.Errorf("planning %s: %w", t.Name, err)
+		}
+		res = append(res, stmts...)
+	}
+	return res, nil
+}
+
 func DDL(conf Root) []string {
-	var tables = map[string]wpg.Table{}
+	var res []string
+	for _, t := range Tables(conf) {
+		res = append(res, t.DDL()...)
+	}
+	return res
+}
+
+// Integration tables sorted by name. Integrations
+// that share a table have their columns combined.
+// Conflicts are reported by [ValidateFix].
+func Tables(conf Root) []wpg.Table {
+	res, _ := tables(conf)
+	return res
+}
+
+func tables(conf Root) ([]wpg.Table, error) {
+	var (
+		errs   []error
+		byName = map[string]wpg.Table{}
+	)
 	for i := range conf.Integrations {
 		nt := conf.Integrations[i].Table
-		et, exists := tables[nt.Name]
+		et, exists := byName[nt.QName()]
 		if exists {
-			nt = union(nt, et)
+			var err error
+			nt, err = union(nt, et)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("table %s shared by %s: %w", nt.QName(), conf.Integrations[i].Name, err))
+			}
 		}
-		tables[nt.Name] = nt
+		byName[nt.QName()] = nt
 	}
-	var res []string
-	for _, t := range tables {
-		for _, stmt := range t.DDL() {
-			res = append(res, stmt)
-		}
+	var res []wpg.Table
+	for _, t := range byName {
+		res = append(res, t)
 	}
-	return res
+	slices.SortFunc(res, func(a, b wpg.Table) int {
+		return cmp.Compare(a.QName(), b.QName())
+	})
+	return res, errors.Join(errs...)
 }
 
 func ValidateFix(conf *Root) error {
+	for i := range conf.Integrations {
+		if err := conf.Integrations[i].applyPreset(); err != nil {
+			return fmt.Errorf("integration %s: %w", conf.Integrations[i].Name, err)
+		}
+		if err := conf.Integrations[i].expand(); err != nil {
+			return fmt.Errorf("integration %s: %w", conf.Integrations[i].Name, err)
+		}
+	}
 	if err := CheckUserInput(*conf); err != nil {
 		return fmt.Errorf("checking config for dangerous strings: %w", err)
 	}
+	if err := validateDatabases(*conf); err != nil {
+		return fmt.Errorf("checking databases: %w", err)
+	}
 	if err := ValidateFilterRefs(conf); err != nil {
 		return fmt.Errorf("checking config for filter_refs: %w", err)
 	}
+	if err := validateOIDC(conf.Dashboard.OIDC); err != nil {
+		return fmt.Errorf("checking dashboard oidc: %w", err)
+	}
+	if err := validateLog(conf.Log); err != nil {
+		return fmt.Errorf("checking log: %w", err)
+	}
+	if err := validateAlerts(conf.Alerts); err != nil {
+		return fmt.Errorf("checking alerts: %w", err)
+	}
+	if err := validateBackpressure(conf.Backpressure); err != nil {
+		return fmt.Errorf("checking backpressure: %w", err)
+	}
+	if r := conf.Telemetry.SampleRatio; r < 0 || r > 1 {
+		return fmt.Errorf("telemetry sample_ratio must be between 0 and 1. got: %v", r)
+	}
 	for i := range conf.Integrations {
 		if conf.Integrations[i].FilterAGG == "" {
 			conf.Integrations[i].FilterAGG = "or"
@@ -85,11 +538,64 @@ func ValidateFix(conf *Root) error {
 		if !slices.Contains([]string{"and", "or", ""}, conf.Integrations[i].FilterAGG) {
 			return fmt.Errorf("filter_agg must be one of: and, or. got: %s", conf.Integrations[i].FilterAGG)
 		}
+		if !slices.Contains([]string{"fail", "skip", "dead_letter", ""}, conf.Integrations[i].OnError) {
+			return fmt.Errorf("on_error must be one of: fail, skip, dead_letter. got: %s", conf.Integrations[i].OnError)
+		}
+		if !slices.Contains([]string{"high", "normal", "low", ""}, conf.Integrations[i].Priority) {
+			return fmt.Errorf("priority must be one of: high, normal, low. got: %s", conf.Integrations[i].Priority)
+		}
+		if err := validateWithdrawals(conf.Integrations[i]); err != nil {
+			return fmt.Errorf("checking withdrawals for %s: %w", conf.Integrations[i].Name, err)
+		}
+		if err := validateUnnest(conf.Integrations[i]); err != nil {
+			return fmt.Errorf("checking unnest for %s: %w", conf.Integrations[i].Name, err)
+		}
+		if err := validateFilters(conf.Integrations[i]); err != nil {
+			return fmt.Errorf("checking filters for %s: %w", conf.Integrations[i].Name, err)
+		}
+		if err := validateAnonymous(conf.Integrations[i]); err != nil {
+			return fmt.Errorf("checking anonymous events for %s: %w", conf.Integrations[i].Name, err)
+		}
+		if err := validateWildcard(conf.Integrations[i]); err != nil {
+			return fmt.Errorf("checking log capture for %s: %w", conf.Integrations[i].Name, err)
+		}
+		conf.Integrations[i].applyEncoding(conf.Encoding)
+		if err := validateEncoding(conf.Integrations[i].Table); err != nil {
+			return fmt.Errorf("checking encodings for %s: %w", conf.Integrations[i].Name, err)
+		}
+		if err := validateGenerated(conf.Integrations[i]); err != nil {
+			return fmt.Errorf("checking generated columns for %s: %w", conf.Integrations[i].Name, err)
+		}
+		for _, inp := range conf.Integrations[i].selected() {
+			if err := inp.ValidateTransform(); err != nil {
+				return fmt.Errorf("checking transforms for %s: input %s: %w", conf.Integrations[i].Name, inp.Name, err)
+			}
+		}
 		conf.Integrations[i].AddRequiredFields()
 		AddUniqueIndex(&conf.Integrations[i].Table)
+		if err := validatePartition(conf.Integrations[i].Table); err != nil {
+			return fmt.Errorf("checking partitions for %s: %w", conf.Integrations[i].Name, err)
+		}
+		if err := validateIndexes(conf.Integrations[i].Table); err != nil {
+			return fmt.Errorf("checking indexes for %s: %w", conf.Integrations[i].Name, err)
+		}
+		if err := validateExport(conf.Integrations[i]); err != nil {
+			return fmt.Errorf("checking export for %s: %w", conf.Integrations[i].Name, err)
+		}
+		if err := validateRetention(conf.Integrations[i]); err != nil {
+			return fmt.Errorf("checking retention for %s: %w", conf.Integrations[i].Name, err)
+		}
 		if err := ValidateColRefs(conf.Integrations[i]); err != nil {
 			return fmt.Errorf("checking config for references: %w", err)
 		}
+		for _, sc := range conf.Integrations[i].Sinks {
+			if err := sc.Validate(conf.Integrations[i].Table); err != nil {
+				return fmt.Errorf("checking sinks for %s: %w", conf.Integrations[i].Name, err)
+			}
+		}
+	}
+	if _, err := tables(*conf); err != nil {
+		return fmt.Errorf("checking shared tables: %w", err)
 	}
 	return nil
 }
@@ -97,6 +603,8 @@ func ValidateFix(conf *Root) error {
 // Checks each integration for a filter_ref and ensures that the referenced
 // integration exists and has the specified column.
 // Also ensures that the referenced table has an index on the column.
+// A filter_ref may instead name a table and column that are
+// not managed by shovel, in which case neither check is made.
 func ValidateFilterRefs(conf *Root) error {
 	var igs = map[string]*Integration{}
 	for i := range conf.Integrations {
@@ -111,11 +619,11 @@ func ValidateFilterRefs(conf *Root) error {
 
 	var cols = map[string]map[string]bool{}
 	for _, ig := range conf.Integrations {
-		if _, ok := cols[ig.Table.Name]; !ok {
-			cols[ig.Table.Name] = map[string]bool{}
+		if _, ok := cols[ig.Table.QName()]; !ok {
+			cols[ig.Table.QName()] = map[string]bool{}
 		}
 		for _, col := range ig.Table.Columns {
-			cols[ig.Table.Name][col.Name] = true
+			cols[ig.Table.QName()][col.Name] = true
 		}
 	}
 	colexists := func(table, col string) error {
@@ -126,6 +634,14 @@ func ValidateFilterRefs(conf *Root) error {
 	}
 
 	check := func(ref *dig.Ref) (bool, error) {
+		if len(ref.Refresh) > 0 {
+			if len(ref.Integration) > 0 {
+				return false, fmt.Errorf("filter_ref refresh is only used for tables that aren't managed by shovel")
+			}
+			if _, err := time.ParseDuration(ref.Refresh); err != nil {
+				return false, fmt.Errorf("filter_ref refresh: %w", err)
+			}
+		}
 		switch {
 		case len(ref.Integration) > 0:
 			if err := igexists(ref.Integration); err != nil {
@@ -135,39 +651,59 @@ func ValidateFilterRefs(conf *Root) error {
 			if len(ref.Column) == 0 {
 				return false, fmt.Errorf("missing filter_ref column")
 			}
-			var table = igs[ref.Integration].Table.Name
+			var table = igs[ref.Integration].Table.QName()
 			if err := colexists(table, ref.Column); err != nil {
 				return false, fmt.Errorf("filter_ref depends on %q: %w", ref.Column, err)
 			}
 			ref.Table = table
 			return true, nil
+		case len(ref.Table) > 0 && len(ref.Column) > 0:
+			// A table that isn't managed by shovel. Eg a list
+			// of addresses maintained by another process.
+			return false, nil
 		case len(ref.Table) > 0 || len(ref.Column) > 0:
-			return false, fmt.Errorf("filter_ref requires integration field")
+			return false, fmt.Errorf("filter_ref requires integration or table and column fields")
 		default:
 			return false, nil
 		}
 	}
+	// a dependency's table and task state must be
+	// in the dependent integration's database
+	sameDB := func(ig *Integration, ref dig.Ref) error {
+		if len(ref.Integration) == 0 || igs[ref.Integration].Database == ig.Database {
+			return nil
+		}
+		return fmt.Errorf("filter_ref depends on %q which uses a different database", ref.Integration)
+	}
 	for i := range conf.Integrations {
-		for j := range conf.Integrations[i].Event.Inputs {
-			ok, err := check(&conf.Integrations[i].Event.Inputs[j].Filter.Ref)
-			if err != nil {
-				return err
+		for _, inputs := range conf.Integrations[i].inputs() {
+			for j := range inputs {
+				ok, err := check(&inputs[j].Filter.Ref)
+				if err != nil {
+					return err
+				}
+				if err := sameDB(&conf.Integrations[i], inputs[j].Filter.Ref); err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+				var (
+					refName = inputs[j].Filter.Ref.Integration
+					refCol  = inputs[j].Filter.Ref.Column
+				)
+				conf.Integrations[i].Dependencies = append(
+					conf.Integrations[i].Dependencies,
+					refName,
+				)
+				igs[refName].Table.Index = append(igs[refName].Table.Index, []string{refCol})
 			}
-			if !ok {
-				continue
-			}
-			var (
-				refName = conf.Integrations[i].Event.Inputs[j].Filter.Ref.Integration
-				refCol  = conf.Integrations[i].Event.Inputs[j].Filter.Ref.Column
-			)
-			conf.Integrations[i].Dependencies = append(
-				conf.Integrations[i].Dependencies,
-				refName,
-			)
-			igs[refName].Table.Index = append(igs[refName].Table.Index, []string{refCol})
 		}
 		for j := range conf.Integrations[i].Block {
 			ok, err := check(&conf.Integrations[i].Block[j].Filter.Ref)
+			if err == nil {
+				err = sameDB(&conf.Integrations[i], conf.Integrations[i].Block[j].Filter.Ref)
+			}
 			if err != nil {
 				return fmt.Errorf("field %q: %w", conf.Integrations[i].Block[j].Name, err)
 			}
@@ -178,6 +714,16 @@ func ValidateFilterRefs(conf *Root) error {
 				refName = conf.Integrations[i].Block[j].Filter.Ref.Integration
 				refCol  = conf.Integrations[i].Block[j].Filter.Ref.Column
 			)
+			// the task reads log_addr's references as bytea
+			// (see shovel.Task.refreshAddresses)
+			for _, c := range igs[refName].Table.Columns {
+				if conf.Integrations[i].Block[j].Name != "log_addr" || c.Name != refCol {
+					continue
+				}
+				if !strings.EqualFold(c.Type, "bytea") {
+					return fmt.Errorf(`field "log_addr": filter_ref column %q must be bytea. got: %s`, refCol, c.Type)
+				}
+			}
 			conf.Integrations[i].Dependencies = append(
 				conf.Integrations[i].Dependencies,
 				refName,
@@ -200,11 +746,17 @@ func ValidateColRefs(ig Integration) error {
 		}
 		ucols[c.Name] = struct{}{}
 	}
-	for _, inp := range ig.Event.Inputs {
-		if _, ok := uinputs[inp.Name]; ok {
-			return fmt.Errorf("duplicate input: %s", inp.Name)
+	if len(ig.AllEvents()) > 0 && !ig.Function.Empty() {
+		return fmt.Errorf("event and function are mutually exclusive")
+	}
+	for _, inputs := range ig.inputs() {
+		clear(uinputs)
+		for _, inp := range inputs {
+			if _, ok := uinputs[inp.Name]; ok {
+				return fmt.Errorf("duplicate input: %s", inp.Name)
+			}
+			uinputs[inp.Name] = struct{}{}
 		}
-		uinputs[inp.Name] = struct{}{}
 	}
 	for _, bd := range ig.Block {
 		if _, ok := ubd[bd.Name]; ok {
@@ -212,8 +764,32 @@ func ValidateColRefs(ig Integration) error {
 		}
 		ubd[bd.Name] = struct{}{}
 	}
+	// Events that share a column must decode the same type
+	// into it. Transformed values may differ.
+	var (
+		colTypes  = map[string]string{}
+		colEvents = map[string]string{}
+	)
+	for _, ev := range ig.AllEvents() {
+		for _, inp := range ev.Selected() {
+			if len(inp.Transform) > 0 {
+				continue
+			}
+			t := inp.Type
+			if i := strings.LastIndex(t, "["); inp.Unnest && i > 0 {
+				t = t[:i]
+			}
+			prev, ok := colTypes[inp.Column]
+			if ok && prev != t {
+				const tag = "column %s: %s.%s is %s but %s is %s"
+				return fmt.Errorf(tag, inp.Column, ev.Name, inp.Name, t, colEvents[inp.Column], prev)
+			}
+			colTypes[inp.Column] = t
+			colEvents[inp.Column] = ev.Name
+		}
+	}
 	// Every selected input must have a coresponding column
-	for _, inp := range ig.Event.Selected() {
+	for _, inp := range ig.selected() {
 		var found bool
 		for _, c := range ig.Table.Columns {
 			if c.Name == inp.Column {
@@ -237,27 +813,543 @@ func ValidateColRefs(ig Integration) error {
 				break
 			}
 		}
-		if !found {
-			return fmt.Errorf("missing column for block.%s", bd.Name)
+		if !found {
+			return fmt.Errorf("missing column for block.%s", bd.Name)
+		}
+	}
+	// Every notification column must have a coresponding column
+	for _, colName := range ig.Notification.Columns {
+		var found bool
+		for _, c := range ig.Table.Columns {
+			if c.Name == colName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("missing column for notification.%s", colName)
+		}
+	}
+	return nil
+}
+
+// Checks that ABI types are canonical, since the signature hash
+// is computed from them (eg uint must be written as uint256),
+// and that each selected input's column can store its values.
+// Integers are stored as decimals so they may use any numeric
+// column that is wide enough.
+func ValidateTypes(ig Integration) error {
+	for _, inputs := range ig.inputs() {
+		for _, inp := range inputs {
+			if err := checkABIType(inp); err != nil {
+				return err
+			}
+		}
+	}
+	cols := map[string]string{}
+	for _, c := range ig.Table.Columns {
+		cols[c.Name] = strings.ToLower(c.Type)
+	}
+	encs := map[string]string{}
+	for _, c := range ig.Table.Columns {
+		encs[c.Name] = c.Encoding
+	}
+	for _, inp := range ig.selected() {
+		pgType, ok := cols[inp.Column]
+		if !ok || len(inp.Components) > 0 {
+			continue
+		}
+		// transformed values may use any column type
+		// that Postgres accepts
+		if len(inp.Transform) > 0 {
+			continue
+		}
+		if enc := encs[inp.Column]; len(enc) > 0 {
+			t, _ := elemType(inp.Type)
+			if !encodable(inp.Type) || (enc == "checksum" && t != "address") {
+				return fmt.Errorf("input %s: %s cannot be encoded as %s", inp.Name, inp.Type, enc)
+			}
+			continue
+		}
+		if !compatible(inp.Type, pgType) {
+			const tag = "column %s (%s) cannot store %s (%s)"
+			return fmt.Errorf(tag, inp.Column, pgType, inp.Name, inp.Type)
+		}
+	}
+	return nil
+}
+
+// Arrays are removed since each element is stored in its own row
+func elemType(t string) (string, bool) {
+	for strings.HasSuffix(t, "]") {
+		i := strings.LastIndex(t, "[")
+		if i < 0 {
+			return t, false
+		}
+		if n := t[i+1 : len(t)-1]; len(n) > 0 {
+			if _, err := strconv.ParseUint(n, 10, 64); err != nil {
+				return t, false
+			}
+		}
+		t = t[:i]
+	}
+	return t, true
+}
+
+func checkABIType(inp dig.Input) error {
+	t, ok := elemType(inp.Type)
+	if !ok {
+		return fmt.Errorf("input %s: invalid array type %s", inp.Name, inp.Type)
+	}
+	bits := func(prefix string) (int, bool) {
+		n, err := strconv.Atoi(strings.TrimPrefix(t, prefix))
+		return n, err == nil
+	}
+	switch {
+	case t == "tuple":
+		if len(inp.Components) == 0 {
+			return fmt.Errorf("input %s: tuple without components", inp.Name)
+		}
+		for _, c := range inp.Components {
+			if err := checkABIType(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	case t == "address", t == "bool", t == "string", t == "bytes":
+		return nil
+	case t == "uint", t == "int":
+		return fmt.Errorf("input %s: %s must be written as %s256", inp.Name, t, t)
+	case strings.HasPrefix(t, "uint"), strings.HasPrefix(t, "int"):
+		n, ok := bits(strings.TrimRight(t, "0123456789"))
+		if !ok || n < 8 || n > 256 || n%8 != 0 {
+			return fmt.Errorf("input %s: invalid integer type %s", inp.Name, t)
+		}
+		return nil
+	case strings.HasPrefix(t, "bytes"):
+		n, ok := bits("bytes")
+		if !ok || n < 1 || n > 32 {
+			return fmt.Errorf("input %s: invalid type %s", inp.Name, t)
+		}
+		return nil
+	default:
+		return fmt.Errorf("input %s: unknown type %s", inp.Name, inp.Type)
+	}
+}
+
+func compatible(abiType, pgType string) bool {
+	t, _ := elemType(abiType)
+	switch {
+	case strings.HasPrefix(t, "uint"), strings.HasPrefix(t, "int"):
+		n, _ := strconv.Atoi(strings.TrimPrefix(strings.TrimPrefix(t, "u"), "int"))
+		if strings.HasPrefix(t, "uint") {
+			n++ // unsigned needs the sign bit
+		}
+		switch pgType {
+		case "numeric", "decimal", "text":
+			return true
+		case "int8", "bigint":
+			return n <= 64
+		case "int4", "int", "integer":
+			return n <= 32
+		case "int2", "smallint":
+			return n <= 16
+		default:
+			return strings.HasPrefix(pgType, "numeric")
+		}
+	case t == "bool":
+		return pgType == "bool" || pgType == "boolean"
+	case t == "string":
+		return pgType == "text" || strings.HasPrefix(pgType, "varchar")
+	default:
+		return pgType == "bytea"
+	}
+}
+
+// Default encodings for the columns of event and function
+// inputs. Numbers applies to integer types, Addresses to
+// address types, and Bytes to bytes types (and address
+// types when Addresses is empty). A column with a default encoding
+// is given the encoding's type (eg text for hex) while
+// columns with their own encoding are left as is.
+// See [wpg.Column].
+type Encoding struct {
+	Numbers   string `json:"numbers"`
+	Addresses string `json:"addresses"`
+	Bytes     string `json:"bytes"`
+}
+
+func encodable(abiType string) bool {
+	t, _ := elemType(abiType)
+	return t != "bool" && t != "string" && t != "tuple"
+}
+
+func (e Encoding) forType(abiType string) string {
+	t, _ := elemType(abiType)
+	switch {
+	case strings.HasPrefix(t, "uint"), strings.HasPrefix(t, "int"):
+		return e.Numbers
+	case t == "address" && len(e.Addresses) > 0:
+		return e.Addresses
+	case t == "address", strings.HasPrefix(t, "bytes"):
+		return e.Bytes
+	default:
+		return ""
+	}
+}
+
+var encodingTypes = map[string]string{
+	"numeric":  "numeric",
+	"bytea":    "bytea",
+	"hex":      "text",
+	"decimal":  "text",
+	"checksum": "text",
+}
+
+// Sets the encoding of input columns that don't have one
+func (ig *Integration) applyEncoding(e Encoding) {
+	for _, inp := range ig.selected() {
+		enc := e.forType(inp.Type)
+		if len(enc) == 0 || len(inp.Transform) > 0 {
+			continue
+		}
+		for i := range ig.Table.Columns {
+			c := &ig.Table.Columns[i]
+			if c.Name != inp.Column || len(c.Encoding) > 0 {
+				continue
+			}
+			c.Encoding = enc
+			if t, ok := encodingTypes[enc]; ok {
+				c.Type = t
+			}
+		}
+	}
+}
+
+// Encoded values are written as the column's type
+// so the column must be able to store them.
+func validateEncoding(t wpg.Table) error {
+	for _, c := range t.Columns {
+		pgType := strings.ToLower(c.Type)
+		switch c.Encoding {
+		case "":
+			continue
+		case "numeric":
+			if pgType != "decimal" && !strings.HasPrefix(pgType, "numeric") {
+				return fmt.Errorf("column %s: numeric encoding requires a numeric column. got: %s", c.Name, c.Type)
+			}
+		case "bytea":
+			if pgType != "bytea" {
+				return fmt.Errorf("column %s: bytea encoding requires a bytea column. got: %s", c.Name, c.Type)
+			}
+		case "hex", "decimal", "checksum":
+			if pgType != "text" && !strings.HasPrefix(pgType, "varchar") {
+				return fmt.Errorf("column %s: %s encoding requires a text column. got: %s", c.Name, c.Encoding, c.Type)
+			}
+		default:
+			return fmt.Errorf("column %s: encoding must be one of: numeric, bytea, hex, decimal, checksum. got: %s", c.Name, c.Encoding)
+		}
+		// used for deletes and partitioning
+		if c.Name == "block_num" {
+			return fmt.Errorf("column block_num cannot be encoded")
+		}
+	}
+	return nil
+}
+
+// Generated columns are computed by Postgres from the
+// columns that shovel writes so they can't be written
+// themselves.
+func validateGenerated(ig Integration) error {
+	written := map[string]bool{}
+	for _, inp := range ig.selected() {
+		written[inp.Column] = true
+	}
+	for _, bd := range ig.Block {
+		written[bd.Column] = true
+	}
+	for _, c := range ig.Table.Columns {
+		switch {
+		case len(c.Expr) == 0:
+		case strings.Contains(c.Expr, ";"), strings.Contains(c.Expr, "--"), strings.Contains(c.Expr, "/*"):
+			return fmt.Errorf("column %s: expr must be a single expression", c.Name)
+		case written[c.Name]:
+			return fmt.Errorf("column %s: generated column cannot be written by an input or block data", c.Name)
+		case len(c.Encoding) > 0:
+			return fmt.Errorf("column %s: generated column cannot have an encoding", c.Name)
+		}
+	}
+	return nil
+}
+
+// sets default unique columns unless already set by user
+// Postgres requires unique indexes on partitioned
+// tables to include the partition key.
+func validatePartition(t wpg.Table) error {
+	if t.PartitionSize == 0 {
+		return nil
+	}
+	if !slices.ContainsFunc(t.Columns, func(c wpg.Column) bool { return c.Name == "block_num" }) {
+		return fmt.Errorf("partitioned table %s requires a block_num column", t.Name)
+	}
+	for _, u := range t.Unique {
+		if !slices.Contains(u, "block_num") {
+			return fmt.Errorf("unique index on partitioned table %s must include block_num", t.Name)
+		}
+	}
+	return nil
+}
+
+func validateRetention(ig Integration) error {
+	r := ig.Retention
+	if r.Blocks > 0 && r.Days > 0 {
+		return fmt.Errorf("retention blocks and days are mutually exclusive")
+	}
+	if r.Days > 0 && len(ig.BlockTimeColumn()) == 0 {
+		return fmt.Errorf("retention days requires block_time block data")
+	}
+	return nil
+}
+
+func validateExport(ig Integration) error {
+	if ig.Export.Empty() {
+		return nil
+	}
+	_, _, err := ig.Export.Bucket()
+	return err
+}
+
+// Withdrawal rows aren't part of a tx so they can only
+// be combined with block level data.
+func validateWithdrawals(ig Integration) error {
+	if !ig.withdrawals() {
+		return nil
+	}
+	if len(ig.AllEvents()) > 0 || !ig.Function.Empty() {
+		return fmt.Errorf("withdrawal data cannot be used with an event or function")
+	}
+	for _, bd := range ig.Block {
+		for _, p := range []string{"tx_", "log_", "trace_"} {
+			if strings.HasPrefix(bd.Name, p) {
+				return fmt.Errorf("withdrawal data cannot be used with %s", bd.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Unnested arrays share rows by element index. Nested
+// arrays and topics (which only hold an array's hash)
+// can't be unnested.
+func validateUnnest(ig Integration) error {
+	check := func(inputs []dig.Input) error {
+		for _, inp := range inputs {
+			if !inp.Unnest {
+				continue
+			}
+			switch {
+			case inp.Indexed:
+				return fmt.Errorf("%s: indexed inputs cannot be unnested", inp.Name)
+			case !strings.HasSuffix(inp.Type, "]"):
+				return fmt.Errorf("%s: unnest requires an array type. got: %s", inp.Name, inp.Type)
+			case strings.Count(inp.Type, "[") > 1:
+				return fmt.Errorf("%s: unnest requires a one dimensional array. got: %s", inp.Name, inp.Type)
+			}
+		}
+		return nil
+	}
+	for _, ev := range ig.AllEvents() {
+		if err := check(ev.Inputs); err != nil {
+			return err
+		}
+	}
+	return check(ig.Function.Inputs)
+}
+
+// Numeric comparisons use the first filter_arg so they
+// take one arg. range takes [min, max].
+func validateFilters(ig Integration) error {
+	check := func(name string, f dig.Filter) error {
+		if len(f.Arg) == 0 {
+			return nil
+		}
+		switch f.Op {
+		case "gt", "gte", "lt", "lte":
+			if len(f.Arg) != 1 {
+				return fmt.Errorf("field %q: %s filter requires 1 arg. got: %d", name, f.Op, len(f.Arg))
+			}
+		case "range":
+			if len(f.Arg) != 2 {
+				return fmt.Errorf("field %q: range filter requires 2 args [min, max]. got: %d", name, len(f.Arg))
+			}
+			lo, ok1 := decimal(f.Arg[0])
+			hi, ok2 := decimal(f.Arg[1])
+			switch {
+			case !ok1 || !ok2:
+				return fmt.Errorf("field %q: range filter args must be numbers. got: %v", name, f.Arg)
+			case lo.Cmp(hi) > 0:
+				return fmt.Errorf("field %q: range filter min %s is greater than max %s", name, f.Arg[0], f.Arg[1])
+			}
+		}
+		return nil
+	}
+	var walk func([]dig.Input) error
+	walk = func(inputs []dig.Input) error {
+		for _, inp := range inputs {
+			if err := check(inp.Name, inp.Filter); err != nil {
+				return err
+			}
+			if err := walk(inp.Components); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, inputs := range ig.inputs() {
+		if err := walk(inputs); err != nil {
+			return err
+		}
+	}
+	for _, bd := range ig.Block {
+		if err := check(bd.Name, bd.Filter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parses a filter arg the way dig does: decimal,
+// possibly negative, and possibly in scientific
+// notation (eg 1e18).
+func decimal(s string) (*big.Float, bool) {
+	f, _, err := big.ParseFloat(s, 10, 512, big.ToNearestEven)
+	return f, err == nil
+}
+
+// Without a topic0 an anonymous event would match every
+// log with the same layout so its addresses must be
+// filtered.
+func validateAnonymous(ig Integration) error {
+	var addrFilter bool
+	for _, bd := range ig.Block {
+		if bd.Name == "log_addr" && (len(bd.Filter.Arg) > 0 || len(bd.Filter.Ref.Table) > 0) {
+			addrFilter = true
+		}
+	}
+	for _, ev := range ig.AllEvents() {
+		var indexed int
+		for _, inp := range ev.Inputs {
+			if inp.Indexed {
+				indexed++
+			}
+		}
+		switch {
+		case !ev.Anon && len(ev.Topic0) > 0:
+			return fmt.Errorf("%s: topic0 requires an anonymous event", ev.Name)
+		case !ev.Anon:
+		case len(ev.Topic0) > 0 && len(eth.DecodeHex(ev.Topic0)) != 32:
+			return fmt.Errorf("%s: topic0 must be 32 bytes. got: %s", ev.Name, ev.Topic0)
+		case len(ev.Topic0) > 0 && indexed == 0:
+			return fmt.Errorf("%s: topic0 requires an indexed input", ev.Name)
+		case len(ev.Topic0) == 0 && !addrFilter:
+			return fmt.Errorf("%s: anonymous event without topic0 requires a log_addr filter", ev.Name)
+		}
+	}
+	return nil
+}
+
+// Integrations without an event or function that select
+// log data capture every log from the log_addr filter's
+// addresses. See [dig.Integration.SetABI].
+func (ig Integration) wildcard() bool {
+	if len(ig.AllEvents()) > 0 || !ig.Function.Empty() {
+		return false
+	}
+	for _, bd := range ig.Block {
+		if strings.HasPrefix(bd.Name, "log_") {
+			return true
+		}
+	}
+	return false
+}
+
+func validateWildcard(ig Integration) error {
+	if !ig.wildcard() {
+		if len(ig.ABI) > 0 {
+			return fmt.Errorf("abi requires log data without an event or function")
+		}
+		return nil
+	}
+	for _, bd := range ig.Block {
+		if bd.Name == "log_addr" && (len(bd.Filter.Arg) > 0 || len(bd.Filter.Ref.Table) > 0) {
+			return nil
+		}
+	}
+	return fmt.Errorf("capturing all logs requires a log_addr filter")
+}
+
+// Selects tuple members named by the event or function's
+// select paths so that their columns are validated and
+// added to the table like any other input.
+func (ig *Integration) expand() error {
+	var err error
+	if ig.Event, err = ig.Event.Expand(); err != nil {
+		return err
+	}
+	for i := range ig.Events {
+		if ig.Events[i], err = ig.Events[i].Expand(); err != nil {
+			return err
+		}
+	}
+	ig.Function, err = ig.Function.Expand()
+	return err
+}
+
+func (ig Integration) withdrawals() bool {
+	for _, bd := range ig.Block {
+		if strings.HasPrefix(bd.Name, "withdrawal_") {
+			return true
+		}
+	}
+	return false
+}
+
+// The column storing block_time or "" when not selected
+func (ig Integration) BlockTimeColumn() string {
+	for _, bd := range ig.Block {
+		if bd.Name == "block_time" {
+			return bd.Column
+		}
+	}
+	return ""
+}
+
+// Index names and columns are checked by [CheckUserInput].
+// The where predicate is SQL and is trusted like the rest
+// of the config, but is limited to a single statement.
+func validateIndexes(t wpg.Table) error {
+	for _, idx := range t.Indexes {
+		if len(idx.Columns) == 0 {
+			return fmt.Errorf("index on %s missing columns", t.Name)
+		}
+		for _, c := range idx.Columns {
+			if !slices.ContainsFunc(t.Columns, func(tc wpg.Column) bool { return tc.Name == c }) {
+				return fmt.Errorf("index column %s is not a column of %s", c, t.Name)
+			}
+		}
+		if !slices.Contains([]string{"", "btree", "brin", "hash"}, idx.Method) {
+			return fmt.Errorf("index method must be one of: btree, brin, hash. got: %s", idx.Method)
 		}
-	}
-	// Every notification column must have a coresponding column
-	for _, colName := range ig.Notification.Columns {
-		var found bool
-		for _, c := range ig.Table.Columns {
-			if c.Name == colName {
-				found = true
-				break
-			}
+		if idx.Method == "hash" && len(idx.Columns) > 1 {
+			return fmt.Errorf("hash index on %s must have a single column", t.Name)
 		}
-		if !found {
-			return fmt.Errorf("missing column for notification.%s", colName)
+		if strings.Contains(idx.Where, ";") || strings.Contains(idx.Where, "--") {
+			return fmt.Errorf("index predicate on %s must be a single expression", t.Name)
 		}
 	}
 	return nil
 }
 
-// sets default unique columns unless already set by user
 func AddUniqueIndex(table *wpg.Table) {
 	if len(table.Unique) > 0 {
 		return
@@ -269,7 +1361,9 @@ func AddUniqueIndex(table *wpg.Table) {
 		"tx_idx",
 		"log_idx",
 		"abi_idx",
+		"array_idx",
 		"trace_action_idx",
+		"withdrawal_index",
 	}
 	var uidx []string
 	for i := range possible {
@@ -302,20 +1396,39 @@ func CheckUserInput(conf Root) error {
 			}
 		}
 	)
+	// referenced tables may be schema qualified
+	checkQ := func(name, val string) {
+		schema, table, ok := strings.Cut(val, ".")
+		if ok {
+			check(name, schema)
+		}
+		check(name, table)
+	}
 	for _, ig := range conf.Integrations {
 		check("integration name", ig.Name)
 		check("table name", ig.Table.Name)
+		check("schema name", ig.Table.Schema)
 		for _, c := range ig.Table.Columns {
 			check("column name", c.Name)
 			check("column type", c.Type)
 		}
+		for _, idx := range ig.Table.Indexes {
+			check("index name", idx.Name)
+			for _, c := range idx.Columns {
+				check("index column name", c)
+			}
+		}
 		for _, name := range ig.Notification.Columns {
 			check("notification column name", name)
 		}
-		for _, inp := range ig.Event.Inputs {
-			check("referenced column name", inp.Filter.Ref.Column)
+		for _, inputs := range ig.inputs() {
+			for _, inp := range inputs {
+				checkQ("referenced table name", inp.Filter.Ref.Table)
+				check("referenced column name", inp.Filter.Ref.Column)
+			}
 		}
 		for _, bd := range ig.Block {
+			checkQ("referenced table name", bd.Filter.Ref.Table)
 			check("referenced column name", bd.Filter.Ref.Column)
 		}
 	}
@@ -325,10 +1438,269 @@ func CheckUserInput(conf Root) error {
 	return err
 }
 
+// A Postgres database other than pg_url. Each database
+// has its own shovel schema; the task state of the
+// integrations using it is kept alongside their tables.
+type Database struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// Like dashboard.query_pg_url, for querying this
+	// database from the query console.
+	QueryURL wos.EnvString `json:"query_url"`
+}
+
+func validateDatabases(conf Root) error {
+	names := map[string]bool{}
+	for _, db := range conf.Databases {
+		switch {
+		case len(db.Name) == 0:
+			return fmt.Errorf("missing database name")
+		case len(db.URL) == 0:
+			return fmt.Errorf("database %s: missing url", db.Name)
+		case names[db.Name]:
+			return fmt.Errorf("duplicate database: %s", db.Name)
+		}
+		names[db.Name] = true
+	}
+	for _, ig := range conf.Integrations {
+		if len(ig.Database) > 0 && !names[ig.Database] {
+			return fmt.Errorf("integration %s: unknown database %q", ig.Name, ig.Database)
+		}
+	}
+	return nil
+}
+
+// The config with only the integrations that use the
+// named database. The empty name is pg_url.
+func (conf Root) ForDatabase(name string) Root {
+	res := conf
+	res.Integrations = nil
+	for _, ig := range conf.Integrations {
+		if ig.Database == name {
+			res.Integrations = append(res.Integrations, ig)
+		}
+	}
+	return res
+}
+
 type Dashboard struct {
 	EnableLoopbackAuthn bool          `json:"enable_loopback_authn"`
 	DisableAuthn        bool          `json:"disable_authn"`
 	RootPassword        wos.EnvString `json:"root_password"`
+
+	// Serve /graphql without authentication and
+	// allow cross-origin requests to it. Requests are
+	// limited in size and time and use a separate pool.
+	PublicGraphQL bool `json:"public_graphql"`
+
+	// Serve Go's profiles at /debug/pprof/ and runtime
+	// stats at /debug/vars (authenticated).
+	EnableDebug bool `json:"enable_debug"`
+
+	// Login using an OpenID Connect provider. When set and
+	// root_password is empty, password login is disabled.
+	OIDC *OIDC `json:"oidc"`
+
+	// Connection used by the query console. It should be a
+	// role that can only select from the tables users may
+	// read. The console is disabled when empty.
+	QueryPGURL wos.EnvString `json:"query_pg_url"`
+}
+
+type OIDC struct {
+	Issuer       wos.EnvString `json:"issuer"`
+	ClientID     wos.EnvString `json:"client_id"`
+	ClientSecret wos.EnvString `json:"client_secret"`
+
+	// Shovel's /oidc/callback URL as registered
+	// with the provider.
+	RedirectURL wos.EnvString `json:"redirect_url"`
+
+	// Users must be a member of one of the groups, have
+	// one of the emails, or have an email at one of the
+	// domains. At least one is required since otherwise
+	// anyone with an account at the issuer could log in.
+	// Groups are read from the ID token's groups_claim
+	// (defaults to "groups"). Emails must be verified.
+	AllowedGroups  []string `json:"allowed_groups"`
+	AllowedEmails  []string `json:"allowed_emails"`
+	AllowedDomains []string `json:"allowed_domains"`
+	GroupsClaim    string   `json:"groups_claim"`
+	Scopes         []string `json:"scopes"`
+}
+
+func validateOIDC(c *OIDC) error {
+	if c == nil {
+		return nil
+	}
+	switch {
+	case len(c.Issuer) == 0:
+		return fmt.Errorf("missing issuer")
+	case len(c.ClientID) == 0:
+		return fmt.Errorf("missing client_id")
+	case len(c.RedirectURL) == 0:
+		return fmt.Errorf("missing redirect_url")
+	case len(c.AllowedGroups)+len(c.AllowedEmails)+len(c.AllowedDomains) == 0:
+		return fmt.Errorf("one of allowed_groups, allowed_emails, or allowed_domains is required")
+	}
+	if c.GroupsClaim == "" {
+		c.GroupsClaim = "groups"
+	}
+	return nil
+}
+
+// Used by /readyz. A source is ready when its latest
+// indexed block is within MaxLag blocks of the source's
+// latest block. MaxLag defaults to 100.
+type Health struct {
+	MaxLag uint64 `json:"max_lag"`
+}
+
+type Log struct {
+	// debug, info, warn, or error. The -v flag sets debug.
+	Level string `json:"level"`
+
+	// text (key=value lines, the default) or json
+	Format string `json:"format"`
+
+	// Levels for the logs of a subsystem. Subsystems are
+	// named by package: task, jrpc2, dig, sink, web.
+	Levels map[string]string `json:"levels"`
+}
+
+func validateLog(l Log) error {
+	if !slices.Contains([]string{"", "text", "json"}, l.Format) {
+		return fmt.Errorf("format must be one of: text, json. got: %s", l.Format)
+	}
+	var lv slog.Level
+	if len(l.Level) > 0 {
+		if err := lv.UnmarshalText([]byte(l.Level)); err != nil {
+			return fmt.Errorf("level: %w", err)
+		}
+	}
+	for name, level := range l.Levels {
+		if err := lv.UnmarshalText([]byte(level)); err != nil {
+			return fmt.Errorf("level for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Rules are checked against every running task and each
+// change of a rule's state for a task is sent to every hook.
+type Alerts struct {
+	Rules []AlertRule `json:"rules"`
+	Hooks []AlertHook `json:"hooks"`
+}
+
+// Sets one of max_lag or max_errors.
+type AlertRule struct {
+	Name string `json:"name"`
+
+	// Fires when a task is more than max_lag blocks
+	// behind its source for the duration of for.
+	MaxLag uint64 `json:"max_lag"`
+
+	// Fires when a task has more than max_errors failed
+	// converges within the duration of for.
+	MaxErrors uint64 `json:"max_errors"`
+
+	// A Go duration (eg 5m). Defaults to 5m.
+	For string `json:"for"`
+}
+
+// Fewer converges write to a database at a time while its
+// inserts (including the wait for a connection) take longer
+// than MaxInsertLatency. Defaults to 10s.
+type Backpressure struct {
+	MaxInsertLatency string `json:"max_insert_latency"`
+}
+
+func (b Backpressure) Latency() time.Duration {
+	d, err := time.ParseDuration(b.MaxInsertLatency)
+	if err != nil || d <= 0 {
+		return 10 * time.Second
+	}
+	return d
+}
+
+func validateBackpressure(b Backpressure) error {
+	if len(b.MaxInsertLatency) == 0 {
+		return nil
+	}
+	d, err := time.ParseDuration(b.MaxInsertLatency)
+	if err != nil {
+		return fmt.Errorf("max_insert_latency: %w", err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("max_insert_latency must be positive. got: %s", d)
+	}
+	return nil
+}
+
+func (r AlertRule) Duration() time.Duration {
+	d, err := time.ParseDuration(r.For)
+	if err != nil || d == 0 {
+		return 5 * time.Minute
+	}
+	return d
+}
+
+type AlertHook struct {
+	// slack, pagerduty, or webhook
+	Kind string `json:"kind"`
+
+	// Slack's incoming webhook url or the webhook's url
+	URL wos.EnvString `json:"url"`
+
+	// PagerDuty Events API v2 integration key
+	RoutingKey wos.EnvString `json:"routing_key"`
+}
+
+func validateAlerts(a Alerts) error {
+	names := map[string]bool{}
+	for _, r := range a.Rules {
+		if len(r.Name) == 0 {
+			return fmt.Errorf("rule missing name")
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicate rule %s", r.Name)
+		}
+		names[r.Name] = true
+		if (r.MaxLag == 0) == (r.MaxErrors == 0) {
+			return fmt.Errorf("rule %s must set one of max_lag or max_errors", r.Name)
+		}
+		if len(r.For) > 0 {
+			if _, err := time.ParseDuration(r.For); err != nil {
+				return fmt.Errorf("rule %s for: %w", r.Name, err)
+			}
+		}
+	}
+	for _, h := range a.Hooks {
+		switch h.Kind {
+		case "slack", "webhook":
+			if len(h.URL) == 0 {
+				return fmt.Errorf("%s hook missing url", h.Kind)
+			}
+		case "pagerduty":
+			if len(h.RoutingKey) == 0 {
+				return fmt.Errorf("pagerduty hook missing routing_key")
+			}
+		default:
+			return fmt.Errorf("hook kind must be one of: slack, pagerduty, webhook. got: %s", h.Kind)
+		}
+	}
+	return nil
+}
+
+// Spans are exported using OTLP over HTTP
+// when Endpoint is set.
+type Telemetry struct {
+	Endpoint    wos.EnvString            `json:"endpoint"`
+	Headers     map[string]wos.EnvString `json:"headers"`
+	Insecure    bool                     `json:"insecure"`
+	SampleRatio float64                  `json:"sample_ratio"`
 }
 
 type Source struct {
@@ -336,25 +1708,212 @@ type Source struct {
 	ChainID      uint64
 	URLs         []string
 	WSURL        string
+	TraceMethod  string
+	Follow       string
 	Start        uint64
 	Stop         uint64
 	PollDuration time.Duration
 	Concurrency  int
 	BatchSize    int
+
+	// Number of blocks that may be reorganized. A reorg
+	// deeper than this halts the task instead of deleting
+	// further back. Defaults to 1000.
+	ReorgDepth uint64
+
+	// Max rpc calls per second shared by every task
+	// using the source. Set using either rps or
+	// requests_per_minute. Zero is unlimited.
+	RPS float64
+
+	// When set, BatchSize and Concurrency are starting
+	// values that are adjusted within these bounds.
+	Adaptive *Adaptive
+
+	// Store finalized rpc responses in shovel.block_cache
+	Cache bool
+
+	// Index every integration using the source in a single
+	// task so that a block range is committed to each of
+	// their tables in one pg tx.
+	Atomic bool
+
+	// Only used by an integration's reference to a source.
+	// A new integration starts at the source's head and
+	// its range up to the head is backfilled separately.
+	Backfill bool
+
+	// Blocks that aren't requested from the source or
+	// indexed (eg blocks that crash providers or decoders)
+	Skip []SkipBlocks
+
+	// Receipts are requested for every block instead of
+	// only for blocks whose logs bloom may match. For chains
+	// whose blooms don't include every log.
+	DisableBloom bool
+
+	// Each block's parent hash is checked against the hash
+	// of the block before it, across each batch and against
+	// the last indexed block. Mismatches are recorded in
+	// shovel.chain_discrepancies. Headers are requested
+	// when the integrations don't otherwise need them.
+	VerifyChain bool
+
+	// Rewrites responses that the eth types reject (eg null
+	// or non-canonical quantities) for chains whose nodes
+	// return them. Decoding is slower.
+	Lenient bool
+
+	// When set, the next batch is loaded while the current
+	// batch is decoded and inserted, and each batch is
+	// decoded by this many workers.
+	DecodeWorkers int
+
+	// HTTP client settings for the source's urls
+	Transport *Transport
+
+	// Sent with each request to the source's urls
+	// (including ws_url), eg for gateways that
+	// require an api key
+	Headers   map[string]string
+	BasicAuth *BasicAuth
+
+	// Client certificate and root CAs for nodes behind
+	// mutual TLS
+	TLS *TLS
+}
+
+// Each of Cert, Key, and CA is either PEM (eg from an
+// env var or secret) or the path of a PEM file
+type TLS struct {
+	Cert       string
+	Key        string
+	CA         string
+	ServerName string
+}
+
+func readPEM(s string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(s), "-----BEGIN") {
+		return []byte(s), nil
+	}
+	return os.ReadFile(s)
+}
+
+func (t TLS) Config() (*tls.Config, error) {
+	c := &tls.Config{ServerName: t.ServerName}
+	switch {
+	case len(t.Cert) > 0 && len(t.Key) > 0:
+		cert, err := readPEM(t.Cert)
+		if err != nil {
+			return nil, fmt.Errorf("reading tls cert: %w", err)
+		}
+		key, err := readPEM(t.Key)
+		if err != nil {
+			return nil, fmt.Errorf("reading tls key: %w", err)
+		}
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("loading tls cert: %w", err)
+		}
+		c.Certificates = []tls.Certificate{pair}
+	case len(t.Cert) > 0 || len(t.Key) > 0:
+		return nil, fmt.Errorf("tls cert and key must be set together")
+	}
+	if len(t.CA) > 0 {
+		ca, err := readPEM(t.CA)
+		if err != nil {
+			return nil, fmt.Errorf("reading tls ca: %w", err)
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in tls ca")
+		}
+	}
+	return c, nil
+}
+
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+type SkipBlocks struct {
+	Start  uint64 `json:"start"`
+	Stop   uint64 `json:"stop"` // defaults to start
+	Reason string `json:"reason"`
+}
+
+type Transport struct {
+	MaxIdleConns          int           // per host. defaults to 100
+	IdleConnTimeout       time.Duration // defaults to 90s
+	KeepAlive             time.Duration // defaults to 30s
+	ResponseHeaderTimeout time.Duration // defaults to none
+	DisableHTTP2          bool
+	DisableCompression    bool
+
+	// http, https, or socks5 url. Defaults to the
+	// HTTP_PROXY and HTTPS_PROXY env vars.
+	Proxy string
+}
+
+type Adaptive struct {
+	MinBatchSize   int           // defaults to 1
+	MaxBatchSize   int           // defaults to 2000
+	MaxConcurrency int           // defaults to 10
+	TargetLatency  time.Duration // defaults to 5s
 }
 
 func (s *Source) UnmarshalJSON(d []byte) error {
 	x := struct {
-		Name         wos.EnvString   `json:"name"`
-		ChainID      wos.EnvUint64   `json:"chain_id"`
-		URL          wos.EnvString   `json:"url"`
-		URLs         []wos.EnvString `json:"urls"`
-		WSURL        wos.EnvString   `json:"ws_url"`
-		Start        wos.EnvUint64   `json:"start"`
-		Stop         wos.EnvUint64   `json:"stop"`
-		PollDuration wos.EnvString   `json:"poll_duration"`
-		Concurrency  wos.EnvInt      `json:"concurrency"`
-		BatchSize    wos.EnvInt      `json:"batch_size"`
+		Name          wos.EnvString   `json:"name"`
+		ChainID       wos.EnvUint64   `json:"chain_id"`
+		URL           wos.EnvString   `json:"url"`
+		URLs          []wos.EnvString `json:"urls"`
+		WSURL         wos.EnvString   `json:"ws_url"`
+		TraceMethod   wos.EnvString   `json:"trace_method"`
+		Follow        wos.EnvString   `json:"follow"`
+		Start         wos.EnvUint64   `json:"start"`
+		Stop          wos.EnvUint64   `json:"stop"`
+		PollDuration  wos.EnvString   `json:"poll_duration"`
+		Concurrency   wos.EnvInt      `json:"concurrency"`
+		BatchSize     wos.EnvInt      `json:"batch_size"`
+		ReorgDepth    wos.EnvUint64   `json:"reorg_depth"`
+		RPS           wos.EnvInt      `json:"rps"`
+		RPM           wos.EnvInt      `json:"requests_per_minute"`
+		Cache         bool            `json:"cache"`
+		Atomic        bool            `json:"atomic"`
+		Backfill      bool            `json:"backfill"`
+		Skip          []SkipBlocks    `json:"skip"`
+		DisableBloom  bool            `json:"disable_bloom"`
+		VerifyChain   bool            `json:"verify_chain"`
+		Lenient       bool            `json:"lenient"`
+		DecodeWorkers wos.EnvInt      `json:"decode_workers"`
+		Adaptive      *struct {
+			MinBatchSize   wos.EnvInt    `json:"min_batch_size"`
+			MaxBatchSize   wos.EnvInt    `json:"max_batch_size"`
+			MaxConcurrency wos.EnvInt    `json:"max_concurrency"`
+			TargetLatency  wos.EnvString `json:"target_latency"`
+		} `json:"adaptive"`
+		Transport *struct {
+			MaxIdleConns          wos.EnvInt    `json:"max_idle_conns"`
+			IdleConnTimeout       wos.EnvString `json:"idle_conn_timeout"`
+			KeepAlive             wos.EnvString `json:"keep_alive"`
+			ResponseHeaderTimeout wos.EnvString `json:"response_header_timeout"`
+			DisableHTTP2          bool          `json:"disable_http2"`
+			DisableCompression    bool          `json:"disable_compression"`
+			Proxy                 wos.EnvString `json:"proxy"`
+		} `json:"transport"`
+		Headers   map[string]wos.EnvString `json:"headers"`
+		BasicAuth *struct {
+			Username wos.EnvString `json:"username"`
+			Password wos.EnvString `json:"password"`
+		} `json:"basic_auth"`
+		TLS *struct {
+			Cert       wos.EnvString `json:"cert"`
+			Key        wos.EnvString `json:"key"`
+			CA         wos.EnvString `json:"ca"`
+			ServerName wos.EnvString `json:"server_name"`
+		} `json:"tls"`
 	}{}
 	if err := json.Unmarshal(d, &x); err != nil {
 		return err
@@ -362,10 +1921,155 @@ func (s *Source) UnmarshalJSON(d []byte) error {
 	s.Name = string(x.Name)
 	s.ChainID = uint64(x.ChainID)
 	s.WSURL = string(x.WSURL)
+	s.TraceMethod = string(x.TraceMethod)
+	switch s.TraceMethod {
+	case "", "trace_block", "trace_filter", "debug_traceBlockByNumber":
+	default:
+		const tag = "trace_method must be trace_block, trace_filter, or debug_traceBlockByNumber. got: %s"
+		return fmt.Errorf(tag, s.TraceMethod)
+	}
+	s.Follow = string(x.Follow)
+	switch s.Follow {
+	case "", "latest", "safe", "finalized":
+	default:
+		const tag = "follow must be latest, safe, or finalized. got: %s"
+		return fmt.Errorf(tag, s.Follow)
+	}
 	s.Start = uint64(x.Start)
 	s.Stop = uint64(x.Stop)
+	if s.Stop > 0 && s.Start > s.Stop {
+		return fmt.Errorf("start (%d) must not exceed stop (%d)", s.Start, s.Stop)
+	}
 	s.Concurrency = int(x.Concurrency)
 	s.BatchSize = int(x.BatchSize)
+	s.ReorgDepth = uint64(x.ReorgDepth)
+	s.Cache = x.Cache
+	s.Atomic = x.Atomic
+	s.Backfill = x.Backfill
+	s.DisableBloom = x.DisableBloom
+	s.VerifyChain = x.VerifyChain
+	s.Lenient = x.Lenient
+	if x.DecodeWorkers < 0 {
+		return fmt.Errorf("decode_workers must not be negative")
+	}
+	s.DecodeWorkers = int(x.DecodeWorkers)
+	for _, sb := range x.Skip {
+		if sb.Stop == 0 {
+			sb.Stop = sb.Start
+		}
+		if sb.Start == 0 || sb.Stop < sb.Start {
+			return fmt.Errorf("skip start must be positive and stop must be >= start. got: %d-%d", sb.Start, sb.Stop)
+		}
+		s.Skip = append(s.Skip, sb)
+	}
+	slices.SortFunc(s.Skip, func(a, b SkipBlocks) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	switch {
+	case x.RPS < 0 || x.RPM < 0:
+		return fmt.Errorf("rps and requests_per_minute must be positive")
+	case x.RPS > 0 && x.RPM > 0:
+		return fmt.Errorf("only one of rps or requests_per_minute may be set")
+	case x.RPS > 0:
+		s.RPS = float64(x.RPS)
+	case x.RPM > 0:
+		s.RPS = float64(x.RPM) / 60
+	}
+	if a := x.Adaptive; a != nil {
+		s.Adaptive = &Adaptive{
+			MinBatchSize:   int(a.MinBatchSize),
+			MaxBatchSize:   int(a.MaxBatchSize),
+			MaxConcurrency: int(a.MaxConcurrency),
+		}
+		if len(a.TargetLatency) > 0 {
+			d, err := time.ParseDuration(string(a.TargetLatency))
+			if err != nil {
+				const tag = "unable to parse adaptive target_latency value: %s"
+				return fmt.Errorf(tag, string(a.TargetLatency))
+			}
+			s.Adaptive.TargetLatency = d
+		}
+		if s.Adaptive.MaxBatchSize > 0 && s.Adaptive.MinBatchSize > s.Adaptive.MaxBatchSize {
+			return fmt.Errorf("adaptive min_batch_size must not exceed max_batch_size")
+		}
+	}
+	if tr := x.Transport; tr != nil {
+		if tr.MaxIdleConns < 0 {
+			return fmt.Errorf("transport max_idle_conns must not be negative")
+		}
+		s.Transport = &Transport{
+			MaxIdleConns:       int(tr.MaxIdleConns),
+			DisableHTTP2:       tr.DisableHTTP2,
+			DisableCompression: tr.DisableCompression,
+			Proxy:              string(tr.Proxy),
+		}
+		if len(s.Transport.Proxy) > 0 {
+			u, err := url.Parse(s.Transport.Proxy)
+			if err != nil {
+				return fmt.Errorf("unable to parse transport proxy: %w", err)
+			}
+			switch u.Scheme {
+			case "http", "https", "socks5":
+			default:
+				const tag = "transport proxy must be an http, https, or socks5 url. got: %s"
+				return fmt.Errorf(tag, u.Redacted())
+			}
+			if len(u.Host) == 0 {
+				return fmt.Errorf("transport proxy requires a host")
+			}
+		}
+		durations := []struct {
+			name string
+			v    wos.EnvString
+			d    *time.Duration
+		}{
+			{"idle_conn_timeout", tr.IdleConnTimeout, &s.Transport.IdleConnTimeout},
+			{"keep_alive", tr.KeepAlive, &s.Transport.KeepAlive},
+			{"response_header_timeout", tr.ResponseHeaderTimeout, &s.Transport.ResponseHeaderTimeout},
+		}
+		for _, d := range durations {
+			if len(d.v) == 0 {
+				continue
+			}
+			v, err := time.ParseDuration(string(d.v))
+			if err != nil {
+				const tag = "unable to parse transport %s value: %s"
+				return fmt.Errorf(tag, d.name, string(d.v))
+			}
+			*d.d = v
+		}
+	}
+	for k, v := range x.Headers {
+		if s.Headers == nil {
+			s.Headers = map[string]string{}
+		}
+		s.Headers[k] = string(v)
+	}
+	if ba := x.BasicAuth; ba != nil {
+		if len(ba.Username) == 0 {
+			return fmt.Errorf("basic_auth requires a username")
+		}
+		for k := range s.Headers {
+			if strings.EqualFold(k, "authorization") {
+				return fmt.Errorf("basic_auth and an authorization header are mutually exclusive")
+			}
+		}
+		s.BasicAuth = &BasicAuth{
+			Username: string(ba.Username),
+			Password: string(ba.Password),
+		}
+	}
+	if t := x.TLS; t != nil {
+		s.TLS = &TLS{
+			Cert:       string(t.Cert),
+			Key:        string(t.Key),
+			CA:         string(t.CA),
+			ServerName: string(t.ServerName),
+		}
+		if _, err := s.TLS.Config(); err != nil {
+			return err
+		}
+	}
 
 	var urls []string
 	urls = append(urls, string(x.URL))
@@ -393,6 +2097,22 @@ func (s *Source) UnmarshalJSON(d []byte) error {
 	return nil
 }
 
+// Block range for an integration's reference to the source.
+// The reference may narrow the source's range: the greater
+// of the two starts and the lesser of the two stops are
+// used. A zero stop is unbounded.
+func (s Source) Range(ref Source) (uint64, uint64) {
+	start := max(s.Start, ref.Start)
+	switch {
+	case s.Stop == 0:
+		return start, ref.Stop
+	case ref.Stop == 0:
+		return start, s.Stop
+	default:
+		return start, min(s.Stop, ref.Stop)
+	}
+}
+
 func Sources(ctx context.Context, pgp *pgxpool.Pool) ([]Source, error) {
 	var res []Source
 	const q = `select name, chain_id, url from shovel.sources`
@@ -427,11 +2147,124 @@ type Integration struct {
 	FilterAGG    string           `json:"filter_agg"`
 	Notification dig.Notification `json:"notification"`
 	Compiled     Compiled         `json:"compiled"`
+	Preset       string           `json:"preset"`
 	Block        []dig.BlockData  `json:"block"`
 	Event        dig.Event        `json:"event"`
+	Events       []dig.Event      `json:"events"`
+	Function     dig.Function     `json:"function"`
+	ABI          []dig.Event      `json:"abi"`
+	Sinks        []sink.Config    `json:"sinks"`
+	Retention    Retention        `json:"retention"`
+	Export       Export           `json:"export"`
+
+	// fail (default) stops the source when a log can't be
+	// decoded or a row can't be inserted. skip logs and
+	// drops the log or row. dead_letter writes the log or
+	// row to shovel.dead_letters. Calldata that can't be
+	// decoded is skipped unless fail is set explicitly.
+	// See [dig.OnError].
+	OnError string `json:"on_error"`
+
+	// Name of one of [Root.Databases]. Empty uses pg_url.
+	Database string `json:"database"`
+
+	// high, normal (default) or low. When a source's rps
+	// limit or a database's connections are saturated,
+	// higher priority integrations are served first.
+	Priority string `json:"priority"`
+
 	Dependencies []string
 }
 
+// Rows more than Blocks behind the latest indexed block, or
+// with a block_time older than Days, are pruned in the
+// background. Days requires block_time block data.
+type Retention struct {
+	Blocks uint64 `json:"blocks"`
+	Days   uint64 `json:"days"`
+}
+
+// Orders priorities so that high > normal > low
+func (ig Integration) PriorityLevel() int {
+	switch ig.Priority {
+	case "high":
+		return 1
+	case "low":
+		return -1
+	default:
+		return 0
+	}
+}
+
+func (r Retention) Empty() bool {
+	return r.Blocks == 0 && r.Days == 0
+}
+
+// Finalized block ranges of the integration's rows are
+// periodically written as Parquet files to S3 or an S3
+// compatible store. GCS works through its S3 interoperability
+// endpoint using HMAC keys. Credentials are found the way the
+// AWS CLI finds them: env vars, the shared config and
+// credentials files, or the instance's role.
+type Export struct {
+	// s3://bucket/prefix
+	URL wos.EnvString `json:"url"`
+
+	// Defaults to https://s3.<region>.amazonaws.com
+	// Use https://storage.googleapis.com for GCS.
+	Endpoint wos.EnvString `json:"endpoint"`
+
+	// Defaults to AWS_REGION
+	Region wos.EnvString `json:"region"`
+
+	// Blocks per file. Defaults to 100,000.
+	Blocks uint64 `json:"blocks"`
+}
+
+func (e Export) Empty() bool {
+	return len(e.URL) == 0
+}
+
+// Returns the bucket and key prefix of the export's URL
+func (e Export) Bucket() (string, string, error) {
+	rest, ok := strings.CutPrefix(string(e.URL), "s3://")
+	if !ok {
+		return "", "", fmt.Errorf("export url must start with s3://")
+	}
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if len(bucket) == 0 {
+		return "", "", fmt.Errorf("export url is missing a bucket")
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// Returns Event (when set) followed by Events
+func (ig Integration) AllEvents() []dig.Event {
+	var res []dig.Event
+	if len(ig.Event.Name) > 0 {
+		res = append(res, ig.Event)
+	}
+	return append(res, ig.Events...)
+}
+
+// The inputs of each event and the function.
+// The returned slices share memory with ig.
+func (ig Integration) inputs() [][]dig.Input {
+	res := [][]dig.Input{ig.Event.Inputs, ig.Function.Inputs}
+	for i := range ig.Events {
+		res = append(res, ig.Events[i].Inputs)
+	}
+	return res
+}
+
+func (ig Integration) selected() []dig.Input {
+	var res []dig.Input
+	for _, ev := range ig.AllEvents() {
+		res = append(res, ev.Selected()...)
+	}
+	return append(res, ig.Function.Selected()...)
+}
+
 func (ig *Integration) AddRequiredFields() {
 	hasBD := func(name string) bool {
 		for _, bd := range ig.Block {
@@ -463,13 +2296,35 @@ func (ig *Integration) AddRequiredFields() {
 	add("ig_name", "text")
 	add("src_name", "text")
 	add("block_num", "numeric")
+	if ig.withdrawals() {
+		add("withdrawal_index", "numeric")
+		return
+	}
 	add("tx_idx", "int")
-	if len(ig.Event.Selected()) > 0 {
+	if ig.wildcard() {
 		add("log_idx", "int")
 	}
-	for _, inp := range ig.Event.Selected() {
-		if !inp.Indexed {
-			add("abi_idx", "int2")
+	for _, ev := range ig.AllEvents() {
+		if len(ev.Selected()) > 0 {
+			add("log_idx", "int")
+		}
+		for _, inp := range ev.Selected() {
+			if !inp.Indexed {
+				add("abi_idx", "int2")
+			}
+		}
+		for _, inp := range ev.Inputs {
+			if inp.Unnest {
+				add("array_idx", "int")
+			}
+		}
+	}
+	if len(ig.Function.Selected()) > 0 {
+		add("abi_idx", "int2")
+	}
+	for _, inp := range ig.Function.Inputs {
+		if inp.Unnest {
+			add("array_idx", "int")
 		}
 	}
 	for _, bd := range ig.Block {
@@ -509,6 +2364,76 @@ func (ig Integration) Source(name string) (Source, error) {
 	return Source{}, fmt.Errorf("missing source config for: %s", name)
 }
 
+// Integrations and sources that were added, removed,
+// or modified between two configs. Names are sorted.
+type Changes struct {
+	Integrations []string
+	Sources      []string
+}
+
+func (c Changes) Empty() bool {
+	return len(c.Integrations) == 0 && len(c.Sources) == 0
+}
+
+// Reports whether a task for the (src, ig) pair
+// needs to be restarted to pick up the changes.
+func (c Changes) Affects(srcName, igName string) bool {
+	return slices.Contains(c.Integrations, igName) ||
+		slices.Contains(c.Sources, srcName)
+}
+
+// Compares the integrations and sources in a with those in b.
+// Integrations and sources are matched by name and
+// considered modified when their JSON encodings differ.
+func Diff(a, b Root) Changes {
+	var (
+		res     Changes
+		changed = func(x, y any) bool {
+			xj, _ := json.Marshal(x)
+			yj, _ := json.Marshal(y)
+			return !bytes.Equal(xj, yj)
+		}
+	)
+	var aigs, bigs = map[string]Integration{}, map[string]Integration{}
+	for _, ig := range a.Integrations {
+		aigs[ig.Name] = ig
+	}
+	for _, ig := range b.Integrations {
+		bigs[ig.Name] = ig
+	}
+	for name, ig := range aigs {
+		if other, ok := bigs[name]; !ok || changed(ig, other) {
+			res.Integrations = append(res.Integrations, name)
+		}
+	}
+	for name := range bigs {
+		if _, ok := aigs[name]; !ok {
+			res.Integrations = append(res.Integrations, name)
+		}
+	}
+
+	var asrcs, bsrcs = map[string]Source{}, map[string]Source{}
+	for _, src := range a.Sources {
+		asrcs[src.Name] = src
+	}
+	for _, src := range b.Sources {
+		bsrcs[src.Name] = src
+	}
+	for name, src := range asrcs {
+		if other, ok := bsrcs[name]; !ok || changed(src, other) {
+			res.Sources = append(res.Sources, name)
+		}
+	}
+	for name := range bsrcs {
+		if _, ok := asrcs[name]; !ok {
+			res.Sources = append(res.Sources, name)
+		}
+	}
+	slices.Sort(res.Integrations)
+	slices.Sort(res.Sources)
+	return res
+}
+
 func (conf Root) AllIntegrations(ctx context.Context, pg wpg.Conn) ([]Integration, error) {
 	indb, err := Integrations(ctx, pg)
 	if err != nil {
@@ -1,9 +1,16 @@
 package config
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/indexsupply/shovel/dig"
+	"github.com/indexsupply/shovel/shovel/sink"
+	"github.com/indexsupply/shovel/wos"
 	"github.com/indexsupply/shovel/wpg"
 
 	"kr.dev/diff"
@@ -79,13 +86,82 @@ func TestUnion(t *testing.T) {
 				},
 			},
 		},
+		{
+			a: wpg.Table{
+				Name:  "a",
+				Index: [][]string{{"c1"}},
+				Columns: []wpg.Column{
+					{Name: "c1", Type: "int"},
+				},
+			},
+			b: wpg.Table{
+				Name:    "a",
+				Index:   [][]string{{"c1"}, {"c2"}},
+				Indexes: []wpg.Index{{Columns: []string{"c2"}, Method: "brin"}},
+				Columns: []wpg.Column{
+					{Name: "c1", Type: "int"},
+					{Name: "c2", Type: "bytea", Encoding: "hex"},
+					{Name: "c3", Type: "text", Expr: "c2"},
+				},
+			},
+			want: wpg.Table{
+				Name:    "a",
+				Index:   [][]string{{"c1"}, {"c2"}},
+				Indexes: []wpg.Index{{Columns: []string{"c2"}, Method: "brin"}},
+				Columns: []wpg.Column{
+					{Name: "c1", Type: "int"},
+					{Name: "c2", Type: "bytea", Encoding: "hex"},
+					{Name: "c3", Type: "text", Expr: "c2"},
+				},
+			},
+		},
 	}
 	for _, tc := range cases {
-		got := union(tc.a, tc.b)
+		got, err := union(tc.a, tc.b)
+		diff.Test(t, t.Fatalf, nil, err)
 		diff.Test(t, t.Errorf, got, tc.want)
 	}
 }
 
+func TestUnion_Conflict(t *testing.T) {
+	cases := []struct {
+		a, b wpg.Table
+		want string
+	}{
+		{
+			a:    wpg.Table{Columns: []wpg.Column{{Name: "c1", Type: "int"}}},
+			b:    wpg.Table{Columns: []wpg.Column{{Name: "c1", Type: "text"}}},
+			want: "column c1: type int conflicts with text",
+		},
+		{
+			a:    wpg.Table{Columns: []wpg.Column{{Name: "c1", Type: "bytea"}}},
+			b:    wpg.Table{Columns: []wpg.Column{{Name: "c1", Type: "bytea", Encoding: "hex"}}},
+			want: `column c1: encoding "" conflicts with "hex"`,
+		},
+		{
+			a:    wpg.Table{Columns: []wpg.Column{{Name: "c1", Type: "int", Expr: "c2"}}},
+			b:    wpg.Table{Columns: []wpg.Column{{Name: "c1", Type: "int"}}},
+			want: `column c1: expr "c2" conflicts with ""`,
+		},
+		{
+			a:    wpg.Table{Indexes: []wpg.Index{{Name: "i", Columns: []string{"c1"}}}},
+			b:    wpg.Table{Indexes: []wpg.Index{{Name: "i", Columns: []string{"c2"}}}},
+			want: "index i has conflicting definitions",
+		},
+		{
+			a:    wpg.Table{PartitionSize: 10},
+			b:    wpg.Table{PartitionSize: 20},
+			want: "partition_size 10 conflicts with 20",
+		},
+	}
+	for _, tc := range cases {
+		_, err := union(tc.a, tc.b)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("got: %v want: %s", err, tc.want)
+		}
+	}
+}
+
 func TestDDL(t *testing.T) {
 	conf := &Root{
 		Integrations: []Integration{
@@ -193,3 +269,1016 @@ func TestValidateFix_MissingCols(t *testing.T) {
 	const want = "checking config for references: missing column for b"
 	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
 }
+
+func TestDiff(t *testing.T) {
+	var (
+		a = Root{
+			Sources: []Source{{Name: "mainnet", ChainID: 1}},
+			Integrations: []Integration{
+				{Name: "foo", Table: wpg.Table{Name: "foo"}},
+				{Name: "bar", Table: wpg.Table{Name: "bar"}},
+			},
+		}
+		b = Root{
+			Sources: []Source{
+				{Name: "mainnet", ChainID: 1},
+				{Name: "base", ChainID: 8453},
+			},
+			Integrations: []Integration{
+				{Name: "foo", Table: wpg.Table{Name: "foo2"}},
+				{Name: "baz", Table: wpg.Table{Name: "baz"}},
+			},
+		}
+	)
+	diff.Test(t, t.Errorf, Diff(a, a), Changes{})
+	diff.Test(t, t.Errorf, Diff(a, b), Changes{
+		Integrations: []string{"bar", "baz", "foo"},
+		Sources:      []string{"base"},
+	})
+	diff.Test(t, t.Errorf, Diff(a, b).Affects("mainnet", "foo"), true)
+	diff.Test(t, t.Errorf, Diff(a, b).Affects("mainnet", "qux"), false)
+}
+
+func TestLoad(t *testing.T) {
+	files := map[string]string{
+		"c.json": `{
+			"pg_url": "postgres:///shovel",
+			"eth_sources": [{"name": "mainnet", "chain_id": 1, "url": "http://a"}],
+			"integrations": [{"name": "foo", "enabled": true, "table": {"name": "foo"}}]
+		}`,
+		"c.yaml": `
+pg_url: postgres:///shovel
+eth_sources:
+  - name: mainnet
+    chain_id: 1
+    url: http://a
+integrations:
+  # comments are allowed
+  - name: foo
+    enabled: true
+    table:
+      name: foo
+`,
+		"c.toml": `
+pg_url = "postgres:///shovel"
+
+[[eth_sources]]
+name = "mainnet"
+chain_id = 1
+url = "http://a"
+
+[[integrations]]
+name = "foo"
+enabled = true
+table = { name = "foo" }
+`,
+	}
+	want := Root{
+		PGURL: "postgres:///shovel",
+		Sources: []Source{{
+			Name:         "mainnet",
+			ChainID:      1,
+			URLs:         []string{"http://a"},
+			PollDuration: time.Second,
+		}},
+		Integrations: []Integration{{
+			Name:    "foo",
+			Enabled: true,
+			Table:   wpg.Table{Name: "foo"},
+		}},
+	}
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := Load(path)
+		diff.Test(t, t.Fatalf, err, nil)
+		want.Files = []string{path}
+		diff.Test(t, t.Errorf, got, want)
+	}
+}
+
+func TestLoad_Hex(t *testing.T) {
+	files := map[string]string{
+		"c.yaml": `
+integrations:
+  - name: foo
+    block:
+      - name: log_addr
+        column: log_addr
+        filter_op: contains
+        filter_arg: [0x10, 0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48]
+`,
+		"c.toml": `
+[[integrations]]
+name = "foo"
+block = [{name = "log_addr", column = "log_addr", filter_op = "contains", filter_arg = [0x10]}]
+`,
+	}
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		diff.Test(t, t.Fatalf, os.WriteFile(path, []byte(data), 0644), nil)
+	}
+
+	got, err := Load(filepath.Join(dir, "c.yaml"))
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, got.Integrations[0].Block[0].Filter.Arg, []string{
+		"0x10",
+		"0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48",
+	})
+
+	// toml hex integers can't be kept as written
+	_, err = Load(filepath.Join(dir, "c.toml"))
+	if err == nil || !strings.Contains(err.Error(), "filter_arg") || !strings.HasSuffix(err.Error(), "must be a string. quote hex values") {
+		t.Errorf("expected filter_arg error. got: %v", err)
+	}
+}
+
+func TestLoad_Interpolate(t *testing.T) {
+	t.Setenv("DEPLOY_ENV", "staging")
+	t.Setenv("USDC", "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48")
+	t.Setenv("START", "18000000")
+	path := filepath.Join(t.TempDir(), "c.json")
+	err := os.WriteFile(path, []byte(`{
+		"pg_url": "postgres:///shovel",
+		"eth_sources": [{"name": "mainnet", "chain_id": 1, "url": "http://a"}],
+		"integrations": [{
+			"name": "${DEPLOY_ENV}_transfers",
+			"enabled": true,
+			"sources": [{"name": "mainnet", "start": "$START"}],
+			"table": {"name": "{{.DEPLOY_ENV}}_transfers"},
+			"block": [{
+				"name": "log_addr",
+				"column": "log_addr",
+				"filter_op": "contains",
+				"filter_arg": ["${USDC}"]
+			}]
+		}]
+	}`), 0644)
+	diff.Test(t, t.Fatalf, err, nil)
+
+	conf, err := Load(path)
+	diff.Test(t, t.Fatalf, err, nil)
+	ig := conf.Integrations[0]
+	diff.Test(t, t.Errorf, ig.Name, "staging_transfers")
+	diff.Test(t, t.Errorf, ig.Table.Name, "staging_transfers")
+	diff.Test(t, t.Errorf, ig.Sources[0].Start, uint64(18000000))
+	diff.Test(t, t.Errorf, ig.Block[0].Filter.Arg, []string{"0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"})
+	diff.Test(t, t.Errorf, conf.Sources[0].ChainID, uint64(1))
+
+	err = os.WriteFile(path, []byte(`{"integrations": [{"name": "${MISSING}_x"}]}`), 0644)
+	diff.Test(t, t.Fatalf, err, nil)
+	_, err = Load(path)
+	diff.Test(t, t.Errorf, err.Error(), path+": integrations.0.name: expected $MISSING to be set")
+
+	// only the documented fields are interpolated
+	err = os.WriteFile(path, []byte(`{
+		"integrations": [{
+			"name": "x",
+			"table": {
+				"name": "x",
+				"columns": [{"name": "y", "type": "text", "expr": "'${MISSING}' || '{{.MISSING}}'"}]
+			}
+		}]
+	}`), 0644)
+	diff.Test(t, t.Fatalf, err, nil)
+	conf, err = Load(path)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, conf.Integrations[0].Table.Columns[0].Expr, "'${MISSING}' || '{{.MISSING}}'")
+}
+
+func TestInterpolates(t *testing.T) {
+	cases := []struct {
+		path string
+		want bool
+	}{
+		{"pg_url", true},
+		{"eth_sources.0.url", true},
+		{"eth_sources.0.urls.1", true},
+		{"integrations.0.name", true},
+		{"integrations.0.table.name", true},
+		{"integrations.0.table.columns.0.name", false},
+		{"integrations.0.sources.0.start", true},
+		{"integrations.0.block.2.filter_arg.0", true},
+		{"integrations.0.block.2.filter_op", false},
+		{"integrations.0.event.inputs.1.filter_arg.0", true},
+		{"integrations.0.event.name", false},
+		{"integrations.0.sinks.0.password", true},
+		{"alerts.hooks.0.url", true},
+		{"alerts.rules.0.name", false},
+	}
+	for _, tc := range cases {
+		got := interpolates(strings.Split(tc.path, "."))
+		if got != tc.want {
+			t.Errorf("%s: got %v want %v", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestLoad_Include(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	root := write("root.json", `{
+		"pg_url": "postgres:///shovel",
+		"include": ["teams", "sources.yaml"]
+	}`)
+	write("sources.yaml", "eth_sources: [{name: mainnet, chain_id: 1}]")
+	write("teams/a.json", `{"integrations": [{"name": "a"}]}`)
+	write("teams/b.toml", "[[integrations]]\nname = \"b\"")
+	write("teams/readme.txt", "ignored")
+
+	conf, err := Load(root)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, len(conf.Sources), 1)
+	diff.Test(t, t.Errorf, len(conf.Integrations), 2)
+	diff.Test(t, t.Errorf, conf.Integrations[0].Name, "a")
+	diff.Test(t, t.Errorf, conf.Integrations[1].Name, "b")
+	diff.Test(t, t.Errorf, len(conf.Files), 4)
+
+	files, err := Files(root)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, files, conf.Files)
+
+	write("teams/c.json", `{"integrations": [{"name": "a"}]}`)
+	files, err = Files(root)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, len(files), 5)
+	_, err = Load(root)
+	diff.Test(t, t.Errorf, err.Error(), root+": duplicate integration: a")
+
+	cycle := write("cycle.json", `{"include": ["cycle.json"]}`)
+	_, err = Load(cycle)
+	diff.Test(t, t.Errorf, err.Error(), `include "cycle.json": include cycle: `+cycle)
+}
+
+func TestValidateFilterRefs(t *testing.T) {
+	conf := &Root{
+		Integrations: []Integration{
+			{
+				Name:  "factory",
+				Table: wpg.Table{Name: "pools", Columns: []wpg.Column{{Name: "pool", Type: "bytea"}}},
+			},
+			{
+				Name: "swaps",
+				Block: []dig.BlockData{{
+					Name:   "log_addr",
+					Filter: dig.Filter{Op: "contains", Ref: dig.Ref{Integration: "factory", Column: "pool"}},
+				}},
+			},
+			{
+				Name: "allowlist",
+				Block: []dig.BlockData{{
+					Name:   "log_addr",
+					Filter: dig.Filter{Op: "contains", Ref: dig.Ref{Table: "tokens", Column: "addr"}},
+				}},
+			},
+		},
+	}
+	diff.Test(t, t.Fatalf, ValidateFilterRefs(conf), nil)
+	diff.Test(t, t.Errorf, conf.Integrations[1].Block[0].Filter.Ref.Table, "pools")
+	diff.Test(t, t.Errorf, conf.Integrations[1].Dependencies, []string{"factory"})
+	diff.Test(t, t.Errorf, conf.Integrations[0].Table.Index, [][]string{{"pool"}})
+	diff.Test(t, t.Errorf, len(conf.Integrations[2].Dependencies), 0)
+
+	conf.Integrations[2].Block[0].Filter.Ref.Table = ""
+	const want = `field "log_addr": filter_ref requires integration or table and column fields`
+	diff.Test(t, t.Errorf, ValidateFilterRefs(conf).Error(), want)
+
+	conf.Integrations[2].Block[0].Filter.Ref.Table = "tokens"
+	conf.Integrations[0].Table.Columns[0].Type = "text"
+	const wantType = `field "log_addr": filter_ref column "pool" must be bytea. got: text`
+	diff.Test(t, t.Errorf, ValidateFilterRefs(conf).Error(), wantType)
+
+	conf.Integrations[0].Table.Columns[0].Type = "bytea"
+	conf.Integrations[2].Block[0].Filter.Ref.Refresh = "1m"
+	diff.Test(t, t.Errorf, ValidateFilterRefs(conf), nil)
+
+	conf.Integrations[2].Block[0].Filter.Ref.Refresh = "soon"
+	const wantRefresh = `field "log_addr": filter_ref refresh: time: invalid duration "soon"`
+	diff.Test(t, t.Errorf, ValidateFilterRefs(conf).Error(), wantRefresh)
+
+	conf.Integrations[2].Block[0].Filter.Ref.Refresh = ""
+	conf.Integrations[1].Block[0].Filter.Ref.Refresh = "1m"
+	const wantManaged = `field "log_addr": filter_ref refresh is only used for tables that aren't managed by shovel`
+	diff.Test(t, t.Errorf, ValidateFilterRefs(conf).Error(), wantManaged)
+}
+
+func TestValidateFix_Function(t *testing.T) {
+	conf := &Root{
+		Integrations: []Integration{
+			{
+				Name: "foo",
+				Table: wpg.Table{
+					Name: "foo",
+					Columns: []wpg.Column{
+						{Name: "to", Type: "bytea"},
+					},
+				},
+				Function: dig.Function{
+					Name: "transfer",
+					Inputs: []dig.Input{
+						{Name: "to", Type: "address", Column: "to"},
+						{Name: "amount", Type: "uint256"},
+					},
+				},
+			},
+		},
+	}
+	diff.Test(t, t.Fatalf, ValidateFix(conf), nil)
+	diff.Test(t, t.Errorf, conf.Integrations[0].Table.Unique, [][]string{
+		{"ig_name", "src_name", "block_num", "tx_idx", "abi_idx"},
+	})
+
+	conf.Integrations[0].Event = dig.Event{Name: "Transfer"}
+	const want = "checking config for references: event and function are mutually exclusive"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+}
+
+func TestValidateFix_Sinks(t *testing.T) {
+	conf := &Root{
+		Integrations: []Integration{
+			{
+				Name: "foo",
+				Table: wpg.Table{
+					Name:    "foo",
+					Columns: []wpg.Column{{Name: "tx_hash", Type: "bytea"}},
+				},
+				Block: []dig.BlockData{{Name: "tx_hash", Column: "tx_hash"}},
+				Sinks: []sink.Config{{Kafka: &sink.Kafka{
+					Brokers: []wos.EnvString{"localhost:9092"},
+					Topic:   "foo",
+					Key:     "tx_hash",
+				}}},
+			},
+		},
+	}
+	diff.Test(t, t.Fatalf, ValidateFix(conf), nil)
+
+	conf.Integrations[0].Sinks[0].Kafka.Key = "bar"
+	const want = `checking sinks for foo: kafka sink key "bar" is not a column of foo`
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+}
+
+func TestValidateFix_OIDC(t *testing.T) {
+	conf := &Root{Dashboard: Dashboard{OIDC: &OIDC{
+		Issuer:      "https://accounts.example.com",
+		RedirectURL: "https://shovel.example.com/oidc/callback",
+	}}}
+	const want = "checking dashboard oidc: missing client_id"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+
+	conf.Dashboard.OIDC.ClientID = "shovel"
+	const wantAllowed = "checking dashboard oidc: one of allowed_groups, allowed_emails, or allowed_domains is required"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), wantAllowed)
+
+	conf.Dashboard.OIDC.AllowedDomains = []string{"example.com"}
+	diff.Test(t, t.Fatalf, ValidateFix(conf), nil)
+	diff.Test(t, t.Errorf, conf.Dashboard.OIDC.GroupsClaim, "groups")
+}
+
+func TestValidateFix_Log(t *testing.T) {
+	conf := &Root{Log: Log{Format: "logfmt"}}
+	const want = "checking log: format must be one of: text, json. got: logfmt"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+
+	conf.Log = Log{Format: "json", Levels: map[string]string{"jrpc2": "loud"}}
+	const want2 = `checking log: level for jrpc2: slog: level string "loud": unknown name`
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want2)
+
+	conf.Log.Levels["jrpc2"] = "debug"
+	diff.Test(t, t.Errorf, ValidateFix(conf), nil)
+}
+
+func TestValidateFix_Backpressure(t *testing.T) {
+	conf := &Root{}
+	diff.Test(t, t.Errorf, ValidateFix(conf), nil)
+	diff.Test(t, t.Errorf, conf.Backpressure.Latency(), 10*time.Second)
+
+	conf.Backpressure.MaxInsertLatency = "30s"
+	diff.Test(t, t.Errorf, ValidateFix(conf), nil)
+	diff.Test(t, t.Errorf, conf.Backpressure.Latency(), 30*time.Second)
+
+	conf.Backpressure.MaxInsertLatency = "-1s"
+	const want = "checking backpressure: max_insert_latency must be positive. got: -1s"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+}
+
+func TestValidateFix_Alerts(t *testing.T) {
+	conf := &Root{Alerts: Alerts{Rules: []AlertRule{{Name: "stall"}}}}
+	const want = "checking alerts: rule stall must set one of max_lag or max_errors"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+
+	conf.Alerts.Rules[0] = AlertRule{Name: "stall", MaxLag: 10, For: "5"}
+	const want2 = `checking alerts: rule stall for: time: missing unit in duration "5"`
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want2)
+
+	conf.Alerts.Rules[0].For = "10m"
+	conf.Alerts.Hooks = []AlertHook{{Kind: "pagerduty"}}
+	const want3 = "checking alerts: pagerduty hook missing routing_key"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want3)
+
+	conf.Alerts.Hooks[0].RoutingKey = "key"
+	diff.Test(t, t.Errorf, ValidateFix(conf), nil)
+	diff.Test(t, t.Errorf, conf.Alerts.Rules[0].Duration(), 10*time.Minute)
+}
+
+func TestValidateFix_Indexes(t *testing.T) {
+	cases := []struct {
+		idx  wpg.Index
+		want string
+	}{
+		{wpg.Index{Columns: []string{"a"}, Method: "brin"}, ""},
+		{wpg.Index{Columns: []string{"b"}}, "checking indexes for foo: index column b is not a column of foo"},
+		{wpg.Index{Columns: []string{"a"}, Method: "gin"}, "checking indexes for foo: index method must be one of: btree, brin, hash. got: gin"},
+		{wpg.Index{Columns: []string{"a", "a"}, Method: "hash"}, "checking indexes for foo: hash index on foo must have a single column"},
+		{wpg.Index{Columns: []string{"a"}, Where: "a > 1; drop table foo"}, "checking indexes for foo: index predicate on foo must be a single expression"},
+	}
+	for _, tc := range cases {
+		conf := &Root{Integrations: []Integration{{
+			Name: "foo",
+			Table: wpg.Table{
+				Name:    "foo",
+				Columns: []wpg.Column{{Name: "a", Type: "int"}},
+				Indexes: []wpg.Index{tc.idx},
+			},
+		}}}
+		var got string
+		if err := ValidateFix(conf); err != nil {
+			got = err.Error()
+		}
+		diff.Test(t, t.Errorf, got, tc.want)
+	}
+}
+
+func TestValidateTypes(t *testing.T) {
+	cases := []struct {
+		inp  dig.Input
+		col  string
+		want string
+	}{
+		{dig.Input{Name: "a", Type: "uint256", Column: "a"}, "numeric", ""},
+		{dig.Input{Name: "a", Type: "uint32[]", Column: "a"}, "bigint", ""},
+		{dig.Input{Name: "a", Type: "uint64", Column: "a"}, "bigint", "column a (bigint) cannot store a (uint64)"},
+		{dig.Input{Name: "a", Type: "int64", Column: "a"}, "int8", ""},
+		{dig.Input{Name: "a", Type: "address", Column: "a"}, "text", "column a (text) cannot store a (address)"},
+		{dig.Input{Name: "a", Type: "bytes32", Column: "a"}, "bytea", ""},
+		{dig.Input{Name: "a", Type: "uint", Column: "a"}, "numeric", "input a: uint must be written as uint256"},
+		{dig.Input{Name: "a", Type: "uint7", Column: "a"}, "numeric", "input a: invalid integer type uint7"},
+		{dig.Input{Name: "a", Type: "bytes33", Column: "a"}, "bytea", "input a: invalid type bytes33"},
+		{dig.Input{Name: "a", Type: "address[x]", Column: "a"}, "bytea", "input a: invalid array type address[x]"},
+		{dig.Input{Name: "a", Type: "tuple"}, "bytea", "input a: tuple without components"},
+	}
+	for _, tc := range cases {
+		ig := Integration{
+			Table: wpg.Table{Columns: []wpg.Column{{Name: "a", Type: tc.col}}},
+			Event: dig.Event{Name: "E", Inputs: []dig.Input{tc.inp}},
+		}
+		var got string
+		if err := ValidateTypes(ig); err != nil {
+			got = err.Error()
+		}
+		diff.Test(t, t.Errorf, got, tc.want)
+	}
+}
+
+func TestValidateFix_Events(t *testing.T) {
+	conf := &Root{
+		Integrations: []Integration{
+			{
+				Name: "foo",
+				Table: wpg.Table{
+					Name: "foo",
+					Columns: []wpg.Column{
+						{Name: "event_name", Type: "text"},
+						{Name: "user", Type: "bytea"},
+						{Name: "amount", Type: "numeric"},
+					},
+				},
+				Block: []dig.BlockData{
+					{Name: "event_name", Column: "event_name"},
+				},
+				Events: []dig.Event{
+					{
+						Name: "Deposit",
+						Inputs: []dig.Input{
+							{Indexed: true, Name: "user", Type: "address", Column: "user"},
+							{Name: "amount", Type: "uint256", Column: "amount"},
+						},
+					},
+					{
+						Name: "Withdraw",
+						Inputs: []dig.Input{
+							{Indexed: true, Name: "user", Type: "address", Column: "user"},
+							{Name: "amount", Type: "uint256", Column: "amount"},
+						},
+					},
+				},
+			},
+		},
+	}
+	diff.Test(t, t.Fatalf, ValidateFix(conf), nil)
+	diff.Test(t, t.Errorf, len(conf.Integrations[0].AllEvents()), 2)
+	diff.Test(t, t.Errorf, conf.Integrations[0].Table.Unique, [][]string{
+		{"ig_name", "src_name", "block_num", "tx_idx", "log_idx", "abi_idx"},
+	})
+
+	conf.Integrations[0].Events[1].Inputs[1].Type = "uint128"
+	const wantType = "checking config for references: column amount: Withdraw.amount is uint128 but Deposit is uint256"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), wantType)
+
+	conf.Integrations[0].Events[1].Inputs[1].Type = "uint256"
+	conf.Integrations[0].Events[1].Inputs[1].Name = "user"
+	const want = "checking config for references: duplicate input: user"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+}
+
+func TestValidateFix_Withdrawals(t *testing.T) {
+	conf := &Root{
+		Integrations: []Integration{
+			{
+				Name: "foo",
+				Table: wpg.Table{
+					Name: "foo",
+					Columns: []wpg.Column{
+						{Name: "validator", Type: "numeric"},
+						{Name: "amount", Type: "numeric"},
+					},
+				},
+				Block: []dig.BlockData{
+					{Name: "withdrawal_validator_index", Column: "validator"},
+					{Name: "withdrawal_amount", Column: "amount"},
+				},
+			},
+		},
+	}
+	diff.Test(t, t.Fatalf, ValidateFix(conf), nil)
+	diff.Test(t, t.Errorf, conf.Integrations[0].Table.Unique, [][]string{
+		{"ig_name", "src_name", "block_num", "withdrawal_index"},
+	})
+
+	conf.Integrations[0].Block = append(conf.Integrations[0].Block, dig.BlockData{
+		Name:   "tx_hash",
+		Column: "tx_hash",
+	})
+	const want = "checking withdrawals for foo: withdrawal data cannot be used with tx_hash"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+}
+
+func TestValidateFix_Filters(t *testing.T) {
+	cases := []struct {
+		filter dig.Filter
+		want   string
+	}{
+		{dig.Filter{Op: "range", Arg: []string{"1", "1e18"}}, ""},
+		{dig.Filter{Op: "range", Arg: []string{"-5", "5"}}, ""},
+		{dig.Filter{Op: "gte", Arg: []string{"1"}}, ""},
+		{
+			dig.Filter{Op: "range", Arg: []string{"1"}},
+			`checking filters for foo: field "amount": range filter requires 2 args [min, max]. got: 1`,
+		},
+		{
+			dig.Filter{Op: "range", Arg: []string{"10", "1"}},
+			`checking filters for foo: field "amount": range filter min 10 is greater than max 1`,
+		},
+		{
+			dig.Filter{Op: "range", Arg: []string{"a", "1"}},
+			`checking filters for foo: field "amount": range filter args must be numbers. got: [a 1]`,
+		},
+		{
+			dig.Filter{Op: "lt", Arg: []string{"1", "2"}},
+			`checking filters for foo: field "amount": lt filter requires 1 arg. got: 2`,
+		},
+	}
+	for _, tc := range cases {
+		conf := &Root{
+			Integrations: []Integration{
+				{
+					Name: "foo",
+					Table: wpg.Table{
+						Name:    "foo",
+						Columns: []wpg.Column{{Name: "amount", Type: "numeric"}},
+					},
+					Event: dig.Event{
+						Name: "Transfer",
+						Inputs: []dig.Input{
+							{Name: "amount", Type: "uint256", Column: "amount", Filter: tc.filter},
+						},
+					},
+				},
+			},
+		}
+		err := ValidateFix(conf)
+		switch {
+		case len(tc.want) == 0:
+			diff.Test(t, t.Errorf, err, nil)
+		case err == nil:
+			t.Errorf("%v: expected error %q", tc.filter.Arg, tc.want)
+		default:
+			diff.Test(t, t.Errorf, err.Error(), tc.want)
+		}
+	}
+
+	conf := &Root{
+		Integrations: []Integration{
+			{
+				Name:  "foo",
+				Table: wpg.Table{Name: "foo", Columns: []wpg.Column{{Name: "block_num", Type: "numeric"}}},
+				Block: []dig.BlockData{{
+					Name:   "block_num",
+					Column: "block_num",
+					Filter: dig.Filter{Op: "range", Arg: []string{"2", "1"}},
+				}},
+			},
+		},
+	}
+	const want = `checking filters for foo: field "block_num": range filter min 2 is greater than max 1`
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+}
+
+func TestValidateFix_Unnest(t *testing.T) {
+	conf := &Root{
+		Integrations: []Integration{
+			{
+				Name: "foo",
+				Table: wpg.Table{
+					Name: "foo",
+					Columns: []wpg.Column{
+						{Name: "id", Type: "numeric"},
+						{Name: "value", Type: "numeric"},
+					},
+				},
+				Event: dig.Event{
+					Name: "TransferBatch",
+					Inputs: []dig.Input{
+						{Indexed: true, Name: "operator", Type: "address"},
+						{Indexed: true, Name: "from", Type: "address"},
+						{Indexed: true, Name: "to", Type: "address"},
+						{Name: "ids", Type: "uint256[]", Column: "id", Unnest: true},
+						{Name: "values", Type: "uint256[]", Column: "value", Unnest: true},
+					},
+				},
+			},
+		},
+	}
+	diff.Test(t, t.Fatalf, ValidateFix(conf), nil)
+	diff.Test(t, t.Errorf, conf.Integrations[0].Table.Unique, [][]string{
+		{"ig_name", "src_name", "block_num", "tx_idx", "log_idx", "abi_idx", "array_idx"},
+	})
+
+	conf.Integrations[0].Event.Inputs[3].Type = "uint256"
+	const want = "checking unnest for foo: ids: unnest requires an array type. got: uint256"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+}
+
+func TestValidateFix_Anonymous(t *testing.T) {
+	conf := &Root{
+		Integrations: []Integration{
+			{
+				Name: "foo",
+				Table: wpg.Table{
+					Name: "foo",
+					Columns: []wpg.Column{
+						{Name: "log_addr", Type: "bytea"},
+						{Name: "amount", Type: "numeric"},
+					},
+				},
+				Block: []dig.BlockData{
+					{Name: "log_addr", Column: "log_addr"},
+				},
+				Event: dig.Event{
+					Anon: true,
+					Name: "Note",
+					Inputs: []dig.Input{
+						{Indexed: true, Name: "sig", Type: "bytes4"},
+						{Name: "amount", Type: "uint256", Column: "amount"},
+					},
+				},
+			},
+		},
+	}
+	const want = "checking anonymous events for foo: Note: anonymous event without topic0 requires a log_addr filter"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+
+	conf.Integrations[0].Event.Topic0 = "0xa9059cbb00000000000000000000000000000000000000000000000000000000"
+	diff.Test(t, t.Errorf, ValidateFix(conf), nil)
+}
+
+func TestValidateFix_Wildcard(t *testing.T) {
+	conf := &Root{
+		Integrations: []Integration{
+			{
+				Name: "foo",
+				Table: wpg.Table{
+					Name: "foo",
+					Columns: []wpg.Column{
+						{Name: "log_addr", Type: "bytea"},
+						{Name: "log_data", Type: "bytea"},
+					},
+				},
+				Block: []dig.BlockData{
+					{Name: "log_addr", Column: "log_addr"},
+					{Name: "log_data", Column: "log_data"},
+				},
+			},
+		},
+	}
+	const want = "checking log capture for foo: capturing all logs requires a log_addr filter"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+
+	conf.Integrations[0].Block[0].Filter = dig.Filter{Op: "contains", Arg: []string{"0x01"}}
+	diff.Test(t, t.Fatalf, ValidateFix(conf), nil)
+	diff.Test(t, t.Errorf, conf.Integrations[0].Table.Unique, [][]string{
+		{"ig_name", "src_name", "block_num", "tx_idx", "log_idx"},
+	})
+}
+
+func TestValidateFix_Preset(t *testing.T) {
+	conf := &Root{
+		Integrations: []Integration{
+			{
+				Name:   "foo",
+				Preset: "erc20_transfer",
+				Block: []dig.BlockData{
+					{
+						Name:   "log_addr",
+						Column: "log_addr",
+						Filter: dig.Filter{Op: "contains", Arg: []string{"0x01"}},
+					},
+				},
+			},
+		},
+	}
+	diff.Test(t, t.Fatalf, ValidateFix(conf), nil)
+	ig := conf.Integrations[0]
+	diff.Test(t, t.Errorf, ig.Table.Name, "erc20_transfer")
+	diff.Test(t, t.Errorf, ig.Event.Name, "Transfer")
+	diff.Test(t, t.Errorf, len(ig.Block[0].Filter.Arg), 1)
+	diff.Test(t, t.Errorf, ig.Table.Unique, [][]string{
+		{"ig_name", "src_name", "block_num", "tx_idx", "log_idx", "abi_idx"},
+	})
+	diff.Test(t, t.Errorf, ValidateFix(conf), nil)
+
+	conf.Integrations[0].Preset = "erc20"
+	const want = "integration foo: unknown preset \"erc20\". must be one of: approval_for_all, erc1155_transfer_batch, erc1155_transfer_single, erc20_approval, erc20_transfer, erc721_approval, erc721_transfer, weth_deposit, weth_withdrawal"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+}
+
+func TestValidateFix_Encoding(t *testing.T) {
+	conf := &Root{
+		Encoding: Encoding{Addresses: "checksum"},
+		Integrations: []Integration{
+			{
+				Name:   "foo",
+				Preset: "erc20_transfer",
+				Table: wpg.Table{
+					Columns: []wpg.Column{
+						{Name: "value", Type: "text", Encoding: "decimal"},
+					},
+				},
+			},
+		},
+	}
+	diff.Test(t, t.Fatalf, ValidateFix(conf), nil)
+	cols := map[string]wpg.Column{}
+	for _, c := range conf.Integrations[0].Table.Columns {
+		cols[c.Name] = c
+	}
+	diff.Test(t, t.Errorf, cols["from"], wpg.Column{Name: "from", Type: "text", Encoding: "checksum"})
+	diff.Test(t, t.Errorf, cols["value"], wpg.Column{Name: "value", Type: "text", Encoding: "decimal"})
+	diff.Test(t, t.Errorf, cols["log_addr"].Encoding, "")
+	diff.Test(t, t.Errorf, ValidateTypes(conf.Integrations[0]), nil)
+
+	conf.Integrations[0].Table.Columns[0].Type = "numeric"
+	const want = "checking encodings for foo: column value: decimal encoding requires a text column. got: numeric"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+}
+
+func TestValidateFix_Generated(t *testing.T) {
+	conf := &Root{
+		Integrations: []Integration{
+			{
+				Name:   "foo",
+				Preset: "erc20_transfer",
+				Table: wpg.Table{
+					Columns: []wpg.Column{
+						{Name: "amount", Type: "numeric", Expr: "value / 1e6"},
+					},
+				},
+			},
+		},
+	}
+	diff.Test(t, t.Fatalf, ValidateFix(conf), nil)
+
+	conf.Integrations[0].Table.Columns[0].Expr = "value; drop table foo"
+	const want = "checking generated columns for foo: column amount: expr must be a single expression"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+}
+
+func TestValidateFix_OnError(t *testing.T) {
+	conf := &Root{
+		Integrations: []Integration{
+			{
+				Name:    "foo",
+				Preset:  "erc20_transfer",
+				OnError: "dead_letter",
+			},
+		},
+	}
+	diff.Test(t, t.Errorf, ValidateFix(conf), nil)
+
+	conf.Integrations[0].OnError = "skip"
+	diff.Test(t, t.Errorf, ValidateFix(conf), nil)
+
+	conf.Integrations[0].OnError = "ignore"
+	const want = "on_error must be one of: fail, skip, dead_letter. got: ignore"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+}
+
+func TestValidateFix_Priority(t *testing.T) {
+	conf := &Root{
+		Integrations: []Integration{
+			{
+				Name:     "foo",
+				Preset:   "erc20_transfer",
+				Priority: "high",
+			},
+		},
+	}
+	diff.Test(t, t.Errorf, ValidateFix(conf), nil)
+	diff.Test(t, t.Errorf, conf.Integrations[0].PriorityLevel(), 1)
+
+	conf.Integrations[0].Priority = "urgent"
+	const want = "priority must be one of: high, normal, low. got: urgent"
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+}
+
+func TestSourceRange(t *testing.T) {
+	cases := []struct {
+		sc, ref     Source
+		start, stop uint64
+	}{
+		{Source{}, Source{}, 0, 0},
+		{Source{Start: 10}, Source{Start: 18}, 18, 0},
+		{Source{Start: 18}, Source{Start: 10}, 18, 0},
+		{Source{Stop: 100}, Source{Stop: 50}, 0, 50},
+		{Source{Stop: 50}, Source{Stop: 100}, 0, 50},
+		{Source{Stop: 50}, Source{}, 0, 50},
+		{Source{}, Source{Start: 10, Stop: 20}, 10, 20},
+	}
+	for _, c := range cases {
+		start, stop := c.sc.Range(c.ref)
+		if start != c.start || stop != c.stop {
+			t.Errorf("%+v %+v: got [%d, %d] want [%d, %d]", c.sc, c.ref, start, stop, c.start, c.stop)
+		}
+	}
+
+	var sc Source
+	const want = "start (10) must not exceed stop (5)"
+	err := json.Unmarshal([]byte(`{"name": "foo", "start": 10, "stop": 5}`), &sc)
+	diff.Test(t, t.Errorf, err.Error(), want)
+}
+
+func TestSourceSkip(t *testing.T) {
+	var sc Source
+	err := json.Unmarshal([]byte(`{
+		"name": "foo",
+		"skip": [
+			{"start": 20, "stop": 25, "reason": "trace timeout"},
+			{"start": 10, "reason": "bad receipt"}
+		]
+	}`), &sc)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, sc.Skip, []SkipBlocks{
+		{Start: 10, Stop: 10, Reason: "bad receipt"},
+		{Start: 20, Stop: 25, Reason: "trace timeout"},
+	})
+
+	err = json.Unmarshal([]byte(`{"name": "foo", "skip": [{"start": 5, "stop": 4}]}`), &sc)
+	diff.Test(t, t.Errorf, err.Error(), "skip start must be positive and stop must be >= start. got: 5-4")
+}
+
+func TestSourceTransport(t *testing.T) {
+	var sc Source
+	err := json.Unmarshal([]byte(`{
+		"name": "foo",
+		"transport": {
+			"max_idle_conns": 50,
+			"keep_alive": "15s",
+			"response_header_timeout": "5s",
+			"disable_http2": true
+		}
+	}`), &sc)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, sc.Transport, &Transport{
+		MaxIdleConns:          50,
+		KeepAlive:             15 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+		DisableHTTP2:          true,
+	})
+
+	err = json.Unmarshal([]byte(`{"name": "foo", "transport": {"keep_alive": "x"}}`), &sc)
+	diff.Test(t, t.Errorf, err.Error(), "unable to parse transport keep_alive value: x")
+}
+
+func TestSourceHeaders(t *testing.T) {
+	t.Setenv("RPC_KEY", "secret")
+	var sc Source
+	err := json.Unmarshal([]byte(`{
+		"name": "foo",
+		"headers": {"x-api-key": "$RPC_KEY"},
+		"basic_auth": {"username": "bar", "password": "$RPC_KEY"}
+	}`), &sc)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, sc.Headers, map[string]string{"x-api-key": "secret"})
+	diff.Test(t, t.Errorf, sc.BasicAuth, &BasicAuth{Username: "bar", Password: "secret"})
+
+	err = json.Unmarshal([]byte(`{
+		"name": "foo",
+		"headers": {"Authorization": "Bearer x"},
+		"basic_auth": {"username": "bar"}
+	}`), &sc)
+	diff.Test(t, t.Errorf, err.Error(), "basic_auth and an authorization header are mutually exclusive")
+}
+
+func TestSourceProxy(t *testing.T) {
+	var sc Source
+	err := json.Unmarshal([]byte(`{"name": "foo", "transport": {"proxy": "socks5://proxy:1080"}}`), &sc)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, sc.Transport.Proxy, "socks5://proxy:1080")
+
+	err = json.Unmarshal([]byte(`{"name": "foo", "transport": {"proxy": "ftp://proxy"}}`), &sc)
+	diff.Test(t, t.Errorf, err.Error(), "transport proxy must be an http, https, or socks5 url. got: ftp://proxy")
+}
+
+func TestSourceTLS(t *testing.T) {
+	var sc Source
+	err := json.Unmarshal([]byte(`{"name": "foo", "tls": {"cert": "cert.pem"}}`), &sc)
+	diff.Test(t, t.Errorf, err.Error(), "tls cert and key must be set together")
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	diff.Test(t, t.Fatalf, os.WriteFile(ca, []byte("not a cert"), 0644), nil)
+	err = json.Unmarshal([]byte(`{"name": "foo", "tls": {"ca": "`+ca+`"}}`), &sc)
+	diff.Test(t, t.Errorf, err.Error(), "no certificates found in tls ca")
+}
+
+func TestSourceDecodeWorkers(t *testing.T) {
+	var sc Source
+	err := json.Unmarshal([]byte(`{"name": "foo", "decode_workers": 4}`), &sc)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, sc.DecodeWorkers, 4)
+
+	err = json.Unmarshal([]byte(`{"name": "foo", "decode_workers": -1}`), &sc)
+	diff.Test(t, t.Errorf, err.Error(), "decode_workers must not be negative")
+}
+
+func TestValidateFix_Databases(t *testing.T) {
+	conf := &Root{
+		Databases: []Database{{Name: "raw", URL: "$RAW_PG_URL"}},
+		Integrations: []Integration{
+			{Name: "foo", Preset: "erc20_transfer", Database: "raw"},
+			{Name: "bar", Preset: "erc20_approval"},
+		},
+	}
+	diff.Test(t, t.Fatalf, ValidateFix(conf), nil)
+	diff.Test(t, t.Errorf, len(conf.ForDatabase("raw").Integrations), 1)
+	diff.Test(t, t.Errorf, conf.ForDatabase("").Integrations[0].Name, "bar")
+
+	conf.Integrations[0].Database = "curated"
+	const want = "checking databases: integration foo: unknown database \"curated\""
+	diff.Test(t, t.Errorf, ValidateFix(conf).Error(), want)
+}
+
+func TestExportBucket(t *testing.T) {
+	cases := []struct {
+		url    string
+		bucket string
+		prefix string
+		err    string
+	}{
+		{"s3://data/shovel/", "data", "shovel", ""},
+		{"s3://data", "data", "", ""},
+		{"gs://data/shovel", "", "", "export url must start with s3://"},
+		{"s3:///shovel", "", "", "export url is missing a bucket"},
+	}
+	for _, tc := range cases {
+		bucket, prefix, err := Export{URL: wos.EnvString(tc.url)}.Bucket()
+		if err != nil {
+			diff.Test(t, t.Errorf, err.Error(), tc.err)
+			continue
+		}
+		diff.Test(t, t.Errorf, bucket, tc.bucket)
+		diff.Test(t, t.Errorf, prefix, tc.prefix)
+	}
+}
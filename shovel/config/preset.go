@@ -0,0 +1,202 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/indexsupply/shovel/dig"
+	"github.com/indexsupply/shovel/wpg"
+)
+
+// A preset supplies the event, columns, and block data
+// for commonly indexed events. Config may add columns and
+// block data, or replace a preset's block data (eg to add a
+// log_addr filter) by using the same name.
+type preset struct {
+	event dig.Event
+	cols  []wpg.Column
+}
+
+func addr(name, col string, indexed bool) dig.Input {
+	return dig.Input{Indexed: indexed, Name: name, Type: "address", Column: col}
+}
+
+func u256(name, col string, indexed bool) dig.Input {
+	return dig.Input{Indexed: indexed, Name: name, Type: "uint256", Column: col}
+}
+
+func cols(types ...string) []wpg.Column {
+	var res []wpg.Column
+	for i := 0; i+1 < len(types); i += 2 {
+		res = append(res, wpg.Column{Name: types[i], Type: types[i+1]})
+	}
+	return res
+}
+
+// Block data added to every preset
+var (
+	presetBlocks = []dig.BlockData{
+		{Name: "log_addr", Column: "log_addr"},
+		{Name: "tx_hash", Column: "tx_hash"},
+	}
+	presetCols = cols("log_addr", "bytea", "tx_hash", "bytea")
+)
+
+var presets = map[string]preset{
+	"erc20_transfer": {
+		event: dig.Event{
+			Name: "Transfer",
+			Type: "event",
+			Inputs: []dig.Input{
+				addr("from", "from", true),
+				addr("to", "to", true),
+				u256("value", "value", false),
+			},
+		},
+		cols: cols("from", "bytea", "to", "bytea", "value", "numeric"),
+	},
+	"erc20_approval": {
+		event: dig.Event{
+			Name: "Approval",
+			Type: "event",
+			Inputs: []dig.Input{
+				addr("owner", "owner", true),
+				addr("spender", "spender", true),
+				u256("value", "value", false),
+			},
+		},
+		cols: cols("owner", "bytea", "spender", "bytea", "value", "numeric"),
+	},
+	"erc721_transfer": {
+		event: dig.Event{
+			Name: "Transfer",
+			Type: "event",
+			Inputs: []dig.Input{
+				addr("from", "from", true),
+				addr("to", "to", true),
+				u256("tokenId", "token_id", true),
+			},
+		},
+		cols: cols("from", "bytea", "to", "bytea", "token_id", "numeric"),
+	},
+	"erc721_approval": {
+		event: dig.Event{
+			Name: "Approval",
+			Type: "event",
+			Inputs: []dig.Input{
+				addr("owner", "owner", true),
+				addr("approved", "approved", true),
+				u256("tokenId", "token_id", true),
+			},
+		},
+		cols: cols("owner", "bytea", "approved", "bytea", "token_id", "numeric"),
+	},
+	// ERC-721 and ERC-1155 share the same event
+	"approval_for_all": {
+		event: dig.Event{
+			Name: "ApprovalForAll",
+			Type: "event",
+			Inputs: []dig.Input{
+				addr("owner", "owner", true),
+				addr("operator", "operator", true),
+				{Name: "approved", Type: "bool", Column: "approved"},
+			},
+		},
+		cols: cols("owner", "bytea", "operator", "bytea", "approved", "bool"),
+	},
+	"erc1155_transfer_single": {
+		event: dig.Event{
+			Name: "TransferSingle",
+			Type: "event",
+			Inputs: []dig.Input{
+				addr("operator", "operator", true),
+				addr("from", "from", true),
+				addr("to", "to", true),
+				u256("id", "id", false),
+				u256("value", "value", false),
+			},
+		},
+		cols: cols("operator", "bytea", "from", "bytea", "to", "bytea", "id", "numeric", "value", "numeric"),
+	},
+	"erc1155_transfer_batch": {
+		event: dig.Event{
+			Name: "TransferBatch",
+			Type: "event",
+			Inputs: []dig.Input{
+				addr("operator", "operator", true),
+				addr("from", "from", true),
+				addr("to", "to", true),
+				{Name: "ids", Type: "uint256[]", Column: "id", Unnest: true},
+				{Name: "values", Type: "uint256[]", Column: "value", Unnest: true},
+			},
+		},
+		cols: cols("operator", "bytea", "from", "bytea", "to", "bytea", "id", "numeric", "value", "numeric"),
+	},
+	"weth_deposit": {
+		event: dig.Event{
+			Name: "Deposit",
+			Type: "event",
+			Inputs: []dig.Input{
+				addr("dst", "dst", true),
+				u256("wad", "wad", false),
+			},
+		},
+		cols: cols("dst", "bytea", "wad", "numeric"),
+	},
+	"weth_withdrawal": {
+		event: dig.Event{
+			Name: "Withdrawal",
+			Type: "event",
+			Inputs: []dig.Input{
+				addr("src", "src", true),
+				u256("wad", "wad", false),
+			},
+		},
+		cols: cols("src", "bytea", "wad", "numeric"),
+	},
+}
+
+func presetNames() string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Sets the integration's event, columns, and block data
+// using its preset. The table name defaults to the
+// preset's name.
+func (ig *Integration) applyPreset() error {
+	if len(ig.Preset) == 0 {
+		return nil
+	}
+	p, ok := presets[ig.Preset]
+	if !ok {
+		return fmt.Errorf("unknown preset %q. must be one of: %s", ig.Preset, presetNames())
+	}
+	// config may have been validated (and the preset applied) before
+	applied := ig.Event.Name == p.event.Name && len(ig.Events) == 0 && ig.Function.Empty()
+	if !applied && (len(ig.AllEvents()) > 0 || !ig.Function.Empty()) {
+		return fmt.Errorf("preset cannot be used with an event or function")
+	}
+	ig.Event = p.event
+	ig.Event.Inputs = slices.Clone(p.event.Inputs)
+	if len(ig.Table.Name) == 0 {
+		ig.Table.Name = ig.Preset
+	}
+	for _, c := range append(slices.Clone(p.cols), presetCols...) {
+		if !slices.ContainsFunc(ig.Table.Columns, func(x wpg.Column) bool { return x.Name == c.Name }) {
+			ig.Table.Columns = append(ig.Table.Columns, c)
+		}
+	}
+	for _, bd := range presetBlocks {
+		if !slices.ContainsFunc(ig.Block, func(x dig.BlockData) bool { return x.Name == bd.Name }) {
+			ig.Block = append(ig.Block, bd)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package shovel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/indexsupply/shovel/shovel/config"
+	"github.com/indexsupply/shovel/wctx"
+	"github.com/indexsupply/shovel/wparquet"
+	"github.com/indexsupply/shovel/wpg"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/jackc/pgx/v5"
+)
+
+// Writes each of the integration's finalized block ranges
+// that hasn't been exported to a Parquet file. Ranges are
+// aligned to multiples of Export.Blocks and a range is
+// final once the task is ReorgDepth blocks past its end.
+// Exported ranges are recorded in shovel.exports and a
+// range is exported again when rows are written into it
+// (eg by a backfill).
+//
+// Files are named using hive style partitions:
+//
+//	<prefix>/<table>/ig_name=<ig>/src_name=<src>/<start>-<stop>.parquet
+//
+// so src_name and ig_name aren't included in the file.
+func ExportIntegration(ctx context.Context, pg wpg.Conn, ig config.Integration, sources map[string]config.Source) error {
+	if ig.Export.Empty() {
+		return nil
+	}
+	up, err := newUploader(ig.Export)
+	if err != nil {
+		return err
+	}
+	ctx = wctx.WithIGName(ctx, ig.Name)
+	for _, ref := range ig.Sources {
+		finality := sources[ref.Name].ReorgDepth
+		if finality == 0 {
+			finality = 1000
+		}
+		if err := exportSource(ctx, pg, up, ig, ref.Name, finality); err != nil {
+			return fmt.Errorf("exporting %s: %w", ref.Name, err)
+		}
+	}
+	return nil
+}
+
+func exportSource(ctx context.Context, pg wpg.Conn, up *uploader, ig config.Integration, srcName string, finality uint64) error {
+	blocks := ig.Export.Blocks
+	if blocks == 0 {
+		blocks = 100_000
+	}
+	var latest uint64
+	const lq = `
+		select coalesce(max(num), 0)
+		from shovel.task_updates
+		where src_name = $1 and ig_name = $2
+	`
+	if err := pg.QueryRow(ctx, lq, srcName, ig.Name).Scan(&latest); err != nil {
+		return fmt.Errorf("querying latest: %w", err)
+	}
+	if latest <= finality {
+		return nil
+	}
+	final := latest - finality
+	if final+1 < blocks {
+		return nil
+	}
+
+	var first *uint64
+	fq := fmt.Sprintf(`
+		select min(block_num)
+		from %s
+		where src_name = $1 and ig_name = $2
+	`, ig.Table.QName())
+	if err := pg.QueryRow(ctx, fq, srcName, ig.Name).Scan(&first); err != nil {
+		return fmt.Errorf("querying first block: %w", err)
+	}
+	if first == nil {
+		return nil
+	}
+	// Ranges are missing from shovel.exports when they
+	// haven't been exported or when rows were written into
+	// them after they were exported (see [Task.staleExports]).
+	const mq = `
+		select s
+		from generate_series($3::bigint, $4::bigint, $5::bigint) s
+		where not exists (
+			select 1
+			from shovel.exports
+			where src_name = $1 and ig_name = $2 and start = s
+		)
+		order by s
+	`
+	rows, err := pg.Query(ctx, mq, srcName, ig.Name,
+		*first-*first%blocks,
+		(final+1)/blocks*blocks-blocks,
+		blocks,
+	)
+	if err != nil {
+		return fmt.Errorf("querying exports: %w", err)
+	}
+	starts, err := pgx.CollectRows(rows, pgx.RowTo[uint64])
+	if err != nil {
+		return fmt.Errorf("querying exports: %w", err)
+	}
+	for _, start := range starts {
+		if err := exportRange(ctx, pg, up, ig, srcName, start, start+blocks-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parquet type and select expression for the column.
+// Types without a Parquet equivalent (eg numeric) are
+// exported as text.
+func exportColumn(c wpg.Column) (wparquet.Kind, string) {
+	id := pgx.Identifier{c.Name}.Sanitize()
+	if c.Name == "block_num" {
+		return wparquet.Int64, id + "::bigint"
+	}
+	switch strings.ToLower(c.Type) {
+	case "bool", "boolean":
+		return wparquet.Boolean, id
+	case "smallint", "int2", "int", "integer", "int4":
+		return wparquet.Int32, id
+	case "bigint", "int8":
+		return wparquet.Int64, id
+	case "bytea":
+		return wparquet.Bytes, id
+	case "timestamp", "timestamptz":
+		return wparquet.Timestamp, id
+	default:
+		return wparquet.String, id + "::text"
+	}
+}
+
+// Row groups are written once their values reach this size
+// so that a range's rows aren't held in memory
+const exportRowGroup = 64 << 20
+
+func exportRange(ctx context.Context, pg wpg.Conn, up *uploader, ig config.Integration, srcName string, start, stop uint64) error {
+	var (
+		cols  []wparquet.Column
+		exprs []string
+	)
+	for _, c := range ig.Table.Columns {
+		if c.Name == "src_name" || c.Name == "ig_name" {
+			continue
+		}
+		kind, expr := exportColumn(c)
+		cols = append(cols, wparquet.Column{Name: c.Name, Kind: kind})
+		exprs = append(exprs, expr)
+	}
+	q := fmt.Sprintf(`
+		select %s
+		from %s
+		where src_name = $1 and ig_name = $2
+		and block_num between $3 and $4
+		order by block_num
+	`, strings.Join(exprs, ", "), ig.Table.QName())
+	rows, err := pg.Query(ctx, q, srcName, ig.Name, start, stop)
+	if err != nil {
+		return fmt.Errorf("querying rows: %w", err)
+	}
+	defer rows.Close()
+
+	// The file is written to a pipe that is read by the
+	// upload which is started once there is a row
+	var (
+		pr, pw = io.Pipe()
+		w      = wparquet.NewWriter(pw, cols)
+		done   chan error
+		k      = path.Join(
+			up.prefix,
+			ig.Table.QName(),
+			"ig_name="+ig.Name,
+			"src_name="+srcName,
+			fmt.Sprintf("%012d-%012d.parquet", start, stop),
+		)
+	)
+	err = func() error {
+		for rows.Next() {
+			if done == nil {
+				done = make(chan error, 1)
+				go func() {
+					err := up.upload(ctx, k, pr)
+					pr.CloseWithError(err)
+					done <- err
+				}()
+			}
+			vals, err := rows.Values()
+			if err != nil {
+				return fmt.Errorf("reading row: %w", err)
+			}
+			if err := w.Add(vals); err != nil {
+				return fmt.Errorf("adding row: %w", err)
+			}
+			if w.Buffered() >= exportRowGroup {
+				if err := w.Flush(); err != nil {
+					return fmt.Errorf("writing parquet: %w", err)
+				}
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("querying rows: %w", err)
+		}
+		if done == nil {
+			return nil
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("writing parquet: %w", err)
+		}
+		return nil
+	}()
+	// A nil error ends the upload's body and any other
+	// error aborts it
+	pw.CloseWithError(err)
+	if done != nil {
+		if uerr := <-done; err == nil && uerr != nil {
+			err = fmt.Errorf("uploading %s: %w", k, uerr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	var key *string
+	if w.Rows() > 0 {
+		key = &k
+	}
+	const iq = `
+		insert into shovel.exports (src_name, ig_name, start, stop, key, nrows)
+		values ($1, $2, $3, $4, $5, $6)
+	`
+	if _, err := pg.Exec(ctx, iq, srcName, ig.Name, start, stop, key, w.Rows()); err != nil {
+		return fmt.Errorf("recording export: %w", err)
+	}
+	slog.InfoContext(ctx, "export",
+		"src", srcName,
+		"start", start,
+		"stop", stop,
+		"n", w.Rows(),
+	)
+	return nil
+}
+
+// Uploads objects using the S3 API. Files larger than a part
+// are sent as multipart uploads. Requests use path style
+// urls so that S3 compatible stores work too. Credentials
+// are found the way the AWS CLI finds them: env vars, the
+// shared config and credentials files, or the instance's
+// role.
+type uploader struct {
+	s3     *s3manager.Uploader
+	bucket string
+	prefix string
+}
+
+func newUploader(e config.Export) (*uploader, error) {
+	bucket, prefix, err := e.Bucket()
+	if err != nil {
+		return nil, err
+	}
+	conf := aws.NewConfig().WithS3ForcePathStyle(true)
+	if len(e.Region) > 0 {
+		conf = conf.WithRegion(string(e.Region))
+	}
+	if len(e.Endpoint) > 0 {
+		conf = conf.WithEndpoint(string(e.Endpoint))
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *conf,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("aws session: %w", err)
+	}
+	if len(aws.StringValue(sess.Config.Region)) == 0 {
+		sess.Config.Region = aws.String("us-east-1")
+	}
+	return &uploader{
+		s3:     s3manager.NewUploader(sess),
+		bucket: bucket,
+		prefix: prefix,
+	}, nil
+}
+
+func (up *uploader) upload(ctx context.Context, key string, body io.Reader) error {
+	_, err := up.s3.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(up.bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String("application/vnd.apache.parquet"),
+	})
+	return err
+}
@@ -1,7 +1,13 @@
 // eth_getLogs filter
 package glf
 
-import "strings"
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/indexsupply/shovel/eth"
+)
 
 type Filter struct {
 	needs       []string
@@ -45,6 +51,135 @@ func New(needs, addresses []string, topics [][]string) *Filter {
 func (f *Filter) Addresses() []string { return f.addresses }
 func (f *Filter) Topics() [][]string  { return f.topics }
 
+// Replaces the log addresses. Used when the address
+// list isn't known until runtime (eg filter_ref).
+func (f *Filter) SetAddresses(addresses []string) {
+	f.addresses = append([]string(nil), addresses...)
+}
+
+func (f *Filter) SetTopics(topics [][]string) {
+	f.topics = append([][]string(nil), topics...)
+}
+
+// A filter that requests everything needed by each of
+// the filters. Logs are only limited by address or first
+// topic when every filter that uses logs is limited by it,
+// so the result may include logs that none of the filters
+// accept.
+func Union(filters ...Filter) Filter {
+	var (
+		res       Filter
+		addresses = map[string]bool{}
+		topics    = map[string]bool{}
+		anyAddr   bool
+		anyTopic  bool
+	)
+	for _, f := range filters {
+		res.UseHeaders = res.UseHeaders || f.UseHeaders
+		res.UseBlocks = res.UseBlocks || f.UseBlocks
+		res.UseReceipts = res.UseReceipts || f.UseReceipts
+		res.UseLogs = res.UseLogs || f.UseLogs
+		res.UseTraces = res.UseTraces || f.UseTraces
+		if !f.UseLogs && !f.UseReceipts {
+			continue
+		}
+		if len(f.addresses) == 0 {
+			anyAddr = true
+		}
+		for _, a := range f.addresses {
+			addresses[a] = true
+		}
+		if len(f.topics) == 0 || len(f.topics[0]) == 0 {
+			anyTopic = true
+		} else {
+			for _, t := range f.topics[0] {
+				topics[t] = true
+			}
+		}
+	}
+	if !anyAddr {
+		for a := range addresses {
+			res.addresses = append(res.addresses, a)
+		}
+		sort.Strings(res.addresses)
+	}
+	if !anyTopic && len(topics) > 0 {
+		var t0 []string
+		for t := range topics {
+			t0 = append(t0, t)
+		}
+		sort.Strings(t0)
+		res.topics = [][]string{t0}
+	}
+	return res
+}
+
+// Reports whether the filter limits logs by address or topic
+// such that a block's logs bloom can rule the block out.
+func (f *Filter) Screens() bool {
+	if len(f.addresses) > 0 {
+		return true
+	}
+	for _, t := range f.topics {
+		if len(t) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether a block with the logs bloom may contain a
+// log that the filter accepts: one of the addresses and one
+// of the topics in each position. See [eth.BloomContains].
+func (f *Filter) MayMatch(bloom []byte) bool {
+	contains := func(vals []string) bool {
+		if len(vals) == 0 {
+			return true
+		}
+		for _, v := range vals {
+			if eth.BloomContains(bloom, eth.DecodeHex(v)) {
+				return true
+			}
+		}
+		return false
+	}
+	if !contains(f.addresses) {
+		return false
+	}
+	for _, t := range f.topics {
+		if !contains(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether f requests every log that g requests.
+// Only addresses and the first topic are compared.
+func (f Filter) Covers(g Filter) bool {
+	if len(f.addresses) > 0 {
+		if len(g.addresses) == 0 {
+			return false
+		}
+		for _, a := range g.addresses {
+			if !slices.Contains(f.addresses, a) {
+				return false
+			}
+		}
+	}
+	if len(f.topics) > 0 && len(f.topics[0]) > 0 {
+		if len(g.topics) == 0 || len(g.topics[0]) == 0 {
+			return false
+		}
+		for _, t := range g.topics[0] {
+			if !slices.Contains(f.topics[0], t) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func (f *Filter) String() string {
 	var opts = make([]string, 0, 7)
 	if f.UseLogs {
@@ -100,11 +235,25 @@ var (
 		"block_hash",
 		"block_num",
 		"block_time",
+		"block_blob_gas_used",
+		"block_excess_blob_gas",
+		"block_l1_num",
+		"block_send_root",
+		"block_send_count",
+		"block_l1_batch_num",
+		"block_l1_batch_time",
 	}
 	block = []string{
 		"block_hash",
 		"block_num",
 		"block_time",
+		"block_blob_gas_used",
+		"block_excess_blob_gas",
+		"block_l1_num",
+		"block_send_root",
+		"block_send_count",
+		"block_l1_batch_num",
+		"block_l1_batch_time",
 		"tx_hash",
 		"tx_idx",
 		"tx_nonce",
@@ -115,6 +264,21 @@ var (
 		"tx_type",
 		"tx_max_priority_fee_per_gas",
 		"tx_max_fee_per_gas",
+		"tx_max_fee_per_blob_gas",
+		"tx_blob_versioned_hashes",
+		"tx_source_hash",
+		"tx_mint",
+		"tx_is_system_tx",
+		"tx_request_id",
+		"tx_ticket_id",
+		"tx_refund_to",
+		"tx_l1_base_fee",
+		"tx_deposit_value",
+		"tx_l1_batch_tx_idx",
+		"withdrawal_index",
+		"withdrawal_validator_index",
+		"withdrawal_address",
+		"withdrawal_amount",
 	}
 	receipt = []string{
 		"block_hash",
@@ -126,7 +290,10 @@ var (
 		"tx_type",
 		"tx_status",
 		"tx_gas_used",
+		"tx_cumulative_gas_used",
+		"tx_effective_gas_price",
 		"tx_contract_address",
+		"tx_l1_batch_tx_idx",
 		"log_addr",
 		"log_idx",
 	}
@@ -137,11 +304,19 @@ var (
 		"tx_idx",
 		"log_addr",
 		"log_idx",
+		"log_topics",
+		"log_data",
+		"log_event",
+		"log_decoded",
 	}
 	trace = []string{
 		"trace_action_call_type",
 		"trace_action_from",
 		"trace_action_to",
 		"trace_action_value",
+		"trace_action_input",
+		"trace_action_selector",
+		"trace_action_depth",
+		"trace_action_error",
 	}
 )
@@ -52,6 +52,26 @@ func TestNeeds(t *testing.T) {
 			fields:   []string{"tx_status"},
 			receipts: true,
 		},
+		{
+			fields:  []string{"block_num", "block_blob_gas_used", "block_excess_blob_gas"},
+			headers: true,
+		},
+		{
+			fields: []string{"tx_hash", "tx_blob_versioned_hashes", "tx_max_fee_per_blob_gas"},
+			blocks: true,
+		},
+		{
+			fields: []string{"block_num", "withdrawal_index", "withdrawal_amount"},
+			blocks: true,
+		},
+		{
+			fields:   []string{"tx_hash", "tx_effective_gas_price", "tx_gas_used"},
+			receipts: true,
+		},
+		{
+			fields:   []string{"tx_cumulative_gas_used", "tx_contract_address", "log_addr"},
+			receipts: true,
+		},
 	}
 	for _, tc := range cases {
 		f := New(tc.fields, nil, nil)
@@ -61,3 +81,36 @@ func TestNeeds(t *testing.T) {
 		diff.Test(t, t.Errorf, f.UseLogs, tc.logs)
 	}
 }
+
+func TestUnion(t *testing.T) {
+	var (
+		a = *New([]string{"log_addr"}, []string{"0xb"}, [][]string{{"0x2"}, {"0x9"}})
+		b = *New([]string{"log_addr"}, []string{"0xa"}, [][]string{{"0x1"}})
+		c = *New([]string{"block_time"}, nil, nil)
+		d = *New([]string{"log_idx"}, nil, nil)
+	)
+	got := Union(a, b, c)
+	diff.Test(t, t.Errorf, got.String(), "l,h")
+	diff.Test(t, t.Errorf, got.Addresses(), []string{"0xa", "0xb"})
+	diff.Test(t, t.Errorf, got.Topics(), [][]string{{"0x1", "0x2"}})
+
+	got = Union(a, d)
+	diff.Test(t, t.Errorf, got.Addresses(), []string(nil))
+	diff.Test(t, t.Errorf, got.Topics(), [][]string(nil))
+}
+
+func TestCovers(t *testing.T) {
+	var (
+		a = *New([]string{"log_addr"}, []string{"0xb"}, [][]string{{"0x2"}, {"0x9"}})
+		b = *New([]string{"log_addr"}, []string{"0xa"}, [][]string{{"0x1"}})
+		d = *New([]string{"log_idx"}, nil, nil)
+		u = Union(a, b)
+	)
+	diff.Test(t, t.Errorf, u.Covers(a), true)
+	diff.Test(t, t.Errorf, u.Covers(b), true)
+	diff.Test(t, t.Errorf, u.Covers(d), false)
+	diff.Test(t, t.Errorf, Union(a, d).Covers(b), true)
+
+	a.SetAddresses([]string{"0xc"})
+	diff.Test(t, t.Errorf, u.Covers(a), false)
+}
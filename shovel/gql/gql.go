@@ -0,0 +1,357 @@
+// Read-only GraphQL queries over integration tables
+//
+// Each table is a field on the Query type that returns a
+// list of rows. Tables with a block_num column are ordered
+// by block_num and may be filtered using block_num_gte and
+// block_num_lte. Pagination uses limit and offset.
+//
+//	{
+//	  erc20_transfers(block_num_gte: "19000000", limit: 10) {
+//	    block_num
+//	    from
+//	    to
+//	    value
+//	  }
+//	}
+//
+// bytea values are 0x prefixed hex strings. int8 and numeric
+// values are decimal strings (BigInt) since they may exceed
+// GraphQL's 32 bit Int.
+package gql
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/indexsupply/shovel/wpg"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+	MaxOffset    = 10_000
+
+	// Limits on a request's query. See [Check].
+	MaxTables = 10
+	MaxFields = 500
+	MaxDepth  = 12
+)
+
+// Decimal string. Accepts strings or integers as input.
+var BigInt = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "BigInt",
+	Description: "Arbitrary precision integer encoded as a decimal string",
+	Serialize: func(v any) any {
+		switch v := v.(type) {
+		case string:
+			return v
+		case nil:
+			return nil
+		default:
+			return fmt.Sprint(v)
+		}
+	},
+	ParseValue: func(v any) any {
+		switch v := v.(type) {
+		case string:
+			if _, err := strconv.ParseUint(v, 10, 64); err != nil {
+				return nil
+			}
+			return v
+		case int:
+			return strconv.Itoa(v)
+		case float64:
+			if v < 0 || v != float64(uint64(v)) {
+				return nil
+			}
+			return strconv.FormatUint(uint64(v), 10)
+		default:
+			return nil
+		}
+	},
+	ParseLiteral: func(v ast.Value) any {
+		switch v := v.(type) {
+		case *ast.IntValue:
+			return v.Value
+		case *ast.StringValue:
+			if _, err := strconv.ParseUint(v.Value, 10, 64); err != nil {
+				return nil
+			}
+			return v.Value
+		default:
+			return nil
+		}
+	},
+})
+
+var order = graphql.NewEnum(graphql.EnumConfig{
+	Name: "Order",
+	Values: graphql.EnumValueConfigMap{
+		"asc":  &graphql.EnumValueConfig{Value: "asc"},
+		"desc": &graphql.EnumValueConfig{Value: "desc"},
+	},
+})
+
+var validName = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+func name(s string) bool {
+	return validName.MatchString(s) && !strings.HasPrefix(s, "__")
+}
+
+// Returns an error when there are no tables since
+// a GraphQL schema requires at least one query field.
+// Tables and columns whose names are not valid
+// GraphQL names are skipped. Tables outside of the
+// public schema are named <schema>_<table>.
+//
+// Each table is queried using its connection in conns,
+// keyed by [wpg.Table.QName].
+func Schema(conns map[string]wpg.Conn, tables []wpg.Table) (graphql.Schema, error) {
+	fields := graphql.Fields{}
+	for _, t := range tables {
+		fname := t.Name
+		if len(t.Schema) > 0 {
+			fname = t.Schema + "_" + t.Name
+		}
+		if !name(fname) {
+			continue
+		}
+		q := query{pg: conns[t.QName()], table: wpg.QName(wpg.Quote(t.Schema), wpg.Quote(t.Name))}
+		rowFields := graphql.Fields{}
+		for _, c := range t.Columns {
+			if !name(c.Name) {
+				continue
+			}
+			rowFields[c.Name] = &graphql.Field{Type: scalar(c.Type)}
+			q.cols = append(q.cols, c)
+			if c.Name == "block_num" {
+				q.hasBlockNum = true
+			}
+		}
+		if len(q.cols) == 0 {
+			continue
+		}
+		args := graphql.FieldConfigArgument{
+			"limit": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: DefaultLimit,
+				Description:  fmt.Sprintf("max rows returned (at most %d)", MaxLimit),
+			},
+			"offset": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: 0,
+			},
+		}
+		if q.hasBlockNum {
+			args["block_num_gte"] = &graphql.ArgumentConfig{Type: BigInt}
+			args["block_num_lte"] = &graphql.ArgumentConfig{Type: BigInt}
+			args["order"] = &graphql.ArgumentConfig{
+				Type:         order,
+				DefaultValue: "asc",
+			}
+		}
+		fields[fname] = &graphql.Field{
+			Type: graphql.NewList(graphql.NewObject(graphql.ObjectConfig{
+				Name:   fname,
+				Fields: rowFields,
+			})),
+			Args:    args,
+			Resolve: q.resolve,
+		}
+	}
+	if len(fields) == 0 {
+		return graphql.Schema{}, fmt.Errorf("no tables to query")
+	}
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Query",
+			Fields: fields,
+		}),
+	})
+}
+
+func scalar(pgType string) graphql.Output {
+	switch strings.ToLower(pgType) {
+	case "bool", "boolean":
+		return graphql.Boolean
+	case "smallint", "int2", "int", "integer", "int4":
+		return graphql.Int
+	case "bigint", "int8", "numeric":
+		return BigInt
+	default:
+		return graphql.String
+	}
+}
+
+type query struct {
+	pg          wpg.Conn
+	table       string
+	cols        []wpg.Column
+	hasBlockNum bool
+}
+
+// Column values that aren't natively supported by GraphQL
+// are converted to text in the query so that the rows can
+// be returned as-is.
+func selectExpr(c wpg.Column) string {
+	col := wpg.Quote(c.Name)
+	switch strings.ToLower(c.Type) {
+	case "bytea":
+		return fmt.Sprintf("'0x' || encode(%s, 'hex') as %s", col, col)
+	case "bool", "boolean", "smallint", "int2", "int", "integer", "int4":
+		return col
+	default:
+		return fmt.Sprintf("%s::text as %s", col, col)
+	}
+}
+
+func (q query) sql(args map[string]any) (string, []any, error) {
+	limit, _ := args["limit"].(int)
+	offset, _ := args["offset"].(int)
+	switch {
+	case limit <= 0:
+		return "", nil, fmt.Errorf("limit must be positive")
+	case limit > MaxLimit:
+		return "", nil, fmt.Errorf("limit must be at most %d", MaxLimit)
+	case offset < 0:
+		return "", nil, fmt.Errorf("offset must not be negative")
+	case offset > MaxOffset:
+		return "", nil, fmt.Errorf("offset must be at most %d. use block_num_gte to page", MaxOffset)
+	}
+	var (
+		exprs  []string
+		where  []string
+		params []any
+	)
+	for _, c := range q.cols {
+		exprs = append(exprs, selectExpr(c))
+	}
+	if v, ok := args["block_num_gte"].(string); ok {
+		params = append(params, v)
+		where = append(where, fmt.Sprintf("block_num >= $%d::numeric", len(params)))
+	}
+	if v, ok := args["block_num_lte"].(string); ok {
+		params = append(params, v)
+		where = append(where, fmt.Sprintf("block_num <= $%d::numeric", len(params)))
+	}
+	var s strings.Builder
+	fmt.Fprintf(&s, "select %s from %s", strings.Join(exprs, ", "), q.table)
+	if len(where) > 0 {
+		fmt.Fprintf(&s, " where %s", strings.Join(where, " and "))
+	}
+	if q.hasBlockNum {
+		dir, _ := args["order"].(string)
+		if dir != "desc" {
+			dir = "asc"
+		}
+		fmt.Fprintf(&s, " order by block_num %s", dir)
+	}
+	params = append(params, limit, offset)
+	fmt.Fprintf(&s, " limit $%d offset $%d", len(params)-1, len(params))
+	return s.String(), params, nil
+}
+
+func (q query) resolve(p graphql.ResolveParams) (any, error) {
+	query, params, err := q.sql(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	ctx := p.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	rows, err := q.pg.Query(ctx, query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("querying %s: %w", q.table, err)
+	}
+	res, err := pgx.CollectRows(rows, pgx.RowToMap)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", q.table, err)
+	}
+	return res, nil
+}
+
+// Rejects queries that select more than MaxTables tables
+// (aliases of a table count separately), more than MaxFields
+// fields, or fields nested deeper than MaxDepth. Fragments
+// are counted where they're spread. MaxDepth allows the
+// standard introspection query.
+func Check(doc string) error {
+	d, err := parser.Parse(parser.ParseParams{Source: doc})
+	if err != nil {
+		return err
+	}
+	c := checker{frags: map[string]*ast.FragmentDefinition{}}
+	for _, def := range d.Definitions {
+		if f, ok := def.(*ast.FragmentDefinition); ok {
+			c.frags[f.Name.Value] = f
+		}
+	}
+	for _, def := range d.Definitions {
+		if op, ok := def.(*ast.OperationDefinition); ok {
+			if err := c.walk(op.SelectionSet, 1, map[string]bool{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type checker struct {
+	frags  map[string]*ast.FragmentDefinition
+	tables int
+	fields int
+}
+
+func (c *checker) walk(ss *ast.SelectionSet, depth int, path map[string]bool) error {
+	if ss == nil {
+		return nil
+	}
+	for _, sel := range ss.Selections {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			c.fields++
+			switch {
+			case depth > MaxDepth:
+				return fmt.Errorf("query is deeper than %d", MaxDepth)
+			case c.fields > MaxFields:
+				return fmt.Errorf("query has more than %d fields", MaxFields)
+			}
+			if depth == 1 && !strings.HasPrefix(sel.Name.Value, "__") {
+				c.tables++
+				if c.tables > MaxTables {
+					return fmt.Errorf("query selects more than %d tables", MaxTables)
+				}
+			}
+			if err := c.walk(sel.SelectionSet, depth+1, path); err != nil {
+				return err
+			}
+		case *ast.InlineFragment:
+			if err := c.walk(sel.SelectionSet, depth, path); err != nil {
+				return err
+			}
+		case *ast.FragmentSpread:
+			name := sel.Name.Value
+			f, ok := c.frags[name]
+			if !ok || path[name] {
+				// reported by graphql's validation
+				continue
+			}
+			path[name] = true
+			err := c.walk(f.SelectionSet, depth, path)
+			delete(path, name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package gql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/indexsupply/shovel/wpg"
+
+	"github.com/graphql-go/graphql"
+	"kr.dev/diff"
+)
+
+var transfers = wpg.Table{
+	Name: "transfers",
+	Columns: []wpg.Column{
+		{Name: "block_num", Type: "numeric"},
+		{Name: "tx_idx", Type: "int"},
+		{Name: "from", Type: "bytea"},
+		{Name: "value", Type: "numeric"},
+		{Name: "bad-name", Type: "text"},
+	},
+}
+
+func TestSQL(t *testing.T) {
+	q := query{
+		table:       "transfers",
+		cols:        transfers.Columns[:3],
+		hasBlockNum: true,
+	}
+	cases := []struct {
+		args   map[string]any
+		query  string
+		params []any
+		err    string
+	}{
+		{
+			args:   map[string]any{"limit": 10, "offset": 0},
+			query:  `select block_num::text as block_num, tx_idx, '0x' || encode("from", 'hex') as "from" from transfers order by block_num asc limit $1 offset $2`,
+			params: []any{10, 0},
+		},
+		{
+			args: map[string]any{
+				"limit":         10,
+				"offset":        20,
+				"block_num_gte": "100",
+				"block_num_lte": "200",
+				"order":         "desc",
+			},
+			query:  `select block_num::text as block_num, tx_idx, '0x' || encode("from", 'hex') as "from" from transfers where block_num >= $1::numeric and block_num <= $2::numeric order by block_num desc limit $3 offset $4`,
+			params: []any{"100", "200", 10, 20},
+		},
+		{
+			args: map[string]any{"limit": MaxLimit + 1},
+			err:  "limit must be at most 1000",
+		},
+		{
+			args: map[string]any{"limit": 1, "offset": -1},
+			err:  "offset must not be negative",
+		},
+		{
+			args: map[string]any{"limit": 1, "offset": MaxOffset + 1},
+			err:  "offset must be at most 10000. use block_num_gte to page",
+		},
+	}
+	for _, tc := range cases {
+		query, params, err := q.sql(tc.args)
+		if tc.err != "" {
+			diff.Test(t, t.Errorf, err.Error(), tc.err)
+			continue
+		}
+		diff.Test(t, t.Fatalf, err, nil)
+		diff.Test(t, t.Errorf, query, tc.query)
+		diff.Test(t, t.Errorf, params, tc.params)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	var (
+		aliases strings.Builder
+		fields  strings.Builder
+		nested  = "{ transfers { block_num } }"
+	)
+	aliases.WriteString("{")
+	for i := 0; i <= MaxTables; i++ {
+		fmt.Fprintf(&aliases, " t%d: transfers { block_num }", i)
+	}
+	aliases.WriteString(" }")
+	fields.WriteString("{ transfers {")
+	for i := 0; i < MaxFields; i++ {
+		fmt.Fprintf(&fields, " b%d: block_num", i)
+	}
+	fields.WriteString(" } }")
+	for i := 0; i < MaxDepth; i++ {
+		nested = "{ __schema " + nested + " }"
+	}
+	cases := []struct {
+		doc string
+		err string
+	}{
+		{"{ transfers { block_num } }", ""},
+		{"{ a: transfers { block_num } b: transfers { block_num } __typename }", ""},
+		{aliases.String(), "query selects more than 10 tables"},
+		{nested, "query is deeper than 12"},
+		{fields.String(), "query has more than 500 fields"},
+		{"query { ...f } fragment f on Query { transfers { ...f } }", ""},
+		{"{ transfers {", `Syntax Error GraphQL (1:14) Expected Name, found EOF`},
+	}
+	for _, tc := range cases {
+		err := Check(tc.doc)
+		if tc.err == "" {
+			diff.Test(t, t.Errorf, err, nil)
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+			t.Errorf("%s: got: %v want: %s", tc.doc, err, tc.err)
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+	_, err := Schema(nil, nil)
+	diff.Test(t, t.Errorf, err.Error(), "no tables to query")
+
+	schema, err := Schema(nil, []wpg.Table{transfers})
+	diff.Test(t, t.Fatalf, err, nil)
+
+	field := schema.QueryType().Fields()["transfers"]
+	if field == nil {
+		t.Fatal("missing transfers field")
+	}
+	var args []string
+	for _, a := range field.Args {
+		args = append(args, a.Name())
+	}
+	diff.Test(t, t.Errorf, len(args), 5)
+
+	row := field.Type.(*graphql.List).OfType.(*graphql.Object).Fields()
+	diff.Test(t, t.Errorf, len(row), 4)
+	diff.Test(t, t.Errorf, row["tx_idx"].Type, graphql.Output(graphql.Int))
+	diff.Test(t, t.Errorf, row["value"].Type, graphql.Output(BigInt))
+	diff.Test(t, t.Errorf, row["from"].Type, graphql.Output(graphql.String))
+
+	res := graphql.Do(graphql.Params{
+		Schema:        schema,
+		RequestString: `{ transfers(limit: 5000) { block_num } }`,
+	})
+	if len(res.Errors) != 1 {
+		t.Fatalf("expected limit error. got: %v", res.Errors)
+	}
+	diff.Test(t, t.Errorf, res.Errors[0].Message, "limit must be at most 1000")
+}
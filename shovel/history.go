@@ -0,0 +1,97 @@
+package shovel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/indexsupply/shovel/wpg"
+)
+
+// Snapshots older than this are deleted by [SnapshotProgress]
+const historyRetention = 7 * 24 * time.Hour
+
+// Copies each task's latest progress into shovel.metrics so
+// that the dashboard can chart progress over time. Backfill
+// tasks are excluded. Called periodically by main.
+func SnapshotProgress(ctx context.Context, pg wpg.Conn) error {
+	const iq = `
+		insert into shovel.metrics (src_name, ig_name, num, src_num)
+		select distinct on (src_name, ig_name) src_name, ig_name, num, src_num
+		from shovel.task_updates
+		where ig_name not like '%/backfill/%'
+		order by src_name, ig_name, num desc
+	`
+	if _, err := pg.Exec(ctx, iq); err != nil {
+		return fmt.Errorf("inserting snapshot: %w", err)
+	}
+	const dq = `delete from shovel.metrics where created_at < $1`
+	if _, err := pg.Exec(ctx, dq, time.Now().Add(-historyRetention)); err != nil {
+		return fmt.Errorf("pruning snapshots: %w", err)
+	}
+	return nil
+}
+
+// A source's progress during a bucket of [History].
+// Num is the highest block indexed by any of the source's
+// integrations and Lag is the lag of the slowest one.
+type ProgressPoint struct {
+	Time time.Time
+	Num  uint64
+	Lag  uint64
+
+	// Blocks indexed per minute since the previous point.
+	// Zero for the first point.
+	BlocksPerMin float64
+}
+
+// Progress of each source since the given time, grouped
+// into buckets of the given width.
+func History(ctx context.Context, pg wpg.Conn, since time.Time, bucket time.Duration) (map[string][]ProgressPoint, error) {
+	const q = `
+		select
+			src_name,
+			to_timestamp(floor(extract(epoch from created_at) / $2) * $2) t,
+			max(num)::bigint,
+			greatest(max(coalesce(src_num, num) - num), 0)::bigint
+		from shovel.metrics
+		where created_at >= $1
+		group by 1, 2
+		order by 1, 2
+	`
+	rows, err := pg.Query(ctx, q, since, int64(bucket.Seconds()))
+	if err != nil {
+		return nil, fmt.Errorf("querying metrics: %w", err)
+	}
+	defer rows.Close()
+	res := map[string][]ProgressPoint{}
+	for rows.Next() {
+		var (
+			src string
+			p   ProgressPoint
+		)
+		if err := rows.Scan(&src, &p.Time, &p.Num, &p.Lag); err != nil {
+			return nil, fmt.Errorf("scanning metrics: %w", err)
+		}
+		res[src] = append(res[src], p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("querying metrics: %w", err)
+	}
+	for _, points := range res {
+		rates(points)
+	}
+	return res, nil
+}
+
+func rates(points []ProgressPoint) {
+	for i := 1; i < len(points); i++ {
+		var (
+			prev = points[i-1]
+			mins = points[i].Time.Sub(prev.Time).Minutes()
+		)
+		if mins > 0 && points[i].Num > prev.Num {
+			points[i].BlocksPerMin = float64(points[i].Num-prev.Num) / mins
+		}
+	}
+}
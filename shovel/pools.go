@@ -0,0 +1,201 @@
+package shovel
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/indexsupply/shovel/shovel/config"
+	"github.com/indexsupply/shovel/wos"
+	"github.com/indexsupply/shovel/wpg"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Connection pools keyed by [config.Database] name. The
+// empty name is the main database (pg_url) which holds
+// sources and integrations added using the dashboard.
+type Pools map[string]*pgxpool.Pool
+
+// Returns a copy of p with a pool for each of the config's
+// databases that p doesn't already have.
+func (p Pools) Open(ctx context.Context, conf config.Root) (Pools, error) {
+	res := Pools{}
+	for name, pg := range p {
+		res[name] = pg
+	}
+	for _, db := range conf.Databases {
+		if _, ok := res[db.Name]; ok {
+			continue
+		}
+		url := db.URL
+		if !wos.IsSecret(url) {
+			url = wos.Getenv(url)
+		}
+		pg, err := wpg.NewPool(ctx, url)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to %s: %w", db.Name, err)
+		}
+		res[db.Name] = pg
+	}
+	return res, nil
+}
+
+func (p Pools) Main() *pgxpool.Pool {
+	return p[""]
+}
+
+// Pool for the integration's database
+func (p Pools) For(ig config.Integration) *pgxpool.Pool {
+	if pg, ok := p[ig.Database]; ok {
+		return pg
+	}
+	return p.Main()
+}
+
+// Closes and removes the pools of databases that conf
+// doesn't have. The main pool is kept.
+func (p Pools) Prune(conf config.Root) {
+	for name, pg := range p {
+		if name == "" {
+			continue
+		}
+		keep := slices.ContainsFunc(conf.Databases, func(db config.Database) bool {
+			return db.Name == name
+		})
+		if !keep {
+			pg.Close()
+			delete(p, name)
+		}
+	}
+}
+
+// Each pool once, main first. Integrations that use another
+// database record their progress in that database so readers
+// of task_updates and metrics query every pool.
+func (p Pools) All() []*pgxpool.Pool {
+	var names []string
+	for name := range p {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var res []*pgxpool.Pool
+	for _, name := range names {
+		if !slices.Contains(res, p[name]) {
+			res = append(res, p[name])
+		}
+	}
+	return res
+}
+
+// [SourceUpdates] from every database. When more than one
+// database has updates for a source, the latest is used.
+func (p Pools) SourceUpdates(ctx context.Context) ([]SrcUpdate, error) {
+	var (
+		res   []SrcUpdate
+		index = map[string]int{}
+	)
+	for _, pg := range p.All() {
+		sus, err := SourceUpdates(ctx, pg)
+		if err != nil {
+			return nil, err
+		}
+		for _, su := range sus {
+			i, ok := index[su.Name]
+			switch {
+			case !ok:
+				index[su.Name] = len(res)
+				res = append(res, su)
+			case su.Num.Int64 > res[i].Num.Int64:
+				res[i] = su
+			}
+		}
+	}
+	return res, nil
+}
+
+// [TaskUpdates] from every database. When more than one
+// database has updates for a task, the latest is used.
+func (p Pools) TaskUpdates(ctx context.Context) ([]TaskUpdate, error) {
+	var (
+		res   []TaskUpdate
+		index = map[string]int{}
+	)
+	for _, pg := range p.All() {
+		tus, err := TaskUpdates(ctx, pg)
+		if err != nil {
+			return nil, err
+		}
+		for _, tu := range tus {
+			i, ok := index[tu.DOMID]
+			switch {
+			case !ok:
+				index[tu.DOMID] = len(res)
+				res = append(res, tu)
+			case tu.Num > res[i].Num:
+				res[i] = tu
+			}
+		}
+	}
+	return res, nil
+}
+
+// [History] from every database. Points in the same bucket
+// are combined using the furthest progress.
+func (p Pools) History(ctx context.Context, since time.Time, bucket time.Duration) (map[string][]ProgressPoint, error) {
+	res := map[string][]ProgressPoint{}
+	for _, pg := range p.All() {
+		h, err := History(ctx, pg, since, bucket)
+		if err != nil {
+			return nil, err
+		}
+		for src, points := range h {
+			res[src] = mergePoints(res[src], points)
+		}
+	}
+	return res, nil
+}
+
+func mergePoints(a, b []ProgressPoint) []ProgressPoint {
+	if len(a) == 0 {
+		return b
+	}
+	byTime := map[time.Time]ProgressPoint{}
+	for _, pt := range append(a, b...) {
+		pt.BlocksPerMin = 0
+		prev, ok := byTime[pt.Time]
+		if ok {
+			pt.Num = max(pt.Num, prev.Num)
+			pt.Lag = max(pt.Lag, prev.Lag)
+		}
+		byTime[pt.Time] = pt
+	}
+	var res []ProgressPoint
+	for _, pt := range byTime {
+		res = append(res, pt)
+	}
+	slices.SortFunc(res, func(x, y ProgressPoint) int {
+		return x.Time.Compare(y.Time)
+	})
+	rates(res)
+	return res
+}
+
+// Latest block indexed for the source in any database
+func (p Pools) Latest(ctx context.Context, srcName string) (uint64, error) {
+	const q = `
+		select coalesce(max(num), 0)
+		from shovel.task_updates
+		where src_name = $1
+	`
+	var res uint64
+	for _, pg := range p.All() {
+		var n uint64
+		if err := pg.QueryRow(ctx, q, srcName).Scan(&n); err != nil {
+			return 0, err
+		}
+		res = max(res, n)
+	}
+	return res, nil
+}
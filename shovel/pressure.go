@@ -0,0 +1,101 @@
+package shovel
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Limits the number of converges writing to a database at a
+// time so that blocks aren't loaded faster than the database
+// can insert them. Loaded blocks are held in memory until
+// they're inserted, so without a limit a database that falls
+// behind (eg during a backfill) causes memory to grow until
+// the process runs out.
+//
+// The limit starts at the pool's max connections and is
+// adjusted after each insert using additive increase and
+// multiplicative decrease: an insert (including the wait for
+// a connection) slower than maxLatency halves the limit and
+// a faster one raises it by one.
+type pressure struct {
+	mut        sync.Mutex
+	maxLatency time.Duration
+	maxLimit   int
+	limit      int
+	inflight   int
+}
+
+func newPressure(maxLatency time.Duration, maxLimit int) *pressure {
+	maxLimit = max(1, maxLimit)
+	return &pressure{
+		maxLatency: maxLatency,
+		maxLimit:   maxLimit,
+		limit:      maxLimit,
+	}
+}
+
+func (p *pressure) tryAcquire() bool {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.inflight >= p.limit {
+		return false
+	}
+	p.inflight++
+	return true
+}
+
+// Waits until the database has room for another converge.
+// Callers must call release once the converge is finished.
+func (p *pressure) acquire(ctx context.Context) error {
+	for !p.tryAcquire() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// Zero insert means the converge didn't insert
+// and the limit isn't adjusted.
+func (p *pressure) release(insert time.Duration) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.inflight--
+	switch {
+	case insert == 0:
+	case insert > p.maxLatency:
+		p.limit = max(1, p.limit/2)
+	default:
+		p.limit = min(p.maxLimit, p.limit+1)
+	}
+}
+
+// Reports whether recent inserts were slower than maxLatency
+// in which case tasks don't grow their batches.
+func (p *pressure) high() bool {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.limit < p.maxLimit
+}
+
+var (
+	pressuresMut sync.Mutex
+	pressures    = map[*pgxpool.Pool]*pressure{}
+)
+
+// Tasks writing to the same pool share its pressure
+func poolPressure(pg *pgxpool.Pool, maxLatency time.Duration) *pressure {
+	pressuresMut.Lock()
+	defer pressuresMut.Unlock()
+	p, ok := pressures[pg]
+	if !ok {
+		p = newPressure(maxLatency, int(pg.Config().MaxConns))
+		pressures[pg] = p
+	}
+	return p
+}
@@ -0,0 +1,102 @@
+package shovel
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/indexsupply/shovel/shovel/config"
+	"github.com/indexsupply/shovel/wpg"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Calls f with batches of at most fetch rows of the
+// integration's rows with block_num in [start, stop], in
+// block order. A zero stop has no limit and an empty src
+// reads every source. Rows are read from a server-side
+// cursor in a read only transaction so that large ranges
+// aren't held in memory and every batch is from the same
+// snapshot.
+func ReadRows(
+	ctx context.Context,
+	pg *pgxpool.Pool,
+	ig config.Integration,
+	src string,
+	start, stop uint64,
+	fetch int,
+	f func([]wpg.Column, [][]any) error,
+) error {
+	var (
+		cols  = ig.Table.Columns
+		names = make([]string, len(cols))
+		args  = []any{ig.Name, start}
+		q     strings.Builder
+	)
+	for i := range cols {
+		names[i] = pgx.Identifier{cols[i].Name}.Sanitize()
+	}
+	fmt.Fprintf(&q, "select %s from %s where ig_name = $1 and block_num >= $2",
+		strings.Join(names, ", "),
+		ig.Table.QName(),
+	)
+	if stop > 0 {
+		args = append(args, stop)
+		fmt.Fprintf(&q, " and block_num <= $%d", len(args))
+	}
+	if len(src) > 0 {
+		args = append(args, src)
+		fmt.Fprintf(&q, " and src_name = $%d", len(args))
+	}
+	q.WriteString(" order by block_num")
+
+	tx, err := pg.BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	})
+	if err != nil {
+		return fmt.Errorf("starting tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "declare shovel_rows no scroll cursor for "+q.String(), args...); err != nil {
+		return fmt.Errorf("declaring cursor: %w", err)
+	}
+	for {
+		rows, err := tx.Query(ctx, fmt.Sprintf("fetch forward %d from shovel_rows", fetch))
+		if err != nil {
+			return fmt.Errorf("fetching: %w", err)
+		}
+		batch, err := pgx.CollectRows(rows, func(r pgx.CollectableRow) ([]any, error) {
+			return r.Values()
+		})
+		if err != nil {
+			return fmt.Errorf("fetching: %w", err)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := f(cols, batch); err != nil {
+			return err
+		}
+	}
+}
+
+// Returns the highest block_num of the integration's rows.
+// An empty src reads every source. Returns 0 when there
+// are no rows.
+func MaxBlock(ctx context.Context, pg *pgxpool.Pool, ig config.Integration, src string) (uint64, error) {
+	var (
+		args = []any{ig.Name}
+		q    = fmt.Sprintf("select coalesce(max(block_num), 0) from %s where ig_name = $1", ig.Table.QName())
+		n    uint64
+	)
+	if len(src) > 0 {
+		args = append(args, src)
+		q += " and src_name = $2"
+	}
+	if err := pg.QueryRow(ctx, q, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("querying max block: %w", err)
+	}
+	return n, nil
+}
@@ -55,6 +55,9 @@ add column if not exists chain_id int;
 alter table shovel.task_updates
 add column if not exists ig_name text;
 
+alter table shovel.task_updates
+add column if not exists skip_reason text;
+
 drop index if exists shovel.task_src_name_num_idx;
 drop index if exists shovel.task_src_name_num_idx1;
 
@@ -130,3 +133,81 @@ with abs_latest as (
 	group by shovel.task_updates.src_name, shovel.task_updates.ig_name
 )
 select src_name, min(num) num from src_latest group by 1;
+
+create table if not exists shovel.block_cache (
+	chain_id int not null,
+	kind text not null,
+	num numeric not null,
+	data bytea not null,
+	primary key (chain_id, kind, num)
+);
+
+create table if not exists shovel.dead_letters (
+	src_name text not null,
+	ig_name text not null,
+	block_num numeric not null,
+	tx_hash bytea,
+	log_idx int,
+	log_addr bytea,
+	topics bytea[],
+	data bytea,
+	"row" jsonb,
+	error text not null,
+	created_at timestamptz not null default now()
+);
+create index if not exists dead_letters_block_num
+on shovel.dead_letters (src_name, ig_name, block_num);
+
+create table if not exists shovel.chain_discrepancies (
+	src_name text not null,
+	ig_name text not null,
+	num numeric not null,
+	hash bytea,
+	parent bytea,
+	expected bytea,
+	created_at timestamptz not null default now()
+);
+create index if not exists chain_discrepancies_num
+on shovel.chain_discrepancies (src_name, ig_name, num);
+
+create table if not exists shovel.exports (
+	src_name text not null,
+	ig_name text not null,
+	start numeric not null,
+	stop numeric not null,
+	key text,
+	nrows numeric not null,
+	created_at timestamptz not null default now(),
+	primary key (src_name, ig_name, start)
+);
+
+create table if not exists shovel.metrics (
+	src_name text not null,
+	ig_name text not null,
+	num numeric not null,
+	src_num numeric,
+	created_at timestamptz not null default now()
+);
+create index if not exists metrics_created_at
+on shovel.metrics (src_name, created_at);
+
+create table if not exists shovel.history (
+	src_name text not null,
+	ig_name text not null,
+	start numeric not null,
+	stop numeric not null,
+	created_at timestamptz not null default now(),
+	primary key (src_name, ig_name)
+);
+
+create table if not exists shovel.kafka_outbox (
+	id bigserial primary key,
+	ig_name text not null,
+	topic text not null,
+	key bytea,
+	value bytea,
+	event text,
+	created_at timestamptz not null default now()
+);
+create index if not exists kafka_outbox_topic
+on shovel.kafka_outbox (ig_name, topic, id);
@@ -0,0 +1,345 @@
+// Helpers for testing integrations against a local chain
+//
+// [StartAnvil] runs anvil (from foundry) as a child process.
+// Contracts are deployed and called using anvil's unlocked
+// accounts, and every transaction is mined in its own block.
+// [New] creates a database for a config whose integrations
+// read from the anvil node and [Shovel.Sync] indexes every
+// block mined so far:
+//
+//	func TestMain(m *testing.M) {
+//		shoveltest.Main(m)
+//	}
+//
+//	func TestTransfer(t *testing.T) {
+//		a := shoveltest.StartAnvil(t)
+//		addr := a.Deploy(t, bytecode)
+//		a.Send(t, addr, calldata)
+//		s := shoveltest.New(t, a, conf)
+//		s.Sync(t)
+//		s.Check(t, `select count(*) = 1 from transfers`)
+//	}
+//
+// [PG] creates a database for tests that insert rows
+// themselves:
+//
+//	func TestQuery(t *testing.T) {
+//		pg := shoveltest.PG(t, conf)
+//		...
+//	}
+//
+// Tests are skipped when anvil isn't on PATH or when
+// Postgres is unavailable (see [wpg.TestPG]).
+package shoveltest
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/indexsupply/shovel/eth"
+	"github.com/indexsupply/shovel/jrpc2"
+	"github.com/indexsupply/shovel/shovel"
+	"github.com/indexsupply/shovel/shovel/config"
+	"github.com/indexsupply/shovel/wctx"
+	"github.com/indexsupply/shovel/wpg"
+
+	"blake.io/pqx/pqxtest"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// Anvil's default chain id
+const ChainID = 31337
+
+// Source name used for the anvil node
+const SrcName = "anvil"
+
+// Must be called by the test binary's TestMain
+// so that [New] can create databases.
+func Main(m *testing.M) {
+	sql.Register("postgres", stdlib.GetDefaultDriver())
+	pqxtest.TestMain(m)
+}
+
+type Anvil struct {
+	URL string
+
+	// anvil's unlocked accounts
+	Accounts [][]byte
+
+	hc *http.Client
+}
+
+// Starts anvil on a free port and stops it when the test
+// finishes. The test is skipped when anvil isn't on PATH.
+func StartAnvil(tb testing.TB) *Anvil {
+	tb.Helper()
+	if _, err := exec.LookPath("anvil"); err != nil {
+		tb.Skip("anvil not found")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	var out bytes.Buffer
+	cmd := exec.Command("anvil",
+		"--host", "127.0.0.1",
+		"--port", strconv.Itoa(port),
+		"--chain-id", strconv.Itoa(ChainID),
+	)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Start(); err != nil {
+		tb.Fatalf("starting anvil: %s", err)
+	}
+	tb.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	a := &Anvil{
+		URL: fmt.Sprintf("http://127.0.0.1:%d", port),
+		hc:  &http.Client{Timeout: 10 * time.Second},
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var accounts []string
+		err := a.call("eth_accounts", &accounts)
+		if err == nil {
+			for _, s := range accounts {
+				a.Accounts = append(a.Accounts, eth.DecodeHex(s))
+			}
+			return a
+		}
+		if time.Now().After(deadline) {
+			tb.Fatalf("waiting for anvil: %s: %s", err, out.String())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (a *Anvil) call(method string, dest any, params ...any) error {
+	if params == nil {
+		params = []any{}
+	}
+	body, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := a.hc.Post(a.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("decoding %s response: %w", method, err)
+	}
+	if res.Error != nil {
+		return fmt.Errorf("%s: %d %s", method, res.Error.Code, res.Error.Message)
+	}
+	if dest == nil {
+		return nil
+	}
+	return json.Unmarshal(res.Result, dest)
+}
+
+// Calls an anvil JSON RPC method
+func (a *Anvil) Call(tb testing.TB, method string, dest any, params ...any) {
+	tb.Helper()
+	if err := a.call(method, dest, params...); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+type Receipt struct {
+	TxHash          eth.Bytes  `json:"transactionHash"`
+	BlockNum        eth.Uint64 `json:"blockNumber"`
+	Status          eth.Uint64 `json:"status"`
+	ContractAddress eth.Bytes  `json:"contractAddress"`
+}
+
+// Sends a transaction from the first account and returns its
+// receipt once it's mined. A nil to creates a contract.
+// Fails the test when the transaction reverts.
+func (a *Anvil) Send(tb testing.TB, to, data []byte) Receipt {
+	tb.Helper()
+	tx := map[string]any{
+		"from": eth.EncodeHex(a.Accounts[0]),
+		"data": eth.EncodeHex(data),
+	}
+	if to != nil {
+		tx["to"] = eth.EncodeHex(to)
+	}
+	var hash string
+	a.Call(tb, "eth_sendTransaction", &hash, tx)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var r *Receipt
+		a.Call(tb, "eth_getTransactionReceipt", &r, hash)
+		if r != nil {
+			if r.Status != 1 {
+				tb.Fatalf("tx %s reverted", hash)
+			}
+			return *r
+		}
+		if time.Now().After(deadline) {
+			tb.Fatalf("waiting for receipt of %s", hash)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Deploys the contract's creation code and
+// returns the contract's address
+func (a *Anvil) Deploy(tb testing.TB, code []byte) []byte {
+	tb.Helper()
+	return a.Send(tb, nil, code).ContractAddress
+}
+
+// Mines n empty blocks
+func (a *Anvil) Mine(tb testing.TB, n uint64) {
+	tb.Helper()
+	a.Call(tb, "anvil_mine", nil, "0x"+strconv.FormatUint(n, 16))
+}
+
+func (a *Anvil) BlockNum(tb testing.TB) uint64 {
+	tb.Helper()
+	var n eth.Uint64
+	a.Call(tb, "eth_blockNumber", &n)
+	return uint64(n)
+}
+
+type Shovel struct {
+	PG    *pgxpool.Pool
+	Conf  config.Root
+	anvil *Anvil
+}
+
+// Creates a database with shovel's schema and the config's
+// integration tables. The config's sources are replaced by
+// the anvil node and every integration reads from it.
+func New(tb testing.TB, a *Anvil, conf config.Root) *Shovel {
+	tb.Helper()
+	conf.Sources = []config.Source{{
+		Name:    SrcName,
+		ChainID: ChainID,
+		URLs:    []string{a.URL},
+	}}
+	for i := range conf.Integrations {
+		conf.Integrations[i].Enabled = true
+		conf.Integrations[i].Sources = []config.Source{{Name: SrcName}}
+	}
+	pg := migrate(tb, &conf)
+	return &Shovel{PG: pg, Conf: conf, anvil: a}
+}
+
+// Creates a database with shovel's schema and the config's
+// integration tables for tests that don't need a chain, eg
+// tests of queries over an integration's table. The database
+// is created by the Postgres that [Main] starts so nothing
+// needs to be running beforehand, and it's dropped when the
+// test finishes.
+func PG(tb testing.TB, conf config.Root) *pgxpool.Pool {
+	tb.Helper()
+	return migrate(tb, &conf)
+}
+
+func migrate(tb testing.TB, conf *config.Root) *pgxpool.Pool {
+	tb.Helper()
+	if err := config.ValidateFix(conf); err != nil {
+		tb.Fatalf("validating config: %s", err)
+	}
+	pg := wpg.TestPG(tb, shovel.Schema)
+	tb.Cleanup(pg.Close)
+	if err := config.Migrate(context.Background(), pg, *conf); err != nil {
+		tb.Fatalf("migrating: %s", err)
+	}
+	return pg
+}
+
+// Indexes every integration up to anvil's latest block
+func (s *Shovel) Sync(tb testing.TB) {
+	tb.Helper()
+	head := s.anvil.BlockNum(tb)
+	if head == 0 {
+		return
+	}
+	ctx := wctx.WithChainID(context.Background(), ChainID)
+	ctx = wctx.WithSrcName(ctx, SrcName)
+	for _, ig := range s.Conf.Integrations {
+		task, err := shovel.NewTask(
+			shovel.WithContext(wctx.WithIGName(ctx, ig.Name)),
+			shovel.WithPG(s.PG),
+			shovel.WithSrcName(SrcName),
+			shovel.WithChainID(ChainID),
+			shovel.WithSource(jrpc2.New(s.anvil.URL)),
+			shovel.WithIntegration(ig),
+			shovel.WithRange(1, head),
+		)
+		if err != nil {
+			tb.Fatalf("creating task for %s: %s", ig.Name, err)
+		}
+		for {
+			err := task.Converge()
+			if errors.Is(err, shovel.ErrDone) {
+				break
+			}
+			if err != nil && !errors.Is(err, shovel.ErrNothingNew) {
+				tb.Fatalf("indexing %s: %s", ig.Name, err)
+			}
+		}
+	}
+}
+
+// Fails the test when a query doesn't return true
+func (s *Shovel) Check(tb testing.TB, queries ...string) {
+	tb.Helper()
+	for _, q := range queries {
+		var ok bool
+		if err := s.PG.QueryRow(context.Background(), q).Scan(&ok); err != nil {
+			tb.Fatalf("query %q: %s", q, err)
+		}
+		if !ok {
+			tb.Errorf("query failed: %q", q)
+		}
+	}
+}
+
+// Returns the number of rows matching the query's
+// where clause (which may be empty)
+func (s *Shovel) Count(tb testing.TB, table, where string, args ...any) int {
+	tb.Helper()
+	q := "select count(*) from " + table
+	if len(where) > 0 {
+		q += " where " + where
+	}
+	var n int
+	if err := s.PG.QueryRow(context.Background(), q, args...).Scan(&n); err != nil {
+		tb.Fatalf("query %q: %s", q, err)
+	}
+	return n
+}
@@ -0,0 +1,119 @@
+package shoveltest
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/indexsupply/shovel/eth"
+	"github.com/indexsupply/shovel/shovel/config"
+)
+
+func TestMain(m *testing.M) {
+	Main(m)
+}
+
+// Creation code for a contract that logs
+// Ping(uint256) with its calldata.
+func pingCode() []byte {
+	topic := eth.Keccak([]byte("Ping(uint256)"))
+	runtime := []byte{
+		0x36, 0x60, 0x00, 0x60, 0x00, 0x37, // calldatacopy(0, 0, calldatasize)
+		0x7f, // push32 topic
+	}
+	runtime = append(runtime, topic...)
+	runtime = append(runtime,
+		0x36, 0x60, 0x00, // calldatasize, 0
+		0xa1, // log1
+		0x00, // stop
+	)
+	n := byte(len(runtime))
+	init := []byte{
+		0x60, n, 0x60, 0x0c, 0x60, 0x00, 0x39, // codecopy(0, 12, n)
+		0x60, n, 0x60, 0x00, 0xf3, // return(0, n)
+	}
+	return append(init, runtime...)
+}
+
+func TestPing(t *testing.T) {
+	a := StartAnvil(t)
+	addr := a.Deploy(t, pingCode())
+	word := make([]byte, 32)
+	word[31] = 42
+	a.Send(t, addr, word)
+	a.Mine(t, 2)
+
+	var conf config.Root
+	err := json.Unmarshal([]byte(`{
+		"integrations": [{
+			"name": "ping",
+			"table": {"name": "ping", "columns": [
+				{"name": "log_addr", "type": "bytea"},
+				{"name": "n", "type": "numeric"}
+			]},
+			"block": [{"name": "log_addr", "column": "log_addr"}],
+			"event": {
+				"name": "Ping",
+				"type": "event",
+				"anonymous": false,
+				"inputs": [{"name": "n", "type": "uint256", "column": "n"}]
+			}
+		}]
+	}`), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(t, a, conf)
+	s.Sync(t)
+	s.Check(t, `select count(*) = 1 from ping where n = 42`)
+	if n := s.Count(t, "ping", "log_addr = $1", addr); n != 1 {
+		t.Errorf("got %d rows for %s", n, eth.EncodeHex(addr))
+	}
+
+	a.Send(t, addr, word)
+	s.Sync(t)
+	s.Check(t, `select count(*) = 2 from ping`)
+}
+
+func TestPG(t *testing.T) {
+	var conf config.Root
+	err := json.Unmarshal([]byte(`{
+		"integrations": [{
+			"name": "transfers",
+			"table": {"name": "transfers", "columns": [
+				{"name": "f", "type": "bytea"},
+				{"name": "v", "type": "numeric"}
+			]},
+			"block": [],
+			"event": {
+				"name": "Transfer",
+				"type": "event",
+				"anonymous": false,
+				"inputs": [
+					{"indexed": true, "name": "from", "type": "address", "column": "f"},
+					{"indexed": true, "name": "to", "type": "address"},
+					{"name": "value", "type": "uint256", "column": "v"}
+				]
+			}
+		}]
+	}`), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pg := PG(t, conf)
+	const q = `
+		insert into transfers (ig_name, src_name, block_num, tx_idx, f, v)
+		values ('transfers', 'test', 1, 0, '\x01', 42)
+	`
+	if _, err := pg.Exec(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	err = pg.QueryRow(context.Background(), `select count(*) from transfers where v = 42`).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d rows", n)
+	}
+}
@@ -0,0 +1,219 @@
+package sink
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/indexsupply/shovel/wpg"
+)
+
+// Rows are written using Avro's single object encoding:
+// a 2 byte marker, the schema's 64 bit Rabin fingerprint,
+// then the binary encoded record. Every field is nullable.
+// The schema is derived from the table's columns:
+//
+//	bool              -> boolean
+//	smallint/int/...  -> long
+//	bytea             -> bytes
+//	numeric           -> bytes with the decimal logical type
+//	everything else   -> string
+type avroSchema struct {
+	types       []string
+	json        []byte
+	fingerprint uint64
+}
+
+// uint256 and int256 values have at most 78 digits
+const avroDecimal = `{"type":"bytes","logicalType":"decimal","precision":78,"scale":0}`
+
+func avroType(pgType string) string {
+	switch strings.ToLower(pgType) {
+	case "bool", "boolean":
+		return "boolean"
+	case "smallint", "int", "int2", "int4", "int8", "integer", "bigint":
+		return "long"
+	case "bytea":
+		return "bytes"
+	case "numeric":
+		return "decimal"
+	default:
+		return "string"
+	}
+}
+
+// The fingerprint is computed over the schema's parsing
+// canonical form, which drops logical type attributes, so
+// that it matches what a consumer computes.
+func newAvroSchema(name string, cols []wpg.Column) *avroSchema {
+	s := &avroSchema{}
+	var b, pcf strings.Builder
+	fmt.Fprintf(&b, `{"name":%s,"type":"record","fields":[`, strconv.Quote(avroName(name)))
+	fmt.Fprintf(&pcf, `{"name":%s,"type":"record","fields":[`, strconv.Quote(avroName(name)))
+	for i, c := range cols {
+		t := avroType(c.Type)
+		s.types = append(s.types, t)
+		if i > 0 {
+			b.WriteByte(',')
+			pcf.WriteByte(',')
+		}
+		switch t {
+		case "decimal":
+			fmt.Fprintf(&b, `{"name":%s,"type":["null",%s]}`, strconv.Quote(c.Name), avroDecimal)
+			fmt.Fprintf(&pcf, `{"name":%s,"type":["null","bytes"]}`, strconv.Quote(c.Name))
+		default:
+			fmt.Fprintf(&b, `{"name":%s,"type":["null","%s"]}`, strconv.Quote(c.Name), t)
+			fmt.Fprintf(&pcf, `{"name":%s,"type":["null","%s"]}`, strconv.Quote(c.Name), t)
+		}
+	}
+	b.WriteString("]}")
+	pcf.WriteString("]}")
+	s.json = []byte(b.String())
+	s.fingerprint = rabin([]byte(pcf.String()))
+	return s
+}
+
+// Avro names may only contain [A-Za-z0-9_]
+func avroName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+func (s *avroSchema) encode(row []any) ([]byte, error) {
+	buf := []byte{0xc3, 0x01}
+	buf = binary.LittleEndian.AppendUint64(buf, s.fingerprint)
+	for i, t := range s.types {
+		v, err := Value(row[i])
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			buf = binary.AppendVarint(buf, 0)
+			continue
+		}
+		buf = binary.AppendVarint(buf, 1)
+		switch t {
+		case "boolean":
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("expected bool got %T", v)
+			}
+			if b {
+				buf = append(buf, 1)
+			} else {
+				buf = append(buf, 0)
+			}
+		case "long":
+			n, err := long(v)
+			if err != nil {
+				return nil, err
+			}
+			buf = binary.AppendVarint(buf, n)
+		case "bytes":
+			b, err := avroBytes(v)
+			if err != nil {
+				return nil, err
+			}
+			buf = binary.AppendVarint(buf, int64(len(b)))
+			buf = append(buf, b...)
+		case "decimal":
+			n, ok := new(big.Int).SetString(Text(v), 10)
+			if !ok {
+				return nil, fmt.Errorf("expected decimal got %T", v)
+			}
+			b := twos(n)
+			buf = binary.AppendVarint(buf, int64(len(b)))
+			buf = append(buf, b...)
+		default:
+			str := Text(v)
+			buf = binary.AppendVarint(buf, int64(len(str)))
+			buf = append(buf, str...)
+		}
+	}
+	return buf, nil
+}
+
+// Value hex encodes bytes for JSON
+func avroBytes(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case string:
+		if !strings.HasPrefix(v, "0x") {
+			return []byte(v), nil
+		}
+		b, err := hex.DecodeString(v[2:])
+		if err != nil {
+			return nil, fmt.Errorf("decoding bytes: %w", err)
+		}
+		return b, nil
+	default:
+		return []byte(Text(v)), nil
+	}
+}
+
+// Integer columns can't hold values past MaxInt64. A
+// value that doesn't fit is an error rather than wrapping.
+func long(v any) (int64, error) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, fmt.Errorf("%d overflows long", v)
+		}
+		return int64(v), nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	default:
+		return 0, fmt.Errorf("expected integer got %T", v)
+	}
+}
+
+// Big-endian two's complement, as used by the decimal
+// logical type, in the fewest bytes
+func twos(n *big.Int) []byte {
+	if n.Sign() >= 0 {
+		b := n.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		return b
+	}
+	size := (new(big.Int).Not(n).BitLen())/8 + 1
+	m := new(big.Int).Lsh(big.NewInt(1), uint(size*8))
+	return m.Add(m, n).Bytes()
+}
+
+const rabinEmpty = 0xc15d213aa4d7a795
+
+var rabinTable = func() [256]uint64 {
+	var t [256]uint64
+	for i := range t {
+		fp := uint64(i)
+		for j := 0; j < 8; j++ {
+			fp = (fp >> 1) ^ (rabinEmpty & -(fp & 1))
+		}
+		t[i] = fp
+	}
+	return t
+}()
+
+// CRC-64-AVRO as defined in the Avro specification
+func rabin(b []byte) uint64 {
+	fp := uint64(rabinEmpty)
+	for _, c := range b {
+		fp = (fp >> 8) ^ rabinTable[byte(fp)^c]
+	}
+	return fp
+}
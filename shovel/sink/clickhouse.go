@@ -0,0 +1,343 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indexsupply/shovel/wctx"
+	"github.com/indexsupply/shovel/wos"
+	"github.com/indexsupply/shovel/wpg"
+)
+
+// Rows are inserted using ClickHouse's HTTP interface into
+// a ReplacingMergeTree table that is created when it doesn't
+// exist. The table is ordered by the integration's unique
+// columns and has two additional columns: _version (the
+// insert time in nanoseconds) and _deleted.
+//
+// Like Kafka, rows are buffered and inserted after the pg
+// transaction commits (see [dig.Flusher]) in the background
+// (see [queue]). A batch that is retried replaces the rows
+// that were inserted by the failed attempt. When blocks are
+// deleted during a reorg, rows for those blocks are replaced
+// with _deleted = 1 rows before the rows that replace them
+// are inserted. Query using FINAL (or wait for merges) to
+// exclude replaced and deleted rows.
+//
+// The native protocol isn't used so that the sink doesn't
+// require a ClickHouse client library.
+type ClickHouse struct {
+	// eg http://localhost:8123
+	URL      wos.EnvString `json:"url"`
+	User     wos.EnvString `json:"user"`
+	Password wos.EnvString `json:"password"`
+
+	// Defaults to default
+	Database string `json:"database"`
+
+	// Defaults to the integration's table name
+	Table string `json:"table"`
+
+	// ClickHouse types keyed by column name. Other
+	// types are derived from the column's pg type.
+	Types map[string]string `json:"types"`
+
+	// Rows per insert. Defaults to 100,000.
+	BatchSize int `json:"batch_size"`
+
+	// Attempts per request. Defaults to 5.
+	MaxAttempts int `json:"max_attempts"`
+}
+
+type clickhouseSink struct {
+	igName string
+	conf   ClickHouse
+	table  wpg.Table
+	hc     *http.Client
+	q      *queue
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// only used by deliveries, which the queue runs
+	// one at a time
+	created bool
+
+	mut     sync.Mutex
+	pending [][]byte
+	deletes []chDelete
+}
+
+type chDelete struct {
+	src string
+	n   uint64
+}
+
+func newClickHouse(igName string, table wpg.Table, c ClickHouse) *clickhouseSink {
+	if len(c.Database) == 0 {
+		c.Database = "default"
+	}
+	if len(c.Table) == 0 {
+		c.Table = table.Name
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = 100_000
+	}
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = 5
+	}
+	return &clickhouseSink{
+		igName:     igName,
+		conf:       c,
+		table:      table,
+		hc:         &http.Client{Timeout: 60 * time.Second},
+		q:          queueFor("clickhouse", igName, string(c.URL)+"/"+c.Database+"."+c.Table),
+		minBackoff: time.Second,
+		maxBackoff: 30 * time.Second,
+	}
+}
+
+func chIdent(s string) string {
+	return "`" + strings.ReplaceAll(s, "`", "\\`") + "`"
+}
+
+func (cs *clickhouseSink) tableName() string {
+	return chIdent(cs.conf.Database) + "." + chIdent(cs.conf.Table)
+}
+
+// ClickHouse type for a pg column type. numeric
+// columns are usually uint256 abi values.
+func chType(pgType string) string {
+	switch strings.ToLower(pgType) {
+	case "bool", "boolean":
+		return "Bool"
+	case "smallint", "int2":
+		return "Int16"
+	case "int", "integer", "int4":
+		return "Int32"
+	case "bigint", "int8":
+		return "Int64"
+	case "numeric":
+		return "UInt256"
+	case "timestamp", "timestamptz":
+		return "DateTime"
+	default:
+		return "String"
+	}
+}
+
+func (cs *clickhouseSink) ddl() string {
+	var cols []string
+	for _, c := range cs.table.Columns {
+		if len(c.Expr) > 0 {
+			continue
+		}
+		t, ok := cs.conf.Types[c.Name]
+		if !ok {
+			t = chType(c.Type)
+		}
+		cols = append(cols, chIdent(c.Name)+" "+t)
+	}
+	cols = append(cols, "`_version` UInt64", "`_deleted` UInt8")
+
+	order := []string{"src_name", "ig_name", "block_num"}
+	if len(cs.table.Unique) > 0 {
+		order = slices.Clone(cs.table.Unique[0])
+	}
+	for i := range order {
+		order[i] = chIdent(order[i])
+	}
+	return fmt.Sprintf(
+		"create table if not exists %s (%s) engine = ReplacingMergeTree(`_version`, `_deleted`) order by (%s)",
+		cs.tableName(),
+		strings.Join(cols, ", "),
+		strings.Join(order, ", "),
+	)
+}
+
+func (cs *clickhouseSink) create(ctx context.Context) error {
+	if cs.created {
+		return nil
+	}
+	if err := cs.exec(ctx, cs.ddl(), nil, nil); err != nil {
+		return fmt.Errorf("creating table: %w", err)
+	}
+	cs.created = true
+	return nil
+}
+
+// Rows are encoded without _version. It's set when
+// they're inserted so that it's greater than the
+// version of rows deleted before them.
+func (cs *clickhouseSink) Send(ctx context.Context, cols []wpg.Column, rows [][]any) error {
+	encoded := make([][]byte, len(rows))
+	for i, row := range rows {
+		obj := make(map[string]any, len(cols))
+		for j := range cols {
+			v, err := Value(row[j])
+			if err != nil {
+				return fmt.Errorf("encoding column %s: %w", cols[j].Name, err)
+			}
+			obj[cols[j].Name] = v
+		}
+		b, err := json.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("encoding row: %w", err)
+		}
+		encoded[i] = b
+	}
+	cs.mut.Lock()
+	cs.pending = append(cs.pending, encoded...)
+	cs.mut.Unlock()
+	return nil
+}
+
+func (cs *clickhouseSink) Reset() {
+	cs.mut.Lock()
+	cs.pending = nil
+	cs.mut.Unlock()
+}
+
+// Deletes aren't dropped by Reset since the delete
+// may be committed before the insert that follows
+// it fails.
+func (cs *clickhouseSink) Delete(ctx context.Context, n uint64) error {
+	cs.mut.Lock()
+	cs.deletes = append(cs.deletes, chDelete{wctx.SrcName(ctx), n})
+	cs.mut.Unlock()
+	return nil
+}
+
+// Called after the commit. Deletes are queued before
+// rows and rows are inserted in batches of BatchSize.
+func (cs *clickhouseSink) Flush(ctx context.Context, _ wpg.Conn) error {
+	cs.mut.Lock()
+	rows, deletes := cs.pending, cs.deletes
+	cs.pending, cs.deletes = nil, nil
+	cs.mut.Unlock()
+
+	for _, d := range deletes {
+		d := d
+		cs.q.add(ctx, 0, func(ctx context.Context) error {
+			return cs.delete(ctx, d.src, d.n)
+		})
+	}
+	for len(rows) > 0 {
+		n := min(len(rows), cs.conf.BatchSize)
+		batch := rows[:n]
+		cs.q.add(ctx, n, func(ctx context.Context) error {
+			return cs.insert(ctx, batch)
+		})
+		rows = rows[n:]
+	}
+	return nil
+}
+
+func (cs *clickhouseSink) insert(ctx context.Context, rows [][]byte) error {
+	if err := cs.create(ctx); err != nil {
+		return err
+	}
+	var (
+		version = uint64(time.Now().UnixNano())
+		q       = fmt.Sprintf("insert into %s format JSONEachRow", cs.tableName())
+		params  = url.Values{"date_time_input_format": {"best_effort"}}
+		body    bytes.Buffer
+	)
+	for _, row := range rows {
+		fmt.Fprintf(&body, `{"_version":%d`, version)
+		if len(row) > 2 {
+			body.WriteByte(',')
+		}
+		body.Write(row[1:])
+		body.WriteByte('\n')
+	}
+	if err := cs.exec(ctx, q, params, body.Bytes()); err != nil {
+		return fmt.Errorf("inserting into clickhouse: %w", err)
+	}
+	return nil
+}
+
+// Replaces the source's rows for blocks >= n with
+// deleted rows
+func (cs *clickhouseSink) delete(ctx context.Context, src string, n uint64) error {
+	if err := cs.create(ctx); err != nil {
+		return err
+	}
+	var cols []string
+	for _, c := range cs.table.Columns {
+		if len(c.Expr) == 0 {
+			cols = append(cols, chIdent(c.Name))
+		}
+	}
+	q := fmt.Sprintf(`
+		insert into %s (%s, _version, _deleted)
+		select %s, toUnixTimestamp64Nano(now64(9)), 1
+		from %s final
+		where src_name = {src:String}
+		and ig_name = {ig:String}
+		and block_num >= {n:UInt64}
+		and _deleted = 0`,
+		cs.tableName(),
+		strings.Join(cols, ", "),
+		strings.Join(cols, ", "),
+		cs.tableName(),
+	)
+	params := url.Values{
+		"param_src": {src},
+		"param_ig":  {cs.igName},
+		"param_n":   {strconv.FormatUint(n, 10)},
+	}
+	if err := cs.exec(ctx, q, params, nil); err != nil {
+		return fmt.Errorf("deleting from clickhouse: %w", err)
+	}
+	return nil
+}
+
+// Runs q, with body as the query's data, retrying
+// network and server errors.
+func (cs *clickhouseSink) exec(ctx context.Context, q string, params url.Values, body []byte) error {
+	u, err := url.Parse(string(cs.conf.URL))
+	if err != nil {
+		return fmt.Errorf("parsing url: %w", err)
+	}
+	vals := u.Query()
+	vals.Set("query", q)
+	for k, v := range params {
+		vals[k] = v
+	}
+	u.RawQuery = vals.Encode()
+	return retry(ctx, cs.conf.MaxAttempts, cs.minBackoff, cs.maxBackoff, func() (bool, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewReader(body))
+		if err != nil {
+			return false, fmt.Errorf("new request: %w", err)
+		}
+		if len(cs.conf.User) > 0 {
+			req.Header.Set("X-ClickHouse-User", string(cs.conf.User))
+			req.Header.Set("X-ClickHouse-Key", string(cs.conf.Password))
+		}
+		resp, err := cs.hc.Do(req)
+		if err != nil {
+			return true, fmt.Errorf("post: %w", err)
+		}
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		switch {
+		case resp.StatusCode/100 == 2:
+			return false, nil
+		case resp.StatusCode == 408, resp.StatusCode == 429, resp.StatusCode/100 == 5:
+			return true, fmt.Errorf("status: %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+		default:
+			return false, fmt.Errorf("status: %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+		}
+	})
+}
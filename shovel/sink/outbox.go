@@ -0,0 +1,181 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/indexsupply/shovel/wkafka"
+	"github.com/indexsupply/shovel/wpg"
+	"github.com/indexsupply/shovel/wprom"
+)
+
+var mPublished = wprom.NewCounter(
+	"shovel_sink_published_total",
+	"messages published from the kafka outbox",
+	"ig", "topic",
+)
+
+// Messages read from the outbox per publish
+const outboxBatch = 1000
+
+type writer interface {
+	Write(ctx context.Context, msgs ...wkafka.Message) error
+}
+
+// Publishes the messages that Kafka sinks write to
+// shovel.kafka_outbox in the insert's pg transaction (see
+// [kafkaSink.Stage]). Messages are published in the order
+// they were written and deleted once the broker has them so
+// a message may be published more than once but a committed
+// row is never lost. A failed publish is retried until it
+// succeeds, and once maxQueued messages are waiting the
+// task blocks in Flush until the broker catches up.
+type outbox struct {
+	igName string
+	topic  string
+
+	mut     sync.Mutex
+	cond    *sync.Cond
+	w       writer
+	brokers string
+	pg      wpg.Conn
+	// incremented by every flush so that a publish that
+	// finds the outbox empty knows whether it's still empty
+	flushes uint64
+	waiting int
+	checked bool
+	running bool
+}
+
+var (
+	outboxesMut sync.Mutex
+	outboxes    = map[string]*outbox{}
+)
+
+// Shared by every sink with the same integration and topic
+// so that one routine publishes their messages in order.
+// A reloaded config with other brokers replaces the writer.
+func outboxFor(igName, topic string, brokers []string) *outbox {
+	outboxesMut.Lock()
+	defer outboxesMut.Unlock()
+	k := igName + "/" + topic
+	o, ok := outboxes[k]
+	if !ok {
+		o = &outbox{igName: igName, topic: topic}
+		o.cond = sync.NewCond(&o.mut)
+		outboxes[k] = o
+	}
+	o.mut.Lock()
+	if b := fmt.Sprint(brokers); o.w == nil || o.brokers != b {
+		o.w, o.brokers = wkafka.New(brokers, topic), b
+	}
+	o.mut.Unlock()
+	return o
+}
+
+// Called after a commit that wrote n messages. The first
+// flush also publishes messages left by a previous process.
+func (o *outbox) flush(ctx context.Context, pg wpg.Conn, n int) {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+	o.pg = pg
+	o.flushes++
+	o.waiting += n
+	if !o.running && (n > 0 || !o.checked) {
+		o.running = true
+		go o.run(context.WithoutCancel(ctx))
+	}
+	for o.running && o.waiting > maxQueued {
+		o.cond.Wait()
+	}
+}
+
+func (o *outbox) run(ctx context.Context) {
+	backoff := time.Second
+	for {
+		o.mut.Lock()
+		flushes := o.flushes
+		o.mut.Unlock()
+
+		n, err := o.publish(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "kafka-outbox",
+				"ig", o.igName,
+				"topic", o.topic,
+				"error", err,
+			)
+			time.Sleep(backoff)
+			backoff = min(backoff*2, 30*time.Second)
+			continue
+		}
+		backoff = time.Second
+
+		o.mut.Lock()
+		o.waiting = max(0, o.waiting-n)
+		if n == 0 && flushes == o.flushes {
+			o.waiting, o.checked, o.running = 0, true, false
+			o.cond.Broadcast()
+			o.mut.Unlock()
+			return
+		}
+		o.cond.Broadcast()
+		o.mut.Unlock()
+	}
+}
+
+// Publishes the oldest messages and returns how many
+// were published.
+func (o *outbox) publish(ctx context.Context) (int, error) {
+	o.mut.Lock()
+	pg, w := o.pg, o.w
+	o.mut.Unlock()
+
+	const q = `
+		select id, key, value, coalesce(event, '')
+		from shovel.kafka_outbox
+		where ig_name = $1 and topic = $2
+		order by id
+		limit $3
+	`
+	rows, err := pg.Query(ctx, q, o.igName, o.topic, outboxBatch)
+	if err != nil {
+		return 0, fmt.Errorf("reading outbox: %w", err)
+	}
+	var (
+		ids  []int64
+		msgs []wkafka.Message
+	)
+	for rows.Next() {
+		var (
+			id    int64
+			m     wkafka.Message
+			event string
+		)
+		if err := rows.Scan(&id, &m.Key, &m.Value, &event); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning outbox: %w", err)
+		}
+		if len(event) > 0 {
+			m.Headers = []wkafka.Header{{Key: "shovel-event", Value: []byte(event)}}
+		}
+		ids, msgs = append(ids, id), append(msgs, m)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("reading outbox: %w", err)
+	}
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	if err := w.Write(ctx, msgs...); err != nil {
+		return 0, fmt.Errorf("writing to kafka: %w", err)
+	}
+	const d = `delete from shovel.kafka_outbox where id = any($1)`
+	if _, err := pg.Exec(ctx, d, ids); err != nil {
+		return 0, fmt.Errorf("deleting published messages: %w", err)
+	}
+	mPublished.Add(uint64(len(msgs)), o.igName, o.topic)
+	return len(msgs), nil
+}
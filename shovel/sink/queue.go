@@ -0,0 +1,121 @@
+package sink
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/indexsupply/shovel/wprom"
+)
+
+var mDropped = wprom.NewCounter(
+	"shovel_sink_dropped_total",
+	"rows that weren't delivered to a sink after every attempt",
+	"sink", "ig",
+)
+
+// Rows queued per sink before Flush waits for deliveries
+const maxQueued = 250_000
+
+type job struct {
+	ctx     context.Context
+	nrows   int
+	deliver func(context.Context) error
+}
+
+// Delivers batches in the order they're added on a goroutine
+// that runs while the queue has batches so that a slow
+// receiver doesn't hold up the task. Once maxQueued rows are
+// waiting, add blocks until there is room. A batch that fails
+// after every attempt is dropped and counted in
+// shovel_sink_dropped_total since its rows are committed.
+type queue struct {
+	kind   string
+	igName string
+
+	mut     sync.Mutex
+	cond    *sync.Cond
+	jobs    []job
+	nrows   int
+	running bool
+}
+
+var (
+	queuesMut sync.Mutex
+	queues    = map[string]*queue{}
+)
+
+// A task's destinations each have their own sinks (one per
+// decode worker) so the queue is shared by every sink with
+// the same kind, integration, and target. This keeps
+// batches in the order that the task flushes them.
+func queueFor(kind, igName, target string) *queue {
+	queuesMut.Lock()
+	defer queuesMut.Unlock()
+	k := kind + "/" + igName + "/" + target
+	q, ok := queues[k]
+	if !ok {
+		q = &queue{kind: kind, igName: igName}
+		q.cond = sync.NewCond(&q.mut)
+		queues[k] = q
+	}
+	return q
+}
+
+func (q *queue) add(ctx context.Context, nrows int, deliver func(context.Context) error) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	for q.nrows > 0 && q.nrows+nrows > maxQueued {
+		q.cond.Wait()
+	}
+	q.jobs = append(q.jobs, job{
+		ctx:     context.WithoutCancel(ctx),
+		nrows:   nrows,
+		deliver: deliver,
+	})
+	q.nrows += nrows
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+}
+
+func (q *queue) run() {
+	for {
+		q.mut.Lock()
+		if len(q.jobs) == 0 {
+			q.running = false
+			q.cond.Broadcast()
+			q.mut.Unlock()
+			return
+		}
+		j := q.jobs[0]
+		q.jobs[0] = job{}
+		q.jobs = q.jobs[1:]
+		q.mut.Unlock()
+
+		if err := j.deliver(j.ctx); err != nil {
+			mDropped.Add(uint64(j.nrows), q.kind, q.igName)
+			slog.ErrorContext(j.ctx, "sink-dropped",
+				"sink", q.kind,
+				"ig", q.igName,
+				"rows", j.nrows,
+				"error", err,
+			)
+		}
+
+		q.mut.Lock()
+		q.nrows -= j.nrows
+		q.cond.Broadcast()
+		q.mut.Unlock()
+	}
+}
+
+// Waits for every queued batch to be delivered
+func (q *queue) wait() {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	for q.running {
+		q.cond.Wait()
+	}
+}
@@ -0,0 +1,348 @@
+// Publishes an integration's rows to systems other than Postgres
+//
+// Kafka sinks write their messages to an outbox table in the
+// insert's pg transaction (see [dig.Stager]) and publish them
+// after the commit (see [outbox]) so that consumers never see
+// rows that are later rolled back and committed rows aren't
+// lost when the broker is unavailable. When blocks are deleted
+// during a reorg a message with a shovel-event: reorg header
+// is published before the rows that replace them.
+//
+// Webhook sinks buffer rows and deliver them after the commit
+// (see [dig.Flusher]) so that receivers never see rows that
+// are later rolled back.
+//
+// ClickHouse sinks, like Kafka sinks, insert after the commit
+// in the background and replace the rows of reorganized
+// blocks (see [dig.Deleter]) before inserting the rows that
+// replace them.
+package sink
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/indexsupply/shovel/dig"
+	"github.com/indexsupply/shovel/eth"
+	"github.com/indexsupply/shovel/wctx"
+	"github.com/indexsupply/shovel/wkafka"
+	"github.com/indexsupply/shovel/wos"
+	"github.com/indexsupply/shovel/wpg"
+)
+
+type Config struct {
+	Kafka      *Kafka      `json:"kafka,omitempty"`
+	Webhook    *Webhook    `json:"webhook,omitempty"`
+	ClickHouse *ClickHouse `json:"clickhouse,omitempty"`
+}
+
+type Kafka struct {
+	Brokers []wos.EnvString `json:"brokers"`
+	Topic   wos.EnvString   `json:"topic"`
+
+	// json (default) or avro
+	Format string `json:"format"`
+
+	// Column used for the message key. When empty, the
+	// integration name is used so that every row is
+	// written to a single partition in order.
+	Key string `json:"key"`
+}
+
+func (c Config) Validate(table wpg.Table) error {
+	switch {
+	case c.Kafka != nil:
+		k := c.Kafka
+		if len(k.Brokers) == 0 {
+			return fmt.Errorf("kafka sink missing brokers")
+		}
+		if len(k.Topic) == 0 {
+			return fmt.Errorf("kafka sink missing topic")
+		}
+		if !slices.Contains([]string{"", "json", "avro"}, k.Format) {
+			return fmt.Errorf("kafka sink format must be one of: json, avro. got: %s", k.Format)
+		}
+		if len(k.Key) > 0 && !hasColumn(table, k.Key) {
+			return fmt.Errorf("kafka sink key %q is not a column of %s", k.Key, table.Name)
+		}
+		return nil
+	case c.Webhook != nil:
+		if len(c.Webhook.URL) == 0 {
+			return fmt.Errorf("webhook sink missing url")
+		}
+		if c.Webhook.BatchSize < 0 || c.Webhook.MaxAttempts < 0 {
+			return fmt.Errorf("webhook sink batch_size and max_attempts must be positive")
+		}
+		return nil
+	case c.ClickHouse != nil:
+		ch := c.ClickHouse
+		if len(ch.URL) == 0 {
+			return fmt.Errorf("clickhouse sink missing url")
+		}
+		if ch.BatchSize < 0 || ch.MaxAttempts < 0 {
+			return fmt.Errorf("clickhouse sink batch_size and max_attempts must be positive")
+		}
+		for _, name := range []string{"src_name", "ig_name", "block_num"} {
+			if !hasColumn(table, name) {
+				return fmt.Errorf("clickhouse sink requires a %s column", name)
+			}
+		}
+		for name := range ch.Types {
+			if !hasColumn(table, name) {
+				return fmt.Errorf("clickhouse sink type for %q is not a column of %s", name, table.Name)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("sink must have one of: kafka, webhook, clickhouse")
+	}
+}
+
+func hasColumn(table wpg.Table, name string) bool {
+	for _, c := range table.Columns {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func New(igName string, table wpg.Table, c Config) (dig.Sink, error) {
+	switch {
+	case c.Kafka != nil:
+		var brokers []string
+		for _, b := range c.Kafka.Brokers {
+			brokers = append(brokers, string(b))
+		}
+		return &kafkaSink{
+			igName: igName,
+			conf:   *c.Kafka,
+			ob:     outboxFor(igName, string(c.Kafka.Topic), brokers),
+		}, nil
+	case c.Webhook != nil:
+		return newWebhook(igName, *c.Webhook), nil
+	case c.ClickHouse != nil:
+		return newClickHouse(igName, table, *c.ClickHouse), nil
+	default:
+		return nil, fmt.Errorf("sink must have one of: kafka, webhook, clickhouse")
+	}
+}
+
+type kafkaSink struct {
+	igName string
+	conf   Kafka
+	ob     *outbox
+
+	// fingerprint of the last logged avro schema
+	logged atomic.Uint64
+
+	mut     sync.Mutex
+	pending []wkafka.Message
+	reorgs  []wkafka.Message
+	// messages written to the outbox since the last Flush
+	staged int
+}
+
+func (ks *kafkaSink) Send(ctx context.Context, cols []wpg.Column, rows [][]any) error {
+	var (
+		msgs   = make([]wkafka.Message, len(rows))
+		keyIdx = slices.IndexFunc(cols, func(c wpg.Column) bool {
+			return c.Name == ks.conf.Key
+		})
+		schema *avroSchema
+	)
+	if ks.conf.Format == "avro" {
+		schema = newAvroSchema(ks.igName, cols)
+		if ks.logged.Swap(schema.fingerprint) != schema.fingerprint {
+			slog.InfoContext(ctx, "avro-schema",
+				"fingerprint", fmt.Sprintf("%x", schema.fingerprint),
+				"schema", string(schema.json),
+			)
+		}
+	}
+	for i, row := range rows {
+		switch {
+		case len(ks.conf.Key) > 0 && keyIdx >= 0:
+			msgs[i].Key = []byte(Text(row[keyIdx]))
+		default:
+			msgs[i].Key = []byte(ks.igName)
+		}
+		var err error
+		switch {
+		case schema != nil:
+			msgs[i].Value, err = schema.encode(row)
+		default:
+			msgs[i].Value, err = JSON(cols, row)
+		}
+		if err != nil {
+			return fmt.Errorf("encoding row: %w", err)
+		}
+	}
+	ks.mut.Lock()
+	ks.pending = append(ks.pending, msgs...)
+	ks.mut.Unlock()
+	return nil
+}
+
+func (ks *kafkaSink) Reset() {
+	ks.mut.Lock()
+	ks.pending = nil
+	ks.mut.Unlock()
+}
+
+type kafkaReorg struct {
+	SrcName  string `json:"src_name"`
+	IGName   string `json:"ig_name"`
+	BlockNum uint64 `json:"block_num"`
+}
+
+// Holds a message saying that rows for blocks >= n were
+// deleted until the delete's transaction is staged. The
+// message is keyed by the integration name.
+func (ks *kafkaSink) Delete(ctx context.Context, n uint64) error {
+	b, err := json.Marshal(kafkaReorg{
+		SrcName:  wctx.SrcName(ctx),
+		IGName:   ks.igName,
+		BlockNum: n,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding reorg: %w", err)
+	}
+	ks.mut.Lock()
+	ks.reorgs = append(ks.reorgs, wkafka.Message{
+		Key:     []byte(ks.igName),
+		Value:   b,
+		Headers: []wkafka.Header{{Key: "shovel-event", Value: []byte("reorg")}},
+	})
+	ks.mut.Unlock()
+	return nil
+}
+
+// Writes the held messages, reorgs first, to the outbox
+// in pg's transaction.
+func (ks *kafkaSink) Stage(ctx context.Context, pg wpg.Conn) error {
+	ks.mut.Lock()
+	msgs := append(ks.reorgs, ks.pending...)
+	ks.reorgs, ks.pending = nil, nil
+	ks.staged += len(msgs)
+	ks.mut.Unlock()
+	if len(msgs) == 0 {
+		return nil
+	}
+	var (
+		keys   = make([][]byte, len(msgs))
+		values = make([][]byte, len(msgs))
+		events = make([]string, len(msgs))
+	)
+	for i, m := range msgs {
+		keys[i], values[i] = m.Key, m.Value
+		for _, h := range m.Headers {
+			if h.Key == "shovel-event" {
+				events[i] = string(h.Value)
+			}
+		}
+	}
+	const q = `
+		insert into shovel.kafka_outbox (ig_name, topic, key, value, event)
+		select $1, $2, k, v, nullif(e, '')
+		from unnest($3::bytea[], $4::bytea[], $5::text[])
+		with ordinality as m(k, v, e, i)
+		order by i
+	`
+	_, err := pg.Exec(ctx, q, ks.igName, string(ks.conf.Topic), keys, values, events)
+	if err != nil {
+		return fmt.Errorf("writing kafka outbox: %w", err)
+	}
+	return nil
+}
+
+// Called after the commit with the task's pool.
+// Publishes the outbox in the background and blocks
+// while the broker is too far behind (see [outbox]).
+func (ks *kafkaSink) Flush(ctx context.Context, pg wpg.Conn) error {
+	ks.mut.Lock()
+	n := ks.staged
+	ks.staged = 0
+	ks.mut.Unlock()
+	ks.ob.flush(ctx, pg, n)
+	return nil
+}
+
+// Converts a value from a row into one that encodes
+// naturally as JSON: bytes are hex encoded and
+// large integers are decimal strings.
+func Value(v any) (any, error) {
+	switch v := v.(type) {
+	case nil, bool, string, int, int64, uint64:
+		return v, nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case eth.Uint64:
+		return uint64(v), nil
+	case eth.Byte:
+		return uint64(v), nil
+	case uint8:
+		return uint64(v), nil
+	case []byte:
+		return eth.EncodeHex(v), nil
+	case [][]byte:
+		res := make([]string, len(v))
+		for i := range v {
+			res[i] = eth.EncodeHex(v[i])
+		}
+		return res, nil
+	case time.Time:
+		return v.UTC().Format(time.RFC3339), nil
+	case driver.Valuer:
+		return v.Value()
+	default:
+		return nil, fmt.Errorf("unknown type: %T", v)
+	}
+}
+
+// String form of a row's value. Used for message keys.
+func Text(v any) string {
+	v, err := Value(v)
+	if err != nil {
+		return ""
+	}
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Encodes the row as an object keyed by column name
+func JSON(cols []wpg.Column, row []any) ([]byte, error) {
+	obj := make(map[string]any, len(cols))
+	for i := range cols {
+		v, err := Value(row[i])
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", cols[i].Name, err)
+		}
+		obj[cols[i].Name] = v
+	}
+	return json.Marshal(obj)
+}
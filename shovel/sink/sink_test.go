@@ -0,0 +1,358 @@
+package sink
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"blake.io/pqx/pqxtest"
+	"github.com/holiman/uint256"
+	"github.com/indexsupply/shovel/eth"
+	"github.com/indexsupply/shovel/wctx"
+	"github.com/indexsupply/shovel/wkafka"
+	"github.com/indexsupply/shovel/wos"
+	"github.com/indexsupply/shovel/wpg"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"kr.dev/diff"
+)
+
+func TestMain(m *testing.M) {
+	sql.Register("postgres", stdlib.GetDefaultDriver())
+	pqxtest.TestMain(m)
+}
+
+var testCols = []wpg.Column{
+	{Name: "block_num", Type: "numeric"},
+	{Name: "log_idx", Type: "int"},
+	{Name: "f", Type: "bytea"},
+	{Name: "value", Type: "numeric"},
+	{Name: "ok", Type: "bool"},
+	{Name: "memo", Type: "text"},
+}
+
+func TestJSON(t *testing.T) {
+	row := []any{
+		uint64(18000000),
+		eth.Uint64(3),
+		[]byte{0xab, 0xcd},
+		uint256.NewInt(1e18),
+		true,
+		nil,
+	}
+	got, err := JSON(testCols, row)
+	diff.Test(t, t.Fatalf, err, nil)
+	const want = `{"block_num":18000000,"f":"0xabcd","log_idx":3,"memo":null,"ok":true,"value":"1000000000000000000"}`
+	diff.Test(t, t.Errorf, string(got), want)
+
+	_, err = JSON(testCols[:1], []any{struct{}{}})
+	diff.Test(t, t.Errorf, err.Error(), "column block_num: unknown type: struct {}")
+}
+
+func TestAvro(t *testing.T) {
+	// from the avro spec's test data
+	diff.Test(t, t.Errorf, rabin([]byte(`"int"`)), uint64(0x7275d51a3f395c8f))
+
+	s := newAvroSchema("erc20-transfers", testCols)
+	const want = `{"name":"erc20_transfers","type":"record","fields":[` +
+		`{"name":"block_num","type":["null",` + avroDecimal + `]},` +
+		`{"name":"log_idx","type":["null","long"]},` +
+		`{"name":"f","type":["null","bytes"]},` +
+		`{"name":"value","type":["null",` + avroDecimal + `]},` +
+		`{"name":"ok","type":["null","boolean"]},` +
+		`{"name":"memo","type":["null","string"]}]}`
+	diff.Test(t, t.Errorf, string(s.json), want)
+
+	got, err := s.encode([]any{
+		uint64(42),
+		eth.Uint64(3),
+		[]byte{0xab},
+		uint256.NewInt(7),
+		true,
+		nil,
+	})
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, got[:2], []byte{0xc3, 0x01})
+	diff.Test(t, t.Errorf, binary.LittleEndian.Uint64(got[2:10]), s.fingerprint)
+	diff.Test(t, t.Errorf, got[10:], []byte{
+		2, 2, 42, // block_num
+		2, 6, // log_idx 3
+		2, 2, 0xab, // f
+		2, 2, 7, // value
+		2, 1, // ok
+		0, // memo null
+	})
+
+	_, err = newAvroSchema("x", testCols[1:2]).encode([]any{uint64(math.MaxUint64)})
+	diff.Test(t, t.Errorf, err.Error(), "18446744073709551615 overflows long")
+
+	got, err = newAvroSchema("x", testCols[3:4]).encode([]any{uint256.NewInt(math.MaxUint64)})
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, got[10:], []byte{2, 18, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+}
+
+func TestTwos(t *testing.T) {
+	for _, tc := range []struct {
+		n    int64
+		want []byte
+	}{
+		{0, []byte{0}},
+		{127, []byte{0x7f}},
+		{128, []byte{0, 0x80}},
+		{-1, []byte{0xff}},
+		{-128, []byte{0x80}},
+		{-129, []byte{0xff, 0x7f}},
+	} {
+		diff.Test(t, t.Errorf, twos(big.NewInt(tc.n)), tc.want)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var (
+		nreq   int
+		bodies [][]byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nreq++
+		body, _ := io.ReadAll(r.Body)
+		if nreq == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		ts := r.Header.Get(timestampHeader)
+		diff.Test(t, t.Errorf, r.Header.Get(signatureHeader), Sign([]byte("s3cret"), ts, body))
+		bodies = append(bodies, body)
+	}))
+	defer srv.Close()
+
+	ws := newWebhook("foo", Webhook{
+		URL:       wos.EnvString(srv.URL),
+		Secret:    "s3cret",
+		BatchSize: 2,
+	})
+	ws.minBackoff = time.Millisecond
+
+	var (
+		ctx  = context.Background()
+		cols = []wpg.Column{{Name: "n", Type: "numeric"}}
+	)
+	diff.Test(t, t.Fatalf, ws.Send(ctx, cols, [][]any{{uint64(1)}}), nil)
+	ws.Reset()
+	diff.Test(t, t.Fatalf, ws.Send(ctx, cols, [][]any{{uint64(2)}, {uint64(3)}, {uint64(4)}}), nil)
+	diff.Test(t, t.Fatalf, ws.Flush(ctx, nil), nil)
+	ws.q.wait()
+	diff.Test(t, t.Errorf, nreq, 3)
+	diff.Test(t, t.Errorf, string(bodies[0]), `{"integration":"foo","rows":[{"n":2},{"n":3}]}`)
+	diff.Test(t, t.Errorf, string(bodies[1]), `{"integration":"foo","rows":[{"n":4}]}`)
+
+	// nothing pending
+	diff.Test(t, t.Fatalf, ws.Flush(ctx, nil), nil)
+	ws.q.wait()
+	diff.Test(t, t.Errorf, nreq, 3)
+}
+
+func TestWebhook_NoRetry(t *testing.T) {
+	var nreq int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nreq++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	ws := newWebhook("bar", Webhook{URL: wos.EnvString(srv.URL)})
+	ws.minBackoff = time.Millisecond
+	cols := []wpg.Column{{Name: "n", Type: "numeric"}}
+	diff.Test(t, t.Fatalf, ws.Send(context.Background(), cols, [][]any{{uint64(1)}}), nil)
+	diff.Test(t, t.Fatalf, ws.Flush(context.Background(), nil), nil)
+	ws.q.wait()
+	diff.Test(t, t.Errorf, nreq, 1)
+	diff.Test(t, t.Errorf, mDropped.Get("webhook", "bar"), uint64(1))
+}
+
+func TestClickHouse(t *testing.T) {
+	var queries, bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		queries = append(queries, r.URL.Query().Get("query"))
+		bodies = append(bodies, string(body))
+		if r.URL.Query().Get("param_n") != "" {
+			diff.Test(t, t.Errorf, r.URL.Query().Get("param_n"), "10")
+			diff.Test(t, t.Errorf, r.URL.Query().Get("param_ig"), "foo")
+			diff.Test(t, t.Errorf, r.URL.Query().Get("param_src"), "main")
+		}
+	}))
+	defer srv.Close()
+
+	table := wpg.Table{
+		Name: "transfers",
+		Columns: []wpg.Column{
+			{Name: "src_name", Type: "text"},
+			{Name: "ig_name", Type: "text"},
+			{Name: "block_num", Type: "numeric"},
+			{Name: "f", Type: "bytea"},
+		},
+		Unique: [][]string{{"src_name", "ig_name", "block_num"}},
+	}
+	diff.Test(t, t.Fatalf, Config{ClickHouse: &ClickHouse{URL: "x"}}.Validate(table), nil)
+	cs := newClickHouse("foo", table, ClickHouse{
+		URL:   wos.EnvString(srv.URL),
+		Types: map[string]string{"block_num": "UInt64"},
+	})
+	var (
+		ctx = wctx.WithSrcName(context.Background(), "main")
+		row = []any{"main", "foo", uint64(10), []byte{0xab}}
+	)
+
+	// nothing is sent before the commit and
+	// Reset keeps the delete
+	diff.Test(t, t.Fatalf, cs.Delete(ctx, 10), nil)
+	diff.Test(t, t.Fatalf, cs.Send(ctx, table.Columns, [][]any{{"main", "foo", uint64(9), []byte{0xcd}}}), nil)
+	cs.Reset()
+	diff.Test(t, t.Fatalf, cs.Send(ctx, table.Columns, [][]any{row}), nil)
+	diff.Test(t, t.Fatalf, len(queries), 0)
+
+	diff.Test(t, t.Fatalf, cs.Flush(ctx, nil), nil)
+	cs.q.wait()
+	diff.Test(t, t.Fatalf, len(queries), 3)
+	const ddl = "create table if not exists `default`.`transfers` (" +
+		"`src_name` String, `ig_name` String, `block_num` UInt64, `f` String, " +
+		"`_version` UInt64, `_deleted` UInt8) " +
+		"engine = ReplacingMergeTree(`_version`, `_deleted`) " +
+		"order by (`src_name`, `ig_name`, `block_num`)"
+	diff.Test(t, t.Errorf, queries[0], ddl)
+	if !strings.Contains(queries[1], "_deleted = 0") {
+		t.Errorf("expected delete. got: %s", queries[1])
+	}
+	diff.Test(t, t.Errorf, queries[2], "insert into `default`.`transfers` format JSONEachRow")
+	if !strings.HasPrefix(bodies[2], `{"_version":`) || !strings.HasSuffix(bodies[2], `,"block_num":10,"f":"0xab","ig_name":"foo","src_name":"main"}`+"\n") {
+		t.Errorf("unexpected insert body: %s", bodies[2])
+	}
+	diff.Test(t, t.Errorf, table.Unique[0][0], "src_name")
+}
+
+func TestQueue(t *testing.T) {
+	var (
+		ctx = context.Background()
+		q   = queueFor("test", "foo", "")
+		got []int
+	)
+	for i := 0; i < 3; i++ {
+		i := i
+		q.add(ctx, 2, func(context.Context) error {
+			got = append(got, i)
+			if i == 1 {
+				return errors.New("nope")
+			}
+			return nil
+		})
+	}
+	q.wait()
+	diff.Test(t, t.Errorf, got, []int{0, 1, 2})
+	diff.Test(t, t.Errorf, mDropped.Get("test", "foo"), uint64(2))
+	diff.Test(t, t.Errorf, q.nrows, 0)
+	diff.Test(t, t.Errorf, queueFor("test", "foo", "") == q, true)
+}
+
+func TestKafka_Pending(t *testing.T) {
+	s, err := New("foo", wpg.Table{}, Config{Kafka: &Kafka{Brokers: []wos.EnvString{"localhost:1"}, Topic: "t"}})
+	diff.Test(t, t.Fatalf, err, nil)
+	var (
+		ks   = s.(*kafkaSink)
+		ctx  = wctx.WithSrcName(context.Background(), "main")
+		cols = []wpg.Column{{Name: "n", Type: "numeric"}}
+	)
+	diff.Test(t, t.Fatalf, ks.Send(ctx, cols, [][]any{{uint64(1)}}), nil)
+	diff.Test(t, t.Fatalf, ks.Delete(ctx, 10), nil)
+	ks.Reset()
+	diff.Test(t, t.Fatalf, ks.Send(ctx, cols, [][]any{{uint64(2)}}), nil)
+	diff.Test(t, t.Errorf, len(ks.pending), 1)
+	diff.Test(t, t.Errorf, string(ks.pending[0].Value), `{"n":2}`)
+	diff.Test(t, t.Errorf, len(ks.reorgs), 1)
+	diff.Test(t, t.Errorf, string(ks.reorgs[0].Value), `{"src_name":"main","ig_name":"foo","block_num":10}`)
+	diff.Test(t, t.Errorf, string(ks.reorgs[0].Headers[0].Value), "reorg")
+}
+
+type testWriter struct {
+	mut  sync.Mutex
+	fail int
+	msgs []wkafka.Message
+}
+
+func (w *testWriter) Write(_ context.Context, msgs ...wkafka.Message) error {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if w.fail > 0 {
+		w.fail--
+		return errors.New("broker unavailable")
+	}
+	w.msgs = append(w.msgs, msgs...)
+	return nil
+}
+
+func TestKafka_Outbox(t *testing.T) {
+	schema, err := os.ReadFile("../schema.sql")
+	diff.Test(t, t.Fatalf, err, nil)
+	pqxtest.CreateDB(t, string(schema))
+	pg, err := pgxpool.New(context.Background(), pqxtest.DSNForTest(t))
+	diff.Test(t, t.Fatalf, err, nil)
+
+	var (
+		ctx  = wctx.WithSrcName(context.Background(), "main")
+		cols = []wpg.Column{{Name: "n", Type: "numeric"}}
+		w    = &testWriter{fail: 1}
+		ob   = &outbox{igName: "foo", topic: "t", w: w}
+		ks   = &kafkaSink{igName: "foo", conf: Kafka{Topic: "t"}, ob: ob}
+	)
+	ob.cond = sync.NewCond(&ob.mut)
+	stage := func(commit bool) {
+		tx, err := pg.Begin(ctx)
+		diff.Test(t, t.Fatalf, err, nil)
+		diff.Test(t, t.Fatalf, ks.Stage(ctx, tx), nil)
+		if commit {
+			diff.Test(t, t.Fatalf, tx.Commit(ctx), nil)
+			return
+		}
+		diff.Test(t, t.Fatalf, tx.Rollback(ctx), nil)
+	}
+
+	// rolled back messages are never published
+	diff.Test(t, t.Fatalf, ks.Send(ctx, cols, [][]any{{uint64(1)}}), nil)
+	stage(false)
+
+	diff.Test(t, t.Fatalf, ks.Delete(ctx, 2), nil)
+	diff.Test(t, t.Fatalf, ks.Send(ctx, cols, [][]any{{uint64(2)}, {uint64(3)}}), nil)
+	stage(true)
+	diff.Test(t, t.Fatalf, ks.Flush(ctx, pg), nil)
+
+	// the first write fails and is retried
+	ob.mut.Lock()
+	for ob.running {
+		ob.cond.Wait()
+	}
+	ob.mut.Unlock()
+
+	var got []string
+	for _, m := range w.msgs {
+		got = append(got, string(m.Value))
+	}
+	diff.Test(t, t.Errorf, got, []string{
+		`{"src_name":"main","ig_name":"foo","block_num":2}`,
+		`{"n":2}`,
+		`{"n":3}`,
+	})
+	diff.Test(t, t.Errorf, string(w.msgs[0].Headers[0].Value), "reorg")
+	var n int
+	diff.Test(t, t.Fatalf, pg.QueryRow(ctx, `select count(*) from shovel.kafka_outbox`).Scan(&n), nil)
+	diff.Test(t, t.Errorf, n, 0)
+}
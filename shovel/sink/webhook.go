@@ -0,0 +1,199 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/indexsupply/shovel/wos"
+	"github.com/indexsupply/shovel/wpg"
+)
+
+const (
+	signatureHeader = "X-Shovel-Signature"
+	timestampHeader = "X-Shovel-Timestamp"
+)
+
+type Webhook struct {
+	URL wos.EnvString `json:"url"`
+
+	// When set, each request has an X-Shovel-Signature
+	// header of the form:
+	// sha256=hex(hmac(secret, timestamp + "." + body))
+	// where timestamp is the X-Shovel-Timestamp header.
+	Secret wos.EnvString `json:"secret"`
+
+	// Rows per request. Defaults to 1000.
+	BatchSize int `json:"batch_size"`
+
+	// Attempts per request before the rows are dropped.
+	// Defaults to 5.
+	MaxAttempts int `json:"max_attempts"`
+}
+
+type webhookPayload struct {
+	Integration string            `json:"integration"`
+	Rows        []json.RawMessage `json:"rows"`
+}
+
+// Rows are buffered by Send and queued by Flush once the
+// block range has been committed to pg. Requests are made
+// in the background (see [queue]).
+type webhookSink struct {
+	igName string
+	conf   Webhook
+	hc     *http.Client
+	q      *queue
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mut     sync.Mutex
+	pending []json.RawMessage
+}
+
+func newWebhook(igName string, c Webhook) *webhookSink {
+	if c.BatchSize == 0 {
+		c.BatchSize = 1000
+	}
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = 5
+	}
+	return &webhookSink{
+		igName:     igName,
+		conf:       c,
+		hc:         &http.Client{Timeout: 30 * time.Second},
+		q:          queueFor("webhook", igName, string(c.URL)),
+		minBackoff: time.Second,
+		maxBackoff: 30 * time.Second,
+	}
+}
+
+func (ws *webhookSink) Send(ctx context.Context, cols []wpg.Column, rows [][]any) error {
+	encoded := make([]json.RawMessage, len(rows))
+	for i := range rows {
+		b, err := JSON(cols, rows[i])
+		if err != nil {
+			return fmt.Errorf("encoding row: %w", err)
+		}
+		encoded[i] = b
+	}
+	ws.mut.Lock()
+	ws.pending = append(ws.pending, encoded...)
+	ws.mut.Unlock()
+	return nil
+}
+
+func (ws *webhookSink) Reset() {
+	ws.mut.Lock()
+	ws.pending = nil
+	ws.mut.Unlock()
+}
+
+// Since the rows are already committed, a request that
+// fails after every attempt is logged and its rows dropped
+// so that the remaining batches are still delivered.
+func (ws *webhookSink) Flush(ctx context.Context, _ wpg.Conn) error {
+	ws.mut.Lock()
+	rows := ws.pending
+	ws.pending = nil
+	ws.mut.Unlock()
+
+	for len(rows) > 0 {
+		n := min(len(rows), ws.conf.BatchSize)
+		batch := rows[:n]
+		ws.q.add(ctx, n, func(ctx context.Context) error {
+			return ws.deliver(ctx, batch)
+		})
+		rows = rows[n:]
+	}
+	return nil
+}
+
+func (ws *webhookSink) deliver(ctx context.Context, rows []json.RawMessage) error {
+	body, err := json.Marshal(webhookPayload{
+		Integration: ws.igName,
+		Rows:        rows,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding payload: %w", err)
+	}
+	return retry(ctx, ws.conf.MaxAttempts, ws.minBackoff, ws.maxBackoff, func() (bool, error) {
+		return ws.post(ctx, body)
+	})
+}
+
+// Calls f until it succeeds, it reports that its error
+// shouldn't be retried, or maxAttempts is reached.
+// The delay between attempts doubles up to maxBackoff.
+func retry(ctx context.Context, maxAttempts int, minBackoff, maxBackoff time.Duration, f func() (bool, error)) error {
+	backoff := minBackoff
+	for attempt := 1; ; attempt++ {
+		again, err := f()
+		if err == nil {
+			return nil
+		}
+		if !again || attempt >= maxAttempts {
+			return fmt.Errorf("attempt %d: %w", attempt, err)
+		}
+		slog.WarnContext(ctx, "sink-retry", "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// Reports whether a failed request should be retried.
+// Client errors (other than 408 and 429) are not retried.
+func (ws *webhookSink) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", string(ws.conf.URL), bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("new request: %w", err)
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set(timestampHeader, ts)
+	if len(ws.conf.Secret) > 0 {
+		req.Header.Set(signatureHeader, Sign([]byte(ws.conf.Secret), ts, body))
+	}
+	resp, err := ws.hc.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("post: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<10))
+	switch {
+	case resp.StatusCode/100 == 2:
+		return false, nil
+	case resp.StatusCode == 408, resp.StatusCode == 429, resp.StatusCode/100 == 5:
+		return true, fmt.Errorf("status: %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("status: %d", resp.StatusCode)
+	}
+}
+
+// Value for the X-Shovel-Signature header. Receivers should
+// compute the same value over the X-Shovel-Timestamp header
+// and the raw request body, compare using a constant time
+// comparison, and reject old timestamps so that requests
+// can't be replayed.
+func Sign(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,110 @@
+package shovel
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Throughput is averaged over converges in this window
+const statsWindow = time.Minute
+
+// A task's recent throughput. Durations are the average
+// time spent per converge in each stage.
+type Stats struct {
+	BlocksPerSec float64       `json:"blocks_per_sec"`
+	RowsPerSec   float64       `json:"rows_per_sec"`
+	Load         time.Duration `json:"load"`
+	Decode       time.Duration `json:"decode"`
+	Insert       time.Duration `json:"insert"`
+	Lag          uint64        `json:"lag"`
+}
+
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("%.1f blocks/s %.1f rows/s load=%s decode=%s insert=%s lag=%d",
+		s.BlocksPerSec,
+		s.RowsPerSec,
+		ms(s.Load),
+		ms(s.Decode),
+		ms(s.Insert),
+		s.Lag,
+	)
+}
+
+type sample struct {
+	t       time.Time
+	nblocks uint64
+	nrows   int64
+	load    time.Duration
+	decode  time.Duration
+	insert  time.Duration
+}
+
+type window struct {
+	start   time.Time
+	samples []sample
+}
+
+var (
+	statsMut sync.Mutex
+	stats    = map[string]*window{}
+)
+
+func record(src, ig string, s sample) {
+	statsMut.Lock()
+	defer statsMut.Unlock()
+	w, ok := stats[src+"/"+ig]
+	if !ok {
+		w = &window{start: s.t}
+		stats[src+"/"+ig] = w
+	}
+	w.samples = append(w.samples, s)
+	w.prune(s.t)
+}
+
+func (w *window) prune(now time.Time) {
+	var i int
+	for i < len(w.samples) && now.Sub(w.samples[i].t) > statsWindow {
+		i++
+	}
+	w.samples = w.samples[i:]
+}
+
+// Throughput of the task over the last minute
+func Throughput(src, ig string) Stats {
+	res := Stats{Lag: mLag.Get(src, ig)}
+	statsMut.Lock()
+	defer statsMut.Unlock()
+	w, ok := stats[src+"/"+ig]
+	if !ok {
+		return res
+	}
+	now := time.Now()
+	w.prune(now)
+	if len(w.samples) == 0 {
+		return res
+	}
+	var (
+		nblocks uint64
+		nrows   int64
+		n       = time.Duration(len(w.samples))
+		elapsed = min(statsWindow, max(time.Second, now.Sub(w.start))).Seconds()
+	)
+	for _, s := range w.samples {
+		nblocks += s.nblocks
+		nrows += s.nrows
+		res.Load += s.load
+		res.Decode += s.decode
+		res.Insert += s.insert
+	}
+	res.Load /= n
+	res.Decode /= n
+	res.Insert /= n
+	res.BlocksPerSec = float64(nblocks) / elapsed
+	res.RowsPerSec = float64(nrows) / elapsed
+	return res
+}
@@ -0,0 +1,392 @@
+// Streams integration rows to gRPC subscribers
+//
+// Rows are published to a [Hub] by a sink that each integration
+// has (see [Hub.Sink]) after the pg transaction that inserted
+// them commits. A subscriber may request a start block, in which
+// case rows already in pg are read from the [Store], a page of
+// blocks at a time, before switching to published rows. When a
+// reorg deletes rows the subscriber is sent a reorg message
+// (see [Server.Rows]).
+//
+// There is no generated code: the service's request and
+// response messages are google.protobuf.Struct so that any
+// gRPC client can use it with stream.proto.
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/indexsupply/shovel/shovel/sink"
+	"github.com/indexsupply/shovel/wctx"
+	"github.com/indexsupply/shovel/wpg"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// A row encoded by [sink.JSON]. When Reorg is set, Data
+// is empty and the source's rows for blocks >= BlockNum
+// were deleted.
+type Row struct {
+	Src      string
+	BlockNum uint64
+	Data     json.RawMessage
+	Reorg    bool
+}
+
+// Batches published to a subscriber are buffered up to
+// this limit. A subscriber that falls further behind is
+// disconnected and may resume from its last block.
+const subBuffer = 256
+
+type sub struct {
+	c chan []Row
+}
+
+type Hub struct {
+	mut  sync.Mutex
+	subs map[string]map[*sub]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: map[string]map[*sub]struct{}{}}
+}
+
+func (h *Hub) subscribed(ig string) bool {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	return len(h.subs[ig]) > 0
+}
+
+// The returned channel is closed by the returned func or
+// when the subscriber falls behind.
+func (h *Hub) Subscribe(ig string) (<-chan []Row, func()) {
+	s := &sub{c: make(chan []Row, subBuffer)}
+	h.mut.Lock()
+	if h.subs[ig] == nil {
+		h.subs[ig] = map[*sub]struct{}{}
+	}
+	h.subs[ig][s] = struct{}{}
+	h.mut.Unlock()
+	return s.c, func() {
+		h.mut.Lock()
+		defer h.mut.Unlock()
+		h.remove(ig, s)
+	}
+}
+
+func (h *Hub) remove(ig string, s *sub) {
+	if _, ok := h.subs[ig][s]; !ok {
+		return
+	}
+	delete(h.subs[ig], s)
+	close(s.c)
+}
+
+func (h *Hub) Publish(ig string, rows []Row) {
+	if len(rows) == 0 {
+		return
+	}
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	for s := range h.subs[ig] {
+		select {
+		case s.c <- rows:
+		default:
+			h.remove(ig, s)
+		}
+	}
+}
+
+// Returns a sink that publishes the integration's rows
+// after they are committed. Rows are only encoded when
+// the integration has subscribers.
+func (h *Hub) Sink(ig string) *Sink {
+	return &Sink{hub: h, ig: ig}
+}
+
+// Implements [dig.Sink], [dig.Flusher], and [dig.Deleter]
+type Sink struct {
+	hub *Hub
+	ig  string
+
+	mut     sync.Mutex
+	pending []Row
+}
+
+func (s *Sink) Send(ctx context.Context, cols []wpg.Column, rows [][]any) error {
+	if !s.hub.subscribed(s.ig) {
+		return nil
+	}
+	var (
+		src     = wctx.SrcName(ctx)
+		bnIdx   = -1
+		encoded = make([]Row, len(rows))
+	)
+	for i := range cols {
+		if cols[i].Name == "block_num" {
+			bnIdx = i
+		}
+	}
+	for i := range rows {
+		b, err := sink.JSON(cols, rows[i])
+		if err != nil {
+			return fmt.Errorf("encoding row: %w", err)
+		}
+		encoded[i] = Row{Src: src, Data: b}
+		if bnIdx >= 0 {
+			encoded[i].BlockNum = blockNum(rows[i][bnIdx])
+		}
+	}
+	s.mut.Lock()
+	s.pending = append(s.pending, encoded...)
+	s.mut.Unlock()
+	return nil
+}
+
+// Held until Flush, like rows, since the delete is part
+// of the task's pg transaction
+func (s *Sink) Delete(ctx context.Context, n uint64) error {
+	if !s.hub.subscribed(s.ig) {
+		return nil
+	}
+	s.mut.Lock()
+	s.pending = append(s.pending, Row{
+		Src:      wctx.SrcName(ctx),
+		BlockNum: n,
+		Reorg:    true,
+	})
+	s.mut.Unlock()
+	return nil
+}
+
+func (s *Sink) Reset() {
+	s.mut.Lock()
+	s.pending = nil
+	s.mut.Unlock()
+}
+
+func (s *Sink) Flush(ctx context.Context, _ wpg.Conn) error {
+	s.mut.Lock()
+	rows := s.pending
+	s.pending = nil
+	s.mut.Unlock()
+	s.hub.Publish(s.ig, rows)
+	return nil
+}
+
+func blockNum(v any) uint64 {
+	n, _ := strconv.ParseUint(sink.Text(v), 10, 64)
+	return n
+}
+
+// Reads an integration's committed rows. An empty src reads
+// every source.
+type Store interface {
+	// Rows with block_num in [start, stop] in block order.
+	// A zero stop has no limit.
+	Rows(ctx context.Context, ig, src string, start, stop uint64, f func([]wpg.Column, [][]any) error) error
+	// The highest block_num or 0 when there are no rows
+	MaxBlock(ctx context.Context, ig, src string) (uint64, error)
+}
+
+// Blocks read from the [Store] per page of a replay. The
+// last page is read after subscribing so it's kept small
+// enough that the subscriber's buffer doesn't fill while
+// it's sent.
+const replayBlocks = 1000
+
+type Server struct {
+	hub   *Hub
+	store Store
+}
+
+func NewServer(hub *Hub, store Store) *Server {
+	return &Server{hub: hub, store: store}
+}
+
+// Registers the shovel.v1.Stream service
+func Register(gs *grpc.Server, s *Server) {
+	gs.RegisterService(&ServiceDesc, s)
+}
+
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "shovel.v1.Stream",
+	HandlerType: (*any)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Rows",
+		Handler:       rowsHandler,
+		ServerStreams: true,
+	}},
+	Metadata: "stream.proto",
+}
+
+func rowsHandler(srv any, ss grpc.ServerStream) error {
+	req := new(structpb.Struct)
+	if err := ss.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(*Server).Rows(req, ss)
+}
+
+// Request fields:
+//
+//	integration  name of the integration (required)
+//	src          only rows from this source
+//	start        first block. rows in pg are sent before new
+//	             rows. when omitted only new rows are sent
+//
+// Each response is a row keyed by column name. Rows written
+// by backfills are sent as they are committed so a row's
+// block_num may be lower than the previous row's. Backfilled
+// rows committed below the replay's position while it pages
+// through pg aren't sent.
+//
+// When a reorg deletes rows, the response is:
+//
+//	{"shovel_reorg": {"src_name": "...", "block_num": n}}
+//
+// and the subscriber should discard the source's rows with
+// block_num >= n. Their replacements follow.
+//
+// Subscribers that are disconnected should resume from
+// the block after the last one they received.
+func (s *Server) Rows(req *structpb.Struct, ss grpc.ServerStream) error {
+	var (
+		fields = req.GetFields()
+		ig     = fields["integration"].GetStringValue()
+		src    = fields["src"].GetStringValue()
+		ctx    = ss.Context()
+	)
+	if len(ig) == 0 {
+		return status.Error(codes.InvalidArgument, "missing integration")
+	}
+
+	// Pages are replayed before subscribing so that a long
+	// replay doesn't fill the subscriber's buffer. The
+	// remainder is replayed after subscribing so that no
+	// rows are missed while switching.
+	v, replay := fields["start"]
+	next := uint64(v.GetNumberValue())
+	for replay {
+		last, err := s.store.MaxBlock(ctx, ig, src)
+		if err != nil {
+			return err
+		}
+		if last < next || last-next < replayBlocks {
+			break
+		}
+		stop := next + replayBlocks - 1
+		if err := s.replay(ctx, ss, ig, src, next, stop, nil); err != nil {
+			return err
+		}
+		next = stop + 1
+	}
+
+	c, unsubscribe := s.hub.Subscribe(ig)
+	defer unsubscribe()
+
+	// last replayed block by source. published rows
+	// at or below it were in the replay's snapshot.
+	replayed := map[string]uint64{}
+	if replay {
+		if err := s.replay(ctx, ss, ig, src, next, 0, replayed); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case rows, ok := <-c:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "subscriber fell behind")
+			}
+			for _, r := range rows {
+				if len(src) > 0 && r.Src != src {
+					continue
+				}
+				if r.Reorg {
+					// the replacement rows weren't replayed
+					if n, ok := replayed[r.Src]; ok && r.BlockNum <= n {
+						delete(replayed, r.Src)
+						if r.BlockNum > 0 {
+							replayed[r.Src] = r.BlockNum - 1
+						}
+					}
+					if err := sendReorg(ss, r.Src, r.BlockNum); err != nil {
+						return err
+					}
+					continue
+				}
+				if n, ok := replayed[r.Src]; ok {
+					if r.BlockNum <= n {
+						continue
+					}
+					delete(replayed, r.Src)
+				}
+				if err := send(ss, r.Data); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// Sends rows with block_num in [start, stop] from the
+// [Store]. When replayed isn't nil, it's updated with
+// the last block sent for each source.
+func (s *Server) replay(ctx context.Context, ss grpc.ServerStream, ig, src string, start, stop uint64, replayed map[string]uint64) error {
+	return s.store.Rows(ctx, ig, src, start, stop, func(cols []wpg.Column, rows [][]any) error {
+		srcIdx, bnIdx := -1, -1
+		for i := range cols {
+			switch cols[i].Name {
+			case "src_name":
+				srcIdx = i
+			case "block_num":
+				bnIdx = i
+			}
+		}
+		for _, row := range rows {
+			b, err := sink.JSON(cols, row)
+			if err != nil {
+				return fmt.Errorf("encoding row: %w", err)
+			}
+			if err := send(ss, b); err != nil {
+				return err
+			}
+			if replayed != nil && srcIdx >= 0 && bnIdx >= 0 {
+				replayed[sink.Text(row[srcIdx])] = blockNum(row[bnIdx])
+			}
+		}
+		return nil
+	})
+}
+
+func sendReorg(ss grpc.ServerStream, src string, n uint64) error {
+	msg, err := structpb.NewStruct(map[string]any{
+		"shovel_reorg": map[string]any{
+			"src_name":  src,
+			"block_num": n,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("encoding reorg: %w", err)
+	}
+	return ss.SendMsg(msg)
+}
+
+func send(ss grpc.ServerStream, row json.RawMessage) error {
+	msg := new(structpb.Struct)
+	if err := protojson.Unmarshal(row, msg); err != nil {
+		return fmt.Errorf("decoding row: %w", err)
+	}
+	return ss.SendMsg(msg)
+}
@@ -0,0 +1,146 @@
+package stream
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/indexsupply/shovel/wctx"
+	"github.com/indexsupply/shovel/wpg"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/structpb"
+	"kr.dev/diff"
+)
+
+var testCols = []wpg.Column{
+	{Name: "src_name", Type: "text"},
+	{Name: "block_num", Type: "numeric"},
+	{Name: "v", Type: "bytea"},
+}
+
+type testStore struct {
+	rows [][]any
+
+	// stop of each Rows call
+	reads []uint64
+}
+
+func (ts *testStore) Rows(ctx context.Context, ig, src string, start, stop uint64, f func([]wpg.Column, [][]any) error) error {
+	ts.reads = append(ts.reads, stop)
+	var rows [][]any
+	for _, r := range ts.rows {
+		n := r[1].(uint64)
+		if n >= start && (stop == 0 || n <= stop) {
+			rows = append(rows, r)
+		}
+	}
+	return f(testCols, rows)
+}
+
+func (ts *testStore) MaxBlock(ctx context.Context, ig, src string) (uint64, error) {
+	var n uint64
+	for _, r := range ts.rows {
+		n = max(n, r[1].(uint64))
+	}
+	return n, nil
+}
+
+func rowsClient(t *testing.T, store Store, req map[string]any) (*Hub, func() map[string]any) {
+	var (
+		ctx = context.Background()
+		hub = NewHub()
+		gs  = grpc.NewServer()
+	)
+	Register(gs, NewServer(hub, store))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	diff.Test(t, t.Fatalf, err, nil)
+	go gs.Serve(ln)
+	t.Cleanup(gs.Stop)
+
+	cc, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	diff.Test(t, t.Fatalf, err, nil)
+	t.Cleanup(func() { cc.Close() })
+	cs, err := cc.NewStream(ctx, &ServiceDesc.Streams[0], "/shovel.v1.Stream/Rows")
+	diff.Test(t, t.Fatalf, err, nil)
+	msg, _ := structpb.NewStruct(req)
+	diff.Test(t, t.Fatalf, cs.SendMsg(msg), nil)
+	diff.Test(t, t.Fatalf, cs.CloseSend(), nil)
+
+	return hub, func() map[string]any {
+		msg := new(structpb.Struct)
+		diff.Test(t, t.Fatalf, cs.RecvMsg(msg), nil)
+		return msg.AsMap()
+	}
+}
+
+// waits for the server to subscribe after its replay
+func waitSubscribed(t *testing.T, hub *Hub, ig string) {
+	for i := 0; !hub.subscribed(ig); i++ {
+		if i == 100 {
+			t.Fatal("server didn't subscribe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRows(t *testing.T) {
+	ctx := context.Background()
+	hub, recv := rowsClient(t, &testStore{rows: [][]any{
+		{"main", uint64(1), []byte{0x01}},
+		{"main", uint64(2), []byte{0x02}},
+	}}, map[string]any{"integration": "foo", "start": 2})
+	diff.Test(t, t.Errorf, recv(), map[string]any{"src_name": "main", "block_num": 2.0, "v": "0x02"})
+
+	// the server subscribes before the last replay
+	// so block 2 was replayed and is skipped
+	waitSubscribed(t, hub, "foo")
+	s := hub.Sink("foo")
+	sctx := wctx.WithSrcName(ctx, "main")
+	rows := [][]any{
+		{"main", uint64(2), []byte{0x02}},
+		{"main", uint64(3), []byte{0x03}},
+	}
+	diff.Test(t, t.Fatalf, s.Send(sctx, testCols, rows), nil)
+	diff.Test(t, t.Fatalf, s.Flush(sctx, nil), nil)
+	diff.Test(t, t.Errorf, recv(), map[string]any{"src_name": "main", "block_num": 3.0, "v": "0x03"})
+}
+
+func TestRows_Pages(t *testing.T) {
+	store := &testStore{rows: [][]any{
+		{"main", uint64(1), []byte{0x01}},
+		{"main", uint64(1500), []byte{0x02}},
+		{"main", uint64(2500), []byte{0x03}},
+	}}
+	hub, recv := rowsClient(t, store, map[string]any{"integration": "foo", "start": 1})
+	diff.Test(t, t.Errorf, recv()["block_num"], 1.0)
+	diff.Test(t, t.Errorf, recv()["block_num"], 1500.0)
+	diff.Test(t, t.Errorf, recv()["block_num"], 2500.0)
+	waitSubscribed(t, hub, "foo")
+	diff.Test(t, t.Errorf, store.reads, []uint64{1000, 2000, 0})
+}
+
+func TestRows_Reorg(t *testing.T) {
+	ctx := context.Background()
+	hub, recv := rowsClient(t, &testStore{rows: [][]any{
+		{"main", uint64(1), []byte{0x01}},
+		{"main", uint64(2), []byte{0x02}},
+	}}, map[string]any{"integration": "foo", "start": 1})
+	diff.Test(t, t.Errorf, recv()["block_num"], 1.0)
+	diff.Test(t, t.Errorf, recv()["block_num"], 2.0)
+	waitSubscribed(t, hub, "foo")
+
+	// block 2 is replaced. its new row is sent even
+	// though block 2 was replayed.
+	s := hub.Sink("foo")
+	sctx := wctx.WithSrcName(ctx, "main")
+	diff.Test(t, t.Fatalf, s.Delete(sctx, 2), nil)
+	diff.Test(t, t.Fatalf, s.Send(sctx, testCols, [][]any{{"main", uint64(2), []byte{0x22}}}), nil)
+	diff.Test(t, t.Fatalf, s.Flush(sctx, nil), nil)
+	diff.Test(t, t.Errorf, recv(), map[string]any{
+		"shovel_reorg": map[string]any{"src_name": "main", "block_num": 2.0},
+	})
+	diff.Test(t, t.Errorf, recv(), map[string]any{"src_name": "main", "block_num": 2.0, "v": "0x22"})
+}
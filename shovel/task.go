@@ -4,27 +4,40 @@ import (
 	"bytes"
 	"cmp"
 	"context"
+	"crypto/rand"
 	"database/sql"
 	_ "embed"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
+	"net/url"
 	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/indexsupply/shovel/dig"
 	"github.com/indexsupply/shovel/eth"
+	"github.com/indexsupply/shovel/freezer"
 	"github.com/indexsupply/shovel/jrpc2"
 	"github.com/indexsupply/shovel/shovel/config"
 	"github.com/indexsupply/shovel/shovel/glf"
+	"github.com/indexsupply/shovel/shovel/sink"
+	"github.com/indexsupply/shovel/shovel/stream"
 	"github.com/indexsupply/shovel/wctx"
+	"github.com/indexsupply/shovel/wotel"
 	"github.com/indexsupply/shovel/wpg"
+	"github.com/indexsupply/shovel/wprom"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -111,9 +124,107 @@ func WithConcurrency(concurrency, batchSize int) Option {
 	}
 }
 
+// Adjusts batch size and concurrency within a's
+// bounds after each batch. See [tuner].
+func WithAdaptive(a *config.Adaptive) Option {
+	return func(t *Task) {
+		if a != nil {
+			t.tuner = newTuner(*a)
+		}
+	}
+}
+
+// Number of blocks that Converge will walk back
+// when the local chain doesn't match the source.
+func WithReorgDepth(n uint64) Option {
+	return func(t *Task) {
+		if n > 0 {
+			t.reorgDepth = n
+		}
+	}
+}
+
+// Indexes only [start, stop] and records progress under
+// [BackfillName] instead of the integration's name so that
+// the range can be indexed alongside a running task.
+func WithBackfill(start, stop uint64) Option {
+	return func(t *Task) {
+		t.start, t.stop = start, stop
+		t.backfill = true
+	}
+}
+
+// Set when the backfill's range may already have rows (see
+// [Backfill]) so that they're skipped instead of failing
+// the table's unique index.
+func WithOverlap(overlap bool) Option {
+	return func(t *Task) {
+		t.overlap = overlap
+	}
+}
+
+// When the task has no progress it starts at the source's
+// head instead of start and records [start, head) in
+// shovel.history. [Gaps] returns the range so that
+// [Manager.Heal] backfills it while the task follows the head.
+func WithHistory(start uint64) Option {
+	return func(t *Task) {
+		t.history = start
+	}
+}
+
+// Blocks in the ranges aren't loaded or inserted. The task's
+// progress moves past them with a task_updates row that
+// records the range's reason. Ranges must be sorted.
+func WithSkip(skip []config.SkipBlocks) Option {
+	return func(t *Task) {
+		t.skip = skip
+	}
+}
+
+// Checks each block's parent hash. See [Task.verify].
+func WithVerifyChain(verify bool) Option {
+	return func(t *Task) {
+		t.verifyChain = verify
+	}
+}
+
+// Tasks writing to the same database share a limit on the
+// number of converges in flight that shrinks while inserts
+// take longer than d. See [pressure].
+func WithMaxInsertLatency(d time.Duration) Option {
+	return func(t *Task) {
+		t.maxInsert = d
+	}
+}
+
+// Loads the next batch while the current batch is decoded
+// and inserted and splits decoding between n workers.
+// See [Task.prefetch].
+func WithDecodeWorkers(n int) Option {
+	return func(t *Task) {
+		if n > 0 {
+			t.decodeWorkers = n
+		}
+	}
+}
+
 var compiled = map[string]Destination{}
 
+// Every integration publishes its committed rows here.
+// See [stream.Server].
+var Streams = stream.NewHub()
+
 func NewDestination(ig config.Integration) (Destination, error) {
+	var sinks []dig.Sink
+	for _, sc := range ig.Sinks {
+		s, err := sink.New(ig.Name, ig.Table, sc)
+		if err != nil {
+			return nil, fmt.Errorf("building sink: %w", err)
+		}
+		sinks = append(sinks, s)
+	}
+	sinks = append(sinks, Streams.Sink(ig.Name))
 	switch {
 	case len(ig.Compiled.Name) > 0:
 		dest, ok := compiled[ig.Name]
@@ -121,11 +232,26 @@ func NewDestination(ig config.Integration) (Destination, error) {
 			return nil, fmt.Errorf("unable to find compiled integration: %s", ig.Name)
 		}
 		return dest, nil
+	case len(ig.Events) > 0:
+		var m dig.Multi
+		for _, ev := range ig.AllEvents() {
+			dest, err := dig.New(ig.Name, ev, dig.Function{}, ig.Block, ig.Table, ig.Notification, ig.FilterAGG)
+			if err != nil {
+				return nil, fmt.Errorf("building abi integration for %s: %w", ev.Name, err)
+			}
+			dest.Sinks = sinks
+			dest.OnError = dig.OnError(ig.OnError)
+			m = append(m, dest)
+		}
+		return m, nil
 	default:
-		dest, err := dig.New(ig.Name, ig.Event, ig.Block, ig.Table, ig.Notification, ig.FilterAGG)
+		dest, err := dig.New(ig.Name, ig.Event, ig.Function, ig.Block, ig.Table, ig.Notification, ig.FilterAGG)
 		if err != nil {
 			return nil, fmt.Errorf("building abi integration: %w", err)
 		}
+		dest.Sinks = sinks
+		dest.OnError = dig.OnError(ig.OnError)
+		dest.SetABI(ig.ABI)
 		return dest, nil
 	}
 }
@@ -136,13 +262,23 @@ func NewTask(opts ...Option) (*Task, error) {
 		pollDuration: time.Second,
 		batchSize:    1,
 		concurrency:  1,
+		reorgDepth:   1000,
 		destFactory:  NewDestination,
 	}
 	for _, opt := range opts {
 		opt(t)
 	}
-	t.dests = make([]Destination, t.concurrency)
-	for i := 0; i < t.concurrency; i++ {
+	t.ctx = wctx.WithPriority(t.ctx, t.destConfig.PriorityLevel())
+	if t.maxInsert > 0 {
+		t.pressure = poolPressure(t.pgp, t.maxInsert)
+	}
+	ndests := max(t.concurrency, t.decodeWorkers)
+	if t.tuner != nil {
+		ndests = max(ndests, t.tuner.maxConcurrency)
+		t.batchSize, t.concurrency = t.tuner.next(t.batchSize, t.concurrency, false, 0, nil)
+	}
+	t.dests = make([]Destination, ndests)
+	for i := 0; i < ndests; i++ {
 		dest, err := t.destFactory(t.destConfig)
 		if err != nil {
 			return nil, fmt.Errorf("initializing destination: %w", err)
@@ -150,10 +286,18 @@ func NewTask(opts ...Option) (*Task, error) {
 		t.dests[i] = dest
 	}
 	t.filter = t.dests[0].Filter()
+	if t.verifyChain && !t.filter.UseBlocks {
+		t.filter.UseHeaders = true
+	}
+	for _, bd := range t.destConfig.Block {
+		if bd.Name == "log_addr" && bd.Filter.Op == "contains" && len(bd.Filter.Ref.Table) > 0 {
+			t.addrRef = bd.Filter.Ref
+		}
+	}
 	t.lockid = wpg.LockHash(fmt.Sprintf(
 		"shovel-task-%s-%s",
 		t.srcName,
-		t.destConfig.Name,
+		t.progressName(),
 	))
 	_, err := t.pgp.Exec(t.ctx, fmt.Sprintf(
 		"set application_name = 'shovel-task-%s-%s-%s'",
@@ -177,8 +321,24 @@ type Task struct {
 	batchSize    int
 	concurrency  int
 	start, stop  uint64
+	reorgDepth   uint64
+	backfill     bool
+	overlap      bool
+	history      uint64
+	skip         []config.SkipBlocks
+	tuner        *tuner
+	maxInsert    time.Duration
+	pressure     *pressure
+	verifyChain  bool
+
+	decodeWorkers int
+	next          *prefetch
 
-	filter glf.Filter
+	filter  glf.Filter
+	addrRef dig.Ref
+	// see [Task.refreshAddresses]
+	addrKey  string
+	addrRead time.Time
 
 	src        Source
 	srcName    string
@@ -187,6 +347,51 @@ type Task struct {
 	dests       []Destination
 	destFactory func(config.Integration) (Destination, error)
 	destConfig  config.Integration
+
+	// integrations indexed by an atomic task. see [atomicTask]
+	members []string
+}
+
+// Identifies the (source, integration) pair that a task indexes.
+func (t *Task) id() string {
+	return t.srcName + "/" + t.destConfig.Name
+}
+
+// The ig_name used for the task's rows in task_updates
+func (t *Task) progressName() string {
+	if t.backfill {
+		return BackfillName(t.destConfig.Name, t.start, t.stop)
+	}
+	return t.destConfig.Name
+}
+
+// The ig_names whose progress the task records. An atomic
+// task records progress for each of its integrations.
+func (t *Task) progressNames() []string {
+	if len(t.members) > 0 {
+		return t.members
+	}
+	return []string{t.progressName()}
+}
+
+// Must match the name built by the query in [Gaps]
+func BackfillName(igName string, start, stop uint64) string {
+	return fmt.Sprintf("%s/backfill/%d-%d", igName, start, stop)
+}
+
+// Blocks until the source has a new head or the poll
+// duration elapses. Sources that push new heads (eg a
+// websocket subscription) allow tasks to skip the sleep.
+func (t *Task) wait() {
+	nh, ok := t.src.(interface{ NewHeads() <-chan struct{} })
+	if !ok {
+		time.Sleep(t.pollDuration)
+		return
+	}
+	select {
+	case <-nh.NewHeads():
+	case <-time.After(t.pollDuration):
+	}
 }
 
 func (t *Task) update(
@@ -215,30 +420,35 @@ func (t *Task) update(
 		)
 		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 	`
-	_, err := pg.Exec(t.ctx, uq,
-		t.srcChainID,
-		t.srcName,
-		t.destConfig.Name,
-		num,
-		hash,
-		srcNum,
-		srcHash,
-		t.stop,
-		nblocks,
-		nrows,
-		elapsed,
-	)
-	return err
+	for _, name := range t.progressNames() {
+		_, err := pg.Exec(t.ctx, uq,
+			t.srcChainID,
+			t.srcName,
+			name,
+			num,
+			hash,
+			srcNum,
+			srcHash,
+			t.stop,
+			nblocks,
+			nrows,
+			elapsed,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (t *Task) Delete(pg wpg.Conn, n uint64) error {
 	const q = `
 		delete from shovel.task_updates
 		where src_name = $1
-		and ig_name = $2
+		and ig_name = any($2)
 		and num >= $3
 	`
-	cmd, err := pg.Exec(t.ctx, q, t.srcName, t.destConfig.Name, n)
+	cmd, err := pg.Exec(t.ctx, q, t.srcName, t.progressNames(), n)
 	if err != nil {
 		return fmt.Errorf("deleting block from task table: %w", err)
 	}
@@ -246,6 +456,9 @@ func (t *Task) Delete(pg wpg.Conn, n uint64) error {
 	if err != nil {
 		return fmt.Errorf("deleting block: %w", err)
 	}
+	if err := t.stage(t.ctx, pg); err != nil {
+		return err
+	}
 	slog.InfoContext(t.ctx, "task-delete",
 		"n", n,
 		"task_updates", cmd.RowsAffected(),
@@ -285,8 +498,26 @@ func (t *Task) latestDependency(pg wpg.Conn) (uint64, []byte, error) {
 	}
 }
 
-func (t *Task) latest(ctx context.Context, pg wpg.Conn) (uint64, []byte, error) {
+// An atomic task's latest block is the lowest of its
+// integrations' latest blocks. See [group.Insert].
+// Called in the tx that commits the task's first update. A
+// failed attempt is replaced by the next attempt's head.
+func (t *Task) recordHistory(pg wpg.Conn, stop uint64) error {
 	const q = `
+		insert into shovel.history (src_name, ig_name, start, stop)
+		values ($1, $2, $3, $4)
+		on conflict (src_name, ig_name)
+		do update set start = excluded.start, stop = excluded.stop
+	`
+	if _, err := pg.Exec(t.ctx, q, t.srcName, t.destConfig.Name, t.history, stop); err != nil {
+		return fmt.Errorf("recording history: %w", err)
+	}
+	slog.InfoContext(t.ctx, "history", "start", t.history, "stop", stop)
+	return nil
+}
+
+func (t *Task) latest(ctx context.Context, pg wpg.Conn) (uint64, []byte, error) {
+	q := `
 		select num, hash
 		from shovel.task_updates
 		where src_name = $1
@@ -294,23 +525,55 @@ func (t *Task) latest(ctx context.Context, pg wpg.Conn) (uint64, []byte, error)
 		order by num desc
 		limit 1
 	`
+	var arg any = t.progressName()
+	if len(t.members) > 0 {
+		q = `
+			with latest as (
+				select distinct on (ig_name)
+				ig_name, num, hash
+				from shovel.task_updates
+				where src_name = $1
+				and ig_name = any($2)
+				order by ig_name, num desc
+			)
+			select num, hash
+			from latest
+			order by num asc
+			limit 1
+		`
+		arg = t.members
+	}
 	localNum, localHash := uint64(0), []byte{}
 	err := pg.QueryRow(
 		t.ctx,
 		q,
 		t.srcName,
-		t.destConfig.Name,
+		arg,
 	).Scan(&localNum, &localHash)
 	switch {
 	case errors.Is(err, pgx.ErrNoRows):
+		start := t.start
+		if t.history > 0 {
+			head, _, err := t.src.Latest(ctx, t.src.NextURL().String(), 0)
+			if err != nil {
+				return 0, nil, err
+			}
+			start = t.history
+			if head > t.history {
+				if err := t.recordHistory(pg, head-1); err != nil {
+					return 0, nil, err
+				}
+				start = head
+			}
+		}
 		switch {
-		case t.start > 0:
-			n := t.start - 1
+		case start > 0:
+			n := start - 1
 			h, err := t.src.Hash(ctx, t.src.NextURL().String(), n)
 			if err != nil {
 				return 0, nil, fmt.Errorf("getting hash for %d: %w", n, err)
 			}
-			slog.InfoContext(t.ctx, "start at config", "num", t.start)
+			slog.InfoContext(t.ctx, "start at config", "num", start)
 			return n, h, nil
 		default:
 			n, _, err := t.src.Latest(ctx, t.src.NextURL().String(), 0)
@@ -331,6 +594,19 @@ func (t *Task) latest(ctx context.Context, pg wpg.Conn) (uint64, []byte, error)
 	}
 }
 
+var (
+	mBlocks = wprom.NewCounter("shovel_blocks_total", "number of blocks processed", "src", "ig")
+	mRows   = wprom.NewCounter("shovel_rows_total", "number of rows inserted", "src", "ig")
+	mReorgs = wprom.NewCounter("shovel_reorgs_total", "number of reorgs detected", "src", "ig")
+	mErrors = wprom.NewCounter("shovel_task_errors_total", "number of failed converge attempts", "src", "ig")
+	mLag    = wprom.NewGauge("shovel_task_lag", "number of blocks between the source and the task", "src", "ig")
+	mLoad   = wprom.NewSecondsCounter("shovel_load_seconds_total", "time spent loading blocks from the source", "src", "ig")
+	mDecode = wprom.NewSecondsCounter("shovel_decode_seconds_total", "time spent decoding blocks into rows", "src", "ig")
+	mInsert = wprom.NewSecondsCounter("shovel_insert_seconds_total", "time spent writing rows", "src", "ig")
+
+	mThrottle = wprom.NewSecondsCounter("shovel_throttle_seconds_total", "time spent waiting for the database to catch up", "src", "ig")
+)
+
 var (
 	ErrNothingNew = errors.New("no new blocks")
 	ErrReorg      = errors.New("reorg")
@@ -342,24 +618,49 @@ var (
 // If pg contains an invalid latest block (ie reorg) then [ErrReorg]
 // is returned and the caller may rollback the transaction resulting
 // in no side-effects.
-func (task *Task) Converge() error {
+func (task *Task) Converge() (err error) {
 	var (
-		ctx     = task.ctx
 		t0      = time.Now()
 		nextURL = task.src.NextURL()
 		url     = nextURL.String()
 		nrpc    = uint64(0)
 	)
+	ctx, span := wotel.Tracer.Start(task.ctx, "converge", trace.WithAttributes(
+		attribute.String("src", task.srcName),
+		attribute.String("ig", task.destConfig.Name),
+		attribute.String("host", nextURL.Hostname()),
+	))
+	defer func() {
+		if errors.Is(err, ErrNothingNew) {
+			span.End()
+			return
+		}
+		wotel.End(span, err)
+	}()
 	ctx = wctx.WithSrcHost(ctx, nextURL.Hostname())
 	ctx = wctx.WithCounter(ctx, &nrpc)
+	ctx = wctx.WithReqID(ctx, reqID(span))
 
+	if err := task.yield(ctx); err != nil {
+		return err
+	}
+	var insertTime time.Duration
+	if task.pressure != nil {
+		tw := time.Now()
+		if err := task.pressure.acquire(ctx); err != nil {
+			return err
+		}
+		mThrottle.AddDuration(time.Since(tw), task.srcName, task.destConfig.Name)
+		defer func() { task.pressure.release(insertTime) }()
+	}
 	pgtx, err := task.pgp.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("unable to start tx: %w", err)
 	}
 	defer pgtx.Rollback(ctx)
 
-	for reorgs := 0; reorgs <= 1000; reorgs++ {
+	var reorg *reorgPayload
+	for reorgs := uint64(0); reorgs <= task.reorgDepth; reorgs++ {
 		localNum, localHash, err := task.latest(ctx, pgtx)
 		if err != nil {
 			return fmt.Errorf("getting latest from task: %w", err)
@@ -412,13 +713,51 @@ func (task *Task) Converge() error {
 		if delta == 0 {
 			return ErrNothingNew
 		}
+		if sb, ok := task.skipped(localNum + 1); ok {
+			return task.skipBlocks(ctx, pgtx, sb, localNum, targetNum, targetHash)
+		}
+		delta = task.beforeSkip(localNum, delta)
+		if err := task.refreshAddresses(ctx, pgtx); err != nil {
+			return fmt.Errorf("refreshing filter addresses: %w", err)
+		}
 		ctx = wctx.WithNumLimit(ctx, localNum+1, delta)
-		blocks, err := task.load(ctx, url, localHash, localNum+1, delta)
+		ctx = wctx.WithBackfill(ctx, targetNum-localNum-delta >= uint64(task.batchSize))
+		ctx = wctx.WithOverlap(ctx, task.overlap)
+		span.SetAttributes(
+			attribute.Int64("start", int64(localNum+1)),
+			attribute.Int64("limit", int64(delta)),
+		)
+		tl := time.Now()
+		blocks, n := task.prefetched(ctx, localNum+1, delta)
+		switch {
+		case n > 0:
+			delta = n
+		default:
+			blocks, err = task.load(ctx, url, localHash, localNum+1, delta, task.batchSize, task.concurrency)
+		}
+		loadTime := time.Since(tl)
+		if !errors.Is(err, ErrReorg) {
+			task.tune(ctx, delta == uint64(task.batchSize), loadTime, err)
+		}
+		if errors.Is(err, ErrReorg) && task.backfill {
+			// Deleting would remove rows indexed by the
+			// integration's main task past the range.
+			return fmt.Errorf("backfill range is not final: %w", err)
+		}
 		if errors.Is(err, ErrReorg) {
+			mReorgs.Inc(task.srcName, task.destConfig.Name)
 			slog.ErrorContext(ctx, "reorg",
 				"n", localNum,
 				"h", fmt.Sprintf("%.4x", localHash),
 			)
+			if reorg == nil {
+				reorg = &reorgPayload{
+					Src:     task.srcName,
+					IG:      task.destConfig.Name,
+					OldNum:  localNum,
+					OldHash: eth.EncodeHex(localHash),
+				}
+			}
 			if err := task.Delete(pgtx, localNum); err != nil {
 				return fmt.Errorf("deleting during reorg: %w", err)
 			}
@@ -427,28 +766,54 @@ func (task *Task) Converge() error {
 		if err != nil {
 			return fmt.Errorf("loading data: %w", err)
 		}
+		if reorg != nil {
+			last := blocks[len(blocks)-1]
+			reorg.Ancestor = localNum
+			reorg.NewNum = last.Num()
+			reorg.NewHash = eth.EncodeHex(last.Hash())
+			if err := notifyReorg(ctx, pgtx, reorg); err != nil {
+				return fmt.Errorf("notifying reorg: %w", err)
+			}
+		}
 		if err := pgtx.Commit(ctx); err != nil {
 			return fmt.Errorf("comitting task_updates tx: %w", err)
 		}
+		last := blocks[len(blocks)-1]
+		task.prefetch(ctx, url, last.Num(), last.Hash(), targetNum)
 
-		pgtx, err = task.pgp.Begin(ctx)
-		if err != nil {
-			return fmt.Errorf("starting insert pg tx: %w", err)
-		}
-		nrows, err := task.insert(ctx, pgtx, blocks)
-		if err != nil {
-			pgtx.Rollback(ctx)
-			return fmt.Errorf("inserting data: %w", err)
+		var (
+			ti     = time.Now()
+			tm     = new(dig.Timing)
+			nrows  int64
+			update = func(pg wpg.Conn, nrows int64) error {
+				return task.update(pg, last.Num(), last.Hash(), targetNum, targetHash, delta, nrows, time.Since(t0))
+			}
+		)
+		switch {
+		case delta == 1 && !wctx.Backfill(ctx):
+			nrows, err = task.writePipelined(dig.WithTiming(ctx, tm), blocks, update)
+		default:
+			nrows, err = task.write(dig.WithTiming(ctx, tm), blocks, update)
 		}
-		last := blocks[len(blocks)-1]
-		err = task.update(pgtx, last.Num(), last.Hash(), targetNum, targetHash, delta, nrows, time.Since(t0))
 		if err != nil {
-			pgtx.Rollback(ctx)
-			return fmt.Errorf("updating task: %w", err)
-		}
-		if err := pgtx.Commit(ctx); err != nil {
-			return fmt.Errorf("committing task tx: %w", err)
+			return err
 		}
+		insertTime = time.Since(ti)
+		task.flush(ctx)
+		mBlocks.Add(delta, task.srcName, task.destConfig.Name)
+		mRows.Add(uint64(nrows), task.srcName, task.destConfig.Name)
+		mLag.Set(wprom.Sub(gethNum, last.Num()), task.srcName, task.destConfig.Name)
+		mLoad.AddDuration(loadTime, task.srcName, task.destConfig.Name)
+		mDecode.AddDuration(tm.Decode(), task.srcName, task.destConfig.Name)
+		mInsert.AddDuration(tm.Write(), task.srcName, task.destConfig.Name)
+		record(task.srcName, task.destConfig.Name, sample{
+			t:       time.Now(),
+			nblocks: delta,
+			nrows:   nrows,
+			load:    loadTime,
+			decode:  tm.Decode(),
+			insert:  tm.Write(),
+		})
 		slog.InfoContext(ctx, "converge",
 			"n", last.Num(),
 			"h", fmt.Sprintf("%.4x", last.Hash()),
@@ -459,7 +824,262 @@ func (task *Task) Converge() error {
 		)
 		return nil
 	}
-	return ErrReorg
+	slog.ErrorContext(ctx, "reorg-depth-exceeded", "depth", task.reorgDepth)
+	return fmt.Errorf("reorg deeper than %d blocks: %w", task.reorgDepth, ErrReorg)
+}
+
+// Low priority tasks don't start a Converge while every
+// connection in the pool is in use so that other tasks
+// aren't queued behind them for connections.
+func (t *Task) yield(ctx context.Context) error {
+	if wctx.Priority(ctx) >= 0 {
+		return nil
+	}
+	for {
+		s := t.pgp.Stat()
+		if s.AcquiredConns() < s.MaxConns() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// The skip range containing n
+func (t *Task) skipped(n uint64) (config.SkipBlocks, bool) {
+	for _, sb := range t.skip {
+		if sb.Start <= n && n <= sb.Stop {
+			return sb, true
+		}
+	}
+	return config.SkipBlocks{}, false
+}
+
+// Shortens delta so that the batch ends before the next skip range
+func (t *Task) beforeSkip(localNum, delta uint64) uint64 {
+	for _, sb := range t.skip {
+		if sb.Start > localNum && sb.Start <= localNum+delta {
+			return sb.Start - localNum - 1
+		}
+	}
+	return delta
+}
+
+// Records progress through the skipped range (up to the target)
+// without loading its blocks. The last block's hash is requested
+// so that the next batch can be checked for a reorg. When the
+// source can't provide it the check is skipped for that batch.
+func (t *Task) skipBlocks(
+	ctx context.Context,
+	pgtx pgx.Tx,
+	sb config.SkipBlocks,
+	localNum, targetNum uint64,
+	targetHash []byte,
+) error {
+	stop := min(sb.Stop, targetNum)
+	if t.stop > 0 {
+		stop = min(stop, t.stop)
+	}
+	hash, err := t.src.Hash(ctx, t.src.NextURL().String(), stop)
+	if err != nil {
+		slog.WarnContext(ctx, "skip-hash", "n", stop, "error", err)
+		hash = nil
+	}
+	const q = `
+		insert into shovel.task_updates (
+			chain_id,
+			src_name,
+			ig_name,
+			num,
+			hash,
+			src_num,
+			src_hash,
+			stop,
+			nblocks,
+			nrows,
+			skip_reason
+		)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, 0, $10)
+	`
+	for _, name := range t.progressNames() {
+		_, err := pgtx.Exec(ctx, q,
+			t.srcChainID,
+			t.srcName,
+			name,
+			stop,
+			hash,
+			targetNum,
+			targetHash,
+			t.stop,
+			stop-localNum,
+			sb.Reason,
+		)
+		if err != nil {
+			return fmt.Errorf("recording skip: %w", err)
+		}
+	}
+	if err := pgtx.Commit(ctx); err != nil {
+		return fmt.Errorf("committing skip: %w", err)
+	}
+	slog.WarnContext(ctx, "skip",
+		"start", localNum+1,
+		"stop", stop,
+		"reason", sb.Reason,
+	)
+	return nil
+}
+
+// The span's trace id when tracing is enabled
+// so that logs can be matched with traces
+func reqID(span trace.Span) string {
+	if sc := span.SpanContext(); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (t *Task) tune(ctx context.Context, full bool, elapsed time.Duration, err error) {
+	if t.tuner == nil {
+		return
+	}
+	if t.pressure != nil && t.pressure.high() {
+		// the database can't keep up with larger batches
+		full = false
+	}
+	batch, conc := t.tuner.next(t.batchSize, t.concurrency, full, elapsed, err)
+	if batch == t.batchSize && conc == t.concurrency {
+		return
+	}
+	slog.DebugContext(ctx, "tune",
+		"batch_size", batch,
+		"concurrency", conc,
+		"elapsed", elapsed,
+	)
+	t.batchSize, t.concurrency = batch, conc
+}
+
+// Sent on the shovel_reorg channel once a reorg is resolved.
+// Rows with block_num > ancestor were deleted from the
+// integration's table and replaced by the new chain.
+type reorgPayload struct {
+	Src      string `json:"src"`
+	IG       string `json:"ig"`
+	Ancestor uint64 `json:"ancestor"`
+	OldNum   uint64 `json:"old_num"`
+	OldHash  string `json:"old_hash"`
+	NewNum   uint64 `json:"new_num"`
+	NewHash  string `json:"new_hash"`
+}
+
+// The notification is transactional and is only delivered
+// if the transaction that deleted the rows commits.
+func notifyReorg(ctx context.Context, pg wpg.Conn, p *reorgPayload) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("encoding payload: %w", err)
+	}
+	if _, err := pg.Exec(ctx, `select pg_notify('shovel_reorg', $1)`, string(b)); err != nil {
+		return fmt.Errorf("sending pg notification: %w", err)
+	}
+	return nil
+}
+
+// When log_addr uses a filter_ref, the addresses are read
+// from the referenced table before each batch so that
+// eth_getLogs is limited to addresses known at that point
+// (eg pools created by a factory integration). When the
+// table is empty the request isn't limited by address
+// and rows are filtered by [dig.Filter.Accept] instead.
+// Reads the filter_ref's addresses into the task's log filter.
+// The addresses of an integration's table are re-read when the
+// integration's progress changes (see [refProgress]). Other
+// tables are re-read every [dig.Ref] Refresh or before each
+// batch when Refresh is empty.
+func (t *Task) refreshAddresses(ctx context.Context, pg wpg.Conn) error {
+	if len(t.addrRef.Table) == 0 {
+		return nil
+	}
+	var key string
+	switch {
+	case len(t.addrRef.Integration) > 0:
+		var err error
+		key, err = refProgress(ctx, pg, t.addrRef.Integration)
+		if err != nil {
+			return fmt.Errorf("checking %s progress: %w", t.addrRef.Integration, err)
+		}
+		if !t.addrRead.IsZero() && key == t.addrKey {
+			return nil
+		}
+	default:
+		d, _ := time.ParseDuration(t.addrRef.Refresh) // validated
+		if !t.addrRead.IsZero() && time.Since(t.addrRead) < d {
+			return nil
+		}
+	}
+	q := fmt.Sprintf(
+		"select distinct %s from %s where %s is not null",
+		t.addrRef.Column,
+		t.addrRef.Table,
+		t.addrRef.Column,
+	)
+	rows, err := pg.Query(ctx, q)
+	if err != nil {
+		return fmt.Errorf("querying %s: %w", t.addrRef.Table, err)
+	}
+	addrs, err := pgx.CollectRows(rows, func(r pgx.CollectableRow) (string, error) {
+		var v any
+		if err := r.Scan(&v); err != nil {
+			return "", err
+		}
+		return refAddress(v)
+	})
+	if err != nil {
+		return fmt.Errorf("scanning %s.%s: %w", t.addrRef.Table, t.addrRef.Column, err)
+	}
+	slices.Sort(addrs)
+	t.filter.SetAddresses(addrs)
+	t.addrKey, t.addrRead = key, time.Now()
+	slog.DebugContext(ctx, "refresh-addresses", "n", len(addrs))
+	return nil
+}
+
+// Identifies the latest block of each of the integration's
+// tasks and backfills. The integration's table only changes
+// when its progress changes, including when a reorg removes
+// blocks that are indexed again with other hashes.
+func refProgress(ctx context.Context, pg wpg.Conn, igName string) (string, error) {
+	const q = `
+		select coalesce(string_agg(
+			concat(ig_name, '/', src_name, '/', num, '/', encode(hash, 'hex')),
+			',' order by ig_name, src_name
+		), '')
+		from (
+			select distinct on (ig_name, src_name) ig_name, src_name, num, hash
+			from shovel.task_updates
+			where ig_name = $1 or starts_with(ig_name, $2)
+			order by ig_name, src_name, num desc
+		) t
+	`
+	var key string
+	err := pg.QueryRow(ctx, q, igName, igName+"/backfill/").Scan(&key)
+	return key, err
+}
+
+// Returns the address read from a filter_ref column. The
+// column must be bytea since logs are filtered by comparing
+// their address to the column's values (see
+// [dig.Filter.Accept]).
+func refAddress(v any) (string, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return "", fmt.Errorf("column must be bytea. got: %T", v)
+	}
+	return eth.EncodeHex(b), nil
 }
 
 func (t *Task) load(
@@ -467,16 +1087,19 @@ func (t *Task) load(
 	url string,
 	localHash []byte,
 	start, limit uint64,
+	batchSize, concurrency int,
 ) ([]eth.Block, error) {
 	var (
 		t0   = time.Now()
 		eg   errgroup.Group
-		part = t.batchSize / t.concurrency
+		part = batchSize / concurrency
 
 		blocksMut sync.Mutex
 		blocks    []eth.Block
 	)
-	for i := 0; i < t.concurrency; i++ {
+	ctx, span := wotel.Tracer.Start(ctx, "load")
+	defer span.End()
+	for i := 0; i < concurrency; i++ {
 		i := i
 		m := start + uint64(i*part)
 		n := min(uint64(part), limit-uint64(i*part))
@@ -497,13 +1120,20 @@ func (t *Task) load(
 		})
 	}
 	if err := eg.Wait(); err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 	slices.SortFunc(blocks, func(a, b eth.Block) int {
 		return cmp.Compare(a.Num(), b.Num())
 	})
+	if t.verifyChain {
+		if err := t.verify(ctx, localHash, blocks); err != nil {
+			return nil, err
+		}
+	}
 	first, last := blocks[0], blocks[len(blocks)-1]
-	if len(first.Header.Parent) == 32 && !bytes.Equal(localHash, first.Header.Parent) {
+	// localHash is empty after a skip range whose hash was unavailable
+	if len(localHash) > 0 && len(first.Header.Parent) == 32 && !bytes.Equal(localHash, first.Header.Parent) {
 		return nil, ErrReorg
 	}
 	slog.DebugContext(ctx, "load",
@@ -515,36 +1145,256 @@ func (t *Task) load(
 	return blocks, nil
 }
 
-func (t *Task) insert(
+// Inserts the blocks and records the task's progress
+// in a pg tx
+func (t *Task) write(
 	ctx context.Context,
-	pg wpg.Conn,
 	blocks []eth.Block,
+	update func(wpg.Conn, int64) error,
 ) (int64, error) {
-	var (
-		t0    = time.Now()
-		nrows int64
-		eg    errgroup.Group
-		pgmut sync.Mutex
-	)
-	for i := 0; i < len(blocks); i += t.batchSize {
-		i := i
-		n := i + t.batchSize
-		if n > len(blocks) {
-			n = len(blocks)
-		}
-		eg.Go(func() error {
-			ctx = wctx.WithNumLimit(ctx, uint64(i), uint64(n))
-			nr, err := t.dests[i].Insert(ctx, &pgmut, pg, blocks[i:n])
-			if err != nil {
-				return fmt.Errorf("inserting blocks: %w", err)
-			}
-			atomic.AddInt64(&nrows, nr)
-			return nil
-		})
+	pgtx, err := t.pgp.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("starting insert pg tx: %w", err)
 	}
-	if err := eg.Wait(); err != nil {
-		return 0, err
+	defer pgtx.Rollback(ctx)
+	nrows, err := t.insert(ctx, pgtx, blocks)
+	if err != nil {
+		return 0, fmt.Errorf("inserting data: %w", err)
+	}
+	if err := t.staleExports(ctx, pgtx, blocks, nrows); err != nil {
+		return 0, fmt.Errorf("updating exports: %w", err)
+	}
+	if err := t.stage(ctx, pgtx); err != nil {
+		return 0, err
+	}
+	if err := update(pgtx, nrows); err != nil {
+		return 0, fmt.Errorf("updating task: %w", err)
+	}
+	if err := pgtx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("committing task tx: %w", err)
+	}
+	return nrows, nil
+}
+
+// Near the head a batch is usually a block with a few rows
+// so the time to write it is mostly round trips. Using a
+// [wpg.Pipeline], begin is sent with the inserts and the
+// task update is sent with commit.
+func (t *Task) writePipelined(
+	ctx context.Context,
+	blocks []eth.Block,
+	update func(wpg.Conn, int64) error,
+) (int64, error) {
+	conn, err := t.pgp.Acquire(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("acquiring insert pg conn: %w", err)
+	}
+	defer conn.Release()
+	var (
+		p     = wpg.NewPipeline(conn)
+		nrows int64
+	)
+	err = func() error {
+		p.Exec(ctx, "begin")
+		n, err := t.insert(ctx, p, blocks)
+		if err != nil {
+			return fmt.Errorf("inserting data: %w", err)
+		}
+		if err := p.Flush(ctx); err != nil {
+			return fmt.Errorf("inserting data: %w", err)
+		}
+		nrows = n + p.RowsAffected()
+		if err := t.staleExports(ctx, p, blocks, nrows); err != nil {
+			return fmt.Errorf("updating exports: %w", err)
+		}
+		// after nrows since the pipeline counts the outbox's rows
+		if err := t.stage(ctx, p); err != nil {
+			return err
+		}
+		if err := update(p, nrows); err != nil {
+			return fmt.Errorf("updating task: %w", err)
+		}
+		p.Exec(ctx, "commit")
+		if err := p.Flush(ctx); err != nil {
+			return fmt.Errorf("committing task tx: %w", err)
+		}
+		return nil
+	}()
+	if err != nil {
+		// the conn is returned to the pool so the
+		// tx mustn't be left open
+		if _, rerr := conn.Exec(context.Background(), "rollback"); rerr != nil {
+			conn.Conn().Close(context.Background())
+		}
+		return 0, err
+	}
+	return nrows, nil
+}
+
+// Rows written into a range that was exported (eg by a
+// backfill or [Manager.Heal]) remove the range's export so
+// that [ExportIntegration] exports it again.
+func (t *Task) staleExports(ctx context.Context, pg wpg.Conn, blocks []eth.Block, nrows int64) error {
+	if nrows == 0 || t.destConfig.Export.Empty() {
+		return nil
+	}
+	const q = `
+		delete from shovel.exports
+		where src_name = $1 and ig_name = $2
+		and start <= $4 and stop >= $3
+	`
+	_, err := pg.Exec(ctx, q,
+		t.srcName,
+		t.destConfig.Name,
+		blocks[0].Num(),
+		blocks[len(blocks)-1].Num(),
+	)
+	return err
+}
+
+// A batch loaded ahead of the converge that indexes it
+type prefetch struct {
+	start, limit uint64
+	done         chan struct{}
+	blocks       []eth.Block
+	err          error
+}
+
+// When the task has decode workers and is backfilling, the
+// batch after last is loaded while the current batch is
+// inserted. Tasks whose addresses are read from a table
+// (see [Task.refreshAddresses]) don't prefetch since the
+// current batch may add addresses.
+func (t *Task) prefetch(ctx context.Context, url string, last uint64, hash []byte, target uint64) {
+	t.next = nil
+	switch {
+	case t.decodeWorkers == 0:
+		return
+	case !wctx.Backfill(ctx):
+		return
+	case len(t.addrRef.Table) > 0:
+		return
+	case target <= last:
+		return
+	}
+	if _, ok := t.skipped(last + 1); ok {
+		return
+	}
+	limit := t.beforeSkip(last, min(target-last, uint64(t.batchSize)))
+	if limit == 0 {
+		return
+	}
+	var (
+		p = &prefetch{start: last + 1, limit: limit, done: make(chan struct{})}
+		// tune may change these before the load finishes
+		batchSize, concurrency = t.batchSize, t.concurrency
+	)
+	go func() {
+		defer close(p.done)
+		ctx := wctx.WithNumLimit(ctx, p.start, p.limit)
+		p.blocks, p.err = t.load(ctx, url, hash, p.start, p.limit, batchSize, concurrency)
+	}()
+	t.next = p
+}
+
+// Returns the prefetched blocks and their number when
+// they start at start and don't exceed limit. A failed
+// prefetch (eg a reorg) is discarded and the caller
+// loads the batch itself.
+func (t *Task) prefetched(ctx context.Context, start, limit uint64) ([]eth.Block, uint64) {
+	p := t.next
+	t.next = nil
+	if p == nil || p.start != start || p.limit > limit {
+		return nil, 0
 	}
+	select {
+	case <-ctx.Done():
+		return nil, 0
+	case <-p.done:
+	}
+	if p.err != nil {
+		slog.DebugContext(ctx, "discarding prefetch", "error", p.err)
+		return nil, 0
+	}
+	return p.blocks, p.limit
+}
+
+// Implemented by destinations with sinks that
+// deliver rows after the insert commits.
+type flusher interface {
+	Flush(context.Context, wpg.Conn) error
+	Reset()
+}
+
+// Implemented by destinations with sinks that write
+// to pg in the insert's transaction. See [dig.Stager].
+type stager interface {
+	Stage(context.Context, wpg.Conn) error
+}
+
+func (t *Task) flush(ctx context.Context) {
+	for _, d := range t.dests {
+		f, ok := d.(flusher)
+		if !ok {
+			continue
+		}
+		if err := f.Flush(ctx, t.pgp); err != nil {
+			slog.ErrorContext(ctx, "flush-sinks", "error", err)
+		}
+	}
+}
+
+func (t *Task) stage(ctx context.Context, pg wpg.Conn) error {
+	for _, d := range t.dests {
+		if s, ok := d.(stager); ok {
+			if err := s.Stage(ctx, pg); err != nil {
+				return fmt.Errorf("staging sinks: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Task) insert(
+	ctx context.Context,
+	pg wpg.Conn,
+	blocks []eth.Block,
+) (int64, error) {
+	for _, d := range t.dests {
+		if f, ok := d.(flusher); ok {
+			f.Reset()
+		}
+	}
+	var (
+		t0    = time.Now()
+		nrows int64
+		eg    errgroup.Group
+		pgmut sync.Mutex
+		// Each worker decodes its part using its own
+		// destination. Writes are serialized by pgmut.
+		workers = max(1, t.decodeWorkers)
+		part    = (len(blocks) + workers - 1) / workers
+	)
+	ctx, span := wotel.Tracer.Start(ctx, "insert")
+	defer span.End()
+	for i, k := 0, 0; i < len(blocks); i, k = i+part, k+1 {
+		i, k := i, k
+		n := min(i+part, len(blocks))
+		eg.Go(func() error {
+			ctx = wctx.WithNumLimit(ctx, blocks[i].Num(), uint64(n-i))
+			nr, err := t.dests[k].Insert(ctx, &pgmut, pg, blocks[i:n])
+			if err != nil {
+				return fmt.Errorf("inserting blocks: %w", err)
+			}
+			atomic.AddInt64(&nrows, nr)
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+	span.SetAttributes(attribute.Int64("nrows", nrows))
 	last := blocks[len(blocks)-1]
 	slog.DebugContext(ctx, "insert",
 		"n", last.Num(),
@@ -579,6 +1429,351 @@ func PruneTask(ctx context.Context, pg wpg.Conn, n int) error {
 	return nil
 }
 
+// Deletes rows outside of the integration's retention window.
+// When the table is partitioned and not shared with another
+// integration, whole partitions are dropped instead.
+func PruneIntegration(ctx context.Context, pg wpg.Conn, ig config.Integration, shared bool) error {
+	if ig.Retention.Empty() {
+		return nil
+	}
+	var (
+		ctx2      = wctx.WithIGName(ctx, ig.Name)
+		partition = ig.Table.PartitionSize > 0 && !shared
+		before    = uint64(math.MaxUint64)
+	)
+	for _, sc := range ig.Sources {
+		n, err := pruneBefore(ctx2, pg, ig, sc.Name)
+		if err != nil {
+			return fmt.Errorf("finding cutoff for %s: %w", sc.Name, err)
+		}
+		before = min(before, n)
+		if n == 0 || partition {
+			continue
+		}
+		var ndeleted int64
+		for {
+			const q = `
+				delete from %s
+				where src_name = $1 and ig_name = $2 and block_num < $3
+				and (tableoid, ctid) in (
+					select tableoid, ctid from %s
+					where src_name = $1 and ig_name = $2 and block_num < $3
+					limit 10000
+				)
+			`
+			cmd, err := pg.Exec(ctx2, fmt.Sprintf(q, ig.Table.QName(), ig.Table.QName()), sc.Name, ig.Name, n)
+			if err != nil {
+				return fmt.Errorf("deleting from %s: %w", ig.Table.Name, err)
+			}
+			ndeleted += cmd.RowsAffected()
+			if cmd.RowsAffected() < 10000 {
+				break
+			}
+		}
+		slog.InfoContext(ctx2, "prune", "src", sc.Name, "before", n, "n", ndeleted)
+	}
+	if !partition || before == 0 || before == math.MaxUint64 {
+		return nil
+	}
+	dropped, err := ig.Table.DropPartitions(ctx2, pg, before)
+	if err != nil {
+		return fmt.Errorf("dropping partitions: %w", err)
+	}
+	if len(dropped) > 0 {
+		slog.InfoContext(ctx2, "prune", "before", before, "dropped", dropped)
+	}
+	return nil
+}
+
+// Returns the block number below which rows are pruned
+// or 0 when nothing is outside of the retention window.
+func pruneBefore(ctx context.Context, pg wpg.Conn, ig config.Integration, srcName string) (uint64, error) {
+	var n uint64
+	switch {
+	case ig.Retention.Blocks > 0:
+		const q = `
+			select coalesce(max(num), 0)
+			from shovel.task_updates
+			where src_name = $1 and ig_name = $2
+		`
+		var latest uint64
+		if err := pg.QueryRow(ctx, q, srcName, ig.Name).Scan(&latest); err != nil {
+			return 0, err
+		}
+		if latest > ig.Retention.Blocks {
+			n = latest - ig.Retention.Blocks + 1
+		}
+	case ig.Retention.Days > 0:
+		var (
+			col    = ig.BlockTimeColumn()
+			cutoff = time.Now().Add(-time.Duration(ig.Retention.Days) * 24 * time.Hour)
+			arg    any
+		)
+		arg = cutoff.Unix()
+		for _, c := range ig.Table.Columns {
+			if c.Name == col && strings.HasPrefix(c.Type, "timestamp") {
+				arg = cutoff
+			}
+		}
+		q := fmt.Sprintf(`
+			select coalesce(max(block_num), -1) + 1
+			from %s
+			where src_name = $1 and ig_name = $2 and %s < $3
+		`, ig.Table.QName(), col)
+		if err := pg.QueryRow(ctx, q, srcName, ig.Name, arg).Scan(&n); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// A range of blocks, inclusive, that a task skipped
+type Gap struct {
+	SrcName string
+	IGName  string
+	Start   uint64
+	Stop    uint64
+}
+
+// Finds ranges missing between consecutive task_updates.
+// Each update covers the nblocks preceding (and including) num.
+// Ranges recorded by tasks using [WithHistory] are included.
+// Gaps that were repaired by a backfill are not returned.
+// Only the history kept by [PruneTask] is scanned.
+func Gaps(ctx context.Context, pg wpg.Conn) ([]Gap, error) {
+	const q = `
+		with updates as (
+			select
+				src_name,
+				ig_name,
+				num,
+				nblocks,
+				lag(num) over (
+					partition by src_name, ig_name
+					order by num
+				) as prev
+			from shovel.task_updates
+			where nblocks > 0
+			and ig_name not like '%/backfill/%'
+		)
+		select src_name, ig_name, prev + 1, num - nblocks
+		from updates u
+		where num - nblocks > prev
+		and not exists (
+			select 1
+			from shovel.task_updates b
+			where b.src_name = u.src_name
+			and b.ig_name = u.ig_name || '/backfill/' || (u.prev + 1) || '-' || (u.num - u.nblocks)
+			and b.num >= u.num - u.nblocks
+		)
+		union all
+		select src_name, ig_name, start, stop
+		from shovel.history h
+		where not exists (
+			select 1
+			from shovel.task_updates b
+			where b.src_name = h.src_name
+			and b.ig_name = h.ig_name || '/backfill/' || h.start || '-' || h.stop
+			and b.num >= h.stop
+		)
+		order by 1, 2, 3
+	`
+	rows, err := pg.Query(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("querying gaps: %w", err)
+	}
+	gaps, err := pgx.CollectRows(rows, func(r pgx.CollectableRow) (Gap, error) {
+		var g Gap
+		err := r.Scan(&g.SrcName, &g.IGName, &g.Start, &g.Stop)
+		return g, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning gaps: %w", err)
+	}
+	return gaps, nil
+}
+
+// Starts a backfill for each gap found by [Gaps] unless
+// the gap is already being repaired or its task is paused.
+// Backfills run in the background and their progress is
+// recorded under [BackfillName].
+func (tm *Manager) Heal() error {
+	tm.confMut.Lock()
+	conf, pools := tm.conf, tm.pools
+	tm.confMut.Unlock()
+
+	var gaps []Gap
+	for _, pg := range pools {
+		g, err := Gaps(tm.ctx, pg)
+		if err != nil {
+			return err
+		}
+		gaps = append(gaps, g...)
+	}
+	if len(gaps) == 0 {
+		return nil
+	}
+	igs, err := conf.AllIntegrations(tm.ctx, pools.Main())
+	if err != nil {
+		return fmt.Errorf("loading integrations: %w", err)
+	}
+	for _, g := range gaps {
+		known := slices.ContainsFunc(igs, func(ig config.Integration) bool {
+			return ig.Name == g.IGName
+		})
+		if !known {
+			continue
+		}
+		tm.confMut.Lock()
+		paused := tm.paused.matches(g.SrcName, g.IGName)
+		tm.confMut.Unlock()
+		if paused {
+			continue
+		}
+		name := BackfillName(g.IGName, g.Start, g.Stop)
+		tm.healMut.Lock()
+		if tm.healing[name] {
+			tm.healMut.Unlock()
+			continue
+		}
+		tm.healing[name] = true
+		tm.healMut.Unlock()
+
+		slog.InfoContext(tm.ctx, "heal",
+			"src", g.SrcName,
+			"ig", g.IGName,
+			"start", g.Start,
+			"stop", g.Stop,
+		)
+		go func(g Gap) {
+			err := Backfill(tm.ctx, pools, conf, g.SrcName, g.IGName, g.Start, g.Stop, 1, 0, 0, "")
+			if err != nil {
+				slog.ErrorContext(tm.ctx, "heal", "name", name, "error", err)
+			}
+			tm.healMut.Lock()
+			delete(tm.healing, name)
+			tm.healMut.Unlock()
+		}(g)
+	}
+	return nil
+}
+
+// Prunes shovel.task_updates in each database. The config
+// is read on every call so that a reload's reorg_depth
+// is used.
+func (tm *Manager) PruneTasks() error {
+	tm.confMut.Lock()
+	conf, pools := tm.conf, tm.pools
+	tm.confMut.Unlock()
+
+	srcs, err := conf.AllSources(tm.ctx, pools.Main())
+	if err != nil {
+		return fmt.Errorf("loading sources: %w", err)
+	}
+	n := taskHistory(srcs)
+	var errs []error
+	for _, pg := range pools {
+		if err := PruneTask(tm.ctx, pg, n); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// task_updates must retain enough history
+// to walk back the deepest reorg
+func taskHistory(srcs []config.Source) int {
+	n := 200
+	for _, sc := range srcs {
+		n = max(n, int(sc.ReorgDepth)+1)
+	}
+	return n
+}
+
+// Applies each integration's retention policy
+func (tm *Manager) Prune() error {
+	tm.confMut.Lock()
+	conf, pools := tm.conf, tm.pools
+	tm.confMut.Unlock()
+
+	igs, err := conf.AllIntegrations(tm.ctx, pools.Main())
+	if err != nil {
+		return fmt.Errorf("loading integrations: %w", err)
+	}
+	tables := map[[2]string]int{}
+	for _, ig := range igs {
+		tables[[2]string{ig.Database, ig.Table.QName()}]++
+	}
+	var errs []error
+	for _, ig := range igs {
+		shared := tables[[2]string{ig.Database, ig.Table.QName()}] > 1
+		err := PruneIntegration(tm.ctx, pools.For(ig), ig, shared)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ig.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Exports each integration's finalized rows.
+// See [ExportIntegration].
+func (tm *Manager) Export() error {
+	tm.confMut.Lock()
+	conf, pools := tm.conf, tm.pools
+	tm.confMut.Unlock()
+
+	igs, err := conf.AllIntegrations(tm.ctx, pools.Main())
+	if err != nil {
+		return fmt.Errorf("loading integrations: %w", err)
+	}
+	sources, err := conf.AllSourcesByName(tm.ctx, pools.Main())
+	if err != nil {
+		return fmt.Errorf("loading sources: %w", err)
+	}
+	var errs []error
+	for _, ig := range igs {
+		if err := ExportIntegration(tm.ctx, pools.For(ig), ig, sources); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ig.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (tm *Manager) integration(ctx context.Context, name string) (config.Integration, *pgxpool.Pool, error) {
+	tm.confMut.Lock()
+	conf, pools := tm.conf, tm.pools
+	tm.confMut.Unlock()
+
+	igs, err := conf.AllIntegrations(ctx, pools.Main())
+	if err != nil {
+		return config.Integration{}, nil, fmt.Errorf("loading integrations: %w", err)
+	}
+	for _, ig := range igs {
+		if ig.Name == name {
+			return ig, pools.For(ig), nil
+		}
+	}
+	return config.Integration{}, nil, fmt.Errorf("unknown integration: %s", name)
+}
+
+// Implements [stream.Store] using [ReadRows]
+func (tm *Manager) Rows(ctx context.Context, igName, src string, start, stop uint64, f func([]wpg.Column, [][]any) error) error {
+	ig, pg, err := tm.integration(ctx, igName)
+	if err != nil {
+		return err
+	}
+	return ReadRows(ctx, pg, ig, src, start, stop, 10000, f)
+}
+
+// Implements [stream.Store] using [MaxBlock]
+func (tm *Manager) MaxBlock(ctx context.Context, igName, src string) (uint64, error) {
+	ig, pg, err := tm.integration(ctx, igName)
+	if err != nil {
+		return 0, err
+	}
+	return MaxBlock(ctx, pg, ig, src)
+}
+
 type jsonDuration time.Duration
 
 func (d *jsonDuration) ScanInterval(i pgtype.Interval) error {
@@ -644,6 +1839,7 @@ type TaskUpdate struct {
 	NBlocks  uint64       `db:"nblocks"`
 	NRows    uint64       `db:"nrows"`
 	Latency  jsonDuration `db:"latency"`
+	Stats    Stats        `db:"-"`
 }
 
 func TaskUpdates(ctx context.Context, pg wpg.Conn) ([]TaskUpdate, error) {
@@ -675,6 +1871,7 @@ func TaskUpdates(ctx context.Context, pg wpg.Conn) ([]TaskUpdate, error) {
 	}
 	for i := range tus {
 		tus[i].DOMID = fmt.Sprintf("%s-%s", tus[i].SrcName, tus[i].DestName)
+		tus[i].Stats = Throughput(tus[i].SrcName, tus[i].DestName)
 	}
 	return tus, nil
 }
@@ -683,42 +1880,126 @@ func TaskUpdates(ctx context.Context, pg wpg.Conn) ([]TaskUpdate, error) {
 // based on config stored in the DB and in the config file.
 type Manager struct {
 	ctx     context.Context
+	cancel  context.CancelFunc
 	running sync.Mutex
 	restart chan struct{}
 	tasks   []*Task
 	updates chan uint64
-	pgp     *pgxpool.Pool
+	pools   Pools
+
+	wg      sync.WaitGroup
+	confMut sync.Mutex
 	conf    config.Root
+	runners map[string]*runner
+	sources map[string]*jrpc2.Client
+	paused  pauseSet
+	closed  bool
+
+	healMut sync.Mutex
+	healing map[string]bool
+}
+
+// Keys are src/ig where either may be empty to match any.
+// Kept in memory and cleared when the process restarts.
+type pauseSet map[string]struct{}
+
+func (ps pauseSet) matches(src, ig string) bool {
+	for _, k := range []string{src + "/" + ig, src + "/", "/" + ig} {
+		if _, ok := ps[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Used to stop an individual task without
+// stopping every task via [Manager.Restart].
+type runner struct {
+	stop chan struct{}
+	done chan struct{}
 }
 
 func NewManager(ctx context.Context, pgp *pgxpool.Pool, conf config.Root) *Manager {
+	ctx, cancel := context.WithCancel(ctx)
 	return &Manager{
 		ctx:     ctx,
+		cancel:  cancel,
 		restart: make(chan struct{}),
 		updates: make(chan uint64),
-		pgp:     pgp,
+		pools:   Pools{"": pgp},
 		conf:    conf,
+		runners: make(map[string]*runner),
+		paused:  make(pauseSet),
+		healing: make(map[string]bool),
+	}
+}
+
+// Sets the pools for the config's databases.
+// See [Pools.Open].
+func (tm *Manager) WithPools(p Pools) *Manager {
+	tm.pools = p
+	return tm
+}
+
+// The config passed to [NewManager] or the
+// latest [Manager.Reload]
+func (tm *Manager) Config() config.Root {
+	tm.confMut.Lock()
+	defer tm.confMut.Unlock()
+	return tm.conf
+}
+
+func (tm *Manager) Pools() Pools {
+	tm.confMut.Lock()
+	defer tm.confMut.Unlock()
+	return tm.pools
+}
+
+// Opens pools for conf's databases that the Manager
+// doesn't have and returns every pool. Used to migrate
+// a new config's databases before [Manager.Reload].
+func (tm *Manager) OpenPools(conf config.Root) (Pools, error) {
+	tm.confMut.Lock()
+	defer tm.confMut.Unlock()
+	pools, err := tm.pools.Open(tm.ctx, conf)
+	if err != nil {
+		return nil, err
 	}
+	tm.pools = pools
+	return pools, nil
 }
 
 func (tm *Manager) Updates() uint64 {
 	return <-tm.updates
 }
 
-func (tm *Manager) runTask(t *Task) {
+func (tm *Manager) runTask(t *Task, r *runner) {
+	defer close(r.done)
 	for {
 		select {
 		case <-tm.restart:
 			slog.InfoContext(t.ctx, "restart-task")
 			return
+		case <-r.stop:
+			slog.InfoContext(t.ctx, "stop-task")
+			return
 		default:
+			var coe *jrpc2.CircuitOpenError
 			switch err := t.Converge(); {
 			case errors.Is(err, ErrDone):
 				slog.InfoContext(t.ctx, "done")
 				return
 			case errors.Is(err, ErrNothingNew):
-				time.Sleep(t.pollDuration)
+				t.wait()
+			case errors.As(err, &coe):
+				// logged by jrpc2 when the circuit opened
+				select {
+				case <-tm.restart:
+				case <-r.stop:
+				case <-time.After(time.Until(coe.Until)):
+				}
 			case err != nil:
+				mErrors.Inc(t.srcName, t.destConfig.Name)
 				time.Sleep(time.Second)
 				slog.ErrorContext(t.ctx, "converge-retry", "msg", err)
 			default:
@@ -735,6 +2016,201 @@ func (tm *Manager) runTask(t *Task) {
 	}
 }
 
+func (tm *Manager) start(t *Task) {
+	if tm.closed {
+		return
+	}
+	if tm.paused.matches(t.srcName, t.destConfig.Name) {
+		slog.InfoContext(t.ctx, "paused-task")
+		return
+	}
+	r := &runner{stop: make(chan struct{}), done: make(chan struct{})}
+	tm.runners[t.id()] = r
+	tm.wg.Add(1)
+	go func() {
+		tm.runTask(t, r)
+		tm.wg.Done()
+	}()
+}
+
+// Stops every task once its in-flight Converge returns and
+// waits for the tasks to finish. No tasks are started after
+// Shutdown is called.
+//
+// A Converge writes its batch and the task's progress in a
+// single pg tx, so when ctx is done before the tasks finish
+// the Manager's context is cancelled which rolls back the
+// in-flight batches. The next process resumes from the last
+// committed block either way.
+func (tm *Manager) Shutdown(ctx context.Context) error {
+	tm.confMut.Lock()
+	tm.closed = true
+	runners := tm.runners
+	tm.runners = make(map[string]*runner)
+	for _, r := range runners {
+		close(r.stop)
+	}
+	tm.confMut.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		for _, r := range runners {
+			<-r.done
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	tm.cancel()
+	select {
+	case <-done:
+		return fmt.Errorf("rolled back in-flight tasks: %w", ctx.Err())
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("tasks didn't stop: %w", ctx.Err())
+	}
+}
+
+// Stops the tasks for src and ig and keeps them stopped
+// across reloads until [Manager.Resume]. Either name may
+// be empty to pause every task for the other.
+func (tm *Manager) Pause(src, ig string) error {
+	tm.confMut.Lock()
+	defer tm.confMut.Unlock()
+
+	if err := tm.checkNames(src, ig); err != nil {
+		return err
+	}
+	tm.paused[src+"/"+ig] = struct{}{}
+	var stopped int
+	for id, r := range tm.runners {
+		s, i, _ := strings.Cut(id, "/")
+		if !tm.paused.matches(s, i) {
+			continue
+		}
+		close(r.stop)
+		<-r.done
+		delete(tm.runners, id)
+		stopped++
+	}
+	slog.InfoContext(tm.ctx, "pause", "src", src, "ig", ig, "stopped", stopped)
+	return nil
+}
+
+// Undoes a prior call to [Manager.Pause] with the same names.
+// Only the tasks that are no longer paused are built and they
+// share the source clients of the running tasks.
+func (tm *Manager) Resume(src, ig string) error {
+	tm.confMut.Lock()
+	defer tm.confMut.Unlock()
+
+	key := src + "/" + ig
+	if _, ok := tm.paused[key]; !ok {
+		return fmt.Errorf("%s is not paused", key)
+	}
+	delete(tm.paused, key)
+	p, err := loadPlan(tm.ctx, tm.pools.Main(), tm.conf)
+	if err != nil {
+		return fmt.Errorf("loading tasks: %w", err)
+	}
+	var (
+		sources = p.clients(tm.pools.Main(), tm.sources, nil)
+		ids     = map[string]bool{}
+		started []*Task
+	)
+	for _, s := range p.specs {
+		id := s.id()
+		if _, ok := tm.runners[id]; ok {
+			continue
+		}
+		if sn, in, _ := strings.Cut(id, "/"); tm.paused.matches(sn, in) {
+			continue
+		}
+		t, err := buildTask(tm.ctx, tm.pools, tm.conf, s, sources[s.sc.Name])
+		if err != nil {
+			return fmt.Errorf("loading tasks: %w", err)
+		}
+		ids[id] = true
+		started = append(started, t)
+	}
+	tm.tasks = slices.DeleteFunc(tm.tasks, func(t *Task) bool {
+		return ids[t.id()]
+	})
+	tm.tasks = append(tm.tasks, started...)
+	tm.sources = sources
+	shareLogs(sources, tm.tasks)
+	for _, t := range started {
+		tm.start(t)
+	}
+	slog.InfoContext(tm.ctx, "resume", "src", src, "ig", ig, "started", len(started))
+	return nil
+}
+
+func (tm *Manager) IsPaused(src, ig string) bool {
+	tm.confMut.Lock()
+	defer tm.confMut.Unlock()
+	return tm.paused.matches(src, ig)
+}
+
+// Number of failed Converge calls since the process started
+func Errors(src, ig string) uint64 {
+	return mErrors.Get(src, ig)
+}
+
+// Sorted src/ig ids of the running tasks
+func (tm *Manager) Running() []string {
+	tm.confMut.Lock()
+	defer tm.confMut.Unlock()
+	res := []string{}
+	for id := range tm.runners {
+		res = append(res, id)
+	}
+	slices.Sort(res)
+	return res
+}
+
+// Sorted src/ig keys passed to [Manager.Pause]
+func (tm *Manager) Paused() []string {
+	tm.confMut.Lock()
+	defer tm.confMut.Unlock()
+	res := []string{}
+	for k := range tm.paused {
+		res = append(res, k)
+	}
+	slices.Sort(res)
+	return res
+}
+
+func (tm *Manager) checkNames(src, ig string) error {
+	if len(src) == 0 && len(ig) == 0 {
+		return fmt.Errorf("missing src or ig")
+	}
+	if len(src) > 0 {
+		srcs, err := tm.conf.AllSourcesByName(tm.ctx, tm.pools.Main())
+		if err != nil {
+			return fmt.Errorf("loading sources: %w", err)
+		}
+		if _, ok := srcs[src]; !ok {
+			return fmt.Errorf("unknown source: %s", src)
+		}
+	}
+	if len(ig) > 0 {
+		igs, err := tm.conf.AllIntegrations(tm.ctx, tm.pools.Main())
+		if err != nil {
+			return fmt.Errorf("loading integrations: %w", err)
+		}
+		found := slices.ContainsFunc(igs, func(c config.Integration) bool {
+			return c.Name == ig
+		})
+		if !found {
+			return fmt.Errorf("unknown integration: %s", ig)
+		}
+	}
+	return nil
+}
+
 // Ensures all running tasks stop
 // and calls [Manager.Run] in a new go routine.
 func (tm *Manager) Restart() error {
@@ -744,6 +2220,91 @@ func (tm *Manager) Restart() error {
 	return <-ec
 }
 
+// Replaces the Manager's config with conf and only restarts
+// tasks whose integration or source was added, removed, or modified.
+// Unaffected tasks keep running. Affected tasks are allowed to
+// finish their in-flight Converge before they are replaced.
+//
+// Only the added and affected tasks are built. Paused tasks
+// are built by [Manager.Resume]. Clients of unchanged sources
+// are reused so that the new tasks share their caches with
+// the tasks that keep running.
+func (tm *Manager) Reload(conf config.Root) (config.Changes, error) {
+	tm.confMut.Lock()
+	defer tm.confMut.Unlock()
+
+	changes := config.Diff(tm.conf, conf)
+	if changes.Empty() {
+		return changes, nil
+	}
+	pools, err := tm.pools.Open(tm.ctx, conf)
+	if err != nil {
+		return changes, fmt.Errorf("opening databases: %w", err)
+	}
+	p, err := loadPlan(tm.ctx, pools.Main(), conf)
+	if err != nil {
+		return changes, fmt.Errorf("loading tasks: %w", err)
+	}
+	var verify []config.Source
+	for _, name := range changes.Sources {
+		if sc, ok := p.sources[name]; ok {
+			verify = append(verify, sc)
+		}
+	}
+	if err := verifyChainIDs(tm.ctx, verify); err != nil {
+		return changes, err
+	}
+	var (
+		sources = p.clients(pools.Main(), tm.sources, changes.Sources)
+		prev    = map[string]*Task{}
+		kept    = map[string]bool{}
+		tasks   []*Task
+		started []*Task
+	)
+	for _, t := range tm.tasks {
+		prev[t.id()] = t
+	}
+	for _, s := range p.specs {
+		id := s.id()
+		_, running := tm.runners[id]
+		if t, ok := prev[id]; ok && running && !s.stale(t, changes) {
+			kept[id] = true
+			tasks = append(tasks, t)
+			continue
+		}
+		src, ig, _ := strings.Cut(id, "/")
+		if tm.paused.matches(src, ig) {
+			continue
+		}
+		t, err := buildTask(tm.ctx, pools, conf, s, sources[s.sc.Name])
+		if err != nil {
+			return changes, fmt.Errorf("loading tasks: %w", err)
+		}
+		started = append(started, t)
+	}
+	for id, r := range tm.runners {
+		if kept[id] {
+			continue
+		}
+		close(r.stop)
+		<-r.done
+		delete(tm.runners, id)
+	}
+	tm.conf, tm.pools, tm.sources = conf, pools, sources
+	tm.tasks = append(tasks, started...)
+	pools.Prune(conf)
+	shareLogs(sources, tm.tasks)
+	for _, t := range started {
+		tm.start(t)
+	}
+	slog.InfoContext(tm.ctx, "reload",
+		"integrations", changes.Integrations,
+		"sources", changes.Sources,
+		"started", len(started),
+	)
+	return changes, nil
+}
+
 // Loads ethereum sources and integrations from both the config file
 // and the database and assembles the nessecary tasks and runs all
 // tasks in a loop.
@@ -754,76 +2315,570 @@ func (tm *Manager) Run(ec chan error) {
 	tm.running.Lock()
 	defer tm.running.Unlock()
 
-	var err error
-	tm.tasks, err = loadTasks(tm.ctx, tm.pgp, tm.conf)
+	tm.confMut.Lock()
+	srcs, err := tm.conf.AllSources(tm.ctx, tm.pools.Main())
 	if err != nil {
+		tm.confMut.Unlock()
+		ec <- fmt.Errorf("loading source configs: %w", err)
+		return
+	}
+	if err := verifyChainIDs(tm.ctx, srcs); err != nil {
+		tm.confMut.Unlock()
+		ec <- err
+		return
+	}
+	tm.tasks, tm.sources, err = loadTasks(tm.ctx, tm.pools, tm.conf)
+	if err != nil {
+		tm.confMut.Unlock()
 		ec <- fmt.Errorf("loading tasks: %w", err)
 		return
 	}
 	close(ec)
 
 	tm.restart = make(chan struct{})
-	var wg sync.WaitGroup
+	tm.runners = make(map[string]*runner)
+	slices.SortStableFunc(tm.tasks, func(a, b *Task) int {
+		return b.destConfig.PriorityLevel() - a.destConfig.PriorityLevel()
+	})
 	for i := range tm.tasks {
-		i := i
-		wg.Add(1)
-		go func() {
-			tm.runTask(tm.tasks[i])
-			wg.Done()
-		}()
+		tm.start(tm.tasks[i])
 	}
-	wg.Wait()
+	tm.confMut.Unlock()
+	tm.wg.Wait()
 }
 
-func loadTasks(ctx context.Context, pgp *pgxpool.Pool, c config.Root) ([]*Task, error) {
-	allIntegrations, err := c.AllIntegrations(ctx, pgp)
+// Indexes [start, stop] of the source for a single integration
+// and returns once the range is complete. The range must be
+// behind the integration's running task (if any) and final.
+// Zero concurrency or batchSize uses the source's config.
+//
+// The range is split into shards (see [Shards]) that are
+// indexed concurrently. Each shard records its progress under
+// [BackfillName] for its own range so an interrupted backfill
+// resumes every shard when run with the same range and number
+// of shards. Concurrency is divided between the shards so the
+// backfill makes at most concurrency requests at a time. Once
+// every shard is done the whole range is recorded as done.
+//
+// Shards whose range already has rows in the integration's
+// table (eg a range that was indexed before) insert with
+// on conflict do nothing instead of COPY.
+//
+// When freezerDir is set blocks are read from a geth freezer
+// (see [freezer.Open]) instead of the source's urls.
+func Backfill(
+	ctx context.Context,
+	pools Pools,
+	c config.Root,
+	srcName, igName string,
+	start, stop uint64,
+	shards, concurrency, batchSize int,
+	freezerDir string,
+) error {
+	if start == 0 || stop < start {
+		return fmt.Errorf("start must be positive and stop must be >= start")
+	}
+	pgp := pools.Main()
+	igs, err := c.AllIntegrations(ctx, pgp)
 	if err != nil {
-		return nil, fmt.Errorf("loading integrations: %w", err)
+		return fmt.Errorf("loading integrations: %w", err)
+	}
+	i := slices.IndexFunc(igs, func(ig config.Integration) bool {
+		return ig.Name == igName
+	})
+	if i < 0 {
+		return fmt.Errorf("unknown integration: %s", igName)
+	}
+	ig := igs[i]
+	uses := slices.ContainsFunc(ig.Sources, func(s config.Source) bool {
+		return s.Name == srcName
+	})
+	if !uses {
+		return fmt.Errorf("%s does not use source %s", igName, srcName)
 	}
 	scByName, err := c.AllSourcesByName(ctx, pgp)
 	if err != nil {
-		return nil, fmt.Errorf("loading source configs: %w", err)
+		return fmt.Errorf("loading source configs: %w", err)
 	}
-	var sources = map[string]Source{}
-	for _, sc := range scByName {
-		sources[sc.Name] = jrpc2.New(sc.URLs...).
-			WithWSURL(sc.WSURL).
-			WithPollDuration(sc.PollDuration).
-			WithMaxReads(len(allIntegrations))
+	sc, ok := scByName[srcName]
+	if !ok {
+		return fmt.Errorf("unknown source: %s", srcName)
 	}
-	var tasks []*Task
-	for _, ig := range allIntegrations {
+
+	const q = `
+		select coalesce(max(num), 0)
+		from shovel.task_updates
+		where src_name = $1 and ig_name = $2
+	`
+	var head uint64
+	igp := pools.For(ig)
+	if err := igp.QueryRow(ctx, q, srcName, igName).Scan(&head); err != nil {
+		return fmt.Errorf("loading latest for %s: %w", igName, err)
+	}
+	if head > 0 && stop >= head {
+		const tag = "stop (%d) must be below %s's latest block (%d)"
+		return fmt.Errorf(tag, stop, igName, head)
+	}
+
+	var fz *freezer.Freezer
+	if len(freezerDir) > 0 {
+		fz, err = freezer.Open(freezerDir)
+		if err != nil {
+			return fmt.Errorf("opening freezer: %w", err)
+		}
+		defer fz.Close()
+		last, _, err := fz.Latest(ctx, "", 0)
+		if err != nil {
+			return fmt.Errorf("reading freezer: %w", err)
+		}
+		if stop > last {
+			const tag = "stop (%d) is past the freezer's last block (%d)"
+			return fmt.Errorf(tag, stop, last)
+		}
+	}
+
+	if concurrency == 0 {
+		concurrency = max(1, sc.Concurrency)
+	}
+	if batchSize == 0 {
+		batchSize = sc.BatchSize
+	}
+	ranges := Shards(start, stop, min(max(1, shards), concurrency))
+	ctx = wctx.WithChainID(ctx, sc.ChainID)
+	ctx = wctx.WithSrcName(ctx, sc.Name)
+	ctx = wctx.WithIGName(ctx, ig.Name)
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, r := range ranges {
+		overlap, err := hasRows(ctx, igp, ig, srcName, r[0], r[1])
+		if err != nil {
+			return fmt.Errorf("checking for existing rows: %w", err)
+		}
+		var src Source
+		switch {
+		case fz != nil:
+			src = fz
+		default:
+			src = sourceStore(pgp, sc, NewClient(sc).
+				WithBloom(!sc.DisableBloom).
+				WithLimiter(sourceLimiter(sc)).
+				WithFollow(sc.Follow).
+				WithTraceMethod(sc.TraceMethod).
+				WithPollDuration(sc.PollDuration))
+		}
+		task, err := NewTask(
+			WithContext(ctx),
+			WithPG(igp),
+			WithBackfill(r[0], r[1]),
+			WithOverlap(overlap),
+			WithPollDuration(sc.PollDuration),
+			WithConcurrency(max(1, concurrency/len(ranges)), batchSize),
+			WithDecodeWorkers(sc.DecodeWorkers),
+			WithAdaptive(sc.Adaptive),
+			WithMaxInsertLatency(c.Backpressure.Latency()),
+			WithSkip(sc.Skip),
+			WithVerifyChain(sc.VerifyChain),
+			WithSrcName(sc.Name),
+			WithChainID(sc.ChainID),
+			WithSource(src),
+			WithIntegration(ig),
+		)
+		if err != nil {
+			return fmt.Errorf("setting up backfill task: %w", err)
+		}
+		eg.Go(func() error {
+			return runBackfill(ctx, task)
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	if len(ranges) == 1 {
+		return nil
+	}
+	return recordBackfill(ctx, igp, sc.ChainID, srcName, igName, start, stop)
+}
+
+// Shards record progress under their own ranges so once
+// every shard is done, progress is recorded for the whole
+// range so that [Gaps] finds that it was repaired.
+func recordBackfill(ctx context.Context, pg wpg.Conn, chainID uint64, srcName, igName string, start, stop uint64) error {
+	const q = `
+		insert into shovel.task_updates (chain_id, src_name, ig_name, num, stop, nblocks)
+		values ($1, $2, $3, $4, $4, 0)
+		on conflict do nothing
+	`
+	_, err := pg.Exec(ctx, q, chainID, srcName, BackfillName(igName, start, stop), stop)
+	if err != nil {
+		return fmt.Errorf("recording backfill: %w", err)
+	}
+	return nil
+}
+
+// Reports whether the integration's table has rows for the
+// source in [start, stop]
+func hasRows(ctx context.Context, pg wpg.Conn, ig config.Integration, srcName string, start, stop uint64) (bool, error) {
+	const q = `
+		select exists (
+			select 1 from %s
+			where src_name = $1
+			and ig_name = $2
+			and block_num between $3 and $4
+		)
+	`
+	if len(ig.Table.Name) == 0 {
+		return false, nil
+	}
+	var found bool
+	err := pg.QueryRow(ctx, fmt.Sprintf(q, ig.Table.QName()), srcName, ig.Name, start, stop).Scan(&found)
+	return found, err
+}
+
+// Splits [start, stop] into at most n contiguous ranges of
+// nearly equal size. The ranges only depend on the arguments
+// so that a restarted backfill finds each shard's progress.
+func Shards(start, stop uint64, n int) [][2]uint64 {
+	total := stop - start + 1
+	size := (total + uint64(n) - 1) / uint64(n)
+	var res [][2]uint64
+	for s := start; s <= stop; s += size {
+		res = append(res, [2]uint64{s, min(s+size-1, stop)})
+		if s+size < s {
+			break
+		}
+	}
+	return res
+}
+
+func runBackfill(ctx context.Context, task *Task) error {
+	slog.InfoContext(ctx, "backfill", "start", task.start, "stop", task.stop)
+	for {
+		var coe *jrpc2.CircuitOpenError
+		switch err := task.Converge(); {
+		case errors.Is(err, ErrDone):
+			slog.InfoContext(ctx, "backfill-done", "start", task.start, "stop", task.stop)
+			return nil
+		case errors.Is(err, ErrNothingNew):
+			task.wait()
+		case errors.Is(err, ErrReorg):
+			return err
+		case errors.As(err, &coe):
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Until(coe.Until)):
+			}
+		case err != nil:
+			mErrors.Inc(task.srcName, task.destConfig.Name)
+			slog.ErrorContext(ctx, "backfill-retry", "msg", err)
+			time.Sleep(time.Second)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+var (
+	limitersMut sync.Mutex
+	limiters    = map[string]*jrpc2.Limiter{}
+)
+
+// Limiters are kept for the life of the process so that
+// tasks started by a restart or a backfill share the
+// source's limit with tasks that are already running.
+func sourceLimiter(sc config.Source) *jrpc2.Limiter {
+	if sc.RPS <= 0 {
+		return nil
+	}
+	limitersMut.Lock()
+	defer limitersMut.Unlock()
+	l, ok := limiters[sc.Name]
+	if !ok || l.Rate() != sc.RPS {
+		l = jrpc2.NewLimiter(sc.RPS)
+		limiters[sc.Name] = l
+	}
+	return l
+}
+
+// A client for the source's urls using its
+// transport, headers, and basic auth. Requests
+// aren't sent to urls on another chain.
+func NewClient(sc config.Source) *jrpc2.Client {
+	return jrpc2.New(sc.URLs...).
+		WithTransport(sourceTransport(sc)).
+		WithHeaders(sc.Headers).
+		WithBasicAuth(sourceBasicAuth(sc)).
+		WithChainID(sc.ChainID).
+		WithLenient(sc.Lenient)
+}
+
+// Refuses to start when a source's url is on another chain
+// than the source's chain_id. A misconfigured url would
+// otherwise write another chain's blocks into the source's
+// tables.
+func verifyChainIDs(ctx context.Context, srcs []config.Source) error {
+	var eg errgroup.Group
+	for _, sc := range srcs {
+		sc := sc
+		eg.Go(func() error {
+			if err := NewClient(sc).VerifyChainID(ctx); err != nil {
+				return fmt.Errorf("source %s: %w", sc.Name, err)
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+func sourceTransport(sc config.Source) jrpc2.Transport {
+	var t jrpc2.Transport
+	if sc.Transport != nil {
+		t = jrpc2.Transport{
+			MaxIdleConns:          sc.Transport.MaxIdleConns,
+			IdleConnTimeout:       sc.Transport.IdleConnTimeout,
+			KeepAlive:             sc.Transport.KeepAlive,
+			ResponseHeaderTimeout: sc.Transport.ResponseHeaderTimeout,
+			DisableHTTP2:          sc.Transport.DisableHTTP2,
+			DisableCompression:    sc.Transport.DisableCompression,
+		}
+		if len(sc.Transport.Proxy) > 0 {
+			// validated when the config is read
+			t.Proxy, _ = url.Parse(sc.Transport.Proxy)
+		}
+	}
+	if sc.TLS != nil {
+		// validated when the config is read
+		c, err := sc.TLS.Config()
+		if err != nil {
+			slog.Error("loading source tls", "src", sc.Name, "error", err)
+		}
+		t.TLS = c
+	}
+	return t
+}
+
+func sourceBasicAuth(sc config.Source) (string, string) {
+	if sc.BasicAuth == nil {
+		return "", ""
+	}
+	return sc.BasicAuth.Username, sc.BasicAuth.Password
+}
+
+func sourceStore(pgp *pgxpool.Pool, sc config.Source, c *jrpc2.Client) *jrpc2.Client {
+	if !sc.Cache {
+		return c
+	}
+	// blocks deeper than a reorg can reach are final
+	finality := sc.ReorgDepth
+	if finality == 0 {
+		finality = 1000
+	}
+	return c.WithStore(BlockCache{pgp}, sc.ChainID, finality)
+}
+
+// The source and integrations of a task. Each integration
+// gets a task per source except for atomic sources which
+// have a single task for all of their integrations.
+type taskSpec struct {
+	sc  config.Source
+	ref config.Source
+	igs []config.Integration
+}
+
+func (s taskSpec) id() string {
+	if s.sc.Atomic {
+		return s.sc.Name + "/" + AtomicName
+	}
+	return s.sc.Name + "/" + s.igs[0].Name
+}
+
+func (s taskSpec) names() []string {
+	var res []string
+	for _, ig := range s.igs {
+		res = append(res, ig.Name)
+	}
+	slices.Sort(res)
+	return res
+}
+
+// Reports whether t, built from an older config, has
+// to be rebuilt to index s after changes.
+func (s taskSpec) stale(t *Task, changes config.Changes) bool {
+	for _, ig := range s.igs {
+		if changes.Affects(s.sc.Name, ig.Name) {
+			return true
+		}
+	}
+	if !s.sc.Atomic {
+		return false
+	}
+	members := slices.Clone(t.members)
+	slices.Sort(members)
+	return !slices.Equal(members, s.names())
+}
+
+// The tasks for a config. See [loadPlan].
+type plan struct {
+	specs   []taskSpec
+	sources map[string]config.Source
+	nigs    int
+}
+
+// Loads the config's integrations and sources (including
+// those stored in the db) without building any tasks.
+func loadPlan(ctx context.Context, pgp *pgxpool.Pool, c config.Root) (plan, error) {
+	allIntegrations, err := c.AllIntegrations(ctx, pgp)
+	if err != nil {
+		return plan{}, fmt.Errorf("loading integrations: %w", err)
+	}
+	scByName, err := c.AllSourcesByName(ctx, pgp)
+	if err != nil {
+		return plan{}, fmt.Errorf("loading source configs: %w", err)
+	}
+	return newPlan(allIntegrations, scByName)
+}
+
+func newPlan(igs []config.Integration, scByName map[string]config.Source) (plan, error) {
+	var (
+		p      = plan{sources: scByName, nigs: len(igs)}
+		atomic = map[string][]config.Integration{}
+	)
+	for _, ig := range igs {
 		if !ig.Enabled {
 			continue
 		}
 		for _, scRef := range ig.Sources {
 			sc, ok := scByName[scRef.Name]
 			if !ok {
-				return nil, fmt.Errorf("finding source config for %s", scRef.Name)
-			}
-			ctx = wctx.WithChainID(ctx, sc.ChainID)
-			ctx = wctx.WithSrcName(ctx, sc.Name)
-			ctx = wctx.WithIGName(ctx, ig.Name)
-			src, ok := sources[scRef.Name]
-			if !ok {
-				return nil, fmt.Errorf("finding source for %s", scRef.Name)
+				return plan{}, fmt.Errorf("finding source config for %s", scRef.Name)
 			}
-			task, err := NewTask(
-				WithContext(ctx),
-				WithPG(pgp),
-				WithRange(scRef.Start, scRef.Stop),
-				WithPollDuration(sc.PollDuration),
-				WithConcurrency(sc.Concurrency, sc.BatchSize),
-				WithSrcName(sc.Name),
-				WithChainID(sc.ChainID),
-				WithSource(src),
-				WithIntegration(ig),
-			)
-			if err != nil {
-				return nil, fmt.Errorf("setting up main task: %w", err)
+			if sc.Atomic {
+				atomic[sc.Name] = append(atomic[sc.Name], ig)
+				continue
 			}
-			tasks = append(tasks, task)
+			p.specs = append(p.specs, taskSpec{
+				sc:  sc,
+				ref: scRef,
+				igs: []config.Integration{ig},
+			})
+		}
+	}
+	var names []string
+	for name := range atomic {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		p.specs = append(p.specs, taskSpec{sc: scByName[name], igs: atomic[name]})
+	}
+	return p, nil
+}
+
+// Clients for the plan's sources. A client in prev is
+// reused unless its source is in changed so that tasks
+// which keep running across a reload share the client's
+// caches and log filter with the tasks that are started.
+func (p plan) clients(pgp *pgxpool.Pool, prev map[string]*jrpc2.Client, changed []string) map[string]*jrpc2.Client {
+	res := map[string]*jrpc2.Client{}
+	for name, sc := range p.sources {
+		if c, ok := prev[name]; ok && !slices.Contains(changed, name) {
+			res[name] = c.WithMaxReads(p.nigs)
+			continue
+		}
+		res[name] = sourceStore(pgp, sc, NewClient(sc).
+			WithBloom(!sc.DisableBloom).
+			WithLimiter(sourceLimiter(sc)).
+			WithWSURL(sc.WSURL).
+			WithFollow(sc.Follow).
+			WithTraceMethod(sc.TraceMethod).
+			WithPollDuration(sc.PollDuration).
+			WithMaxReads(p.nigs))
+	}
+	return res
+}
+
+func buildTask(ctx context.Context, pools Pools, c config.Root, s taskSpec, src Source) (*Task, error) {
+	if s.sc.Atomic {
+		return atomicTask(ctx, pools, c, s.sc, src, s.igs)
+	}
+	var (
+		sc, scRef, ig = s.sc, s.ref, s.igs[0]
+		start, stop   = sc.Range(scRef)
+	)
+	if stop > 0 && start > stop {
+		const tag = "%s start (%d) exceeds %s stop (%d)"
+		return nil, fmt.Errorf(tag, ig.Name, start, scRef.Name, stop)
+	}
+	var history uint64
+	if scRef.Backfill {
+		history, start = start, 0
+	}
+	ctx = wctx.WithChainID(ctx, sc.ChainID)
+	ctx = wctx.WithSrcName(ctx, sc.Name)
+	ctx = wctx.WithIGName(ctx, ig.Name)
+	task, err := NewTask(
+		WithContext(ctx),
+		WithPG(pools.For(ig)),
+		WithRange(start, stop),
+		WithHistory(history),
+		WithPollDuration(sc.PollDuration),
+		WithConcurrency(sc.Concurrency, sc.BatchSize),
+		WithDecodeWorkers(sc.DecodeWorkers),
+		WithReorgDepth(sc.ReorgDepth),
+		WithAdaptive(sc.Adaptive),
+		WithMaxInsertLatency(c.Backpressure.Latency()),
+		WithSkip(sc.Skip),
+		WithVerifyChain(sc.VerifyChain),
+		WithSrcName(sc.Name),
+		WithChainID(sc.ChainID),
+		WithSource(src),
+		WithIntegration(ig),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("setting up main task: %w", err)
+	}
+	return task, nil
+}
+
+// Builds every task for the config with new source clients
+func loadTasks(ctx context.Context, pools Pools, c config.Root) ([]*Task, map[string]*jrpc2.Client, error) {
+	p, err := loadPlan(ctx, pools.Main(), c)
+	if err != nil {
+		return nil, nil, err
+	}
+	sources := p.clients(pools.Main(), nil, nil)
+	var tasks []*Task
+	for _, s := range p.specs {
+		task, err := buildTask(ctx, pools, c, s, sources[s.sc.Name])
+		if err != nil {
+			return nil, nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	shareLogs(sources, tasks)
+	return tasks, sources, nil
+}
+
+// Gives each source the union of its tasks' log filters so
+// that tasks reading the same blocks share eth_getLogs
+// requests. Tasks whose addresses come from a table aren't
+// included since their addresses change. The union isn't used
+// when it would request every log.
+//
+// Called again with every task whenever tasks are added or
+// removed since the sources' clients outlive their tasks.
+func shareLogs(sources map[string]*jrpc2.Client, tasks []*Task) {
+	filters := map[string][]glf.Filter{}
+	for _, t := range tasks {
+		if !t.filter.UseLogs || len(t.addrRef.Table) > 0 {
+			continue
+		}
+		filters[t.srcName] = append(filters[t.srcName], t.filter)
+	}
+	for name, c := range sources {
+		var u glf.Filter
+		if fs := filters[name]; len(fs) >= 2 {
+			u = glf.Union(fs...)
 		}
+		c.WithLogFilter(u)
 	}
-	return tasks, nil
 }
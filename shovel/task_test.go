@@ -3,10 +3,17 @@ package shovel
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"sort"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/indexsupply/shovel/dig"
 	"github.com/indexsupply/shovel/eth"
@@ -14,6 +21,8 @@ import (
 	"github.com/indexsupply/shovel/shovel/config"
 	"github.com/indexsupply/shovel/shovel/glf"
 	"github.com/indexsupply/shovel/tc"
+	"github.com/indexsupply/shovel/wctx"
+	"github.com/indexsupply/shovel/wos"
 	"github.com/indexsupply/shovel/wpg"
 
 	"blake.io/pqx/pqxtest"
@@ -220,6 +229,207 @@ func TestConverge_Reorg(t *testing.T) {
 	diff.Test(t, t.Errorf, dest.blocks(), tg.blocks)
 }
 
+func TestConverge_ReorgNotify(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		pg        = testpg(t)
+		tg        = &testGeth{}
+		dest      = newTestDestination("foo")
+		task, err = NewTask(
+			WithPG(pg),
+			WithSource(tg),
+			WithIntegration(dest.ig()),
+			WithIntegrationFactory(dest.factory),
+		)
+	)
+	diff.Test(t, t.Fatalf, err, nil)
+	conn, err := pg.Acquire(ctx)
+	diff.Test(t, t.Fatalf, err, nil)
+	defer conn.Release()
+	_, err = conn.Exec(ctx, "listen shovel_reorg")
+	diff.Test(t, t.Fatalf, err, nil)
+
+	tg.add(0, hash(0), hash(0))
+	tg.add(1, hash(2), hash(0))
+	tg.add(2, hash(3), hash(2))
+
+	diff.Test(t, t.Fatalf, nil, task.update(pg, 0, hash(0), 0, hash(0), 0, 0, 0))
+	diff.Test(t, t.Fatalf, nil, task.update(pg, 1, hash(1), 0, hash(0), 0, 0, 0))
+	diff.Test(t, t.Fatalf, task.Converge(), nil)
+
+	n, err := conn.Conn().WaitForNotification(ctx)
+	diff.Test(t, t.Fatalf, err, nil)
+	var got reorgPayload
+	diff.Test(t, t.Fatalf, json.Unmarshal([]byte(n.Payload), &got), nil)
+	diff.Test(t, t.Errorf, got, reorgPayload{
+		Src:      task.srcName,
+		IG:       "foo",
+		Ancestor: 0,
+		OldNum:   1,
+		OldHash:  eth.EncodeHex(hash(1)),
+		NewNum:   1,
+		NewHash:  eth.EncodeHex(hash(2)),
+	})
+}
+
+func TestConverge_ReorgDepth(t *testing.T) {
+	var (
+		pg        = testpg(t)
+		tg        = &testGeth{}
+		dest      = newTestDestination("foo")
+		task, err = NewTask(
+			WithPG(pg),
+			WithSource(tg),
+			WithReorgDepth(1),
+			WithIntegration(dest.ig()),
+			WithIntegrationFactory(dest.factory),
+		)
+	)
+	diff.Test(t, t.Fatalf, err, nil)
+
+	tg.add(0, hash(0), hash(0))
+	tg.add(1, hash(3), hash(0))
+	tg.add(2, hash(4), hash(3))
+	tg.add(3, hash(5), hash(4))
+
+	diff.Test(t, t.Fatalf, nil, task.update(pg, 0, hash(0), 0, hash(0), 0, 0, 0))
+	diff.Test(t, t.Fatalf, nil, task.update(pg, 1, hash(1), 0, hash(0), 0, 0, 0))
+	diff.Test(t, t.Fatalf, nil, task.update(pg, 2, hash(2), 0, hash(0), 0, 0, 0))
+
+	diff.Test(t, t.Fatalf, errors.Is(task.Converge(), ErrReorg), true)
+	checkQuery(t, pg, `select count(*) = 3 from shovel.task_updates`)
+}
+
+func TestTaskHistory(t *testing.T) {
+	diff.Test(t, t.Errorf, taskHistory(nil), 200)
+	diff.Test(t, t.Errorf, taskHistory([]config.Source{{ReorgDepth: 64}}), 200)
+	diff.Test(t, t.Errorf, taskHistory([]config.Source{{ReorgDepth: 64}, {ReorgDepth: 500}}), 501)
+}
+
+func TestConverge_Backfill(t *testing.T) {
+	var (
+		pg        = testpg(t)
+		tg        = &testGeth{}
+		dest      = newTestDestination("foo")
+		task, err = NewTask(
+			WithPG(pg),
+			WithSource(tg),
+			WithBackfill(1, 2),
+			WithIntegration(dest.ig()),
+			WithIntegrationFactory(dest.factory),
+		)
+	)
+	diff.Test(t, t.Fatalf, err, nil)
+
+	tg.add(0, hash(0), hash(0))
+	tg.add(1, hash(1), hash(0))
+	tg.add(2, hash(2), hash(1))
+	tg.add(3, hash(3), hash(2))
+
+	diff.Test(t, t.Fatalf, task.Converge(), nil)
+	diff.Test(t, t.Fatalf, task.Converge(), nil)
+	diff.Test(t, t.Fatalf, task.Converge(), ErrDone)
+	diff.Test(t, t.Errorf, dest.blocks(), tg.blocks[1:3])
+	checkQuery(t, pg, `
+		select count(*) = 2
+		from shovel.task_updates
+		where ig_name = $1
+	`, BackfillName("foo", 1, 2))
+	checkQuery(t, pg, `
+		select count(*) = 0
+		from shovel.task_updates
+		where ig_name = 'foo'
+	`)
+}
+
+func TestConverge_BackfillOverlap(t *testing.T) {
+	var (
+		ctx = context.Background()
+		pg  = testpg(t)
+		tg  = &testGeth{}
+		ig  = config.Integration{
+			Name:    "foo",
+			Enabled: true,
+			Table: wpg.Table{
+				Name:    "overlap_test",
+				Columns: []wpg.Column{{Name: "tx_hash", Type: "bytea"}},
+			},
+			Block: []dig.BlockData{{Name: "tx_hash", Column: "tx_hash"}},
+		}
+	)
+	ig.AddRequiredFields()
+	config.AddUniqueIndex(&ig.Table)
+	diff.Test(t, t.Fatalf, ig.Table.Migrate(ctx, pg), nil)
+	for i := byte(0); i <= 3; i++ {
+		tg.add(uint64(i), hash(i), hash(max(i, 1)-1))
+		tg.blocks[i].Txs = []eth.Tx{{PrecompHash: hash(i)}}
+	}
+	_, err := pg.Exec(ctx, `
+		insert into overlap_test(src_name, ig_name, block_num, tx_idx, tx_hash)
+		values ('', 'foo', 1, 0, $1)
+	`, hash(1))
+	diff.Test(t, t.Fatalf, err, nil)
+
+	overlap, err := hasRows(ctx, pg, ig, "", 1, 2)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, overlap, true)
+	overlap, err = hasRows(ctx, pg, ig, "", 3, 3)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, overlap, false)
+
+	task, err := NewTask(
+		WithPG(pg),
+		WithSource(tg),
+		WithBackfill(1, 2),
+		WithOverlap(true),
+		WithIntegration(ig),
+	)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Fatalf, task.Converge(), nil)
+	diff.Test(t, t.Fatalf, task.Converge(), nil)
+	diff.Test(t, t.Fatalf, task.Converge(), ErrDone)
+	checkQuery(t, pg, `select count(*) = 2 from overlap_test`)
+}
+
+func TestConverge_PipelineNotify(t *testing.T) {
+	var (
+		ctx = context.Background()
+		pg  = testpg(t)
+		tg  = &testGeth{}
+		ig  = config.Integration{
+			Name:    "foo",
+			Enabled: true,
+			Table: wpg.Table{
+				Name:    "pipeline_notify_test",
+				Columns: []wpg.Column{{Name: "tx_hash", Type: "bytea"}},
+			},
+			Block:        []dig.BlockData{{Name: "tx_hash", Column: "tx_hash"}},
+			Notification: dig.Notification{Columns: []string{"tx_hash"}},
+		}
+	)
+	ig.AddRequiredFields()
+	config.AddUniqueIndex(&ig.Table)
+	diff.Test(t, t.Fatalf, ig.Table.Migrate(ctx, pg), nil)
+	for i := byte(0); i <= 1; i++ {
+		tg.add(uint64(i), hash(i), hash(max(i, 1)-1))
+		tg.blocks[i].Txs = []eth.Tx{{PrecompHash: hash(i)}}
+	}
+
+	task, err := NewTask(
+		WithPG(pg),
+		WithSource(tg),
+		WithIntegration(ig),
+	)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Fatalf, nil, task.update(pg, 0, hash(0), 0, hash(0), 0, 0, 0))
+	diff.Test(t, t.Fatalf, task.Converge(), nil)
+	checkQuery(t, pg, `
+		select nrows = 1
+		from shovel.task_updates
+		where ig_name = 'foo' and num = 1
+	`)
+}
+
 func TestConverge_DeltaBatchSize(t *testing.T) {
 	const (
 		batchSize   = 16
@@ -336,6 +546,23 @@ func TestConverge_Done(t *testing.T) {
 	diff.Test(t, t.Errorf, ErrDone, task.Converge())
 }
 
+func TestPauseSet(t *testing.T) {
+	ps := pauseSet{"mainnet/": {}, "/foo": {}, "base/bar": {}}
+	cases := []struct {
+		src, ig string
+		want    bool
+	}{
+		{"mainnet", "bar", true},
+		{"base", "foo", true},
+		{"base", "bar", true},
+		{"base", "baz", false},
+		{"op", "bar", false},
+	}
+	for _, tc := range cases {
+		diff.Test(t, t.Errorf, ps.matches(tc.src, tc.ig), tc.want)
+	}
+}
+
 func TestPruneTask(t *testing.T) {
 	pg := testpg(t)
 	it := func(n uint8) {
@@ -355,6 +582,93 @@ func TestPruneTask(t *testing.T) {
 	checkQuery(t, pg, `select count(*) = 1 from shovel.task_updates`)
 }
 
+func TestPruneIntegration(t *testing.T) {
+	var (
+		ctx = context.Background()
+		pg  = testpg(t)
+		ig  = config.Integration{
+			Name:      "bar",
+			Sources:   []config.Source{{Name: "foo"}},
+			Retention: config.Retention{Blocks: 5},
+			Table: wpg.Table{
+				Name: "prune_test",
+				Columns: []wpg.Column{
+					{Name: "src_name", Type: "text"},
+					{Name: "ig_name", Type: "text"},
+					{Name: "block_num", Type: "numeric"},
+				},
+			},
+		}
+	)
+	diff.Test(t, t.Fatalf, ig.Table.Migrate(ctx, pg), nil)
+	for i := uint8(0); i < 10; i++ {
+		_, err := pg.Exec(ctx, `
+			insert into shovel.task_updates(src_name, ig_name, num, hash)
+			values ('foo', 'bar', $1, $2)
+		`, i, hash(i))
+		diff.Test(t, t.Fatalf, err, nil)
+		_, err = pg.Exec(ctx, `
+			insert into prune_test(src_name, ig_name, block_num)
+			values ('foo', 'bar', $1)
+		`, i)
+		diff.Test(t, t.Fatalf, err, nil)
+	}
+	diff.Test(t, t.Fatalf, PruneIntegration(ctx, pg, ig, false), nil)
+	checkQuery(t, pg, `select count(*) = 5 from prune_test`)
+	checkQuery(t, pg, `select min(block_num) = 5 from prune_test`)
+}
+
+func TestGaps(t *testing.T) {
+	var (
+		ctx = context.Background()
+		pg  = testpg(t)
+	)
+	it := func(ig string, num, nblocks uint64) {
+		_, err := pg.Exec(ctx, `
+			insert into shovel.task_updates(src_name, ig_name, num, nblocks)
+			values ('foo', $1, $2, $3)
+		`, ig, num, nblocks)
+		diff.Test(t, t.Fatalf, err, nil)
+	}
+	it("bar", 10, 10)
+	it("bar", 20, 10)
+	it("bar", 40, 10)
+	it("bar", 50, 5)
+	it("baz", 10, 10)
+	it("baz", 30, 10)
+
+	gaps, err := Gaps(ctx, pg)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, gaps, []Gap{
+		{"foo", "bar", 21, 30},
+		{"foo", "bar", 41, 45},
+		{"foo", "baz", 11, 20},
+	})
+
+	it(BackfillName("bar", 21, 30), 30, 10)
+	it(BackfillName("baz", 11, 20), 15, 5)
+	gaps, err = Gaps(ctx, pg)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, gaps, []Gap{
+		{"foo", "bar", 41, 45},
+		{"foo", "baz", 11, 20},
+	})
+
+	// a sharded backfill
+	it(BackfillName("bar", 41, 43), 43, 3)
+	it(BackfillName("bar", 44, 45), 45, 2)
+	gaps, err = Gaps(ctx, pg)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, len(gaps), 2)
+	diff.Test(t, t.Fatalf, recordBackfill(ctx, pg, 1, "foo", "bar", 41, 45), nil)
+	diff.Test(t, t.Fatalf, recordBackfill(ctx, pg, 1, "foo", "bar", 41, 45), nil)
+	gaps, err = Gaps(ctx, pg)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, gaps, []Gap{
+		{"foo", "baz", 11, 20},
+	})
+}
+
 func destFactory(dests ...*testDestination) func(config.Integration) (Destination, error) {
 	return func(ig config.Integration) (Destination, error) {
 		for i := range dests {
@@ -403,12 +717,51 @@ func TestLoadTasks(t *testing.T) {
 			},
 		},
 	}
-	tasks, err := loadTasks(ctx, pg, conf)
+	tasks, _, err := loadTasks(ctx, Pools{"": pg}, conf)
 	diff.Test(t, t.Fatalf, err, nil)
 	diff.Test(t, t.Fatalf, len(tasks), 1)
 	diff.Test(t, t.Fatalf, tasks[0].start, uint64(0))
 }
 
+func TestPlan(t *testing.T) {
+	var (
+		scs = map[string]config.Source{
+			"main": config.Source{Name: "main"},
+			"base": config.Source{Name: "base", Atomic: true},
+		}
+		igs = []config.Integration{
+			{Name: "a", Enabled: true, Sources: []config.Source{{Name: "main"}, {Name: "base"}}},
+			{Name: "b", Enabled: true, Sources: []config.Source{{Name: "base"}}},
+			{Name: "c", Enabled: false, Sources: []config.Source{{Name: "main"}}},
+		}
+	)
+	p, err := newPlan(igs, scs)
+	diff.Test(t, t.Fatalf, err, nil)
+	var ids []string
+	for _, s := range p.specs {
+		ids = append(ids, s.id())
+	}
+	diff.Test(t, t.Errorf, ids, []string{"main/a", "base/" + AtomicName})
+	diff.Test(t, t.Errorf, p.specs[1].names(), []string{"a", "b"})
+
+	_, err = newPlan([]config.Integration{{Name: "d", Enabled: true, Sources: []config.Source{{Name: "op"}}}}, scs)
+	diff.Test(t, t.Errorf, err != nil, true)
+
+	var (
+		single = &Task{srcName: "main", destConfig: config.Integration{Name: "a"}}
+		atomic = &Task{srcName: "base", members: []string{"b", "a"}}
+	)
+	diff.Test(t, t.Errorf, p.specs[0].stale(single, config.Changes{}), false)
+	diff.Test(t, t.Errorf, p.specs[0].stale(single, config.Changes{Integrations: []string{"b"}}), false)
+	diff.Test(t, t.Errorf, p.specs[0].stale(single, config.Changes{Integrations: []string{"a"}}), true)
+	diff.Test(t, t.Errorf, p.specs[0].stale(single, config.Changes{Sources: []string{"main"}}), true)
+	diff.Test(t, t.Errorf, p.specs[1].stale(atomic, config.Changes{}), false)
+	diff.Test(t, t.Errorf, p.specs[1].stale(atomic, config.Changes{Integrations: []string{"b"}}), true)
+
+	atomic.members = []string{"a", "b", "e"}
+	diff.Test(t, t.Errorf, p.specs[1].stale(atomic, config.Changes{}), true)
+}
+
 func TestLatest(t *testing.T) {
 	ctx := context.Background()
 	pqxtest.CreateDB(t, Schema)
@@ -505,3 +858,515 @@ func TestLatest_NoSrc(t *testing.T) {
 	check(pg.Exec(ctx, q, "base", "bar", 1))
 	checkLatest(101)
 }
+
+func TestTuner(t *testing.T) {
+	tu := newTuner(config.Adaptive{MaxBatchSize: 100, MaxConcurrency: 4, TargetLatency: time.Second})
+	cases := []struct {
+		batch, conc int
+		full        bool
+		elapsed     time.Duration
+		err         error
+		wantBatch   int
+		wantConc    int
+	}{
+		{10, 2, true, 100 * time.Millisecond, nil, 12, 2},
+		{10, 2, false, 100 * time.Millisecond, nil, 10, 2},
+		{100, 2, true, 100 * time.Millisecond, nil, 100, 2},
+		{10, 2, true, 2 * time.Second, nil, 10, 3},
+		{100, 4, true, 2 * time.Second, nil, 75, 4},
+		{10, 2, true, 0, errors.New("too large"), 5, 1},
+		{1, 1, true, 0, errors.New("too large"), 1, 1},
+		{2, 4, false, 0, nil, 2, 2},
+	}
+	for _, tc := range cases {
+		batch, conc := tu.next(tc.batch, tc.conc, tc.full, tc.elapsed, tc.err)
+		diff.Test(t, t.Errorf, batch, tc.wantBatch)
+		diff.Test(t, t.Errorf, conc, tc.wantConc)
+	}
+}
+
+func TestUploader(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	var (
+		got  *http.Request
+		body []byte
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer s.Close()
+
+	up, err := newUploader(config.Export{
+		URL:      "s3://data/shovel",
+		Endpoint: wos.EnvString(s.URL),
+	})
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Fatalf, up.upload(context.Background(), "shovel/a/ig_name=foo bar/0.parquet", strings.NewReader("x")), nil)
+	diff.Test(t, t.Errorf, got.Method, "PUT")
+	diff.Test(t, t.Errorf, got.URL.EscapedPath(), "/data/shovel/a/ig_name%3Dfoo%20bar/0.parquet")
+	diff.Test(t, t.Errorf, string(body), "x")
+	if !strings.Contains(got.Header.Get("Authorization"), "/us-east-1/s3/aws4_request") {
+		t.Errorf("unexpected authorization: %s", got.Header.Get("Authorization"))
+	}
+}
+
+func TestStaleExports(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		pg   = testpg(t)
+		task = &Task{
+			srcName: "src",
+			destConfig: config.Integration{
+				Name:   "foo",
+				Export: config.Export{URL: "s3://data"},
+			},
+		}
+	)
+	_, err := pg.Exec(ctx, `
+		insert into shovel.exports (src_name, ig_name, start, stop, nrows)
+		values ('src', 'foo', 0, 9, 1), ('src', 'foo', 10, 19, 1), ('src', 'foo', 20, 29, 1)
+	`)
+	diff.Test(t, t.Fatalf, err, nil)
+	blocks := []eth.Block{
+		{Header: eth.Header{Number: 12}},
+		{Header: eth.Header{Number: 20}},
+	}
+	diff.Test(t, t.Fatalf, task.staleExports(ctx, pg, blocks, 0), nil)
+	checkQuery(t, pg, `select count(*) = 3 from shovel.exports`)
+	diff.Test(t, t.Fatalf, task.staleExports(ctx, pg, blocks, 1), nil)
+	checkQuery(t, pg, `select array_agg(start order by start) = '{0}' from shovel.exports`)
+}
+
+func TestRefAddress(t *testing.T) {
+	cases := []struct {
+		v    any
+		want string
+		err  bool
+	}{
+		{[]byte{0xab, 0xcd}, "0xabcd", false},
+		{"0xabcd", "", true},
+		{int64(1), "", true},
+	}
+	for _, tc := range cases {
+		got, err := refAddress(tc.v)
+		diff.Test(t, t.Errorf, err != nil, tc.err)
+		diff.Test(t, t.Errorf, got, tc.want)
+	}
+}
+
+func TestRefreshAddresses(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		pg   = testpg(t)
+		task = &Task{
+			srcName: "src",
+			addrRef: dig.Ref{Integration: "factory", Table: "pools", Column: "pool"},
+		}
+		check = func(want ...string) {
+			t.Helper()
+			diff.Test(t, t.Fatalf, task.refreshAddresses(ctx, pg), nil)
+			diff.Test(t, t.Errorf, task.filter.Addresses(), want)
+		}
+		exec = func(q string) {
+			t.Helper()
+			_, err := pg.Exec(ctx, q)
+			diff.Test(t, t.Fatalf, err, nil)
+		}
+		progress = func(igName string, n int) {
+			t.Helper()
+			const q = `insert into shovel.task_updates (src_name, ig_name, num, hash) values ('src', $1, $2, $3)`
+			_, err := pg.Exec(ctx, q, igName, n, hash(byte(n)))
+			diff.Test(t, t.Fatalf, err, nil)
+		}
+	)
+	exec(`create table pools (pool bytea)`)
+	exec(`insert into pools values ('\xaa')`)
+	progress("factory", 1)
+	check("0xaa")
+
+	// not re-read until the integration makes progress
+	exec(`insert into pools values ('\xbb')`)
+	check("0xaa")
+	progress("factory", 2)
+	check("0xaa", "0xbb")
+
+	exec(`insert into pools values ('\xcc')`)
+	progress(BackfillName("factory", 0, 10), 5)
+	check("0xaa", "0xbb", "0xcc")
+
+	// tables that aren't managed by shovel
+	task.addrRef = dig.Ref{Table: "pools", Column: "pool", Refresh: "1h"}
+	task.addrRead = time.Time{}
+	exec(`insert into pools values ('\xdd')`)
+	check("0xaa", "0xbb", "0xcc", "0xdd")
+	exec(`insert into pools values ('\xee')`)
+	check("0xaa", "0xbb", "0xcc", "0xdd")
+	task.addrRef.Refresh = ""
+	check("0xaa", "0xbb", "0xcc", "0xdd", "0xee")
+}
+
+func TestThroughput(t *testing.T) {
+	now := time.Now()
+	record("stats-src", "stats-ig", sample{
+		t:       now.Add(-2 * time.Minute),
+		nblocks: 1000,
+		nrows:   1000,
+		load:    time.Hour,
+	})
+	record("stats-src", "stats-ig", sample{
+		t:       now.Add(-30 * time.Second),
+		nblocks: 60,
+		nrows:   120,
+		load:    2 * time.Second,
+		decode:  10 * time.Millisecond,
+		insert:  30 * time.Millisecond,
+	})
+	record("stats-src", "stats-ig", sample{
+		t:       now,
+		nblocks: 60,
+		nrows:   0,
+		load:    time.Second,
+		decode:  20 * time.Millisecond,
+		insert:  10 * time.Millisecond,
+	})
+	got := Throughput("stats-src", "stats-ig")
+	diff.Test(t, t.Errorf, got.BlocksPerSec, 2.0)
+	diff.Test(t, t.Errorf, got.RowsPerSec, 2.0)
+	diff.Test(t, t.Errorf, got.Load, 1500*time.Millisecond)
+	diff.Test(t, t.Errorf, got.Decode, 15*time.Millisecond)
+	diff.Test(t, t.Errorf, got.Insert, 20*time.Millisecond)
+	diff.Test(t, t.Errorf, Throughput("stats-src", "other"), Stats{})
+}
+
+func TestHistoryRates(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	points := []ProgressPoint{
+		{Time: t0, Num: 100},
+		{Time: t0.Add(10 * time.Minute), Num: 150},
+		{Time: t0.Add(30 * time.Minute), Num: 150},
+		{Time: t0.Add(40 * time.Minute), Num: 170},
+	}
+	rates(points)
+	var got []float64
+	for _, p := range points {
+		got = append(got, p.BlocksPerMin)
+	}
+	diff.Test(t, t.Errorf, got, []float64{0, 5, 0, 2})
+}
+
+func TestMergePoints(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var (
+		main = []ProgressPoint{
+			{Time: t0, Num: 100, Lag: 1},
+			{Time: t0.Add(10 * time.Minute), Num: 110},
+		}
+		other = []ProgressPoint{
+			{Time: t0.Add(10 * time.Minute), Num: 150, Lag: 3},
+			{Time: t0.Add(20 * time.Minute), Num: 160},
+		}
+	)
+	diff.Test(t, t.Errorf, mergePoints(main, other), []ProgressPoint{
+		{Time: t0, Num: 100, Lag: 1},
+		{Time: t0.Add(10 * time.Minute), Num: 150, Lag: 3, BlocksPerMin: 5},
+		{Time: t0.Add(20 * time.Minute), Num: 160, BlocksPerMin: 1},
+	})
+}
+
+func TestManager_Shutdown(t *testing.T) {
+	tm := NewManager(context.Background(), nil, config.Root{})
+	// a Converge that only returns when its ctx is cancelled
+	r := &runner{stop: make(chan struct{}), done: make(chan struct{})}
+	tm.runners["a/b"] = r
+	go func() {
+		<-r.stop
+		<-tm.ctx.Done()
+		close(r.done)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	const want = "rolled back in-flight tasks: context deadline exceeded"
+	diff.Test(t, t.Errorf, tm.Shutdown(ctx).Error(), want)
+	diff.Test(t, t.Errorf, tm.Running(), []string{})
+
+	tm.start(&Task{})
+	diff.Test(t, t.Errorf, tm.Running(), []string{})
+}
+
+func TestConverge_Atomic(t *testing.T) {
+	var (
+		pg   = testpg(t)
+		tg   = &testGeth{}
+		foo  = newTestDestination("foo")
+		bar  = newTestDestination("bar")
+		g    = &group{members: []member{{ig: foo.ig(), dest: foo}, {ig: bar.ig(), dest: bar}}}
+		task *Task
+		err  error
+	)
+	task, err = NewTask(
+		WithPG(pg),
+		WithSource(tg),
+		WithIntegration(config.Integration{Name: AtomicName}),
+		WithIntegrationFactory(func(config.Integration) (Destination, error) { return g, nil }),
+		WithConcurrency(1, 3),
+	)
+	diff.Test(t, t.Fatalf, err, nil)
+	task.members = []string{"foo", "bar"}
+
+	tg.add(0, hash(0), hash(0))
+	tg.add(1, hash(1), hash(0))
+	tg.add(2, hash(2), hash(1))
+
+	const q = `
+		insert into shovel.task_updates (src_name, ig_name, num, hash)
+		values ('', $1, $2, $3)
+	`
+	for _, r := range []struct {
+		ig string
+		n  uint64
+	}{{"foo", 0}, {"foo", 1}, {"bar", 0}} {
+		_, err := pg.Exec(context.Background(), q, r.ig, r.n, hash(byte(r.n)))
+		diff.Test(t, t.Fatalf, err, nil)
+	}
+
+	// foo is ahead so only bar indexes block 1
+	diff.Test(t, t.Fatalf, task.Converge(), nil)
+	diff.Test(t, t.Errorf, len(foo.blocks()), 1)
+	diff.Test(t, t.Errorf, bar.blocks(), tg.blocks[1:])
+	checkQuery(t, pg, `
+		select count(*) = 2
+		from shovel.task_updates
+		where num = 2
+		and ig_name in ('foo', 'bar')
+	`)
+}
+
+func TestSortDependencies(t *testing.T) {
+	igs := []config.Integration{
+		{Name: "swaps", Dependencies: []string{"pools"}},
+		{Name: "transfers"},
+		{Name: "pools", Dependencies: []string{"factories"}},
+		{Name: "factories"},
+	}
+	var got []string
+	for _, ig := range sortDependencies(igs) {
+		got = append(got, ig.Name)
+	}
+	diff.Test(t, t.Errorf, got, []string{"factories", "pools", "swaps", "transfers"})
+}
+
+func TestShards(t *testing.T) {
+	diff.Test(t, t.Errorf, Shards(1, 10, 1), [][2]uint64{{1, 10}})
+	diff.Test(t, t.Errorf, Shards(1, 10, 3), [][2]uint64{{1, 4}, {5, 8}, {9, 10}})
+	diff.Test(t, t.Errorf, Shards(1, 2, 4), [][2]uint64{{1, 1}, {2, 2}})
+	diff.Test(t, t.Errorf, Shards(5, 5, 2), [][2]uint64{{5, 5}})
+}
+
+func TestConverge_History(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		pg        = testpg(t)
+		tg        = &testGeth{}
+		dest      = newTestDestination("foo")
+		task, err = NewTask(
+			WithPG(pg),
+			WithSource(tg),
+			WithIntegration(dest.ig()),
+			WithIntegrationFactory(dest.factory),
+			WithHistory(1),
+		)
+	)
+	diff.Test(t, t.Fatalf, err, nil)
+	for i := byte(0); i <= 5; i++ {
+		tg.add(uint64(i), hash(i), hash(max(i, 1)-1))
+	}
+	diff.Test(t, t.Fatalf, task.Converge(), nil)
+	diff.Test(t, t.Errorf, dest.blocks(), tg.blocks[5:])
+
+	gaps, err := Gaps(ctx, pg)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, gaps, []Gap{{"", "foo", 1, 4}})
+
+	_, err = pg.Exec(ctx, `
+		insert into shovel.task_updates(src_name, ig_name, num)
+		values ('', $1, 4)
+	`, BackfillName("foo", 1, 4))
+	diff.Test(t, t.Fatalf, err, nil)
+	gaps, err = Gaps(ctx, pg)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, len(gaps), 0)
+}
+
+func TestSkip(t *testing.T) {
+	task := &Task{skip: []config.SkipBlocks{
+		{Start: 10, Stop: 10},
+		{Start: 20, Stop: 25},
+	}}
+	_, ok := task.skipped(9)
+	diff.Test(t, t.Errorf, ok, false)
+	sb, ok := task.skipped(22)
+	diff.Test(t, t.Errorf, ok, true)
+	diff.Test(t, t.Errorf, sb.Stop, uint64(25))
+
+	diff.Test(t, t.Errorf, task.beforeSkip(0, 5), uint64(5))
+	diff.Test(t, t.Errorf, task.beforeSkip(5, 10), uint64(4))
+	diff.Test(t, t.Errorf, task.beforeSkip(10, 20), uint64(9))
+}
+
+func TestPrefetch(t *testing.T) {
+	tg := &testGeth{}
+	for i := uint64(1); i <= 10; i++ {
+		tg.add(i, hash(byte(i)), hash(byte(i-1)))
+	}
+	var (
+		task = &Task{src: tg, batchSize: 2, concurrency: 1, decodeWorkers: 2}
+		ctx  = wctx.WithBackfill(context.Background(), true)
+	)
+	task.prefetch(ctx, "", 2, hash(2), 10)
+	blocks, n := task.prefetched(ctx, 3, 2)
+	diff.Test(t, t.Fatalf, n, uint64(2))
+	diff.Test(t, t.Errorf, blocks[0].Num(), uint64(3))
+	diff.Test(t, t.Errorf, blocks[1].Num(), uint64(4))
+
+	task.prefetch(ctx, "", 4, hash(4), 10)
+	_, n = task.prefetched(ctx, 3, 2)
+	diff.Test(t, t.Errorf, n, uint64(0))
+
+	task.prefetch(ctx, "", 4, hash(9), 10) // reorg
+	_, n = task.prefetched(ctx, 5, 2)
+	diff.Test(t, t.Errorf, n, uint64(0))
+
+	task.prefetch(ctx, "", 9, hash(9), 10)
+	blocks, n = task.prefetched(ctx, 10, 2)
+	diff.Test(t, t.Fatalf, n, uint64(1))
+	diff.Test(t, t.Errorf, blocks[0].Num(), uint64(10))
+
+	task.prefetch(context.Background(), "", 4, hash(4), 10)
+	diff.Test(t, t.Errorf, task.next == nil, true)
+}
+
+func TestConverge_Skip(t *testing.T) {
+	var (
+		pg        = testpg(t)
+		tg        = &testGeth{}
+		dest      = newTestDestination("foo")
+		task, err = NewTask(
+			WithPG(pg),
+			WithSource(tg),
+			WithIntegration(dest.ig()),
+			WithIntegrationFactory(dest.factory),
+			WithRange(1, 0),
+			WithConcurrency(1, 10),
+			WithSkip([]config.SkipBlocks{{Start: 2, Stop: 3, Reason: "bad"}}),
+		)
+	)
+	diff.Test(t, t.Fatalf, err, nil)
+	for i := byte(0); i <= 5; i++ {
+		tg.add(uint64(i), hash(i), hash(max(i, 1)-1))
+	}
+	for i := 0; i < 3; i++ {
+		diff.Test(t, t.Fatalf, task.Converge(), nil)
+	}
+	var got []uint64
+	for _, b := range dest.blocks() {
+		got = append(got, b.Num())
+	}
+	diff.Test(t, t.Errorf, got, []uint64{1, 4, 5})
+	checkQuery(t, pg, `
+		select skip_reason = 'bad' and nblocks = 2
+		from shovel.task_updates
+		where num = 3
+	`)
+}
+
+func TestPressure(t *testing.T) {
+	var (
+		ctx = context.Background()
+		p   = newPressure(time.Second, 4)
+	)
+	for i := 0; i < 4; i++ {
+		diff.Test(t, t.Fatalf, p.acquire(ctx), nil)
+	}
+	diff.Test(t, t.Errorf, p.tryAcquire(), false)
+
+	p.release(2 * time.Second)
+	diff.Test(t, t.Errorf, p.limit, 2)
+	diff.Test(t, t.Errorf, p.high(), true)
+	// 3 in flight with a limit of 2
+	diff.Test(t, t.Errorf, p.tryAcquire(), false)
+	p.release(2 * time.Second)
+	p.release(0)
+	diff.Test(t, t.Errorf, p.limit, 1)
+	diff.Test(t, t.Errorf, p.tryAcquire(), false)
+	p.release(time.Millisecond)
+	diff.Test(t, t.Errorf, p.limit, 2)
+	diff.Test(t, t.Errorf, p.tryAcquire(), true)
+	diff.Test(t, t.Errorf, p.tryAcquire(), true)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	diff.Test(t, t.Errorf, p.acquire(cctx), context.Canceled)
+}
+
+func TestDiscrepancies(t *testing.T) {
+	tg := &testGeth{}
+	tg.add(1, hash(1), hash(0))
+	tg.add(2, hash(2), hash(1))
+	tg.add(3, hash(3), hash(9))
+
+	ds, err := discrepancies(hash(0), tg.blocks[:2])
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, len(ds), 0)
+
+	ds, err = discrepancies(nil, tg.blocks)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Fatalf, len(ds), 1)
+	diff.Test(t, t.Errorf, ds[0].num, uint64(3))
+	diff.Test(t, t.Errorf, ds[0].expected, hash(2))
+
+	ds, err = discrepancies(hash(7), tg.blocks[:1])
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, len(ds), 1)
+
+	tg.add(4, nil, hash(3))
+	_, err = discrepancies(nil, tg.blocks)
+	diff.Test(t, t.Errorf, err != nil, true)
+}
+
+func TestConverge_VerifyChain(t *testing.T) {
+	var (
+		pg        = testpg(t)
+		tg        = &testGeth{}
+		dest      = newTestDestination("foo")
+		task, err = NewTask(
+			WithPG(pg),
+			WithSource(tg),
+			WithConcurrency(1, 3),
+			WithVerifyChain(true),
+			WithIntegration(dest.ig()),
+			WithIntegrationFactory(dest.factory),
+		)
+	)
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, task.filter.UseHeaders, true)
+
+	tg.add(0, hash(0), hash(0))
+	tg.add(1, hash(1), hash(0))
+	tg.add(2, hash(2), hash(9))
+	diff.Test(t, t.Fatalf, nil, task.update(pg, 0, hash(0), 0, hash(0), 0, 0, 0))
+
+	err = task.Converge()
+	diff.Test(t, t.Errorf, errors.Is(err, ErrDiscrepancy), true)
+	checkQuery(t, pg, `
+		select count(*) = 1
+		from shovel.chain_discrepancies
+		where src_name = $1 and ig_name = $2
+		and num = 2 and parent = $3 and expected = $4
+	`, task.srcName, dest.name, hash(9), hash(1))
+	diff.Test(t, t.Errorf, len(dest.blocks()), 0)
+}
@@ -0,0 +1,64 @@
+package shovel
+
+import (
+	"time"
+
+	"github.com/indexsupply/shovel/shovel/config"
+)
+
+// Adjusts a task's batch size and concurrency after each
+// load using additive increase and multiplicative decrease:
+//
+//   - A failed load (including responses that exceed a
+//     provider's size limit) halves the batch size and
+//     reduces concurrency by one.
+//   - A load slower than the target latency is split across
+//     another request, or when concurrency is at its max,
+//     the batch size is reduced by a quarter.
+//   - A full batch loaded in under half the target latency
+//     grows the batch size by a quarter.
+//
+// At the head of the chain batches are rarely full so the
+// values tuned during a backfill shrink as needed but
+// don't grow.
+type tuner struct {
+	minBatch, maxBatch int
+	maxConcurrency     int
+	target             time.Duration
+}
+
+func newTuner(a config.Adaptive) *tuner {
+	tu := &tuner{
+		minBatch:       max(1, a.MinBatchSize),
+		maxBatch:       a.MaxBatchSize,
+		maxConcurrency: a.MaxConcurrency,
+		target:         a.TargetLatency,
+	}
+	if tu.maxBatch == 0 {
+		tu.maxBatch = 2000
+	}
+	if tu.maxConcurrency == 0 {
+		tu.maxConcurrency = 10
+	}
+	if tu.target == 0 {
+		tu.target = 5 * time.Second
+	}
+	return tu
+}
+
+func (tu *tuner) next(batch, conc int, full bool, elapsed time.Duration, err error) (int, int) {
+	switch {
+	case err != nil:
+		batch /= 2
+		conc--
+	case elapsed > tu.target && conc < tu.maxConcurrency:
+		conc++
+	case elapsed > tu.target:
+		batch = batch * 3 / 4
+	case full && elapsed < tu.target/2:
+		batch += max(1, batch/4)
+	}
+	batch = min(max(batch, tu.minBatch), tu.maxBatch)
+	conc = min(max(conc, 1), tu.maxConcurrency, batch)
+	return batch, conc
+}
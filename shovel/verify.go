@@ -0,0 +1,83 @@
+package shovel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/indexsupply/shovel/eth"
+)
+
+// Returned when a batch's blocks aren't a chain, eg when a
+// load balancer sends requests to nodes on different forks.
+// The batch is loaded again.
+var ErrDiscrepancy = errors.New("parent hash mismatch")
+
+// A block whose parent hash isn't the hash of the block
+// before it
+type discrepancy struct {
+	num                    uint64
+	hash, parent, expected []byte
+}
+
+// blocks are sorted and contiguous. prev is the hash of the
+// block before the first block and may be empty (eg after a
+// skipped range).
+func discrepancies(prev []byte, blocks []eth.Block) ([]discrepancy, error) {
+	var res []discrepancy
+	for i := range blocks {
+		b := &blocks[i]
+		if len(b.Hash()) != 32 || len(b.Header.Parent) != 32 {
+			return nil, fmt.Errorf("block %d is missing its hash or parent hash", b.Num())
+		}
+		if len(prev) > 0 && !bytes.Equal(prev, b.Header.Parent) {
+			res = append(res, discrepancy{
+				num:      b.Num(),
+				hash:     b.Hash(),
+				parent:   b.Header.Parent,
+				expected: prev,
+			})
+		}
+		prev = b.Hash()
+	}
+	return res, nil
+}
+
+// Checks that each block's parent hash is the hash of the
+// block before it (localHash for the first block) and records
+// mismatches in shovel.chain_discrepancies. A mismatch at the
+// first block is a reorg and returns [ErrReorg]. A mismatch
+// within the batch returns [ErrDiscrepancy].
+func (t *Task) verify(ctx context.Context, localHash []byte, blocks []eth.Block) error {
+	ds, err := discrepancies(localHash, blocks)
+	if err != nil {
+		return fmt.Errorf("verifying chain: %w", err)
+	}
+	if len(ds) == 0 {
+		return nil
+	}
+	const q = `
+		insert into shovel.chain_discrepancies
+		(src_name, ig_name, num, hash, parent, expected)
+		values ($1, $2, $3, $4, $5, $6)
+	`
+	for _, d := range ds {
+		slog.WarnContext(ctx, "chain-discrepancy",
+			"n", d.num,
+			"h", fmt.Sprintf("%.4x", d.hash),
+			"parent", fmt.Sprintf("%.4x", d.parent),
+			"expected", fmt.Sprintf("%.4x", d.expected),
+		)
+		_, err := t.pgp.Exec(ctx, q, t.srcName, t.destConfig.Name, d.num, d.hash, d.parent, d.expected)
+		if err != nil {
+			return fmt.Errorf("recording chain discrepancy: %w", err)
+		}
+	}
+	last := ds[len(ds)-1]
+	if last.num != blocks[0].Num() {
+		return fmt.Errorf("block %d: %w", last.num, ErrDiscrepancy)
+	}
+	return ErrReorg
+}
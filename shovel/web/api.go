@@ -0,0 +1,190 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/indexsupply/shovel/eth"
+	"github.com/indexsupply/shovel/jrpc2"
+	"github.com/indexsupply/shovel/shovel"
+)
+
+// JSON API for automation. Routes:
+//
+//	GET  /api/sources
+//	GET  /api/integrations
+//	GET  /api/tasks
+//	POST /api/sources/<name>/enable|disable
+//	POST /api/integrations/<name>/enable|disable
+//
+// Disabling pauses the matching tasks until they are
+// enabled or the process restarts. See [shovel.Manager.Pause].
+func (h *Handler) API(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api"), "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 1:
+		switch parts[0] {
+		case "sources":
+			h.apiSources(w, r)
+		case "integrations":
+			h.apiIntegrations(w, r)
+		case "tasks":
+			h.apiTasks(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	case r.Method == http.MethodPost && len(parts) == 3:
+		var src, ig string
+		switch parts[0] {
+		case "sources":
+			src = parts[1]
+		case "integrations":
+			ig = parts[1]
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		h.apiToggle(w, r, src, ig, parts[2])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("content-type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+type apiSource struct {
+	Name    string `json:"name"`
+	ChainID uint64 `json:"chain_id"`
+	// urls may contain credentials
+	Hosts  []string `json:"hosts"`
+	Paused bool     `json:"paused"`
+
+	// Circuit breaker state keyed by host
+	Circuits map[string]jrpc2.CircuitState `json:"circuits"`
+}
+
+func (h *Handler) apiSources(w http.ResponseWriter, r *http.Request) {
+	srcs, err := h.config().AllSources(r.Context(), h.pgp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := []apiSource{}
+	for _, sc := range srcs {
+		as := apiSource{
+			Name:     sc.Name,
+			ChainID:  sc.ChainID,
+			Hosts:    []string{},
+			Paused:   h.mgr.IsPaused(sc.Name, ""),
+			Circuits: map[string]jrpc2.CircuitState{},
+		}
+		for _, u := range sc.URLs {
+			ju := jrpc2.MustURL(u)
+			as.Hosts = append(as.Hosts, ju.Hostname())
+			as.Circuits[ju.Hostname()] = ju.State(time.Now())
+		}
+		res = append(res, as)
+	}
+	writeJSON(w, res)
+}
+
+type apiIntegration struct {
+	Name    string   `json:"name"`
+	Enabled bool     `json:"enabled"`
+	Paused  bool     `json:"paused"`
+	Table   string   `json:"table"`
+	Sources []string `json:"sources"`
+}
+
+func (h *Handler) apiIntegrations(w http.ResponseWriter, r *http.Request) {
+	igs, err := h.config().AllIntegrations(r.Context(), h.pgp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := []apiIntegration{}
+	for _, ig := range igs {
+		ai := apiIntegration{
+			Name:    ig.Name,
+			Enabled: ig.Enabled,
+			Paused:  h.mgr.IsPaused("", ig.Name),
+			Table:   ig.Table.QName(),
+			Sources: []string{},
+		}
+		for _, sc := range ig.Sources {
+			ai.Sources = append(ai.Sources, sc.Name)
+		}
+		res = append(res, ai)
+	}
+	writeJSON(w, res)
+}
+
+type apiTask struct {
+	Src     string    `json:"src"`
+	IG      string    `json:"ig"`
+	Num     uint64    `json:"num"`
+	Hash    eth.Bytes `json:"hash"`
+	SrcNum  uint64    `json:"src_num"`
+	NBlocks uint64    `json:"nblocks"`
+	NRows   uint64    `json:"nrows"`
+	Latency string    `json:"latency"`
+	Errors  uint64    `json:"errors"`
+	Paused  bool      `json:"paused"`
+
+	Stats shovel.Stats `json:"stats"`
+}
+
+func (h *Handler) apiTasks(w http.ResponseWriter, r *http.Request) {
+	tus, err := h.pools().TaskUpdates(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := []apiTask{}
+	for _, tu := range tus {
+		res = append(res, apiTask{
+			Src:     tu.SrcName,
+			IG:      tu.DestName,
+			Num:     tu.Num,
+			Hash:    tu.Hash,
+			SrcNum:  tu.SrcNum,
+			NBlocks: tu.NBlocks,
+			NRows:   tu.NRows,
+			Latency: tu.Latency.String(),
+			Errors:  shovel.Errors(tu.SrcName, tu.DestName),
+			Paused:  h.mgr.IsPaused(tu.SrcName, tu.DestName),
+			Stats:   tu.Stats,
+		})
+	}
+	slices.SortFunc(res, func(a, b apiTask) int {
+		return strings.Compare(a.Src+"/"+a.IG, b.Src+"/"+b.IG)
+	})
+	writeJSON(w, res)
+}
+
+func (h *Handler) apiToggle(w http.ResponseWriter, r *http.Request, src, ig, action string) {
+	var (
+		err    error
+		paused = slices.Contains(h.mgr.Paused(), src+"/"+ig)
+	)
+	switch {
+	case action == "disable" && !paused:
+		err = h.mgr.Pause(src, ig)
+	case action == "enable" && paused:
+		err = h.mgr.Resume(src, ig)
+	case action != "disable" && action != "enable":
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, h.mgr.Paused())
+}
@@ -0,0 +1,64 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/indexsupply/shovel/shovel"
+)
+
+const (
+	sparkWidth  = 240
+	sparkHeight = 30
+)
+
+// Sparklines of a source's progress over the last day
+type Chart struct {
+	Blocks    template.HTML
+	MaxBlocks float64
+	Lag       template.HTML
+	MaxLag    float64
+}
+
+func newChart(points []shovel.ProgressPoint) Chart {
+	var blocks, lag []float64
+	for i, p := range points {
+		if i > 0 {
+			blocks = append(blocks, p.BlocksPerMin)
+		}
+		lag = append(lag, float64(p.Lag))
+	}
+	var c Chart
+	c.Blocks, c.MaxBlocks = sparkline(blocks)
+	c.Lag, c.MaxLag = sparkline(lag)
+	return c
+}
+
+// An SVG polyline of vs scaled so that the largest
+// value touches the top. Returns the largest value.
+func sparkline(vs []float64) (template.HTML, float64) {
+	if len(vs) < 2 {
+		return "", 0
+	}
+	var top float64
+	for _, v := range vs {
+		top = max(top, v)
+	}
+	var (
+		pts  []string
+		step = float64(sparkWidth) / float64(len(vs)-1)
+	)
+	for i, v := range vs {
+		y := float64(sparkHeight)
+		if top > 0 {
+			y -= v / top * float64(sparkHeight-2)
+		}
+		pts = append(pts, fmt.Sprintf("%.1f,%.1f", float64(i)*step, y))
+	}
+	return template.HTML(fmt.Sprintf(
+		`<svg width="%d" height="%d" viewBox="0 0 %d %d"><polyline fill="none" stroke="currentColor" stroke-width="1" points="%s"/></svg>`,
+		sparkWidth, sparkHeight, sparkWidth, sparkHeight,
+		strings.Join(pts, " "),
+	)), top
+}
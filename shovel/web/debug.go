@@ -0,0 +1,43 @@
+package web
+
+import (
+	"expvar"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+var started = time.Now()
+
+func init() {
+	expvar.Publish("runtime", expvar.Func(func() any {
+		return map[string]any{
+			"go_version":     runtime.Version(),
+			"goroutines":     runtime.NumGoroutine(),
+			"gomaxprocs":     runtime.GOMAXPROCS(0),
+			"num_cpu":        runtime.NumCPU(),
+			"uptime_seconds": int64(time.Since(started).Seconds()),
+		}
+	}))
+}
+
+// Serves next when dashboard.enable_debug is set and
+// responds not found otherwise. Requests are authenticated
+// like the rest of the dashboard since profiles may contain
+// secrets (eg the process's command line).
+func (h *Handler) Debug(next http.HandlerFunc) http.Handler {
+	authn := h.Authn(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.config().Dashboard.EnableDebug {
+			http.NotFound(w, r)
+			return
+		}
+		authn.ServeHTTP(w, r)
+	})
+}
+
+// Runtime stats in expvar's format: memstats, cmdline,
+// and a runtime object with goroutine counts and uptime.
+func (h *Handler) Vars(w http.ResponseWriter, r *http.Request) {
+	expvar.Handler().ServeHTTP(w, r)
+}
@@ -0,0 +1,156 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/indexsupply/shovel/shovel/config"
+	"github.com/indexsupply/shovel/shovel/gql"
+	"github.com/indexsupply/shovel/wpg"
+
+	"github.com/graphql-go/graphql"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	graphqlTimeout = 5 * time.Second
+	graphqlConns   = 4
+)
+
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// Serves read-only queries over the integration tables.
+// See [gql.Schema]. Accepts POST requests with a JSON body
+// and GET requests with query and variables url params.
+//
+// Requires authentication unless dashboard.public_graphql
+// is set, in which case any origin may make requests. Since
+// anyone may query, each request is limited (see [gql.Check]
+// and [gql.MaxLimit]), times out after graphqlTimeout, and
+// uses a small pool that is separate from the tasks' pools.
+func (h *Handler) GraphQL(w http.ResponseWriter, r *http.Request) {
+	if !h.config().Dashboard.PublicGraphQL {
+		h.Authn(h.graphql).ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("access-control-allow-origin", "*")
+	w.Header().Set("access-control-allow-headers", "content-type")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	h.graphql(w, r)
+}
+
+func (h *Handler) graphql(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if v := r.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				http.Error(w, "decoding variables: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "decoding request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := gql.Check(req.Query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	schema, err := h.graphqlSchema(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), graphqlTimeout)
+	defer cancel()
+	writeJSON(w, graphql.Do(graphql.Params{
+		Schema:         schema,
+		RequestString:  req.Query,
+		OperationName:  req.OperationName,
+		VariableValues: req.Variables,
+		Context:        ctx,
+	}))
+}
+
+// Queries use a pool per database with few connections whose
+// statements are read-only and time out. Must hold gqlMut.
+func (h *Handler) graphqlPool(db string, pg *pgxpool.Pool) (*pgxpool.Pool, error) {
+	if gp, ok := h.gqlPG[db]; ok {
+		return gp, nil
+	}
+	pc := pg.Config().Copy()
+	pc.MaxConns = graphqlConns
+	pc.MinConns = 0
+	pc.ConnConfig.RuntimeParams["statement_timeout"] = fmt.Sprint(graphqlTimeout.Milliseconds())
+	pc.ConnConfig.RuntimeParams["default_transaction_read_only"] = "on"
+	gp, err := pgxpool.NewWithConfig(context.Background(), pc)
+	if err != nil {
+		return nil, fmt.Errorf("connecting graphql pool: %w", err)
+	}
+	if h.gqlPG == nil {
+		h.gqlPG = map[string]*pgxpool.Pool{}
+	}
+	h.gqlPG[db] = gp
+	return gp, nil
+}
+
+// Integrations may be added while running so the schema
+// is rebuilt when the set of tables changes.
+func (h *Handler) graphqlSchema(r *http.Request) (graphql.Schema, error) {
+	igs, err := h.config().AllIntegrations(r.Context(), h.pgp)
+	if err != nil {
+		return graphql.Schema{}, err
+	}
+	var (
+		tables = config.Tables(config.Root{Integrations: igs})
+		dbs    = map[string]string{}
+	)
+	for _, ig := range igs {
+		dbs[ig.Table.QName()] = ig.Database
+	}
+	key, err := json.Marshal([]any{tables, dbs})
+	if err != nil {
+		return graphql.Schema{}, err
+	}
+
+	h.gqlMut.Lock()
+	defer h.gqlMut.Unlock()
+	if h.gqlKey == string(key) {
+		return h.gqlSchema, nil
+	}
+	var (
+		pools = h.pools()
+		conns = map[string]wpg.Conn{}
+	)
+	for _, ig := range igs {
+		gp, err := h.graphqlPool(ig.Database, pools.For(ig))
+		if err != nil {
+			return graphql.Schema{}, err
+		}
+		conns[ig.Table.QName()] = gp
+	}
+	schema, err := gql.Schema(conns, tables)
+	if err != nil {
+		return graphql.Schema{}, err
+	}
+	h.gqlKey, h.gqlSchema = string(key), schema
+	return schema, nil
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/indexsupply/shovel/shovel"
+)
+
+// Reports that the process is serving requests.
+// See [Handler.Readyz] for indexing progress.
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+type readySource struct {
+	Name   string `json:"name"`
+	Latest uint64 `json:"latest"`
+	Local  uint64 `json:"local"`
+	Lag    uint64 `json:"lag"`
+	Paused bool   `json:"paused"`
+	Ready  bool   `json:"ready"`
+	Error  string `json:"error,omitempty"`
+}
+
+type readyResult struct {
+	Ready   bool          `json:"ready"`
+	PGError string        `json:"pg_error,omitempty"`
+	MaxLag  uint64        `json:"max_lag"`
+	Sources []readySource `json:"sources"`
+}
+
+// Responds with 200 when pg is reachable and every source
+// that isn't paused is within health.max_lag blocks of its
+// latest block. Otherwise responds with 503. The body
+// includes each source's lag in either case.
+//
+// Errors are logged rather than returned since the
+// endpoint doesn't require authentication.
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	res := readyResult{
+		Ready:   true,
+		MaxLag:  h.config().Health.MaxLag,
+		Sources: []readySource{},
+	}
+	if res.MaxLag == 0 {
+		res.MaxLag = 100
+	}
+	defer func() {
+		if !res.Ready {
+			w.Header().Set("content-type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		writeJSON(w, res)
+	}()
+
+	if _, err := h.pgp.Exec(ctx, "select 1"); err != nil {
+		slog.ErrorContext(ctx, "readyz-pg", "error", err)
+		res.Ready = false
+		res.PGError = "unable to reach pg"
+		return
+	}
+	scs, err := h.config().AllSources(ctx, h.pgp)
+	if err != nil {
+		slog.ErrorContext(ctx, "readyz-sources", "error", err)
+		res.Ready = false
+		res.PGError = "unable to load sources"
+		return
+	}
+	for _, sc := range scs {
+		rs := readySource{
+			Name:   sc.Name,
+			Paused: h.mgr.IsPaused(sc.Name, ""),
+		}
+		local, err := h.pools().Latest(ctx, sc.Name)
+		rs.Local = local
+		if err != nil {
+			slog.ErrorContext(ctx, "readyz-local", "src", sc.Name, "error", err)
+			rs.Error = "unable to query task_updates"
+		}
+		src := shovel.NewClient(sc)
+		latest, _, err := src.Latest(ctx, src.NextURL().String(), 0)
+		if err != nil {
+			slog.ErrorContext(ctx, "readyz-latest", "src", sc.Name, "error", err)
+			rs.Error = "unable to reach source"
+		}
+		rs.Latest = latest
+		if latest > rs.Local {
+			rs.Lag = latest - rs.Local
+		}
+		rs.Ready = rs.Paused || (rs.Error == "" && rs.Lag <= res.MaxLag)
+		res.Ready = res.Ready && rs.Ready
+		res.Sources = append(res.Sources, rs)
+	}
+}
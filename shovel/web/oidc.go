@@ -0,0 +1,131 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/indexsupply/shovel/shovel/config"
+	"github.com/indexsupply/shovel/woidc"
+
+	"github.com/kr/session"
+)
+
+// Kept in a short lived cookie between the
+// redirect to the provider and the callback.
+type oidcState struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+func (h *Handler) oidcSess(r *http.Request) *session.Config {
+	c := session.DefaultCookie
+	c.Name = "shovel_oidc"
+	c.MaxAge = 10 * 60
+	c.Secure = !isLoopback(r)
+	return &session.Config{Keys: h.sess.Keys, Cookie: &c}
+}
+
+// Redirects to the provider's login page
+func (h *Handler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	st := oidcState{
+		State:    woidc.Random(),
+		Nonce:    woidc.Random(),
+		Verifier: woidc.Random(),
+	}
+	u, err := h.oidc.AuthURL(r.Context(), st.State, st.Nonce, st.Verifier)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "oidc-login", "error", err)
+		http.Error(w, "unable to reach identity provider", http.StatusBadGateway)
+		return
+	}
+	if err := session.Set(w, &st, h.oidcSess(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, u, http.StatusSeeOther)
+}
+
+// Verifies the provider's response and, when the user
+// is allowed (see [allowed]), starts a session.
+func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ctx := r.Context()
+	if e := r.URL.Query().Get("error"); e != "" {
+		slog.InfoContext(ctx, "oidc-callback", "error", e)
+		http.Error(w, "login failed: "+e, http.StatusUnauthorized)
+		return
+	}
+	var st oidcState
+	if err := session.Get(r, &st, h.oidcSess(r)); err != nil {
+		http.Error(w, "login expired. try again", http.StatusBadRequest)
+		return
+	}
+	session.Set(w, &oidcState{}, h.oidcSess(r))
+	if st.State == "" || r.URL.Query().Get("state") != st.State {
+		http.Error(w, "invalid state", http.StatusBadRequest)
+		return
+	}
+	claims, err := h.oidc.Exchange(ctx, r.URL.Query().Get("code"), st.Nonce, st.Verifier)
+	if err != nil {
+		slog.ErrorContext(ctx, "oidc-callback", "error", err)
+		http.Error(w, "login failed", http.StatusUnauthorized)
+		return
+	}
+	user := claims.String("email")
+	if user == "" {
+		user = claims.String("sub")
+	}
+	if !allowed(h.config().Dashboard.OIDC, claims) {
+		slog.InfoContext(ctx, "oidc-denied", "user", user)
+		http.Error(w, "not an allowed user", http.StatusForbidden)
+		return
+	}
+	slog.InfoContext(ctx, "oidc-login", "user", user)
+	h.setSession(w, r)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Reports whether the user is in one of the allowed groups,
+// or has a verified email that is allowed or is at one of
+// the allowed domains. Nobody is allowed when all are empty.
+func allowed(conf *config.OIDC, claims woidc.Claims) bool {
+	group := slices.ContainsFunc(claims.Strings(conf.GroupsClaim), func(g string) bool {
+		return slices.Contains(conf.AllowedGroups, g)
+	})
+	if group {
+		return true
+	}
+	email := strings.ToLower(claims.String("email"))
+	// some providers send email_verified as a string
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		if !v {
+			return false
+		}
+	case string:
+		if v != "true" {
+			return false
+		}
+	default:
+		return false
+	}
+	if email == "" {
+		return false
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	return slices.ContainsFunc(conf.AllowedEmails, func(e string) bool {
+		return strings.EqualFold(e, email)
+	}) || slices.ContainsFunc(conf.AllowedDomains, func(d string) bool {
+		return strings.EqualFold(d, domain)
+	})
+}
@@ -0,0 +1,166 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/indexsupply/shovel/shovel/sink"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	queryTimeout = "10s"
+	queryLimit   = 500
+	queryConns   = 2
+)
+
+type queryView struct {
+	Limit     int
+	Databases []string
+}
+
+type queryResult struct {
+	Columns   []string   `json:"columns"`
+	Rows      [][]string `json:"rows"`
+	Truncated bool       `json:"truncated"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// The console connects using dashboard.query_pg_url, which
+// should be a role that can only select from the tables that
+// users may read, eg:
+//
+//	create role shovel_query login password '...';
+//	grant usage on schema public to shovel_query;
+//	grant select on all tables in schema public to shovel_query;
+//
+// Queries run in read-only transactions with a statement
+// timeout on a pool with few connections. Other databases
+// (see [config.Database]) are queried using their query_url.
+func (h *Handler) queryPool(db string) (*pgxpool.Pool, error) {
+	h.queryMut.Lock()
+	defer h.queryMut.Unlock()
+	if pg, ok := h.queryPG[db]; ok {
+		return pg, nil
+	}
+	url, err := h.queryURL(db)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		return nil, fmt.Errorf("parsing query_pg_url: %w", err)
+	}
+	pc.MaxConns = queryConns
+	pc.ConnConfig.RuntimeParams["statement_timeout"] = queryTimeout
+	pc.ConnConfig.RuntimeParams["default_transaction_read_only"] = "on"
+	pg, err := pgxpool.NewWithConfig(context.Background(), pc)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to query url: %w", err)
+	}
+	if h.queryPG == nil {
+		h.queryPG = map[string]*pgxpool.Pool{}
+	}
+	h.queryPG[db] = pg
+	return pg, nil
+}
+
+func (h *Handler) queryURL(db string) (string, error) {
+	if len(db) == 0 {
+		if len(h.config().Dashboard.QueryPGURL) == 0 {
+			return "", fmt.Errorf("the query console requires dashboard.query_pg_url")
+		}
+		return string(h.config().Dashboard.QueryPGURL), nil
+	}
+	for _, d := range h.config().Databases {
+		if d.Name != db {
+			continue
+		}
+		if len(d.QueryURL) == 0 {
+			return "", fmt.Errorf("database %s has no query_url", db)
+		}
+		return string(d.QueryURL), nil
+	}
+	return "", fmt.Errorf("unknown database: %s", db)
+}
+
+// Runs q in a read-only transaction that is always rolled
+// back. The statement is prepared so that multiple statements
+// are rejected by pg. At most queryLimit rows are returned.
+func (h *Handler) runQuery(ctx context.Context, db, q string) (queryResult, error) {
+	var res queryResult
+	pgp, err := h.queryPool(db)
+	if err != nil {
+		return res, err
+	}
+	tx, err := pgp.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return res, fmt.Errorf("starting tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "set local statement_timeout = '"+queryTimeout+"'"); err != nil {
+		return res, fmt.Errorf("setting timeout: %w", err)
+	}
+	rows, err := tx.Query(ctx, q, pgx.QueryExecModeDescribeExec)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for _, fd := range rows.FieldDescriptions() {
+		res.Columns = append(res.Columns, fd.Name)
+	}
+	for rows.Next() {
+		if len(res.Rows) == queryLimit {
+			res.Truncated = true
+			break
+		}
+		vals, err := rows.Values()
+		if err != nil {
+			return res, err
+		}
+		row := make([]string, len(vals))
+		for i := range vals {
+			row[i] = sink.Text(vals[i])
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	rows.Close()
+	return res, rows.Err()
+}
+
+// Serves the query console. POST requests run the query in
+// the request body and respond with a queryResult.
+func (h *Handler) Query(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		t, err := h.template(isLoopback(r), "query")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		view := queryView{Limit: queryLimit}
+		for _, db := range h.config().Databases {
+			view.Databases = append(view.Databases, db.Name)
+		}
+		if err := t.Execute(w, view); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	var req struct {
+		Database string `json:"database"`
+		Query    string `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "decoding request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, err := h.runQuery(r.Context(), req.Database, req.Query)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	writeJSON(w, res)
+}
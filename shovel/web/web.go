@@ -13,6 +13,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,9 +22,12 @@ import (
 	"github.com/indexsupply/shovel/jrpc2"
 	"github.com/indexsupply/shovel/shovel"
 	"github.com/indexsupply/shovel/shovel/config"
+	"github.com/indexsupply/shovel/woidc"
+	"github.com/indexsupply/shovel/wprom"
 	"github.com/indexsupply/shovel/wstrings"
 
 	"filippo.io/age"
+	"github.com/graphql-go/graphql"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/kr/session"
 )
@@ -43,6 +47,9 @@ var (
 
 	//go:embed add-integration.html
 	addIntegrationHTML string
+
+	//go:embed query.html
+	queryHTML string
 )
 
 var htmlpages = map[string]string{
@@ -51,12 +58,12 @@ var htmlpages = map[string]string{
 	"login":           loginHTML,
 	"add-source":      addSourceHTML,
 	"add-integration": addIntegrationHTML,
+	"query":           queryHTML,
 }
 
 type Handler struct {
-	pgp  *pgxpool.Pool
-	mgr  *shovel.Manager
-	conf *config.Root
+	pgp *pgxpool.Pool
+	mgr *shovel.Manager
 
 	clientsMutex sync.Mutex
 	clients      map[string]chan []byte
@@ -65,27 +72,51 @@ type Handler struct {
 
 	sess     session.Config
 	password []byte
+	oidc     *woidc.Client
 
 	// global rate limit for diag requests
 	diagLastReqMut sync.Mutex
 	diagLastReq    time.Time
+
+	// see [Handler.queryPool]
+	queryMut sync.Mutex
+	queryPG  map[string]*pgxpool.Pool
+
+	// cached by the json encoding of its tables
+	gqlMut    sync.Mutex
+	gqlKey    string
+	gqlSchema graphql.Schema
+	gqlPG     map[string]*pgxpool.Pool
 }
 
-func New(mgr *shovel.Manager, conf *config.Root, pgp *pgxpool.Pool) *Handler {
+// The dashboard's password and oidc client are read from
+// the manager's config when the handler is created. Everything
+// else reads the manager's current config (see [shovel.Manager.Config])
+// so that reloads are picked up.
+func New(mgr *shovel.Manager, pgp *pgxpool.Pool) *Handler {
 	h := &Handler{
 		pgp:       pgp,
 		mgr:       mgr,
-		conf:      conf,
 		clients:   make(map[string]chan []byte),
 		templates: make(map[string]*template.Template),
 	}
+	conf := h.config()
 	cookieID, err := age.GenerateX25519Identity()
 	if err != nil {
 		panic(err)
 	}
 	h.sess.Keys = append(h.sess.Keys, cookieID)
 	h.password = []byte(conf.Dashboard.RootPassword)
-	if len(h.password) == 0 {
+	if oc := conf.Dashboard.OIDC; oc != nil {
+		h.oidc = woidc.New(woidc.Config{
+			Issuer:       string(oc.Issuer),
+			ClientID:     string(oc.ClientID),
+			ClientSecret: string(oc.ClientSecret),
+			RedirectURL:  string(oc.RedirectURL),
+			Scopes:       oc.Scopes,
+		})
+	}
+	if len(h.password) == 0 && h.oidc == nil {
 		b := make([]byte, 8)
 		rand.Read(b)
 		h.password = make([]byte, hex.EncodedLen(len(b)))
@@ -94,13 +125,31 @@ func New(mgr *shovel.Manager, conf *config.Root, pgp *pgxpool.Pool) *Handler {
 	return h
 }
 
+func (h *Handler) config() config.Root {
+	if h.mgr == nil {
+		return config.Root{}
+	}
+	return h.mgr.Config()
+}
+
+// Pools for every database. Integrations that use another
+// database record their progress there.
+func (h *Handler) pools() shovel.Pools {
+	if h.mgr != nil {
+		if p := h.mgr.Pools(); len(p) > 0 {
+			return p
+		}
+	}
+	return shovel.Pools{"": h.pgp}
+}
+
 func (h *Handler) Authn(next http.HandlerFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if h.conf.Dashboard.DisableAuthn {
+		if h.config().Dashboard.DisableAuthn {
 			next(w, r)
 			return
 		}
-		if !h.conf.Dashboard.EnableLoopbackAuthn && isLoopback(r) {
+		if !h.config().Dashboard.EnableLoopbackAuthn && isLoopback(r) {
 			next(w, r)
 			return
 		}
@@ -114,7 +163,7 @@ func (h *Handler) Authn(next http.HandlerFunc) http.Handler {
 }
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
-	if h.conf.Dashboard.RootPassword == "" {
+	if h.config().Dashboard.RootPassword == "" && h.oidc == nil {
 		slog.InfoContext(r.Context(), "random-temp-password",
 			"password", string(h.password),
 		)
@@ -126,7 +175,11 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if err := tmpl.Execute(w, nil); err != nil {
+		err = tmpl.Execute(w, struct{ Password, OIDC bool }{
+			Password: len(h.password) > 0,
+			OIDC:     h.oidc != nil,
+		})
+		if err != nil {
 			slog.ErrorContext(r.Context(), "template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -137,16 +190,11 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		supplied := []byte(r.FormValue("password"))
-		if subtle.ConstantTimeCompare(supplied, h.password) != 1 {
+		if len(h.password) == 0 || subtle.ConstantTimeCompare(supplied, h.password) != 1 {
 			http.Error(w, "invalid password", http.StatusUnauthorized)
 			return
 		}
-		if isLoopback(r) {
-			c := session.DefaultCookie
-			c.Secure = false
-			h.sess.Cookie = &c
-		}
-		session.Set(w, &struct{}{}, &h.sess)
+		h.setSession(w, r)
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	default:
 		http.Error(w, "must be post or get", http.StatusMethodNotAllowed)
@@ -154,6 +202,15 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *Handler) setSession(w http.ResponseWriter, r *http.Request) {
+	if isLoopback(r) {
+		c := session.DefaultCookie
+		c.Secure = false
+		h.sess.Cookie = &c
+	}
+	session.Set(w, &struct{}{}, &h.sess)
+}
+
 func isLoopback(r *http.Request) bool {
 	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
@@ -193,14 +250,7 @@ func (h *Handler) Prom(w http.ResponseWriter, r *http.Request) {
 			pgErr, srcErr       int
 		)
 		// PG
-		const q = `
-			select num
-			from shovel.task_updates
-			where src_name = $1
-			order by num desc
-			limit 1
-		`
-		err := h.pgp.QueryRow(r.Context(), q, srcName).Scan(&pgLatest)
+		pgLatest, err := h.pools().Latest(r.Context(), srcName)
 		if err != nil {
 			pgErr++
 		}
@@ -241,19 +291,23 @@ func (h *Handler) Prom(w http.ResponseWriter, r *http.Request) {
 		return res
 	}
 
-	scs, err := h.conf.AllSources(r.Context(), h.pgp)
+	scs, err := h.config().AllSources(r.Context(), h.pgp)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	var res []string
 	for _, sc := range scs {
-		src := jrpc2.New(sc.URLs...)
+		src := shovel.NewClient(sc)
 		for _, line := range checkSource(sc.Name, src) {
 			res = append(res, line)
 		}
 	}
 	fmt.Fprintf(w, strings.Join(res, "\n"))
+	fmt.Fprintf(w, "\n")
+	if err := wprom.Write(w); err != nil {
+		slog.ErrorContext(r.Context(), "writing metrics", "error", err)
+	}
 }
 
 func (h *Handler) Diag(w http.ResponseWriter, r *http.Request) {
@@ -273,14 +327,8 @@ func (h *Handler) Diag(w http.ResponseWriter, r *http.Request) {
 	)
 	checkPG := func(dr *DiagResult) {
 		start := time.Now()
-		const q = `
-			select num
-			from shovel.task_updates
-			where src_name = $1
-			order by num desc
-			limit 1
-		`
-		err := h.pgp.QueryRow(ctx, q, dr.Source).Scan(&dr.PGLatest)
+		var err error
+		dr.PGLatest, err = h.pools().Latest(ctx, dr.Source)
 		dr.PGLatency = uint64(time.Since(start) / time.Millisecond)
 		if err != nil {
 			dr.PGError = err.Error()
@@ -295,7 +343,7 @@ func (h *Handler) Diag(w http.ResponseWriter, r *http.Request) {
 		dr.Latest = n
 		dr.Latency = uint64(time.Since(start) / time.Millisecond)
 	}
-	scs, err := h.conf.AllSources(ctx, h.pgp)
+	scs, err := h.config().AllSources(ctx, h.pgp)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -303,7 +351,7 @@ func (h *Handler) Diag(w http.ResponseWriter, r *http.Request) {
 	for _, sc := range scs {
 		var (
 			dr  = &DiagResult{Source: sc.Name}
-			src = jrpc2.New(sc.URLs...)
+			src = shovel.NewClient(sc)
 		)
 		checkPG(dr)
 		checkSrc(src, dr)
@@ -317,7 +365,7 @@ func (h *Handler) Diag(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) PushUpdates() error {
 	ctx := context.Background()
 	for ; ; h.mgr.Updates() {
-		tus, err := shovel.TaskUpdates(ctx, h.pgp)
+		tus, err := h.pools().TaskUpdates(ctx)
 		if err != nil {
 			return fmt.Errorf("querying task updates: %w", err)
 		}
@@ -357,6 +405,76 @@ func (h *Handler) template(local bool, name string) (*template.Template, error)
 	return t, nil
 }
 
+// Validates ig as if it were added to the running config
+// and returns the fixed integration and the statements that
+// migrating its table would run. Integrations defined in
+// the config file can't be changed from the dashboard.
+func (h *Handler) checkIntegration(ctx context.Context, ig config.Integration) (config.Integration, []string, error) {
+	if len(ig.Name) == 0 {
+		return ig, nil, fmt.Errorf("missing name")
+	}
+	rc := h.config()
+	for i := range rc.Integrations {
+		if rc.Integrations[i].Name == ig.Name {
+			return ig, nil, fmt.Errorf("%s is defined in the config file", ig.Name)
+		}
+	}
+	srcs, err := rc.AllSources(ctx, h.pgp)
+	if err != nil {
+		return ig, nil, err
+	}
+	conf := config.Root{
+		Sources:      srcs,
+		Integrations: []config.Integration{ig},
+		Databases:    rc.Databases,
+	}
+	if err := config.ValidateFix(&conf); err != nil {
+		return ig, nil, err
+	}
+	for _, ref := range ig.Sources {
+		if !slices.ContainsFunc(srcs, func(s config.Source) bool { return s.Name == ref.Name }) {
+			return ig, nil, fmt.Errorf("unknown source: %s", ref.Name)
+		}
+	}
+	pg, ok := h.mgr.Pools()[ig.Database]
+	if !ok {
+		return ig, nil, fmt.Errorf("unknown database: %s", ig.Database)
+	}
+	stmts, err := config.Plan(ctx, pg, conf)
+	if err != nil {
+		return ig, nil, err
+	}
+	return conf.Integrations[0], stmts, nil
+}
+
+type integrationCheck struct {
+	Error string   `json:"error,omitempty"`
+	DDL   []string `json:"ddl"`
+}
+
+// Responds with the integration's validation error, if any,
+// and the DDL that saving it would run. Nothing is changed.
+func (h *Handler) ValidateIntegration(w http.ResponseWriter, r *http.Request) {
+	var (
+		ig  config.Integration
+		res = integrationCheck{DDL: []string{}}
+	)
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&ig); err != nil {
+		res.Error = fmt.Sprintf("decoding integration: %s", err)
+		writeJSON(w, res)
+		return
+	}
+	_, stmts, err := h.checkIntegration(r.Context(), ig)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	res.DDL = append(res.DDL, stmts...)
+	writeJSON(w, res)
+}
+
+// Creates or replaces the integration in shovel.integrations
+// after validating it and migrating its table.
 func (h *Handler) SaveIntegration(w http.ResponseWriter, r *http.Request) {
 	var (
 		err error
@@ -367,12 +485,12 @@ func (h *Handler) SaveIntegration(w http.ResponseWriter, r *http.Request) {
 	err = json.NewDecoder(r.Body).Decode(&ig)
 	if err != nil {
 		slog.ErrorContext(ctx, "decoding integration", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	testConfig := config.Root{Integrations: []config.Integration{ig}}
-	if err := config.CheckUserInput(testConfig); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	fixed, _, err := h.checkIntegration(ctx, ig)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	cj, err := json.Marshal(ig)
@@ -381,8 +499,27 @@ func (h *Handler) SaveIntegration(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	const q = `insert into shovel.integrations(name, conf) values ($1, $2)`
-	_, err = h.pgp.Exec(ctx, q, ig.Name, cj)
+	pg := h.mgr.Pools()[ig.Database]
+	err = config.Migrate(ctx, pg, config.Root{Integrations: []config.Integration{fixed}})
+	if err != nil {
+		slog.ErrorContext(ctx, "migrating integration", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tx, err := h.pgp.Begin(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+	_, err = tx.Exec(ctx, `delete from shovel.integrations where name = $1`, ig.Name)
+	if err == nil {
+		const q = `insert into shovel.integrations(name, conf) values ($1, $2)`
+		_, err = tx.Exec(ctx, q, ig.Name, cj)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
 	if err != nil {
 		slog.ErrorContext(ctx, "inserting integration", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -398,15 +535,20 @@ func (h *Handler) SaveIntegration(w http.ResponseWriter, r *http.Request) {
 
 type AddIntegrationView struct {
 	Sources json.RawMessage
+
+	// The integration being edited or null
+	Integration json.RawMessage
 }
 
+// Creates an integration from an ABI or, given a
+// name, edits an integration in shovel.integrations.
 func (h *Handler) AddIntegration(w http.ResponseWriter, r *http.Request) {
 	var (
 		err  error
 		ctx  = r.Context()
-		view = AddIntegrationView{}
+		view = AddIntegrationView{Integration: json.RawMessage("null")}
 	)
-	srcs, err := h.conf.AllSources(ctx, h.pgp)
+	srcs, err := h.config().AllSources(ctx, h.pgp)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -416,6 +558,23 @@ func (h *Handler) AddIntegration(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if name := r.FormValue("name"); len(name) > 0 {
+		igs, err := config.Integrations(ctx, h.pgp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		i := slices.IndexFunc(igs, func(ig config.Integration) bool { return ig.Name == name })
+		if i < 0 {
+			http.NotFound(w, r)
+			return
+		}
+		view.Integration, err = json.MarshalIndent(igs[i], "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 	tmpl, err := h.template(isLoopback(r), "add-integration")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -431,20 +590,22 @@ func (h *Handler) AddIntegration(w http.ResponseWriter, r *http.Request) {
 type IndexView struct {
 	SourceUpdates []shovel.SrcUpdate
 	TaskUpdates   map[string][]shovel.TaskUpdate
+	Circuits      []jrpc2.Circuit
+	Charts        map[string]Chart
 }
 
 func (h *Handler) Index(w http.ResponseWriter, r *http.Request) {
 	var (
 		ctx  = r.Context()
-		view = IndexView{}
+		view = IndexView{Circuits: jrpc2.Circuits()}
 		err  error
 	)
-	view.SourceUpdates, err = shovel.SourceUpdates(ctx, h.pgp)
+	view.SourceUpdates, err = h.pools().SourceUpdates(ctx)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	tus, err := shovel.TaskUpdates(ctx, h.pgp)
+	tus, err := h.pools().TaskUpdates(ctx)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -456,6 +617,15 @@ func (h *Handler) Index(w http.ResponseWriter, r *http.Request) {
 			tu,
 		)
 	}
+	history, err := h.pools().History(ctx, time.Now().Add(-24*time.Hour), 10*time.Minute)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	view.Charts = make(map[string]Chart)
+	for src, points := range history {
+		view.Charts[src] = newChart(points)
+	}
 	t, err := h.template(isLoopback(r), "index")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -501,6 +671,54 @@ func (h *Handler) Updates(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Responds with the statements that a migration would run
+// for the config's and the database's integrations. Only
+// integrations using the main database are planned.
+func (h *Handler) MigratePlan(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	igs, err := h.config().AllIntegrations(ctx, h.pgp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	conf := config.Root{Integrations: igs}.ForDatabase("")
+	stmts, err := config.Plan(ctx, h.pgp, conf)
+	if err != nil {
+		slog.ErrorContext(ctx, "migrate-plan", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if stmts == nil {
+		stmts = []string{}
+	}
+	w.Header().Set("content-type", "application/json")
+	json.NewEncoder(w).Encode(stmts)
+}
+
+// Pauses the tasks for the src and/or ig form values
+// and responds with the paused src/ig pairs.
+func (h *Handler) Pause(w http.ResponseWriter, r *http.Request) {
+	h.pauseResume(w, r, h.mgr.Pause)
+}
+
+func (h *Handler) Resume(w http.ResponseWriter, r *http.Request) {
+	h.pauseResume(w, r, h.mgr.Resume)
+}
+
+func (h *Handler) pauseResume(w http.ResponseWriter, r *http.Request, f func(string, string) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := f(r.FormValue("src"), r.FormValue("ig")); err != nil {
+		slog.ErrorContext(r.Context(), "pause-resume", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	json.NewEncoder(w).Encode(h.mgr.Paused())
+}
+
 func (h *Handler) AddSource(w http.ResponseWriter, r *http.Request) {
 	t, err := h.template(isLoopback(r), "add-source")
 	if err != nil {
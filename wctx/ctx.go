@@ -16,6 +16,10 @@ const (
 	counterKey  key = 5
 	numLimitKey key = 6
 	srcHostKey  key = 7
+	backfillKey key = 8
+	reqIDKey    key = 9
+	priorityKey key = 10
+	overlapKey  key = 11
 )
 
 func WithChainID(ctx context.Context, id uint64) context.Context {
@@ -45,6 +49,28 @@ func SrcName(ctx context.Context) string {
 	return name
 }
 
+// Set when the task is at least a batch behind the
+// source so that inserts can favor throughput.
+func WithBackfill(ctx context.Context, b bool) context.Context {
+	return context.WithValue(ctx, backfillKey, b)
+}
+
+func Backfill(ctx context.Context) bool {
+	b, _ := ctx.Value(backfillKey).(bool)
+	return b
+}
+
+// Set when a backfill's range may already have rows
+// so that inserts skip them instead of using COPY.
+func WithOverlap(ctx context.Context, b bool) context.Context {
+	return context.WithValue(ctx, overlapKey, b)
+}
+
+func Overlap(ctx context.Context) bool {
+	b, _ := ctx.Value(overlapKey).(bool)
+	return b
+}
+
 func WithVersion(ctx context.Context, v string) context.Context {
 	return context.WithValue(ctx, versionKey, v)
 }
@@ -93,3 +119,24 @@ func SrcHost(ctx context.Context) string {
 	v, _ := ctx.Value(srcHostKey).(string)
 	return v
 }
+
+// Identifies a single Converge so that the logs of
+// loading and inserting a batch of blocks can be found.
+func WithReqID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, reqIDKey, id)
+}
+
+func ReqID(ctx context.Context) string {
+	v, _ := ctx.Value(reqIDKey).(string)
+	return v
+}
+
+// Integration priority. See [config.Integration.PriorityLevel]
+func WithPriority(ctx context.Context, p int) context.Context {
+	return context.WithValue(ctx, priorityKey, p)
+}
+
+func Priority(ctx context.Context) int {
+	p, _ := ctx.Value(priorityKey).(int)
+	return p
+}
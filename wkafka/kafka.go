@@ -0,0 +1,539 @@
+// A minimal Kafka producer
+//
+// Only what is needed to publish rows is implemented:
+// Metadata (v1) to find partition leaders and Produce (v3)
+// with uncompressed v2 record batches. Messages are assigned
+// to partitions using the murmur2 hash of their key so that
+// partitioning matches the Java client's default partitioner.
+package wkafka
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	apiProduce  = 0
+	apiMetadata = 3
+
+	clientID = "shovel"
+
+	// Below the broker's default message.max.bytes
+	maxBatchBytes = 900 << 10
+)
+
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+type Message struct {
+	Key     []byte
+	Value   []byte
+	Headers []Header
+}
+
+// Error codes returned by the broker
+type Error int16
+
+func (e Error) Error() string {
+	switch e {
+	case 3:
+		return "kafka: unknown topic or partition"
+	case 5:
+		return "kafka: leader not available"
+	case 6:
+		return "kafka: not leader for partition"
+	case 7:
+		return "kafka: request timed out"
+	case 10:
+		return "kafka: message too large"
+	case 18:
+		return "kafka: record list too large"
+	case 19:
+		return "kafka: not enough replicas"
+	default:
+		return fmt.Sprintf("kafka: error code %d", int16(e))
+	}
+}
+
+type broker struct {
+	addr string
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+type Writer struct {
+	brokers []string
+	topic   string
+	timeout time.Duration
+
+	mut     sync.Mutex
+	corrID  int32
+	nodes   map[int32]*broker
+	leaders []int32 // indexed by partition
+}
+
+func New(brokers []string, topic string) *Writer {
+	return &Writer{
+		brokers: brokers,
+		topic:   topic,
+		timeout: 10 * time.Second,
+	}
+}
+
+// Publishes msgs and waits for every in-sync replica to
+// acknowledge them. On error, connections and metadata are
+// discarded so that the next call starts fresh.
+func (w *Writer) Write(ctx context.Context, msgs ...Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	err := w.write(ctx, msgs)
+	if err != nil {
+		w.reset()
+	}
+	return err
+}
+
+func (w *Writer) Close() error {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	w.reset()
+	return nil
+}
+
+func (w *Writer) reset() {
+	for _, b := range w.nodes {
+		if b.conn != nil {
+			b.conn.Close()
+		}
+	}
+	w.nodes, w.leaders = nil, nil
+}
+
+func (w *Writer) write(ctx context.Context, msgs []Message) error {
+	if len(w.leaders) == 0 {
+		if err := w.metadata(ctx); err != nil {
+			return fmt.Errorf("loading metadata: %w", err)
+		}
+	}
+	parts := map[int32][]Message{}
+	for _, m := range msgs {
+		p := Partition(m.Key, len(w.leaders))
+		parts[p] = append(parts[p], m)
+	}
+	byNode := map[int32]map[int32][]Message{}
+	for p, pmsgs := range parts {
+		node := w.leaders[p]
+		if byNode[node] == nil {
+			byNode[node] = map[int32][]Message{}
+		}
+		byNode[node][p] = pmsgs
+	}
+	for node, pmsgs := range byNode {
+		b, ok := w.nodes[node]
+		if !ok {
+			return fmt.Errorf("missing broker for node %d", node)
+		}
+		if err := w.produce(ctx, b, pmsgs); err != nil {
+			return fmt.Errorf("producing to %s: %w", b.addr, err)
+		}
+	}
+	return nil
+}
+
+func (w *Writer) metadata(ctx context.Context) error {
+	var errs []error
+	for _, addr := range w.brokers {
+		b := &broker{addr: addr}
+		req := newEncoder()
+		req.i32(1)
+		req.str(w.topic)
+		resp, err := w.roundTrip(ctx, b, apiMetadata, 1, req.bytes())
+		if err != nil {
+			if b.conn != nil {
+				b.conn.Close()
+			}
+			errs = append(errs, err)
+			continue
+		}
+		if b.conn != nil {
+			b.conn.Close()
+		}
+		return w.parseMetadata(resp)
+	}
+	return errors.Join(errs...)
+}
+
+func (w *Writer) parseMetadata(resp []byte) error {
+	d := &decoder{b: resp}
+	w.nodes = map[int32]*broker{}
+	for i, n := 0, d.i32(); i < int(n); i++ {
+		var (
+			id   = d.i32()
+			host = d.str()
+			port = d.i32()
+		)
+		d.str() // rack
+		w.nodes[id] = &broker{addr: net.JoinHostPort(host, strconv.Itoa(int(port)))}
+	}
+	d.i32() // controller
+	for i, n := 0, d.i32(); i < int(n); i++ {
+		var (
+			code = d.i16()
+			name = d.str()
+		)
+		d.i8() // internal
+		if name != w.topic {
+			return fmt.Errorf("unexpected topic %q", name)
+		}
+		if code != 0 {
+			return fmt.Errorf("topic %s: %w", name, Error(code))
+		}
+		np := d.i32()
+		w.leaders = make([]int32, np)
+		for j := 0; j < int(np); j++ {
+			var (
+				pcode  = d.i16()
+				idx    = d.i32()
+				leader = d.i32()
+			)
+			d.i32s() // replicas
+			d.i32s() // isr
+			if pcode != 0 {
+				return fmt.Errorf("partition %d: %w", idx, Error(pcode))
+			}
+			if idx < 0 || int(idx) >= len(w.leaders) {
+				return fmt.Errorf("partition %d out of range", idx)
+			}
+			w.leaders[idx] = leader
+		}
+	}
+	if d.err != nil {
+		return fmt.Errorf("decoding metadata: %w", d.err)
+	}
+	if len(w.leaders) == 0 {
+		return fmt.Errorf("topic %s has no partitions", w.topic)
+	}
+	return nil
+}
+
+func (w *Writer) produce(ctx context.Context, b *broker, parts map[int32][]Message) error {
+	req := newEncoder()
+	req.i16(-1) // transactional_id
+	req.i16(-1) // acks=all
+	req.i32(int32(w.timeout / time.Millisecond))
+	req.i32(1)
+	req.str(w.topic)
+	req.i32(int32(len(parts)))
+	now := time.Now().UnixMilli()
+	for p, msgs := range parts {
+		req.i32(p)
+		var records []byte
+		for len(msgs) > 0 {
+			var batch []byte
+			batch, msgs = recordBatch(now, msgs)
+			records = append(records, batch...)
+		}
+		req.i32(int32(len(records)))
+		req.raw(records)
+	}
+	resp, err := w.roundTrip(ctx, b, apiProduce, 3, req.bytes())
+	if err != nil {
+		return err
+	}
+	d := &decoder{b: resp}
+	for i, n := 0, d.i32(); i < int(n); i++ {
+		d.str()
+		for j, m := 0, d.i32(); j < int(m); j++ {
+			var (
+				idx  = d.i32()
+				code = d.i16()
+			)
+			d.i64() // base offset
+			d.i64() // log append time
+			if code != 0 {
+				return fmt.Errorf("partition %d: %w", idx, Error(code))
+			}
+		}
+	}
+	return d.err
+}
+
+func (w *Writer) roundTrip(ctx context.Context, b *broker, api, version int16, body []byte) ([]byte, error) {
+	if b.conn == nil {
+		var dialer net.Dialer
+		c, err := dialer.DialContext(ctx, "tcp", b.addr)
+		if err != nil {
+			return nil, fmt.Errorf("dialing %s: %w", b.addr, err)
+		}
+		b.conn, b.rd = c, bufio.NewReader(c)
+	}
+	deadline := time.Now().Add(w.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	b.conn.SetDeadline(deadline)
+
+	w.corrID++
+	h := newEncoder()
+	h.i16(api)
+	h.i16(version)
+	h.i32(w.corrID)
+	h.str(clientID)
+	h.raw(body)
+	msg := h.bytes()
+
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(msg)))
+	if _, err := b.conn.Write(append(size[:], msg...)); err != nil {
+		return nil, fmt.Errorf("writing request: %w", err)
+	}
+	if _, err := io.ReadFull(b.rd, size[:]); err != nil {
+		return nil, fmt.Errorf("reading response size: %w", err)
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(b.rd, resp); err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	if len(resp) < 4 {
+		return nil, fmt.Errorf("short response")
+	}
+	if id := int32(binary.BigEndian.Uint32(resp)); id != w.corrID {
+		return nil, fmt.Errorf("correlation id mismatch: %d != %d", id, w.corrID)
+	}
+	return resp[4:], nil
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Encodes a prefix of msgs into a v2 record batch that is
+// at most maxBatchBytes (unless a single message is larger)
+// and returns the remaining messages.
+func recordBatch(ts int64, msgs []Message) ([]byte, []Message) {
+	var (
+		records bytes.Buffer
+		n       int
+	)
+	for n < len(msgs) {
+		r := record(int32(n), msgs[n])
+		if n > 0 && records.Len()+len(r) > maxBatchBytes {
+			break
+		}
+		records.Write(r)
+		n++
+	}
+
+	// attributes through the end is covered by the crc
+	body := newEncoder()
+	body.i16(0)            // attributes
+	body.i32(int32(n - 1)) // last offset delta
+	body.i64(ts)           // first timestamp
+	body.i64(ts)           // max timestamp
+	body.i64(-1)           // producer id
+	body.i16(-1)           // producer epoch
+	body.i32(-1)           // base sequence
+	body.i32(int32(n))
+	body.raw(records.Bytes())
+
+	batch := newEncoder()
+	batch.i64(0) // base offset
+	batch.i32(int32(4 + 1 + 4 + len(body.bytes())))
+	batch.i32(-1) // partition leader epoch
+	batch.i8(2)   // magic
+	batch.u32(crc32.Checksum(body.bytes(), castagnoli))
+	batch.raw(body.bytes())
+	return batch.bytes(), msgs[n:]
+}
+
+func record(offset int32, m Message) []byte {
+	r := &encoder{}
+	r.i8(0)     // attributes
+	r.varint(0) // timestamp delta
+	r.varint(int64(offset))
+	if m.Key == nil {
+		r.varint(-1)
+	} else {
+		r.varint(int64(len(m.Key)))
+		r.raw(m.Key)
+	}
+	r.varint(int64(len(m.Value)))
+	r.raw(m.Value)
+	r.varint(int64(len(m.Headers)))
+	for _, h := range m.Headers {
+		r.varint(int64(len(h.Key)))
+		r.raw([]byte(h.Key))
+		r.varint(int64(len(h.Value)))
+		r.raw(h.Value)
+	}
+	res := &encoder{}
+	res.varint(int64(len(r.bytes())))
+	res.raw(r.bytes())
+	return res.bytes()
+}
+
+// Kafka's default partitioner: murmur2 of the key
+// with the sign bit cleared.
+func Partition(key []byte, n int) int32 {
+	if n <= 1 {
+		return 0
+	}
+	return int32((murmur2(key) & 0x7fffffff) % uint32(n))
+}
+
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	var (
+		n = len(data)
+		h = uint32(seed) ^ uint32(n)
+	)
+	for ; len(data) >= 4; data = data[4:] {
+		k := binary.LittleEndian.Uint32(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	switch len(data) {
+	case 3:
+		h ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func newEncoder() *encoder { return &encoder{} }
+
+func (e *encoder) bytes() []byte { return e.buf }
+func (e *encoder) raw(b []byte)  { e.buf = append(e.buf, b...) }
+func (e *encoder) i8(v int8)     { e.buf = append(e.buf, byte(v)) }
+
+func (e *encoder) i16(v int16) {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v))
+}
+
+func (e *encoder) i32(v int32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+}
+
+func (e *encoder) u32(v uint32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) i64(v int64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v))
+}
+
+func (e *encoder) str(s string) {
+	e.i16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// zig-zag encoded
+func (e *encoder) varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+// Records the first error and returns zero
+// values after reading past the end.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.b) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	res := d.b[:n]
+	d.b = d.b[n:]
+	return res
+}
+
+func (d *decoder) i8() int8 {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return int8(b[0])
+}
+
+func (d *decoder) i16() int16 {
+	b := d.take(2)
+	if b == nil {
+		return 0
+	}
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (d *decoder) i32() int32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) i64() int64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+// Nullable strings (length -1) are returned as ""
+func (d *decoder) str() string {
+	n := d.i16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+func (d *decoder) i32s() []int32 {
+	n := d.i32()
+	if n < 0 || d.err != nil {
+		return nil
+	}
+	var res []int32
+	for i := 0; i < int(n) && d.err == nil; i++ {
+		res = append(res, d.i32())
+	}
+	return res
+}
@@ -0,0 +1,212 @@
+package wkafka
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"kr.dev/diff"
+)
+
+func TestMurmur2(t *testing.T) {
+	// values from the Java client's test suite
+	cases := []struct {
+		key  string
+		want int32
+	}{
+		{"21", -973932308},
+		{"foobar", -790332482},
+		{"a-little-bit-long-string", -985981536},
+		{"a-little-bit-longer-string", -1486304829},
+		{"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8", -58897971},
+		{"abc", 479470107},
+	}
+	for _, tc := range cases {
+		diff.Test(t, t.Errorf, int32(murmur2([]byte(tc.key))), tc.want)
+	}
+}
+
+type fakeBroker struct {
+	t      *testing.T
+	ln     net.Listener
+	nparts int32
+
+	mut  sync.Mutex
+	msgs map[int32][]Message
+}
+
+func newFakeBroker(t *testing.T, nparts int32) *fakeBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	diff.Test(t, t.Fatalf, err, nil)
+	fb := &fakeBroker{t: t, ln: ln, nparts: nparts, msgs: map[int32][]Message{}}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go fb.serve(c)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return fb
+}
+
+func (fb *fakeBroker) serve(c net.Conn) {
+	defer c.Close()
+	rd := bufio.NewReader(c)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(rd, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(rd, req); err != nil {
+			return
+		}
+		d := &decoder{b: req}
+		var (
+			api    = d.i16()
+			_      = d.i16()
+			corrID = d.i32()
+			_      = d.str()
+		)
+		resp := newEncoder()
+		resp.i32(corrID)
+		switch api {
+		case apiMetadata:
+			fb.metadata(d, resp)
+		case apiProduce:
+			fb.produce(d, resp)
+		default:
+			fb.t.Errorf("unexpected api %d", api)
+			return
+		}
+		binary.BigEndian.PutUint32(size[:], uint32(len(resp.bytes())))
+		c.Write(append(size[:], resp.bytes()...))
+	}
+}
+
+func (fb *fakeBroker) metadata(d *decoder, resp *encoder) {
+	d.i32()
+	topic := d.str()
+	host, port, _ := net.SplitHostPort(fb.ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	resp.i32(1)
+	resp.i32(7)
+	resp.str(host)
+	resp.i32(int32(p))
+	resp.i16(-1)
+	resp.i32(7)
+	resp.i32(1)
+	resp.i16(0)
+	resp.str(topic)
+	resp.i8(0)
+	resp.i32(fb.nparts)
+	for i := int32(0); i < fb.nparts; i++ {
+		resp.i16(0)
+		resp.i32(i)
+		resp.i32(7)
+		resp.i32(0)
+		resp.i32(0)
+	}
+}
+
+func (fb *fakeBroker) produce(d *decoder, resp *encoder) {
+	d.str()
+	diff.Test(fb.t, fb.t.Errorf, d.i16(), int16(-1))
+	d.i32()
+	d.i32()
+	topic := d.str()
+	nparts := d.i32()
+	resp.i32(1)
+	resp.str(topic)
+	resp.i32(nparts)
+	for i := int32(0); i < nparts; i++ {
+		p := d.i32()
+		records := &decoder{b: d.take(int(d.i32()))}
+		for len(records.b) > 0 {
+			fb.batch(p, records)
+		}
+		resp.i32(p)
+		resp.i16(0)
+		resp.i64(0)
+		resp.i64(-1)
+	}
+	resp.i32(0)
+}
+
+func (fb *fakeBroker) batch(p int32, d *decoder) {
+	d.i64()
+	b := &decoder{b: d.take(int(d.i32()))}
+	b.i32()
+	diff.Test(fb.t, fb.t.Errorf, b.i8(), int8(2))
+	crc := uint32(b.i32())
+	diff.Test(fb.t, fb.t.Errorf, crc32.Checksum(b.b, castagnoli), crc)
+	b.take(2 + 4 + 8 + 8 + 8 + 2 + 4)
+	n := b.i32()
+	varint := func() int64 {
+		v, k := binary.Varint(b.b)
+		b.b = b.b[k:]
+		return v
+	}
+	fb.mut.Lock()
+	defer fb.mut.Unlock()
+	for i := int32(0); i < n; i++ {
+		varint()
+		b.i8()
+		varint()
+		diff.Test(fb.t, fb.t.Errorf, varint(), int64(i))
+		var m Message
+		if kl := varint(); kl >= 0 {
+			m.Key = b.take(int(kl))
+		}
+		m.Value = b.take(int(varint()))
+		for j, nh := 0, varint(); j < int(nh); j++ {
+			k := string(b.take(int(varint())))
+			m.Headers = append(m.Headers, Header{k, b.take(int(varint()))})
+		}
+		fb.msgs[p] = append(fb.msgs[p], m)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var (
+		ctx = context.Background()
+		fb  = newFakeBroker(t, 3)
+		w   = New([]string{fb.ln.Addr().String()}, "transfers")
+	)
+	defer w.Close()
+	msgs := []Message{
+		{Key: []byte("foobar"), Value: []byte("a")},
+		{Key: []byte("foobar"), Value: []byte("b")},
+		{Key: []byte("21"), Value: []byte("c"), Headers: []Header{{"h", []byte("v")}}},
+	}
+	diff.Test(t, t.Fatalf, w.Write(ctx, msgs...), nil)
+
+	p0, p1 := Partition([]byte("foobar"), 3), Partition([]byte("21"), 3)
+	diff.Test(t, t.Errorf, fb.msgs[p0][0].Value, []byte("a"))
+	diff.Test(t, t.Errorf, fb.msgs[p0][1].Value, []byte("b"))
+	diff.Test(t, t.Errorf, fb.msgs[p1][len(fb.msgs[p1])-1].Headers, []Header{{"h", []byte("v")}})
+
+	// connections are reused for the next write
+	diff.Test(t, t.Fatalf, w.Write(ctx, Message{Value: []byte("d")}), nil)
+	diff.Test(t, t.Errorf, len(fb.msgs[Partition(nil, 3)]) > 0, true)
+}
+
+func TestRecordBatch_Split(t *testing.T) {
+	var (
+		big  = make([]byte, maxBatchBytes/2+1)
+		msgs = []Message{{Value: big}, {Value: big}, {Value: big}}
+	)
+	_, rest := recordBatch(0, msgs)
+	diff.Test(t, t.Errorf, len(rest), 2)
+	_, rest = recordBatch(0, rest)
+	diff.Test(t, t.Errorf, len(rest), 1)
+}
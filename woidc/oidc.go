@@ -0,0 +1,372 @@
+// OpenID Connect login using the authorization code flow
+//
+// The provider's endpoints are read from its discovery
+// document. ID tokens are verified using the provider's
+// JWKS (RS256 and ES256 are supported) and checked for
+// issuer, audience, expiry, and nonce. PKCE is used for
+// every authorization request.
+package woidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	// Defaults to openid, profile, and email
+	Scopes []string
+}
+
+type Client struct {
+	conf Config
+	hc   *http.Client
+	now  func() time.Time
+
+	mut    sync.Mutex
+	disc   *discovery
+	keys   map[string]crypto.PublicKey
+	keysAt time.Time
+}
+
+type discovery struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURL  string `json:"jwks_uri"`
+}
+
+func New(c Config) *Client {
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "profile", "email"}
+	}
+	if !slices.Contains(c.Scopes, "openid") {
+		c.Scopes = append([]string{"openid"}, c.Scopes...)
+	}
+	return &Client{
+		conf: c,
+		hc:   &http.Client{Timeout: 10 * time.Second},
+		now:  time.Now,
+	}
+}
+
+// Random value for state, nonce, and PKCE verifiers
+func Random() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (c *Client) discover(ctx context.Context) (*discovery, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.disc != nil {
+		return c.disc, nil
+	}
+	u := strings.TrimSuffix(c.conf.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	d := &discovery{}
+	if err := c.do(req, d); err != nil {
+		return nil, fmt.Errorf("reading discovery document: %w", err)
+	}
+	if d.Issuer != c.conf.Issuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match %q", d.Issuer, c.conf.Issuer)
+	}
+	c.disc = d
+	return d, nil
+}
+
+// URL of the provider's login page. The state, nonce, and
+// verifier must be kept (eg in a cookie) by the caller
+// and provided to [Client.Exchange] in the callback.
+func (c *Client) AuthURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", c.conf.ClientID)
+	v.Set("redirect_uri", c.conf.RedirectURL)
+	v.Set("scope", strings.Join(c.conf.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	v.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(d.AuthURL, "?") {
+		sep = "&"
+	}
+	return d.AuthURL + sep + v.Encode(), nil
+}
+
+// Exchanges the callback's code for an ID token and
+// returns the token's verified claims.
+func (c *Client) Exchange(ctx context.Context, code, nonce, verifier string) (Claims, error) {
+	d, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", c.conf.RedirectURL)
+	v.Set("code_verifier", verifier)
+	req, err := http.NewRequestWithContext(ctx, "POST", d.TokenURL, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.conf.ClientID), url.QueryEscape(c.conf.ClientSecret))
+	var resp struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := c.do(req, &resp); err != nil {
+		return nil, fmt.Errorf("exchanging code: %w", err)
+	}
+	if len(resp.IDToken) == 0 {
+		return nil, fmt.Errorf("token response missing id_token")
+	}
+	return c.Verify(ctx, resp.IDToken, nonce)
+}
+
+type Claims map[string]any
+
+// Values of a claim that is either a string or a list
+// of strings. Providers differ in which they use for
+// groups and roles.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var res []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	default:
+		return nil
+	}
+}
+
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// clock skew allowed when checking exp
+const leeway = time.Minute
+
+func (c *Client) Verify(ctx context.Context, token, nonce string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed id_token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("decoding header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decoding signature: %w", err)
+	}
+	key, err := c.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verify(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("decoding claims: %w", err)
+	}
+	now := c.now()
+	exp, _ := claims["exp"].(float64)
+	switch {
+	case claims.String("iss") != c.conf.Issuer:
+		return nil, fmt.Errorf("unexpected issuer: %s", claims.String("iss"))
+	case !slices.Contains(claims.Strings("aud"), c.conf.ClientID):
+		return nil, fmt.Errorf("id_token audience does not include client_id")
+	case time.Unix(int64(exp), 0).Add(leeway).Before(now):
+		return nil, fmt.Errorf("id_token expired")
+	case claims.String("nonce") != nonce:
+		return nil, fmt.Errorf("id_token nonce mismatch")
+	}
+	return claims, nil
+}
+
+func decodeSegment(s string, dest any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dest)
+}
+
+func verify(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	h := sha256.Sum256(signed)
+	switch alg {
+	case "RS256":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("RS256 token with non-RSA key")
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig); err != nil {
+			return fmt.Errorf("invalid signature: %w", err)
+		}
+		return nil
+	case "ES256":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("ES256 token with non-EC key")
+		}
+		if len(sig) != 64 {
+			return fmt.Errorf("invalid signature length: %d", len(sig))
+		}
+		var (
+			r = new(big.Int).SetBytes(sig[:32])
+			s = new(big.Int).SetBytes(sig[32:])
+		)
+		if !ecdsa.Verify(k, h[:], r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported alg: %q", alg)
+	}
+}
+
+// Keys are fetched again when kid is unknown (providers
+// rotate keys) but no more than once a minute.
+func (c *Client) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	d, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if k, ok := c.keys[kid]; ok {
+		return k, nil
+	}
+	if c.now().Sub(c.keysAt) < time.Minute {
+		return nil, fmt.Errorf("unknown key id: %q", kid)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", d.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := c.do(req, &jwks); err != nil {
+		return nil, fmt.Errorf("reading jwks: %w", err)
+	}
+	c.keysAt = c.now()
+	c.keys = map[string]crypto.PublicKey{}
+	for _, k := range jwks.Keys {
+		pk, err := k.public()
+		if err != nil {
+			continue // unsupported key types are ignored
+		}
+		c.keys[k.Kid] = pk
+	}
+	k, ok := c.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %q", kid)
+	}
+	return k, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) public() (crypto.PublicKey, error) {
+	b64 := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := b64(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := b64(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+	}
+}
+
+func (c *Client) do(req *http.Request, dest any) error {
+	req.Header.Set("accept", "application/json")
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, b)
+	}
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
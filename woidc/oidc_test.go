@@ -0,0 +1,141 @@
+package woidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+)
+
+type provider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]any
+	form   url.Values
+}
+
+func newProvider(t *testing.T) *provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	diff.Test(t, t.Fatalf, err, nil)
+	p := &provider{key: key}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":                 p.URL,
+				"authorization_endpoint": p.URL + "/auth",
+				"token_endpoint":         p.URL + "/token",
+				"jwks_uri":               p.URL + "/jwks",
+			})
+		case "/jwks":
+			json.NewEncoder(w).Encode(map[string]any{
+				"keys": []map[string]string{{
+					"kty": "RSA",
+					"kid": "k1",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				}},
+			})
+		case "/token":
+			r.ParseForm()
+			p.form = r.Form
+			json.NewEncoder(w).Encode(map[string]string{
+				"id_token": p.sign(t, p.claims),
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *provider) sign(t *testing.T, claims map[string]any) string {
+	enc := func(v any) string {
+		b, err := json.Marshal(v)
+		diff.Test(t, t.Fatalf, err, nil)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": "RS256", "kid": "k1"}) + "." + enc(claims)
+	h := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, h[:])
+	diff.Test(t, t.Fatalf, err, nil)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestExchange(t *testing.T) {
+	var (
+		ctx = context.Background()
+		p   = newProvider(t)
+		c   = New(Config{
+			Issuer:       p.URL,
+			ClientID:     "shovel",
+			ClientSecret: "secret",
+			RedirectURL:  "https://shovel.example.com/oidc/callback",
+		})
+	)
+	au, err := c.AuthURL(ctx, "state", "nonce", "verifier")
+	diff.Test(t, t.Fatalf, err, nil)
+	if !strings.HasPrefix(au, p.URL+"/auth?") || !strings.Contains(au, "code_challenge_method=S256") {
+		t.Errorf("unexpected auth url: %s", au)
+	}
+
+	valid := map[string]any{
+		"iss":    p.URL,
+		"aud":    "shovel",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"nonce":  "nonce",
+		"email":  "a@example.com",
+		"groups": []string{"eng", "ops"},
+	}
+	p.claims = valid
+	claims, err := c.Exchange(ctx, "code", "nonce", "verifier")
+	diff.Test(t, t.Fatalf, err, nil)
+	diff.Test(t, t.Errorf, claims.String("email"), "a@example.com")
+	diff.Test(t, t.Errorf, claims.Strings("groups"), []string{"eng", "ops"})
+	diff.Test(t, t.Errorf, p.form.Get("code_verifier"), "verifier")
+
+	cases := []struct {
+		key  string
+		val  any
+		want string
+	}{
+		{"iss", "https://other.example.com", "unexpected issuer: https://other.example.com"},
+		{"aud", []string{"other"}, "id_token audience does not include client_id"},
+		{"exp", time.Now().Add(-time.Hour).Unix(), "id_token expired"},
+		{"nonce", "other", "id_token nonce mismatch"},
+	}
+	for _, tc := range cases {
+		claims := map[string]any{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		claims[tc.key] = tc.val
+		p.claims = claims
+		_, err := c.Exchange(ctx, "code", "nonce", "verifier")
+		if err == nil {
+			t.Errorf("%s: expected error", tc.key)
+			continue
+		}
+		diff.Test(t, t.Errorf, err.Error(), tc.want)
+	}
+
+	tok := p.sign(t, valid)
+	tampered := tok[:len(tok)-4] + "AAAA"
+	_, err = c.Verify(ctx, tampered, "nonce")
+	if err == nil || !strings.HasPrefix(err.Error(), "invalid signature") {
+		t.Errorf("expected invalid signature. got: %v", err)
+	}
+}
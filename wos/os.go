@@ -1,10 +1,14 @@
 package wos
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"text/template"
 )
 
 // If s has a $ prefix then we assume
@@ -13,8 +17,20 @@ import (
 //
 // # If there is no env var for s then the program will crash with an error
 //
+// If s references a secret (see [IsSecret]) then
+// the secret is read and the program will crash if
+// it can't be read.
+//
 // if there is no $ prefix then s is returned
 func Getenv(s string) string {
+	if IsSecret(s) {
+		v, err := Secret(context.Background(), s)
+		if err != nil {
+			fmt.Printf("unable to read secret: %s\n", err)
+			os.Exit(1)
+		}
+		return v
+	}
 	if strings.HasPrefix(s, "$") {
 		v := os.Getenv(strings.ToUpper(strings.TrimPrefix(s, "$")))
 		if v == "" {
@@ -26,6 +42,84 @@ func Getenv(s string) string {
 	return s
 }
 
+// Like [Getenv] but an unreadable secret is returned as an
+// error so that reloading a config doesn't exit the program.
+func resolve(s string) (string, error) {
+	if IsSecret(s) {
+		return Secret(context.Background(), s)
+	}
+	return Getenv(s), nil
+}
+
+var (
+	envRef    = regexp.MustCompile(`^\$[A-Za-z_][A-Za-z0-9_]*$`)
+	bracedRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+func lookup(name string) (string, error) {
+	v := os.Getenv(strings.ToUpper(name))
+	if v == "" {
+		return "", fmt.Errorf("expected $%s to be set", name)
+	}
+	return v, nil
+}
+
+// Expands the env vars referenced by s:
+//
+//   - $NAME when it's all of s (as in [Getenv])
+//   - ${NAME} anywhere in s (eg ${DEPLOY_ENV}_transfers)
+//   - Go templates with an env func or the environment
+//     as data (eg {{env "DEPLOY_ENV"}} or {{.DEPLOY_ENV}})
+//
+// Secret references are returned as is since they're read
+// by the Env types. Returns an error when a referenced var
+// isn't set.
+func Interpolate(s string) (string, error) {
+	if IsSecret(s) {
+		return s, nil
+	}
+	if strings.Contains(s, "{{") {
+		var err error
+		if s, err = execute(s); err != nil {
+			return "", err
+		}
+	}
+	var errs []error
+	s = bracedRef.ReplaceAllStringFunc(s, func(ref string) string {
+		v, err := lookup(ref[2 : len(ref)-1])
+		errs = append(errs, err)
+		return v
+	})
+	if err := errors.Join(errs...); err != nil {
+		return "", err
+	}
+	if envRef.MatchString(s) {
+		return lookup(s[1:])
+	}
+	return s, nil
+}
+
+func execute(s string) (string, error) {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	t, err := template.New("").
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"env": lookup}).
+		Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("parsing template %q: %w", s, err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, env); err != nil {
+		return "", fmt.Errorf("executing template %q: %w", s, err)
+	}
+	return b.String(), nil
+}
+
 type EnvString string
 
 func (es *EnvString) UnmarshalJSON(data []byte) error {
@@ -33,7 +127,11 @@ func (es *EnvString) UnmarshalJSON(data []byte) error {
 		return fmt.Errorf("EnvString must be at least 2 bytes")
 	}
 	data = data[1 : len(data)-1] // remove quotes
-	*es = EnvString(Getenv(string(data)))
+	s, err := resolve(string(data))
+	if err != nil {
+		return err
+	}
+	*es = EnvString(s)
 	return nil
 }
 
@@ -43,7 +141,10 @@ func (eu *EnvUint64) UnmarshalJSON(d []byte) error {
 	if len(d) >= 2 && d[0] == '"' && d[len(d)-1] == '"' {
 		d = d[1 : len(d)-1] // remove quotes
 	}
-	s := Getenv(string(d))
+	s, err := resolve(string(d))
+	if err != nil {
+		return err
+	}
 	n, err := strconv.ParseUint(s, 10, 64)
 	if err != nil {
 		return fmt.Errorf("EnvUint64 unable to decode %s: %w", s, err)
@@ -58,7 +159,10 @@ func (ei *EnvInt) UnmarshalJSON(d []byte) error {
 	if len(d) >= 2 && d[0] == '"' && d[len(d)-1] == '"' {
 		d = d[1 : len(d)-1] // remove quotes
 	}
-	s := Getenv(string(d))
+	s, err := resolve(string(d))
+	if err != nil {
+		return err
+	}
 	n, err := strconv.ParseInt(s, 10, 64)
 	if err != nil {
 		return fmt.Errorf("EnvInt unable to decode %s: %w", s, err)
@@ -1,8 +1,12 @@
 package wos
 
 import (
+	"context"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"kr.dev/diff"
@@ -49,3 +53,72 @@ func TestUnmarshal(t *testing.T) {
 		diff.Test(t, t.Errorf, tc.want, int(got))
 	}
 }
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("DEPLOY_ENV", "staging")
+	t.Setenv("START", "42")
+	cases := []struct {
+		input string
+		want  string
+		err   string
+	}{
+		{"foo", "foo", ""},
+		{"$START", "42", ""},
+		{"$start", "42", ""},
+		{"${DEPLOY_ENV}_transfers", "staging_transfers", ""},
+		{"a-${DEPLOY_ENV}-${START}", "a-staging-42", ""},
+		{`{{env "DEPLOY_ENV"}}_transfers`, "staging_transfers", ""},
+		{"{{.DEPLOY_ENV}}", "staging", ""},
+		{"pa$$word", "pa$$word", ""},
+		{"vault:secret/data/x#y", "vault:secret/data/x#y", ""},
+		{"${MISSING}_x", "", "expected $MISSING to be set"},
+		{"$MISSING", "", "expected $MISSING to be set"},
+	}
+	for _, tc := range cases {
+		got, err := Interpolate(tc.input)
+		var gotErr string
+		if err != nil {
+			gotErr = err.Error()
+		}
+		diff.Test(t, t.Errorf, gotErr, tc.err)
+		diff.Test(t, t.Errorf, got, tc.want)
+	}
+	_, err := Interpolate("{{.MISSING}}")
+	diff.Test(t, t.Errorf, err != nil, true)
+}
+
+func TestSecret(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/secret/data/shovel":
+			diff.Test(t, t.Errorf, r.Header.Get("X-Vault-Token"), "tok")
+			w.Write([]byte(`{"data": {"data": {"pg_url": "postgres://vault"}}}`))
+		case r.Header.Get("X-Amz-Target") == "secretsmanager.GetSecretValue":
+			diff.Test(t, t.Errorf, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256"), true)
+			w.Write([]byte(`{"SecretString": "{\"url\": \"https://aws\"}"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	t.Setenv("VAULT_ADDR", ts.URL)
+	t.Setenv("VAULT_TOKEN", "tok")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	t.Setenv("AWS_ENDPOINT_URL_SECRETS_MANAGER", ts.URL)
+
+	ctx := context.Background()
+	got, err := Secret(ctx, "vault:secret/data/shovel#pg_url")
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, got, "postgres://vault")
+
+	got, err = Secret(ctx, "aws-sm:shovel#url")
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, got, "https://aws")
+
+	_, err = Secret(ctx, "vault:secret/data/missing#pg_url")
+	diff.Test(t, t.Errorf, err != nil, true)
+}
@@ -0,0 +1,190 @@
+package wos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+// Values that accept env vars may instead reference a secret:
+//
+//	vault:<path>#<field>
+//	aws-sm:<secret-id>[#<key>]
+//
+// Vault is read using VAULT_ADDR and VAULT_TOKEN. Both KV
+// version 1 and 2 responses are supported.
+//
+// Secrets Manager is read using the AWS SDK's region and
+// credentials (env vars, shared config files, or the
+// instance's role). The region of an ARN secret-id is used
+// when it's set. When key is set the secret is decoded as a
+// JSON object.
+//
+// Values are cached for a minute so that rotated
+// secrets are read again without a request per use.
+func IsSecret(s string) bool {
+	return strings.HasPrefix(s, "vault:") || strings.HasPrefix(s, "aws-sm:")
+}
+
+const secretTTL = time.Minute
+
+type cachedSecret struct {
+	val string
+	exp time.Time
+}
+
+var (
+	secretsMut sync.Mutex
+	secrets    = map[string]cachedSecret{}
+	secretHC   = &http.Client{Timeout: 10 * time.Second}
+)
+
+func Secret(ctx context.Context, ref string) (string, error) {
+	secretsMut.Lock()
+	cs, ok := secrets[ref]
+	secretsMut.Unlock()
+	if ok && time.Now().Before(cs.exp) {
+		return cs.val, nil
+	}
+	var (
+		val string
+		err error
+	)
+	switch {
+	case strings.HasPrefix(ref, "vault:"):
+		val, err = vault(ctx, strings.TrimPrefix(ref, "vault:"))
+	case strings.HasPrefix(ref, "aws-sm:"):
+		val, err = awsSecret(ctx, strings.TrimPrefix(ref, "aws-sm:"))
+	default:
+		return "", fmt.Errorf("unknown secret reference: %s", ref)
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", ref, err)
+	}
+	secretsMut.Lock()
+	secrets[ref] = cachedSecret{val: val, exp: time.Now().Add(secretTTL)}
+	secretsMut.Unlock()
+	return val, nil
+}
+
+func vault(ctx context.Context, ref string) (string, error) {
+	path, field, _ := strings.Cut(ref, "#")
+	if len(field) == 0 {
+		return "", fmt.Errorf("missing #field")
+	}
+	addr := os.Getenv("VAULT_ADDR")
+	if len(addr) == 0 {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+	u := strings.TrimSuffix(addr, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	var resp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := doJSON(req, &resp); err != nil {
+		return "", err
+	}
+	// kv v2 nests the secret under data.data
+	data := resp.Data
+	if inner, ok := resp.Data["data"]; ok {
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(inner, &m); err == nil {
+			data = m
+		}
+	}
+	return field2string(data, field)
+}
+
+var (
+	awsMut  sync.Mutex
+	awsSess *session.Session
+)
+
+// The session is shared so that credentials (eg from the
+// instance's role) are reused until they expire
+func awsSession() (*session.Session, error) {
+	awsMut.Lock()
+	defer awsMut.Unlock()
+	if awsSess != nil {
+		return awsSess, nil
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("aws session: %w", err)
+	}
+	awsSess = sess
+	return sess, nil
+}
+
+func awsSecret(ctx context.Context, ref string) (string, error) {
+	id, key, _ := strings.Cut(ref, "#")
+	sess, err := awsSession()
+	if err != nil {
+		return "", err
+	}
+	conf := aws.NewConfig().WithHTTPClient(secretHC)
+	if parts := strings.Split(id, ":"); len(parts) > 3 && parts[0] == "arn" {
+		conf = conf.WithRegion(parts[3])
+	}
+	if ep := os.Getenv("AWS_ENDPOINT_URL_SECRETS_MANAGER"); len(ep) > 0 {
+		conf = conf.WithEndpoint(ep)
+	}
+	if len(aws.StringValue(conf.Region)) == 0 && len(aws.StringValue(sess.Config.Region)) == 0 {
+		return "", fmt.Errorf("AWS_REGION is not set")
+	}
+	out, err := secretsmanager.New(sess, conf).GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(key) == 0 {
+		return aws.StringValue(out.SecretString), nil
+	}
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(aws.StringValue(out.SecretString)), &data); err != nil {
+		return "", fmt.Errorf("decoding secret as json: %w", err)
+	}
+	return field2string(data, key)
+}
+
+func field2string(data map[string]json.RawMessage, field string) (string, error) {
+	raw, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("missing field %s", field)
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return string(raw), nil
+	}
+	return s, nil
+}
+
+func doJSON(req *http.Request, dest any) error {
+	resp, err := secretHC.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, b)
+	}
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
@@ -0,0 +1,123 @@
+// OpenTelemetry tracing
+//
+// Spans are started using [Tracer]. Until [Setup] installs
+// an exporter the global provider is a no-op so instrumented
+// code costs little when tracing isn't configured.
+package wotel
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var Tracer = otel.Tracer("github.com/indexsupply/shovel")
+
+type Config struct {
+	// host:port or a URL (eg https://otel.example.com/v1/traces)
+	Endpoint string
+	Headers  map[string]string
+	Insecure bool
+
+	// Fraction of traces to sample. 0 samples every trace.
+	SampleRatio float64
+
+	Version string
+}
+
+// Installs a global tracer provider that exports spans
+// using OTLP over HTTP. The returned func flushes buffered
+// spans and should be called before the process exits.
+func Setup(ctx context.Context, c Config) (func(context.Context) error, error) {
+	opts := []otlptracehttp.Option{otlptracehttp.WithHeaders(c.Headers)}
+	switch {
+	case strings.Contains(c.Endpoint, "://"):
+		opts = append(opts, otlptracehttp.WithEndpointURL(c.Endpoint))
+	default:
+		opts = append(opts, otlptracehttp.WithEndpoint(c.Endpoint))
+	}
+	if c.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exp, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating otlp exporter: %w", err)
+	}
+	sampler := sdktrace.AlwaysSample()
+	if c.SampleRatio > 0 {
+		sampler = sdktrace.TraceIDRatioBased(c.SampleRatio)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "shovel"),
+			attribute.String("service.version", c.Version),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Records err (if any) on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Implements [pgx.QueryTracer] and [pgx.CopyFromTracer]
+// so that each query is a child of the caller's span.
+type PGTracer struct{}
+
+func (PGTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, d pgx.TraceQueryStartData) context.Context {
+	ctx, _ = Tracer.Start(ctx, "pg.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", truncate(d.SQL, 2048)),
+		),
+	)
+	return ctx
+}
+
+func (PGTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, d pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int64("db.rows_affected", d.CommandTag.RowsAffected()))
+	End(span, d.Err)
+}
+
+func (PGTracer) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, d pgx.TraceCopyFromStartData) context.Context {
+	ctx, _ = Tracer.Start(ctx, "pg.copy",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.sql.table", d.TableName.Sanitize()),
+		),
+	)
+	return ctx
+}
+
+func (PGTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, d pgx.TraceCopyFromEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int64("db.rows_affected", d.CommandTag.RowsAffected()))
+	End(span, d.Err)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,31 @@
+package wotel
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"kr.dev/diff"
+)
+
+func TestEnd(t *testing.T) {
+	var (
+		sr = tracetest.NewSpanRecorder()
+		tp = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+		tr = tp.Tracer("test")
+	)
+	ctx, parent := tr.Start(context.Background(), "converge")
+	_, child := tr.Start(ctx, "rpc")
+	End(child, errors.New("bad"))
+	End(parent, nil)
+
+	spans := sr.Ended()
+	diff.Test(t, t.Fatalf, len(spans), 2)
+	diff.Test(t, t.Errorf, spans[0].Name(), "rpc")
+	diff.Test(t, t.Errorf, spans[0].Status().Code, codes.Error)
+	diff.Test(t, t.Errorf, spans[0].Parent().SpanID(), spans[1].SpanContext().SpanID())
+	diff.Test(t, t.Errorf, spans[1].Status().Code, codes.Unset)
+}
@@ -0,0 +1,461 @@
+// A minimal Parquet writer
+//
+// Only what is needed to export rows is implemented: flat
+// schemas of optional columns written as row groups with
+// one PLAIN encoded, zstd compressed, v1 data page per
+// column. Definition levels use the RLE/bit-packed hybrid
+// encoding and the file metadata uses the thrift compact
+// protocol.
+package wparquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Logical column types
+type Kind int
+
+const (
+	Boolean   Kind = iota
+	Int32          // INT32
+	Int64          // INT64
+	Bytes          // BYTE_ARRAY
+	String         // BYTE_ARRAY (UTF8)
+	Timestamp      // INT64 (TIMESTAMP_MICROS, UTC)
+)
+
+// parquet.thrift enums
+const (
+	typeBoolean   = 0
+	typeInt32     = 1
+	typeInt64     = 2
+	typeByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+
+	repetitionOptional = 1
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecZstd = 6
+
+	pageData = 0
+)
+
+func (k Kind) physical() int32 {
+	switch k {
+	case Boolean:
+		return typeBoolean
+	case Int32:
+		return typeInt32
+	case Int64, Timestamp:
+		return typeInt64
+	default:
+		return typeByteArray
+	}
+}
+
+type Column struct {
+	Name string
+	Kind Kind
+}
+
+type column struct {
+	Column
+	defs  []bool
+	bools []bool
+	plain bytes.Buffer
+}
+
+type chunk struct {
+	offset int64
+	size   int64
+	csize  int64
+}
+
+type rowGroup struct {
+	nrows  int
+	chunks []chunk
+}
+
+// Buffers rows in memory until [Writer.Flush] writes them
+// to dst as a row group. [Writer.Close] writes the file's
+// metadata.
+type Writer struct {
+	dst    io.Writer
+	enc    *zstd.Encoder
+	off    int64
+	cols   []*column
+	nrows  int
+	total  int
+	groups []rowGroup
+}
+
+func NewWriter(dst io.Writer, cols []Column) *Writer {
+	w := &Writer{dst: dst}
+	for _, c := range cols {
+		w.cols = append(w.cols, &column{Column: c})
+	}
+	return w
+}
+
+// Rows added to the file
+func (w *Writer) Rows() int {
+	return w.total + w.nrows
+}
+
+// Approximate size of the rows that haven't been flushed
+func (w *Writer) Buffered() int {
+	var n int
+	for _, c := range w.cols {
+		n += c.plain.Len() + len(c.defs)/8 + len(c.bools)/8
+	}
+	return n
+}
+
+// Adds a row with a value for each column. nil values are
+// null. Integer values must fit the column's type. Rows
+// with an invalid value are not added.
+func (w *Writer) Add(row []any) error {
+	if len(row) != len(w.cols) {
+		return fmt.Errorf("row has %d values for %d columns", len(row), len(w.cols))
+	}
+	vals := make([][]byte, len(row))
+	for i, c := range w.cols {
+		b, err := c.encode(row[i])
+		if err != nil {
+			return fmt.Errorf("column %s: %w", c.Name, err)
+		}
+		vals[i] = b
+	}
+	for i, c := range w.cols {
+		switch {
+		case row[i] == nil:
+			c.defs = append(c.defs, false)
+			continue
+		case c.Kind == Boolean:
+			c.bools = append(c.bools, row[i].(bool))
+		default:
+			c.plain.Write(vals[i])
+		}
+		c.defs = append(c.defs, true)
+	}
+	w.nrows++
+	return nil
+}
+
+// PLAIN encoding of v. Booleans are bit-packed
+// when the page is written.
+func (c *column) encode(v any) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch c.Kind {
+	case Boolean:
+		if _, ok := v.(bool); !ok {
+			return nil, fmt.Errorf("expected bool got %T", v)
+		}
+		return nil, nil
+	case Int32, Int64:
+		var n int64
+		switch iv := v.(type) {
+		case int16:
+			n = int64(iv)
+		case int32:
+			n = int64(iv)
+		case int64:
+			n = iv
+		case int:
+			n = int64(iv)
+		case uint64:
+			if iv > math.MaxInt64 {
+				return nil, fmt.Errorf("%d overflows int64", iv)
+			}
+			n = int64(iv)
+		default:
+			return nil, fmt.Errorf("expected integer got %T", v)
+		}
+		if c.Kind == Int64 {
+			return binary.LittleEndian.AppendUint64(nil, uint64(n)), nil
+		}
+		if n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, fmt.Errorf("%d overflows int32", n)
+		}
+		return binary.LittleEndian.AppendUint32(nil, uint32(n)), nil
+	case Timestamp:
+		t, ok := v.(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("expected time got %T", v)
+		}
+		return binary.LittleEndian.AppendUint64(nil, uint64(t.UnixMicro())), nil
+	default:
+		var d []byte
+		switch bv := v.(type) {
+		case []byte:
+			d = bv
+		case string:
+			d = []byte(bv)
+		default:
+			return nil, fmt.Errorf("expected bytes got %T", v)
+		}
+		return append(binary.LittleEndian.AppendUint32(nil, uint32(len(d))), d...), nil
+	}
+}
+
+// Bit-packed, LSB first
+func pack(bits []bool) []byte {
+	res := make([]byte, (len(bits)+7)/8)
+	for i, b := range bits {
+		if b {
+			res[i/8] |= 1 << (i % 8)
+		}
+	}
+	return res
+}
+
+// Definition levels (bit width 1) as a single bit-packed
+// run prefixed by its length.
+func (c *column) levels() []byte {
+	packed := pack(c.defs)
+	run := binary.AppendUvarint(nil, uint64(len(packed))<<1|1)
+	run = append(run, packed...)
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(run))), run...)
+}
+
+func (c *column) page() []byte {
+	res := c.levels()
+	if c.Kind == Boolean {
+		return append(res, pack(c.bools)...)
+	}
+	return append(res, c.plain.Bytes()...)
+}
+
+func (w *Writer) write(b []byte) error {
+	n, err := w.dst.Write(b)
+	w.off += int64(n)
+	return err
+}
+
+// Writes the buffered rows as a row group
+func (w *Writer) Flush() error {
+	if w.nrows == 0 {
+		return nil
+	}
+	if w.off == 0 {
+		if err := w.write([]byte("PAR1")); err != nil {
+			return err
+		}
+	}
+	if w.enc == nil {
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return fmt.Errorf("zstd: %w", err)
+		}
+		w.enc = enc
+	}
+	rg := rowGroup{nrows: w.nrows, chunks: make([]chunk, len(w.cols))}
+	for i, c := range w.cols {
+		page := c.page()
+		compressed := w.enc.EncodeAll(page, nil)
+
+		var t thrift
+		t.begin()
+		t.i32(1, pageData)
+		t.i32(2, int32(len(page)))
+		t.i32(3, int32(len(compressed)))
+		t.beginField(5)
+		t.i32(1, int32(w.nrows))
+		t.i32(2, encodingPlain)
+		t.i32(3, encodingRLE)
+		t.i32(4, encodingRLE)
+		t.end()
+		t.end()
+
+		rg.chunks[i] = chunk{
+			offset: w.off,
+			size:   int64(len(t.buf) + len(page)),
+			csize:  int64(len(t.buf) + len(compressed)),
+		}
+		if err := w.write(append(t.buf, compressed...)); err != nil {
+			return err
+		}
+		c.defs = c.defs[:0]
+		c.bools = c.bools[:0]
+		c.plain.Reset()
+	}
+	w.groups = append(w.groups, rg)
+	w.total += w.nrows
+	w.nrows = 0
+	return nil
+}
+
+// Flushes the buffered rows and writes the file's metadata
+func (w *Writer) Close() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if w.enc != nil {
+		defer w.enc.Close()
+	}
+	var buf []byte
+	if w.off == 0 {
+		buf = append(buf, "PAR1"...)
+	}
+	meta := w.meta()
+	buf = append(buf, meta...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(meta)))
+	buf = append(buf, "PAR1"...)
+	return w.write(buf)
+}
+
+func (w *Writer) meta() []byte {
+	var t thrift
+	t.begin()
+	t.i32(1, 1)
+
+	t.list(2, tStruct, len(w.cols)+1)
+	t.begin()
+	t.str(4, "schema")
+	t.i32(5, int32(len(w.cols)))
+	t.end()
+	for _, c := range w.cols {
+		t.begin()
+		t.i32(1, c.Kind.physical())
+		t.i32(3, repetitionOptional)
+		t.str(4, c.Name)
+		switch c.Kind {
+		case String:
+			t.i32(6, convertedUTF8)
+		case Timestamp:
+			t.i32(6, convertedTimestampMicros)
+		}
+		t.end()
+	}
+
+	t.i64(3, int64(w.total))
+
+	t.list(4, tStruct, len(w.groups))
+	for _, rg := range w.groups {
+		var total int64
+		for _, ch := range rg.chunks {
+			total += ch.size
+		}
+		t.begin()
+		t.list(1, tStruct, len(w.cols))
+		for i, c := range w.cols {
+			t.begin()
+			t.i64(2, rg.chunks[i].offset)
+			t.beginField(3)
+			t.i32(1, c.Kind.physical())
+			t.list(2, tI32, 2)
+			t.elemI32(encodingPlain)
+			t.elemI32(encodingRLE)
+			t.list(3, tBinary, 1)
+			t.elemStr(c.Name)
+			t.i32(4, codecZstd)
+			t.i64(5, int64(rg.nrows))
+			t.i64(6, rg.chunks[i].size)
+			t.i64(7, rg.chunks[i].csize)
+			t.i64(9, rg.chunks[i].offset)
+			t.end()
+			t.end()
+		}
+		t.i64(2, total)
+		t.i64(3, int64(rg.nrows))
+		t.end()
+	}
+
+	t.str(6, "shovel")
+	t.end()
+	return t.buf
+}
+
+// thrift compact protocol types
+const (
+	tI32    = 5
+	tI64    = 6
+	tBinary = 8
+	tList   = 9
+	tStruct = 12
+)
+
+// Encodes structs using the thrift compact protocol.
+// last holds the previous field id of each open struct
+// since field ids are written as deltas.
+type thrift struct {
+	buf  []byte
+	last []int16
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func (t *thrift) field(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if d := id - *last; d > 0 && d <= 15 {
+		t.buf = append(t.buf, byte(d)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.buf = binary.AppendUvarint(t.buf, zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (t *thrift) i32(id int16, v int32) {
+	t.field(id, tI32)
+	t.elemI32(v)
+}
+
+func (t *thrift) i64(id int16, v int64) {
+	t.field(id, tI64)
+	t.buf = binary.AppendUvarint(t.buf, zigzag(v))
+}
+
+func (t *thrift) str(id int16, s string) {
+	t.field(id, tBinary)
+	t.elemStr(s)
+}
+
+func (t *thrift) list(id int16, typ byte, n int) {
+	t.field(id, tList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|typ)
+		return
+	}
+	t.buf = append(t.buf, 0xf0|typ)
+	t.buf = binary.AppendUvarint(t.buf, uint64(n))
+}
+
+func (t *thrift) elemI32(v int32) {
+	t.buf = binary.AppendUvarint(t.buf, zigzag(int64(v)))
+}
+
+func (t *thrift) elemStr(s string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+// Starts a top level struct or a list element
+func (t *thrift) begin() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thrift) beginField(id int16) {
+	t.field(id, tStruct)
+	t.begin()
+}
+
+func (t *thrift) end() {
+	t.buf = append(t.buf, 0)
+	t.last = t.last[:len(t.last)-1]
+}
@@ -0,0 +1,197 @@
+package wparquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"kr.dev/diff"
+)
+
+// Decodes compact protocol structs into maps keyed by
+// field id. Lists are []any and binary is string.
+type decoder struct {
+	t *testing.T
+	b []byte
+}
+
+func (d *decoder) byte() byte {
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.t.Fatal("bad varint")
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v := d.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (d *decoder) value(typ byte) any {
+	switch typ {
+	case tI32, tI64:
+		return d.varint()
+	case tBinary:
+		n := d.uvarint()
+		s := string(d.b[:n])
+		d.b = d.b[n:]
+		return s
+	case tList:
+		h := d.byte()
+		n, et := uint64(h>>4), h&0x0f
+		if n == 15 {
+			n = d.uvarint()
+		}
+		var res []any
+		for i := uint64(0); i < n; i++ {
+			res = append(res, d.value(et))
+		}
+		return res
+	case tStruct:
+		res := map[int16]any{}
+		var last int16
+		for {
+			h := d.byte()
+			if h == 0 {
+				return res
+			}
+			id := last + int16(h>>4)
+			if h>>4 == 0 {
+				id = int16(d.varint())
+			}
+			res[id] = d.value(h & 0x0f)
+			last = id
+		}
+	}
+	d.t.Fatalf("unknown type %d", typ)
+	return nil
+}
+
+// Decodes the file's metadata
+func footer(t *testing.T, b []byte) map[int16]any {
+	diff.Test(t, t.Fatalf, string(b[:4]), "PAR1")
+	diff.Test(t, t.Fatalf, string(b[len(b)-4:]), "PAR1")
+	n := binary.LittleEndian.Uint32(b[len(b)-8:])
+	d := &decoder{t: t, b: b[len(b)-8-int(n) : len(b)-8]}
+	meta := d.value(tStruct).(map[int16]any)
+	diff.Test(t, t.Errorf, len(d.b), 0)
+	return meta
+}
+
+func TestWriter_RowGroups(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, []Column{{"block_num", Int64}})
+	diff.Test(t, t.Fatalf, w.Add([]any{int64(1)}), nil)
+	diff.Test(t, t.Errorf, w.Buffered(), 8)
+	diff.Test(t, t.Fatalf, w.Flush(), nil)
+	diff.Test(t, t.Errorf, w.Buffered(), 0)
+	diff.Test(t, t.Fatalf, w.Add([]any{int64(2)}), nil)
+	diff.Test(t, t.Fatalf, w.Add([]any{int64(3)}), nil)
+	diff.Test(t, t.Errorf, w.Rows(), 3)
+	diff.Test(t, t.Fatalf, w.Close(), nil)
+
+	meta := footer(t, buf.Bytes())
+	diff.Test(t, t.Errorf, meta[3], int64(3))
+	rgs := meta[4].([]any)
+	diff.Test(t, t.Fatalf, len(rgs), 2)
+	var offsets []int64
+	for i, want := range []int64{1, 2} {
+		rg := rgs[i].(map[int16]any)
+		diff.Test(t, t.Errorf, rg[3], want)
+		cm := rg[1].([]any)[0].(map[int16]any)[3].(map[int16]any)
+		diff.Test(t, t.Errorf, cm[5], want)
+		offsets = append(offsets, cm[9].(int64))
+	}
+	diff.Test(t, t.Errorf, offsets[0], int64(4))
+	diff.Test(t, t.Errorf, offsets[1] > offsets[0], true)
+}
+
+func TestWriter_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, []Column{{"block_num", Int64}})
+	diff.Test(t, t.Fatalf, w.Close(), nil)
+	meta := footer(t, buf.Bytes())
+	diff.Test(t, t.Errorf, meta[3], int64(0))
+	diff.Test(t, t.Errorf, meta[4], []any(nil))
+}
+
+func TestWriter(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		ts  = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	)
+	w := NewWriter(&buf, []Column{
+		{"block_num", Int64},
+		{"ok", Boolean},
+		{"addr", Bytes},
+		{"value", String},
+		{"block_time", Timestamp},
+		{"n", Int32},
+	})
+	diff.Test(t, t.Fatalf, w.Add([]any{uint64(42), true, []byte{0xab}, "1000", ts, int32(-1)}), nil)
+	diff.Test(t, t.Fatalf, w.Add([]any{int64(43), nil, nil, nil, nil, nil}), nil)
+	diff.Test(t, t.Fatalf, w.Add([]any{int64(44), false, []byte{}, "x", ts, int16(7)}), nil)
+	if err := w.Add([]any{"a", nil, nil, nil, nil, nil}); err == nil {
+		t.Error("expected error for string in int64 column")
+	}
+	if err := w.Add([]any{int64(1), nil, nil, nil, nil, int64(1 << 40)}); err == nil {
+		t.Error("expected error for int32 overflow")
+	}
+
+	diff.Test(t, t.Fatalf, w.Close(), nil)
+	b := buf.Bytes()
+	meta := footer(t, b)
+	diff.Test(t, t.Errorf, meta[3], int64(3))
+
+	schema := meta[2].([]any)
+	var names []any
+	for _, s := range schema {
+		names = append(names, s.(map[int16]any)[4])
+	}
+	diff.Test(t, t.Errorf, names, []any{"schema", "block_num", "ok", "addr", "value", "block_time", "n"})
+	diff.Test(t, t.Errorf, schema[4].(map[int16]any)[6], int64(convertedUTF8))
+
+	dec, err := zstd.NewReader(nil)
+	diff.Test(t, t.Fatalf, err, nil)
+	defer dec.Close()
+
+	rg := meta[4].([]any)[0].(map[int16]any)
+	cols := rg[1].([]any)
+	diff.Test(t, t.Fatalf, len(cols), 6)
+	page := func(i int) []byte {
+		cm := cols[i].(map[int16]any)[3].(map[int16]any)
+		d := &decoder{t: t, b: b[cm[9].(int64):]}
+		ph := d.value(tStruct).(map[int16]any)
+		dph := ph[5].(map[int16]any)
+		diff.Test(t, t.Errorf, dph[1], int64(3))
+		body, err := dec.DecodeAll(d.b[:ph[3].(int64)], nil)
+		diff.Test(t, t.Fatalf, err, nil)
+		diff.Test(t, t.Errorf, int64(len(body)), ph[2].(int64))
+		return body
+	}
+
+	// levels: len=2, bit-packed run of 1 group, all defined
+	p := page(0)
+	diff.Test(t, t.Errorf, p[:6], []byte{2, 0, 0, 0, 3, 0b111})
+	diff.Test(t, t.Errorf, binary.LittleEndian.Uint64(p[6:]), uint64(42))
+	diff.Test(t, t.Errorf, binary.LittleEndian.Uint64(p[22:]), uint64(44))
+
+	p = page(1)
+	diff.Test(t, t.Errorf, p, []byte{2, 0, 0, 0, 3, 0b101, 0b01})
+
+	p = page(3)
+	diff.Test(t, t.Errorf, p[6:], []byte{4, 0, 0, 0, '1', '0', '0', '0', 1, 0, 0, 0, 'x'})
+
+	p = page(4)
+	diff.Test(t, t.Errorf, int64(binary.LittleEndian.Uint64(p[6:])), ts.UnixMicro())
+}
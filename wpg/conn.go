@@ -6,6 +6,9 @@ import (
 	"hash/fnv"
 	"sync"
 
+	"github.com/indexsupply/shovel/wos"
+	"github.com/indexsupply/shovel/wotel"
+
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -18,13 +21,39 @@ type Conn interface {
 	Query(context.Context, string, ...any) (pgx.Rows, error)
 }
 
+// url may reference a secret (see [wos.IsSecret]) in which
+// case the secret is read again for each new connection so
+// that rotated credentials are used.
 func NewPool(ctx context.Context, url string) (*pgxpool.Pool, error) {
+	ref := url
+	if wos.IsSecret(ref) {
+		var err error
+		url, err = wos.Secret(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+	}
 	conf, err := pgxpool.ParseConfig(url)
 	if err != nil {
 		return nil, err
 	}
 	conf.ConnConfig.RuntimeParams["statement_timeout"] = "5s"
 	conf.ConnConfig.RuntimeParams["idle_in_transaction_session_timeout"] = "10s"
+	conf.ConnConfig.Tracer = wotel.PGTracer{}
+	if wos.IsSecret(ref) {
+		conf.BeforeConnect = func(ctx context.Context, cc *pgx.ConnConfig) error {
+			url, err := wos.Secret(ctx, ref)
+			if err != nil {
+				return err
+			}
+			fresh, err := pgx.ParseConfig(url)
+			if err != nil {
+				return fmt.Errorf("parsing rotated pg url: %w", err)
+			}
+			cc.User, cc.Password = fresh.User, fresh.Password
+			return nil
+		}
+	}
 	return pgxpool.NewWithConfig(context.Background(), conf)
 }
 
@@ -0,0 +1,167 @@
+package wpg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A Postgres server spawned as a child process for local
+// development (the -spawn-pg flag). This is not an embedded
+// database: shovel only runs against Postgres, and Local
+// requires the initdb and postgres binaries of a Postgres
+// install on PATH (some packages install them outside of
+// PATH, eg /usr/lib/postgresql/<version>/bin on Debian). It
+// saves running and configuring a server. The server only
+// listens on a unix socket in its directory and skips fsync,
+// so it is not meant for production data.
+type Local struct {
+	dir  string
+	tmp  bool
+	cmd  *exec.Cmd
+	done chan error
+	out  *tail
+}
+
+// Bytes of the server's output kept for errors
+const tailSize = 16 << 10
+
+// Keeps the last n bytes written to it
+type tail struct {
+	mut sync.Mutex
+	n   int
+	buf []byte
+}
+
+func (t *tail) Write(p []byte) (int, error) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.n; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tail) String() string {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return string(t.buf)
+}
+
+// Starts a server whose data is kept in dir. The data
+// directory is initialized when it doesn't exist. An empty
+// dir uses a temporary directory that is removed by Stop.
+// Returns the server's connection url.
+func StartLocal(ctx context.Context, dir string) (*Local, string, error) {
+	for _, name := range []string{"initdb", "postgres"} {
+		if _, err := exec.LookPath(name); err != nil {
+			const tag = "local postgres requires %s on PATH. install postgres or add its bin directory to PATH: %w"
+			return nil, "", fmt.Errorf(tag, name, err)
+		}
+	}
+	l := &Local{dir: dir, out: &tail{n: tailSize}}
+	if len(l.dir) == 0 {
+		d, err := os.MkdirTemp("", "shovel-pg-")
+		if err != nil {
+			return nil, "", fmt.Errorf("creating dir: %w", err)
+		}
+		l.dir, l.tmp = d, true
+	}
+	data := filepath.Join(l.dir, "data")
+	if _, err := os.Stat(filepath.Join(data, "PG_VERSION")); err != nil {
+		err := run("initdb",
+			"-D", data,
+			"-U", "postgres",
+			"-E", "UTF8",
+			"--auth=trust",
+			"--no-sync",
+		)
+		if err != nil {
+			l.cleanup()
+			return nil, "", err
+		}
+	}
+	l.cmd = exec.Command("postgres",
+		"-D", data,
+		"-k", l.dir,
+		"-c", "listen_addresses=",
+		"-c", "fsync=off",
+		"-c", "synchronous_commit=off",
+		"-c", "full_page_writes=off",
+	)
+	l.cmd.Stdout, l.cmd.Stderr = l.out, l.out
+	if err := l.cmd.Start(); err != nil {
+		l.cleanup()
+		return nil, "", fmt.Errorf("starting postgres: %w", err)
+	}
+	l.done = make(chan error, 1)
+	go func() { l.done <- l.cmd.Wait() }()
+
+	u := (&url.URL{
+		Scheme:   "postgres",
+		User:     url.User("postgres"),
+		Path:     "/postgres",
+		RawQuery: url.Values{"host": {l.dir}}.Encode(),
+	}).String()
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		conn, err := pgx.Connect(ctx, u)
+		if err == nil {
+			conn.Close(ctx)
+			return l, u, nil
+		}
+		select {
+		case err := <-l.done:
+			l.cleanup()
+			return nil, "", fmt.Errorf("postgres exited: %v: %s", err, l.out)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			l.Stop()
+			return nil, "", fmt.Errorf("waiting for postgres: %w: %s", err, l.out)
+		}
+	}
+}
+
+// Shuts down the server and removes its
+// directory when it is temporary.
+func (l *Local) Stop() error {
+	if l.cmd == nil || l.cmd.Process == nil {
+		return l.cleanup()
+	}
+	// SIGINT is postgres' fast shutdown
+	if err := l.cmd.Process.Signal(os.Interrupt); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("stopping postgres: %w", err)
+	}
+	select {
+	case <-l.done:
+	case <-time.After(10 * time.Second):
+		l.cmd.Process.Kill()
+		<-l.done
+	}
+	return l.cleanup()
+}
+
+func (l *Local) cleanup() error {
+	if !l.tmp {
+		return nil
+	}
+	return os.RemoveAll(l.dir)
+}
+
+func run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", name, err, out)
+	}
+	return nil
+}
@@ -2,7 +2,10 @@ package wpg
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
@@ -42,22 +45,131 @@ func TestPG(tb testing.TB, schema string) *pgxpool.Pool {
 type Column struct {
 	Name string `db:"column_name"json:"name"`
 	Type string `db:"data_type"json:"type"`
+
+	// How integers and bytes are written: numeric, bytea,
+	// hex (0x prefixed text), decimal (text), or
+	// checksum (EIP-55 address text).
+	// Empty means the value's natural type.
+	Encoding string `db:"-" json:"encoding,omitempty"`
+
+	// SQL expression over the table's other columns. The
+	// column is created as a stored generated column and so
+	// it is computed by Postgres rather than written.
+	Expr string `db:"-" json:"expr,omitempty"`
+}
+
+func (c Column) def() string {
+	if len(c.Expr) > 0 {
+		return fmt.Sprintf("%s %s generated always as (%s) stored", Quote(c.Name), c.Type, c.Expr)
+	}
+	return fmt.Sprintf("%s %s", Quote(c.Name), c.Type)
 }
 
-func quote(s string) string {
+// Quotes s when it is a reserved word
+func Quote(s string) string {
 	if _, ok := reservedWords[strings.ToLower(s)]; ok {
 		return strconv.Quote(s)
 	}
 	return s
 }
 
+type Index struct {
+	// Defaults to shovel_<table>_<columns>
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+
+	// btree (default), brin, or hash
+	Method string `json:"method"`
+
+	// Optional predicate for a partial index
+	Where string `json:"where"`
+}
+
+// Name of the index in the database
+func (idx Index) Ident(table string) string {
+	if len(idx.Name) > 0 {
+		return idx.Name
+	}
+	return Ident(fmt.Sprintf("shovel_%s_%s", table, strings.Join(idx.Columns, "_")))
+}
+
+// Postgres truncates identifiers to 63 bytes which would
+// give generated names that share a long prefix the same
+// name. Longer names keep a prefix and end with a hash of
+// the full name.
+func Ident(s string) string {
+	const max = 63
+	if len(s) <= max {
+		return s
+	}
+	h := sha256.Sum256([]byte(s))
+	return fmt.Sprintf("%s_%x", s[:max-9], h[:4])
+}
+
+// The index is created in the table's schema. qname
+// is the table's schema qualified name.
+func (idx Index) DDL(table, qname string) string {
+	var cols []string
+	for _, c := range idx.Columns {
+		cols = append(cols, Quote(c))
+	}
+	s := fmt.Sprintf("create index if not exists %s on %s", idx.Ident(table), qname)
+	if len(idx.Method) > 0 {
+		s += " using " + idx.Method
+	}
+	s += " (" + strings.Join(cols, ", ") + ")"
+	if len(idx.Where) > 0 {
+		s += " where " + idx.Where
+	}
+	return s
+}
+
 type Table struct {
+	// Postgres schema (namespace) created by [Table.DDL]
+	// when it doesn't exist. Defaults to public.
+	Schema  string   `json:"schema"`
 	Name    string   `json:"name"`
 	Columns []Column `json:"columns"`
 
 	DisableUnique bool       `json:"disable_unique"`
 	Unique        [][]string `json:"unique"`
 	Index         [][]string `json:"index"`
+	Indexes       []Index    `json:"indexes"`
+
+	// When non-zero the table is range partitioned on
+	// block_num with PartitionSize blocks per partition.
+	// Partitions are created by [Table.Partition].
+	PartitionSize uint64 `json:"partition_size"`
+}
+
+// Schema qualified name used in statements
+func (t Table) QName() string {
+	return QName(t.Schema, t.Name)
+}
+
+// Identifier for the table used by CopyFrom
+func (t Table) Identifier() pgx.Identifier {
+	if len(t.Schema) == 0 {
+		return pgx.Identifier{t.Name}
+	}
+	return pgx.Identifier{t.Schema, t.Name}
+}
+
+func QName(schema, name string) string {
+	if len(schema) == 0 {
+		return name
+	}
+	return schema + "." + name
+}
+
+// Splits a possibly schema qualified name.
+// The schema defaults to public.
+func splitQName(s string) (string, string) {
+	schema, name, ok := strings.Cut(s, ".")
+	if !ok {
+		return "public", s
+	}
+	return schema, name
 }
 
 func (t Table) DDL() []string {
@@ -65,26 +177,32 @@ func (t Table) DDL() []string {
 		return nil
 	}
 	var res []string
+	if len(t.Schema) > 0 {
+		res = append(res, fmt.Sprintf("create schema if not exists %s", t.Schema))
+	}
 
-	createTable := fmt.Sprintf("create table if not exists %s(", t.Name)
+	createTable := fmt.Sprintf("create table if not exists %s(", t.QName())
 	for i, col := range t.Columns {
-		createTable += fmt.Sprintf("%s %s", quote(col.Name), col.Type)
+		createTable += col.def()
 		if i+1 == len(t.Columns) {
 			createTable += ")"
 			break
 		}
 		createTable += ", "
 	}
+	if t.PartitionSize > 0 {
+		createTable += " partition by range (block_num)"
+	}
 	res = append(res, createTable)
 
 	for _, cols := range t.Unique {
 		createIndex := fmt.Sprintf(
-			"create unique index if not exists u_%s on %s (",
-			t.Name,
-			t.Name,
+			"create unique index if not exists %s on %s (",
+			Ident("u_"+t.Name),
+			t.QName(),
 		)
 		for i, cname := range cols {
-			createIndex += quote(cname)
+			createIndex += Quote(cname)
 			if i+1 == len(cols) {
 				createIndex += ")"
 				break
@@ -103,12 +221,12 @@ func (t Table) DDL() []string {
 			}
 		}
 		createIndex := fmt.Sprintf(
-			"create index if not exists shovel_%s on %s (",
-			indexName,
-			t.Name,
+			"create index if not exists %s on %s (",
+			Ident("shovel_"+indexName),
+			t.QName(),
 		)
 		for i, cname := range cols {
-			createIndex += quote(cname)
+			createIndex += Quote(cname)
 			if i+1 == len(cols) {
 				createIndex += ")"
 				break
@@ -118,6 +236,9 @@ func (t Table) DDL() []string {
 		res = append(res, createIndex)
 	}
 
+	for _, idx := range t.Indexes {
+		res = append(res, idx.DDL(t.Name, t.QName()))
+	}
 	return res
 }
 
@@ -127,29 +248,183 @@ func (t Table) Migrate(ctx context.Context, pg Conn) error {
 			return fmt.Errorf("table %q stmt %q: %w", t.Name, stmt, err)
 		}
 	}
-	diff, err := Diff(ctx, pg, t.Name, t.Columns)
+	diff, err := Diff(ctx, pg, t.QName(), t.Columns)
 	if err != nil {
 		return fmt.Errorf("getting diff for %s: %w", t.Name, err)
 	}
 	for _, c := range diff.Add {
-		var q = fmt.Sprintf(
-			"alter table %s add column if not exists %s %s",
-			t.Name,
-			quote(c.Name),
-			c.Type,
-		)
-		if _, err := pg.Exec(ctx, q); err != nil {
+		if _, err := pg.Exec(ctx, t.addColumn(c)); err != nil {
 			return fmt.Errorf("adding column %s/%s: %w", t.Name, c.Name, err)
 		}
 	}
 	return nil
 }
 
+func (t Table) addColumn(c Column) string {
+	return fmt.Sprintf(
+		"alter table %s add column if not exists %s",
+		t.QName(),
+		c.def(),
+	)
+}
+
+// Statements that [Table.Migrate] would run that change the
+// database. Statements for tables and indexes that already
+// exist are omitted. Nothing is modified.
+func (t Table) Plan(ctx context.Context, pg Conn) ([]string, error) {
+	var missing []string
+	for _, stmt := range t.DDL() {
+		var (
+			name = ddlName(stmt)
+			q    = `select to_regclass($1) is not null`
+		)
+		switch {
+		case strings.HasPrefix(stmt, "create schema"):
+			q = `select to_regnamespace($1) is not null`
+		case strings.HasPrefix(stmt, "create table"):
+		default:
+			// indexes are in the table's schema
+			name = QName(t.Schema, name)
+		}
+		var exists bool
+		if err := pg.QueryRow(ctx, q, name).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("checking %s: %w", name, err)
+		}
+		if !exists {
+			missing = append(missing, stmt)
+		}
+	}
+	created := slices.ContainsFunc(missing, func(stmt string) bool {
+		return strings.HasPrefix(stmt, "create table")
+	})
+	if created {
+		return missing, nil
+	}
+	diff, err := Diff(ctx, pg, t.QName(), t.Columns)
+	if err != nil {
+		return nil, fmt.Errorf("getting diff for %s: %w", t.Name, err)
+	}
+	// columns are added before indexes that may use them
+	var res []string
+	for _, c := range diff.Add {
+		res = append(res, t.addColumn(c))
+	}
+	return append(res, missing...), nil
+}
+
+// The name of the schema, table, or index created
+// by a statement from [Table.DDL]
+func ddlName(stmt string) string {
+	_, s, _ := strings.Cut(stmt, "if not exists ")
+	if i := strings.IndexAny(s, " ("); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+func (t Table) PartitionName(start uint64) string {
+	return fmt.Sprintf("%s_p%d", t.Name, start)
+}
+
+// Ensures that partitions exist for blocks in [from, to].
+// Missing partitions are created and partitions that exist
+// but were detached are re-attached. Must be called in a tx
+// since an advisory lock serializes tasks sharing the table.
+func (t Table) Partition(ctx context.Context, pg Conn, from, to uint64) error {
+	if t.PartitionSize == 0 {
+		return nil
+	}
+	for start := from - from%t.PartitionSize; start <= to; start += t.PartitionSize {
+		if err := t.partition(ctx, pg, start); err != nil {
+			return fmt.Errorf("partition %s: %w", t.PartitionName(start), err)
+		}
+	}
+	return nil
+}
+
+func (t Table) partition(ctx context.Context, pg Conn, start uint64) error {
+	var (
+		name     = t.PartitionName(start)
+		qname    = QName(t.Schema, name)
+		bounds   = fmt.Sprintf("for values from (%d) to (%d)", start, start+t.PartitionSize)
+		attached bool
+	)
+	const lq = `select pg_advisory_xact_lock(hashtext($1))`
+	if _, err := pg.Exec(ctx, lq, qname); err != nil {
+		return fmt.Errorf("locking: %w", err)
+	}
+	const q = `
+		select exists (select 1 from pg_inherits where inhrelid = c.oid)
+		from pg_class c
+		where c.relname = $1
+		and c.relnamespace = $2::regnamespace
+	`
+	schema, _ := splitQName(t.QName())
+	err := pg.QueryRow(ctx, q, name, schema).Scan(&attached)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		q := fmt.Sprintf("create table %s partition of %s %s", qname, t.QName(), bounds)
+		if _, err := pg.Exec(ctx, q); err != nil {
+			return fmt.Errorf("creating: %w", err)
+		}
+		return nil
+	case err != nil:
+		return fmt.Errorf("querying catalog: %w", err)
+	case attached:
+		return nil
+	default:
+		q := fmt.Sprintf("alter table %s attach partition %s %s", t.QName(), qname, bounds)
+		if _, err := pg.Exec(ctx, q); err != nil {
+			return fmt.Errorf("attaching: %w", err)
+		}
+		return nil
+	}
+}
+
+// Drops partitions whose range is entirely below before.
+// Returns the names of the dropped partitions.
+func (t Table) DropPartitions(ctx context.Context, pg Conn, before uint64) ([]string, error) {
+	if t.PartitionSize == 0 {
+		return nil, nil
+	}
+	const q = `
+		select c.relname
+		from pg_inherits i
+		join pg_class c on c.oid = i.inhrelid
+		join pg_class p on p.oid = i.inhparent
+		where p.relname = $1
+		and p.relnamespace = $2::regnamespace
+	`
+	schema, _ := splitQName(t.QName())
+	rows, _ := pg.Query(ctx, q, t.Name, schema)
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("querying partitions: %w", err)
+	}
+	var dropped []string
+	for _, name := range names {
+		s, ok := strings.CutPrefix(name, t.Name+"_p")
+		if !ok {
+			continue
+		}
+		start, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || start+t.PartitionSize > before {
+			continue
+		}
+		if _, err := pg.Exec(ctx, fmt.Sprintf("drop table if exists %s", QName(t.Schema, name))); err != nil {
+			return dropped, fmt.Errorf("dropping %s: %w", name, err)
+		}
+		dropped = append(dropped, name)
+	}
+	return dropped, nil
+}
+
 type DiffDetails struct {
 	Remove []Column
 	Add    []Column
 }
 
+// tableName may be schema qualified
 func Diff(
 	ctx context.Context,
 	pg Conn,
@@ -159,10 +434,11 @@ func Diff(
 	const q = `
 		select column_name, data_type
 		from information_schema.columns
-		where table_schema = 'public'
-		and table_name = $1
+		where table_schema = $1
+		and table_name = $2
 	`
-	rows, _ := pg.Query(ctx, q, tableName)
+	schema, name := splitQName(tableName)
+	rows, _ := pg.Query(ctx, q, schema, name)
 	indb, err := pgx.CollectRows(rows, pgx.RowToStructByName[Column])
 	if err != nil {
 		return DiffDetails{}, fmt.Errorf("querying for table info: %w", err)
@@ -199,9 +475,11 @@ func Indexes(ctx context.Context, pg Conn, table string) []map[string]any {
 	const q = `
 		select indexname, indexdef
 		from pg_indexes
-		where tablename = $1
+		where schemaname = $1
+		and tablename = $2
 	`
-	rows, _ := pg.Query(ctx, q, table)
+	schema, name := splitQName(table)
+	rows, _ := pg.Query(ctx, q, schema, name)
 	res, err := pgx.CollectRows(rows, pgx.RowToMap)
 	if err != nil {
 		return []map[string]any{map[string]any{"error": err.Error()}}
@@ -213,7 +491,7 @@ func RowEstimate(ctx context.Context, pg Conn, table string) string {
 	const q = `
 		select trim(to_char(reltuples, '999,999,999,999'))
 		from pg_class
-		where relname = $1
+		where oid = to_regclass($1)
 	`
 	var res string
 	if err := pg.QueryRow(ctx, q, table).Scan(&res); err != nil {
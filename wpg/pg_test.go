@@ -3,6 +3,10 @@ package wpg
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
 	"testing"
 
 	"blake.io/pqx/pqxtest"
@@ -41,6 +45,58 @@ func TestDDL(t *testing.T) {
 				"create index if not exists shovel_a_asc_b_desc on foo (a asc, b desc)",
 			},
 		},
+		{
+			Table{
+				Name: "foo",
+				Columns: []Column{
+					{Name: "value", Type: "numeric"},
+					{Name: "amount", Type: "numeric", Expr: "value / 1e6"},
+				},
+			},
+			[]string{
+				"create table if not exists foo(value numeric, amount numeric generated always as (value / 1e6) stored)",
+			},
+		},
+		{
+			Table{
+				Name:          "foo",
+				Columns:       []Column{{Name: "block_num", Type: "numeric"}},
+				PartitionSize: 1000,
+			},
+			[]string{
+				"create table if not exists foo(block_num numeric) partition by range (block_num)",
+			},
+		},
+		{
+			Table{
+				Name:    "foo",
+				Columns: []Column{{Name: "block_num", Type: "numeric"}, {Name: "from", Type: "bytea"}},
+				Indexes: []Index{
+					{Columns: []string{"block_num"}, Method: "brin"},
+					{Name: "foo_from", Columns: []string{"from"}, Method: "hash", Where: "block_num > 100"},
+				},
+			},
+			[]string{
+				`create table if not exists foo(block_num numeric, "from" bytea)`,
+				"create index if not exists shovel_foo_block_num on foo using brin (block_num)",
+				`create index if not exists foo_from on foo using hash ("from") where block_num > 100`,
+			},
+		},
+		{
+			Table{
+				Schema:  "uniswap",
+				Name:    "swaps",
+				Columns: []Column{{Name: "block_num", Type: "numeric"}},
+				Unique:  [][]string{{"block_num"}},
+				Indexes: []Index{{Columns: []string{"block_num"}}},
+			},
+			[]string{
+				"create schema if not exists uniswap",
+				"create table if not exists uniswap.swaps(block_num numeric)",
+				"create unique index if not exists u_swaps on uniswap.swaps (block_num)",
+				"create index if not exists shovel_swaps_block_num on uniswap.swaps (block_num)",
+			},
+		},
 	}
 	for _, tc := range cases {
 		diff.Test(t, t.Errorf, tc.table.DDL(), tc.want)
@@ -87,6 +143,122 @@ func TestMigrate(t *testing.T) {
 	}
 }
 
+func TestPlan(t *testing.T) {
+	ctx := context.Background()
+	pqxtest.CreateDB(t, "")
+	pg, err := pgxpool.New(ctx, pqxtest.DSNForTest(t))
+	diff.Test(t, t.Fatalf, nil, err)
+
+	table := Table{
+		Name:    "x",
+		Columns: []Column{{Name: "a", Type: "int"}},
+		Indexes: []Index{{Columns: []string{"a"}}},
+	}
+	got, err := table.Plan(ctx, pg)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, got, table.DDL())
+	diff.Test(t, t.Fatalf, nil, table.Migrate(ctx, pg))
+
+	got, err = table.Plan(ctx, pg)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, len(got), 0)
+
+	table.Columns = append(table.Columns, Column{Name: "b", Type: "text"})
+	table.Indexes = append(table.Indexes, Index{Columns: []string{"b"}})
+	got, err = table.Plan(ctx, pg)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, got, []string{
+		"alter table x add column if not exists b text",
+		"create index if not exists shovel_x_b on x (b)",
+	})
+}
+
+func TestIdent(t *testing.T) {
+	diff.Test(t, t.Errorf, Ident("shovel_foo_a"), "shovel_foo_a")
+	long := Index{Columns: []string{
+		"some_long_column_name",
+		"another_long_column_name",
+		"a_third_long_column_name",
+	}}
+	a, b := long, long
+	b.Columns = append(slices.Clone(b.Columns), "x")
+	diff.Test(t, t.Errorf, len(a.Ident("table")), 63)
+	diff.Test(t, t.Errorf, len(b.Ident("table")), 63)
+	diff.Test(t, t.Errorf, a.Ident("table") != b.Ident("table"), true)
+}
+
+func TestDDLName(t *testing.T) {
+	diff.Test(t, t.Errorf, ddlName("create table if not exists foo(a int)"), "foo")
+	diff.Test(t, t.Errorf, ddlName("create unique index if not exists u_foo on foo (a)"), "u_foo")
+	diff.Test(t, t.Errorf, ddlName("create schema if not exists uniswap"), "uniswap")
+	diff.Test(t, t.Errorf, ddlName("create table if not exists uniswap.swaps(a int)"), "uniswap.swaps")
+}
+
+func TestPartition(t *testing.T) {
+	ctx := context.Background()
+	pqxtest.CreateDB(t, "")
+	pg, err := pgxpool.New(ctx, pqxtest.DSNForTest(t))
+	diff.Test(t, t.Fatalf, nil, err)
+
+	table := Table{
+		Name:          "x",
+		Columns:       []Column{{Name: "block_num", Type: "numeric"}},
+		PartitionSize: 10,
+	}
+	diff.Test(t, t.Fatalf, nil, table.Migrate(ctx, pg))
+	_, err = pg.Exec(ctx, "insert into x values (5)")
+	diff.Test(t, t.Fatalf, true, err != nil)
+
+	tx, err := pg.Begin(ctx)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Fatalf, nil, table.Partition(ctx, tx, 5, 25))
+	diff.Test(t, t.Fatalf, nil, tx.Commit(ctx))
+
+	_, err = pg.Exec(ctx, "insert into x values (5), (15), (25)")
+	diff.Test(t, t.Fatalf, nil, err)
+
+	// detached partitions are re-attached
+	_, err = pg.Exec(ctx, "alter table x detach partition x_p10")
+	diff.Test(t, t.Fatalf, nil, err)
+	tx, err = pg.Begin(ctx)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Fatalf, nil, table.Partition(ctx, tx, 10, 19))
+	diff.Test(t, t.Fatalf, nil, tx.Commit(ctx))
+
+	var n int
+	err = pg.QueryRow(ctx, "select count(*) from x").Scan(&n)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, 3, n)
+}
+
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+	pqxtest.CreateDB(t, "create table x (n int primary key)")
+	pg, err := pgxpool.New(ctx, pqxtest.DSNForTest(t))
+	diff.Test(t, t.Fatalf, nil, err)
+	conn, err := pg.Acquire(ctx)
+	diff.Test(t, t.Fatalf, nil, err)
+	defer conn.Release()
+
+	p := NewPipeline(conn)
+	p.Exec(ctx, "insert into x values (1), (2)")
+	p.Exec(ctx, "insert into x values (2), (3) on conflict do nothing")
+	p.Exec(ctx, "select pg_notify('x', n::text) from x")
+	p.Exec(ctx, "update x set n = n where n = 1")
+	diff.Test(t, t.Errorf, p.RowsAffected(), int64(0))
+
+	// queued statements are sent before a query
+	var n int
+	err = p.QueryRow(ctx, "select count(*) from x").Scan(&n)
+	diff.Test(t, t.Fatalf, nil, err)
+	diff.Test(t, t.Errorf, n, 3)
+	diff.Test(t, t.Errorf, p.RowsAffected(), int64(3))
+
+	p.Exec(ctx, "insert into x values (1)")
+	diff.Test(t, t.Errorf, p.Flush(ctx) != nil, true)
+	diff.Test(t, t.Errorf, p.Flush(ctx), nil)
+}
+
 func TestDiff(t *testing.T) {
 	cases := []struct {
 		table Table
@@ -145,3 +317,38 @@ func TestDiff(t *testing.T) {
 		diff.Test(t, t.Errorf, nil, err)
 	}
 }
+
+func TestTail(t *testing.T) {
+	tl := &tail{n: 4}
+	fmt.Fprint(tl, "ab")
+	diff.Test(t, t.Errorf, tl.String(), "ab")
+	fmt.Fprint(tl, "cdef")
+	diff.Test(t, t.Errorf, tl.String(), "cdef")
+	fmt.Fprint(tl, "g")
+	diff.Test(t, t.Errorf, tl.String(), "defg")
+}
+
+func TestLocal_Missing(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	_, _, err := StartLocal(context.Background(), "")
+	if err == nil || !strings.Contains(err.Error(), "local postgres requires initdb on PATH") {
+		t.Errorf("got: %v", err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	if _, err := exec.LookPath("initdb"); err != nil {
+		t.Skip("initdb not on PATH")
+	}
+	ctx := context.Background()
+	l, url, err := StartLocal(ctx, t.TempDir())
+	diff.Test(t, t.Fatalf, err, nil)
+	defer l.Stop()
+
+	pg, err := NewPool(ctx, url)
+	diff.Test(t, t.Fatalf, err, nil)
+	defer pg.Close()
+	var n int
+	diff.Test(t, t.Fatalf, pg.QueryRow(ctx, "select 1").Scan(&n), nil)
+	diff.Test(t, t.Errorf, n, 1)
+}
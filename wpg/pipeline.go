@@ -0,0 +1,120 @@
+package wpg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Implemented by pgx.Tx, *pgx.Conn, and *pgxpool.Conn
+type Batcher interface {
+	Conn
+	SendBatch(context.Context, *pgx.Batch) pgx.BatchResults
+}
+
+// A Conn that sends statements together to save round trips.
+// Exec queues the statement and returns an empty command tag.
+// Queued statements are sent by Flush or before any method
+// that reads results (QueryRow, Query, CopyFrom) so that
+// statements run in order. Errors from queued statements are
+// returned by the next send.
+type Pipeline struct {
+	mut   sync.Mutex
+	conn  Batcher
+	batch *pgx.Batch
+	nrows int64
+}
+
+func NewPipeline(conn Batcher) *Pipeline {
+	return &Pipeline{conn: conn, batch: &pgx.Batch{}}
+}
+
+func (p *Pipeline) Exec(_ context.Context, q string, args ...any) (pgconn.CommandTag, error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.batch.Queue(q, args...).Exec(func(ct pgconn.CommandTag) error {
+		if ct.Insert() || strings.HasPrefix(ct.String(), "COPY") {
+			p.nrows += ct.RowsAffected()
+		}
+		return nil
+	})
+	return pgconn.CommandTag{}, nil
+}
+
+func (p *Pipeline) Flush(ctx context.Context) error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.flush(ctx)
+}
+
+// Must hold mut
+func (p *Pipeline) flush(ctx context.Context) error {
+	if p.batch.Len() == 0 {
+		return nil
+	}
+	b := p.batch
+	p.batch = &pgx.Batch{}
+	if err := p.conn.SendBatch(ctx, b).Close(); err != nil {
+		return fmt.Errorf("sending %d statements: %w", b.Len(), err)
+	}
+	return nil
+}
+
+// Rows inserted or copied by the queued statements that have
+// been sent. Other statements (eg select pg_notify) aren't
+// counted.
+func (p *Pipeline) RowsAffected() int64 {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.nrows
+}
+
+type errRow struct{ err error }
+
+func (r errRow) Scan(...any) error { return r.err }
+
+func (p *Pipeline) QueryRow(ctx context.Context, q string, args ...any) pgx.Row {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if err := p.flush(ctx); err != nil {
+		return errRow{err}
+	}
+	return p.conn.QueryRow(ctx, q, args...)
+}
+
+func (p *Pipeline) Query(ctx context.Context, q string, args ...any) (pgx.Rows, error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if err := p.flush(ctx); err != nil {
+		return nil, err
+	}
+	return p.conn.Query(ctx, q, args...)
+}
+
+func (p *Pipeline) CopyFrom(ctx context.Context, t pgx.Identifier, cols []string, src pgx.CopyFromSource) (int64, error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if err := p.flush(ctx); err != nil {
+		return 0, err
+	}
+	return p.conn.CopyFrom(ctx, t, cols, src)
+}
+
+// Returns a Conn whose Exec reports its own result. When pg
+// is a [Pipeline] its queued statements are sent first.
+// Used by callers that handle the errors of each statement
+// (eg to roll back to a savepoint).
+func Direct(ctx context.Context, pg Conn) (Conn, error) {
+	p, ok := pg.(*Pipeline)
+	if !ok {
+		return pg, nil
+	}
+	if err := p.Flush(ctx); err != nil {
+		return nil, err
+	}
+	return p.conn, nil
+}
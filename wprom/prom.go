@@ -0,0 +1,173 @@
+// Prometheus text format counters and gauges
+//
+// Metrics are registered in a package level registry
+// and written using [Write]. Labels are passed positionally
+// in the order they were declared.
+package wprom
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Vec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	// values are nanoseconds written as seconds
+	seconds bool
+
+	mut  sync.Mutex
+	vals map[string]*atomic.Uint64
+}
+
+var (
+	registryMut sync.Mutex
+	registry    []*Vec
+)
+
+func register(kind, name, help string, labels []string) *Vec {
+	v := &Vec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		vals:   map[string]*atomic.Uint64{},
+	}
+	registryMut.Lock()
+	registry = append(registry, v)
+	registryMut.Unlock()
+	return v
+}
+
+func NewCounter(name, help string, labels ...string) *Vec {
+	return register("counter", name, help, labels)
+}
+
+func NewGauge(name, help string, labels ...string) *Vec {
+	return register("gauge", name, help, labels)
+}
+
+// A counter of time spent. Values are added using
+// [Vec.AddDuration] and written in seconds.
+func NewSecondsCounter(name, help string, labels ...string) *Vec {
+	v := register("counter", name, help, labels)
+	v.seconds = true
+	return v
+}
+
+func (v *Vec) get(lvs []string) *atomic.Uint64 {
+	if len(lvs) != len(v.labels) {
+		panic(fmt.Sprintf("wprom: %s expects %d labels", v.name, len(v.labels)))
+	}
+	key := strings.Join(lvs, "\x00")
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	n, ok := v.vals[key]
+	if !ok {
+		n = new(atomic.Uint64)
+		v.vals[key] = n
+	}
+	return n
+}
+
+func (v *Vec) Add(n uint64, lvs ...string) {
+	v.get(lvs).Add(n)
+}
+
+func (v *Vec) Inc(lvs ...string) {
+	v.get(lvs).Add(1)
+}
+
+func (v *Vec) AddDuration(d time.Duration, lvs ...string) {
+	if d > 0 {
+		v.get(lvs).Add(uint64(d))
+	}
+}
+
+// Gauges may be set to any value.
+// Setting a counter will likely confuse Prometheus.
+func (v *Vec) Set(n uint64, lvs ...string) {
+	v.get(lvs).Store(n)
+}
+
+func (v *Vec) Get(lvs ...string) uint64 {
+	return v.get(lvs).Load()
+}
+
+// Removes the series for the label values. Used when
+// the thing being measured (eg a task) goes away.
+func (v *Vec) Delete(lvs ...string) {
+	v.mut.Lock()
+	delete(v.vals, strings.Join(lvs, "\x00"))
+	v.mut.Unlock()
+}
+
+func (v *Vec) write(w io.Writer) error {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	if len(v.vals) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.kind)
+	keys := make([]string, 0, len(v.vals))
+	for k := range v.vals {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		var (
+			lvs = strings.Split(k, "\x00")
+			n   = strconv.FormatUint(v.vals[k].Load(), 10)
+		)
+		if v.seconds {
+			n = strconv.FormatFloat(float64(v.vals[k].Load())/1e9, 'f', -1, 64)
+		}
+		if len(v.labels) == 0 {
+			_, err := fmt.Fprintf(w, "%s %s\n", v.name, n)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		var pairs []string
+		for i := range v.labels {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", v.labels[i], lvs[i]))
+		}
+		_, err := fmt.Fprintf(w, "%s{%s} %s\n", v.name, strings.Join(pairs, ","), n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes every registered metric that has a value
+func Write(w io.Writer) error {
+	registryMut.Lock()
+	vecs := slices.Clone(registry)
+	registryMut.Unlock()
+	for _, v := range vecs {
+		if err := v.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Saturating subtraction for lag style gauges
+func Sub(a, b uint64) uint64 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
@@ -0,0 +1,46 @@
+package wprom
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+)
+
+func TestWrite(t *testing.T) {
+	registry = nil
+	var (
+		c = NewCounter("foo_total", "number of foos", "src", "ig")
+		g = NewGauge("bar", "current bar")
+		_ = NewCounter("baz_total", "never set")
+		s = NewSecondsCounter("qux_seconds_total", "time spent quxing")
+	)
+	s.AddDuration(1500 * time.Millisecond)
+	s.AddDuration(250 * time.Microsecond)
+	c.Inc("b", "y")
+	c.Add(2, "a", "x")
+	c.Inc("a", "x")
+	g.Set(42)
+	g.Set(7)
+
+	var buf strings.Builder
+	diff.Test(t, t.Fatalf, Write(&buf), nil)
+	diff.Test(t, t.Errorf, buf.String(), strings.Join([]string{
+		"# HELP foo_total number of foos",
+		"# TYPE foo_total counter",
+		`foo_total{src="a",ig="x"} 3`,
+		`foo_total{src="b",ig="y"} 1`,
+		"# HELP bar current bar",
+		"# TYPE bar gauge",
+		"bar 7",
+		"# HELP qux_seconds_total time spent quxing",
+		"# TYPE qux_seconds_total counter",
+		"qux_seconds_total 1.50025",
+		"",
+	}, "\n"))
+
+	c.Delete("b", "y")
+	diff.Test(t, t.Errorf, c.Get("a", "x"), uint64(3))
+	diff.Test(t, t.Errorf, len(c.vals), 1)
+}
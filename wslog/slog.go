@@ -8,6 +8,7 @@ package wslog
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
@@ -15,11 +16,14 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type Handler struct {
 	ctxs      []func(context.Context) (string, any)
 	opts      slog.HandlerOptions
+	json      bool
+	levels    map[string]slog.Leveler
 	prefix    string
 	preformat string
 	mu        sync.Mutex
@@ -47,14 +51,76 @@ func (h *Handler) RegisterContext(f func(context.Context) (string, any)) {
 	h.mu.Unlock()
 }
 
-func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
-	minLevel := slog.LevelInfo
+// Writes each record as a JSON object instead of
+// key=value pairs. Must be called before the handler
+// is used.
+func (h *Handler) UseJSON() {
+	h.json = true
+}
+
+// Sets the minimum level of records logged by a subsystem.
+// Must be called before the handler is used.
+// See [Subsystem] for names.
+func (h *Handler) SetLevel(subsystem string, l slog.Leveler) {
+	if h.levels == nil {
+		h.levels = map[string]slog.Leveler{}
+	}
+	h.levels[subsystem] = l
+}
+
+var subsystems sync.Map
+
+// Last element of the package path of the function at pc
+// (eg jrpc2). Records logged by the shovel package are
+// from its tasks and so the shovel package is named task.
+func Subsystem(pc uintptr) string {
+	if v, ok := subsystems.Load(pc); ok {
+		return v.(string)
+	}
+	fs := runtime.CallersFrames([]uintptr{pc})
+	f, _ := fs.Next()
+	// eg github.com/indexsupply/shovel/jrpc2.(*Client).do.func1
+	name := f.Function
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	if name == "shovel" {
+		name = "task"
+	}
+	subsystems.Store(pc, name)
+	return name
+}
+
+func (h *Handler) minLevel() slog.Level {
 	if h.opts.Level != nil {
-		minLevel = h.opts.Level.Level()
+		return h.opts.Level.Level()
+	}
+	return slog.LevelInfo
+}
+
+// The record's subsystem is unknown until it's handled
+// so the lowest of all the levels is used.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	minLevel := h.minLevel()
+	for _, l := range h.levels {
+		minLevel = min(minLevel, l.Level())
 	}
 	return level >= minLevel
 }
 
+func (h *Handler) enabled(r slog.Record) bool {
+	minLevel := h.minLevel()
+	if len(h.levels) > 0 && r.PC != 0 {
+		if l, ok := h.levels[Subsystem(r.PC)]; ok {
+			minLevel = l.Level()
+		}
+	}
+	return r.Level >= minLevel
+}
+
 func (h *Handler) WithGroup(name string) slog.Handler {
 	var ctxs []func(context.Context) (string, any)
 	for i := range h.ctxs {
@@ -63,6 +129,8 @@ func (h *Handler) WithGroup(name string) slog.Handler {
 	return &Handler{
 		ctxs:      ctxs,
 		opts:      h.opts,
+		json:      h.json,
+		levels:    h.levels,
 		prefix:    h.prefix + name + ".",
 		preformat: h.preformat,
 		mu:        sync.Mutex{},
@@ -82,6 +150,8 @@ func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
 	return &Handler{
 		ctxs:      ctxs,
 		opts:      h.opts,
+		json:      h.json,
+		levels:    h.levels,
 		prefix:    h.prefix,
 		preformat: h.preformat + string(buf),
 		mu:        sync.Mutex{},
@@ -99,6 +169,12 @@ func freebuf(b *[]byte) {
 }
 
 func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if !h.enabled(r) {
+		return nil
+	}
+	if h.json {
+		return h.handleJSON(ctx, r)
+	}
 	var (
 		bufp = bpool.Get().(*[]byte)
 		buf  = *bufp
@@ -144,10 +220,90 @@ func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
 	return err
 }
 
+func (h *Handler) handleJSON(ctx context.Context, r slog.Record) error {
+	var (
+		bufp = bpool.Get().(*[]byte)
+		buf  = *bufp
+	)
+	defer func() {
+		*bufp = buf
+		freebuf(bufp)
+	}()
+	buf = append(buf, '{')
+	buf = appendJSON(buf, "l", strings.ToLower(r.Level.String()))
+	buf = append(buf, h.preformat...)
+	if len(r.Message) > 0 {
+		buf = appendJSON(buf, "msg", r.Message)
+	}
+	for _, f := range h.ctxs {
+		k, v := f(ctx)
+		if k == "" {
+			continue
+		}
+		buf = appendJSON(buf, k, jsonValue(slog.AnyValue(v)))
+	}
+	if h.opts.AddSource && r.PC != 0 {
+		fs := runtime.CallersFrames([]uintptr{r.PC})
+		f, _ := fs.Next()
+		buf = appendJSON(buf, "source", f.File+":"+strconv.Itoa(f.Line))
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		buf = h.appendAttr(buf, h.prefix, a)
+		return true
+	})
+	buf = bytes.TrimSuffix(buf, []byte(","))
+	buf = append(buf, '}', '\n')
+	h.mu.Lock()
+	_, err := h.w.Write(buf)
+	h.mu.Unlock()
+	return err
+}
+
+// Appends "k":v, using v's JSON encoding or,
+// when it has none, its string form.
+func appendJSON(buf []byte, k string, v any) []byte {
+	kb, _ := json.Marshal(k)
+	vb, err := json.Marshal(v)
+	if err != nil {
+		vb, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf = append(buf, kb...)
+	buf = append(buf, ':')
+	buf = append(buf, vb...)
+	return append(buf, ',')
+}
+
+// Durations, times, errors, and Stringers are
+// written as they are in text logs.
+func jsonValue(v slog.Value) any {
+	switch v.Kind() {
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindTime:
+		return v.Time().Format(time.RFC3339Nano)
+	case slog.KindAny, slog.KindLogValuer:
+		switch x := v.Resolve().Any().(type) {
+		case error:
+			return x.Error()
+		case fmt.Stringer:
+			return x.String()
+		case []byte:
+			return fmt.Sprint(x)
+		default:
+			return x
+		}
+	default:
+		return v.Any()
+	}
+}
+
 func (h *Handler) appendAttr(buf []byte, prefix string, a slog.Attr) []byte {
 	if a.Equal(slog.Attr{}) {
 		return buf
 	}
+	if a.Value.Kind() != slog.KindGroup && h.json {
+		return appendJSON(buf, prefix+a.Key, jsonValue(a.Value))
+	}
 	if a.Value.Kind() != slog.KindGroup {
 		buf = append(buf, prefix...)
 		buf = append(buf, a.Key...)
@@ -3,8 +3,10 @@ package wslog
 import (
 	"bytes"
 	"context"
+	"errors"
 	"log/slog"
 	"testing"
+	"time"
 
 	"kr.dev/diff"
 )
@@ -77,3 +79,35 @@ func TestHandler(t *testing.T) {
 		diff.Test(t, t.Errorf, buf.String(), tc.want)
 	}
 }
+
+func TestHandler_JSON(t *testing.T) {
+	var (
+		ctx = context.WithValue(context.Background(), wslogTestKey{}, "bar")
+		buf bytes.Buffer
+		h   = New(&buf, nil)
+	)
+	h.UseJSON()
+	h.RegisterContext(func(ctx context.Context) (string, any) {
+		return "foo", ctx.Value(wslogTestKey{})
+	})
+	l := slog.New(h).With("v", 1).WithGroup("g")
+	l.InfoContext(ctx, "baz",
+		"s", `a "b"`,
+		"d", time.Second,
+		"err", errors.New("oops"),
+	)
+	diff.Test(t, t.Errorf, buf.String(), `{"l":"info","v":1,"msg":"baz","foo":"bar","g.s":"a \"b\"","g.d":"1s","g.err":"oops"}`+"\n")
+}
+
+func TestHandler_SetLevel(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		h   = New(&buf, &slog.HandlerOptions{Level: slog.LevelWarn})
+		l   = slog.New(h)
+	)
+	h.SetLevel("wslog", slog.LevelDebug)
+	h.SetLevel("other", slog.LevelError)
+	l.Debug("a")
+	l.Info("b")
+	diff.Test(t, t.Errorf, buf.String(), "l=debug msg=a\nl=info  msg=b\n")
+}